@@ -0,0 +1,224 @@
+// Command replay reads an AOF file or a CDC stream and replays its commands
+// against another instance, at the original pace or accelerated, to load
+// test or refresh staging environments.
+//
+// AOF input is a sequence of RESP command arrays; optional "#TS:<unix-sec>"
+// annotation lines (as written by Redis 7) provide timing. CDC input is one
+// JSON object per line: {"ts": <unix-ms>, "cmd": ["SET", "k", "v"]}.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+// event is one command read from the input, with its original timestamp
+// (zero when the input carries none)
+type event struct {
+	ts   time.Time
+	args []string
+}
+
+type source interface {
+	next() (event, error)
+}
+
+// aofSource reads RESP arrays, tracking the last #TS annotation
+type aofSource struct {
+	r  *bufio.Reader
+	ts time.Time
+}
+
+func (a *aofSource) next() (event, error) {
+	for {
+		b, err := a.r.Peek(1)
+		if err != nil {
+			return event{}, err
+		}
+		if b[0] != '#' {
+			break
+		}
+		line, err := a.r.ReadString('\n')
+		if err != nil {
+			return event{}, err
+		}
+		line = strings.TrimSpace(line)
+		if secs, ok := strings.CutPrefix(line, "#TS:"); ok {
+			n, err := strconv.ParseInt(secs, 10, 64)
+			if err != nil {
+				return event{}, fmt.Errorf("bad timestamp annotation %q", line)
+			}
+			a.ts = time.Unix(n, 0)
+		}
+	}
+
+	v, err := protocol.ParseRESP(a.r)
+	if err != nil {
+		return event{}, err
+	}
+	arr, ok := v.(protocol.Array)
+	if !ok || len(arr) == 0 {
+		return event{}, fmt.Errorf("expected a command array, got %T", v)
+	}
+	args := make([]string, 0, len(arr))
+	for _, el := range arr {
+		bs, ok := el.(protocol.BulkString)
+		if !ok {
+			return event{}, fmt.Errorf("expected bulk string argument, got %T", el)
+		}
+		args = append(args, string(bs))
+	}
+	return event{ts: a.ts, args: args}, nil
+}
+
+// cdcSource reads JSON change records, one per line
+type cdcSource struct {
+	sc *bufio.Scanner
+}
+
+type cdcRecord struct {
+	TS  int64    `json:"ts"`
+	Cmd []string `json:"cmd"`
+}
+
+func (c *cdcSource) next() (event, error) {
+	for c.sc.Scan() {
+		line := strings.TrimSpace(c.sc.Text())
+		if line == "" {
+			continue
+		}
+		var rec cdcRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return event{}, fmt.Errorf("bad CDC record: %w", err)
+		}
+		if len(rec.Cmd) == 0 {
+			return event{}, errors.New("CDC record without a command")
+		}
+		ev := event{args: rec.Cmd}
+		if rec.TS > 0 {
+			ev.ts = time.UnixMilli(rec.TS)
+		}
+		return ev, nil
+	}
+	if err := c.sc.Err(); err != nil {
+		return event{}, err
+	}
+	return event{}, io.EOF
+}
+
+func encodeCommand(args []string) []byte {
+	arr := make(protocol.Array, len(args))
+	for i, a := range args {
+		arr[i] = protocol.BulkString(a)
+	}
+	return []byte(protocol.Encode(arr))
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+
+	input := flag.String("input", "", "AOF or CDC file to replay (- for stdin)")
+	format := flag.String("format", "aof", "input format: aof or cdc")
+	addr := flag.String("addr", "localhost:6380", "target instance")
+	speed := flag.Float64("speed", 1, "replay speed relative to the original timing; 0 replays as fast as possible")
+	flag.Parse()
+
+	if *input == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var in io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			log.Fatalf("Error opening input: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	var src source
+	switch strings.ToLower(*format) {
+	case "aof":
+		src = &aofSource{r: bufio.NewReader(in)}
+	case "cdc":
+		sc := bufio.NewScanner(in)
+		sc.Buffer(make([]byte, 64*1024), 512*1024*1024)
+		src = &cdcSource{sc: sc}
+	default:
+		log.Fatalf("Unknown format %q (want aof or cdc)", *format)
+	}
+
+	conn, err := net.Dial("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Error connecting to %s: %v", *addr, err)
+	}
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+	r := bufio.NewReader(conn)
+
+	var (
+		sent, failed int
+		firstTS      time.Time
+		start        = time.Now()
+	)
+	for {
+		ev, err := src.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Error reading input after %d commands: %v", sent, err)
+		}
+
+		// hold the original spacing between commands, scaled by speed
+		if *speed > 0 && !ev.ts.IsZero() {
+			if firstTS.IsZero() {
+				firstTS = ev.ts
+			}
+			due := start.Add(time.Duration(float64(ev.ts.Sub(firstTS)) / *speed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		if _, err := w.Write(encodeCommand(ev.args)); err != nil {
+			log.Fatalf("Error writing to %s: %v", *addr, err)
+		}
+		if err := w.Flush(); err != nil {
+			log.Fatalf("Error writing to %s: %v", *addr, err)
+		}
+		reply, err := protocol.ParseRESP(r)
+		if err != nil {
+			log.Fatalf("Error reading reply from %s: %v", *addr, err)
+		}
+		sent++
+		if e, ok := reply.(protocol.Error); ok {
+			failed++
+			log.Printf("Command %d (%s) failed: %s", sent, ev.args[0], string(e))
+		}
+		if sent%10000 == 0 {
+			log.Printf("Replayed %d commands (%d failed)", sent, failed)
+		}
+	}
+
+	elapsed := time.Since(start)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(sent) / elapsed.Seconds()
+	}
+	log.Printf("Replayed %d commands in %v (%.0f ops/sec, %d failed)", sent, elapsed.Round(time.Millisecond), rate, failed)
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
+	"multithreaded-redis/internal/config"
 	"multithreaded-redis/internal/net"
 	"os"
 	"os/signal"
+	"runtime"
 	"syscall"
 	"time"
 )
@@ -14,7 +18,32 @@ func main() {
 	// Enable immediate logging
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
 	
-	s := net.NewServer(":6380")
+	configPath := flag.String("config", "", "path to a config file (\"name value\" per line)")
+	selfTest := flag.Bool("selftest", false, "check every subsystem, print the results and exit, nonzero if any check failed")
+	overrides := config.Flags(flag.CommandLine)
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Error loading config: %v", err)
+	}
+	if err := overrides.Apply(cfg); err != nil {
+		log.Fatalf("Invalid flag: %v", err)
+	}
+
+	// Go sizes GOMAXPROCS to the machine, not to a container's CPU limit
+	if n := config.CPUs(); n < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(n)
+		log.Printf("GOMAXPROCS set to %d from the cgroup CPU limit", n)
+	}
+
+	s, err := net.NewServer(cfg)
+	if err != nil {
+		log.Fatalf("Error creating server: %v", err)
+	}
+	if *selfTest {
+		os.Exit(runSelfTest(s))
+	}
 	if err := s.Start(); err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -22,7 +51,25 @@ func main() {
 
 	//gracefully shutdown on SIGINT or SIGTERM
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	<-ctx.Done()
+	// SIGUSR2 hands the socket and keys to a new process running the binary
+	// now on disk, then exits. Connected clients are dropped and reconnect
+	// to it; if it fails to take over, this process serves on.
+	upgrade := make(chan os.Signal, 1)
+	signal.Notify(upgrade, syscall.SIGUSR2)
+	for ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-upgrade:
+			upgradeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := s.Upgrade(upgradeCtx)
+			cancel()
+			if err == nil {
+				log.Println("Server handed over to the new process")
+				return
+			}
+			log.Printf("Upgrade failed, still serving: %v", err)
+		}
+	}
 	stop()
 
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -34,3 +81,20 @@ func main() {
 		log.Println("Server shut down gracefully")
 	}
 }
+
+// runSelfTest prints the outcome of every self-test check and returns the
+// exit status: 0 if all passed
+func runSelfTest(s *net.Server) int {
+	status := 0
+	for _, r := range s.SelfTest() {
+		result := "ok"
+		if !r.OK {
+			result, status = "FAILED", 1
+		}
+		fmt.Printf("%-12s %-6s %-10v %s\n", r.Name, result, r.Took.Round(time.Microsecond), r.Detail)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s.Shutdown(ctx)
+	return status
+}
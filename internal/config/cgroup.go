@@ -0,0 +1,71 @@
+package config
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// CPUs is how many CPUs the process may use: the cgroup CPU quota rounded
+// up when one is set, as in a container with a CPU limit, otherwise every
+// CPU of the machine
+func CPUs() int {
+	n := runtime.NumCPU()
+	if limit, ok := CPULimit(); ok {
+		n = min(n, max(int(math.Ceil(limit)), 1))
+	}
+	return n
+}
+
+// CPULimit reads the cgroup CPU quota in CPUs, from cpu.max under cgroup v2
+// or cpu.cfs_quota_us and cpu.cfs_period_us under v1. ok is false when no
+// quota is set or none can be read.
+func CPULimit() (limit float64, ok bool) {
+	if data, err := os.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		// "max 100000" or "<quota> <period>"
+		quota, period, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+		return quotaCPUs(quota, period)
+	}
+	quota, err := os.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	if err != nil {
+		return 0, false
+	}
+	period, err := os.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+	if err != nil {
+		return 0, false
+	}
+	return quotaCPUs(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func quotaCPUs(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 { // "max", or -1 under v1
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
+
+// MemoryLimit reads the cgroup memory limit in bytes, from memory.max under
+// cgroup v2 or memory.limit_in_bytes under v1. ok is false when no limit is
+// set or none can be read.
+func MemoryLimit() (limit int64, ok bool) {
+	data, err := os.ReadFile("/sys/fs/cgroup/memory.max")
+	if err != nil {
+		if data, err = os.ReadFile("/sys/fs/cgroup/memory/memory.limit_in_bytes"); err != nil {
+			return 0, false
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	// "max" under v2; v1 reports no limit as a number near the largest
+	// int64, rounded down to a page
+	if err != nil || n <= 0 || n >= 1<<62 {
+		return 0, false
+	}
+	return n, true
+}
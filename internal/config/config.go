@@ -0,0 +1,987 @@
+// Package config holds the server's tunables. Values come from defaults, an
+// optional redis.conf-style file ("name value" per line, # comments) and
+// command-line flags, in that order, and the mutable ones may be changed at
+// runtime with CONFIG SET.
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// Config guards a set of Values for concurrent CONFIG GET/SET
+type Config struct {
+	mu   sync.RWMutex
+	file string // where CONFIG REWRITE writes, empty if none
+	Values
+
+	fileValues map[string]string // what the file set when last read, by parameter
+	secrets    map[string]string // secret file contents last applied, by parameter
+}
+
+// Values are the parameters themselves
+type Values struct {
+	Addr     string
+	WSAddr   string
+	Shards   int // 0: one per CPU the process may use, see CPUs
+	Replicas int // virtual nodes per shard on the hash ring
+
+	Databases int // logical databases selectable with SELECT
+
+	Timeout      time.Duration // idle time before a connection is closed, 0 = never
+	ReadTimeout  time.Duration // time allowed to receive one command, 0 = no limit
+	TCPKeepAlive time.Duration // 0 = keepalive off
+
+	ProtoMaxBulkLen int // longest bulk string a client may send
+
+	AcceptRateLimit int // connections started per second, 0 = unlimited
+	AcceptQueue     int // accepted connections waiting to start; more are refused
+	MaxClientsPerIP int // 0 = unlimited
+
+	CleanerSampleSize int
+	CleanerInterval   time.Duration
+	DefragSampleSize  int
+	DefragInterval    time.Duration
+
+	// TTLPolicies give keys a default and a max TTL by name prefix
+	TTLPolicies []store.TTLPolicy
+
+	HotKeyThreshold int
+	HotKeyWindow    time.Duration
+
+	MaxMemory        int64
+	MaxMemoryPolicy  store.EvictionPolicy
+	MaxMemoryTypes   []store.ValueType // eviction candidates, empty = all
+	MaxValueSize     int
+	MaxCollectionLen int
+	InternMembers    bool // share one copy of set members and hash fields across keys
+
+	// MemoryGuard sets how the server responds as the process nears its
+	// memory limit
+	MemoryGuard MemoryGuard
+
+	// Encoding bounds the small sets and hashes kept in compact encodings
+	Encoding store.EncodingLimits
+
+	// Bloom shapes the filters BFADD creates for missing keys
+	Bloom store.BloomDefaults
+
+	NotifyKeyspaceEvents store.EventClass
+
+	// ZSetScoreEvents are the sorted set key patterns whose score changes are
+	// published on __zscore@<db>__:<key>, empty = none
+	ZSetScoreEvents []string
+
+	// CommandAliases map extra command names, upper case, to the commands
+	// they run, e.g. JSON.GET to GET
+	CommandAliases map[string]string
+
+	// CommandPolicy limits commands, by upper-case name, to
+	// CommandPolicyAdminOnly users or turns them off with CommandPolicyDeny;
+	// commands not listed are allowed
+	CommandPolicy map[string]string
+
+	OwnershipAuditInterval time.Duration // 0: no periodic audit
+
+	// VNodeReweightInterval is how often virtual nodes move toward an even
+	// load, within VNodes, 0 = never
+	VNodeReweightInterval time.Duration
+	VNodes                store.VNodeBounds
+
+	// Migration caps how fast resharding moves keys
+	Migration store.MigrationLimits
+
+	EventLogMaxLen int // server events EVENTS keeps; older ones are dropped
+
+	// AnomalyWindow is the period per-user command counts are judged over,
+	// 0 = no anomaly detection. A count over AnomalyFactor times its average
+	// and at least AnomalyMinCount is reported.
+	AnomalyWindow   time.Duration
+	AnomalyFactor   int
+	AnomalyMinCount int
+
+	// ConfirmDestructive makes FLUSHALL, FLUSHDB and REMOVENODE run only
+	// when repeated with the token their first attempt replies with, within
+	// ConfirmDestructiveWindow
+	ConfirmDestructive       bool
+	ConfirmDestructiveWindow time.Duration
+
+	AdaptiveTTL store.AdaptiveTTL
+
+	// MultiErrorPolicy is what a malformed or unknown command queued inside
+	// MULTI does: MultiAbortTransaction or MultiCloseConnection
+	MultiErrorPolicy string
+
+	Storage store.EngineConfig
+
+	ClusterEnabled      bool   // answer CLUSTER commands and redirect replica writes with MOVED
+	ClusterAnnounceAddr string // host:port given to cluster clients, default the listen address
+
+	SentinelMasterName string // name Sentinel clients ask for this server's primary by
+
+	MQTTBroker        string // host:port of an MQTT broker to bridge pub/sub to, empty for none
+	MQTTChannelPrefix string // channels bridged to MQTT topics
+	MQTTTopicPrefix   string // MQTT topics bridged to channels
+	MQTTClientID      string // client ID the bridge connects with, default from the node ID
+
+	RequirePass     string // password of the default user, empty for none
+	RequirePassFile string // file requirepass is read from, e.g. a mounted Secret
+
+	// ConfigWatchInterval is how often the config file and secret files are
+	// checked for changes, 0 = never
+	ConfigWatchInterval time.Duration
+
+	ReplicaOf       string // "host port" of the primary to replicate at startup
+	MasterUser      string // user a replica authenticates to its primary as
+	MasterAuth      string // password a replica authenticates with, empty for none
+	MasterAuthFile  string // file masterauth is read from
+	ReplBacklogSize int    // bytes of recent writes kept for partial resyncs
+
+	// ReplPubSub is where PUBLISH delivers: ReplPubSubLeaderOnly or
+	// ReplPubSubEverywhere
+	ReplPubSub string
+
+	BackupS3Endpoint string
+	BackupS3Region   string
+	RestoreFrom      string // backup location loaded at startup
+
+	// BackupCompactAfter is how many increments an incremental backup may
+	// leave at a location before they are compacted in the background,
+	// 0 = never
+	BackupCompactAfter int
+
+	WarmupManifest string
+	WarmupLoader   string
+}
+
+func Default() *Config {
+	return &Config{Values: Values{
+		Addr:              ":6380",
+		TCPKeepAlive:      300 * time.Second,
+		ProtoMaxBulkLen:   protocol.DefaultMaxBulkLen,
+		AcceptQueue:       1024,
+		Shards:            2,
+		Replicas:          2,
+		Databases:         16,
+		CleanerSampleSize: 20,
+		CleanerInterval:   100 * time.Millisecond,
+		DefragSampleSize:  100,
+		DefragInterval:    10 * time.Second,
+		HotKeyThreshold:   5000,
+		HotKeyWindow:      time.Second,
+		MaxMemoryPolicy:   store.NoEviction,
+		MultiErrorPolicy:  MultiAbortTransaction,
+		EventLogMaxLen:    128,
+		AnomalyFactor:     10,
+		AnomalyMinCount:   100,
+		VNodes:            store.VNodeBounds{Min: 1},
+		MemoryGuard: MemoryGuard{
+			Warn:        80,
+			Evict:       85,
+			Reject:      90,
+			CloseIdle:   95,
+			IdleTimeout: time.Minute,
+		},
+
+		ConfirmDestructiveWindow: 30 * time.Second,
+		Encoding: store.EncodingLimits{
+			SetIntsetEntries:    512,
+			SetListpackEntries:  128,
+			SetListpackValue:    64,
+			HashListpackEntries: 128,
+			HashListpackValue:   64,
+		},
+		Bloom: store.DefaultBloom,
+		AdaptiveTTL: store.AdaptiveTTL{
+			Min:      time.Minute,
+			Max:      time.Hour,
+			HotReads: 10,
+		},
+		Storage: store.EngineConfig{
+			Engine:    store.MemoryEngine,
+			Dir:       "data",
+			CacheKeys: 10000,
+			TierIdle:  time.Minute,
+		},
+		ReplBacklogSize: 1 << 20,
+		ReplPubSub:      ReplPubSubLeaderOnly,
+		BackupS3Region:  "us-east-1",
+
+		SentinelMasterName: "mymaster",
+
+		MQTTChannelPrefix: "mqtt:",
+		MQTTTopicPrefix:   "redis/",
+	}}
+}
+
+// Values of multi-error-policy
+const (
+	// MultiAbortTransaction lets the connection go on and makes EXEC fail
+	// with EXECABORT, as Redis does
+	MultiAbortTransaction = "abort-transaction"
+	// MultiCloseConnection drops the connection and its queued commands
+	MultiCloseConnection = "close-connection"
+)
+
+// Values of repl-pubsub
+const (
+	// ReplPubSubLeaderOnly delivers messages to subscribers of the server
+	// they were published on
+	ReplPubSubLeaderOnly = "leader-only"
+	// ReplPubSubEverywhere also sends messages published on a primary down
+	// the replication stream, to subscribers of its replicas
+	ReplPubSubEverywhere = "everywhere"
+)
+
+// MemoryGuard sets the tiers of the server's response to memory pressure,
+// in percent of Limit or, when Limit is 0, of the cgroup memory limit. Each
+// tier adds to the ones below it; a tier at 0 is off.
+type MemoryGuard struct {
+	Limit     int64 // bytes, 0 = the cgroup limit; without one the guard is off
+	Warn      int   // log and record an event
+	Evict     int   // evict keys by maxmemory-policy whatever maxmemory is
+	Reject    int   // refuse writes that may add memory
+	CloseIdle int   // close clients idle for IdleTimeout
+
+	IdleTimeout time.Duration
+}
+
+// Policies of command-policy
+const (
+	CommandPolicyAllow     = "allow"      // the default, never stored
+	CommandPolicyDeny      = "deny"       // the command fails for everyone
+	CommandPolicyAdminOnly = "admin-only" // only users allowed every @admin command may run it
+)
+
+// param describes one tunable. Only mutable params may change at runtime.
+type param struct {
+	name    string
+	mutable bool
+	usage   string
+	get     func(c *Values) string
+	set     func(c *Values, v string) error
+}
+
+func intParam(name string, mutable bool, usage string, field func(c *Values) *int) param {
+	return param{
+		name: name, mutable: mutable, usage: usage,
+		get: func(c *Values) string { return strconv.Itoa(*field(c)) },
+		set: func(c *Values, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return fmt.Errorf("argument must be a non-negative integer")
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+func durationParam(name string, mutable bool, usage string, field func(c *Values) *time.Duration) param {
+	return param{
+		name: name, mutable: mutable, usage: usage,
+		get: func(c *Values) string { return field(c).String() },
+		set: func(c *Values, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("argument must be a positive duration such as 500ms or 10s")
+			}
+			*field(c) = d
+			return nil
+		},
+	}
+}
+
+// offDurationParam is durationParam for settings that 0 turns off
+func offDurationParam(name string, usage string, field func(c *Values) *time.Duration) param {
+	return param{
+		name: name, mutable: true, usage: usage,
+		get: func(c *Values) string { return field(c).String() },
+		set: func(c *Values, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return fmt.Errorf("argument must be a duration such as 10s, or 0 to disable")
+			}
+			*field(c) = d
+			return nil
+		},
+	}
+}
+
+func stringParam(name string, usage string, field func(c *Values) *string) param {
+	return param{
+		name: name, usage: usage,
+		get: func(c *Values) string { return *field(c) },
+		set: func(c *Values, v string) error {
+			*field(c) = v
+			return nil
+		},
+	}
+}
+
+var params = []param{
+	stringParam("addr", "TCP address to listen on", func(c *Values) *string { return &c.Addr }),
+	offDurationParam("timeout", "close connections idle for this long, e.g. 5m (0 = never; subscribers and replicas are exempt)", func(c *Values) *time.Duration { return &c.Timeout }),
+	offDurationParam("read-timeout", "close connections that take longer than this to send a whole command (0 = no limit)", func(c *Values) *time.Duration { return &c.ReadTimeout }),
+	{
+		name: "proto-max-bulk-len", mutable: true, usage: "longest bulk string a client may send, in bytes, at least 1mb; longer ones are a protocol error",
+		get: func(c *Values) string { return strconv.Itoa(c.ProtoMaxBulkLen) },
+		set: func(c *Values, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1<<20 {
+				return fmt.Errorf("argument must be an integer of at least 1048576")
+			}
+			c.ProtoMaxBulkLen = n
+			return nil
+		},
+	},
+	offDurationParam("tcp-keepalive", "TCP keepalive period for new connections (0 = off)", func(c *Values) *time.Duration { return &c.TCPKeepAlive }),
+	intParam("accept-rate-limit", true, "new connections started per second; more wait in the accept queue (0 = unlimited)", func(c *Values) *int { return &c.AcceptRateLimit }),
+	intParam("accept-queue", false, "accepted connections that may wait to start; more are refused", func(c *Values) *int { return &c.AcceptQueue }),
+	intParam("max-clients-per-ip", true, "connections one client IP may hold open (0 = unlimited)", func(c *Values) *int { return &c.MaxClientsPerIP }),
+	stringParam("ws-addr", "also serve RESP over WebSocket on this address, e.g. :6381", func(c *Values) *string { return &c.WSAddr }),
+	{
+		name: "shards", usage: "number of shards created at startup, or auto for one per CPU the process may use",
+		get: func(c *Values) string {
+			if c.Shards == 0 {
+				return "auto"
+			}
+			return strconv.Itoa(c.Shards)
+		},
+		set: func(c *Values, v string) error {
+			if strings.EqualFold(v, "auto") {
+				c.Shards = 0
+				return nil
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("argument must be a positive integer or auto")
+			}
+			c.Shards = n
+			return nil
+		},
+	},
+	intParam("replicas", false, "virtual nodes per shard on the hash ring", func(c *Values) *int { return &c.Replicas }),
+	intParam("databases", false, "number of logical databases selectable with SELECT", func(c *Values) *int { return &c.Databases }),
+	intParam("cleaner-sample-size", true, "keys an expire cycle deletes between checks of its time limit", func(c *Values) *int { return &c.CleanerSampleSize }),
+	durationParam("cleaner-interval", true, "time between active expire cycles; shards with many keys expiring run them sooner", func(c *Values) *time.Duration { return &c.CleanerInterval }),
+	intParam("defrag-sample-size", false, "containers inspected per defrag run", func(c *Values) *int { return &c.DefragSampleSize }),
+	durationParam("defrag-interval", false, "time between defrag runs", func(c *Values) *time.Duration { return &c.DefragInterval }),
+	intParam("hotkey-threshold", true, "reads per window that make a key hot (0 = no replication)", func(c *Values) *int { return &c.HotKeyThreshold }),
+	durationParam("hotkey-window", true, "hot key counting window", func(c *Values) *time.Duration { return &c.HotKeyWindow }),
+	{
+		name: "maxmemory", mutable: true, usage: "memory budget for keys and values, e.g. 512mb (0 = unlimited)",
+		get: func(c *Values) string { return strconv.FormatInt(c.MaxMemory, 10) },
+		set: func(c *Values, v string) error {
+			n, err := store.ParseMemorySize(v)
+			if err != nil {
+				return err
+			}
+			c.MaxMemory = n
+			return nil
+		},
+	},
+	{
+		name: "maxmemory-policy", mutable: true, usage: "noeviction, allkeys-lru, allkeys-random, volatile-lru or volatile-ttl",
+		get: func(c *Values) string { return c.MaxMemoryPolicy.String() },
+		set: func(c *Values, v string) error {
+			p, err := store.ParseEvictionPolicy(v)
+			if err != nil {
+				return err
+			}
+			c.MaxMemoryPolicy = p
+			return nil
+		},
+	},
+	{
+		name: "maxmemory-eviction-types", mutable: true, usage: "comma-separated types eviction may remove, e.g. string,hash (empty = all)",
+		get: func(c *Values) string {
+			names := make([]string, len(c.MaxMemoryTypes))
+			for i, t := range c.MaxMemoryTypes {
+				names[i] = t.String()
+			}
+			return strings.Join(names, ",")
+		},
+		set: func(c *Values, v string) error {
+			var types []store.ValueType
+			for _, name := range strings.Split(v, ",") {
+				if name = strings.TrimSpace(name); name == "" {
+					continue
+				}
+				t, err := store.ParseValueType(name)
+				if err != nil {
+					return err
+				}
+				types = append(types, t)
+			}
+			c.MaxMemoryTypes = types
+			return nil
+		},
+	},
+	{
+		name: "memory-guard-limit", mutable: true, usage: "process memory the memory-guard tiers are percentages of, e.g. 4gb (0 = the cgroup memory limit, off without one)",
+		get: func(c *Values) string { return strconv.FormatInt(c.MemoryGuard.Limit, 10) },
+		set: func(c *Values, v string) error {
+			n, err := store.ParseMemorySize(v)
+			if err != nil {
+				return err
+			}
+			c.MemoryGuard.Limit = n
+			return nil
+		},
+	},
+	intParam("memory-guard-warn", true, "percent of the memory limit at which pressure is logged (0 = off)", func(c *Values) *int { return &c.MemoryGuard.Warn }),
+	intParam("memory-guard-evict", true, "percent of the memory limit at which keys are evicted by maxmemory-policy (0 = off)", func(c *Values) *int { return &c.MemoryGuard.Evict }),
+	intParam("memory-guard-reject", true, "percent of the memory limit at which writes are refused (0 = off)", func(c *Values) *int { return &c.MemoryGuard.Reject }),
+	intParam("memory-guard-close-idle", true, "percent of the memory limit at which idle clients are closed (0 = off)", func(c *Values) *int { return &c.MemoryGuard.CloseIdle }),
+	durationParam("memory-guard-idle-timeout", true, "how long a client must be idle to be closed under memory pressure", func(c *Values) *time.Duration { return &c.MemoryGuard.IdleTimeout }),
+	intParam("max-value-size", true, "max bytes per string value or collection element (0 = unlimited)", func(c *Values) *int { return &c.MaxValueSize }),
+	intParam("max-collection-len", true, "max elements per set, hash, list or sorted set (0 = unlimited)", func(c *Values) *int { return &c.MaxCollectionLen }),
+	{
+		name: "notify-keyspace-events", mutable: true, usage: "keyspace event classes to publish, e.g. KEA (empty = off)",
+		get: func(c *Values) string { return c.NotifyKeyspaceEvents.String() },
+		set: func(c *Values, v string) error {
+			classes, err := store.ParseEventClasses(v)
+			if err != nil {
+				return err
+			}
+			c.NotifyKeyspaceEvents = classes
+			return nil
+		},
+	},
+	{
+		name: "intern-members", mutable: true, usage: "share one copy of each set member, hash field and sorted set member across keys (yes or no)",
+		get: func(c *Values) string {
+			if c.InternMembers {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(c *Values, v string) error {
+			switch strings.ToLower(v) {
+			case "yes":
+				c.InternMembers = true
+			case "no":
+				c.InternMembers = false
+			default:
+				return fmt.Errorf("argument must be yes or no")
+			}
+			return nil
+		},
+	},
+	intParam("set-max-intset-entries", true, "largest set of integers kept as a sorted array (0 = never)", func(c *Values) *int { return &c.Encoding.SetIntsetEntries }),
+	intParam("set-max-listpack-entries", true, "largest set of other members kept as a flat list (0 = never)", func(c *Values) *int { return &c.Encoding.SetListpackEntries }),
+	intParam("set-max-listpack-value", true, "longest member, in bytes, of a set kept as a flat list", func(c *Values) *int { return &c.Encoding.SetListpackValue }),
+	intParam("hash-max-listpack-entries", true, "most fields of a hash kept as a flat list (0 = never)", func(c *Values) *int { return &c.Encoding.HashListpackEntries }),
+	intParam("hash-max-listpack-value", true, "longest field or value, in bytes, of a hash kept as a flat list", func(c *Values) *int { return &c.Encoding.HashListpackValue }),
+	{
+		name: "bf-error-rate", mutable: true, usage: "false positive rate of Bloom filters BFADD creates",
+		get: func(c *Values) string { return strconv.FormatFloat(c.Bloom.ErrorRate, 'g', -1, 64) },
+		set: func(c *Values, v string) error {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || !(f > 0 && f < 1) {
+				return fmt.Errorf("argument must be a number between 0 and 1, exclusive")
+			}
+			c.Bloom.ErrorRate = f
+			return nil
+		},
+	},
+	intParam("bf-initial-size", true, "items the first layer of a Bloom filter BFADD creates holds", func(c *Values) *int { return &c.Bloom.Capacity }),
+	intParam("bf-expansion", true, "growth factor of the layers added to full Bloom filters (0 = filters do not scale)", func(c *Values) *int { return &c.Bloom.Expansion }),
+	{
+		name: "zset-score-events", mutable: true, usage: "comma-separated key patterns of sorted sets whose ZADD/ZINCRBY score changes are published (empty = none)",
+		get: func(c *Values) string { return strings.Join(c.ZSetScoreEvents, ",") },
+		set: func(c *Values, v string) error {
+			var patterns []string
+			for _, p := range strings.Split(v, ",") {
+				if p = strings.TrimSpace(p); p == "" {
+					continue
+				}
+				if err := store.CheckGlob(p); err != nil {
+					return err
+				}
+				patterns = append(patterns, p)
+			}
+			c.ZSetScoreEvents = patterns
+			return nil
+		},
+	},
+	{
+		name: "command-aliases", mutable: true, usage: "comma-separated alias=command pairs adding command names, e.g. JSON.GET=GET (empty = none)",
+		get: func(c *Values) string {
+			pairs := make([]string, 0, len(c.CommandAliases))
+			for alias, cmd := range c.CommandAliases {
+				pairs = append(pairs, alias+"="+cmd)
+			}
+			sort.Strings(pairs)
+			return strings.Join(pairs, ",")
+		},
+		set: func(c *Values, v string) error {
+			aliases := map[string]string{}
+			for _, p := range strings.Split(v, ",") {
+				if p = strings.TrimSpace(p); p == "" {
+					continue
+				}
+				alias, cmd, ok := strings.Cut(p, "=")
+				alias, cmd = strings.TrimSpace(alias), strings.TrimSpace(cmd)
+				if !ok || alias == "" || cmd == "" || strings.ContainsAny(alias+cmd, " \t") {
+					return fmt.Errorf("invalid alias %q, want alias=command", p)
+				}
+				aliases[strings.ToUpper(alias)] = strings.ToUpper(cmd)
+			}
+			c.CommandAliases = aliases
+			return nil
+		},
+	},
+	{
+		name: "command-policy", mutable: true, usage: "comma-separated command=policy pairs, policy deny or admin-only, e.g. KEYS=deny,FLUSHALL=admin-only (empty = none)",
+		get: func(c *Values) string {
+			pairs := make([]string, 0, len(c.CommandPolicy))
+			for cmd, policy := range c.CommandPolicy {
+				pairs = append(pairs, cmd+"="+policy)
+			}
+			sort.Strings(pairs)
+			return strings.Join(pairs, ",")
+		},
+		set: func(c *Values, v string) error {
+			policies := map[string]string{}
+			for _, p := range strings.Split(v, ",") {
+				if p = strings.TrimSpace(p); p == "" {
+					continue
+				}
+				cmd, policy, ok := strings.Cut(p, "=")
+				cmd, policy = strings.ToUpper(strings.TrimSpace(cmd)), strings.ToLower(strings.TrimSpace(policy))
+				if !ok || cmd == "" || strings.ContainsAny(cmd, " \t") {
+					return fmt.Errorf("invalid policy %q, want command=policy", p)
+				}
+				switch policy {
+				case CommandPolicyAllow:
+					continue // the default
+				case CommandPolicyDeny, CommandPolicyAdminOnly:
+				default:
+					return fmt.Errorf("invalid policy %q for %s, want deny, admin-only or allow", policy, cmd)
+				}
+				policies[cmd] = policy
+			}
+			c.CommandPolicy = policies
+			return nil
+		},
+	},
+	{
+		name: "ttl-policy", mutable: true, usage: "comma-separated prefix=default/max TTLs for keys by name, the longest matching prefix applying, e.g. cache:=1h/24h,tmp:=/10m (0 or empty = none)",
+		get: func(c *Values) string {
+			entries := make([]string, 0, len(c.TTLPolicies))
+			for _, p := range c.TTLPolicies {
+				entries = append(entries, p.Prefix+"="+ttlPolicyDuration(p.Default)+"/"+ttlPolicyDuration(p.Max))
+			}
+			sort.Strings(entries)
+			return strings.Join(entries, ",")
+		},
+		set: func(c *Values, v string) error {
+			var policies []store.TTLPolicy
+			seen := map[string]bool{}
+			for _, e := range strings.Split(v, ",") {
+				if e = strings.TrimSpace(e); e == "" {
+					continue
+				}
+				prefix, ttls, ok := strings.Cut(e, "=")
+				if !ok || strings.ContainsAny(prefix, " \t") {
+					return fmt.Errorf("invalid TTL policy %q, want prefix=default/max", e)
+				}
+				if seen[prefix] {
+					return fmt.Errorf("prefix %q has more than one TTL policy", prefix)
+				}
+				seen[prefix] = true
+				def, maxTTL, _ := strings.Cut(ttls, "/")
+				p := store.TTLPolicy{Prefix: prefix}
+				var err error
+				if p.Default, err = parseTTLPolicyDuration(def); err != nil {
+					return fmt.Errorf("invalid default TTL in %q: %v", e, err)
+				}
+				if p.Max, err = parseTTLPolicyDuration(maxTTL); err != nil {
+					return fmt.Errorf("invalid max TTL in %q: %v", e, err)
+				}
+				if p.Default == 0 && p.Max == 0 {
+					continue // nothing to enforce
+				}
+				if p.Max > 0 && p.Default > p.Max {
+					return fmt.Errorf("default TTL in %q is longer than its max", e)
+				}
+				policies = append(policies, p)
+			}
+			c.TTLPolicies = policies
+			return nil
+		},
+	},
+	offDurationParam("ownership-audit-interval", "how often every key is checked against its ring owner, e.g. 10m (0 = never)", func(c *Values) *time.Duration { return &c.OwnershipAuditInterval }),
+	offDurationParam("vnode-reweight-interval", "how often shards' virtual nodes are adjusted toward an even load, e.g. 5m (0 = never)", func(c *Values) *time.Duration { return &c.VNodeReweightInterval }),
+	intParam("vnode-min", true, "fewest virtual nodes reweighting leaves a shard", func(c *Values) *int { return &c.VNodes.Min }),
+	intParam("vnode-max", true, "most virtual nodes reweighting gives a shard (0 = four times replicas)", func(c *Values) *int { return &c.VNodes.Max }),
+	intParam("migration-max-keys-per-sec", true, "keys resharding moves per second at most (0 = unlimited)", func(c *Values) *int { return &c.Migration.KeysPerSec }),
+	{
+		name: "migration-max-bytes-per-sec", mutable: true, usage: "bytes of values resharding moves per second at most, e.g. 10mb (0 = unlimited)",
+		get: func(c *Values) string { return strconv.FormatInt(c.Migration.BytesPerSec, 10) },
+		set: func(c *Values, v string) error {
+			n, err := store.ParseMemorySize(v)
+			if err != nil {
+				return err
+			}
+			c.Migration.BytesPerSec = n
+			return nil
+		},
+	},
+	intParam("event-log-max-len", true, "server events kept for EVENTS; older ones are dropped", func(c *Values) *int { return &c.EventLogMaxLen }),
+	offDurationParam("anomaly-window", "period per-user command counts are compared with their averages over, e.g. 10s (0 = off)", func(c *Values) *time.Duration { return &c.AnomalyWindow }),
+	intParam("anomaly-factor", true, "how many times its average a command's count must reach to be reported", func(c *Values) *int { return &c.AnomalyFactor }),
+	intParam("anomaly-min-count", true, "fewest calls or keys in a window that are reported", func(c *Values) *int { return &c.AnomalyMinCount }),
+	{
+		name: "confirm-destructive", mutable: true, usage: "make FLUSHALL, FLUSHDB and REMOVENODE wait for a confirmation token (yes or no)",
+		get: func(c *Values) string {
+			if c.ConfirmDestructive {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(c *Values, v string) error {
+			switch strings.ToLower(v) {
+			case "yes":
+				c.ConfirmDestructive = true
+			case "no":
+				c.ConfirmDestructive = false
+			default:
+				return fmt.Errorf("argument must be yes or no")
+			}
+			return nil
+		},
+	},
+	durationParam("confirm-destructive-window", true, "how long a destructive command's confirmation token stays valid", func(c *Values) *time.Duration { return &c.ConfirmDestructiveWindow }),
+
+	offDurationParam("adaptive-ttl-interval", "how often TTLs move toward read frequency, e.g. 1m (0 = never)", func(c *Values) *time.Duration { return &c.AdaptiveTTL.Interval }),
+
+	durationParam("adaptive-ttl-min", true, "shortest TTL adaptive expiration shortens unread keys to", func(c *Values) *time.Duration { return &c.AdaptiveTTL.Min }),
+	durationParam("adaptive-ttl-max", true, "longest TTL adaptive expiration extends hot keys to", func(c *Values) *time.Duration { return &c.AdaptiveTTL.Max }),
+	intParam("adaptive-ttl-hot-reads", true, "reads per adaptive-ttl-interval that extend a key's TTL", func(c *Values) *int { return &c.AdaptiveTTL.HotReads }),
+	{
+		name: "multi-error-policy", mutable: true, usage: "what a command rejected while queuing in MULTI does: abort-transaction or close-connection",
+		get: func(c *Values) string { return c.MultiErrorPolicy },
+		set: func(c *Values, v string) error {
+			v = strings.ToLower(v)
+			if v != MultiAbortTransaction && v != MultiCloseConnection {
+				return fmt.Errorf("unknown multi error policy %q (want abort-transaction or close-connection)", v)
+			}
+			c.MultiErrorPolicy = v
+			return nil
+		},
+	},
+	{
+		name: "storage-engine", usage: "engine holding each shard's keys: memory, disk (spill by LRU) or tiered (spill when idle) under storage-dir",
+		get: func(c *Values) string { return c.Storage.Engine },
+		set: func(c *Values, v string) error {
+			if err := store.CheckEngine(v); err != nil {
+				return err
+			}
+			c.Storage.Engine = v
+			return nil
+		},
+	},
+	stringParam("storage-dir", "directory for disk engine spill files", func(c *Values) *string { return &c.Storage.Dir }),
+	intParam("storage-cache-keys", false, "values a disk engine keeps decoded in memory, per shard", func(c *Values) *int { return &c.Storage.CacheKeys }),
+	durationParam("tier-idle-threshold", true, "how long a tiered engine keeps an unused value in memory", func(c *Values) *time.Duration { return &c.Storage.TierIdle }),
+	{
+		name: "storage-namespaces", usage: "per-namespace engines, e.g. archive=disk,session=memory (namespace = key prefix before ':')",
+		get: func(c *Values) string {
+			pairs := make([]string, 0, len(c.Storage.Namespaces))
+			for ns, engine := range c.Storage.Namespaces {
+				pairs = append(pairs, ns+"="+engine)
+			}
+			sort.Strings(pairs)
+			return strings.Join(pairs, ",")
+		},
+		set: func(c *Values, v string) error {
+			spaces := make(map[string]string)
+			for _, pair := range strings.Split(v, ",") {
+				if pair = strings.TrimSpace(pair); pair == "" {
+					continue
+				}
+				ns, engine, ok := strings.Cut(pair, "=")
+				if !ok || ns == "" {
+					return fmt.Errorf("expected namespace=engine, got %q", pair)
+				}
+				if err := store.CheckEngine(engine); err != nil {
+					return err
+				}
+				spaces[ns] = engine
+			}
+			c.Storage.Namespaces = spaces
+			return nil
+		},
+	},
+	{
+		name: "cluster-enabled", usage: "serve CLUSTER commands and send writes on a replica to its primary with MOVED (yes or no)",
+		get: func(c *Values) string {
+			if c.ClusterEnabled {
+				return "yes"
+			}
+			return "no"
+		},
+		set: func(c *Values, v string) error {
+			switch strings.ToLower(v) {
+			case "yes":
+				c.ClusterEnabled = true
+			case "no":
+				c.ClusterEnabled = false
+			default:
+				return fmt.Errorf("argument must be yes or no")
+			}
+			return nil
+		},
+	},
+	stringParam("cluster-announce-addr", "host:port cluster clients are told to connect to (default: the listen address)", func(c *Values) *string { return &c.ClusterAnnounceAddr }),
+	stringParam("sentinel-master-name", "master name SENTINEL commands answer for and +switch-master messages carry", func(c *Values) *string { return &c.SentinelMasterName }),
+	stringParam("mqtt-broker", "host:port of an MQTT broker to bridge pub/sub channels to and from (empty = no bridge)", func(c *Values) *string { return &c.MQTTBroker }),
+	stringParam("mqtt-channel-prefix", "channels under this prefix are bridged, to topics under mqtt-topic-prefix", func(c *Values) *string { return &c.MQTTChannelPrefix }),
+	stringParam("mqtt-topic-prefix", "MQTT topics under this prefix are bridged, to channels under mqtt-channel-prefix", func(c *Values) *string { return &c.MQTTTopicPrefix }),
+	stringParam("mqtt-client-id", "client ID the MQTT bridge connects with (empty = derived from the node ID)", func(c *Values) *string { return &c.MQTTClientID }),
+	{
+		name: "requirepass", mutable: true, usage: "password the default user must AUTH with (empty = none)",
+		get: func(c *Values) string { return c.RequirePass },
+		set: func(c *Values, v string) error {
+			c.RequirePass = v
+			return nil
+		},
+	},
+	stringParam("requirepass-file", "file holding requirepass, re-read by config-watch-interval", func(c *Values) *string { return &c.RequirePassFile }),
+	offDurationParam("config-watch-interval", "how often the config file and secret files are re-read and changes applied, e.g. 10s (0 = never)", func(c *Values) *time.Duration { return &c.ConfigWatchInterval }),
+	stringParam("replicaof", "primary to replicate at startup, as \"host port\"", func(c *Values) *string { return &c.ReplicaOf }),
+	stringParam("masteruser", "user a replica authenticates to its primary as (empty = default)", func(c *Values) *string { return &c.MasterUser }),
+	stringParam("masterauth", "password a replica authenticates to its primary with", func(c *Values) *string { return &c.MasterAuth }),
+	stringParam("masterauth-file", "file holding masterauth, re-read by config-watch-interval", func(c *Values) *string { return &c.MasterAuthFile }),
+	intParam("repl-backlog-size", false, "bytes of recent writes kept so reconnecting replicas can resync partially", func(c *Values) *int { return &c.ReplBacklogSize }),
+	{
+		name: "repl-pubsub", mutable: true, usage: "where PUBLISH delivers: leader-only or everywhere (also to subscribers of replicas)",
+		get: func(c *Values) string { return c.ReplPubSub },
+		set: func(c *Values, v string) error {
+			v = strings.ToLower(v)
+			if v != ReplPubSubLeaderOnly && v != ReplPubSubEverywhere {
+				return fmt.Errorf("unknown pubsub delivery %q (want leader-only or everywhere)", v)
+			}
+			c.ReplPubSub = v
+			return nil
+		},
+	},
+	stringParam("backup-s3-endpoint", "S3-compatible endpoint for s3:// backup locations, e.g. http://localhost:9000 (empty = AWS)", func(c *Values) *string { return &c.BackupS3Endpoint }),
+	stringParam("backup-s3-region", "region used to sign S3 backup requests", func(c *Values) *string { return &c.BackupS3Region }),
+	intParam("backup-compact-after", true, "increments left at a backup location before they are folded into its full backup in the background (0 = never)", func(c *Values) *int { return &c.BackupCompactAfter }),
+	stringParam("restore-from", "backup directory or s3://bucket/prefix to load before serving", func(c *Values) *string { return &c.RestoreFrom }),
+	stringParam("warmup-manifest", "file listing keys to preload at startup, one per line", func(c *Values) *string { return &c.WarmupManifest }),
+	stringParam("warmup-loader", "HTTP endpoint preloaded values are fetched from (GET <loader>/<key>)", func(c *Values) *string { return &c.WarmupLoader }),
+}
+
+func lookupParam(name string) (param, bool) {
+	for _, p := range params {
+		if strings.EqualFold(p.name, name) {
+			return p, true
+		}
+	}
+	return param{}, false
+}
+
+// Load reads a config file over the defaults. An empty path yields defaults.
+func Load(file string) (*Config, error) {
+	c := Default()
+	if file == "" {
+		return c, nil
+	}
+	c.file = file
+	set, err := readFile(file, &c.Values)
+	if err != nil {
+		return nil, err
+	}
+	c.fileValues = set
+	return c, nil
+}
+
+// readFile applies a config file to v and returns what it set, by parameter
+func readFile(file string, v *Values) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	set := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		name, value, ok := parseLine(sc.Text())
+		if !ok {
+			continue
+		}
+		p, known := lookupParam(name)
+		if !known {
+			return nil, fmt.Errorf("%s:%d: unknown parameter %q", file, lineNo, name)
+		}
+		if err := p.set(v, value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %v", file, lineNo, name, err)
+		}
+		set[p.name] = value
+	}
+	return set, sc.Err()
+}
+
+// parseLine splits a "name value" line; blank lines and comments yield ok=false
+func parseLine(line string) (name, value string, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	name, value, _ = strings.Cut(line, " ")
+	return strings.ToLower(name), strings.Trim(strings.TrimSpace(value), `"`), true
+}
+
+// Flags registers one command-line flag per parameter. Call Apply after
+// flag.Parse to layer the flags that were given over a loaded config.
+func Flags(fs *flag.FlagSet) *FlagOverrides {
+	def := Default()
+	for _, p := range params {
+		fs.String(p.name, p.get(&def.Values), p.usage)
+	}
+	return &FlagOverrides{fs: fs}
+}
+
+type FlagOverrides struct {
+	fs *flag.FlagSet
+}
+
+func (o *FlagOverrides) Apply(c *Config) error {
+	var err error
+	o.fs.Visit(func(f *flag.Flag) {
+		if p, ok := lookupParam(f.Name); ok && err == nil {
+			if serr := p.set(&c.Values, f.Value.String()); serr != nil {
+				err = fmt.Errorf("-%s: %v", f.Name, serr)
+			}
+		}
+	})
+	return err
+}
+
+// Get returns the parameters whose names match the glob pattern
+func (c *Config) Get(pattern string) map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]string)
+	for _, p := range params {
+		if store.GlobMatch(strings.ToLower(pattern), p.name) {
+			out[p.name] = p.get(&c.Values)
+		}
+	}
+	return out
+}
+
+// Set changes a mutable parameter at runtime
+func (c *Config) Set(name, value string) error {
+	p, ok := lookupParam(name)
+	if !ok {
+		return fmt.Errorf("Unknown option or number of arguments for CONFIG SET - '%s'", name)
+	}
+	if !p.mutable {
+		return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", p.name)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := p.set(&c.Values, value); err != nil {
+		return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %v", p.name, err)
+	}
+	return nil
+}
+
+// Snapshot returns a copy that is safe to read without locking
+func (c *Config) Snapshot() Values {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.Values
+}
+
+// Rewrite saves the current values back to the config file, updating known
+// parameters in place, keeping comments and appending parameters that differ
+// from their defaults
+func (c *Config) Rewrite() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.file == "" {
+		return fmt.Errorf("The server is running without a config file")
+	}
+
+	var lines []string
+	written := map[string]bool{}
+	if data, err := os.ReadFile(c.file); err == nil {
+		for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+			if name, _, ok := parseLine(line); ok {
+				if p, known := lookupParam(name); known {
+					if written[p.name] {
+						continue // drop duplicates, the last one used to win
+					}
+					line = p.name + " " + quote(p.get(&c.Values))
+					written[p.name] = true
+				}
+			}
+			lines = append(lines, line)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	def := Default()
+	var extra []string
+	for _, p := range params {
+		if !written[p.name] && p.get(&c.Values) != p.get(&def.Values) {
+			extra = append(extra, p.name+" "+quote(p.get(&c.Values)))
+		}
+	}
+	sort.Strings(extra)
+	lines = append(lines, extra...)
+
+	tmp := c.file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.file)
+}
+
+func quote(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t") {
+		return `"` + v + `"`
+	}
+	return v
+}
+
+// parseTTLPolicyDuration parses one TTL of ttl-policy, "" or 0 for none
+func parseTTLPolicyDuration(v string) (time.Duration, error) {
+	if v = strings.TrimSpace(v); v == "" || v == "0" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("want a positive duration such as 30m or 24h")
+	}
+	return d, nil
+}
+
+// ttlPolicyDuration formats one TTL of ttl-policy, "" for none
+func ttlPolicyDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return d.String()
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// secretFiles pairs each *-file parameter with the parameter it fills, so a
+// password can come from a mounted secret instead of the config file
+var secretFiles = []struct{ file, param string }{
+	{"requirepass-file", "requirepass"},
+	{"masterauth-file", "masterauth"},
+}
+
+// Reload applies what changed in the config file and the secret files since
+// they were last read, and returns the parameters it set. Only parameters
+// whose line changed are applied, so CONFIG SET and flags keep their values
+// for the ones an edit left alone, and a removed line leaves its parameter
+// as it is. An immutable parameter that changed is returned in skipped and
+// waits for a restart. A file that does not parse changes nothing.
+func (c *Config) Reload() (changed, skipped []string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != "" {
+		scratch := c.Values
+		set, err := readFile(c.file, &scratch)
+		if err != nil {
+			return nil, nil, err
+		}
+		for name, value := range set {
+			if old, ok := c.fileValues[name]; ok && old == value {
+				continue
+			}
+			p, _ := lookupParam(name)
+			if !p.mutable && !isSecretFile(name) {
+				skipped = append(skipped, name)
+				continue
+			}
+			p.set(&c.Values, value) // checked on scratch
+			changed = append(changed, name)
+		}
+		c.fileValues = set
+	}
+	secrets, err := c.readSecrets()
+	return append(changed, secrets...), skipped, err
+}
+
+// ReadSecrets fills the parameters whose *-file parameter names a file,
+// returning those whose value changed
+func (c *Config) ReadSecrets() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readSecrets()
+}
+
+func (c *Config) readSecrets() ([]string, error) {
+	var changed []string
+	for _, sf := range secretFiles {
+		fp, _ := lookupParam(sf.file)
+		path := fp.get(&c.Values)
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return changed, fmt.Errorf("%s: %v", sf.file, err)
+		}
+		// mounted secrets often end in a newline the password does not have
+		value := strings.TrimRight(string(data), "\r\n")
+		if prev, ok := c.secrets[sf.param]; ok && prev == value {
+			continue
+		}
+		p, _ := lookupParam(sf.param)
+		if err := p.set(&c.Values, value); err != nil {
+			return changed, fmt.Errorf("%s: %v", sf.file, err)
+		}
+		if c.secrets == nil {
+			c.secrets = make(map[string]string)
+		}
+		c.secrets[sf.param] = value
+		changed = append(changed, sf.param)
+	}
+	return changed, nil
+}
+
+func isSecretFile(name string) bool {
+	for _, sf := range secretFiles {
+		if sf.file == name {
+			return true
+		}
+	}
+	return false
+}
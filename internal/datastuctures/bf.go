@@ -1,51 +1,259 @@
 package datastuctures
 
-import "hash/fnv"
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"hash/fnv"
+	"math"
+)
 
+// ErrBloomFull is an add to a non-scaling filter holding its capacity
+var ErrBloomFull = errors.New("ERR non scaling filter is full")
+
+// bloomTightening is the factor each added layer's error rate is scaled
+// by, so the rates of all layers together stay close to the requested one
+const bloomTightening = 0.5
+
+// bfData is used for serialization of BloomFilter. Filters written before
+// layers existed have only M, K, Bits and Seeds.
+type bfData struct {
+	M     uint
+	K     uint
+	Bits  []byte
+	Seeds []uint64
+
+	ErrorRate float64
+	Expansion uint
+	Layers    []bfLayerData
+}
+
+type bfLayerData struct {
+	M, K     uint
+	Bits     []byte
+	Seeds    []uint64
+	Capacity uint64
+	Count    uint64
+}
+
+// BloomFilter is a scalable Bloom filter: a stack of layers, each sized for
+// a capacity at an error rate. Items go into the newest layer; when it
+// holds its capacity a layer Expansion times bigger, at a tighter error
+// rate, is added. A filter with Expansion 0 does not scale and refuses
+// items once full.
 type BloomFilter struct {
-	m     uint
-	k     uint
-	bits  []byte
-	seeds []uint64
+	errorRate float64
+	expansion uint
+	layers    []*bloomLayer
+}
+
+type bloomLayer struct {
+	m, k     uint
+	bits     []byte
+	seeds    []uint64 // set on filters from before layers, hashed the old way
+	capacity uint64   // 0 for those, whose capacity was never recorded
+	count    uint64
+}
+
+// NewBloomFilter returns a filter for capacity items at errorRate, growing
+// by expansion when full, or not at all for 0
+func NewBloomFilter(errorRate float64, capacity uint64, expansion uint) *BloomFilter {
+	bf := &BloomFilter{errorRate: errorRate, expansion: expansion}
+	bf.layers = []*bloomLayer{newBloomLayer(errorRate, capacity)}
+	return bf
+}
+
+// BloomBytes is the size of the bit array of a layer for capacity items at
+// errorRate, for checking a size limit before allocating it
+func BloomBytes(errorRate float64, capacity uint64) uint64 {
+	m, _ := bloomShape(errorRate, capacity)
+	return (uint64(m) + 7) / 8
+}
+
+// bloomShape picks the bits and hash functions of a layer, the classic
+// m = -n ln p / (ln 2)^2 and k = log2(1/p)
+func bloomShape(errorRate float64, capacity uint64) (m, k uint) {
+	bitsPerItem := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	m = uint(math.Ceil(float64(max(capacity, 1)) * bitsPerItem))
+	k = uint(math.Ceil(-math.Log2(errorRate)))
+	return max(m, 8), max(k, 1)
 }
 
-func NewBloomFilter(m, k uint) *BloomFilter {
-	seeds := make([]uint64, k)
-	for i := uint(0); i < k; i++ {
-		seeds[i] = uint64(i + 1) // simple different seeds
+func newBloomLayer(errorRate float64, capacity uint64) *bloomLayer {
+	m, k := bloomShape(errorRate, capacity)
+	return &bloomLayer{m: m, k: k, bits: make([]byte, (m+7)/8), capacity: capacity}
+}
+
+// positions calls fn with each bit position of item, stopping if it
+// returns false. Layers use double hashing over one FNV-1a sum; old layers
+// keep their original per-seed hashes so their bits stay valid.
+func (l *bloomLayer) positions(item string, fn func(pos uint) bool) {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	if l.seeds != nil {
+		for _, seed := range l.seeds {
+			if !fn(uint((sum ^ seed) % uint64(l.m))) {
+				return
+			}
+		}
+		return
 	}
-	return &BloomFilter{
-		m:     m,
-		k:     k,
-		bits:  make([]byte, (m+7)/8), // round up to full bytes
-		seeds: seeds,
+	h1, h2 := sum&0xFFFFFFFF, sum>>32|1
+	for i := uint64(0); i < uint64(l.k); i++ {
+		if !fn(uint((h1 + i*h2) % uint64(l.m))) {
+			return
+		}
 	}
 }
 
-func (bf *BloomFilter) hash(data string, seed uint64) uint {
-	h := fnv.New64a()
-	h.Write(([]byte(data)))
-	sum := h.Sum64() ^ seed
-	return uint(sum % uint64(bf.m))
+func (l *bloomLayer) has(item string) bool {
+	found := true
+	l.positions(item, func(pos uint) bool {
+		found = l.bits[pos/8]&(1<<(pos%8)) != 0
+		return found
+	})
+	return found
+}
+
+func (l *bloomLayer) add(item string) {
+	l.positions(item, func(pos uint) bool {
+		l.bits[pos/8] |= 1 << (pos % 8)
+		return true
+	})
+	l.count++
 }
 
-func (bf *BloomFilter) Add(item string) {
-	for _, seed := range bf.seeds {
-		pos := bf.hash(item, seed)
-		byteIndex := pos / 8
-		bitIndex := pos % 8
-		bf.bits[byteIndex] |= (1 << bitIndex)
+// Add inserts item, reporting false if it may already be present, in which
+// case nothing changes
+func (bf *BloomFilter) Add(item string) (bool, error) {
+	if bf.Exists(item) {
+		return false, nil
+	}
+	last := bf.layers[len(bf.layers)-1]
+	if last.capacity > 0 && last.count >= last.capacity {
+		if bf.expansion == 0 {
+			return false, ErrBloomFull
+		}
+		last = bf.grow()
 	}
+	last.add(item)
+	return true, nil
+}
+
+// NextLayerBytes is the size of the layer the next Add would create, 0 if
+// it would create none
+func (bf *BloomFilter) NextLayerBytes() uint64 {
+	last := bf.layers[len(bf.layers)-1]
+	if last.capacity == 0 || last.count < last.capacity || bf.expansion == 0 {
+		return 0
+	}
+	rate, capacity := bf.nextLayer()
+	return BloomBytes(rate, capacity)
+}
+
+func (bf *BloomFilter) nextLayer() (float64, uint64) {
+	n := len(bf.layers)
+	return bf.errorRate * math.Pow(bloomTightening, float64(n)),
+		bf.layers[n-1].capacity * uint64(bf.expansion)
+}
+
+func (bf *BloomFilter) grow() *bloomLayer {
+	l := newBloomLayer(bf.nextLayer())
+	bf.layers = append(bf.layers, l)
+	return l
 }
 
+// Exists reports whether item may have been added
 func (bf *BloomFilter) Exists(item string) bool {
-	for _, seed := range bf.seeds {
-		pos := bf.hash(item, seed)
-		byteIndex := pos / 8
-		bitIndex := pos % 8
-		if bf.bits[byteIndex]&(1<<bitIndex) == 0 {
-			return false
+	for _, l := range bf.layers {
+		if l.has(item) {
+			return true
 		}
 	}
-	return true
+	return false
+}
+
+// SizeBytes returns the size of the bit arrays
+func (bf *BloomFilter) SizeBytes() int {
+	n := 0
+	for _, l := range bf.layers {
+		n += len(l.bits)
+	}
+	return n
+}
+
+// Bits returns the bit arrays of all layers, oldest first
+func (bf *BloomFilter) Bits() []byte {
+	if len(bf.layers) == 1 {
+		return bf.layers[0].bits
+	}
+	out := make([]byte, 0, bf.SizeBytes())
+	for _, l := range bf.layers {
+		out = append(out, l.bits...)
+	}
+	return out
+}
+
+// Capacity is the items the filter holds before it must grow, or before it
+// is full if it does not scale; 0 for a filter from before layers existed
+func (bf *BloomFilter) Capacity() uint64 {
+	var n uint64
+	for _, l := range bf.layers {
+		n += l.capacity
+	}
+	return n
+}
+
+// Count is the items added
+func (bf *BloomFilter) Count() uint64 {
+	var n uint64
+	for _, l := range bf.layers {
+		n += l.count
+	}
+	return n
+}
+
+// Layers is the number of sub-filters
+func (bf *BloomFilter) Layers() int {
+	return len(bf.layers)
+}
+
+// Expansion is the growth factor of added layers, 0 if the filter does not
+// scale
+func (bf *BloomFilter) Expansion() uint {
+	return bf.expansion
+}
+
+// GobEncode implements gob.GobEncoder interface
+func (bf *BloomFilter) GobEncode() ([]byte, error) {
+	d := bfData{ErrorRate: bf.errorRate, Expansion: bf.expansion, Layers: make([]bfLayerData, len(bf.layers))}
+	for i, l := range bf.layers {
+		d.Layers[i] = bfLayerData{M: l.m, K: l.k, Bits: l.bits, Seeds: l.seeds, Capacity: l.capacity, Count: l.count}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(d); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder interface
+func (bf *BloomFilter) GobDecode(data []byte) error {
+	var tmp bfData
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tmp); err != nil {
+		return err
+	}
+	if len(tmp.Layers) == 0 {
+		// a filter from before layers: one fixed layer that never fills
+		bf.layers = []*bloomLayer{{m: tmp.M, k: tmp.K, bits: tmp.Bits, seeds: tmp.Seeds}}
+		bf.errorRate, bf.expansion = 0, 0
+		return nil
+	}
+	bf.errorRate, bf.expansion = tmp.ErrorRate, tmp.Expansion
+	bf.layers = make([]*bloomLayer, len(tmp.Layers))
+	for i, l := range tmp.Layers {
+		bf.layers[i] = &bloomLayer{m: l.M, k: l.K, bits: l.Bits, seeds: l.Seeds, capacity: l.Capacity, count: l.Count}
+	}
+	return nil
 }
@@ -0,0 +1,260 @@
+package datastuctures
+
+import "math/rand"
+
+const (
+	skipListMaxLevel = 32
+	skipListP        = 0.25
+)
+
+// SkipListEntry is a (member, score) pair returned by range queries
+type SkipListEntry struct {
+	Member string
+	Score  float64
+}
+
+type skipListLevel struct {
+	forward *skipListNode
+	span    int // number of nodes skipped by forward
+}
+
+type skipListNode struct {
+	member   string
+	score    float64
+	backward *skipListNode
+	level    []skipListLevel
+}
+
+// SkipList keeps members ordered by (score, member) and tracks spans so
+// rank and index lookups are O(log n), same layout as the Redis zskiplist.
+type SkipList struct {
+	header *skipListNode
+	tail   *skipListNode
+	length int
+	level  int
+	slab   *skipListSlab // where nodes other than the header come from
+}
+
+func NewSkipList() *SkipList {
+	sl := &SkipList{
+		header: newSkipListNode(skipListMaxLevel, "", 0),
+		level:  1,
+	}
+	sl.slab = newSkipListSlab(sl)
+	return sl
+}
+
+func newSkipListNode(level int, member string, score float64) *skipListNode {
+	return &skipListNode{
+		member: member,
+		score:  score,
+		level:  make([]skipListLevel, level),
+	}
+}
+
+func randomSkipListLevel() int {
+	level := 1
+	for level < skipListMaxLevel && rand.Float64() < skipListP {
+		level++
+	}
+	return level
+}
+
+// less reports whether node sorts before (score, member)
+func (n *skipListNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// after reports whether node sorts strictly after (score, member)
+func (n *skipListNode) after(score float64, member string) bool {
+	return n.score > score || (n.score == score && n.member > member)
+}
+
+func (sl *SkipList) Len() int {
+	return sl.length
+}
+
+// Insert adds a member; the caller must make sure it is not already present
+func (sl *SkipList) Insert(member string, score float64) {
+	var update [skipListMaxLevel]*skipListNode
+	var rank [skipListMaxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i != sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomSkipListLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = sl.slab.node(level, member, score)
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// untouched levels get one more node under their span
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+	sl.length++
+}
+
+// Delete removes the member with the given score, returns false if not found
+func (sl *SkipList) Delete(member string, score float64) bool {
+	var update [skipListMaxLevel]*skipListNode
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.less(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < sl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+	sl.length--
+	sl.slab.release(x)
+	return true
+}
+
+// UpdateScore moves a member from oldScore to newScore
+func (sl *SkipList) UpdateScore(member string, oldScore, newScore float64) {
+	if oldScore == newScore {
+		return
+	}
+	sl.Delete(member, oldScore)
+	sl.Insert(member, newScore)
+}
+
+// Rank returns the 0-based position of member, or -1 if it is not present
+func (sl *SkipList) Rank(member string, score float64) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.after(score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != sl.header && x.score == score && x.member == member {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// nodeByRank returns the node at 0-based rank, or nil if out of range
+func (sl *SkipList) nodeByRank(rank int) *skipListNode {
+	if rank < 0 || rank >= sl.length {
+		return nil
+	}
+	traversed := 0
+	target := rank + 1
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= target {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == target {
+			return x
+		}
+	}
+	return nil
+}
+
+// Range returns entries with ranks in [start, stop] (inclusive, already clamped)
+func (sl *SkipList) Range(start, stop int) []SkipListEntry {
+	if start < 0 || start > stop || start >= sl.length {
+		return nil
+	}
+	if stop >= sl.length {
+		stop = sl.length - 1
+	}
+	out := make([]SkipListEntry, 0, stop-start+1)
+	x := sl.nodeByRank(start)
+	for i := start; i <= stop && x != nil; i++ {
+		out = append(out, SkipListEntry{Member: x.member, Score: x.score})
+		x = x.level[0].forward
+	}
+	return out
+}
+
+// firstFrom returns the first node with score >= min, or nil
+func (sl *SkipList) firstFrom(min float64) *skipListNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.score < min {
+			x = x.level[i].forward
+		}
+	}
+	return x.level[0].forward
+}
+
+// RangeByScore returns entries with min <= score <= max in ascending order
+func (sl *SkipList) RangeByScore(min, max float64) []SkipListEntry {
+	x := sl.firstFrom(min)
+
+	var out []SkipListEntry
+	for x != nil && x.score <= max {
+		out = append(out, SkipListEntry{Member: x.member, Score: x.score})
+		x = x.level[0].forward
+	}
+	return out
+}
+
+// FirstByScore returns up to limit entries with score >= min in ascending
+// order, in O(log n + limit)
+func (sl *SkipList) FirstByScore(min float64, limit int) []SkipListEntry {
+	var out []SkipListEntry
+	for x := sl.firstFrom(min); x != nil && len(out) < limit; x = x.level[0].forward {
+		out = append(out, SkipListEntry{Member: x.member, Score: x.score})
+	}
+	return out
+}
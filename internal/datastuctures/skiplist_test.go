@@ -0,0 +1,165 @@
+package datastuctures
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// sortedEntries returns the members of m in skip list order
+func sortedEntries(m map[string]float64) []SkipListEntry {
+	out := make([]SkipListEntry, 0, len(m))
+	for member, score := range m {
+		out = append(out, SkipListEntry{Member: member, Score: score})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score < out[j].Score
+		}
+		return out[i].Member < out[j].Member
+	})
+	return out
+}
+
+// checkSpans verifies that every level's spans add up to the node's rank
+func checkSpans(t *testing.T, sl *SkipList) {
+	t.Helper()
+	for i := 0; i < sl.level; i++ {
+		rank := 0
+		for x := sl.header; x.level[i].forward != nil; x = x.level[i].forward {
+			rank += x.level[i].span
+			if got := sl.Rank(x.level[i].forward.member, x.level[i].forward.score); got != rank-1 {
+				t.Fatalf("level %d: %s has rank %d by span, %d by Rank", i, x.level[i].forward.member, rank-1, got)
+			}
+		}
+	}
+}
+
+func TestSkipListMatchesSortedMap(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	sl := NewSkipList()
+	ref := make(map[string]float64)
+
+	for op := 0; op < 5000; op++ {
+		member := fmt.Sprintf("m%d", rng.Intn(300))
+		score := float64(rng.Intn(50)) // few distinct scores, so ties are common
+		old, exists := ref[member]
+		switch {
+		case !exists:
+			sl.Insert(member, score)
+			ref[member] = score
+		case rng.Intn(3) == 0:
+			if !sl.Delete(member, old) {
+				t.Fatalf("Delete(%s, %v) = false", member, old)
+			}
+			delete(ref, member)
+		default:
+			sl.UpdateScore(member, old, score)
+			ref[member] = score
+		}
+	}
+
+	want := sortedEntries(ref)
+	if sl.Len() != len(want) {
+		t.Fatalf("Len = %d, want %d", sl.Len(), len(want))
+	}
+	if got := sl.Range(0, sl.Len()-1); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Range(0, -1) differs from the sorted map")
+	}
+	for rank, e := range want {
+		if got := sl.Rank(e.Member, e.Score); got != rank {
+			t.Fatalf("Rank(%s) = %d, want %d", e.Member, got, rank)
+		}
+	}
+	checkSpans(t, sl)
+}
+
+func TestSkipListRange(t *testing.T) {
+	sl := NewSkipList()
+	for i, m := range []string{"a", "b", "c", "d", "e"} {
+		sl.Insert(m, float64(i))
+	}
+	tests := []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, 4, []string{"a", "b", "c", "d", "e"}},
+		{1, 3, []string{"b", "c", "d"}},
+		{3, 10, []string{"d", "e"}},
+		{4, 4, []string{"e"}},
+		{5, 6, nil},
+		{3, 2, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, e := range sl.Range(tt.start, tt.stop) {
+			got = append(got, e.Member)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Range(%d, %d) = %v, want %v", tt.start, tt.stop, got, tt.want)
+		}
+	}
+}
+
+func TestSkipListRangeByScore(t *testing.T) {
+	sl := NewSkipList()
+	sl.Insert("a", 1)
+	sl.Insert("b", 2)
+	sl.Insert("c", 2)
+	sl.Insert("d", 3.5)
+
+	got := sl.RangeByScore(2, 3.5)
+	want := []SkipListEntry{{"b", 2}, {"c", 2}, {"d", 3.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RangeByScore(2, 3.5) = %v, want %v", got, want)
+	}
+	if got := sl.RangeByScore(4, 10); got != nil {
+		t.Errorf("RangeByScore(4, 10) = %v, want none", got)
+	}
+}
+
+func TestSkipListMissingMember(t *testing.T) {
+	sl := NewSkipList()
+	sl.Insert("a", 1)
+	if r := sl.Rank("a", 2); r != -1 {
+		t.Errorf("Rank with the wrong score = %d, want -1", r)
+	}
+	if r := sl.Rank("b", 1); r != -1 {
+		t.Errorf("Rank of a missing member = %d, want -1", r)
+	}
+	if sl.Delete("b", 1) {
+		t.Error("Delete of a missing member = true")
+	}
+	if sl.Len() != 1 {
+		t.Errorf("Len = %d, want 1", sl.Len())
+	}
+}
+
+func TestSkipListFirstByScore(t *testing.T) {
+	sl := NewSkipList()
+	for i, m := range []string{"a", "b", "c", "d"} {
+		sl.Insert(m, float64(i*10))
+	}
+	tests := []struct {
+		min   float64
+		limit int
+		want  []string
+	}{
+		{0, 2, []string{"a", "b"}},
+		{5, 2, []string{"b", "c"}},
+		{10, 10, []string{"b", "c", "d"}},
+		{31, 1, nil},
+		{0, 0, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, e := range sl.FirstByScore(tt.min, tt.limit) {
+			got = append(got, e.Member)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FirstByScore(%v, %d) = %v, want %v", tt.min, tt.limit, got, tt.want)
+		}
+	}
+}
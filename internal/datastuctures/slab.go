@@ -0,0 +1,115 @@
+package datastuctures
+
+import (
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Skiplist nodes come from a slab owned by their skiplist instead of two
+// heap allocations each (node and level array). Nodes are carved out of
+// blocks that double in size up to slabMaxBlock, so a large sorted set is a
+// few hundred objects for the collector rather than a few million, and a
+// small one wastes little. Deleted nodes go on a free list per level count
+// and are reused by later inserts. The blocks die with the skiplist, all at
+// once, when its key is deleted, evicted or flushed.
+const (
+	slabMinBlock = 4
+	slabMaxBlock = 256
+)
+
+// SlabStats describes the memory held by skiplist slabs process-wide
+type SlabStats struct {
+	Blocks    int64 // node and level blocks allocated
+	Bytes     int64 // bytes those blocks reserve
+	Nodes     int64 // nodes holding a member
+	FreeNodes int64 // deleted nodes kept for reuse
+}
+
+var slabTotals struct {
+	blocks, bytes, nodes, free atomic.Int64
+}
+
+// Slabs reports SlabStats. Counts of dropped skiplists are taken off once
+// the garbage collector has reclaimed them.
+func Slabs() SlabStats {
+	return SlabStats{
+		Blocks:    slabTotals.blocks.Load(),
+		Bytes:     slabTotals.bytes.Load(),
+		Nodes:     slabTotals.nodes.Load(),
+		FreeNodes: slabTotals.free.Load(),
+	}
+}
+
+type skipListSlab struct {
+	nodes  []skipListNode  // unused rest of the current node block
+	levels []skipListLevel // unused rest of the current level block
+	free   [skipListMaxLevel + 1][]*skipListNode
+
+	next                       int // size of the next node block
+	blocks, bytes, live, freed int64
+}
+
+func newSkipListSlab(owner *SkipList) *skipListSlab {
+	s := &skipListSlab{next: slabMinBlock}
+	runtime.AddCleanup(owner, (*skipListSlab).drop, s)
+	return s
+}
+
+// node returns a node with level levels, reusing a deleted one if possible
+func (s *skipListSlab) node(level int, member string, score float64) *skipListNode {
+	var n *skipListNode
+	if f := s.free[level]; len(f) > 0 {
+		n = f[len(f)-1]
+		f[len(f)-1] = nil
+		s.free[level] = f[:len(f)-1]
+		s.freed--
+		slabTotals.free.Add(-1)
+	} else {
+		if len(s.nodes) == 0 {
+			s.nodes = make([]skipListNode, s.next)
+			s.grew(int64(s.next) * int64(unsafe.Sizeof(skipListNode{})))
+			s.next = min(2*s.next, slabMaxBlock)
+		}
+		n = &s.nodes[0]
+		s.nodes = s.nodes[1:]
+		if len(s.levels) < level {
+			// a node has 4/3 levels on average
+			size := max(level, s.next+s.next/2)
+			s.levels = make([]skipListLevel, size)
+			s.grew(int64(size) * int64(unsafe.Sizeof(skipListLevel{})))
+		}
+		n.level = s.levels[:level:level]
+		s.levels = s.levels[level:]
+	}
+	n.member, n.score = member, score
+	s.live++
+	slabTotals.nodes.Add(1)
+	return n
+}
+
+// release takes back a node unlinked from the skiplist
+func (s *skipListSlab) release(n *skipListNode) {
+	n.member, n.backward = "", nil
+	clear(n.level)
+	s.free[len(n.level)] = append(s.free[len(n.level)], n)
+	s.live--
+	s.freed++
+	slabTotals.nodes.Add(-1)
+	slabTotals.free.Add(1)
+}
+
+func (s *skipListSlab) grew(bytes int64) {
+	s.blocks++
+	s.bytes += bytes
+	slabTotals.blocks.Add(1)
+	slabTotals.bytes.Add(bytes)
+}
+
+// drop takes a collected skiplist's slab off the totals
+func (s *skipListSlab) drop() {
+	slabTotals.blocks.Add(-s.blocks)
+	slabTotals.bytes.Add(-s.bytes)
+	slabTotals.nodes.Add(-s.live)
+	slabTotals.free.Add(-s.freed)
+}
@@ -0,0 +1,73 @@
+package datastuctures
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSlabReusesDeletedNodes(t *testing.T) {
+	sl := NewSkipList()
+	want := make(map[string]float64)
+	for i := 0; i < 1000; i++ {
+		m := fmt.Sprintf("m%04d", i)
+		sl.Insert(m, float64(i%37))
+		want[m] = float64(i % 37)
+	}
+	s := sl.slab
+	if s.live != 1000 || s.freed != 0 {
+		t.Fatalf("after 1000 inserts: %d live, %d free", s.live, s.freed)
+	}
+	blocks, bytes := s.blocks, s.bytes
+
+	for i := 0; i < 1000; i += 2 {
+		m := fmt.Sprintf("m%04d", i)
+		sl.Delete(m, want[m])
+		delete(want, m)
+	}
+	if s.live != 500 || s.freed != 500 {
+		t.Errorf("after 500 deletes: %d live, %d free", s.live, s.freed)
+	}
+
+	// reinserting takes nodes off the free lists where their level count
+	// allows, and the levels of reused nodes must not overlap live ones
+	for i := 0; i < 1000; i += 2 {
+		m := fmt.Sprintf("n%04d", i)
+		sl.Insert(m, float64(i%11))
+		want[m] = float64(i % 11)
+	}
+	if s.live != 1000 || s.freed >= 500 {
+		t.Errorf("after reinserting: %d live, %d free", s.live, s.freed)
+	}
+	if s.bytes-bytes > bytes/2 {
+		t.Errorf("reinserting grew the slab from %d to %d bytes in %d more blocks", bytes, s.bytes, s.blocks-blocks)
+	}
+	if got := sl.Range(0, sl.Len()-1); !reflect.DeepEqual(got, sortedEntries(want)) {
+		t.Errorf("skip list order broke after reusing nodes")
+	}
+	checkSpans(t, sl)
+}
+
+func TestSlabStatsDropWithSkipList(t *testing.T) {
+	sl := NewSkipList()
+	for i := 0; i < 5000; i++ {
+		sl.Insert(fmt.Sprint(i), float64(i))
+	}
+	held := sl.slab.bytes
+	before := Slabs()
+	if before.Bytes < held || before.Nodes < 5000 {
+		t.Fatalf("Slabs() = %+v with a list of %d bytes and 5000 nodes", before, held)
+	}
+
+	sl = nil
+	deadline := time.Now().Add(5 * time.Second)
+	for Slabs().Bytes > before.Bytes-held {
+		if time.Now().After(deadline) {
+			t.Fatalf("Slabs() = %+v long after the list was dropped", Slabs())
+		}
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,174 @@
+package net
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Accepted connections wait in a bounded queue until a single starter
+// goroutine hands them to handleConn, at no more than accept-rate-limit a
+// second. A storm of new connections therefore costs a queue slot each,
+// not a goroutine, and connections beyond the queue or over a client IP's
+// max-clients-per-ip are refused at once. Protection against SYN floods
+// proper stays with the kernel (somaxconn, tcp_syncookies): it happens
+// before Accept returns.
+type acceptGate struct {
+	queue chan net.Conn
+
+	rate  atomic.Int64 // connections started per second, 0 = unlimited
+	perIP atomic.Int64 // connections per client IP, 0 = unlimited
+
+	mu   sync.Mutex
+	byIP map[string]int // queued and running connections by client IP
+
+	rejected atomic.Uint64 // refused for a full queue or the per-IP cap
+}
+
+const (
+	acceptRetryMin = 5 * time.Millisecond
+	acceptRetryMax = time.Second
+)
+
+func newAcceptGate(queue int) acceptGate {
+	return acceptGate{queue: make(chan net.Conn, max(queue, 1)), byIP: make(map[string]int)}
+}
+
+// acceptLoop accepts connections and queues them for startLoop. Failed
+// accepts, such as running out of file descriptors, are retried after a
+// growing pause rather than in a tight loop.
+func (s *Server) acceptLoop() {
+	var retry time.Duration
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			select {
+			case <-s.stopCh:
+				// Server is shutting down
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			retry = min(max(2*retry, acceptRetryMin), acceptRetryMax)
+			log.Printf("failed to accept connection: %v; retrying in %v", err, retry)
+			time.Sleep(retry)
+			continue
+		}
+		retry = 0
+
+		ip := remoteIP(conn)
+		if !s.accept.admit(ip) {
+			s.refuse(conn, "ERR max number of clients per IP reached")
+			continue
+		}
+		select {
+		case s.accept.queue <- conn:
+		default:
+			s.accept.release(ip)
+			s.refuse(conn, "ERR max number of clients reached")
+		}
+	}
+}
+
+// startLoop starts queued connections, pacing them to accept-rate-limit.
+// A second's worth of connections may start at once after a quiet spell.
+func (s *Server) startLoop() {
+	var next time.Time // when the next connection may start
+	for {
+		var conn net.Conn
+		select {
+		case <-s.stopCh:
+			s.drainAcceptQueue()
+			return
+		case conn = <-s.accept.queue:
+		}
+
+		if rate := s.accept.rate.Load(); rate > 0 {
+			now := time.Now()
+			if floor := now.Add(-time.Second); next.Before(floor) {
+				next = floor
+			}
+			next = next.Add(time.Second / time.Duration(rate))
+			if wait := next.Sub(now); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-s.stopCh:
+					t.Stop()
+					conn.Close()
+					s.drainAcceptQueue()
+					return
+				case <-t.C:
+				}
+			}
+		}
+
+		if tc, ok := conn.(*net.TCPConn); ok {
+			s.setKeepAlive(tc)
+		}
+		s.register(conn)
+
+		s.wg.Add(1)
+		go func(ip string) {
+			s.handleConn(conn)
+			s.accept.release(ip)
+		}(remoteIP(conn))
+	}
+}
+
+// drainAcceptQueue closes connections still queued at shutdown
+func (s *Server) drainAcceptQueue() {
+	for {
+		select {
+		case conn := <-s.accept.queue:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
+
+// refuse replies msg to a connection that will not be served and closes it
+func (s *Server) refuse(conn net.Conn, msg string) {
+	s.accept.rejected.Add(1)
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	conn.Write([]byte("-" + msg + "\r\n"))
+	conn.Close()
+}
+
+// admit counts a connection from ip, unless ip is at max-clients-per-ip
+func (g *acceptGate) admit(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if limit := g.perIP.Load(); limit > 0 && int64(g.byIP[ip]) >= limit {
+		return false
+	}
+	g.byIP[ip]++
+	return true
+}
+
+// release uncounts a connection admitted from ip
+func (g *acceptGate) release(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.byIP[ip] <= 1 {
+		delete(g.byIP, ip)
+		return
+	}
+	g.byIP[ip]--
+}
+
+func remoteIP(c net.Conn) string {
+	if a, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+	return host
+}
@@ -0,0 +1,525 @@
+package net
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// defaultUser is the user connections start as. While it is on with nopass,
+// new connections are authenticated without AUTH.
+const defaultUser = "default"
+
+// aclUser is one ACL user. Users are never modified in place: ACL SETUSER
+// builds a new one, so a user loaded from aclState can be read unlocked.
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords map[string]struct{} // SHA-256, hex
+	keys      []string            // key patterns, "*" for every key
+	rules     []string            // command rules in the order given, e.g. +@all -debug
+	allowed   map[string]bool     // upper-case command names the rules allow
+	quotas    quotaLimits
+}
+
+// aclState holds the users and their quota usage, by name
+type aclState struct {
+	mu    sync.RWMutex
+	users map[string]*aclUser
+	usage map[string]*quotaUsage
+}
+
+// aclExempt commands run regardless of authentication and command rules,
+// so any connection can authenticate or leave
+var aclExempt = map[string]bool{
+	"AUTH":  true,
+	"HELLO": true,
+	"QUIT":  true,
+}
+
+// aclDangerous are the non-admin commands in @dangerous
+var aclDangerous = map[string]bool{"FLUSHALL": true, "FLUSHDB": true, "SWAPDB": true}
+
+// aclCategories are the command categories +@ and -@ rules name
+var aclCategories = map[string]func(cmd *command) bool{
+	"all":         func(cmd *command) bool { return true },
+	"read":        func(cmd *command) bool { return cmd.has(flagReadOnly) },
+	"write":       func(cmd *command) bool { return cmd.has(flagWrite) },
+	"fast":        func(cmd *command) bool { return cmd.has(flagFast) },
+	"slow":        func(cmd *command) bool { return !cmd.has(flagFast) },
+	"admin":       func(cmd *command) bool { return cmd.has(flagAdmin) },
+	"dangerous":   func(cmd *command) bool { return cmd.has(flagAdmin) || aclDangerous[cmd.name] },
+	"pubsub":      func(cmd *command) bool { return cmd.has(flagPubSub) },
+	"blocking":    func(cmd *command) bool { return cmd.has(flagBlocking) },
+	"keyspace":    func(cmd *command) bool { return cmd.firstKey > 0 },
+	"connection":  func(cmd *command) bool { return aclExempt[cmd.name] || cmd.name == "PING" || cmd.name == "CLIENT" },
+	"transaction": func(cmd *command) bool { return txExempt[cmd.name] && cmd.name != "QUIT" },
+}
+
+func newUser(name string) *aclUser {
+	u := &aclUser{name: name, passwords: make(map[string]struct{})}
+	u.setRules([]string{"-@all"})
+	return u
+}
+
+// clone copies u for ACL SETUSER to modify
+func (u *aclUser) clone() *aclUser {
+	n := *u
+	n.passwords = make(map[string]struct{}, len(u.passwords))
+	for h := range u.passwords {
+		n.passwords[h] = struct{}{}
+	}
+	n.keys = append([]string(nil), u.keys...)
+	n.rules = append([]string(nil), u.rules...)
+	return &n
+}
+
+// setRules replaces the command rules and recomputes what they allow
+func (u *aclUser) setRules(rules []string) {
+	u.rules = rules
+	u.allowed = make(map[string]bool)
+	for _, r := range rules {
+		allow := r[0] == '+'
+		if cat, ok := strings.CutPrefix(r[1:], "@"); ok {
+			in := aclCategories[cat]
+			for name, cmd := range commandTable {
+				if in(cmd) {
+					u.allowed[name] = allow
+				}
+			}
+			continue
+		}
+		u.allowed[strings.ToUpper(r[1:])] = allow
+	}
+}
+
+// apply applies one ACL SETUSER rule
+func (u *aclUser) apply(rule string) error {
+	switch lower := strings.ToLower(rule); {
+	case lower == "on":
+		u.enabled = true
+	case lower == "off":
+		u.enabled = false
+	case lower == "nopass":
+		u.nopass = true
+		u.passwords = make(map[string]struct{})
+	case lower == "resetpass":
+		u.nopass = false
+		u.passwords = make(map[string]struct{})
+	case lower == "allkeys":
+		u.keys = []string{"*"}
+	case lower == "resetkeys":
+		u.keys = nil
+	case lower == "allcommands":
+		u.setRules([]string{"+@all"})
+	case lower == "nocommands":
+		u.setRules([]string{"-@all"})
+	case lower == "reset":
+		*u = *newUser(u.name)
+	case rule[0] == '>':
+		u.passwords[hashPassword(rule[1:])] = struct{}{}
+		u.nopass = false
+	case rule[0] == '<':
+		h := hashPassword(rule[1:])
+		if _, ok := u.passwords[h]; !ok {
+			return fmt.Errorf("no such password")
+		}
+		delete(u.passwords, h)
+	case rule[0] == '#' || rule[0] == '!':
+		h := strings.ToLower(rule[1:])
+		if b, err := hex.DecodeString(h); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("the password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		if rule[0] == '#' {
+			u.passwords[h] = struct{}{}
+			u.nopass = false
+		} else if _, ok := u.passwords[h]; !ok {
+			return fmt.Errorf("no such password")
+		} else {
+			delete(u.passwords, h)
+		}
+	case rule[0] == '~':
+		if !u.allKeys() {
+			u.keys = append(u.keys, rule[1:])
+		}
+	case rule[0] == '+' || rule[0] == '-':
+		if cat, ok := strings.CutPrefix(lower[1:], "@"); ok {
+			if _, ok := aclCategories[cat]; !ok {
+				return fmt.Errorf("unknown command category '%s'", cat)
+			}
+			if cat == "all" {
+				u.setRules([]string{lower})
+				return nil
+			}
+		} else if _, ok := lookupCommand(lower[1:]); !ok {
+			return fmt.Errorf("unknown command '%s'", lower[1:])
+		}
+		u.setRules(append(u.rules, lower))
+	case isQuotaRule(lower):
+		return u.quotas.apply(lower)
+	default:
+		return fmt.Errorf("syntax error")
+	}
+	return nil
+}
+
+func (u *aclUser) allKeys() bool {
+	return len(u.keys) == 1 && u.keys[0] == "*"
+}
+
+// canRun reports whether the rules allow cmd
+func (u *aclUser) canRun(cmd *command) bool {
+	return u.allowed[cmd.name]
+}
+
+// canAccess reports whether key matches one of the user's key patterns
+func (u *aclUser) canAccess(key string) bool {
+	for _, p := range u.keys {
+		if store.GlobMatch(p, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword reports whether pass opens u
+func (u *aclUser) checkPassword(pass string) bool {
+	if !u.enabled {
+		return false
+	}
+	if u.nopass {
+		return true
+	}
+	h := hashPassword(pass)
+	for stored := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(h)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// flags are the user's ACL GETUSER flags
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *aclUser) hashes() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for h := range u.passwords {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+func (u *aclUser) keyRules() string {
+	keys := make([]string, len(u.keys))
+	for i, p := range u.keys {
+		keys[i] = "~" + p
+	}
+	return strings.Join(keys, " ")
+}
+
+// describe renders u as an ACL LIST line
+func (u *aclUser) describe() string {
+	parts := append([]string{"user", u.name}, u.flags()...)
+	for _, h := range u.hashes() {
+		parts = append(parts, "#"+h)
+	}
+	if k := u.keyRules(); k != "" {
+		parts = append(parts, k)
+	}
+	parts = append(parts, u.rules...)
+	parts = append(parts, u.quotas.rules()...)
+	return strings.Join(parts, " ")
+}
+
+func hashPassword(pass string) string {
+	sum := sha256.Sum256([]byte(pass))
+	return hex.EncodeToString(sum[:])
+}
+
+// user returns the named user, or nil
+func (s *Server) user(name string) *aclUser {
+	s.acl.mu.RLock()
+	defer s.acl.mu.RUnlock()
+	return s.acl.users[name]
+}
+
+// setDefaultPassword applies requirepass: the default user keeps its rules
+// and gets pass as its only password, or nopass when pass is empty
+func (s *Server) setDefaultPassword(pass string) {
+	s.acl.mu.Lock()
+	defer s.acl.mu.Unlock()
+	u, ok := s.acl.users[defaultUser]
+	if !ok {
+		u = newUser(defaultUser)
+		u.enabled = true
+		u.keys = []string{"*"}
+		u.setRules([]string{"+@all"})
+	} else {
+		u = u.clone()
+	}
+	u.apply("resetpass")
+	if pass == "" {
+		u.apply("nopass")
+	} else {
+		u.apply(">" + pass)
+	}
+	s.acl.users[defaultUser] = u
+}
+
+// initialUser is the user a new connection is authenticated as: default
+// while it needs no password, otherwise none until AUTH
+func (s *Server) initialUser() string {
+	if u := s.user(defaultUser); u != nil && u.enabled && u.nopass {
+		return defaultUser
+	}
+	return ""
+}
+
+// authenticate logs cl in as name if pass opens it
+func (s *Server) authenticate(cl *client, name, pass string) bool {
+	u := s.user(name)
+	if u == nil || !u.checkPassword(pass) {
+		return false
+	}
+	cl.user.Store(name)
+	return true
+}
+
+// checkACL returns the error a command must be rejected with, or "" when
+// the connection's user may run it against its keys
+func (s *Server) checkACL(cl *client, cmd *command, args []string) string {
+	if aclExempt[cmd.name] {
+		return ""
+	}
+	name := cl.user.Load().(string)
+	if name == "" {
+		return "NOAUTH Authentication required."
+	}
+	u := s.user(name)
+	if u == nil || !u.canRun(cmd) {
+		return fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", name, strings.ToLower(cmd.name))
+	}
+	if u.allKeys() {
+		return ""
+	}
+	for _, key := range cmd.keys(args) {
+		if !u.canAccess(key) {
+			return "NOPERM No permissions to access a key"
+		}
+	}
+	return ""
+}
+
+// AUTH [username] password
+func (s *Server) handleAuth(c net.Conn, args []string) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	if len(args) > 3 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	name, pass := defaultUser, args[1]
+	if len(args) == 3 {
+		name, pass = args[1], args[2]
+	} else if u := s.user(defaultUser); u != nil && u.nopass {
+		s.reply(c, protocol.Error("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"))
+		return
+	}
+	if !s.authenticate(cl, name, pass) {
+		s.reply(c, protocol.Error("WRONGPASS invalid username-password pair or user is disabled."))
+		return
+	}
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// aclArity bounds the arguments of each ACL subcommand, counting ACL and
+// the subcommand; a maximum of -1 means no limit
+var aclArity = map[string][2]int{
+	"SETUSER": {3, -1}, "GETUSER": {3, 3}, "DELUSER": {3, -1},
+	"LIST": {2, 2}, "USERS": {2, 2}, "WHOAMI": {2, 2}, "CAT": {2, 3},
+}
+
+// ACL SETUSER name [rule ...] | GETUSER name | DELUSER name [name ...] |
+// LIST | USERS | WHOAMI | CAT [category]
+func (s *Server) handleACL(c net.Conn, args []string) {
+	sub := args[1]
+	name := strings.ToUpper(sub)
+	if bounds, ok := aclArity[name]; ok && (len(args) < bounds[0] || bounds[1] > 0 && len(args) > bounds[1]) {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'acl|"+strings.ToLower(name)+"' command"))
+		return
+	}
+
+	switch name {
+	case "SETUSER":
+		s.aclSetUser(c, args[2:])
+
+	case "GETUSER":
+		n := args[2]
+		u := s.user(n)
+		if u == nil {
+			s.reply(c, protocol.Array(nil))
+			return
+		}
+		flags := protocol.Array{}
+		for _, f := range u.flags() {
+			flags = append(flags, protocol.BulkString(f))
+		}
+		hashes := protocol.Array{}
+		for _, h := range u.hashes() {
+			hashes = append(hashes, protocol.BulkString(h))
+		}
+		s.reply(c, protocol.Map{
+			protocol.BulkString("flags"), flags,
+			protocol.BulkString("passwords"), hashes,
+			protocol.BulkString("commands"), protocol.BulkString(strings.Join(u.rules, " ")),
+			protocol.BulkString("keys"), protocol.BulkString(u.keyRules()),
+			protocol.BulkString("quotas"), protocol.BulkString(strings.Join(u.quotas.rules(), " ")),
+			protocol.BulkString("usage"), s.quotaUsage(u.name).usage(u.quotas, time.Now()),
+		})
+
+	case "DELUSER":
+		s.aclDelUser(c, args[2:])
+
+	case "LIST", "USERS":
+		s.acl.mu.RLock()
+		users := make([]*aclUser, 0, len(s.acl.users))
+		for _, u := range s.acl.users {
+			users = append(users, u)
+		}
+		s.acl.mu.RUnlock()
+		sort.Slice(users, func(i, j int) bool { return users[i].name < users[j].name })
+		out := make(protocol.Array, len(users))
+		for i, u := range users {
+			if name == "LIST" {
+				out[i] = protocol.BulkString(u.describe())
+			} else {
+				out[i] = protocol.BulkString(u.name)
+			}
+		}
+		s.reply(c, out)
+
+	case "WHOAMI":
+		cl := s.client(c)
+		if cl == nil {
+			return
+		}
+		s.reply(c, protocol.BulkString(cl.user.Load().(string)))
+
+	case "CAT":
+		var out []string
+		if len(args) == 2 {
+			for cat := range aclCategories {
+				out = append(out, cat)
+			}
+		} else {
+			cat := args[2]
+			in, ok := aclCategories[strings.ToLower(cat)]
+			if !ok {
+				s.reply(c, protocol.Error("ERR Unknown category '"+cat+"'"))
+				return
+			}
+			for _, cmd := range commandTable {
+				if in(cmd) {
+					out = append(out, strings.ToLower(cmd.name))
+				}
+			}
+		}
+		sort.Strings(out)
+		arr := make(protocol.Array, len(out))
+		for i, v := range out {
+			arr[i] = protocol.BulkString(v)
+		}
+		s.reply(c, arr)
+
+	default:
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+sub+"'. Try ACL SETUSER, GETUSER, DELUSER, LIST, USERS, WHOAMI or CAT."))
+	}
+}
+
+// aclSetUser creates or changes a user. Rules apply in order to a copy, so
+// an invalid rule leaves the user as it was.
+func (s *Server) aclSetUser(c net.Conn, args []string) {
+	n := args[0]
+	name := n
+	if name == "" || strings.ContainsAny(name, " \n") {
+		s.reply(c, protocol.Error("ERR Usernames can't contain spaces or newlines"))
+		return
+	}
+	s.acl.mu.Lock()
+	defer s.acl.mu.Unlock()
+	u := newUser(name)
+	if old, ok := s.acl.users[name]; ok {
+		u = old.clone()
+	}
+	for _, a := range args[1:] {
+		rule := []byte(a)
+		if len(rule) == 0 {
+			s.reply(c, protocol.Error("ERR Error in ACL SETUSER modifier '': syntax error"))
+			return
+		}
+		if err := u.apply(string(rule)); err != nil {
+			s.reply(c, protocol.Error(fmt.Sprintf("ERR Error in ACL SETUSER modifier '%s': %v", rule, err)))
+			return
+		}
+	}
+	s.acl.users[name] = u
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// aclDelUser removes users and closes the connections authenticated as them
+func (s *Server) aclDelUser(c net.Conn, args []string) {
+	names := make(map[string]bool, len(args))
+	for _, n := range args {
+		if n == defaultUser {
+			s.reply(c, protocol.Error("ERR The 'default' user cannot be removed"))
+			return
+		}
+		names[n] = true
+	}
+	s.acl.mu.Lock()
+	deleted := 0
+	for name := range names {
+		if _, ok := s.acl.users[name]; ok {
+			delete(s.acl.users, name)
+			delete(s.acl.usage, name)
+			deleted++
+		}
+	}
+	s.acl.mu.Unlock()
+
+	var conns []net.Conn
+	s.mu.Lock()
+	for conn, cl := range s.conns {
+		if names[cl.user.Load().(string)] {
+			conns = append(conns, conn)
+		}
+	}
+	s.mu.Unlock()
+	s.reply(c, protocol.Integer(deleted))
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
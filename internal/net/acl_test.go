@@ -0,0 +1,96 @@
+package net
+
+import (
+	"testing"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+func TestACLKeyPatterns(t *testing.T) {
+	u := newUser("alice")
+	for _, rule := range []string{"on", "~cache:*", "~user:?", "~lit\\*"} {
+		if err := u.apply(rule); err != nil {
+			t.Fatalf("apply(%q): %v", rule, err)
+		}
+	}
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"cache:", true},
+		{"cache:a:b", true},
+		{"user:1", true},
+		{"user:12", false},
+		{"lit*", true},
+		{"litx", false},
+		{"other", false},
+	}
+	for _, tt := range tests {
+		if got := u.canAccess(tt.key); got != tt.want {
+			t.Errorf("canAccess(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+	if u.allKeys() {
+		t.Error("allKeys with key patterns")
+	}
+
+	// allkeys replaces the patterns, and later patterns do not narrow it
+	u.apply("allkeys")
+	u.apply("~cache:*")
+	if !u.allKeys() || !u.canAccess("anything") {
+		t.Errorf("allkeys then ~cache:* gave keys %v", u.keys)
+	}
+	u.apply("resetkeys")
+	if u.canAccess("cache:a") {
+		t.Error("resetkeys left a pattern")
+	}
+}
+
+func TestACLCommandRules(t *testing.T) {
+	u := newUser("bob")
+	for _, rule := range []string{"+@read", "-get", "+set"} {
+		if err := u.apply(rule); err != nil {
+			t.Fatalf("apply(%q): %v", rule, err)
+		}
+	}
+	for name, want := range map[string]bool{"GET": false, "STRLEN": true, "SET": true, "DEL": false} {
+		cmd, _ := lookupCommand(name)
+		if got := u.canRun(cmd); got != want {
+			t.Errorf("canRun(%s) = %v, want %v", name, got, want)
+		}
+	}
+	if err := u.apply("+nosuchcommand"); err == nil {
+		t.Error("a rule for an unknown command was accepted")
+	}
+	if err := u.apply("+@nosuchcategory"); err == nil {
+		t.Error("a rule for an unknown category was accepted")
+	}
+}
+
+func TestACLEnforcement(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.dial(t)
+	admin.expect(protocol.SimpleString("OK"), "ACL", "SETUSER", "alice", "on", ">secret", "~cache:*", "+@all", "-flushall")
+
+	c := s.dial(t)
+	c.expect(protocol.Error("WRONGPASS invalid username-password pair or user is disabled."), "AUTH", "alice", "wrong")
+	c.expect(protocol.SimpleString("OK"), "AUTH", "alice", "secret")
+	c.expect(protocol.BulkString("alice"), "ACL", "WHOAMI")
+
+	c.expect(protocol.SimpleString("OK"), "SET", "cache:1", "v")
+	c.expect(protocol.Error("NOPERM No permissions to access a key"), "SET", "other", "v")
+	// every key argument is checked, not just the first
+	c.expect(protocol.Error("NOPERM No permissions to access a key"), "MGET", "cache:1", "other")
+	c.expect(protocol.Error("NOPERM User alice has no permissions to run the 'flushall' command"), "FLUSHALL")
+
+	// a password for the default user closes new connections until AUTH
+	admin.expect(protocol.SimpleString("OK"), "ACL", "SETUSER", "default", "resetpass", ">pw")
+	anon := s.dial(t)
+	anon.expect(protocol.Error("NOAUTH Authentication required."), "GET", "cache:1")
+	anon.expect(protocol.SimpleString("OK"), "AUTH", "pw")
+	anon.expect(protocol.BulkString("v"), "GET", "cache:1")
+
+	// until then only short commands are read
+	long := s.dial(t)
+	long.expect(protocol.Error("ERR Protocol error: invalid multibulk length"), "MSET", "a", "1", "b", "2", "c", "3", "d", "4", "e", "5")
+}
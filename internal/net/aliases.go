@@ -0,0 +1,43 @@
+package net
+
+import (
+	"log"
+	"strings"
+)
+
+// Command aliases, from command-aliases, are extra names for commands in
+// the table, for fronting clients that send odd command names. dispatch
+// swaps an alias for the name it stands for before anything else looks at
+// the command, so ACLs, MULTI, replication and handlers only ever see the
+// real name. An alias cannot hide a command of the table.
+
+// setAliases resolves the configured aliases. Entries naming an unknown
+// command or reusing a command's name are logged and left out.
+func (s *Server) setAliases(aliases map[string]string) {
+	m := make(map[string]*command, len(aliases))
+	for alias, name := range aliases {
+		if _, taken := lookupCommand(alias); taken {
+			log.Printf("command-aliases: %s is already a command, ignoring alias", alias)
+			continue
+		}
+		cmd, ok := lookupCommand(name)
+		if !ok {
+			log.Printf("command-aliases: %s names unknown command %s, ignoring alias", alias, name)
+			continue
+		}
+		m[alias] = cmd
+	}
+	s.aliases.Store(&m)
+}
+
+// lookupCommand finds name in the command table or among the aliases
+func (s *Server) lookupCommand(name string) (*command, bool) {
+	if cmd, ok := lookupCommand(name); ok {
+		return cmd, true
+	}
+	if m := s.aliases.Load(); m != nil {
+		cmd, ok := (*m)[strings.ToUpper(name)]
+		return cmd, ok
+	}
+	return nil, false
+}
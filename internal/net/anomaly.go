@@ -0,0 +1,194 @@
+package net
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Anomaly detection learns, per ACL user, how often each command runs and
+// how many keys it touches in an anomaly-window, as a moving average over
+// past windows. A window in which a user runs a command, or touches keys
+// with it, more than anomaly-factor times the average, and at least
+// anomaly-min-count times, is reported: in the event log and as a JSON
+// message on the __anomalies__ channel, e.g.
+//
+//	{"user":"app","command":"del","measure":"keys","observed":50000,"baseline":12.5,"window_ms":10000}
+//
+// Commands that sweep the whole keyspace count from a single call, so a
+// KEYS * from a user who never ran it is reported on its own.
+
+// anomalyChannel is where anomalies are published
+const anomalyChannel = "__anomalies__"
+
+// anomalyWarmup is how many windows of a user are learned before any is
+// judged
+const anomalyWarmup = 6
+
+// anomalyAlpha weighs the latest window in a user's moving averages
+const anomalyAlpha = 0.2
+
+// sweepingCommands touch every key at once; one call is enough to report
+var sweepingCommands = map[string]bool{
+	"KEYS":     true,
+	"FLUSHALL": true,
+	"FLUSHDB":  true,
+}
+
+type anomalyState struct {
+	mu    sync.Mutex
+	users map[string]*userProfile
+	reset chan struct{} // anomaly-window changed
+}
+
+// userProfile is one user's current window and learned averages, by
+// command name
+type userProfile struct {
+	windows  int
+	current  map[string]*commandUsage
+	baseline map[string]*commandBaseline
+}
+
+type commandUsage struct {
+	calls, keys uint64
+}
+
+type commandBaseline struct {
+	calls, keys float64
+}
+
+// anomaly is one report
+type anomaly struct {
+	User     string  `json:"user"`
+	Command  string  `json:"command"`
+	Measure  string  `json:"measure"` // calls or keys
+	Observed uint64  `json:"observed"`
+	Baseline float64 `json:"baseline"`
+	WindowMS int64   `json:"window_ms"`
+}
+
+func newAnomalyState() anomalyState {
+	return anomalyState{users: make(map[string]*userProfile), reset: make(chan struct{}, 1)}
+}
+
+// recordCommand counts a command toward its user's window, when anomaly
+// detection is on
+func (s *Server) recordCommand(cl *client, cmd *command, args []string) {
+	if s.anomalyWindow.Load() <= 0 {
+		return
+	}
+	user := cl.user.Load().(string)
+	if user == "" {
+		return // not logged in yet
+	}
+	a := &s.anomaly
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p := a.users[user]
+	if p == nil {
+		p = &userProfile{current: make(map[string]*commandUsage), baseline: make(map[string]*commandBaseline)}
+		a.users[user] = p
+	}
+	u := p.current[cmd.name]
+	if u == nil {
+		u = &commandUsage{}
+		p.current[cmd.name] = u
+	}
+	u.calls++
+	u.keys += uint64(cmd.keyCount(args))
+}
+
+// anomalyLoop closes a window every anomaly-window, waking early when the
+// window changes
+func (s *Server) anomalyLoop() {
+	for {
+		window := time.Duration(s.anomalyWindow.Load())
+		var tick <-chan time.Time
+		if window > 0 {
+			tick = time.After(window)
+		}
+		select {
+		case <-tick:
+			s.closeAnomalyWindow(window)
+		case <-s.anomaly.reset:
+			// averages over windows of another length mean nothing now
+			s.anomaly.mu.Lock()
+			s.anomaly.users = make(map[string]*userProfile)
+			s.anomaly.mu.Unlock()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// closeAnomalyWindow judges every user's window against their averages,
+// then folds it into them
+func (s *Server) closeAnomalyWindow(window time.Duration) {
+	c := s.cfg.Snapshot()
+	factor, minCount := float64(c.AnomalyFactor), uint64(max(c.AnomalyMinCount, 1))
+
+	var found []anomaly
+	a := &s.anomaly
+	a.mu.Lock()
+	for user, p := range a.users {
+		judged := p.windows >= anomalyWarmup
+		for name := range p.current {
+			if p.baseline[name] == nil {
+				p.baseline[name] = &commandBaseline{}
+			}
+		}
+		for name, b := range p.baseline {
+			var u commandUsage
+			if cur := p.current[name]; cur != nil {
+				u = *cur
+			}
+			if judged {
+				least := minCount
+				if sweepingCommands[name] {
+					least = 1
+				}
+				for _, m := range []struct {
+					measure  string
+					observed uint64
+					baseline float64
+				}{{"calls", u.calls, b.calls}, {"keys", u.keys, b.keys}} {
+					if m.measure == "keys" && u.keys == u.calls {
+						continue // one key a call: calls says it all
+					}
+					if m.observed >= least && float64(m.observed) > factor*m.baseline {
+						found = append(found, anomaly{User: user, Command: strings.ToLower(name), Measure: m.measure,
+							Observed: m.observed, Baseline: m.baseline, WindowMS: window.Milliseconds()})
+					}
+				}
+			}
+			b.calls += anomalyAlpha * (float64(u.calls) - b.calls)
+			b.keys += anomalyAlpha * (float64(u.keys) - b.keys)
+			if b.calls < 0.01 && u.calls == 0 {
+				delete(p.baseline, name) // long unused
+			}
+		}
+		p.current = make(map[string]*commandUsage, len(p.current))
+		p.windows++
+	}
+	a.mu.Unlock()
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].User != found[j].User {
+			return found[i].User < found[j].User
+		}
+		return found[i].Command < found[j].Command
+	})
+	for _, an := range found {
+		s.event(eventAnomaly, "user %s: %s %s %d in %v against a baseline of %.1f",
+			an.User, an.Command, an.Measure, an.Observed, window, an.Baseline)
+		b, err := json.Marshal(an)
+		if err != nil {
+			log.Printf("ERROR: Cannot encode anomaly: %v", err)
+			continue
+		}
+		s.pubsub.Publish(anomalyChannel, string(b))
+	}
+}
@@ -0,0 +1,88 @@
+package net
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// s3Config returns the S3 settings for backup locations, with credentials
+// from the environment
+func (s *Server) s3Config() store.S3Config {
+	c := s.cfg.Snapshot()
+	return store.S3ConfigFromEnv(c.BackupS3Endpoint, c.BackupS3Region)
+}
+
+// BACKUP TO <dir | s3://bucket/prefix> [INCREMENTAL] | COMPACT <location>
+func (s *Server) handleBackup(c net.Conn, args []string) {
+	sub := strings.ToUpper(args[1])
+	incremental := len(args) == 4 && strings.EqualFold(args[3], "INCREMENTAL")
+	if !(sub == "TO" && (len(args) == 3 || incremental)) && !(sub == "COMPACT" && len(args) == 3) {
+		s.reply(c, protocol.Error("ERR syntax error, expected BACKUP TO <dir | s3://bucket/prefix> [INCREMENTAL] or BACKUP COMPACT <location>"))
+		return
+	}
+	t, err := store.OpenBackupTarget(args[2], s.s3Config())
+	if err != nil {
+		s.reply(c, protocol.Error("ERR "+err.Error()))
+		return
+	}
+
+	start := time.Now()
+	var m store.BackupManifest
+	kind := "full"
+	switch {
+	case sub == "COMPACT":
+		kind = "compact"
+		m, err = s.shards.CompactBackup(t)
+	case incremental:
+		kind = "incremental"
+		m, err = s.shards.BackupIncremental(t)
+	default:
+		m, err = s.shards.Backup(t)
+	}
+	if errors.Is(err, store.ErrBackupChain) {
+		s.reply(c, protocol.Error("ERR "+err.Error()))
+		return
+	}
+	if err != nil {
+		s.reply(c, protocol.Error("ERR backup failed: "+err.Error()))
+		return
+	}
+
+	// an increment reports what it wrote, anything else the full backup
+	keys, deleted, shards := m.Keys, 0, m.Shards
+	if incremental {
+		inc := m.Increments[len(m.Increments)-1]
+		keys, deleted, shards = inc.Keys, inc.Deleted, inc.Shards
+		if n := s.cfg.Snapshot().BackupCompactAfter; n > 0 && len(m.Increments) >= n {
+			go s.compactBackup(t)
+		}
+	}
+	var bytes int64
+	for _, sh := range shards {
+		bytes += sh.Bytes
+	}
+	s.reply(c, protocol.Map{
+		protocol.BulkString("location"), protocol.BulkString(t.String()),
+		protocol.BulkString("type"), protocol.BulkString(kind),
+		protocol.BulkString("keys"), protocol.Integer(keys),
+		protocol.BulkString("deleted"), protocol.Integer(deleted),
+		protocol.BulkString("shards"), protocol.Integer(len(shards)),
+		protocol.BulkString("bytes"), protocol.Integer(bytes),
+		protocol.BulkString("increments"), protocol.Integer(len(m.Increments)),
+		protocol.BulkString("duration_ms"), protocol.Integer(time.Since(start).Milliseconds()),
+	})
+}
+
+// compactBackup folds the increments at t into its full backup, once
+// backup-compact-after of them have piled up
+func (s *Server) compactBackup(t store.BackupTarget) {
+	if _, err := s.shards.CompactBackup(t); err != nil {
+		log.Printf("Compacting backup at %s failed: %v", t, err)
+	}
+}
@@ -0,0 +1,115 @@
+package net
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// SETBIT key offset value
+func (s *Server) handleSetBit(c net.Conn, args []string) {
+	offset, err := strconv.Atoi(args[2])
+	if err != nil || offset < 0 || offset >= store.MaxBitOffset {
+		s.reply(c, protocol.Error("ERR bit offset is not an integer or out of range"))
+		return
+	}
+	if args[3] != "0" && args[3] != "1" {
+		s.reply(c, protocol.Error("ERR bit is not an integer or out of range"))
+		return
+	}
+	s.writeBitReply(c, s.execute(c, "SETBIT", args[1], args[2], args[3]))
+}
+
+// GETBIT key offset
+func (s *Server) handleGetBit(c net.Conn, args []string) {
+	offset, err := strconv.Atoi(args[2])
+	if err != nil || offset < 0 || offset >= store.MaxBitOffset {
+		s.reply(c, protocol.Error("ERR bit offset is not an integer or out of range"))
+		return
+	}
+	s.writeBitReply(c, s.execute(c, "GETBIT", args[1], args[2]))
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func (s *Server) handleBitCount(c net.Conn, args []string) {
+	if len(args) == 3 || len(args) > 5 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	r, msg := parseBitRange(args[2:])
+	if msg != "" {
+		s.reply(c, protocol.Error(msg))
+		return
+	}
+	s.writeBitReply(c, s.execute(c, "BITCOUNT", args[1], r.Args()...))
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+func (s *Server) handleBitPos(c net.Conn, args []string) {
+	if args[2] != "0" && args[2] != "1" {
+		s.reply(c, protocol.Error("ERR The bit argument must be 1 or 0."))
+		return
+	}
+	if len(args) > 6 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	r, msg := parseBitRange(args[3:])
+	if msg != "" {
+		s.reply(c, protocol.Error(msg))
+		return
+	}
+	s.writeBitReply(c, s.execute(c, "BITPOS", args[1], append([]string{args[2]}, r.Args()...)...))
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+func (s *Server) handleBitOp(c net.Conn, args []string) {
+	n, err := s.shards.BitOp(args[1], args[2], args[3:])
+	if err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	s.reply(c, protocol.Integer(n))
+}
+
+// parseBitRange reads the [start [end [BYTE|BIT]]] of BITCOUNT and BITPOS
+func parseBitRange(a []string) (store.BitRange, string) {
+	var r store.BitRange
+	var err error
+	if len(a) > 0 {
+		if r.Start, err = strconv.Atoi(a[0]); err != nil {
+			return r, "ERR value is not an integer or out of range"
+		}
+		r.HasStart = true
+	}
+	if len(a) > 1 {
+		if r.End, err = strconv.Atoi(a[1]); err != nil {
+			return r, "ERR value is not an integer or out of range"
+		}
+		r.HasEnd = true
+	}
+	if len(a) > 2 {
+		switch strings.ToUpper(a[2]) {
+		case "BYTE":
+		case "BIT":
+			r.Bits = true
+		default:
+			return r, "ERR syntax error"
+		}
+	}
+	return r, ""
+}
+
+func (s *Server) writeBitReply(c net.Conn, res interface{}) {
+	switch v := res.(type) {
+	case int:
+		s.reply(c, protocol.Integer(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.Integer(0))
+	}
+}
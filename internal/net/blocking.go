@@ -0,0 +1,110 @@
+package net
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Clients blocked on an empty list wait in a queue per source key, longest
+// waiting first, as in Redis. A write to a key with waiters marks it ready;
+// blockLoop then serves the key's waiters in order, moving one element for
+// each until the list runs out, with blocks.mu held so a waiter cannot time
+// out halfway through being served. Serving happens on blockLoop rather than
+// in the write that made the key ready, since that write holds store locks
+// the move needs.
+type blockState struct {
+	mu      sync.Mutex
+	waiters map[string][]*blockedClient // by source key
+
+	blocked atomic.Int64 // clients blocked or about to block
+
+	readyMu sync.Mutex
+	ready   map[string]struct{}
+	wake    chan struct{} // buffered; a send means ready may be non-empty
+}
+
+// blockedClient is a BLMOVE waiting for an element at its source key
+type blockedClient struct {
+	dst      string
+	fromLeft bool
+	toLeft   bool
+	served   chan blockResult // buffered, receives exactly one result
+}
+
+type blockResult struct {
+	elem string
+	err  error
+}
+
+func newBlockState() blockState {
+	return blockState{
+		waiters: make(map[string][]*blockedClient),
+		ready:   make(map[string]struct{}),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// keyReady marks key as possibly able to serve blocked clients. It is
+// called from store event hooks, so it takes no lock a store write could be
+// waiting on.
+func (s *Server) keyReady(key string) {
+	if s.blocks.blocked.Load() == 0 {
+		return
+	}
+	s.blocks.readyMu.Lock()
+	s.blocks.ready[key] = struct{}{}
+	s.blocks.readyMu.Unlock()
+	select {
+	case s.blocks.wake <- struct{}{}:
+	default:
+	}
+}
+
+// blockLoop serves blocked clients whenever keys become ready
+func (s *Server) blockLoop() {
+	for {
+		select {
+		case <-s.blocks.wake:
+		case <-s.stopCh:
+			return
+		}
+		s.blocks.readyMu.Lock()
+		ready := s.blocks.ready
+		s.blocks.ready = make(map[string]struct{})
+		s.blocks.readyMu.Unlock()
+		for key := range ready {
+			s.serveBlocked(key)
+		}
+	}
+}
+
+// serveBlocked hands elements of key to its waiters, oldest first
+func (s *Server) serveBlocked(key string) {
+	s.blocks.mu.Lock()
+	defer s.blocks.mu.Unlock()
+	for len(s.blocks.waiters[key]) > 0 {
+		w := s.blocks.waiters[key][0]
+		elem, ok, err := s.moveElement(key, w.dst, w.fromLeft, w.toLeft)
+		if err == nil && !ok {
+			return
+		}
+		s.dropWaiter(key, w)
+		w.served <- blockResult{elem: elem, err: err}
+	}
+}
+
+// dropWaiter removes w from key's queue. Callers hold blocks.mu.
+func (s *Server) dropWaiter(key string, w *blockedClient) {
+	q := s.blocks.waiters[key]
+	for i, x := range q {
+		if x == w {
+			q = append(q[:i:i], q[i+1:]...)
+			break
+		}
+	}
+	if len(q) == 0 {
+		delete(s.blocks.waiters, key)
+	} else {
+		s.blocks.waiters[key] = q
+	}
+}
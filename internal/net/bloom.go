@@ -0,0 +1,146 @@
+package net
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+func (s *Server) handleBFReserve(c net.Conn, args []string) {
+	rate, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		s.reply(c, protocol.Error("ERR bad error rate"))
+		return
+	}
+	capacity, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		s.reply(c, protocol.Error("ERR bad capacity"))
+		return
+	}
+	if err := store.CheckBloom(rate, capacity); err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	expansion, nonScaling, hasExpansion := int64(store.DefaultBloom.Expansion), false, false
+	for i := 4; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NONSCALING":
+			nonScaling = true
+		case "EXPANSION":
+			if i+1 >= len(args) {
+				s.reply(c, protocol.Error("ERR syntax error"))
+				return
+			}
+			i++
+			if expansion, err = strconv.ParseInt(args[i], 10, 32); err != nil || expansion < 1 {
+				s.reply(c, protocol.Error(store.ErrBloomExpansion.Error()))
+				return
+			}
+			hasExpansion = true
+		default:
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+	}
+	if nonScaling {
+		if hasExpansion {
+			s.reply(c, protocol.Error("ERR Nonscaling filters cannot expand"))
+			return
+		}
+		expansion = 0
+	}
+	switch v := s.execute(c, "BF.RESERVE", args[1], args[2], args[3], strconv.FormatInt(expansion, 10)).(type) {
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.SimpleString("OK"))
+	}
+}
+
+// BF.MADD key item [item ...]
+func (s *Server) handleBFMAdd(c net.Conn, args []string) {
+	s.writeBloomReply(c, s.execute(c, "BFADD", args[1], args[2:]...), true)
+}
+
+// BF.MEXISTS key item [item ...]
+func (s *Server) handleBFMExists(c net.Conn, args []string) {
+	s.writeBloomReply(c, s.execute(c, "BFEXISTS", args[1], args[2:]...), true)
+}
+
+// BF.INFO key [CAPACITY | SIZE | FILTERS | ITEMS | EXPANSION]
+func (s *Server) handleBFInfo(c net.Conn, args []string) {
+	if len(args) > 3 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	res := s.execute(c, "BF.INFO", args[1])
+	if err, ok := res.(error); ok {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	info, _ := res.(store.BloomInfo)
+	var expansion protocol.RESPType = protocol.Integer(info.Expansion)
+	if info.Expansion == 0 {
+		expansion = protocol.BulkString(nil) // does not scale
+	}
+	fields := []struct {
+		opt, name string
+		value     protocol.RESPType
+	}{
+		{"CAPACITY", "Capacity", protocol.Integer(info.Capacity)},
+		{"SIZE", "Size", protocol.Integer(info.Size)},
+		{"FILTERS", "Number of filters", protocol.Integer(info.Filters)},
+		{"ITEMS", "Number of items inserted", protocol.Integer(info.Items)},
+		{"EXPANSION", "Expansion rate", expansion},
+	}
+	if len(args) == 3 {
+		for _, f := range fields {
+			if strings.EqualFold(args[2], f.opt) {
+				s.reply(c, f.value)
+				return
+			}
+		}
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	m := protocol.Map{}
+	for _, f := range fields {
+		m = append(m, protocol.BulkString(f.name), f.value)
+	}
+	s.reply(c, m)
+}
+
+// writeBloomReply renders a BFADD or BFEXISTS shard reply, as one integer
+// or, for the multi-item commands, an array with an error in place of
+// items an add did not reach
+func (s *Server) writeBloomReply(c net.Conn, res interface{}, multi bool) {
+	r, ok := res.(store.BloomResult)
+	if !ok {
+		if err, isErr := res.(error); isErr {
+			s.reply(c, protocol.Error(err.Error()))
+		} else {
+			s.reply(c, protocol.Integer(0))
+		}
+		return
+	}
+	if !multi {
+		if len(r.Found) == 0 && r.Err != nil {
+			s.reply(c, protocol.Error(r.Err.Error()))
+			return
+		}
+		s.reply(c, protocol.Integer(boolInt(len(r.Found) > 0 && r.Found[0])))
+		return
+	}
+	out := make(protocol.Array, 0, len(r.Found)+1)
+	for _, f := range r.Found {
+		out = append(out, protocol.Integer(boolInt(f)))
+	}
+	if r.Err != nil {
+		out = append(out, protocol.Error(r.Err.Error()))
+	}
+	s.reply(c, out)
+}
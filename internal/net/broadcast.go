@@ -0,0 +1,116 @@
+package net
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+// toRESP converts a shard reply to its RESP form
+func toRESP(v interface{}) protocol.RESPType {
+	switch x := v.(type) {
+	case nil:
+		return protocol.BulkString(nil)
+	case error:
+		return protocol.Error(x.Error())
+	case string:
+		return protocol.BulkString(x)
+	case []byte:
+		return protocol.BulkString(x)
+	case bool:
+		if x {
+			return protocol.Integer(1)
+		}
+		return protocol.Integer(0)
+	case int:
+		return protocol.Integer(x)
+	case int64:
+		return protocol.Integer(x)
+	case uint32:
+		return protocol.Integer(x)
+	case float64:
+		return protocol.BulkString(strconv.FormatFloat(x, 'f', -1, 64))
+	case []string:
+		arr := make(protocol.Array, len(x))
+		for i, s := range x {
+			arr[i] = protocol.BulkString(s)
+		}
+		return arr
+	case []interface{}:
+		arr := make(protocol.Array, len(x))
+		for i, e := range x {
+			arr[i] = toRESP(e)
+		}
+		return arr
+	case map[string]string:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		m := protocol.Map{}
+		for _, k := range keys {
+			m = append(m, protocol.BulkString(k), protocol.BulkString(x[k]))
+		}
+		return m
+	default:
+		return protocol.Error("ERR unsupported reply type")
+	}
+}
+
+// BROADCAST command [key [arg ...]] runs a shard command on every shard and
+// replies with a map of node ID to that shard's reply
+func (s *Server) handleBroadcast(c net.Conn, args []string) {
+	parts := args[1:]
+	key := ""
+	if len(parts) > 1 {
+		key = parts[1]
+	}
+	var rest []string
+	if len(parts) > 2 {
+		rest = parts[2:]
+	}
+
+	replies, err := s.shards.BroadcastCommand(strings.ToUpper(parts[0]), key, rest...)
+	if err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	s.reply(c, shardReplyMap(replies))
+}
+
+// shardReplyMap orders per-shard replies by node ID
+func shardReplyMap(replies map[string]interface{}) protocol.Map {
+	nodes := make([]string, 0, len(replies))
+	for id := range replies {
+		nodes = append(nodes, id)
+	}
+	sort.Strings(nodes)
+	m := protocol.Map{}
+	for _, id := range nodes {
+		m = append(m, protocol.BulkString(id), toRESP(replies[id]))
+	}
+	return m
+}
+
+// FLUSHALL [ASYNC | SYNC]. It flushes synchronously.
+func (s *Server) handleFlushAll(c net.Conn, args []string) {
+	if len(args) == 2 {
+		mode := args[1]
+		if m := strings.ToUpper(mode); m != "ASYNC" && m != "SYNC" {
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+	}
+	s.shards.FlushAll()
+	s.touchAllWatched()
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// DBSIZE
+func (s *Server) handleDBSize(c net.Conn, args []string) {
+	s.reply(c, protocol.Integer(s.shards.DBKeys(s.db(c))))
+}
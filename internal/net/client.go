@@ -0,0 +1,515 @@
+package net
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+// client is the server-side state of one connection
+type client struct {
+	id      uint64
+	conn    net.Conn
+	created time.Time
+	proto   atomic.Int32 // RESP version, switched by HELLO
+	name    atomic.Value // string, set by HELLO SETNAME
+	user    atomic.Value // string, the ACL user; "" until authenticated
+	subs    subscriptions
+	tx      txState
+
+	asking       atomic.Bool  // set by ASKING for the next command
+	capaRedirect atomic.Bool  // CLIENT CAPA redirect: REDIRECT errors and topology pushes
+	db           atomic.Int32 // database chosen with SELECT
+
+	confirmDestructive atomic.Bool                    // CLIENT CONFIRM ON
+	pendingConfirm     atomic.Pointer[pendingConfirm] // token the last destructive command was given
+
+	lastCmd    atomic.Value // string, lower-case name of the last command run
+	lastActive atomic.Int64 // UnixNano when the last command arrived
+
+	replAddr   atomic.Value                // string, announced by REPLCONF listening-port
+	replNodeID atomic.Value                // string, announced by REPLCONF node-id
+	replica    atomic.Pointer[replicaPeer] // set once PSYNC made this a replica link
+
+	out replyWriter
+}
+
+// replyWriter buffers what is written to a connection. Replies, pub/sub
+// messages and topology pushes come from different goroutines, so each one
+// is written and flushed whole under mu and none lands inside another.
+type replyWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer // created by the first write
+}
+
+// register starts tracking a newly accepted connection
+func (s *Server) register(c net.Conn) *client {
+	cl := &client{id: s.nextClientID.Add(1), conn: c, created: time.Now()}
+	cl.proto.Store(2)
+	cl.name.Store("")
+	cl.lastCmd.Store("NULL")
+	cl.lastActive.Store(cl.created.UnixNano())
+	cl.user.Store(s.initialUser())
+	s.mu.Lock()
+	s.conns[c] = cl
+	s.mu.Unlock()
+	s.totalConnections.Add(1)
+	return cl
+}
+
+// unregister forgets a closed connection and tears down its subscriptions
+func (s *Server) unregister(c net.Conn) {
+	s.mu.Lock()
+	cl := s.conns[c]
+	delete(s.conns, c)
+	s.mu.Unlock()
+	if cl != nil {
+		s.closeSubscriptions(&cl.subs)
+		s.unwatch(cl)
+		s.detachReplica(cl)
+	}
+}
+
+// client returns the state of connection c, or nil once it has closed
+func (s *Server) client(c net.Conn) *client {
+	if tc, ok := c.(*txConn); ok {
+		c = tc.Conn
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[c]
+}
+
+// reply encodes v in the protocol version negotiated by c and streams it
+// through the connection's writer
+func (s *Server) reply(c net.Conn, v protocol.RESPType) error {
+	cl := s.client(c)
+	if cl == nil || cl.conn != c {
+		// not a client, or a transaction collecting its replies
+		proto := 2
+		if cl != nil {
+			proto = int(cl.proto.Load())
+		}
+		return protocol.EncodeProtoTo(c, v, proto)
+	}
+	return cl.write(func(w *bufio.Writer) error {
+		return protocol.WriteProto(w, v, int(cl.proto.Load()))
+	})
+}
+
+// write runs fn on the connection's writer and flushes what it wrote
+func (cl *client) write(fn func(w *bufio.Writer) error) error {
+	cl.out.mu.Lock()
+	defer cl.out.mu.Unlock()
+	if cl.out.w == nil {
+		cl.out.w = bufio.NewWriter(cl.conn)
+	}
+	if err := fn(cl.out.w); err != nil {
+		return err
+	}
+	return cl.out.w.Flush()
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func (s *Server) handleHello(c net.Conn, args []string) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	proto := int(cl.proto.Load())
+	i := 1
+	if len(args) > 1 {
+		ver := args[1]
+		n, err := strconv.Atoi(ver)
+		if err != nil {
+			s.reply(c, protocol.Error("ERR Protocol version is not an integer or out of range"))
+			return
+		}
+		if n != 2 && n != 3 {
+			s.reply(c, protocol.Error("NOPROTO unsupported protocol version"))
+			return
+		}
+		proto = n
+		i = 2
+	}
+
+	name := ""
+	setName := false
+	var authUser, authPass string
+	auth := false
+	for ; i < len(args); i++ {
+		opt := args[i]
+		switch {
+		case strings.EqualFold(opt, "SETNAME") && i+1 < len(args):
+			v := args[i+1]
+			if strings.ContainsAny(v, " \n") {
+				s.reply(c, protocol.Error("ERR Client names cannot contain spaces, newlines or special characters."))
+				return
+			}
+			name, setName = v, true
+			i++
+		case strings.EqualFold(opt, "AUTH") && i+2 < len(args):
+			user := args[i+1]
+			pass := args[i+2]
+			authUser, authPass, auth = user, pass, true
+			i += 2
+		default:
+			s.reply(c, protocol.Error("ERR Syntax error in HELLO option '"+opt+"'"))
+			return
+		}
+	}
+
+	if auth && !s.authenticate(cl, authUser, authPass) {
+		s.reply(c, protocol.Error("WRONGPASS invalid username-password pair or user is disabled."))
+		return
+	}
+	if cl.user.Load().(string) == "" {
+		s.reply(c, protocol.Error("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"))
+		return
+	}
+	cl.proto.Store(int32(proto))
+	if setName {
+		cl.name.Store(name)
+	}
+	s.reply(c, protocol.Map{
+		protocol.BulkString("server"), protocol.BulkString("multithreaded-redis"),
+		protocol.BulkString("version"), protocol.BulkString(serverVersion),
+		protocol.BulkString("proto"), protocol.Integer(proto),
+		protocol.BulkString("id"), protocol.Integer(cl.id),
+		protocol.BulkString("mode"), protocol.BulkString("standalone"),
+		protocol.BulkString("role"), protocol.BulkString(s.role()),
+		protocol.BulkString("modules"), protocol.Array{},
+	})
+}
+
+// CLIENT ID | INFO | LIST [TYPE type] [ID id ...] | GETNAME | SETNAME name |
+// KILL addr | KILL [ID id] [ADDR addr] [USER user] [TYPE type] [SKIPME yes|no] |
+// CAPA capability [capability ...] | CONFIRM ON|OFF
+func (s *Server) handleClient(c net.Conn, args []string) {
+	sub := args[1]
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	name := strings.ToUpper(sub)
+	if bounds, ok := clientArity[name]; ok && (len(args) < bounds[0] || bounds[1] > 0 && len(args) > bounds[1]) {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'client|"+strings.ToLower(name)+"' command"))
+		return
+	}
+	switch name {
+	case "ID":
+		s.reply(c, protocol.Integer(cl.id))
+	case "INFO":
+		s.clientInfo(c, cl)
+	case "GETNAME":
+		if n := cl.name.Load().(string); n != "" {
+			s.reply(c, protocol.BulkString(n))
+		} else {
+			s.reply(c, protocol.BulkString(nil))
+		}
+	case "SETNAME":
+		v := args[2]
+		if strings.ContainsAny(v, " \n") {
+			s.reply(c, protocol.Error("ERR Client names cannot contain spaces, newlines or special characters."))
+			return
+		}
+		cl.name.Store(v)
+		s.reply(c, protocol.SimpleString("OK"))
+	case "LIST":
+		f, err := parseClientFilter(args[2:], false)
+		if err != nil {
+			s.reply(c, protocol.Error(err.Error()))
+			return
+		}
+		var b strings.Builder
+		for _, other := range s.clients() {
+			if f.matches(other, cl) {
+				b.WriteString(clientLine(s.clientFields(other)))
+			}
+		}
+		s.reply(c, protocol.BulkString(b.String()))
+	case "KILL":
+		s.clientKill(c, cl, args[2:])
+	case "CAPA":
+		// capabilities this server does not know are ignored, as in Redis
+		for _, a := range args[2:] {
+			if capa := a; strings.EqualFold(capa, "redirect") {
+				cl.capaRedirect.Store(true)
+			}
+		}
+		s.reply(c, protocol.SimpleString("OK"))
+	case "CONFIRM":
+		// turns confirmation of destructive commands on for this connection
+		// alone; it cannot turn off confirm-destructive
+		mode := args[2]
+		switch strings.ToUpper(mode) {
+		case "ON":
+			cl.confirmDestructive.Store(true)
+		case "OFF":
+			cl.confirmDestructive.Store(false)
+			cl.pendingConfirm.Store(nil)
+		default:
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+		s.reply(c, protocol.SimpleString("OK"))
+	default:
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+sub+"'. Try CLIENT ID, INFO, LIST, GETNAME, SETNAME, KILL, CAPA or CONFIRM."))
+	}
+}
+
+// clientArity bounds the arguments of each CLIENT subcommand, counting
+// CLIENT and the subcommand; a maximum of -1 means no limit
+var clientArity = map[string][2]int{
+	"ID": {2, 2}, "INFO": {2, 2}, "GETNAME": {2, 2}, "SETNAME": {3, 3},
+	"LIST": {2, -1}, "KILL": {3, -1}, "CAPA": {3, -1}, "CONFIRM": {3, 3},
+}
+
+// clients lists the open connections in ID order
+func (s *Server) clients() []*client {
+	s.mu.Lock()
+	out := make([]*client, 0, len(s.conns))
+	for _, cl := range s.conns {
+		out = append(out, cl)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].id < out[j].id })
+	return out
+}
+
+// clientType is the TYPE CLIENT LIST and KILL filter on: replica for
+// replication links, pubsub while subscribed, otherwise normal
+func clientType(cl *client) string {
+	switch {
+	case cl.replica.Load() != nil:
+		return "replica"
+	case cl.subs.active():
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// clientFilter selects connections for CLIENT LIST and CLIENT KILL; zero
+// fields match everything
+type clientFilter struct {
+	ids    map[uint64]bool
+	addr   string
+	user   string
+	typ    string
+	skipMe bool
+}
+
+func (f clientFilter) matches(cl, self *client) bool {
+	switch {
+	case f.ids != nil && !f.ids[cl.id]:
+		return false
+	case f.addr != "" && cl.conn.RemoteAddr().String() != f.addr:
+		return false
+	case f.user != "" && cl.user.Load().(string) != f.user:
+		return false
+	case f.typ != "" && clientType(cl) != f.typ:
+		return false
+	case f.skipMe && cl == self:
+		return false
+	}
+	return true
+}
+
+// parseClientFilter reads option/value pairs. LIST accepts TYPE and ID,
+// where ID takes every following argument; KILL accepts single IDs and
+// the other filters.
+func parseClientFilter(args []string, kill bool) (clientFilter, error) {
+	f := clientFilter{skipMe: kill}
+	for i := 0; i < len(args); i++ {
+		opt := args[i]
+		if i+1 >= len(args) {
+			return f, fmt.Errorf("ERR syntax error")
+		}
+		val := []byte(args[i+1])
+		switch strings.ToUpper(opt) {
+		case "ID":
+			if f.ids == nil {
+				f.ids = make(map[uint64]bool)
+			}
+			end := i + 2
+			if !kill {
+				end = len(args)
+			}
+			for _, v := range args[i+1 : end] {
+				id, err := strconv.ParseUint(v, 10, 64)
+				if err != nil || id == 0 {
+					return f, fmt.Errorf("ERR Invalid client ID")
+				}
+				f.ids[id] = true
+			}
+			i = end - 1
+			continue
+		case "TYPE":
+			t := strings.ToLower(string(val))
+			if t == "slave" {
+				t = "replica"
+			}
+			if t != "normal" && t != "replica" && t != "pubsub" {
+				return f, fmt.Errorf("ERR Unknown client type '%s'", val)
+			}
+			f.typ = t
+		case "ADDR":
+			if !kill {
+				return f, fmt.Errorf("ERR syntax error")
+			}
+			f.addr = string(val)
+		case "USER":
+			if !kill {
+				return f, fmt.Errorf("ERR syntax error")
+			}
+			f.user = string(val)
+		case "SKIPME":
+			if !kill {
+				return f, fmt.Errorf("ERR syntax error")
+			}
+			switch strings.ToLower(string(val)) {
+			case "yes":
+				f.skipMe = true
+			case "no":
+				f.skipMe = false
+			default:
+				return f, fmt.Errorf("ERR syntax error")
+			}
+		default:
+			return f, fmt.Errorf("ERR syntax error")
+		}
+		i++
+	}
+	return f, nil
+}
+
+// clientKill closes the matching connections. The old single-argument form
+// takes an address and replies OK; the filter form replies with a count.
+func (s *Server) clientKill(c net.Conn, self *client, args []string) {
+	old := len(args) == 1
+	f := clientFilter{}
+	if old {
+		addr := args[0]
+		f.addr = addr
+	} else {
+		var err error
+		if f, err = parseClientFilter(args, true); err != nil {
+			s.reply(c, protocol.Error(err.Error()))
+			return
+		}
+	}
+	var victims []*client
+	for _, cl := range s.clients() {
+		if f.matches(cl, self) {
+			victims = append(victims, cl)
+		}
+	}
+	if old {
+		if len(victims) == 0 {
+			s.reply(c, protocol.Error("ERR No such client"))
+			return
+		}
+		s.reply(c, protocol.SimpleString("OK"))
+	} else {
+		s.reply(c, protocol.Integer(len(victims)))
+	}
+	// replies go out first, in case the caller killed itself
+	for _, cl := range victims {
+		cl.conn.Close()
+	}
+}
+
+// clientField is one field=value pair describing a connection
+type clientField struct {
+	name  string
+	value any // int64 or string
+}
+
+// clientFields describes cl in Redis's CLIENT LIST field order. flags are
+// N (normal), S (replica), P (subscribed) and x (in MULTI); multi is the
+// number of queued commands, -1 outside MULTI.
+func (s *Server) clientFields(cl *client) []clientField {
+	cl.subs.mu.Lock()
+	channels, patterns := len(cl.subs.channels), len(cl.subs.patterns)
+	cl.subs.mu.Unlock()
+	multi := cl.tx.depth()
+	flags := ""
+	switch clientType(cl) {
+	case "replica":
+		flags += "S"
+	case "pubsub":
+		flags += "P"
+	}
+	if multi >= 0 {
+		flags += "x"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	now := time.Now()
+	return []clientField{
+		{"id", int64(cl.id)},
+		{"addr", cl.conn.RemoteAddr().String()},
+		{"laddr", cl.conn.LocalAddr().String()},
+		{"name", cl.name.Load().(string)},
+		{"age", int64(now.Sub(cl.created).Seconds())},
+		{"idle", int64(now.Sub(time.Unix(0, cl.lastActive.Load())).Seconds())},
+		{"flags", flags},
+		{"sub", int64(channels)},
+		{"psub", int64(patterns)},
+		{"db", int64(cl.db.Load())},
+		{"multi", int64(multi)},
+		{"watch", int64(len(cl.tx.watchedKeys()))},
+		{"user", cl.user.Load().(string)},
+		{"cmd", cl.lastCmd.Load().(string)},
+		{"resp", int64(cl.proto.Load())},
+	}
+}
+
+// clientLine renders fields as one CLIENT LIST line
+func clientLine(fields []clientField) string {
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%s=%v", f.name, f.value)
+	}
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// clientInfo describes cl: a map under RESP3, with the watched keys,
+// otherwise the one-line form CLIENT LIST uses
+func (s *Server) clientInfo(c net.Conn, cl *client) {
+	fields := s.clientFields(cl)
+	if cl.proto.Load() < 3 {
+		s.reply(c, protocol.BulkString(clientLine(fields)))
+		return
+	}
+	m := protocol.Map{}
+	for _, f := range fields {
+		var v protocol.RESPType
+		switch x := f.value.(type) {
+		case int64:
+			v = protocol.Integer(x)
+		case string:
+			v = protocol.BulkString(x)
+		}
+		m = append(m, protocol.BulkString(f.name), v)
+	}
+	watched := cl.tx.watchedKeys()
+	keys := make(protocol.Array, len(watched))
+	for i, k := range watched {
+		keys[i] = protocol.BulkString(k)
+	}
+	s.reply(c, append(m, protocol.BulkString("watched-keys"), keys))
+}
@@ -0,0 +1,232 @@
+package net
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// The server presents itself to cluster clients as one primary owning every
+// slot, with its replicas; the shards behind it share its address, so a
+// client never needs to route between them.
+
+// announceAddr is the address given to cluster clients: cluster-announce-addr,
+// or the listen address with a wildcard host replaced by loopback
+func (s *Server) announceAddr() string {
+	c := s.cfg.Snapshot()
+	if c.ClusterAnnounceAddr != "" {
+		return c.ClusterAnnounceAddr
+	}
+	host, port, err := net.SplitHostPort(s.addr)
+	if err != nil {
+		return s.addr
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// replicaWriteError rejects a client write on a replica: cluster clients
+// are sent to the primary with MOVED, clients with CLIENT CAPA redirect with
+// REDIRECT, others get READONLY
+func (s *Server) replicaWriteError(c net.Conn, cmd *command, args []string) {
+	s.repl.mu.Lock()
+	link := s.repl.link
+	s.repl.mu.Unlock()
+	if s.cfg.Snapshot().ClusterEnabled && link != nil && cmd.firstKey > 0 && cmd.firstKey < len(args) {
+		key := args[cmd.firstKey]
+		moved := &store.RedirectError{Kind: "MOVED", Slot: store.KeySlot(key), Addr: link.addr}
+		s.reply(c, protocol.Error(moved.Error()))
+		return
+	}
+	if cl := s.client(c); cl != nil && cl.capaRedirect.Load() && link != nil {
+		s.reply(c, protocol.Error("REDIRECT "+link.addr))
+		return
+	}
+	s.reply(c, protocol.Error("READONLY You can't write against a read only replica."))
+}
+
+// pushTopology tells RESP3 clients with CLIENT CAPA redirect that the
+// topology changed, so they can reroute before a command fails:
+//
+//	topology replica <primary>  this server now replicates primary; send writes there
+//	topology primary            this server now takes writes
+//	topology shards <count>     the hash ring changed; keys are moving between
+//	                            shards and may briefly answer MOVED
+func (s *Server) pushTopology(event string, args ...string) {
+	select {
+	case <-s.stopCh:
+		return // shutting down, not changing roles
+	default:
+	}
+	msg := protocol.Push{protocol.BulkString("topology"), protocol.BulkString(event)}
+	for _, a := range args {
+		msg = append(msg, protocol.BulkString(a))
+	}
+	for _, cl := range s.clients() {
+		if cl.capaRedirect.Load() && cl.proto.Load() >= 3 && cl.replica.Load() == nil {
+			cl.write(func(w *bufio.Writer) error { return protocol.WriteProto(w, msg, 3) })
+		}
+	}
+}
+
+// clusterNode is one member of the topology as CLUSTER replies show it
+type clusterNode struct {
+	id      string
+	addr    string
+	primary bool
+	myself  bool
+	offset  int64
+}
+
+// clusterNodes lists the primary first, then its replicas
+func (s *Server) clusterNodes() []clusterNode {
+	s.repl.mu.Lock()
+	link := s.repl.link
+	peers := make([]*replicaPeer, 0, len(s.repl.replicas))
+	for cl := range s.repl.replicas {
+		peers = append(peers, cl.replica.Load())
+	}
+	s.repl.mu.Unlock()
+
+	me := clusterNode{id: s.nodeID, addr: s.announceAddr(), primary: link == nil, myself: true, offset: s.replOffset()}
+	if link != nil {
+		// the primary's node ID is not known to its replicas
+		return []clusterNode{{addr: link.addr, primary: true}, me}
+	}
+	nodes := []clusterNode{me}
+	for _, p := range peers {
+		nodes = append(nodes, clusterNode{id: p.id, addr: p.addr, offset: p.ack.Load()})
+	}
+	return nodes
+}
+
+// CLUSTER INFO | MYID | SLOTS | SHARDS | NODES | KEYSLOT key |
+// COUNTKEYSINSLOT slot | GETKEYSINSLOT slot count
+func (s *Server) handleCluster(c net.Conn, args []string) {
+	if !s.cfg.Snapshot().ClusterEnabled {
+		s.reply(c, protocol.Error("ERR This instance has cluster support disabled"))
+		return
+	}
+	sub := args[1]
+	name := strings.ToUpper(sub)
+	want := map[string]int{"KEYSLOT": 3, "COUNTKEYSINSLOT": 3, "GETKEYSINSLOT": 4}[name]
+	if want == 0 {
+		want = 2
+	}
+	if len(args) != want {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'cluster|"+strings.ToLower(name)+"' command"))
+		return
+	}
+
+	switch name {
+	case "INFO":
+		nodes := s.clusterNodes()
+		info := fmt.Sprintf("cluster_enabled:1\r\ncluster_state:ok\r\ncluster_slots_assigned:%d\r\ncluster_slots_ok:%d\r\n"+
+			"cluster_slots_pfail:0\r\ncluster_slots_fail:0\r\ncluster_known_nodes:%d\r\ncluster_size:1\r\n",
+			store.ClusterSlots, store.ClusterSlots, len(nodes))
+		s.reply(c, protocol.BulkString(info))
+
+	case "MYID":
+		s.reply(c, protocol.BulkString(s.nodeID))
+
+	case "SLOTS":
+		entry := protocol.Array{protocol.Integer(0), protocol.Integer(store.ClusterSlots - 1)}
+		for _, n := range s.clusterNodes() {
+			host, port, _ := net.SplitHostPort(n.addr)
+			p, _ := strconv.Atoi(port)
+			entry = append(entry, protocol.Array{protocol.BulkString(host), protocol.Integer(p), protocol.BulkString(n.id)})
+		}
+		s.reply(c, protocol.Array{entry})
+
+	case "SHARDS":
+		nodes := protocol.Array{}
+		for _, n := range s.clusterNodes() {
+			host, port, _ := net.SplitHostPort(n.addr)
+			p, _ := strconv.Atoi(port)
+			role := "replica"
+			if n.primary {
+				role = "master"
+			}
+			nodes = append(nodes, protocol.Map{
+				protocol.BulkString("id"), protocol.BulkString(n.id),
+				protocol.BulkString("port"), protocol.Integer(p),
+				protocol.BulkString("ip"), protocol.BulkString(host),
+				protocol.BulkString("endpoint"), protocol.BulkString(host),
+				protocol.BulkString("role"), protocol.BulkString(role),
+				protocol.BulkString("replication-offset"), protocol.Integer(n.offset),
+				protocol.BulkString("health"), protocol.BulkString("online"),
+			})
+		}
+		s.reply(c, protocol.Array{protocol.Map{
+			protocol.BulkString("slots"), protocol.Array{protocol.Integer(0), protocol.Integer(store.ClusterSlots - 1)},
+			protocol.BulkString("nodes"), nodes,
+		}})
+
+	case "NODES":
+		nodes := s.clusterNodes()
+		var b strings.Builder
+		for _, n := range nodes {
+			flags, primary, slots := "slave", nodes[0].id, ""
+			if n.primary {
+				flags, primary, slots = "master", "-", fmt.Sprintf(" 0-%d", store.ClusterSlots-1)
+			}
+			if n.myself {
+				flags = "myself," + flags
+			}
+			if primary == "" {
+				primary = "-"
+			}
+			fmt.Fprintf(&b, "%s %s@0 %s %s 0 0 0 connected%s\n", n.id, n.addr, flags, primary, slots)
+		}
+		s.reply(c, protocol.BulkString(b.String()))
+
+	case "KEYSLOT":
+		key := args[2]
+		s.reply(c, protocol.Integer(store.KeySlot(key)))
+
+	case "COUNTKEYSINSLOT", "GETKEYSINSLOT":
+		slotArg := args[2]
+		slot, err := strconv.Atoi(slotArg)
+		if err != nil || slot < 0 || slot >= store.ClusterSlots {
+			s.reply(c, protocol.Error("ERR Invalid slot"))
+			return
+		}
+		if name == "COUNTKEYSINSLOT" {
+			_, n := s.shards.KeysInSlot(slot, 0)
+			s.reply(c, protocol.Integer(n))
+			return
+		}
+		countArg := args[3]
+		count, err := strconv.Atoi(countArg)
+		if err != nil || count < 0 {
+			s.reply(c, protocol.Error("ERR Invalid number of keys"))
+			return
+		}
+		keys, _ := s.shards.KeysInSlot(slot, count)
+		arr := make(protocol.Array, len(keys))
+		for i, k := range keys {
+			arr[i] = protocol.BulkString(k)
+		}
+		s.reply(c, arr)
+
+	default:
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+sub+"'. Try CLUSTER INFO, SLOTS, SHARDS or NODES."))
+	}
+}
+
+// ASKING lets the connection's next command reach a slot being imported.
+// Migrations between this server's shards are resolved in-process, so the
+// flag only matters to slot-level redirects.
+func (s *Server) handleAsking(c net.Conn, args []string) {
+	if cl := s.client(c); cl != nil {
+		cl.asking.Store(true)
+	}
+	s.reply(c, protocol.SimpleString("OK"))
+}
@@ -0,0 +1,535 @@
+package net
+
+import (
+	"log"
+	"net"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+type commandFlag uint
+
+const (
+	flagWrite commandFlag = 1 << iota
+	flagReadOnly
+	flagFast
+	flagAdmin
+	flagPubSub
+	flagBlocking
+	flagDenyOOM
+)
+
+var commandFlagNames = []struct {
+	flag commandFlag
+	name string
+}{
+	{flagWrite, "write"},
+	{flagReadOnly, "readonly"},
+	{flagFast, "fast"},
+	{flagAdmin, "admin"},
+	{flagPubSub, "pubsub"},
+	{flagBlocking, "blocking"},
+	{flagDenyOOM, "denyoom"},
+}
+
+// command describes one client command. Arity follows the Redis convention:
+// a positive value is the exact argument count including the command name,
+// a negative value is the minimum count.
+type command struct {
+	name     string
+	arity    int
+	flags    commandFlag
+	firstKey int // position of the first key argument, 0 if none
+	lastKey  int // position of the last key, -1 means the last argument
+	step     int // distance between keys
+	keyNum   int // position of a numkeys argument counting the keys from firstKey, 0 if none
+	summary  string
+	handler  func(s *Server, c net.Conn, args []string)
+	// rewrite, if set, turns a write and its reply into the command sent to
+	// replicas, for writes a replica could not repeat; nil sends nothing
+	rewrite func(args []string, reply protocol.RESPType) []string
+}
+
+// commandTable maps upper-case command names to their definitions
+var commandTable = map[string]*command{}
+
+func registerCommand(cmd *command) {
+	commandTable[strings.ToUpper(cmd.name)] = cmd
+}
+
+func lookupCommand(name string) (*command, bool) {
+	cmd, ok := commandTable[strings.ToUpper(name)]
+	return cmd, ok
+}
+
+// checkArity reports whether argc satisfies the declared arity
+func (cmd *command) checkArity(argc int) bool {
+	if cmd.arity >= 0 {
+		return argc == cmd.arity
+	}
+	return argc >= -cmd.arity
+}
+
+func (cmd *command) has(f commandFlag) bool {
+	return cmd.flags&f != 0
+}
+
+func (cmd *command) flagNames() []string {
+	names := []string{}
+	for _, fn := range commandFlagNames {
+		if cmd.has(fn.flag) {
+			names = append(names, fn.name)
+		}
+	}
+	return names
+}
+
+// keyRange returns the positions of the key arguments of args: from first
+// to last, step apart. last < first when there are none. A numkeys that is
+// not a positive integer gives none; the handler rejects it.
+func (cmd *command) keyRange(args []string) (first, last, step int) {
+	if cmd.firstKey <= 0 || cmd.firstKey >= len(args) {
+		return 0, -1, 1
+	}
+	if cmd.keyNum > 0 {
+		n, err := strconv.Atoi(args[cmd.keyNum])
+		if err != nil || n <= 0 {
+			return 0, -1, 1
+		}
+		return cmd.firstKey, min(cmd.firstKey+n, len(args)) - 1, 1
+	}
+	last = cmd.lastKey
+	if last < 0 {
+		last = len(args) + last
+	}
+	return cmd.firstKey, min(last, len(args)-1), max(cmd.step, 1)
+}
+
+// keys returns the key arguments of args, by the command's key positions
+func (cmd *command) keys(args []string) []string {
+	first, last, step := cmd.keyRange(args)
+	var keys []string
+	for i := first; i <= last; i += step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// keyCount is the number of key arguments in args, by the command's key
+// positions
+func (cmd *command) keyCount(args []string) int {
+	first, last, step := cmd.keyRange(args)
+	if last < first {
+		return 0
+	}
+	return (last-first)/step + 1
+}
+
+// info renders the COMMAND INFO entry for cmd
+func (cmd *command) info() protocol.Array {
+	flags := protocol.Array{}
+	for _, f := range cmd.flagNames() {
+		flags = append(flags, protocol.SimpleString(f))
+	}
+	first, last, step := cmd.firstKey, cmd.lastKey, cmd.step
+	if cmd.keyNum > 0 {
+		// keys found by a count argument are reported as Redis does
+		flags = append(flags, protocol.SimpleString("movablekeys"))
+		first, last, step = 0, 0, 0
+	}
+	return protocol.Array{
+		protocol.BulkString(strings.ToLower(cmd.name)),
+		protocol.Integer(cmd.arity),
+		flags,
+		protocol.Integer(first),
+		protocol.Integer(last),
+		protocol.Integer(step),
+	}
+}
+
+func sortedCommands() []*command {
+	cmds := make([]*command, 0, len(commandTable))
+	for _, cmd := range commandTable {
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].name < cmds[j].name })
+	return cmds
+}
+
+func init() {
+	for _, cmd := range []*command{
+		{name: "PING", arity: -1, flags: flagFast, summary: "Returns the server's liveliness response.", handler: (*Server).handlePing},
+		{name: "AUTH", arity: -2, flags: flagFast, summary: "Authenticates the connection.", handler: (*Server).handleAuth},
+		{name: "QUIT", arity: -1, flags: flagFast, summary: "Closes the connection.", handler: (*Server).handleQuit},
+		{name: "HELLO", arity: -1, flags: flagFast, summary: "Handshakes with the server, optionally switching the RESP protocol version.", handler: (*Server).handleHello},
+		{name: "INFO", arity: -1, summary: "Returns information and statistics about the server.", handler: (*Server).handleInfo},
+		{name: "CAPABILITIES", arity: 1, summary: "Reports the version, enabled features and limits, and self-tests every subsystem.", handler: (*Server).handleCapabilities},
+		{name: "EVENTS", arity: -1, flags: flagAdmin, summary: "Returns recent server events: shard and migration, replication, OOM and config changes.", handler: (*Server).handleEvents},
+		{name: "MEMORY", arity: 2, summary: "Reports allocator, dataset and defragmentation statistics.", handler: (*Server).handleMemory},
+		{name: "DEBUG", arity: -2, flags: flagAdmin, summary: "Debugging and verification helpers such as dataset digests.", handler: (*Server).handleDebug},
+		{name: "OWNER", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Reports the node the ring maps a key to and the nodes holding a copy.", handler: (*Server).handleOwner},
+		{name: "KEYGROUP", arity: -2, summary: "Places keys by a group name so they share a shard, or reports the groups.", handler: (*Server).handleKeyGroup},
+		{name: "COMMAND", arity: -1, summary: "Returns detailed information about all commands.", handler: (*Server).handleCommand},
+		{name: "BACKUP", arity: -3, flags: flagAdmin, summary: "Writes a consistent snapshot of all shards, or the keys changed since the last one, to a directory or S3 bucket.", handler: (*Server).handleBackup},
+		{name: "CLIENT", arity: -2, summary: "Lists, names, inspects and kills client connections.", handler: (*Server).handleClient},
+		{name: "REPLICAOF", arity: 3, flags: flagAdmin, summary: "Makes the server a replica of another instance, or promotes it with NO ONE.", handler: (*Server).handleReplicaOf},
+		{name: "REPLCONF", arity: -1, flags: flagAdmin, summary: "An internal command for configuring the replication stream.", handler: (*Server).handleReplConf},
+		{name: "PSYNC", arity: 3, flags: flagAdmin, summary: "An internal command used in replication.", handler: (*Server).handlePSync},
+		{name: "CLUSTER", arity: -2, summary: "Reports the slot layout and nodes to cluster-aware clients.", handler: (*Server).handleCluster},
+		{name: "SENTINEL", arity: -2, summary: "Answers Sentinel clients looking for the primary of this server's replication group.", handler: (*Server).handleSentinel},
+		{name: "ROLE", arity: 1, flags: flagFast, summary: "Returns the replication role.", handler: (*Server).handleRole},
+		{name: "ASKING", arity: 1, flags: flagFast, summary: "Lets the next command reach a slot that is being imported.", handler: (*Server).handleAsking},
+		{name: "ACL", arity: -2, flags: flagAdmin, summary: "Manages users and their command and key permissions.", handler: (*Server).handleACL},
+		{name: "CONFIG", arity: -2, flags: flagAdmin, summary: "Reads, changes or persists server configuration parameters.", handler: (*Server).handleConfig},
+
+		// transactions
+		{name: "MULTI", arity: 1, flags: flagFast, summary: "Starts a transaction.", handler: (*Server).handleMulti},
+		{name: "EXEC", arity: 1, summary: "Executes all commands in a transaction.", handler: (*Server).handleExec},
+		{name: "DISCARD", arity: 1, flags: flagFast, summary: "Discards a transaction.", handler: (*Server).handleDiscard},
+		{name: "WATCH", arity: -2, flags: flagFast, firstKey: 1, lastKey: -1, step: 1, summary: "Monitors changes to keys to determine the execution of a transaction.", handler: (*Server).handleWatch},
+		{name: "UNWATCH", arity: 1, flags: flagFast, summary: "Forgets about watched keys of a transaction.", handler: (*Server).handleUnwatch},
+
+		// strings
+		{name: "SET", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Sets the string value of a key, with optional conditions and expiration.", handler: (*Server).handleSET},
+		{name: "GET", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the string value of a key.", handler: (*Server).handleGET},
+		{name: "APPEND", arity: 3, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Appends a string to the value of a key.", handler: (*Server).handleAppend},
+		{name: "STRLEN", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the length of a string value.", handler: (*Server).handleStrLen},
+		{name: "GETRANGE", arity: 4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns a substring of the string stored at a key.", handler: (*Server).handleGetRange},
+		{name: "SETRANGE", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Overwrites part of a string value from an offset.", handler: (*Server).handleSetRange},
+		{name: "SETBIT", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Sets or clears the bit at offset of the string value.", handler: (*Server).handleSetBit},
+		{name: "GETBIT", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns a bit value by offset.", handler: (*Server).handleGetBit},
+		{name: "BITCOUNT", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Counts the number of set bits in a string.", handler: (*Server).handleBitCount},
+		{name: "BITPOS", arity: -3, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Finds the first set or clear bit in a string.", handler: (*Server).handleBitPos},
+		{name: "BITOP", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 2, lastKey: -1, step: 1, summary: "Performs bitwise operations on multiple strings, and stores the result.", handler: (*Server).handleBitOp},
+		{name: "GETSET", arity: 3, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Sets a key and returns its previous value.", handler: (*Server).handleGetSet},
+		{name: "SETNX", arity: 3, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Sets a key only if it does not exist.", handler: (*Server).handleSetNX},
+		{name: "MGET", arity: -2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: -1, step: 1, summary: "Returns the string values of one or more keys.", handler: (*Server).handleMGet},
+		{name: "MSET", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 2, summary: "Sets the string values of one or more keys.", handler: (*Server).handleMSet},
+
+		// generic
+		{name: "DEL", arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1, summary: "Deletes one or more keys.", handler: (*Server).handleDel},
+		{name: "UNLINK", arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, step: 1, summary: "Deletes one or more keys.", handler: (*Server).handleDel},
+		{name: "TIME", arity: 1, flags: flagFast, summary: "Returns the server time.", handler: (*Server).handleTime},
+		{name: "DBSIZE", arity: 1, flags: flagReadOnly | flagFast, summary: "Returns the number of keys in the database.", handler: (*Server).handleDBSize},
+		{name: "FLUSHALL", arity: -1, flags: flagWrite, summary: "Removes all keys from all databases.", handler: (*Server).handleFlushAll},
+		{name: "FLUSHDB", arity: -1, flags: flagWrite, summary: "Removes all keys from the current database.", handler: (*Server).handleFlushDB},
+		{name: "SELECT", arity: 2, flags: flagFast, summary: "Changes the selected database.", handler: (*Server).handleSelect},
+		{name: "SWAPDB", arity: 3, flags: flagWrite, summary: "Swaps two databases.", handler: (*Server).handleSwapDB},
+		{name: "KEYS", arity: 2, flags: flagReadOnly, summary: "Returns all key names that match a pattern.", handler: (*Server).handleKeys},
+		{name: "SCAN", arity: -2, flags: flagReadOnly, summary: "Iterates over the key names in the database.", handler: (*Server).handleScan},
+		{name: "TIER", arity: -2, summary: "Pins keys in memory and reports the tier of keys under the tiered storage engine.", handler: (*Server).handleTier},
+		{name: "TYPESTATS", arity: 1, flags: flagReadOnly, summary: "Counts the keys of each type.", handler: (*Server).handleTypeStats},
+		{name: "TTLSTATS", arity: 1, flags: flagReadOnly, summary: "Reports a histogram of remaining TTLs and the keys expiring in each of the next 60 minutes.", handler: (*Server).handleTTLStats},
+		{name: "EXISTS", arity: -2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: -1, step: 1, summary: "Determines whether one or more keys exist.", handler: (*Server).handleExists},
+		{name: "TYPE", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Determines the type of value stored at a key.", handler: (*Server).handleType},
+		{name: "OBJECT", arity: -2, flags: flagReadOnly, firstKey: 2, lastKey: 2, step: 1, summary: "Inspects the internal encoding, idle time and access frequency of a key.", handler: (*Server).handleObject},
+		{name: "RANDOMKEY", arity: 1, flags: flagReadOnly, summary: "Returns a random key name from the database.", handler: (*Server).handleRandomKey},
+		{name: "RENAME", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 2, step: 1, summary: "Renames a key and overwrites the destination.", handler: (*Server).handleRename},
+		{name: "RENAMENX", arity: 3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 2, step: 1, summary: "Renames a key only when the target key name doesn't exist.", handler: (*Server).handleRename},
+		{name: "COPY", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, step: 1, summary: "Copies the value of a key to a new key.", handler: (*Server).handleCopy},
+		{name: "DUMP", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns a serialized representation of the value stored at a key.", handler: (*Server).handleDump},
+		{name: "RESTORE", arity: -4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Creates a key from the serialized representation of a value.", handler: (*Server).handleRestore},
+		{name: "MIGRATE", arity: -6, flags: flagWrite, firstKey: 3, lastKey: 3, step: 1, summary: "Atomically transfers a key from one Redis instance to another.", handler: (*Server).handleMigrate, rewrite: rewriteMigrate},
+		{name: "TTL", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the remaining time to live of a key in seconds.", handler: (*Server).handleTTL},
+
+		// sets
+		{name: "SADD", arity: -3, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Adds one or more members to a set.", handler: (*Server).handleSAdd},
+		{name: "SREM", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Removes one or more members from a set.", handler: (*Server).handleSRem},
+		{name: "SMEMBERS", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns all members of a set.", handler: (*Server).handleSMembers},
+		{name: "SCARD", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the number of members in a set.", handler: (*Server).handleSCard},
+		{name: "SPOP", arity: -2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Removes and returns one or more random members from a set.", handler: (*Server).handleSPop, rewrite: rewriteSPop},
+		{name: "SUNION", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: -1, step: 1, summary: "Returns the union of multiple sets.", handler: (*Server).handleSUnion},
+		{name: "SINTER", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: -1, step: 1, summary: "Returns the intersection of multiple sets.", handler: (*Server).handleSInter},
+		{name: "SDIFF", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: -1, step: 1, summary: "Returns the difference of multiple sets.", handler: (*Server).handleSDiff},
+		{name: "SUNIONSTORE", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 1, summary: "Stores the union of multiple sets in a key.", handler: (*Server).handleSUnionStore},
+		{name: "SINTERSTORE", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 1, summary: "Stores the intersection of multiple sets in a key.", handler: (*Server).handleSInterStore},
+		{name: "SDIFFSTORE", arity: -3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: -1, step: 1, summary: "Stores the difference of multiple sets in a key.", handler: (*Server).handleSDiffStore},
+		{name: "SISMEMBER", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Determines whether a member belongs to a set.", handler: (*Server).handleSIsMember},
+		{name: "SRANDMEMBER", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns one or more random members from a set.", handler: (*Server).handleSRandMember},
+		{name: "SAMPLE", arity: -3, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns a random sample of members from a set or sorted set, optionally weighted by score.", handler: (*Server).handleSample},
+
+		// hashes
+		{name: "HSET", arity: -4, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Creates or modifies the value of fields in a hash.", handler: (*Server).handleHSet},
+		{name: "HGET", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the value of a field in a hash.", handler: (*Server).handleHGet},
+		{name: "HDEL", arity: -3, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Deletes one or more fields from a hash.", handler: (*Server).handleHDel},
+		{name: "HGETALL", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns all fields and values in a hash.", handler: (*Server).handleHGetAll},
+		{name: "HMGET", arity: -3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the values of multiple fields in a hash.", handler: (*Server).handleHMGet},
+		{name: "HEXISTS", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Determines whether a field exists in a hash.", handler: (*Server).handleHExists},
+		{name: "HLEN", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the number of fields in a hash.", handler: (*Server).handleHLen},
+		{name: "HKEYS", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns all fields in a hash.", handler: (*Server).handleHKeys},
+		{name: "HVALS", arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns all values in a hash.", handler: (*Server).handleHVals},
+		{name: "HINCRBY", arity: 4, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Increments the integer value of a field in a hash.", handler: (*Server).handleHIncrBy},
+		{name: "HINCRBYFLOAT", arity: 4, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Increments the floating point value of a field in a hash.", handler: (*Server).handleHIncrByFloat},
+
+		// probabilistic
+		{name: "CMSINCR", arity: 4, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Increments an item's count in a Count-Min Sketch.", handler: (*Server).handleCMSIncr},
+		{name: "CMSQUERY", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns an item's estimated count in a Count-Min Sketch.", handler: (*Server).handleCMSQuery},
+		{name: "BFADD", arity: 3, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Adds an item to a Bloom filter.", handler: (*Server).handleBFAdd},
+		{name: "BFEXISTS", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Checks whether an item may exist in a Bloom filter.", handler: (*Server).handleBFExists},
+		{name: "BF.RESERVE", arity: -4, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Creates a Bloom filter with a given error rate and capacity.", handler: (*Server).handleBFReserve},
+		{name: "BF.MADD", arity: -3, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Adds one or more items to a Bloom filter.", handler: (*Server).handleBFMAdd},
+		{name: "BF.MEXISTS", arity: -3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Checks whether one or more items may exist in a Bloom filter.", handler: (*Server).handleBFMExists},
+		{name: "BF.INFO", arity: -2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the capacity, size, layers and item count of a Bloom filter.", handler: (*Server).handleBFInfo},
+
+		// lists
+		{name: "LPUSH", arity: -3, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Prepends one or more elements to a list.", handler: (*Server).handleLPush},
+		{name: "RPUSH", arity: -3, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Appends one or more elements to a list.", handler: (*Server).handleRPush},
+		{name: "LPOP", arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Removes and returns the first element of a list.", handler: (*Server).handleLPop},
+		{name: "RPOP", arity: 2, flags: flagWrite | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Removes and returns the last element of a list.", handler: (*Server).handleRPop},
+		{name: "LMOVE", arity: 5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, step: 1, summary: "Pops an element from one list and pushes it onto another.", handler: (*Server).handleLMove},
+		{name: "RPOPLPUSH", arity: 3, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 2, step: 1, summary: "Pops the last element of a list and pushes it onto the head of another.", handler: (*Server).handleRPopLPush},
+		{name: "BLMOVE", arity: 6, flags: flagWrite | flagDenyOOM | flagBlocking, firstKey: 1, lastKey: 2, step: 1, summary: "Pops an element from one list and pushes it onto another, blocking until one is available.", handler: (*Server).handleBLMove},
+		{name: "BRPOPLPUSH", arity: 4, flags: flagWrite | flagDenyOOM | flagBlocking, firstKey: 1, lastKey: 2, step: 1, summary: "Pops the last element of a list and pushes it onto another, blocking until one is available.", handler: (*Server).handleBRPopLPush},
+		{name: "LLEN", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the length of a list.", handler: (*Server).handleLLen},
+		{name: "LRANGE", arity: 4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns a range of elements from a list.", handler: (*Server).handleLRange},
+		{name: "LINSERT", arity: 5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Inserts an element before or after another element in a list.", handler: (*Server).handleLInsert},
+		{name: "LSET", arity: 4, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Sets the value of an element in a list by its index.", handler: (*Server).handleLSet},
+		{name: "LREM", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1, summary: "Removes elements from a list.", handler: (*Server).handleLRem},
+		{name: "LTRIM", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1, summary: "Removes elements from both ends of a list.", handler: (*Server).handleLTrim},
+		{name: "LPOS", arity: -3, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the index of matching elements in a list.", handler: (*Server).handleLPos},
+		{name: "LMPOP", arity: -4, flags: flagWrite, firstKey: 2, keyNum: 1, summary: "Returns multiple elements from a list after removing them.", handler: (*Server).handleLMPop},
+
+		// sorted sets
+		{name: "ZADD", arity: -4, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Adds members to a sorted set, or updates their scores.", handler: (*Server).handleZAdd},
+		{name: "ZINCRBY", arity: 4, flags: flagWrite | flagFast | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Increments the score of a member in a sorted set.", handler: (*Server).handleZIncrBy},
+		{name: "ZSCORE", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the score of a member in a sorted set.", handler: (*Server).handleZScore},
+		{name: "ZCARD", arity: 2, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the number of members in a sorted set.", handler: (*Server).handleZCard},
+		{name: "ZRANK", arity: 3, flags: flagReadOnly | flagFast, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the index of a member in a sorted set ordered by score.", handler: (*Server).handleZRank},
+		{name: "ZRANGE", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns members in a sorted set within a range of indexes.", handler: (*Server).handleZRange},
+
+		// geo, sorted sets scored by geohash
+		{name: "GEOADD", arity: -5, flags: flagWrite | flagDenyOOM, firstKey: 1, lastKey: 1, step: 1, summary: "Adds members to a geospatial index, or updates their positions.", handler: (*Server).handleGeoAdd},
+		{name: "GEOPOS", arity: -2, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the longitude and latitude of members of a geospatial index.", handler: (*Server).handleGeoPos},
+		{name: "GEODIST", arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the distance between two members of a geospatial index.", handler: (*Server).handleGeoDist},
+		{name: "GEOSEARCH", arity: -7, flags: flagReadOnly, firstKey: 1, lastKey: 1, step: 1, summary: "Returns the members of a geospatial index inside a circle or box.", handler: (*Server).handleGeoSearch},
+
+		// cluster topology
+		{name: "BROADCAST", arity: -2, flags: flagAdmin, summary: "Runs a command on every shard and returns each shard's reply.", handler: (*Server).handleBroadcast},
+		{name: "ADDNODE", arity: 2, flags: flagAdmin, summary: "Adds a shard to the hash ring and moves its slots to it in the background.", handler: (*Server).handleAddNode},
+		{name: "REMOVENODE", arity: 2, flags: flagAdmin, summary: "Moves a shard's slots away and removes it from the hash ring.", handler: (*Server).handleRemoveNode},
+		{name: "MIGRATION", arity: 2, flags: flagAdmin, summary: "Reports resharding progress per node, or pauses, resumes or cancels it.", handler: (*Server).handleMigration},
+		{name: "RESHARD", arity: 2, flags: flagAdmin, summary: "Reports resharding progress, or moves the slots not on the node the ring places them on.", handler: (*Server).handleReshard},
+
+		// pub/sub
+		{name: "SUBSCRIBE", arity: -2, flags: flagPubSub, summary: "Listens for messages published to channels.", handler: (*Server).handleSubscribe},
+		{name: "UNSUBSCRIBE", arity: -1, flags: flagPubSub, summary: "Stops listening to messages posted to channels.", handler: (*Server).handleUnsubscribe},
+		{name: "PSUBSCRIBE", arity: -2, flags: flagPubSub, summary: "Listens for messages published to channels that match one or more patterns.", handler: (*Server).handlePSubscribe},
+		{name: "PUNSUBSCRIBE", arity: -1, flags: flagPubSub, summary: "Stops listening to messages published to channels that match one or more patterns.", handler: (*Server).handlePUnsubscribe},
+		{name: "PUBSUB", arity: -2, flags: flagPubSub, summary: "Inspects the state of the Pub/Sub subsystem.", handler: (*Server).handlePubSub},
+		{name: "PUBLISH", arity: 3, flags: flagPubSub | flagFast, summary: "Posts a message to a channel.", handler: (*Server).handlePublish},
+	} {
+		registerCommand(cmd)
+	}
+}
+
+// dispatch looks up the command table entry for name, checks its arity and
+// runs it. Handlers get the arguments as strings, see argv; one that panics
+// fails its command rather than the server.
+func (s *Server) dispatch(c net.Conn, name string, args []string) {
+	cmd, ok := s.lookupCommand(name)
+	if !ok {
+		s.requestError(c, "ERR unknown command '"+name+"'")
+		return
+	}
+	if len(args) > 0 && !strings.EqualFold(name, cmd.name) {
+		args[0] = cmd.name // an alias
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: panic running %s: %v\n%s", cmd.name, r, debug.Stack())
+			s.reply(c, protocol.Error("ERR internal error running '"+strings.ToLower(cmd.name)+"'"))
+		}
+	}()
+	args, token := splitConfirm(cmd, args)
+	if !cmd.checkArity(len(args)) {
+		s.requestError(c, "ERR wrong number of arguments for '"+strings.ToLower(cmd.name)+"' command")
+		return
+	}
+	if cl := s.client(c); cl != nil {
+		cl.lastCmd.Store(strings.ToLower(cmd.name))
+		cl.lastActive.Store(time.Now().UnixNano())
+		if msg := s.checkACL(cl, cmd, args); msg != "" {
+			s.requestError(c, msg)
+			return
+		}
+		if !isPrimaryConn(c) {
+			if msg := s.checkPolicy(cl, cmd); msg != "" {
+				s.requestError(c, msg)
+				return
+			}
+			if s.memoryRejected(cmd) {
+				s.requestError(c, "OOM command not allowed while the server is short of memory")
+				return
+			}
+		}
+		if msg := s.checkQuota(cl, cmd, args); msg != "" {
+			s.requestError(c, msg)
+			return
+		}
+		s.recordCommand(cl, cmd, args)
+	}
+	if !subscribeContextCommands[cmd.name] && s.inSubscribeContext(c) {
+		s.reply(c, protocol.Error("ERR Can't execute '"+strings.ToLower(cmd.name)+"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context"))
+		return
+	}
+	if !s.confirmed(c, cmd, args, token) {
+		return
+	}
+	if !txExempt[cmd.name] && s.inMulti(c) {
+		s.queue(c, cmd, args)
+		return
+	}
+	s.totalCommands.Add(1)
+	if cmd.name != "ASKING" {
+		if cl := s.client(c); cl != nil {
+			// ASKING covers only the command after it
+			defer cl.asking.Store(false)
+		}
+	}
+	args = s.selectDB(c, cmd, args)
+	if cmd.has(flagWrite) {
+		s.write(c, cmd, args)
+		return
+	}
+	cmd.handler(s, c, args)
+}
+
+// argv returns the arguments of a request, or false if one is not a bulk
+// string
+func argv(req protocol.Array) ([]string, bool) {
+	args := make([]string, len(req))
+	for i, a := range req {
+		b, ok := a.(protocol.BulkString)
+		if !ok {
+			return nil, false
+		}
+		args[i] = string(b)
+	}
+	return args, true
+}
+
+// request encodes args as a command is sent, an array of bulk strings
+func request(args []string) protocol.Array {
+	req := make(protocol.Array, len(args))
+	for i, a := range args {
+		req[i] = protocol.BulkString(a)
+	}
+	return req
+}
+
+// subscribeContextCommands are the only commands a RESP2 connection may send
+// while it holds subscriptions, since its replies share the stream with
+// published messages
+var subscribeContextCommands = map[string]bool{
+	"SUBSCRIBE":    true,
+	"UNSUBSCRIBE":  true,
+	"PSUBSCRIBE":   true,
+	"PUNSUBSCRIBE": true,
+	"PING":         true,
+	"QUIT":         true,
+}
+
+// inSubscribeContext reports whether c is a RESP2 connection with at least
+// one subscription. RESP3 clients can tell pushes from replies, so they are
+// never restricted.
+func (s *Server) inSubscribeContext(c net.Conn) bool {
+	cl := s.client(c)
+	return cl != nil && cl.proto.Load() < 3 && cl.subs.active()
+}
+
+// PING [message]
+func (s *Server) handlePing(c net.Conn, args []string) {
+	if len(args) > 2 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'ping' command"))
+		return
+	}
+	msg := protocol.BulkString("")
+	if len(args) == 2 {
+		msg = protocol.BulkString(args[1])
+	}
+	if s.inSubscribeContext(c) {
+		// subscribers get PING replies in the same shape as messages
+		s.reply(c, protocol.Array{protocol.BulkString("pong"), msg})
+		return
+	}
+	if len(args) == 2 {
+		s.reply(c, msg)
+		return
+	}
+	s.reply(c, protocol.SimpleString("PONG"))
+}
+
+// QUIT
+func (s *Server) handleQuit(c net.Conn, args []string) {
+	s.reply(c, protocol.SimpleString("OK"))
+	c.Close()
+}
+
+// COMMAND [COUNT | LIST | INFO name... | DOCS name...]
+func (s *Server) handleCommand(c net.Conn, args []string) {
+	if len(args) == 1 {
+		arr := protocol.Array{}
+		for _, cmd := range sortedCommands() {
+			arr = append(arr, cmd.info())
+		}
+		s.reply(c, arr)
+		return
+	}
+
+	sub := args[1]
+	names := args[2:]
+
+	switch strings.ToUpper(sub) {
+	case "COUNT":
+		s.reply(c, protocol.Integer(len(commandTable)))
+	case "LIST":
+		arr := protocol.Array{}
+		for _, cmd := range sortedCommands() {
+			arr = append(arr, protocol.BulkString(strings.ToLower(cmd.name)))
+		}
+		s.reply(c, arr)
+	case "INFO":
+		arr := protocol.Array{}
+		if len(names) == 0 {
+			for _, cmd := range sortedCommands() {
+				arr = append(arr, cmd.info())
+			}
+		}
+		for _, n := range names {
+			if cmd, ok := s.lookupCommand(n); ok {
+				arr = append(arr, cmd.info())
+			} else {
+				arr = append(arr, protocol.Array(nil))
+			}
+		}
+		s.reply(c, arr)
+	case "DOCS":
+		cmds := []*command{}
+		if len(names) == 0 {
+			cmds = sortedCommands()
+		}
+		for _, n := range names {
+			if cmd, ok := s.lookupCommand(n); ok {
+				cmds = append(cmds, cmd)
+			}
+		}
+		// flat name -> doc pairs, the RESP2 rendering of a map
+		arr := protocol.Array{}
+		for _, cmd := range cmds {
+			arr = append(arr,
+				protocol.BulkString(strings.ToLower(cmd.name)),
+				protocol.Array{
+					protocol.BulkString("summary"), protocol.BulkString(cmd.summary),
+					protocol.BulkString("arity"), protocol.Integer(cmd.arity),
+				})
+		}
+		s.reply(c, arr)
+	default:
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+sub+"'. Try COMMAND HELP."))
+	}
+}
@@ -0,0 +1,122 @@
+package net
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+// CONFIG GET pattern | SET name value [name value ...] | REWRITE
+func (s *Server) handleConfig(c net.Conn, args []string) {
+	sub := args[1]
+	switch strings.ToUpper(sub) {
+	case "GET":
+		if len(args) != 3 {
+			s.reply(c, protocol.Error("ERR wrong number of arguments for 'config|get' command"))
+			return
+		}
+		pattern := args[2]
+		values := s.cfg.Get(pattern)
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		m := protocol.Map{}
+		for _, name := range names {
+			m = append(m, protocol.BulkString(name), protocol.BulkString(values[name]))
+		}
+		s.reply(c, m)
+
+	case "SET":
+		if len(args) < 4 || len(args)%2 != 0 {
+			s.reply(c, protocol.Error("ERR wrong number of arguments for 'config|set' command"))
+			return
+		}
+		changed := map[string]bool{}
+		for i := 2; i < len(args); i += 2 {
+			name := args[i]
+			value := args[i+1]
+			if err := s.cfg.Set(name, value); err != nil {
+				// apply what was already set so the store matches the config
+				s.applyConfig(changed)
+				s.configEvent("CONFIG SET", changed)
+				s.reply(c, protocol.Error("ERR "+err.Error()))
+				return
+			}
+			changed[strings.ToLower(name)] = true
+		}
+		s.applyConfig(changed)
+		s.configEvent("CONFIG SET", changed)
+		s.reply(c, protocol.SimpleString("OK"))
+
+	case "REWRITE":
+		if err := s.cfg.Rewrite(); err != nil {
+			s.reply(c, protocol.Error("ERR "+err.Error()))
+			return
+		}
+		s.reply(c, protocol.SimpleString("OK"))
+
+	default:
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+sub+"'. Try CONFIG GET, CONFIG SET or CONFIG REWRITE."))
+	}
+}
+
+// configWatchLoop re-reads the config file and secret files every
+// config-watch-interval and applies what changed, as CONFIG SET would. A
+// mounted ConfigMap or Secret can be updated this way without a restart.
+func (s *Server) configWatchLoop() {
+	for {
+		var tick <-chan time.Time
+		if d := s.cfg.Snapshot().ConfigWatchInterval; d > 0 {
+			tick = time.After(d)
+		}
+		select {
+		case <-tick:
+			s.reloadConfig()
+		case <-s.configReset:
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// reloadConfig applies changes to the config file and secret files
+func (s *Server) reloadConfig() {
+	changed, skipped, err := s.cfg.Reload()
+	if err != nil {
+		log.Printf("ERROR: Config reload failed: %v", err)
+	}
+	for _, name := range skipped {
+		log.Printf("Config reload: %s changed but only takes effect on restart", name)
+	}
+	if len(changed) == 0 {
+		return
+	}
+	set := make(map[string]bool, len(changed))
+	for _, name := range changed {
+		set[name] = true
+	}
+	s.applyConfig(set)
+	s.configEvent("config reload", set)
+	sort.Strings(changed)
+	log.Printf("Config reload applied %s", strings.Join(changed, ", "))
+}
+
+// configEvent records the parameters changed by source, without their
+// values, which may be secrets
+func (s *Server) configEvent(source string, changed map[string]bool) {
+	if len(changed) == 0 {
+		return
+	}
+	names := make([]string, 0, len(changed))
+	for name := range changed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	s.event(eventConfig, "%s %s", source, strings.Join(names, ", "))
+}
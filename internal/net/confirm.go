@@ -0,0 +1,100 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// destructiveCommands wipe data or shrink the cluster in one call. With
+// confirm-destructive, or CLIENT CONFIRM ON, the first attempt runs nothing:
+// it replies with what the command would do and a token, and the command
+// runs when repeated with CONFIRM <token> appended before the token expires.
+var destructiveCommands = map[string]bool{
+	"FLUSHALL":   true,
+	"FLUSHDB":    true,
+	"REMOVENODE": true,
+}
+
+// pendingConfirm is the token a connection was given for one command line
+type pendingConfirm struct {
+	token   string
+	command string
+	expires time.Time
+}
+
+// splitConfirm removes a trailing CONFIRM <token> from the arguments of a
+// destructive command, returning the token, "" if there is none
+func splitConfirm(cmd *command, args []string) ([]string, string) {
+	n := len(args)
+	if !destructiveCommands[cmd.name] || n < 3 || !strings.EqualFold(args[n-2], "CONFIRM") {
+		return args, ""
+	}
+	return args[:n-2], args[n-1]
+}
+
+// confirmed reports whether a destructive command may run, replying with a
+// CONFIRM error carrying a fresh token when it may not. Commands EXEC runs
+// were confirmed when queued, and the replication stream is never asked.
+func (s *Server) confirmed(c net.Conn, cmd *command, args []string, token string) bool {
+	if !destructiveCommands[cmd.name] {
+		return true
+	}
+	if _, inExec := c.(*txConn); inExec {
+		return true
+	}
+	cl := s.client(c)
+	if cl == nil {
+		return true
+	}
+	cfg := s.cfg.Snapshot()
+	if !cfg.ConfirmDestructive && !cl.confirmDestructive.Load() {
+		return true
+	}
+
+	line := strings.Join(args, "\x00")
+	p := cl.pendingConfirm.Swap(nil) // a token is good for one attempt
+	if token != "" {
+		if p != nil && p.token == token && p.command == line && time.Now().Before(p.expires) {
+			return true
+		}
+		s.requestError(c, "ERR confirmation token is invalid or expired; send the command without CONFIRM for a new one")
+		return false
+	}
+	impact, ok := s.destructiveImpact(c, cmd, args)
+	if !ok {
+		return true // the command fails on its own
+	}
+	p = &pendingConfirm{token: newID()[:16], command: line, expires: time.Now().Add(cfg.ConfirmDestructiveWindow)}
+	cl.pendingConfirm.Store(p)
+	s.requestError(c, fmt.Sprintf("CONFIRM %s; repeat it with CONFIRM %s within %s to proceed", impact, p.token, cfg.ConfirmDestructiveWindow))
+	return false
+}
+
+// destructiveImpact describes what a destructive command would do, false
+// if it would fail instead
+func (s *Server) destructiveImpact(c net.Conn, cmd *command, args []string) (string, bool) {
+	switch cmd.name {
+	case "FLUSHALL":
+		return fmt.Sprintf("FLUSHALL would delete %d keys", s.shards.DBSize()), true
+	case "FLUSHDB":
+		db := s.db(c)
+		if n := len(args); n >= 3 && strings.EqualFold(args[n-2], "DB") {
+			d, err := strconv.Atoi(args[n-1])
+			if err != nil || d < 0 {
+				return "", false
+			}
+			db = d
+		}
+		return fmt.Sprintf("FLUSHDB would delete %d keys of database %d", s.shards.DBKeys(db), db), true
+	case "REMOVENODE":
+		sh, ok := s.shards.GetShardByNodeID(args[1])
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("REMOVENODE would move %d keys off %s and take it out of the ring", sh.Store.DBSize(), args[1]), true
+	}
+	return "", false
+}
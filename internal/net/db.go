@@ -0,0 +1,126 @@
+package net
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// db is the database c has selected; replicated commands run in database 0,
+// since their keys already carry the prefix of the database they were sent in
+func (s *Server) db(c net.Conn) int {
+	if cl := s.client(c); cl != nil {
+		return int(cl.db.Load())
+	}
+	return 0
+}
+
+// dbKey is the stored name of key in the database c has selected
+func (s *Server) dbKey(c net.Conn, key string) string {
+	return store.DBKey(s.db(c), key)
+}
+
+// selectDB maps a command from a connection in database n > 0 onto the
+// shared keyspace: its keys get the database prefix, and FLUSHDB names the
+// database so a replica flushes the same one
+func (s *Server) selectDB(c net.Conn, cmd *command, args []string) []string {
+	db := s.db(c)
+	if db == 0 {
+		return args
+	}
+	if cmd.name == "FLUSHDB" {
+		return append(args[:len(args):len(args)], "DB", strconv.Itoa(db))
+	}
+	first, last, step := cmd.keyRange(args)
+	if last < first {
+		return args
+	}
+	out := make([]string, len(args))
+	copy(out, args)
+	for i := first; i <= last; i += step {
+		out[i] = store.DBKey(db, args[i])
+	}
+	return out
+}
+
+// parseDB reads a database index in [0, databases)
+func (s *Server) parseDB(arg string) (int, string) {
+	db, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, "ERR value is not an integer or out of range"
+	}
+	if db < 0 || db >= max(s.cfg.Snapshot().Databases, 1) {
+		return 0, "ERR DB index is out of range"
+	}
+	return db, ""
+}
+
+// SELECT index
+func (s *Server) handleSelect(c net.Conn, args []string) {
+	db, msg := s.parseDB(args[1])
+	if msg != "" {
+		s.reply(c, protocol.Error(msg))
+		return
+	}
+	if db != 0 && s.cfg.Snapshot().ClusterEnabled {
+		s.reply(c, protocol.Error("ERR SELECT is not allowed in cluster mode"))
+		return
+	}
+	if cl := s.client(c); cl != nil {
+		cl.db.Store(int32(db))
+	}
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// SWAPDB index1 index2. Keys move one at a time, so commands running
+// meanwhile may see some keys swapped and others not yet.
+func (s *Server) handleSwapDB(c net.Conn, args []string) {
+	a, msg := s.parseDB(args[1])
+	if msg == "" {
+		var b int
+		if b, msg = s.parseDB(args[2]); msg == "" {
+			if s.cfg.Snapshot().ClusterEnabled {
+				msg = "ERR SWAPDB is not allowed in cluster mode"
+			} else {
+				s.shards.SwapDB(a, b)
+				s.touchAllWatched()
+			}
+		}
+	}
+	if msg != "" {
+		s.reply(c, protocol.Error(msg))
+		return
+	}
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// FLUSHDB [ASYNC | SYNC]; selectDB appends DB index for databases past 0.
+// It flushes synchronously.
+func (s *Server) handleFlushDB(c net.Conn, args []string) {
+	db := 0
+	rest := args[1:]
+	if n := len(rest); n >= 2 && strings.EqualFold(rest[n-2], "DB") {
+		d, err := strconv.Atoi(rest[n-1])
+		if err != nil || d < 0 {
+			s.reply(c, protocol.Error("ERR DB index is out of range"))
+			return
+		}
+		db, rest = d, rest[:n-2]
+	}
+	if len(rest) > 1 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	if len(rest) == 1 {
+		if m := strings.ToUpper(rest[0]); m != "ASYNC" && m != "SYNC" {
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+	}
+	s.shards.FlushDB(db)
+	s.touchAllWatched()
+	s.reply(c, protocol.SimpleString("OK"))
+}
@@ -0,0 +1,132 @@
+package net
+
+import (
+	"encoding/hex"
+	"net"
+	"sort"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// DEBUG DIGEST | DIGEST-SHARDS | DIGEST-VALUE key [key ...] | OWNERSHIP-AUDIT [count] |
+// STRINGMATCH-LEN pattern string | CHANGE-REPL-ID | PROTOCOL type
+func (s *Server) handleDebug(c net.Conn, args []string) {
+	sub := args[1]
+	switch strings.ToUpper(sub) {
+	case "DIGEST":
+		d := s.shards.Digest()
+		s.reply(c, protocol.SimpleString(hex.EncodeToString(d[:])))
+
+	case "DIGEST-SHARDS":
+		digests := s.shards.Digests()
+		nodes := make([]string, 0, len(digests))
+		for id := range digests {
+			nodes = append(nodes, id)
+		}
+		sort.Strings(nodes)
+		m := protocol.Map{}
+		for _, id := range nodes {
+			d := digests[id]
+			m = append(m, protocol.BulkString(id), protocol.BulkString(hex.EncodeToString(d[:])))
+		}
+		s.reply(c, m)
+
+	case "DIGEST-VALUE":
+		arr := protocol.Array{}
+		for _, key := range args[2:] {
+			d, _ := s.shards.DigestKey(s.dbKey(c, key)) // zeros for a missing key
+			arr = append(arr, protocol.SimpleString(hex.EncodeToString(d[:])))
+		}
+		s.reply(c, arr)
+
+	case "OWNERSHIP-AUDIT":
+		s.debugOwnershipAudit(c, args)
+
+	case "STRINGMATCH-LEN":
+		if len(args) != 4 {
+			s.reply(c, protocol.Error("ERR wrong number of arguments for 'debug|stringmatch-len' command"))
+			return
+		}
+		pattern := args[2]
+		subject := args[3]
+		match, steps, limited := store.GlobSteps(pattern, subject)
+		s.reply(c, protocol.Map{
+			protocol.BulkString("match"), protocol.Integer(boolInt(match)),
+			protocol.BulkString("steps"), protocol.Integer(steps),
+			protocol.BulkString("limited"), protocol.Integer(boolInt(limited)),
+			protocol.BulkString("accepted"), protocol.Integer(boolInt(store.CheckGlob(pattern) == nil)),
+		})
+
+	case "CHANGE-REPL-ID":
+		s.repl.mu.Lock()
+		s.changeReplID(false)
+		s.repl.mu.Unlock()
+		s.reply(c, protocol.SimpleString("OK"))
+
+	case "PROTOCOL":
+		if len(args) != 3 {
+			s.reply(c, protocol.Error("ERR wrong number of arguments for 'debug|protocol' command"))
+			return
+		}
+		typ := args[2]
+		v, ok := debugProtocolReplies[strings.ToLower(typ)]
+		if !ok {
+			s.reply(c, protocol.Error("ERR unknown type '"+typ+"'. Try "+debugProtocolTypes()+"."))
+			return
+		}
+		s.reply(c, v)
+
+	default:
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+sub+"'. Try DEBUG DIGEST, DEBUG DIGEST-SHARDS, DEBUG DIGEST-VALUE, DEBUG OWNERSHIP-AUDIT, DEBUG STRINGMATCH-LEN, DEBUG CHANGE-REPL-ID or DEBUG PROTOCOL."))
+	}
+}
+
+// debugProtocolReplies are the replies of DEBUG PROTOCOL, one of each
+// type the server can send, for client authors to test their parsers on.
+// The shapes match Redis's where it has the type. RESP2 connections get
+// each RESP3 type as its RESP2 stand-in.
+var debugProtocolReplies = map[string]protocol.RESPType{
+	"string":    protocol.BulkString("Hello World"),
+	"simple":    protocol.SimpleString("OK"),
+	"error":     protocol.Error("ERR an error reply, for parser tests"),
+	"integer":   protocol.Integer(12345),
+	"double":    protocol.Double(3.141),
+	"bignum":    protocol.BigNumber("1234567999999999999999999999999999999"),
+	"null":      protocol.Null{},
+	"nullarray": protocol.Array(nil),
+	"array":     protocol.Array{protocol.Integer(0), protocol.Integer(1), protocol.Integer(2)},
+	"nested": protocol.Array{
+		protocol.Integer(0),
+		protocol.Array{protocol.BulkString("a"), protocol.Array{protocol.Double(1.5), protocol.Null{}}},
+		protocol.Map{protocol.BulkString("k"), protocol.Set{protocol.Boolean(true)}},
+		protocol.Array{},
+	},
+	"set": protocol.Set{protocol.Integer(0), protocol.Integer(1), protocol.Integer(2)},
+	"map": protocol.Map{
+		protocol.Integer(0), protocol.Boolean(false),
+		protocol.Integer(1), protocol.Boolean(true),
+		protocol.Integer(2), protocol.Boolean(false),
+	},
+	"attrib": protocol.Attribute{
+		Attrs: protocol.Map{
+			protocol.BulkString("key-popularity"),
+			protocol.Array{protocol.BulkString("key:123"), protocol.Integer(90)},
+		},
+		Value: protocol.BulkString("Some real reply following the attribute"),
+	},
+	"push":     protocol.Push{protocol.BulkString("server-cpu-usage"), protocol.Integer(42)},
+	"verbatim": protocol.VerbatimString{Format: "txt", Text: "This is a verbatim\nstring"},
+	"true":     protocol.Boolean(true),
+	"false":    protocol.Boolean(false),
+}
+
+func debugProtocolTypes() string {
+	types := make([]string, 0, len(debugProtocolReplies))
+	for t := range debugProtocolReplies {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
@@ -0,0 +1,225 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+// The event log keeps the last event-log-max-len significant server events,
+// shards joining and leaving, virtual node reweighting, migrations,
+// replication role changes, OOM
+// refusals, memory pressure tiers, config changes and command anomalies, so operators can ask the server what
+// happened with EVENTS instead of scraping its text log. INFO events counts
+// every event of each kind since startup, including those the log dropped.
+
+// event kinds
+const (
+	eventNodeAdded         = "node_added"
+	eventNodeRemoved       = "node_removed"
+	eventVNodes            = "vnodes_changed"
+	eventMigrationStarted  = "migration_started"
+	eventMigrationFinished = "migration_finished"
+	eventMigrationFailed   = "migration_failed"
+	eventMigrationPaused   = "migration_paused"
+	eventMigrationResumed  = "migration_resumed"
+	eventReplicaOf         = "replicaof"
+	eventFailover          = "failover"
+	eventOOM               = "oom"
+	eventMemoryTier        = "memory_tier"
+	eventConfig            = "config_changed"
+	eventAnomaly           = "anomaly"
+)
+
+// eventKinds are listed in the order INFO events prints them
+var eventKinds = []string{
+	eventNodeAdded, eventNodeRemoved, eventVNodes,
+	eventMigrationStarted, eventMigrationFinished, eventMigrationFailed,
+	eventMigrationPaused, eventMigrationResumed,
+	eventReplicaOf, eventFailover, eventOOM, eventMemoryTier, eventConfig,
+	eventAnomaly,
+}
+
+// eventOOMInterval spaces out the OOM entries of the log: a full server
+// refuses every write, and one entry says so as well as thousands
+const eventOOMInterval = 10 * time.Second
+
+type serverEvent struct {
+	id     uint64
+	time   time.Time
+	kind   string
+	detail string
+}
+
+type eventLog struct {
+	mu      sync.Mutex
+	events  []serverEvent // oldest first
+	maxLen  int
+	nextID  uint64
+	counts  map[string]uint64
+	lastOOM time.Time
+	oomHeld int // OOM refusals since the last OOM entry
+}
+
+func newEventLog(maxLen int) eventLog {
+	return eventLog{maxLen: maxLen, counts: make(map[string]uint64)}
+}
+
+// event records an event of kind, its detail formatted as by fmt.Sprintf
+func (s *Server) event(kind, format string, args ...interface{}) {
+	s.events.add(kind, fmt.Sprintf(format, args...))
+}
+
+// oomEvent records a write refused over maxmemory, at most one entry every
+// eventOOMInterval
+func (s *Server) oomEvent(cmd, key string) {
+	l := &s.events
+	l.mu.Lock()
+	now := time.Now()
+	if now.Sub(l.lastOOM) < eventOOMInterval {
+		l.counts[eventOOM]++
+		l.oomHeld++
+		l.mu.Unlock()
+		return
+	}
+	held := l.oomHeld
+	l.lastOOM, l.oomHeld = now, 0
+	l.mu.Unlock()
+
+	detail := fmt.Sprintf("%s %s refused over maxmemory", cmd, key)
+	if held > 0 {
+		detail += fmt.Sprintf(", %d more refusals since the last entry", held)
+	}
+	l.add(eventOOM, detail)
+}
+
+func (l *eventLog) add(kind, detail string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	l.counts[kind]++
+	if l.maxLen <= 0 {
+		return
+	}
+	l.events = append(l.events, serverEvent{id: l.nextID, time: time.Now(), kind: kind, detail: detail})
+	if over := len(l.events) - l.maxLen; over > 0 {
+		l.events = append(l.events[:0], l.events[over:]...)
+	}
+}
+
+func (l *eventLog) setMaxLen(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxLen = n
+	if over := len(l.events) - max(n, 0); over > 0 {
+		l.events = append(l.events[:0], l.events[over:]...)
+	}
+}
+
+// query returns up to count events newer than since, of kind if it is not
+// empty, newest first. count <= 0 means all.
+func (l *eventLog) query(count int, kind string, since uint64) []serverEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var out []serverEvent
+	for i := len(l.events) - 1; i >= 0; i-- {
+		e := l.events[i]
+		if e.id <= since || count > 0 && len(out) == count {
+			break
+		}
+		if kind == "" || e.kind == kind {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+func (l *eventLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = nil
+}
+
+// EVENTS [COUNT count] [TYPE kind] [SINCE id] | RESET | KINDS
+//
+// Events come newest first, each as a map of id, time in unix
+// milliseconds, type and detail. SINCE returns only events after id, so a
+// poller can pass the last id it saw.
+func (s *Server) handleEvents(c net.Conn, args []string) {
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case "RESET":
+			s.events.reset()
+			s.reply(c, protocol.SimpleString("OK"))
+			return
+		case "KINDS":
+			out := make(protocol.Array, len(eventKinds))
+			for i, k := range eventKinds {
+				out[i] = protocol.BulkString(k)
+			}
+			s.reply(c, out)
+			return
+		}
+	}
+
+	count, kind, since := 0, "", uint64(0)
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+		var err error
+		switch strings.ToUpper(args[i]) {
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err == nil && count < 0 {
+				err = strconv.ErrRange
+			}
+		case "TYPE":
+			kind = strings.ToLower(args[i+1])
+		case "SINCE":
+			since, err = strconv.ParseUint(args[i+1], 10, 64)
+		default:
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+		if err != nil {
+			s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+			return
+		}
+	}
+
+	events := s.events.query(count, kind, since)
+	out := make(protocol.Array, len(events))
+	for i, e := range events {
+		out[i] = protocol.Map{
+			protocol.BulkString("id"), protocol.Integer(e.id),
+			protocol.BulkString("time"), protocol.Integer(e.time.UnixMilli()),
+			protocol.BulkString("type"), protocol.BulkString(e.kind),
+			protocol.BulkString("detail"), protocol.BulkString(e.detail),
+		}
+	}
+	s.reply(c, out)
+}
+
+// infoEvents counts events by kind since startup
+func (s *Server) infoEvents() []string {
+	l := &s.events
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total uint64
+	lines := []string{}
+	for _, k := range eventKinds {
+		total += l.counts[k]
+		lines = append(lines, fmt.Sprintf("events_%s:%d", k, l.counts[k]))
+	}
+	return append([]string{
+		fmt.Sprintf("events_total:%d", total),
+		fmt.Sprintf("events_logged:%d", len(l.events)),
+	}, lines...)
+}
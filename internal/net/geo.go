@@ -0,0 +1,111 @@
+package net
+
+import (
+	"net"
+	"strconv"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [...]
+func (s *Server) handleGeoAdd(c net.Conn, args []string) {
+	if _, err := store.ParseGeoAdd(args[2:]); err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	switch v := s.execute(c, "GEOADD", args[1], args[2:]...).(type) {
+	case int:
+		s.reply(c, protocol.Integer(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.Integer(0))
+	}
+}
+
+// GEOPOS key member [member ...]
+func (s *Server) handleGeoPos(c net.Conn, args []string) {
+	res := s.execute(c, "GEOPOS", args[1], args[2:]...)
+	if err, ok := res.(error); ok {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	pos, _ := res.([]*store.GeoPoint)
+	out := make(protocol.Array, len(args)-2)
+	for i := range out {
+		if i >= len(pos) || pos[i] == nil {
+			out[i] = protocol.Array(nil)
+			continue
+		}
+		out[i] = geoCoords(*pos[i])
+	}
+	s.reply(c, out)
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func (s *Server) handleGeoDist(c net.Conn, args []string) {
+	if len(args) > 5 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	unit := 1.0
+	if len(args) == 5 {
+		var err error
+		if unit, err = store.GeoUnit(args[4]); err != nil {
+			s.reply(c, protocol.Error(err.Error()))
+			return
+		}
+	}
+	switch v := s.execute(c, "GEODIST", args[1], args[2], args[3]).(type) {
+	case float64:
+		s.reply(c, protocol.BulkString(strconv.FormatFloat(v/unit, 'f', 4, 64)))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.BulkString(nil))
+	}
+}
+
+// GEOSEARCH key FROMMEMBER member | FROMLONLAT longitude latitude
+// BYRADIUS radius unit | BYBOX width height unit [ASC|DESC]
+// [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func (s *Server) handleGeoSearch(c net.Conn, args []string) {
+	q, err := store.ParseGeoSearch(args[2:])
+	if err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	res := s.execute(c, "GEOSEARCH", args[1], args[2:]...)
+	if err, ok := res.(error); ok {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	matches, _ := res.([]store.GeoMatch)
+	out := make(protocol.Array, len(matches))
+	for i, m := range matches {
+		if !q.WithDist && !q.WithHash && !q.WithCoord {
+			out[i] = protocol.BulkString(m.Member)
+			continue
+		}
+		item := protocol.Array{protocol.BulkString(m.Member)}
+		if q.WithDist {
+			item = append(item, protocol.BulkString(strconv.FormatFloat(m.Dist, 'f', 4, 64)))
+		}
+		if q.WithHash {
+			item = append(item, protocol.Integer(m.Hash))
+		}
+		if q.WithCoord {
+			item = append(item, geoCoords(m.GeoPoint))
+		}
+		out[i] = item
+	}
+	s.reply(c, out)
+}
+
+func geoCoords(p store.GeoPoint) protocol.Array {
+	return protocol.Array{
+		protocol.BulkString(strconv.FormatFloat(p.Lon, 'g', 17, 64)),
+		protocol.BulkString(strconv.FormatFloat(p.Lat, 'g', 17, 64)),
+	}
+}
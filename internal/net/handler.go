@@ -2,294 +2,470 @@ package net
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"multithreaded-redis/internal/protocol"
 	"multithreaded-redis/internal/store"
 	"net"
 	"strconv"
-	"time"
+	"strings"
 )
 
-// Handle SET command with optional expiration
-func (s *Server) handleSET(c net.Conn, args protocol.Array) {
+// Handle SET command: SET key value [NX | XX] [GET] [EX | PX | EXAT | PXAT | KEEPTTL]
+func (s *Server) handleSET(c net.Conn, args []string) {
 	if len(args) < 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SET' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SET' command"))
 		return
 	}
 
-	key, _ := args[1].(protocol.BulkString)
-	val, _ := args[2].(protocol.BulkString)
+	key := args[1]
+	val := args[2]
 
-	expire := time.Duration(0)
+	tokens := args[3:]
+	// validate up front so syntax errors never reach the shard
+	opts, err := store.ParseSetOptions(tokens, s.shards.Now())
+	if err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
 
-	//Optional EX argument
-	if len(args) == 5 {
-		opt, _ := args[3].(protocol.BulkString)
-		if string(opt) == "EX" {
-			secs, err := strconv.Atoi(string(args[4].(protocol.BulkString)))
-			if err != nil {
-				c.Write([]byte(protocol.Encode(protocol.Error("ERR invalid expire time in 'SET' command"))))
-				return
-			}
-			expire = time.Duration(secs) * time.Second
+	res := s.execute(c, "SET", key, append([]string{val}, tokens...)...)
+	switch v := res.(type) {
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	case []byte:
+		// GET option: old value, or nil if the key did not exist
+		s.reply(c, protocol.BulkString(v))
+	case string:
+		if opts.Get {
+			s.reply(c, protocol.BulkString(nil))
+			return
 		}
+		s.reply(c, protocol.SimpleString(v))
+	default:
+		// NX/XX condition not met
+		s.reply(c, protocol.BulkString(nil))
 	}
-
-	s.shards.Set(string(key), []byte(val), expire)
-	c.Write([]byte(protocol.Encode(protocol.SimpleString("OK"))))
 }
 
 // Handle GET command
-func (s *Server) handleGET(c net.Conn, args protocol.Array) {
+func (s *Server) handleGET(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'GET' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'GET' command"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	val, ok := s.shards.Get(string(key))
-	if !ok {
-		c.Write([]byte(protocol.Encode(protocol.BulkString(nil))))
+	key := args[1]
+	res := s.execute(c, "GET", key)
+	if err, isErr := res.(error); isErr {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	val, ok := res.([]byte)
+	if !ok || val == nil {
+		s.reply(c, protocol.BulkString(nil))
 		return
 	}
-	c.Write([]byte(protocol.Encode(protocol.BulkString(val))))
+	s.reply(c, protocol.BulkString(val))
 }
 
-// Handle DEL command
-func (s *Server) handleDel(c net.Conn, args protocol.Array) {
+// Handle DEL and UNLINK: every shard deletes its share of the keys in one
+// request, all shards in parallel
+func (s *Server) handleDel(c net.Conn, args []string) {
 	if len(args) < 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'DEL' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'DEL' command"))
 		return
 	}
-	deleted := 0
-	for i := 1; i < len(args); i++ {
-		key, ok := args[i].(protocol.BulkString)
-		if !ok {
-			continue
-		}
-		res := s.shards.Execute("DEL", string(key))
-		if b, ok := res.(bool); ok && b {
-			deleted++
-		}
-	}
-	c.Write([]byte(protocol.Encode(protocol.Integer(deleted))))
+	keys := args[1:]
+	s.reply(c, protocol.Integer(s.shards.DeleteKeys(keys)))
 }
 
 // Handle TTL command
-func (s *Server) handleTTL(c net.Conn, args protocol.Array) {
+func (s *Server) handleTTL(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'TTL' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'TTL' command"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	res := s.shards.Execute("TTL", string(key))
+	key := args[1]
+	res := s.execute(c, "TTL", key)
 	if ttl, ok := res.(int64); ok {
-		c.Write([]byte(protocol.Encode(protocol.Integer(ttl))))
+		s.reply(c, protocol.Integer(ttl))
 	} else {
-		c.Write([]byte(protocol.Encode(protocol.Integer(-2))))
+		s.reply(c, protocol.Integer(-2))
 	}
 }
-func (s *Server) handleSAdd(c net.Conn, args protocol.Array) {
-	if len(args) < 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SADD' command"))))
+
+// APPEND key value
+func (s *Server) handleAppend(c net.Conn, args []string) {
+	if len(args) != 3 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'APPEND' command"))
+		return
+	}
+	key := args[1]
+	value := args[2]
+
+	res := s.execute(c, "APPEND", key, value)
+	switch v := res.(type) {
+	case int:
+		s.reply(c, protocol.Integer(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.Integer(0))
+	}
+}
+
+// STRLEN key
+func (s *Server) handleStrLen(c net.Conn, args []string) {
+	if len(args) != 2 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'STRLEN' command"))
+		return
+	}
+	key := args[1]
+
+	res := s.execute(c, "STRLEN", key)
+	switch v := res.(type) {
+	case int:
+		s.reply(c, protocol.Integer(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.Integer(0))
+	}
+}
+
+// GETRANGE key start end
+func (s *Server) handleGetRange(c net.Conn, args []string) {
+	if len(args) != 4 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'GETRANGE' command"))
+		return
+	}
+	key := args[1]
+	start, err1 := strconv.Atoi(args[2])
+	end, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+		return
+	}
+
+	res := s.execute(c, "GETRANGE", key, fmt.Sprintf("%d", start), fmt.Sprintf("%d", end))
+	switch v := res.(type) {
+	case string:
+		s.reply(c, protocol.BulkString(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.BulkString(""))
+	}
+}
+
+// SETRANGE key offset value
+func (s *Server) handleSetRange(c net.Conn, args []string) {
+	if len(args) != 4 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SETRANGE' command"))
+		return
+	}
+	key := args[1]
+	offset, err := strconv.Atoi(args[2])
+	if err != nil {
+		s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+		return
+	}
+	if offset < 0 {
+		s.reply(c, protocol.Error("ERR offset is out of range"))
+		return
+	}
+	value := args[3]
+
+	res := s.execute(c, "SETRANGE", key, fmt.Sprintf("%d", offset), value)
+	switch v := res.(type) {
+	case int:
+		s.reply(c, protocol.Integer(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.Integer(0))
+	}
+}
+
+// GETSET key value
+func (s *Server) handleGetSet(c net.Conn, args []string) {
+	if len(args) != 3 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'GETSET' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
-	members := []string{}
-	for i := 2; i < len(args); i++ {
-		members = append(members, string(args[i].(protocol.BulkString)))
+	key := args[1]
+	value := args[2]
+
+	res := s.execute(c, "GETSET", key, value)
+	switch v := res.(type) {
+	case []byte:
+		s.reply(c, protocol.BulkString(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.BulkString(nil))
 	}
-	res := s.shards.Execute("SADD", key, members...)
-	if added, ok := res.(int); ok {
-		c.Write([]byte(protocol.Encode(protocol.Integer(added))))
+}
+
+// SETNX key value
+func (s *Server) handleSetNX(c net.Conn, args []string) {
+	if len(args) != 3 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SETNX' command"))
+		return
+	}
+	key := args[1]
+	value := args[2]
+
+	res := s.execute(c, "SETNX", key, value)
+	if err, isErr := res.(error); isErr {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	if ok, _ := res.(bool); ok {
+		s.reply(c, protocol.Integer(1))
 	} else {
-		c.Write([]byte(protocol.Encode(protocol.Integer(0))))
+		s.reply(c, protocol.Integer(0))
+	}
+}
+
+// MGET key [key ...], one request per shard holding any of the keys
+func (s *Server) handleMGet(c net.Conn, args []string) {
+	if len(args) < 2 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'MGET' command"))
+		return
+	}
+	replies := s.executeMulti(c, "GET", store.KeyOps(args[1:]))
+	arr := make(protocol.Array, 0, len(replies))
+	for _, r := range replies {
+		val, ok := r.([]byte)
+		if !ok || val == nil {
+			arr = append(arr, protocol.BulkString(nil))
+			continue
+		}
+		arr = append(arr, protocol.BulkString(val))
 	}
+	s.reply(c, arr)
 }
 
-func (s *Server) handleSRem(c net.Conn, args protocol.Array) {
+// MSET key value [key value ...], one request per shard holding any of the
+// keys
+func (s *Server) handleMSet(c net.Conn, args []string) {
+	if len(args) < 3 || len(args)%2 != 1 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'MSET' command"))
+		return
+	}
+	ops := make([]store.KeyOp, 0, len(args)/2)
+	for i := 1; i+1 < len(args); i += 2 {
+		ops = append(ops, store.KeyOp{Key: args[i], Args: []string{args[i+1]}})
+	}
+	for _, r := range s.executeMulti(c, "SET", ops) {
+		if err, isErr := r.(error); isErr {
+			s.reply(c, protocol.Error(err.Error()))
+			return
+		}
+	}
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+func (s *Server) handleSAdd(c net.Conn, args []string) {
 	if len(args) < 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SREM' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SADD' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
-	members := []string{}
-	for i := 2; i < len(args); i++ {
-		members = append(members, string(args[i].(protocol.BulkString)))
+	key := args[1]
+	members := args[2:]
+	res := s.execute(c, "SADD", key, members...)
+	switch v := res.(type) {
+	case int:
+		s.reply(c, protocol.Integer(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.Integer(0))
+	}
+}
+
+func (s *Server) handleSRem(c net.Conn, args []string) {
+	if len(args) < 3 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SREM' command"))
+		return
 	}
-	res := s.shards.Execute("SREM", key, members...)
+	key := args[1]
+	members := args[2:]
+	res := s.execute(c, "SREM", key, members...)
 	if removed, ok := res.(int); ok {
-		c.Write([]byte(protocol.Encode(protocol.Integer(removed))))
+		s.reply(c, protocol.Integer(removed))
 	} else {
-		c.Write([]byte(protocol.Encode(protocol.Integer(0))))
+		s.reply(c, protocol.Integer(0))
 	}
 }
 
-func (s *Server) handleSMembers(c net.Conn, args protocol.Array) {
+func (s *Server) handleSMembers(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SMEMBERS' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SMEMBERS' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
-	res := s.shards.Execute("SMEMBERS", key)
+	key := args[1]
+	res := s.execute(c, "SMEMBERS", key)
 	members, _ := res.([]string)
 	arr := make([]protocol.RESPType, 0, len(members))
 	for _, m := range members {
 		arr = append(arr, protocol.BulkString(m))
 	}
-	c.Write([]byte(protocol.Encode(protocol.Array(arr))))
+	s.reply(c, protocol.Array(arr))
 }
 
-func (s *Server) handleSCard(c net.Conn, args protocol.Array) {
+func (s *Server) handleSCard(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SCARD' command"))))
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SCARD' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SCARD' command"))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SCARD' command"))
 	}
-	key := string(args[1].(protocol.BulkString))
-	res := s.shards.Execute("SCARD", key)
+	key := args[1]
+	res := s.execute(c, "SCARD", key)
 	if card, ok := res.(int); ok {
-		c.Write([]byte(protocol.Encode(protocol.Integer(card))))
+		s.reply(c, protocol.Integer(card))
 	} else {
-		c.Write([]byte(protocol.Encode(protocol.Integer(0))))
+		s.reply(c, protocol.Integer(0))
 	}
 }
 
-func (s *Server) handleSIsMember(c net.Conn, args protocol.Array) {
+func (s *Server) handleSIsMember(c net.Conn, args []string) {
 	if len(args) != 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of argumments for 'SIMEMBER' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of argumments for 'SIMEMBER' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
-	member := string(args[2].(protocol.BulkString))
+	key := args[1]
+	member := args[2]
 
-	res := s.shards.Execute("SISMEMBER", key, member)
+	res := s.execute(c, "SISMEMBER", key, member)
 	if ok, _ := res.(bool); ok {
-		c.Write([]byte(protocol.Encode(protocol.Integer(1))))
+		s.reply(c, protocol.Integer(1))
 	} else {
-		c.Write([]byte(protocol.Encode(protocol.Integer(0))))
+		s.reply(c, protocol.Integer(0))
 	}
 }
 
-func (s *Server) handleSUnion(c net.Conn, args protocol.Array) {
-	if len(args) < 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SUNION' command"))))
-		return
-	}
-	keys := make([]string, 0, len(args)-1)
-	for _, a := range args[1:] {
-		keys = append(keys, string(a.(protocol.BulkString)))
-	}
+// SUNION key [key ...]
+func (s *Server) handleSUnion(c net.Conn, args []string) {
+	s.setOp(c, store.SetUnion, args[1:])
+}
 
-	res := s.shards.Execute("SUNION", keys[0], keys...)
-	result, _ := res.([]string)
-	arr := make([]protocol.RESPType, 0, len(result))
-	for _, v := range result {
-		arr = append(arr, protocol.BulkString(v))
-	}
-	c.Write([]byte(protocol.Encode(protocol.Array(arr))))
+// SINTER key [key ...]
+func (s *Server) handleSInter(c net.Conn, args []string) {
+	s.setOp(c, store.SetInter, args[1:])
 }
 
-func (s *Server) handleSInter(c net.Conn, args protocol.Array) {
-	if len(args) < 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SINTER' command"))))
-		return
-	}
+// SDIFF key [key ...]
+func (s *Server) handleSDiff(c net.Conn, args []string) {
+	s.setOp(c, store.SetDiff, args[1:])
+}
 
-	keys := make([]string, 0, len(args)-1)
-	for _, a := range args[1:] {
-		keys = append(keys, string(a.(protocol.BulkString)))
+// setOp replies with op applied to the sets at keys, wherever they live
+func (s *Server) setOp(c net.Conn, op store.SetOp, keys []string) {
+	members, err := s.shards.SetOp(op, keys)
+	if err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
 	}
-
-	res := s.shards.Execute("SINTER", keys[0], keys...)
-	result, _ := res.([]string)
-	arr := make([]protocol.RESPType, 0, len(result))
-	for _, v := range result {
+	arr := make([]protocol.RESPType, 0, len(members))
+	for _, v := range members {
 		arr = append(arr, protocol.BulkString(v))
 	}
-	c.Write([]byte(protocol.Encode(protocol.Array(arr))))
+	s.reply(c, protocol.Array(arr))
 }
 
-func (s *Server) handleSDiff(c net.Conn, args protocol.Array) {
-	if len(args) < 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SDIFF' command"))))
-		return
-	}
+// SUNIONSTORE destination key [key ...]
+func (s *Server) handleSUnionStore(c net.Conn, args []string) {
+	s.setOpStore(c, "SUNIONSTORE", store.SetUnion, args[1], args[2:])
+}
 
-	keys := make([]string, 0, len(args)-1)
-	for _, a := range args[1:] {
-		keys = append(keys, string(a.(protocol.BulkString)))
-	}
+// SINTERSTORE destination key [key ...]
+func (s *Server) handleSInterStore(c net.Conn, args []string) {
+	s.setOpStore(c, "SINTERSTORE", store.SetInter, args[1], args[2:])
+}
 
-	res := s.shards.Execute("SDIFF", keys[0], keys...)
-	result, _ := res.([]string)
-	arr := make([]protocol.RESPType, 0, len(result))
-	for _, v := range result {
-		arr = append(arr, protocol.BulkString(v))
+// SDIFFSTORE destination key [key ...]
+func (s *Server) handleSDiffStore(c net.Conn, args []string) {
+	s.setOpStore(c, "SDIFFSTORE", store.SetDiff, args[1], args[2:])
+}
+
+// setOpStore stores op applied to the sets at keys in dest and replies with
+// its cardinality
+func (s *Server) setOpStore(c net.Conn, cmd string, op store.SetOp, dest string, keys []string) {
+	n, err := s.shards.SetOpStore(op, dest, keys)
+	if err != nil {
+		if errors.Is(err, store.ErrOOM) {
+			s.oomEvent(cmd, dest)
+		}
+		s.reply(c, protocol.Error(err.Error()))
+		return
 	}
-	c.Write([]byte(protocol.Encode(protocol.Array(arr))))
+	s.reply(c, protocol.Integer(n))
 }
 
-func (s *Server) handleSPop(c net.Conn, args protocol.Array) {
+func (s *Server) handleSPop(c net.Conn, args []string) {
 	if len(args) < 2 || len(args) > 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SPOP' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SPOP' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
+	key := args[1]
 
 	count := 1
 	if len(args) == 3 {
-		n, err := strconv.Atoi(string(args[2].(protocol.BulkString)))
+		n, err := strconv.Atoi(args[2])
 		if err != nil || n < 0 {
-			c.Write([]byte(protocol.Encode(protocol.Error("ERR value is not an integer or out of range"))))
+			s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
 			return
 		}
 		count = n
 	}
 
-	res := s.shards.Execute("SPOP", key, fmt.Sprintf("%d", count))
+	res := s.execute(c, "SPOP", key, fmt.Sprintf("%d", count))
 	result, _ := res.([]string)
 	if result == nil {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR null"))))
+		s.reply(c, protocol.Error("ERR null"))
 		return
 	}
 
 	if count == 1 {
-		c.Write([]byte(protocol.Encode(protocol.BulkString(result[0]))))
+		s.reply(c, protocol.BulkString(result[0]))
 	} else {
 		arr := make([]protocol.RESPType, len(result))
 		for i, v := range result {
 			arr[i] = protocol.BulkString(v)
 		}
-		c.Write([]byte(protocol.Encode(protocol.Array(arr))))
+		s.reply(c, protocol.Array(arr))
 	}
 }
 
-func (s *Server) handleSRandMember(c net.Conn, args protocol.Array) {
+func (s *Server) handleSRandMember(c net.Conn, args []string) {
 	if len(args) < 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SRANDMEMBER' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'SRANDMEMBER' command"))
 	}
-	key := string(args[1].(protocol.BulkString))
+	key := args[1]
 	count := 0
 
 	if len(args) > 2 {
-		n, err := strconv.Atoi(string(args[2].(protocol.BulkString)))
+		n, err := strconv.Atoi(args[2])
 		if err != nil {
-			c.Write([]byte(protocol.Encode(protocol.Error("ERR value is not an integer or out of range"))))
+			s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
 			return
 		}
 		count = n
 	}
 
-	res := s.shards.Execute("SRANDMEMBER", key, fmt.Sprintf("%d", count))
+	res := s.execute(c, "SRANDMEMBER", key, fmt.Sprintf("%d", count))
 	result, _ := res.([]string)
 	if result == nil {
-		c.Write([]byte(protocol.Encode(protocol.Array(nil))))
+		s.reply(c, protocol.Array(nil))
 		return
 	}
 
 	if count == 0 {
 		//single value
-		c.Write([]byte(protocol.Encode(protocol.BulkString(result[0]))))
+		s.reply(c, protocol.BulkString(result[0]))
 		return
 	}
 
@@ -298,75 +474,109 @@ func (s *Server) handleSRandMember(c net.Conn, args protocol.Array) {
 	for _, v := range result {
 		arr = append(arr, protocol.BulkString(v))
 	}
-	c.Write([]byte(protocol.Encode(arr)))
+	s.reply(c, arr)
 }
 
-func (s *Server) handleHSet(c net.Conn, args protocol.Array) {
-	if len(args) < 4 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'HSET' command"))))
+// SAMPLE key count [WEIGHTED] [WITHSCORES]
+func (s *Server) handleSample(c net.Conn, args []string) {
+	key := args[1]
+	countArg := args[2]
+	count, err := strconv.Atoi(countArg)
+	if err != nil || count <= 0 {
+		s.reply(c, protocol.Error("ERR count should be a positive integer"))
 		return
 	}
+	opts := []string{strconv.Itoa(count)}
+	for _, opt := range args[3:] {
+		switch strings.ToUpper(opt) {
+		case "WEIGHTED", "WITHSCORES":
+			opts = append(opts, strings.ToUpper(opt))
+		default:
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+	}
 
-	key := string(args[1].(protocol.BulkString))
-	field := string(args[2].(protocol.BulkString))
-	value := string(args[3].(protocol.BulkString))
+	switch v := s.execute(c, "SAMPLE", key, opts...).(type) {
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	case []string:
+		arr := make(protocol.Array, len(v))
+		for i, m := range v {
+			arr[i] = protocol.BulkString(m)
+		}
+		s.reply(c, arr)
+	default:
+		s.reply(c, protocol.Array{})
+	}
+}
 
-	res := s.shards.Execute("HSET", key, field, value)
-	if n, ok := res.(int); ok {
-		c.Write([]byte(protocol.Encode(protocol.Integer(n))))
-	} else {
-		c.Write([]byte(protocol.Encode(protocol.Integer(0))))
+// HSET key field value [field value ...]
+func (s *Server) handleHSet(c net.Conn, args []string) {
+	if len(args) < 4 || len(args)%2 != 0 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HSET' command"))
+		return
+	}
+
+	key := args[1]
+	fieldValues := args[2:]
+
+	res := s.execute(c, "HSET", key, fieldValues...)
+	switch v := res.(type) {
+	case int:
+		s.reply(c, protocol.Integer(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.Integer(0))
 	}
 }
 
-func (s *Server) handleHGet(c net.Conn, args protocol.Array) {
+func (s *Server) handleHGet(c net.Conn, args []string) {
 	if len(args) < 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'HGET' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HGET' command"))
 		return
 	}
 
-	key := string(args[1].(protocol.BulkString))
-	field := string(args[2].(protocol.BulkString))
+	key := args[1]
+	field := args[2]
 
-	res := s.shards.Execute("HGET", key, field)
+	res := s.execute(c, "HGET", key, field)
 	val, ok := res.(string)
 	if !ok {
-		c.Write([]byte(protocol.Encode(protocol.BulkString(nil))))
+		s.reply(c, protocol.BulkString(nil))
 		return
 	}
-	c.Write([]byte(protocol.Encode(protocol.BulkString(val))))
+	s.reply(c, protocol.BulkString(val))
 }
 
-func (s *Server) handleHDel(c net.Conn, args protocol.Array) {
+func (s *Server) handleHDel(c net.Conn, args []string) {
 	if len(args) < 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'HDEL' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HDEL' command"))
 		return
 	}
 
-	key := string(args[1].(protocol.BulkString))
-	fields := make([]string, 0, len(args)-2)
-	for _, a := range args[2:] {
-		fields = append(fields, string(a.(protocol.BulkString)))
-	}
+	key := args[1]
+	fields := args[2:]
 
-	res := s.shards.Execute("HDEL", key, fields...)
+	res := s.execute(c, "HDEL", key, fields...)
 	deleted, _ := res.(int)
-	c.Write([]byte(protocol.Encode(protocol.Integer(deleted))))
+	s.reply(c, protocol.Integer(deleted))
 }
 
-func (s *Server) handleHGetAll(c net.Conn, args protocol.Array) {
+func (s *Server) handleHGetAll(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'HGETALL' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HGETALL' command"))
 		return
 	}
 
-	key := string(args[1].(protocol.BulkString))
-	res := s.shards.Execute("HGETALL", key)
+	key := args[1]
+	res := s.execute(c, "HGETALL", key)
 	result, _ := res.(map[string]string)
 
 	if result == nil {
 		// Redis returns empty array for non-existing or non-hash key
-		c.Write([]byte(protocol.Encode(protocol.Array{})))
+		s.reply(c, protocol.Array{})
 		return
 	}
 
@@ -375,523 +585,583 @@ func (s *Server) handleHGetAll(c net.Conn, args protocol.Array) {
 		arr = append(arr, protocol.BulkString(field), protocol.BulkString(val))
 	}
 
-	c.Write([]byte(protocol.Encode(arr)))
+	s.reply(c, arr)
+}
+
+// HMGET key field [field ...]
+func (s *Server) handleHMGet(c net.Conn, args []string) {
+	if len(args) < 3 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HMGET' command"))
+		return
+	}
+
+	key := args[1]
+	fields := args[2:]
+
+	res := s.execute(c, "HMGET", key, fields...)
+	values, _ := res.([]interface{})
+	arr := make(protocol.Array, len(fields))
+	for i := range fields {
+		arr[i] = protocol.BulkString(nil)
+		if i < len(values) {
+			if v, ok := values[i].(string); ok {
+				arr[i] = protocol.BulkString(v)
+			}
+		}
+	}
+	s.reply(c, arr)
+}
+
+// HEXISTS key field
+func (s *Server) handleHExists(c net.Conn, args []string) {
+	if len(args) != 3 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HEXISTS' command"))
+		return
+	}
+
+	key := args[1]
+	field := args[2]
+
+	res := s.execute(c, "HEXISTS", key, field)
+	if ok, _ := res.(bool); ok {
+		s.reply(c, protocol.Integer(1))
+	} else {
+		s.reply(c, protocol.Integer(0))
+	}
+}
+
+// HLEN key
+func (s *Server) handleHLen(c net.Conn, args []string) {
+	if len(args) != 2 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HLEN' command"))
+		return
+	}
+
+	key := args[1]
+	res := s.execute(c, "HLEN", key)
+	n, _ := res.(int)
+	s.reply(c, protocol.Integer(n))
+}
+
+// HKEYS key
+func (s *Server) handleHKeys(c net.Conn, args []string) {
+	if len(args) != 2 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HKEYS' command"))
+		return
+	}
+
+	key := args[1]
+	res := s.execute(c, "HKEYS", key)
+	result, _ := res.([]string)
+	arr := make(protocol.Array, 0, len(result))
+	for _, f := range result {
+		arr = append(arr, protocol.BulkString(f))
+	}
+	s.reply(c, arr)
+}
+
+// HVALS key
+func (s *Server) handleHVals(c net.Conn, args []string) {
+	if len(args) != 2 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HVALS' command"))
+		return
+	}
+
+	key := args[1]
+	res := s.execute(c, "HVALS", key)
+	result, _ := res.([]string)
+	arr := make(protocol.Array, 0, len(result))
+	for _, v := range result {
+		arr = append(arr, protocol.BulkString(v))
+	}
+	s.reply(c, arr)
+}
+
+// HINCRBY key field increment
+func (s *Server) handleHIncrBy(c net.Conn, args []string) {
+	if len(args) != 4 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HINCRBY' command"))
+		return
+	}
+
+	key := args[1]
+	field := args[2]
+	incr := args[3]
+	if _, err := strconv.ParseInt(incr, 10, 64); err != nil {
+		s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+		return
+	}
+
+	res := s.execute(c, "HINCRBY", key, field, incr)
+	switch v := res.(type) {
+	case int64:
+		s.reply(c, protocol.Integer(v))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.Error("ERR unexpected response for 'HINCRBY'"))
+	}
+}
+
+// HINCRBYFLOAT key field increment
+func (s *Server) handleHIncrByFloat(c net.Conn, args []string) {
+	if len(args) != 4 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'HINCRBYFLOAT' command"))
+		return
+	}
+
+	key := args[1]
+	field := args[2]
+	incr := args[3]
+	if _, err := strconv.ParseFloat(incr, 64); err != nil {
+		s.reply(c, protocol.Error("ERR value is not a valid float"))
+		return
+	}
+
+	res := s.execute(c, "HINCRBYFLOAT", key, field, incr)
+	switch v := res.(type) {
+	case float64:
+		s.reply(c, protocol.BulkString(strconv.FormatFloat(v, 'f', -1, 64)))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.Error("ERR unexpected response for 'HINCRBYFLOAT'"))
+	}
 }
 
 // CMS.INCR key item count
-func (s *Server) handleCMSIncr(c net.Conn, args protocol.Array) {
+func (s *Server) handleCMSIncr(c net.Conn, args []string) {
 	if len(args) != 4 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'CMSINCR'"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'CMSINCR'"))
 		return
 	}
 
-	key := string(args[1].(protocol.BulkString))
-	item := string(args[2].(protocol.BulkString))
-	countStr := string(args[3].(protocol.BulkString))
+	key := args[1]
+	item := args[2]
+	countStr := args[3]
 	count, err := strconv.Atoi(countStr)
 	if err != nil {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR invalid count"))))
+		s.reply(c, protocol.Error("ERR invalid count"))
 		return
 	}
 
-	s.shards.Execute("CMSINCR", key, item, fmt.Sprintf("%d", count))
-	c.Write([]byte(protocol.Encode(protocol.SimpleString("OK"))))
+	if err, isErr := s.execute(c, "CMSINCR", key, item, fmt.Sprintf("%d", count)).(error); isErr {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	s.reply(c, protocol.SimpleString("OK"))
 }
 
 // CMS.QUERY key item
-func (s *Server) handleCMSQuery(c net.Conn, args protocol.Array) {
+func (s *Server) handleCMSQuery(c net.Conn, args []string) {
 	if len(args) != 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'CMSQUERY'"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'CMSQUERY'"))
 		return
 	}
 
-	key := string(args[1].(protocol.BulkString))
-	item := string(args[2].(protocol.BulkString))
+	key := args[1]
+	item := args[2]
 
-	res := s.shards.Execute("CMSQUERY", key, item)
+	res := s.execute(c, "CMSQUERY", key, item)
 	count, _ := res.(uint32)
-	c.Write([]byte(protocol.Encode(protocol.Integer(count))))
+	s.reply(c, protocol.Integer(count))
 }
 
 // LPUSH key value [value ...]
-func (s *Server) handleLPush(c net.Conn, args protocol.Array) {
+func (s *Server) handleLPush(c net.Conn, args []string) {
 	if len(args) < 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'LPUSH' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'LPUSH' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
+	key := args[1]
 
-	values := []string{}
-	for i := 2; i < len(args); i++ {
-		values = append(values, string(args[i].(protocol.BulkString)))
-	}
+	values := args[2:]
 
-	res := s.shards.Execute("LPUSH", key, values...)
+	res := s.execute(c, "LPUSH", key, values...)
+	if err, isErr := res.(error); isErr {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
 	newLen, _ := res.(int)
-	c.Write([]byte(protocol.Encode(protocol.Integer(newLen))))
+	s.reply(c, protocol.Integer(newLen))
 }
 
 // RPUSH key value [value ...]
-func (s *Server) handleRPush(c net.Conn, args protocol.Array) {
+func (s *Server) handleRPush(c net.Conn, args []string) {
 	if len(args) < 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'RPUSH' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'RPUSH' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
+	key := args[1]
 
-	values := []string{}
-	for i := 2; i < len(args); i++ {
-		values = append(values, string(args[i].(protocol.BulkString)))
-	}
+	values := args[2:]
 
-	res := s.shards.Execute("RPUSH", key, values...)
+	res := s.execute(c, "RPUSH", key, values...)
+	if err, isErr := res.(error); isErr {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
 	newLen, _ := res.(int)
-	c.Write([]byte(protocol.Encode(protocol.Integer(newLen))))
+	s.reply(c, protocol.Integer(newLen))
 }
 
 // LPOP key
-func (s *Server) handleLPop(c net.Conn, args protocol.Array) {
+func (s *Server) handleLPop(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'LPOP' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'LPOP' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
+	key := args[1]
 
-	res := s.shards.Execute("LPOP", key)
+	res := s.execute(c, "LPOP", key)
 	val, ok := res.(string)
 	if !ok {
-		c.Write([]byte(protocol.Encode(protocol.BulkString(nil))))
+		s.reply(c, protocol.BulkString(nil))
 		return
 	}
 
-	c.Write([]byte(protocol.Encode(protocol.BulkString(val))))
+	s.reply(c, protocol.BulkString(val))
 }
 
 // RPOP key
-func (s *Server) handleRPop(c net.Conn, args protocol.Array) {
+func (s *Server) handleRPop(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'RPOP' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'RPOP' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
-	res := s.shards.Execute("RPOP", key)
+	key := args[1]
+	res := s.execute(c, "RPOP", key)
 	val, ok := res.(string)
 	if !ok {
-		c.Write([]byte(protocol.Encode(protocol.BulkString(nil))))
+		s.reply(c, protocol.BulkString(nil))
 		return
 	}
 
-	c.Write([]byte(protocol.Encode(protocol.BulkString(val))))
+	s.reply(c, protocol.BulkString(val))
 }
 
 // LLEN key
-func (s *Server) handleLLen(c net.Conn, args protocol.Array) {
+func (s *Server) handleLLen(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'LLEN' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'LLEN' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
-	res := s.shards.Execute("LLEN", key)
+	key := args[1]
+	res := s.execute(c, "LLEN", key)
 	length, _ := res.(int)
-	c.Write([]byte(protocol.Encode(protocol.Integer(length))))
+	s.reply(c, protocol.Integer(length))
 }
 
 // LRANGE key start stop
-func (s *Server) handleLRange(c net.Conn, args protocol.Array) {
+func (s *Server) handleLRange(c net.Conn, args []string) {
 	if len(args) != 4 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'LRANGE' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'LRANGE' command"))
 		return
 	}
-	key := string(args[1].(protocol.BulkString))
-	startStr := string(args[2].(protocol.BulkString))
-	stopStr := string(args[3].(protocol.BulkString))
+	key := args[1]
+	startStr := args[2]
+	stopStr := args[3]
 
 	start, err1 := strconv.Atoi(startStr)
 	stop, err2 := strconv.Atoi(stopStr)
 	if err1 != nil || err2 != nil {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR invalid start or stop index"))))
+		s.reply(c, protocol.Error("ERR invalid start or stop index"))
 		return
 	}
 
-	res := s.shards.Execute("LRANGE", key, fmt.Sprintf("%d", start), fmt.Sprintf("%d", stop))
+	res := s.execute(c, "LRANGE", key, fmt.Sprintf("%d", start), fmt.Sprintf("%d", stop))
 	result, _ := res.([]string)
 	arr := make(protocol.Array, 0, len(result))
 	for _, v := range result {
 		arr = append(arr, protocol.BulkString(v))
 	}
 
-	c.Write([]byte(protocol.Encode(arr)))
+	s.reply(c, arr)
 }
 
 // ZADD key score member [score member ...]
-func (s *Server) handleZAdd(c net.Conn, args protocol.Array) {
+func (s *Server) handleZAdd(c net.Conn, args []string) {
 	if len(args) < 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'ZADD' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'ZADD' command"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
+	key := args[1]
 	members := make(map[string]float64)
 	for i := 2; i+1 < len(args); i += 2 {
-		scoreStr, _ := args[i].(protocol.BulkString)
-		member, _ := args[i+1].(protocol.BulkString)
-		score, err := strconv.ParseFloat(string(scoreStr), 64)
-		if err != nil {
-			c.Write([]byte(protocol.Encode(protocol.Error("ERR invalid score for 'ZADD'"))))
+		scoreStr := args[i]
+		member := args[i+1]
+		score, err := strconv.ParseFloat(scoreStr, 64)
+		if err != nil || math.IsNaN(score) {
+			s.reply(c, protocol.Error("ERR value is not a valid float"))
 			return
 		}
-		members[string(member)] = score
+		members[member] = score
 	}
-	// Convert protocol.Array to []string for members
-	memberArgs := []string{}
-	for i := 2; i < len(args); i++ {
-		memberArgs = append(memberArgs, string(args[i].(protocol.BulkString)))
+	res := s.execute(c, "ZADD", key, args[2:]...)
+	if err, isErr := res.(error); isErr {
+		s.reply(c, protocol.Error(err.Error()))
+		return
 	}
-	res := s.shards.Execute("ZADD", string(key), memberArgs...)
 	added, _ := res.(int)
-	c.Write([]byte(protocol.Encode(protocol.Integer(added))))
+	s.reply(c, protocol.Integer(added))
+}
+
+// ZINCRBY key increment member
+func (s *Server) handleZIncrBy(c net.Conn, args []string) {
+	key := args[1]
+	incr := args[2]
+	member := args[3]
+	if _, err := strconv.ParseFloat(incr, 64); err != nil {
+		s.reply(c, protocol.Error(store.ErrNotFloat.Error()))
+		return
+	}
+	res := s.execute(c, "ZINCRBY", key, incr, member)
+	if err, isErr := res.(error); isErr {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	score, _ := res.(float64)
+	s.reply(c, protocol.BulkString(strconv.FormatFloat(score, 'f', -1, 64)))
 }
 
 // ZSCORE key member
-func (s *Server) handleZScore(c net.Conn, args protocol.Array) {
+func (s *Server) handleZScore(c net.Conn, args []string) {
 	if len(args) != 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'ZSCORE' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'ZSCORE' command"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	member, _ := args[2].(protocol.BulkString)
-	res := s.shards.Execute("ZSCORE", string(key), string(member))
+	key := args[1]
+	member := args[2]
+	res := s.execute(c, "ZSCORE", key, member)
 	score, ok := res.(float64)
 	if !ok {
-		c.Write([]byte(protocol.Encode(protocol.BulkString(nil))))
+		s.reply(c, protocol.BulkString(nil))
 		return
 	}
-	c.Write([]byte(protocol.Encode(protocol.BulkString(fmt.Sprintf("%f", score)))))
+	s.reply(c, protocol.BulkString(fmt.Sprintf("%f", score)))
 }
 
 // ZSCORE key member
-func (s *Server) handleZCard(c net.Conn, args protocol.Array) {
+func (s *Server) handleZCard(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'ZCARD' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'ZCARD' command"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	res := s.shards.Execute("ZCARD", string(key))
+	key := args[1]
+	res := s.execute(c, "ZCARD", key)
 	count, _ := res.(int)
-	c.Write([]byte(protocol.Encode(protocol.Integer(count))))
+	s.reply(c, protocol.Integer(count))
 }
 
 // ZRANK key member
-func (s *Server) handleZRank(c net.Conn, args protocol.Array) {
+func (s *Server) handleZRank(c net.Conn, args []string) {
 	if len(args) != 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'ZRANK' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'ZRANK' command"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	member, _ := args[2].(protocol.BulkString)
-	res := s.shards.Execute("ZRANK", string(key), string(member))
+	key := args[1]
+	member := args[2]
+	res := s.execute(c, "ZRANK", key, member)
 	rank, ok := res.(int)
 	if !ok {
-		c.Write([]byte(protocol.Encode(protocol.BulkString(nil))))
+		s.reply(c, protocol.BulkString(nil))
 		return
 	}
-	c.Write([]byte(protocol.Encode(protocol.Integer(rank))))
+	s.reply(c, protocol.Integer(rank))
 }
 
 // ZRANGE key start stop [WITHSCORES]
-func (s *Server) handleZRange(c net.Conn, args protocol.Array) {
+func (s *Server) handleZRange(c net.Conn, args []string) {
 	if len(args) < 4 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'ZRANGE' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'ZRANGE' command"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	start, err1 := strconv.Atoi(string(args[2].(protocol.BulkString)))
-	stop, err2 := strconv.Atoi(string(args[3].(protocol.BulkString)))
+	key := args[1]
+	start, err1 := strconv.Atoi(args[2])
+	stop, err2 := strconv.Atoi(args[3])
 	withScores := false
 	if len(args) > 4 && len(args) == 5 {
-		if bs, ok := args[4].(protocol.BulkString); ok && (string(bs) == "WITHSCORES" || string(bs) == "withscores") {
+		if args[4] == "WITHSCORES" || args[4] == "withscores" {
 			withScores = true
 		}
 	}
 	if err1 != nil || err2 != nil {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR invalid start/stop for 'ZRANGE'"))))
+		s.reply(c, protocol.Error("ERR invalid start/stop for 'ZRANGE'"))
 		return
 	}
-	res := s.shards.Execute("ZRANGE", string(key), fmt.Sprintf("%d", start), fmt.Sprintf("%d", stop), fmt.Sprintf("%t", withScores))
+	rangeArgs := []string{strconv.Itoa(start), strconv.Itoa(stop)}
+	if withScores {
+		rangeArgs = append(rangeArgs, "WITHSCORES")
+	}
+	res := s.execute(c, "ZRANGE", key, rangeArgs...)
 	result, _ := res.([]string)
 	if result == nil {
-		c.Write([]byte(protocol.Encode(protocol.BulkString(nil))))
+		s.reply(c, protocol.BulkString(nil))
 		return
 	}
 	arr := make(protocol.Array, len(result))
 	for i, v := range result {
 		arr[i] = protocol.BulkString(v)
 	}
-	c.Write([]byte(protocol.Encode(arr)))
+	s.reply(c, arr)
 }
 
 // BF.ADD key item
-func (s *Server) handleBFAdd(c net.Conn, args protocol.Array) {
+func (s *Server) handleBFAdd(c net.Conn, args []string) {
 	if len(args) != 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'BFADD' command (expected key m k item)"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'BFADD' command (expected key m k item)"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	item, _ := args[2].(protocol.BulkString)
-	res := s.shards.Execute("BFADD", string(key), string(item))
-	ok, _ := res.(bool)
-	if ok {
-		c.Write([]byte(protocol.Encode(protocol.Integer(1))))
-	} else {
-		c.Write([]byte(protocol.Encode(protocol.Integer(0))))
-	}
+	key := args[1]
+	item := args[2]
+	s.writeBloomReply(c, s.execute(c, "BFADD", key, item), false)
 }
 
 // Handler for BFEXISTS: BFEXISTS key item
-func (s *Server) handleBFExists(c net.Conn, args protocol.Array) {
+func (s *Server) handleBFExists(c net.Conn, args []string) {
 	if len(args) != 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'BFEXISTS' command (expected key item)"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'BFEXISTS' command (expected key item)"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	item, _ := args[2].(protocol.BulkString)
-	res := s.shards.Execute("BFEXISTS", string(key), string(item))
-	ok, _ := res.(bool)
-	if ok {
-		c.Write([]byte(protocol.Encode(protocol.Integer(1))))
-	} else {
-		c.Write([]byte(protocol.Encode(protocol.Integer(0))))
-	}
+	key := args[1]
+	item := args[2]
+	s.writeBloomReply(c, s.execute(c, "BFEXISTS", key, item), false)
 }
 
-func (s *Server) handleAddNode(c net.Conn, args protocol.Array) {
+func (s *Server) handleAddNode(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'ADDNODE' command (expected key)"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'ADDNODE' command (expected key)"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	nodeID := string(key)
+	key := args[1]
+	nodeID := key
 
 	log.Printf("DEBUG: Handling ADDNODE command with key: %s", nodeID)
 
-	// Create and add the new shard
-	newShard := store.NewShard(store.NewStore())
+	// Create and add the new shard; check first so a disk engine never
+	// truncates the spill file of a running shard
+	if _, exists := s.shards.GetShardByNodeID(nodeID); exists {
+		s.reply(c, protocol.Error(fmt.Sprintf("ERR failed to add node: node %s already exists", nodeID)))
+		return
+	}
+	st, err := s.newStore(nodeID)
+	if err != nil {
+		s.reply(c, protocol.Error(fmt.Sprintf("ERR failed to add node: %v", err)))
+		return
+	}
+	newShard := store.NewShard(st)
 	if err := s.shards.AddNode(nodeID, newShard); err != nil {
 		log.Printf("ERROR: Failed to add node %s: %v", nodeID, err)
-		c.Write([]byte(protocol.Encode(protocol.Error(fmt.Sprintf("ERR failed to add node: %v", err)))))
+		st.Close()
+		s.reply(c, protocol.Error(fmt.Sprintf("ERR failed to add node: %v", err)))
 		return
 	}
 
-	// Start migration in background
+	s.pushTopology("shards", strconv.Itoa(len(s.shards.GetNodes())))
+	s.event(eventNodeAdded, "%s joined the hash ring", nodeID)
+
+	// Move the slots the ring now places on the new node in background
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-		defer cancel()
-		if err := s.shards.BackgroundMigrateTo(ctx, nodeID, 10); err != nil {
-			log.Printf("ERROR: Background migration for node %s failed: %v", nodeID, err)
+		s.event(eventMigrationStarted, "moving slots to %s", nodeID)
+		if err := s.shards.Reshard(context.Background()); err != nil {
+			log.Printf("ERROR: Resharding onto node %s failed: %v", nodeID, err)
+			s.event(eventMigrationFailed, "moving slots to %s: %v", nodeID, err)
 		} else {
-			log.Printf("DEBUG: %s - Background migration completed successfully", nodeID)
+			log.Printf("DEBUG: %s - Resharding completed successfully", nodeID)
+			s.event(eventMigrationFinished, "moved slots to %s", nodeID)
 		}
 	}()
 
-	c.Write([]byte(protocol.Encode(protocol.SimpleString("OK"))))
+	s.reply(c, protocol.SimpleString("OK"))
 }
 
-func (s *Server) handleRemoveNode(c net.Conn, args protocol.Array) {
+func (s *Server) handleRemoveNode(c net.Conn, args []string) {
 	if len(args) != 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'REMOVENODE' command (expected key)"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'REMOVENODE' command (expected key)"))
 		return
 	}
-	key, _ := args[1].(protocol.BulkString)
-	nodeID := string(key)
+	key := args[1]
+	nodeID := key
 
 	log.Printf("DEBUG: Handling REMOVENODE command for node: %s", nodeID)
 
 	// Check if the node exists
 	if _, exists := s.shards.GetShardByNodeID(nodeID); !exists {
 		log.Printf("ERROR: Node %s does not exist", nodeID)
-		c.Write([]byte(protocol.Encode(protocol.Error(fmt.Sprintf("ERR node %s does not exist", nodeID)))))
+		s.reply(c, protocol.Error(fmt.Sprintf("ERR node %s does not exist", nodeID)))
 		return
 	}
 
-	// Before removing the node, we need to migrate its data to other nodes
-	if shard, ok := s.shards.GetShardByNodeID(nodeID); ok {
-		// Get all keys from the node that's being removed
-		keys := shard.Store.ScanKeys(-1) // Get all keys
-		log.Printf("DEBUG: Node %s has %d keys to migrate before removal", nodeID, len(keys))
-
-		// Migrate each key to other nodes
-		if len(keys) > 0 {
-			// FIRST: Remove the node from hash ring so GetNodeForKey works correctly
-			s.shards.RemoveNodeFromRing(nodeID)
-			log.Printf("DEBUG: Removed node %s from hash ring", nodeID)
-
-			// Group keys by their target nodes based on updated hash ring
-			keysByTargetNode := make(map[string][]string)
-
-			for _, key := range keys {
-				// Hash key to determine which remaining node it should go to
-				targetNode, ok := s.shards.GetNodeForKey(key)
-				if !ok {
-					log.Printf("WARNING: Could not determine target node for key %s", key)
-					continue
-				}
-
-				// Skip if the target is the node being removed (shouldn't happen after removal from ring)
-				if targetNode == nodeID {
-					log.Printf("WARNING: Key %s still maps to removed node %s", key, nodeID)
-					continue
-				}
-
-				keysByTargetNode[targetNode] = append(keysByTargetNode[targetNode], key)
-			}
-
-			log.Printf("DEBUG: Keys distribution for migration: %v", keysByTargetNode)
-
-			// Migrate keys to their respective target nodes in batches
-			totalMigrated := 0
-			for targetNode, keysToMigrate := range keysByTargetNode {
-				if len(keysToMigrate) == 0 {
-					continue
-				}
-
-				log.Printf("DEBUG: Migrating %d keys from %s to %s", len(keysToMigrate), nodeID, targetNode)
-
-				// Get target shard
-				targetShard, ok := s.shards.GetShardByNodeID(targetNode)
-				if !ok {
-					log.Printf("ERROR: Target shard %s not found", targetNode)
-					continue
-				}
-
-				// Migrate keys in batch to this target node
-				migratedCount := s.shards.MigrateKeysBatch(shard, targetShard, keysToMigrate, nodeID, targetNode)
-				totalMigrated += migratedCount
-				log.Printf("DEBUG: Successfully migrated %d keys from %s to %s", migratedCount, nodeID, targetNode)
-			}
-
-			log.Printf("DEBUG: Total keys migrated from %s: %d/%d", nodeID, totalMigrated, len(keys))
-		} else {
-			// No keys to migrate, just remove from ring
-			s.shards.RemoveNodeFromRing(nodeID)
-			log.Printf("DEBUG: Removed node %s from hash ring (no keys to migrate)", nodeID)
-		}
-
-		// FINALLY: Remove the shard itself
-		s.shards.RemoveShardOnly(nodeID)
-	} else {
-		// Node not found, just remove from ring if it exists
-		s.shards.RemoveNodeFromRing(nodeID)
+	// Take the node off the ring, then move its slots to the nodes the ring
+	// now places them on; only then is the shard itself removed. Keys stay
+	// available throughout, on one node or the other.
+	s.shards.RemoveNodeFromRing(nodeID)
+	s.event(eventMigrationStarted, "moving slots off %s", nodeID)
+	if err := s.shards.Reshard(context.Background()); err != nil {
+		// the shard keeps the slots not moved, and serves them
+		log.Printf("ERROR: Resharding off node %s failed: %v", nodeID, err)
+		s.event(eventMigrationFailed, "moving slots off %s: %v", nodeID, err)
+		s.reply(c, protocol.Error(fmt.Sprintf("ERR failed to move slots off %s: %v", nodeID, err)))
+		return
 	}
+	s.event(eventMigrationFinished, "moved slots off %s", nodeID)
+	s.shards.RemoveShardOnly(nodeID)
 	log.Printf("DEBUG: Successfully removed node %s", nodeID)
+	s.pushTopology("shards", strconv.Itoa(len(s.shards.GetNodes())))
+	s.event(eventNodeRemoved, "%s left the hash ring", nodeID)
 
-	c.Write([]byte(protocol.Encode(protocol.SimpleString("OK"))))
+	s.reply(c, protocol.SimpleString("OK"))
 }
 
 // Handle PUBLISH command: PUBLISH channel message
-func (s *Server) handlePublish(c net.Conn, args protocol.Array) {
+func (s *Server) handlePublish(c net.Conn, args []string) {
 	if len(args) != 3 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'PUBLISH' command"))))
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'PUBLISH' command"))
 		return
 	}
 
-	channel := string(args[1].(protocol.BulkString))
-	message := string(args[2].(protocol.BulkString))
+	channel := args[1]
+	message := args[2]
 
 	log.Printf("DEBUG: Publishing message to channel %s: %s", channel, message)
 	count := s.pubsub.Publish(channel, message)
+	s.propagatePublish(c, args)
 
-	c.Write([]byte(protocol.Encode(protocol.Integer(count))))
+	s.reply(c, protocol.Integer(count))
 }
 
 // Handle SUBSCRIBE command: SUBSCRIBE channel [channel ...]
-func (s *Server) handleSubscribe(c net.Conn, args protocol.Array) {
-	if len(args) < 2 {
-		c.Write([]byte(protocol.Encode(protocol.Error("ERR wrong number of arguments for 'SUBSCRIBE' command"))))
-		return
-	}
-
-	channels := make([]string, 0, len(args)-1)
-	for i := 1; i < len(args); i++ {
-		channels = append(channels, string(args[i].(protocol.BulkString)))
-	}
-
-	log.Printf("DEBUG: Subscribing to channels: %v", channels)
-
-	// Create a channel for this subscription
-	msgCh := make(chan store.PubSubMessage, 100) // Buffer to prevent blocking
-
-	// Subscribe to all channels
-	s.pubsub.Subscribe(channels, msgCh)
-
-	// Send subscription confirmations
-	for i, channel := range channels {
-		// Send subscribe confirmation: ["subscribe", channel, num_subscriptions]
-		response := protocol.Array{
-			protocol.BulkString("subscribe"),
-			protocol.BulkString(channel),
-			protocol.Integer(i + 1), // subscription count
-		}
-		c.Write([]byte(protocol.Encode(response)))
-	}
-
-	// Enter subscription mode - listen for messages
-	go func() {
-		defer func() {
-			// Cleanup: unsubscribe from all channels when connection closes
-			s.pubsub.Unsubscribe(channels, msgCh)
-			close(msgCh)
-		}()
-
-		for {
-			select {
-			case message, ok := <-msgCh:
-				if !ok {
-					return // Channel closed
-				}
-
-				// Send message to client: ["message", channel, message]
-				response := protocol.Array{
-					protocol.BulkString("message"),
-					protocol.BulkString(message.Channel),
-					protocol.BulkString(message.Message),
-				}
-				if _, err := c.Write([]byte(protocol.Encode(response))); err != nil {
-					log.Printf("Failed to send message to subscriber: %v", err)
-					return
-				}
-			case <-s.stopCh:
-				return // Server shutting down
-			}
-		}
-	}()
+func (s *Server) handleSubscribe(c net.Conn, args []string) {
+	s.subscribe(c, args, false)
 }
 
 // Handle UNSUBSCRIBE command: UNSUBSCRIBE [channel [channel ...]]
-func (s *Server) handleUnsubscribe(c net.Conn, args protocol.Array) {
-	// For now, we'll implement a simple version that doesn't track individual connection subscriptions
-	// In a full implementation, you'd need to track which channels each connection is subscribed to
-
-	if len(args) == 1 {
-		// Unsubscribe from all channels - not implemented in this simple version
-		c.Write([]byte(protocol.Encode(protocol.SimpleString("OK"))))
-		return
-	}
-
-	channels := make([]string, 0, len(args)-1)
-	for i := 1; i < len(args); i++ {
-		channels = append(channels, string(args[i].(protocol.BulkString)))
-	}
-
-	log.Printf("DEBUG: Unsubscribing from channels: %v", channels)
+func (s *Server) handleUnsubscribe(c net.Conn, args []string) {
+	s.unsubscribe(c, args, false)
+}
 
-	// Send unsubscribe confirmations
-	for i, channel := range channels {
-		response := protocol.Array{
-			protocol.BulkString("unsubscribe"),
-			protocol.BulkString(channel),
-			protocol.Integer(len(channels) - i - 1), // remaining subscription count
+// Handle PSUBSCRIBE command: PSUBSCRIBE pattern [pattern ...]
+func (s *Server) handlePSubscribe(c net.Conn, args []string) {
+	for _, a := range args[1:] {
+		if err := store.CheckGlob(a); err != nil {
+			s.reply(c, protocol.Error(err.Error()))
+			return
 		}
-		c.Write([]byte(protocol.Encode(response)))
 	}
+	s.subscribe(c, args, true)
+}
+
+// Handle PUNSUBSCRIBE command: PUNSUBSCRIBE [pattern [pattern ...]]
+func (s *Server) handlePUnsubscribe(c net.Conn, args []string) {
+	s.unsubscribe(c, args, true)
+}
 
-	c.Write([]byte(protocol.Encode(protocol.SimpleString("OK"))))
+// TIME: the clock TTLs are measured against, as unix seconds and the
+// microseconds past them
+func (s *Server) handleTime(c net.Conn, args []string) {
+	now := s.shards.Now()
+	s.reply(c, protocol.Array{
+		protocol.BulkString(strconv.FormatInt(now.Unix(), 10)),
+		protocol.BulkString(strconv.FormatInt(int64(now.Nanosecond()/1000), 10)),
+	})
 }
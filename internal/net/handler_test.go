@@ -0,0 +1,155 @@
+package net
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+func TestSortedSetCommands(t *testing.T) {
+	c := newTestServer(t).dial(t)
+
+	c.expect(protocol.Integer(3), "ZADD", "z", "1", "a", "2", "b", "3", "c")
+	c.expect(bulks("a", "b", "c"), "ZRANGE", "z", "0", "-1")
+	c.expect(bulks("b", "2.000000", "c", "3.000000"), "ZRANGE", "z", "1", "-1", "WITHSCORES")
+	c.expect(bulks("b", "2.000000"), "ZRANGE", "z", "1", "1", "withscores")
+	c.expect(protocol.Integer(2), "ZRANK", "z", "c")
+	c.expect(protocol.BulkString("1.000000"), "ZSCORE", "z", "a")
+
+	// a missing member is nil, not rank or score 0
+	c.expect(protocol.BulkString(nil), "ZRANK", "z", "missing")
+	c.expect(protocol.BulkString(nil), "ZSCORE", "z", "missing")
+	c.expect(protocol.BulkString(nil), "ZRANK", "nokey", "a")
+
+	for _, score := range []string{"nan", "NaN", "one"} {
+		c.expect(protocol.Error("ERR value is not a valid float"), "ZADD", "z", score, "d")
+	}
+	c.expect(protocol.Integer(3), "ZCARD", "z")
+}
+
+func TestHashCommands(t *testing.T) {
+	c := newTestServer(t).dial(t)
+
+	c.expect(protocol.Integer(3), "HSET", "h", "a", "1", "b", "2", "c", "x")
+	c.expect(bulks("1", "2"), "HMGET", "h", "a", "b")
+	c.expect(protocol.Array{protocol.BulkString("1"), protocol.BulkString(nil)}, "HMGET", "h", "a", "missing")
+	c.expect(protocol.Integer(1), "HEXISTS", "h", "a")
+	c.expect(protocol.Integer(0), "HEXISTS", "h", "missing")
+	c.expect(protocol.Integer(3), "HLEN", "h")
+	c.expect(protocol.Integer(0), "HLEN", "nokey")
+
+	// HKEYS and HVALS have no defined order
+	for cmd, want := range map[string][]string{"HKEYS": {"a", "b", "c"}, "HVALS": {"1", "2", "x"}} {
+		got, _ := c.do(cmd, "h").(protocol.Array)
+		elems := make([]string, len(got))
+		for i, e := range got {
+			b, _ := e.(protocol.BulkString)
+			elems[i] = string(b)
+		}
+		sort.Strings(elems)
+		if !reflect.DeepEqual(elems, want) {
+			t.Errorf("%s h = %v, want %v", cmd, elems, want)
+		}
+	}
+
+	c.expect(protocol.Integer(11), "HINCRBY", "h", "a", "10")
+	c.expect(protocol.Integer(-5), "HINCRBY", "h", "new", "-5")
+	c.expect(protocol.Error("ERR hash value is not an integer"), "HINCRBY", "h", "c", "1")
+	c.expect(protocol.Error("ERR value is not an integer or out of range"), "HINCRBY", "h", "a", "one")
+	c.expect(protocol.BulkString("2.5"), "HINCRBYFLOAT", "h", "b", "0.5")
+	c.expect(protocol.Error("ERR value is not a valid float"), "HINCRBYFLOAT", "h", "b", "x")
+}
+
+func TestStringCommands(t *testing.T) {
+	c := newTestServer(t).dial(t)
+
+	c.expect(protocol.Integer(5), "APPEND", "s", "Hello")
+	c.expect(protocol.Integer(11), "APPEND", "s", " World")
+	c.expect(protocol.Integer(11), "STRLEN", "s")
+	c.expect(protocol.Integer(0), "STRLEN", "nokey")
+	c.expect(protocol.BulkString("World"), "GETRANGE", "s", "-5", "-1")
+	c.expect(protocol.BulkString("Hello"), "GETRANGE", "s", "0", "4")
+	c.expect(protocol.Integer(11), "SETRANGE", "s", "6", "Redis")
+	c.expect(protocol.BulkString("Hello Redis"), "GET", "s")
+	c.expect(protocol.Error("ERR offset is out of range"), "SETRANGE", "s", "-1", "x")
+
+	c.expect(protocol.BulkString("Hello Redis"), "GETSET", "s", "new")
+	c.expect(protocol.BulkString(nil), "GETSET", "fresh", "v")
+	c.expect(protocol.Integer(0), "SETNX", "s", "other")
+	c.expect(protocol.Integer(1), "SETNX", "nx", "v")
+
+	c.expect(protocol.SimpleString("OK"), "MSET", "k1", "a", "k2", "b")
+	c.expect(protocol.Array{protocol.BulkString("a"), protocol.BulkString(nil), protocol.BulkString("b")}, "MGET", "k1", "missing", "k2")
+	c.expect(protocol.Error("ERR wrong number of arguments for 'MSET' command"), "MSET", "k1", "a", "k2")
+}
+
+func TestListMoveCommands(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+
+	c.expect(protocol.Integer(3), "RPUSH", "src", "a", "b", "c")
+	c.expect(protocol.BulkString("c"), "LMOVE", "src", "dst", "RIGHT", "LEFT")
+	c.expect(protocol.BulkString("c"), "RPOPLPUSH", "dst", "dst")
+	c.expect(protocol.BulkString("a"), "BLMOVE", "src", "dst", "LEFT", "RIGHT", "0")
+	c.expect(protocol.BulkString(nil), "LMOVE", "nokey", "dst", "LEFT", "LEFT")
+	c.expect(protocol.Error("ERR syntax error"), "LMOVE", "src", "dst", "UP", "LEFT")
+
+	// a timeout is a null array, unlike LMOVE's null bulk string
+	c.expect(protocol.Array(nil), "BLMOVE", "nokey", "dst", "LEFT", "LEFT", "0.01")
+	c.expect(protocol.Error("ERR timeout is negative"), "BLMOVE", "nokey", "dst", "LEFT", "LEFT", "-1")
+
+	// a push wakes a blocked client
+	done := make(chan protocol.RESPType, 1)
+	blocked := s.dial(t)
+	go func() { done <- blocked.do("BRPOPLPUSH", "empty", "out", "5") }()
+	for s.blocks.blocked.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	c.expect(protocol.Integer(1), "LPUSH", "empty", "x")
+	if got := <-done; !reflect.DeepEqual(got, protocol.BulkString("x")) {
+		t.Errorf("BRPOPLPUSH woke with %#v, want x", got)
+	}
+}
+
+func TestListCommands(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+
+	c.expect(protocol.Integer(4), "RPUSH", "l", "a", "b", "a", "c")
+	c.expect(protocol.Integer(5), "LINSERT", "l", "BEFORE", "b", "x")
+	c.expect(protocol.Integer(6), "LINSERT", "l", "after", "c", "y")
+	c.expect(protocol.Integer(-1), "LINSERT", "l", "BEFORE", "missing", "z")
+	c.expect(protocol.Integer(0), "LINSERT", "nokey", "BEFORE", "a", "z")
+	c.expect(protocol.Error("ERR syntax error"), "LINSERT", "l", "BESIDE", "a", "z")
+	c.expect(bulks("a", "x", "b", "a", "c", "y"), "LRANGE", "l", "0", "-1")
+
+	c.expect(protocol.SimpleString("OK"), "LSET", "l", "-1", "z")
+	c.expect(protocol.Error("ERR index out of range"), "LSET", "l", "6", "z")
+	c.expect(protocol.Error("ERR no such key"), "LSET", "nokey", "0", "z")
+
+	c.expect(protocol.Integer(0), "LPOS", "l", "a")
+	c.expect(protocol.Integer(3), "LPOS", "l", "a", "RANK", "2")
+	c.expect(protocol.Integer(3), "LPOS", "l", "a", "RANK", "-1")
+	c.expect(protocol.Array{protocol.Integer(0), protocol.Integer(3)}, "LPOS", "l", "a", "COUNT", "0")
+	c.expect(protocol.Array{protocol.Integer(0)}, "LPOS", "l", "a", "COUNT", "0", "MAXLEN", "3")
+	c.expect(protocol.BulkString(nil), "LPOS", "l", "missing")
+	c.expect(protocol.Error("ERR COUNT can't be negative"), "LPOS", "l", "a", "COUNT", "-1")
+
+	// the last match goes first with a negative count
+	c.expect(protocol.Integer(1), "LREM", "l", "-1", "a")
+	c.expect(bulks("a", "x", "b", "c", "z"), "LRANGE", "l", "0", "-1")
+	c.expect(protocol.SimpleString("OK"), "LTRIM", "l", "1", "-2")
+	c.expect(bulks("x", "b", "c"), "LRANGE", "l", "0", "-1")
+	// trimming to nothing deletes the list
+	c.expect(protocol.SimpleString("OK"), "LTRIM", "l", "5", "10")
+	c.expect(protocol.Integer(0), "EXISTS", "l")
+
+	c.expect(protocol.Integer(3), "RPUSH", "l2", "a", "b", "c")
+	c.expect(protocol.Array{protocol.BulkString("l2"), bulks("c", "b")}, "LMPOP", "2", "l", "l2", "RIGHT", "COUNT", "2")
+	c.expect(protocol.Array{protocol.BulkString("l2"), bulks("a")}, "LMPOP", "2", "l", "l2", "LEFT", "COUNT", "5")
+	c.expect(protocol.Array(nil), "LMPOP", "2", "l", "l2", "LEFT")
+	c.expect(protocol.Error("ERR numkeys should be greater than 0"), "LMPOP", "0", "l", "LEFT")
+}
@@ -0,0 +1,277 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"multithreaded-redis/internal/datastuctures"
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// infoSection renders one "# Name" block of the INFO reply as field:value lines
+type infoSection struct {
+	name   string
+	render func(s *Server) []string
+}
+
+// infoSections are listed in the order INFO prints them
+var infoSections = []infoSection{
+	{"Server", (*Server).infoServer},
+	{"Clients", (*Server).infoClients},
+	{"Memory", (*Server).infoMemory},
+	{"Stats", (*Server).infoStats},
+	{"Replication", (*Server).infoReplication},
+	{"Keyspace", (*Server).infoKeyspace},
+	{"Warmup", (*Server).infoWarmup},
+	{"Events", (*Server).infoEvents},
+}
+
+// INFO [section ...]. RESP3 clients get a map of section name to a map of
+// fields instead of the text report.
+func (s *Server) handleInfo(c net.Conn, args []string) {
+	want := map[string]bool{}
+	for _, a := range args[1:] {
+		want[strings.ToLower(a)] = true
+	}
+	all := len(want) == 0 || want["all"] || want["everything"] || want["default"]
+
+	if cl := s.client(c); cl != nil && cl.proto.Load() >= 3 {
+		m := protocol.Map{}
+		for _, sec := range infoSections {
+			if !all && !want[strings.ToLower(sec.name)] {
+				continue
+			}
+			fields := protocol.Map{}
+			for _, line := range sec.render(s) {
+				name, value, _ := strings.Cut(line, ":")
+				fields = append(fields, protocol.BulkString(name), protocol.BulkString(value))
+			}
+			m = append(m, protocol.BulkString(strings.ToLower(sec.name)), fields)
+		}
+		s.reply(c, m)
+		return
+	}
+
+	var b strings.Builder
+	for _, sec := range infoSections {
+		if !all && !want[strings.ToLower(sec.name)] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + sec.name + "\r\n")
+		for _, line := range sec.render(s) {
+			b.WriteString(line + "\r\n")
+		}
+	}
+	s.reply(c, protocol.BulkString(b.String()))
+}
+
+func (s *Server) infoServer() []string {
+	uptime := time.Since(s.startTime)
+	return []string{
+		"server_version:" + serverVersion,
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		"tcp_addr:" + s.addr,
+		fmt.Sprintf("uptime_in_seconds:%d", int64(uptime.Seconds())),
+		fmt.Sprintf("uptime_in_days:%d", int64(uptime.Hours()/24)),
+		fmt.Sprintf("shards:%d", len(s.shards.GetNodes())),
+	}
+}
+
+func (s *Server) infoClients() []string {
+	s.mu.Lock()
+	n := len(s.conns)
+	s.mu.Unlock()
+	return []string{
+		fmt.Sprintf("connected_clients:%d", n),
+		fmt.Sprintf("blocked_clients:%d", s.blocks.blocked.Load()),
+	}
+}
+
+func (s *Server) infoMemory() []string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	limits := s.shards.Limits()
+	maxmemory, policy := s.shards.MaxMemory()
+	var dataset int64
+	for _, sh := range s.shards.ShardStats() {
+		dataset += sh.MemoryBytes
+	}
+	interned, shared := s.shards.InternStats()
+	return []string{
+		fmt.Sprintf("used_memory:%d", ms.HeapAlloc),
+		fmt.Sprintf("used_memory_sys:%d", ms.Sys),
+		fmt.Sprintf("used_memory_dataset:%d", dataset),
+		fmt.Sprintf("memory_guard_limit:%d", s.memGuard.limit.Load()),
+		fmt.Sprintf("memory_guard_usage:%d", s.memGuard.usage.Load()),
+		"memory_guard_tier:" + memoryTierNames[s.memGuard.tier.Load()],
+		fmt.Sprintf("memory_guard_evicted_keys:%d", s.memGuard.evicted.Load()),
+		fmt.Sprintf("memory_guard_rejected_writes:%d", s.memGuard.rejected.Load()),
+		fmt.Sprintf("memory_guard_closed_clients:%d", s.memGuard.closed.Load()),
+		"storage_engine:" + s.cfg.Snapshot().Storage.Engine,
+		fmt.Sprintf("gc_cycles:%d", ms.NumGC),
+		fmt.Sprintf("maxmemory:%d", maxmemory),
+		"maxmemory_policy:" + policy.String(),
+		fmt.Sprintf("max_value_size:%d", limits.MaxValueSize),
+		fmt.Sprintf("max_collection_len:%d", limits.MaxCollectionLen),
+		fmt.Sprintf("interned_strings:%d", interned),
+		fmt.Sprintf("interned_shared:%d", shared),
+	}
+}
+
+func (s *Server) infoStats() []string {
+	var hits, misses, evicted, restarts uint64
+	shards := s.shards.ShardStats()
+	for _, sh := range shards {
+		hits += sh.Hits
+		misses += sh.Misses
+		evicted += sh.Evicted
+		restarts += sh.Restarts
+	}
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = float64(hits) / float64(hits+misses)
+	}
+	hot := s.shards.HotKeyStats()
+	adaptive := s.shards.AdaptiveTTLStats()
+	routing := s.shards.RoutingStats()
+	expiry := s.shards.ExpiryStats()
+	lines := []string{
+		fmt.Sprintf("total_connections_received:%d", s.totalConnections.Load()),
+		fmt.Sprintf("rejected_connections:%d", s.accept.rejected.Load()),
+		fmt.Sprintf("accept_queue_length:%d", len(s.accept.queue)),
+		fmt.Sprintf("total_commands_processed:%d", s.totalCommands.Load()),
+		fmt.Sprintf("keyspace_hits:%d", hits),
+		fmt.Sprintf("keyspace_misses:%d", misses),
+		fmt.Sprintf("keyspace_hit_ratio:%.4f", ratio),
+		fmt.Sprintf("expired_keys:%d", expiry.Expired),
+		fmt.Sprintf("expired_stale_perc:%.2f", expiry.StalePerc),
+		fmt.Sprintf("expired_time_cap_reached_count:%d", expiry.TimeCapped),
+		fmt.Sprintf("expire_cycle_cpu_milliseconds:%d", expiry.CycleTime.Milliseconds()),
+		fmt.Sprintf("evicted_keys:%d", evicted),
+		fmt.Sprintf("shard_worker_restarts:%d", restarts),
+		fmt.Sprintf("hot_keys:%d", len(hot.HotKeys)),
+		fmt.Sprintf("hot_key_replica_hits:%d", hot.ReplicaHits),
+		fmt.Sprintf("adaptive_ttl_extended:%d", adaptive.Extended),
+		fmt.Sprintf("adaptive_ttl_shortened:%d", adaptive.Shortened),
+		fmt.Sprintf("ownership_audits:%d", s.audits.Load()),
+		fmt.Sprintf("vnode_reweights:%d", s.shards.Reweights()),
+		fmt.Sprintf("forwarded_requests:%d", routing.Forwarded),
+		fmt.Sprintf("ask_redirects:%d", routing.Asked),
+		fmt.Sprintf("forward_hop_limit_exceeded:%d", routing.HopLimit),
+	}
+	if s.mqtt.out != nil {
+		lines = append(lines,
+			fmt.Sprintf("mqtt_bridge_connected:%d", boolInt(s.mqtt.connected.Load())),
+			fmt.Sprintf("mqtt_messages_received:%d", s.mqtt.received.Load()),
+			fmt.Sprintf("mqtt_messages_sent:%d", s.mqtt.sent.Load()),
+			fmt.Sprintf("mqtt_messages_dropped:%d", s.mqtt.dropped.Load()),
+		)
+	}
+	if a := s.lastAudit.Load(); a != nil {
+		lines = append(lines,
+			fmt.Sprintf("ownership_last_audit_scanned:%d", a.Scanned),
+			fmt.Sprintf("ownership_last_audit_misplaced:%d", a.Misplaced),
+			fmt.Sprintf("ownership_last_audit_age_seconds:%d", int64(time.Since(a.Started).Seconds())),
+		)
+	}
+	for _, sh := range shards {
+		for _, l := range sh.Lanes {
+			lines = append(lines, fmt.Sprintf("%s_%s_lane:queued=%d,processed=%d,avg_wait_us=%d,max_wait_us=%d",
+				sh.NodeID, l.Lane, l.Queued, l.Processed, l.AvgWait.Microseconds(), l.MaxWait.Microseconds()))
+		}
+	}
+	return lines
+}
+
+// infoKeyspace reports one line per shard in place of Redis's per-db lines
+func (s *Server) infoKeyspace() []string {
+	lines := []string{}
+	for _, sh := range s.shards.ShardStats() {
+		lines = append(lines, fmt.Sprintf("%s:keys=%d,expires=%d,memory=%d,hits=%d,misses=%d,vnodes=%d",
+			sh.NodeID, sh.Keys, sh.Expires, sh.MemoryBytes, sh.Hits, sh.Misses, sh.VNodes))
+	}
+	return lines
+}
+
+func (s *Server) infoWarmup() []string {
+	st := s.shards.WarmupStatus()
+	lines := []string{
+		"warmup_state:" + st.State,
+		fmt.Sprintf("warmup_keys_total:%d", st.Total),
+		fmt.Sprintf("warmup_keys_loaded:%d", st.Loaded),
+		fmt.Sprintf("warmup_keys_missing:%d", st.Missing),
+		fmt.Sprintf("warmup_keys_failed:%d", st.Failed),
+	}
+	if st.Total > 0 {
+		done := st.Loaded + st.Missing + st.Failed
+		lines = append(lines, fmt.Sprintf("warmup_progress_perc:%.2f%%", 100*float64(done)/float64(st.Total)))
+	}
+	if !st.Started.IsZero() {
+		end := st.Finished
+		if end.IsZero() {
+			end = time.Now()
+		}
+		lines = append(lines, fmt.Sprintf("warmup_elapsed_ms:%d", end.Sub(st.Started).Milliseconds()))
+	}
+	return lines
+}
+
+// MEMORY STATS
+func (s *Server) handleMemory(c net.Conn, args []string) {
+	sub := args[1]
+	if strings.ToUpper(sub) != "STATS" {
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+sub+"'. Try MEMORY STATS."))
+		return
+	}
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	var keys int
+	var dataset int64
+	var df store.DefragStats
+	for _, sh := range s.shards.ShardStats() {
+		keys += sh.Keys
+		dataset += sh.MemoryBytes
+		df.Runs += sh.Defrag.Runs
+		df.MapsRebuilt += sh.Defrag.MapsRebuilt
+		df.SlicesTrimmed += sh.Defrag.SlicesTrimmed
+		df.KeyspaceRebuilds += sh.Defrag.KeyspaceRebuilds
+		df.BytesReclaimed += sh.Defrag.BytesReclaimed
+	}
+	slabs := datastuctures.Slabs()
+	// like jemalloc's ratio: memory held in in-use spans versus live objects
+	frag := 0.0
+	if ms.HeapAlloc > 0 {
+		frag = float64(ms.HeapInuse) / float64(ms.HeapAlloc)
+	}
+
+	s.reply(c, protocol.Map{
+		protocol.BulkString("total.allocated"), protocol.Integer(ms.HeapAlloc),
+		protocol.BulkString("heap.inuse"), protocol.Integer(ms.HeapInuse),
+		protocol.BulkString("heap.idle"), protocol.Integer(ms.HeapIdle),
+		protocol.BulkString("heap.released"), protocol.Integer(ms.HeapReleased),
+		protocol.BulkString("heap.objects"), protocol.Integer(ms.HeapObjects),
+		protocol.BulkString("fragmentation"), protocol.BulkString(fmt.Sprintf("%.4f", frag)),
+		protocol.BulkString("fragmentation.bytes"), protocol.Integer(int64(ms.HeapInuse) - int64(ms.HeapAlloc)),
+		protocol.BulkString("keys.count"), protocol.Integer(keys),
+		protocol.BulkString("dataset.bytes"), protocol.Integer(dataset),
+		protocol.BulkString("defrag.runs"), protocol.Integer(df.Runs),
+		protocol.BulkString("defrag.maps_rebuilt"), protocol.Integer(df.MapsRebuilt),
+		protocol.BulkString("defrag.slices_trimmed"), protocol.Integer(df.SlicesTrimmed),
+		protocol.BulkString("defrag.keyspace_rebuilds"), protocol.Integer(df.KeyspaceRebuilds),
+		protocol.BulkString("defrag.bytes_reclaimed"), protocol.Integer(df.BytesReclaimed),
+		protocol.BulkString("arena.blocks"), protocol.Integer(slabs.Blocks),
+		protocol.BulkString("arena.bytes"), protocol.Integer(slabs.Bytes),
+		protocol.BulkString("arena.nodes"), protocol.Integer(slabs.Nodes),
+		protocol.BulkString("arena.free_nodes"), protocol.Integer(slabs.FreeNodes),
+	})
+}
@@ -0,0 +1,106 @@
+package net
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// KEYGROUP ADD group key [key ...] | REMOVE key [key ...] | GET key |
+// MEMBERS group | LIST
+//
+// Places keys by a group name instead of their own, so they share a shard
+// and multi-key commands on them run there atomically, as hash tags would
+// without changing the keys' names. Keys need not exist: a key added before
+// it is written is created on the group's shard. A group holds at most
+// store.MaxKeyGroupSize keys. ADD and REMOVE reply with the number of keys
+// that changed group; keys already in another group switch to the new one.
+// Groups are local to the server and kept in memory only.
+func (s *Server) handleKeyGroup(c net.Conn, args []string) {
+	sub := args[1]
+	switch strings.ToUpper(sub) {
+	case "ADD":
+		if len(args) < 4 {
+			s.reply(c, protocol.Error("ERR wrong number of arguments for 'keygroup|add' command"))
+			return
+		}
+		group := args[2]
+		if group == "" {
+			s.reply(c, protocol.Error("ERR group name may not be empty"))
+			return
+		}
+		s.setKeyGroups(c, group, args[3:])
+
+	case "REMOVE":
+		if len(args) < 3 {
+			s.reply(c, protocol.Error("ERR wrong number of arguments for 'keygroup|remove' command"))
+			return
+		}
+		s.setKeyGroups(c, "", args[2:])
+
+	case "GET":
+		if len(args) != 3 {
+			s.reply(c, protocol.Error("ERR wrong number of arguments for 'keygroup|get' command"))
+			return
+		}
+		key := args[2]
+		if g := s.shards.KeyGroup(s.dbKey(c, key)); g != "" {
+			s.reply(c, protocol.BulkString(g))
+			return
+		}
+		s.reply(c, protocol.BulkString(nil))
+
+	case "MEMBERS":
+		if len(args) != 3 {
+			s.reply(c, protocol.Error("ERR wrong number of arguments for 'keygroup|members' command"))
+			return
+		}
+		group := args[2]
+		db := s.db(c)
+		arr := protocol.Array{}
+		for _, k := range s.shards.KeyGroupMembers(group) {
+			if d, key := store.KeyDB(k); d == db {
+				arr = append(arr, protocol.BulkString(key))
+			}
+		}
+		s.reply(c, arr)
+
+	case "LIST":
+		groups := s.shards.KeyGroups()
+		names := make([]string, 0, len(groups))
+		for g := range groups {
+			names = append(names, g)
+		}
+		sort.Strings(names)
+		m := protocol.Map{}
+		for _, g := range names {
+			m = append(m, protocol.BulkString(g), protocol.Integer(groups[g]))
+		}
+		s.reply(c, m)
+
+	default:
+		s.reply(c, protocol.Error("ERR KEYGROUP subcommand must be ADD, REMOVE, GET, MEMBERS or LIST"))
+	}
+}
+
+// setKeyGroups moves keys into group, or out of their groups when group is
+// "", replying with how many changed group. It stops at the first error,
+// leaving the keys before it moved.
+func (s *Server) setKeyGroups(c net.Conn, group string, keys []string) {
+	changed := 0
+	for _, k := range keys {
+		key := s.dbKey(c, k)
+		if s.shards.KeyGroup(key) == group {
+			continue
+		}
+		if err := s.shards.SetKeyGroup(key, group); err != nil {
+			s.reply(c, protocol.Error(err.Error()))
+			return
+		}
+		changed++
+	}
+	s.reply(c, protocol.Integer(changed))
+}
@@ -0,0 +1,341 @@
+package net
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// KEYS pattern
+func (s *Server) handleKeys(c net.Conn, args []string) {
+	pattern := args[1]
+	if err := store.CheckGlob(pattern); err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	filter := store.ScanFilter{Match: pattern, DB: s.db(c)}
+	arr := protocol.Array{}
+	for cursor := uint64(0); ; {
+		var keys []string
+		keys, cursor = s.shards.Scan(cursor, 0, filter)
+		for _, k := range keys {
+			arr = append(arr, protocol.BulkString(k))
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	s.reply(c, arr)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func (s *Server) handleScan(c net.Conn, args []string) {
+	cursorArg := args[1]
+	cursor, err := strconv.ParseUint(cursorArg, 10, 64)
+	if err != nil {
+		s.reply(c, protocol.Error("ERR invalid cursor"))
+		return
+	}
+
+	count := 10
+	filter := store.ScanFilter{DB: s.db(c)}
+	for i := 2; i < len(args); i += 2 {
+		opt := args[i]
+		if i+1 >= len(args) {
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+		val := args[i+1]
+		switch strings.ToUpper(opt) {
+		case "MATCH":
+			if err := store.CheckGlob(val); err != nil {
+				s.reply(c, protocol.Error(err.Error()))
+				return
+			}
+			filter.Match = val
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+				return
+			}
+			count = n
+		case "TYPE":
+			t, err := store.ParseValueType(val)
+			if err != nil {
+				s.reply(c, protocol.Error("ERR "+err.Error()))
+				return
+			}
+			filter.Type, filter.HasType = t, true
+		default:
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+	}
+
+	keys, next := s.shards.Scan(cursor, count, filter)
+	arr := make(protocol.Array, len(keys))
+	for i, k := range keys {
+		arr[i] = protocol.BulkString(k)
+	}
+	s.reply(c, protocol.Array{
+		protocol.BulkString(strconv.FormatUint(next, 10)),
+		arr,
+	})
+}
+
+// TYPESTATS reports how many keys of each type exist, from the type indexes
+func (s *Server) handleTypeStats(c net.Conn, args []string) {
+	stats := s.shards.TypeStats()
+	m := protocol.Map{}
+	for _, t := range []store.ValueType{store.StringType, store.ListType, store.SetType, store.HashType, store.ZSetType, store.CMSType, store.BFType} {
+		m = append(m, protocol.BulkString(t.String()), protocol.Integer(stats[t]))
+	}
+	s.reply(c, m)
+}
+
+// EXISTS key [key ...]; a key named twice counts twice
+func (s *Server) handleExists(c net.Conn, args []string) {
+	n := 0
+	for _, r := range s.executeMulti(c, "EXISTS", store.KeyOps(args[1:])) {
+		if ok, _ := r.(bool); ok {
+			n++
+		}
+	}
+	s.reply(c, protocol.Integer(n))
+}
+
+// TYPE key
+func (s *Server) handleType(c net.Conn, args []string) {
+	key := args[1]
+	switch v := s.execute(c, "TYPE", key).(type) {
+	case store.ValueType:
+		s.reply(c, protocol.SimpleString(v.String()))
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+	default:
+		s.reply(c, protocol.SimpleString("none"))
+	}
+}
+
+// TTLSTATS reports the keys with a TTL: how many, a histogram of their
+// remaining TTLs keyed by bucket bound ("<=10s" ... ">7d"), and a forecast
+// of how many expire in each of the next 60 minutes
+func (s *Server) handleTTLStats(c net.Conn, args []string) {
+	st := s.shards.TTLStats()
+	hist := make(protocol.Map, 0, 2*len(st.Histogram))
+	for i, n := range st.Histogram {
+		var label string
+		if i < len(store.TTLBuckets) {
+			label = "<=" + shortDuration(store.TTLBuckets[i])
+		} else {
+			label = ">" + shortDuration(store.TTLBuckets[len(store.TTLBuckets)-1])
+		}
+		hist = append(hist, protocol.BulkString(label), protocol.Integer(n))
+	}
+	forecast := make(protocol.Array, len(st.Forecast))
+	for i, n := range st.Forecast {
+		forecast[i] = protocol.Integer(n)
+	}
+	s.reply(c, protocol.Map{
+		protocol.BulkString("keys"), protocol.Integer(st.Keys),
+		protocol.BulkString("histogram"), hist,
+		protocol.BulkString("expiring_per_minute"), forecast,
+	})
+}
+
+// shortDuration writes d in its largest whole unit, e.g. 15m or 7d
+func shortDuration(d time.Duration) string {
+	switch {
+	case d%(24*time.Hour) == 0:
+		return strconv.FormatInt(int64(d/(24*time.Hour)), 10) + "d"
+	case d%time.Hour == 0:
+		return strconv.FormatInt(int64(d/time.Hour), 10) + "h"
+	case d%time.Minute == 0:
+		return strconv.FormatInt(int64(d/time.Minute), 10) + "m"
+	}
+	return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+}
+
+// OBJECT ENCODING|IDLETIME|FREQ|REFCOUNT key | OBJECT HELP
+func (s *Server) handleObject(c net.Conn, args []string) {
+	sub := args[1]
+	name := strings.ToUpper(sub)
+	if name == "HELP" && len(args) == 2 {
+		s.reply(c, protocol.Array{
+			protocol.SimpleString("OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			protocol.SimpleString("ENCODING <key>"),
+			protocol.SimpleString("    Return the kind of internal representation used in order to store the value"),
+			protocol.SimpleString("    associated with a <key>."),
+			protocol.SimpleString("FREQ <key>"),
+			protocol.SimpleString("    Return the reads of the <key> in the current hotkey-window."),
+			protocol.SimpleString("IDLETIME <key>"),
+			protocol.SimpleString("    Return the idle time of the <key>, that is the approximated number of"),
+			protocol.SimpleString("    seconds elapsed since the last access to the key."),
+			protocol.SimpleString("REFCOUNT <key>"),
+			protocol.SimpleString("    Return the number of references of the value associated with the specified"),
+			protocol.SimpleString("    <key>."),
+		})
+		return
+	}
+	if len(args) != 3 || (name != "ENCODING" && name != "IDLETIME" && name != "FREQ" && name != "REFCOUNT") {
+		s.reply(c, protocol.Error("ERR unknown subcommand or wrong number of arguments for '"+sub+"'. Try OBJECT HELP."))
+		return
+	}
+	key := args[2]
+	var info store.ObjectInfo
+	switch v := s.execute(c, "OBJECT", key).(type) {
+	case store.ObjectInfo:
+		info = v
+	case error:
+		s.reply(c, protocol.Error(v.Error()))
+		return
+	default:
+		s.reply(c, protocol.BulkString(nil))
+		return
+	}
+	switch name {
+	case "ENCODING":
+		s.reply(c, protocol.BulkString(info.Encoding))
+	case "IDLETIME":
+		s.reply(c, protocol.Integer(int64(info.Idle/time.Second)))
+	case "FREQ":
+		freq, ok := s.shards.KeyFreq(key)
+		if !ok {
+			s.reply(c, protocol.Error("ERR access frequency not tracked, set hotkey-threshold to count reads"))
+			return
+		}
+		s.reply(c, protocol.Integer(freq))
+	case "REFCOUNT":
+		// values are never shared between keys
+		s.reply(c, protocol.Integer(1))
+	}
+}
+
+// RANDOMKEY
+func (s *Server) handleRandomKey(c net.Conn, args []string) {
+	key, ok := s.shards.RandomKey(s.db(c))
+	if !ok {
+		s.reply(c, protocol.BulkString(nil))
+		return
+	}
+	s.reply(c, protocol.BulkString(key))
+}
+
+// RENAME key newkey | RENAMENX key newkey
+func (s *Server) handleRename(c net.Conn, args []string) {
+	name := args[0]
+	src := args[1]
+	dst := args[2]
+	nx := strings.EqualFold(name, "RENAMENX")
+	done, err := s.shards.Rename(src, dst, nx)
+	switch {
+	case err != nil:
+		s.reply(c, protocol.Error(err.Error()))
+	case !nx:
+		s.reply(c, protocol.SimpleString("OK"))
+	case done:
+		s.reply(c, protocol.Integer(1))
+	default:
+		s.reply(c, protocol.Integer(0))
+	}
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func (s *Server) handleCopy(c net.Conn, args []string) {
+	src := args[1]
+	dst := args[2]
+	target := dst
+	replace := false
+	for i := 3; i < len(args); i++ {
+		opt := args[i]
+		switch {
+		case strings.EqualFold(opt, "REPLACE"):
+			replace = true
+		case strings.EqualFold(opt, "DB") && i+1 < len(args):
+			db, msg := s.parseDB(args[i+1])
+			if msg != "" {
+				s.reply(c, protocol.Error(msg))
+				return
+			}
+			// the destination already carries the selected database's prefix
+			_, name := store.KeyDB(dst)
+			target = store.DBKey(db, name)
+			i++
+		default:
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+	}
+	if src == target {
+		s.reply(c, protocol.Error("ERR source and destination objects are the same"))
+		return
+	}
+	copied, err := s.shards.Copy(src, target, replace)
+	if err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	if copied {
+		s.reply(c, protocol.Integer(1))
+		return
+	}
+	s.reply(c, protocol.Integer(0))
+}
+
+// DUMP key
+func (s *Server) handleDump(c net.Conn, args []string) {
+	key := args[1]
+	payload, _, ok, err := s.shards.Dump(key)
+	switch {
+	case err != nil:
+		s.reply(c, protocol.Error("ERR "+err.Error()))
+	case !ok:
+		s.reply(c, protocol.BulkString(nil))
+	default:
+		s.reply(c, protocol.BulkString(payload))
+	}
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL]. A ttl of 0 restores
+// the key without expiration; otherwise it is milliseconds from now, or a
+// unix time in milliseconds with ABSTTL.
+func (s *Server) handleRestore(c net.Conn, args []string) {
+	key := args[1]
+	ttlArg := args[2]
+	payload := []byte(args[3])
+	ms, err := strconv.ParseInt(ttlArg, 10, 64)
+	if err != nil || ms < 0 {
+		s.reply(c, protocol.Error("ERR Invalid TTL value, must be >= 0"))
+		return
+	}
+	replace, abs := false, false
+	for _, opt := range args[4:] {
+		switch strings.ToUpper(opt) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			abs = true
+		default:
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+	}
+	var ttl time.Time
+	switch {
+	case ms == 0:
+	case abs:
+		ttl = time.UnixMilli(ms)
+	default:
+		ttl = s.shards.Now().Add(time.Duration(ms) * time.Millisecond)
+	}
+	if err := s.shards.Restore(key, payload, ttl, replace); err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	s.reply(c, protocol.SimpleString("OK"))
+}
@@ -0,0 +1,186 @@
+package net
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// LINSERT key BEFORE|AFTER pivot element
+func (s *Server) handleLInsert(c net.Conn, args []string) {
+	where := strings.ToUpper(args[2])
+	if where != "BEFORE" && where != "AFTER" {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	s.writeListReply(c, s.execute(c, "LINSERT", args[1], where, args[3], args[4]))
+}
+
+// LSET key index element
+func (s *Server) handleLSet(c net.Conn, args []string) {
+	if _, err := strconv.Atoi(args[2]); err != nil {
+		s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+		return
+	}
+	s.writeListReply(c, s.execute(c, "LSET", args[1], args[2], args[3]))
+}
+
+// LREM key count element
+//
+// Removes the first count elements equal to element, the last -count when
+// count is negative, or all of them when it is 0.
+func (s *Server) handleLRem(c net.Conn, args []string) {
+	if _, err := strconv.Atoi(args[2]); err != nil {
+		s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+		return
+	}
+	s.writeListReply(c, s.execute(c, "LREM", args[1], args[2], args[3]))
+}
+
+// LTRIM key start stop
+func (s *Server) handleLTrim(c net.Conn, args []string) {
+	_, err1 := strconv.Atoi(args[2])
+	_, err2 := strconv.Atoi(args[3])
+	if err1 != nil || err2 != nil {
+		s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+		return
+	}
+	s.writeListReply(c, s.execute(c, "LTRIM", args[1], args[2], args[3]))
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+//
+// Replies with the index of the first match, or nil, unless COUNT is
+// given: then with an array of up to num-matches indexes, all of them for
+// 0.
+func (s *Server) handleLPos(c net.Conn, args []string) {
+	rank, count, maxLen := 1, -1, 0
+	for i := 3; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+		n, err := strconv.Atoi(args[i+1])
+		if err != nil {
+			s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+			return
+		}
+		var msg string
+		switch strings.ToUpper(args[i]) {
+		case "RANK":
+			if rank = n; n == 0 {
+				msg = "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"
+			}
+		case "COUNT":
+			if count = n; n < 0 {
+				msg = "ERR COUNT can't be negative"
+			}
+		case "MAXLEN":
+			if maxLen = n; n < 0 {
+				msg = "ERR MAXLEN can't be negative"
+			}
+		default:
+			msg = "ERR syntax error"
+		}
+		if msg != "" {
+			s.reply(c, protocol.Error(msg))
+			return
+		}
+	}
+
+	want := count
+	if count < 0 {
+		want = 1 // no COUNT: the first match alone
+	}
+	res := s.execute(c, "LPOS", args[1], args[2], strconv.Itoa(rank), strconv.Itoa(want), strconv.Itoa(maxLen))
+	if err, ok := res.(error); ok {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	pos, _ := res.([]int)
+	if count < 0 {
+		if len(pos) == 0 {
+			s.reply(c, protocol.BulkString(nil))
+			return
+		}
+		s.reply(c, protocol.Integer(pos[0]))
+		return
+	}
+	arr := make(protocol.Array, len(pos))
+	for i, p := range pos {
+		arr[i] = protocol.Integer(p)
+	}
+	s.reply(c, arr)
+}
+
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+//
+// Pops up to count elements, 1 by default, from the first of the keys
+// holding a non-empty list, replying with its name and the elements, or
+// nil when every list is empty. Each key is popped on its own shard in
+// turn, so keys on different shards need no common lock: a list filled
+// after LMPOP passed it is left for the next call, as if it had been
+// filled after LMPOP returned.
+func (s *Server) handleLMPop(c net.Conn, args []string) {
+	numKeys, err := strconv.Atoi(args[1])
+	if err != nil || numKeys <= 0 {
+		s.reply(c, protocol.Error("ERR numkeys should be greater than 0"))
+		return
+	}
+	if numKeys > len(args)-3 {
+		s.reply(c, protocol.Error("ERR Number of keys can't be greater than number of args"))
+		return
+	}
+	keys, rest := args[2:2+numKeys], args[2+numKeys:]
+	left, ok := parseListSide(rest[0])
+	if !ok || len(rest) != 1 && len(rest) != 3 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	count := 1
+	if len(rest) == 3 {
+		if !strings.EqualFold(rest[1], "COUNT") {
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+		if count, err = strconv.Atoi(rest[2]); err != nil || count <= 0 {
+			s.reply(c, protocol.Error("ERR count should be greater than 0"))
+			return
+		}
+	}
+
+	for _, key := range keys {
+		res := s.execute(c, "LMPOP", key, listSide(left), strconv.Itoa(count))
+		if err, ok := res.(error); ok {
+			s.reply(c, protocol.Error(err.Error()))
+			return
+		}
+		popped, _ := res.([]string)
+		if len(popped) == 0 {
+			continue
+		}
+		elems := make(protocol.Array, len(popped))
+		for i, e := range popped {
+			elems[i] = protocol.BulkString(e)
+		}
+		_, name := store.KeyDB(key)
+		s.reply(c, protocol.Array{protocol.BulkString(name), elems})
+		return
+	}
+	s.reply(c, protocol.Array(nil))
+}
+
+// writeListReply writes the reply of a list write: an error, OK or a count
+func (s *Server) writeListReply(c net.Conn, res interface{}) {
+	switch r := res.(type) {
+	case error:
+		s.reply(c, protocol.Error(r.Error()))
+	case int:
+		s.reply(c, protocol.Integer(r))
+	default:
+		s.reply(c, protocol.SimpleString("OK"))
+	}
+}
@@ -0,0 +1,154 @@
+package net
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func (s *Server) handleLMove(c net.Conn, args []string) {
+	fromLeft, ok1 := parseListSide(args[3])
+	toLeft, ok2 := parseListSide(args[4])
+	if !ok1 || !ok2 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	elem, ok, err := s.shards.LMove(args[1], args[2], fromLeft, toLeft)
+	s.writeMoveReply(c, elem, ok, err)
+}
+
+// RPOPLPUSH source destination
+func (s *Server) handleRPopLPush(c net.Conn, args []string) {
+	elem, ok, err := s.shards.LMove(args[1], args[2], false, true)
+	s.writeMoveReply(c, elem, ok, err)
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func (s *Server) handleBLMove(c net.Conn, args []string) {
+	fromLeft, ok1 := parseListSide(args[3])
+	toLeft, ok2 := parseListSide(args[4])
+	if !ok1 || !ok2 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	s.blockingMove(c, args[1], args[2], fromLeft, toLeft, args[5])
+}
+
+// BRPOPLPUSH source destination timeout
+func (s *Server) handleBRPopLPush(c net.Conn, args []string) {
+	s.blockingMove(c, args[1], args[2], false, true, args[3])
+}
+
+// blockingMove moves an element from src to dst, waiting up to timeout
+// seconds, or forever for 0, for one to arrive if src is empty. A client
+// queues behind those already waiting on src, so elements go to waiters in
+// the order they blocked.
+func (s *Server) blockingMove(c net.Conn, src, dst string, fromLeft, toLeft bool, timeout string) {
+	secs, err := strconv.ParseFloat(timeout, 64)
+	if err != nil || math.IsInf(secs, 0) || math.IsNaN(secs) {
+		s.reply(c, protocol.Error("ERR timeout is not a float or out of range"))
+		return
+	}
+	if secs < 0 {
+		s.reply(c, protocol.Error("ERR timeout is negative"))
+		return
+	}
+
+	// counted before the first attempt, so a push racing it marks src ready
+	s.blocks.blocked.Add(1)
+	defer s.blocks.blocked.Add(-1)
+
+	s.blocks.mu.Lock()
+	if len(s.blocks.waiters[src]) == 0 {
+		elem, ok, err := s.moveElement(src, dst, fromLeft, toLeft)
+		if err != nil || ok {
+			s.blocks.mu.Unlock()
+			s.writeMoveReply(c, elem, ok, err)
+			return
+		}
+	} else {
+		s.keyReady(src) // src may have filled up since the others blocked
+	}
+	w := &blockedClient{dst: dst, fromLeft: fromLeft, toLeft: toLeft, served: make(chan blockResult, 1)}
+	s.blocks.waiters[src] = append(s.blocks.waiters[src], w)
+	s.blocks.mu.Unlock()
+
+	var expire <-chan time.Time
+	if secs > 0 {
+		t := time.NewTimer(time.Duration(secs * float64(time.Second)))
+		defer t.Stop()
+		expire = t.C
+	}
+	var res blockResult
+	served := true
+	select {
+	case res = <-w.served:
+	case <-expire:
+		served = false
+	case <-s.stopCh:
+		served = false
+	}
+	if !served {
+		s.blocks.mu.Lock()
+		select {
+		case res = <-w.served: // served just as the wait ended
+			served = true
+		default:
+			s.dropWaiter(src, w)
+		}
+		s.blocks.mu.Unlock()
+	}
+	if !served {
+		// timed out: a null array, as Redis replies, not LMOVE's null bulk
+		s.reply(c, protocol.Array(nil))
+		return
+	}
+	s.writeMoveReply(c, res.elem, res.err == nil, res.err)
+}
+
+// moveElement runs the LMOVE of a blocking command and propagates it.
+// Blocking commands run outside write's replication gate, since they may
+// wait, so each move takes the gate itself.
+func (s *Server) moveElement(src, dst string, fromLeft, toLeft bool) (string, bool, error) {
+	s.repl.gate.RLock()
+	defer s.repl.gate.RUnlock()
+	elem, ok, err := s.shards.LMove(src, dst, fromLeft, toLeft)
+	if ok {
+		s.propagate([]string{"LMOVE", src, dst, listSide(fromLeft), listSide(toLeft)})
+	}
+	return elem, ok, err
+}
+
+func (s *Server) writeMoveReply(c net.Conn, elem string, ok bool, err error) {
+	switch {
+	case err != nil:
+		s.reply(c, protocol.Error(err.Error()))
+	case !ok:
+		s.reply(c, protocol.BulkString(nil))
+	default:
+		s.reply(c, protocol.BulkString(elem))
+	}
+}
+
+// parseListSide reads LEFT or RIGHT, reporting whether it is LEFT
+func parseListSide(arg string) (left, ok bool) {
+	switch strings.ToUpper(arg) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+func listSide(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
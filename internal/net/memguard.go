@@ -0,0 +1,147 @@
+package net
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"multithreaded-redis/internal/config"
+)
+
+// The memory guard keeps the kernel from OOM-killing the server, which
+// would lose every key at once, by answering memory pressure in tiers.
+// Every memoryGuardInterval it compares the memory the Go runtime holds
+// from the OS with memory-guard-limit, or the cgroup memory limit, and
+// each tier reached adds a response to those below it: warn only logs,
+// evict drops keys by maxmemory-policy whatever maxmemory is, reject
+// refuses writes that may add memory, and close-idle closes clients idle
+// for memory-guard-idle-timeout, whose buffers are memory too. Under
+// noeviction the evict tier has nothing to evict and the guard relies on
+// the tiers above it. Tier changes are logged and recorded as memory_tier
+// events; a tier is left only once usage is memoryGuardHysteresis percent
+// below it, so usage hovering at a threshold does not flap.
+
+// memoryGuardInterval is how often memory is checked
+const memoryGuardInterval = 250 * time.Millisecond
+
+// memoryGuardHysteresis is how many percent below a tier usage must fall
+// to leave it
+const memoryGuardHysteresis = 5
+
+// memoryGuardEvictShare is the percentage of each shard's memory evicted
+// per check while in the evict tier
+const memoryGuardEvictShare = 5
+
+// memory pressure tiers, in rising order
+const (
+	memoryTierNormal = iota
+	memoryTierWarn
+	memoryTierEvict
+	memoryTierReject
+	memoryTierCloseIdle
+)
+
+var memoryTierNames = [...]string{"normal", "warn", "evict", "reject", "close-idle"}
+
+type memoryGuard struct {
+	tier     atomic.Int32
+	limit    atomic.Int64 // at the last check, 0 = off
+	usage    atomic.Int64
+	evicted  atomic.Uint64 // keys evicted by the guard
+	rejected atomic.Uint64 // writes refused by the guard
+	closed   atomic.Uint64 // idle clients closed by the guard
+}
+
+// memoryGuardLoop checks memory every memoryGuardInterval
+func (s *Server) memoryGuardLoop() {
+	tick := time.NewTicker(memoryGuardInterval)
+	defer tick.Stop()
+	for range tick.C {
+		s.checkMemory(s.cfg.Snapshot().MemoryGuard)
+	}
+}
+
+// checkMemory moves to the tier memory usage calls for and runs its
+// responses
+func (s *Server) checkMemory(g config.MemoryGuard) {
+	limit := g.Limit
+	if limit == 0 {
+		limit, _ = config.MemoryLimit()
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	usage := int64(ms.Sys - ms.HeapReleased)
+	s.memGuard.limit.Store(limit)
+	s.memGuard.usage.Store(usage)
+
+	tier := memoryTierNormal
+	if limit > 0 {
+		tier = memoryTier(g, usage*100/limit, int(s.memGuard.tier.Load()))
+	}
+	if old := int(s.memGuard.tier.Swap(int32(tier))); old != tier {
+		detail := fmt.Sprintf("memory tier %s -> %s, %d of %d bytes in use", memoryTierNames[old], memoryTierNames[tier], usage, limit)
+		if limit == 0 {
+			detail = fmt.Sprintf("memory tier %s -> %s, no memory limit", memoryTierNames[old], memoryTierNames[tier])
+		}
+		log.Printf("WARNING: %s", detail)
+		s.event(eventMemoryTier, "%s", detail)
+	}
+
+	if tier >= memoryTierEvict {
+		if n := s.shards.EvictShare(memoryGuardEvictShare); n > 0 {
+			s.memGuard.evicted.Add(uint64(n))
+			// hand the evicted keys' memory back now rather than when the
+			// scavenger gets to it
+			debug.FreeOSMemory()
+		}
+	}
+	if tier >= memoryTierCloseIdle {
+		s.closeIdleClients(g.IdleTimeout)
+	}
+}
+
+// memoryTier is the tier for usage percent of the limit, given the current
+// tier
+func memoryTier(g config.MemoryGuard, perc int64, cur int) int {
+	thresholds := [...]int{memoryTierWarn: g.Warn, memoryTierEvict: g.Evict, memoryTierReject: g.Reject, memoryTierCloseIdle: g.CloseIdle}
+	tier := memoryTierNormal
+	for t := memoryTierWarn; t <= memoryTierCloseIdle; t++ {
+		at := int64(thresholds[t])
+		if at == 0 {
+			continue
+		}
+		if t <= cur {
+			at -= memoryGuardHysteresis
+		}
+		if perc >= at {
+			tier = t
+		}
+	}
+	return tier
+}
+
+// closeIdleClients closes normal clients that have sent nothing for idle;
+// replicas and subscribers are waiting by design and are kept
+func (s *Server) closeIdleClients(idle time.Duration) {
+	now := time.Now()
+	for _, cl := range s.clients() {
+		if clientType(cl) != "normal" || now.Sub(time.Unix(0, cl.lastActive.Load())) < idle {
+			continue
+		}
+		cl.conn.Close()
+		s.memGuard.closed.Add(1)
+	}
+}
+
+// memoryRejected reports whether the memory guard refuses writes that may
+// add memory, counting the refusal
+func (s *Server) memoryRejected(cmd *command) bool {
+	if !cmd.has(flagDenyOOM) || s.memGuard.tier.Load() < memoryTierReject {
+		return false
+	}
+	s.memGuard.rejected.Add(1)
+	return true
+}
@@ -0,0 +1,158 @@
+package net
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// respClient is a minimal RESP client for talking to another server: one
+// request at a time, every exchange bounded by the same timeout
+type respClient struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+func dialRESP(addr string, timeout time.Duration) (*respClient, error) {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &respClient{conn: c, r: bufio.NewReader(c), timeout: timeout}, nil
+}
+
+// do sends one command and reads its reply. An error reply is returned as
+// the reply, not as an error; errors are for the connection.
+func (rc *respClient) do(args ...protocol.RESPType) (protocol.RESPType, error) {
+	rc.conn.SetDeadline(time.Now().Add(rc.timeout))
+	if err := protocol.EncodeTo(rc.conn, protocol.Array(args)); err != nil {
+		return nil, err
+	}
+	return protocol.ParseRESP(rc.r)
+}
+
+func (rc *respClient) Close() error {
+	return rc.conn.Close()
+}
+
+// MIGRATE host port key destination-db timeout [COPY] [REPLACE]
+// [AUTH password] [AUTH2 username password] moves a key to another server:
+// it is DUMPed here, RESTOREd there, and deleted here once the target
+// accepted it, unless COPY. The timeout, in milliseconds, bounds the
+// connection and each exchange with the target; 0 means a second.
+func (s *Server) handleMigrate(c net.Conn, args []string) {
+	host := args[1]
+	port := args[2]
+	key := args[3]
+	db, msg := s.parseDB(args[4])
+	if msg != "" {
+		s.reply(c, protocol.Error(msg))
+		return
+	}
+	msArg := args[5]
+	ms, err := strconv.ParseInt(msArg, 10, 64)
+	if err != nil {
+		s.reply(c, protocol.Error("ERR value is not an integer or out of range"))
+		return
+	}
+	if ms <= 0 {
+		ms = 1000
+	}
+	timeout := time.Duration(ms) * time.Millisecond
+	copyKey, replace := false, false
+	var auth protocol.Array
+	for i := 6; i < len(args); i++ {
+		opt := args[i]
+		switch {
+		case strings.EqualFold(opt, "COPY"):
+			copyKey = true
+		case strings.EqualFold(opt, "REPLACE"):
+			replace = true
+		case strings.EqualFold(opt, "AUTH") && i+1 < len(args):
+			auth = protocol.Array{protocol.BulkString("AUTH"), protocol.BulkString(args[i+1])}
+			i++
+		case strings.EqualFold(opt, "AUTH2") && i+2 < len(args):
+			auth = protocol.Array{protocol.BulkString("AUTH"), protocol.BulkString(args[i+1]), protocol.BulkString(args[i+2])}
+			i += 2
+		default:
+			s.reply(c, protocol.Error("ERR syntax error"))
+			return
+		}
+	}
+
+	payload, ttl, ok, err := s.shards.Dump(key)
+	if err != nil {
+		s.reply(c, protocol.Error("ERR "+err.Error()))
+		return
+	}
+	if !ok {
+		s.reply(c, protocol.SimpleString("NOKEY"))
+		return
+	}
+	pttl := int64(0)
+	if !ttl.IsZero() {
+		pttl = max(ttl.Sub(s.shards.Now()).Milliseconds(), 1)
+	}
+
+	addr := net.JoinHostPort(host, port)
+	rc, err := dialRESP(addr, timeout)
+	if err != nil {
+		s.reply(c, protocol.Error("IOERR error or timeout connecting to the client"))
+		return
+	}
+	defer rc.Close()
+	// the stored name carries this server's database; the target gets the
+	// key as clients see it, in the database MIGRATE names
+	_, name := store.KeyDB(key)
+	restore := protocol.Array{
+		protocol.BulkString("RESTORE"), protocol.BulkString(name),
+		protocol.BulkString(strconv.FormatInt(pttl, 10)), protocol.BulkString(payload),
+	}
+	if replace {
+		restore = append(restore, protocol.BulkString("REPLACE"))
+	}
+	exchange := []protocol.Array{restore}
+	if db != 0 {
+		exchange = append([]protocol.Array{{protocol.BulkString("SELECT"), protocol.BulkString(strconv.Itoa(db))}}, exchange...)
+	}
+	if auth != nil {
+		exchange = append([]protocol.Array{auth}, exchange...)
+	}
+	for _, req := range exchange {
+		reply, err := rc.do(req...)
+		if err != nil {
+			s.reply(c, protocol.Error(fmt.Sprintf("IOERR error or timeout reading to target instance: %v", err)))
+			return
+		}
+		if e, ok := reply.(protocol.Error); ok {
+			s.reply(c, protocol.Error("ERR Target instance replied with error: "+strings.TrimPrefix(string(e), "ERR ")))
+			return
+		}
+	}
+
+	if !copyKey {
+		s.shards.DeleteKeys([]string{key})
+	}
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// rewriteMigrate propagates a MIGRATE that moved its key as a DEL of it, so
+// replicas drop the key instead of migrating it again
+func rewriteMigrate(args []string, reply protocol.RESPType) []string {
+	if r, ok := reply.(protocol.SimpleString); !ok || r != "OK" {
+		return nil
+	}
+	for _, opt := range args[6:] {
+		if strings.EqualFold(opt, "COPY") {
+			return nil
+		}
+	}
+	return []string{"DEL", args[3]}
+}
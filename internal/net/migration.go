@@ -0,0 +1,74 @@
+package net
+
+import (
+	"net"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// MIGRATION STATUS | PAUSE | RESUME | CANCEL
+//
+// Controls the slot moves of resharding, which ADDNODE, REMOVENODE,
+// reweighting and RESHARD START set off. STATUS reports the running or
+// last reshard with every node's share of it and an ETA in milliseconds
+// at the rate so far (-1 if unknown). PAUSE holds reshards before their
+// next key, RESUME lets them go on and CANCEL stops the running one; a
+// REMOVENODE waiting on it fails and keeps its node. Their speed is capped
+// by migration-max-keys-per-sec and migration-max-bytes-per-sec.
+func (s *Server) handleMigration(c net.Conn, args []string) {
+	sub := args[1]
+	var ok bool
+	switch strings.ToUpper(sub) {
+	case "STATUS":
+		s.reply(c, migrationMap(s.shards.ReshardStatus()))
+		return
+	case "PAUSE":
+		if ok = s.shards.PauseMigration(); ok {
+			s.event(eventMigrationPaused, "slot moves paused")
+		}
+	case "RESUME":
+		if ok = s.shards.ResumeMigration(); ok {
+			s.event(eventMigrationResumed, "slot moves resumed")
+		}
+	case "CANCEL":
+		ok = s.shards.CancelMigration()
+	default:
+		s.reply(c, protocol.Error("ERR MIGRATION subcommand must be STATUS, PAUSE, RESUME or CANCEL"))
+		return
+	}
+	// 0 when there was nothing to do: already paused, not paused, or no
+	// reshard running
+	s.reply(c, protocol.Integer(boolInt(ok)))
+}
+
+func migrationMap(st store.ReshardStatus) protocol.Map {
+	m := reshardMap(st)
+	eta := protocol.Integer(-1)
+	if st.ETA >= 0 {
+		eta = protocol.Integer(st.ETA.Milliseconds())
+	}
+	nodes := protocol.Array{}
+	for _, n := range st.Nodes {
+		nodes = append(nodes, protocol.Map{
+			protocol.BulkString("node"), protocol.BulkString(n.Node),
+			protocol.BulkString("slots_to_send"), protocol.Integer(n.SlotsToSend),
+			protocol.BulkString("slots_sent"), protocol.Integer(n.SlotsSent),
+			protocol.BulkString("slots_to_receive"), protocol.Integer(n.SlotsToReceive),
+			protocol.BulkString("slots_received"), protocol.Integer(n.SlotsReceived),
+			protocol.BulkString("keys_sent"), protocol.Integer(n.KeysSent),
+			protocol.BulkString("keys_received"), protocol.Integer(n.KeysReceived),
+			protocol.BulkString("bytes_sent"), protocol.Integer(n.BytesSent),
+			protocol.BulkString("bytes_received"), protocol.Integer(n.BytesReceived),
+			protocol.BulkString("error"), protocol.BulkString(n.Err),
+		})
+	}
+	return append(m,
+		protocol.BulkString("paused"), protocol.Integer(boolInt(st.Paused)),
+		protocol.BulkString("keys_total"), protocol.Integer(st.KeysTotal),
+		protocol.BulkString("bytes_moved"), protocol.Integer(st.BytesMoved),
+		protocol.BulkString("eta_ms"), eta,
+		protocol.BulkString("nodes"), nodes,
+	)
+}
@@ -0,0 +1,348 @@
+package net
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"multithreaded-redis/internal/config"
+	"multithreaded-redis/internal/store"
+)
+
+// The MQTT bridge relays pub/sub between this server and an MQTT broker,
+// speaking MQTT 3.1.1 at QoS 0, so devices can follow channels without a
+// broker of their own in front of the cache. A message published to a
+// channel under mqtt-channel-prefix goes to the topic under
+// mqtt-topic-prefix with the same remainder, and a message on a topic under
+// mqtt-topic-prefix is published to the matching channel: with the default
+// prefixes, channel mqtt:sensors/1 is topic redis/sensors/1. Keyspace
+// notifications are channels like any other, so a prefix such as
+// __keyspace@0__: bridges cache events.
+//
+// Messages arriving from the broker are not sent back to it. The broker
+// does send the bridge's own messages back, as it subscribes to the topics
+// it publishes to; those copies are recognized and dropped. Only a primary
+// sends to the broker, since its replicas see its messages again, but every
+// server with a bridge delivers what the broker sends it.
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect  = 1
+	mqttConnAck  = 2
+	mqttPublish  = 3
+	mqttSubscr   = 8
+	mqttSubAck   = 9
+	mqttPingReq  = 12
+	mqttPingResp = 13
+)
+
+const (
+	mqttKeepAlive   = 60 * time.Second
+	mqttTimeout     = 5 * time.Second  // to connect, and for every write
+	mqttMaxBackoff  = 30 * time.Second // between reconnects
+	mqttQueueLen    = 1024             // messages waiting for the broker; more are dropped
+	mqttMaxEchoes   = 10000            // copies awaited from the broker, see mqttEchoes
+	mqttMaxTopicLen = 65535
+)
+
+// mqttBridge is the bridge's state, see StartMQTT
+type mqttBridge struct {
+	out       chan store.PubSubMessage // nil unless the bridge is on
+	connected atomic.Bool
+	received  atomic.Uint64 // messages from the broker delivered to channels
+	sent      atomic.Uint64 // messages sent to the broker
+	dropped   atomic.Uint64 // messages for the broker lost to a full queue or an invalid topic
+	echoes    mqttEchoes
+}
+
+// mqttEchoes counts the messages sent to the broker whose copy has not come
+// back yet. QoS 0 may lose a copy, so rather than keep counts forever they
+// are dropped wholesale once there are too many, at the price of relaying
+// a few copies back.
+type mqttEchoes struct {
+	mu      sync.Mutex
+	pending map[string]int
+}
+
+func (e *mqttEchoes) sent(topic, payload string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.pending == nil || len(e.pending) >= mqttMaxEchoes {
+		e.pending = make(map[string]int)
+	}
+	e.pending[topic+"\x00"+payload]++
+}
+
+// echo reports whether a message from the broker is a copy of one the
+// bridge sent, and if so stops waiting for it
+func (e *mqttEchoes) echo(topic, payload string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	k := topic + "\x00" + payload
+	n, ok := e.pending[k]
+	if !ok {
+		return false
+	}
+	if n > 1 {
+		e.pending[k] = n - 1
+	} else {
+		delete(e.pending, k)
+	}
+	return true
+}
+
+// StartMQTT bridges pub/sub to mqtt-broker in the background, reconnecting
+// whenever the connection drops, until the server shuts down
+func (s *Server) StartMQTT() error {
+	c := s.cfg.Snapshot()
+	if strings.ContainsAny(c.MQTTTopicPrefix, "+#") {
+		return fmt.Errorf("mqtt-topic-prefix %q may not contain the MQTT wildcards + and #", c.MQTTTopicPrefix)
+	}
+	s.mqtt.out = make(chan store.PubSubMessage, mqttQueueLen)
+	s.pubsub.SetTap(func(channel, message string) {
+		if !strings.HasPrefix(channel, c.MQTTChannelPrefix) {
+			return
+		}
+		select {
+		case s.mqtt.out <- store.PubSubMessage{Channel: channel, Message: message}:
+		default:
+			s.mqtt.dropped.Add(1)
+		}
+	})
+	go s.mqttLoop(c)
+	return nil
+}
+
+func (s *Server) mqttLoop(c config.Values) {
+	clientID := c.MQTTClientID
+	if clientID == "" {
+		// brokers need only accept IDs of up to 23 characters
+		clientID = "redis-" + s.nodeID[:16]
+	}
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := s.mqttSession(c, clientID)
+		s.mqtt.connected.Store(false)
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+		if time.Since(start) > mqttMaxBackoff {
+			backoff = time.Second // it was up for a while: a new failure
+		}
+		log.Printf("WARNING: MQTT bridge to %s is down, reconnecting in %v: %v", c.MQTTBroker, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-s.stopCh:
+			return
+		}
+		backoff = min(2*backoff, mqttMaxBackoff)
+	}
+}
+
+// mqttSession connects to the broker and relays messages until the
+// connection fails or the server shuts down
+func (s *Server) mqttSession(c config.Values, clientID string) error {
+	conn, err := net.DialTimeout("tcp", c.MQTTBroker, mqttTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.stopCh:
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	if _, err := conn.Write(mqttConnectPacket(clientID)); err != nil {
+		return err
+	}
+	typ, _, body, err := s.readMQTTPacket(r)
+	if err != nil {
+		return err
+	}
+	if typ != mqttConnAck || len(body) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", typ)
+	}
+	if body[1] != 0 {
+		return fmt.Errorf("broker refused the connection with return code %d", body[1])
+	}
+	if _, err := conn.Write(mqttSubscribePacket(1, c.MQTTTopicPrefix+"#")); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+	s.mqtt.connected.Store(true)
+	log.Printf("MQTT bridge connected to %s, relaying channels %s* and topics %s#", c.MQTTBroker, c.MQTTChannelPrefix, c.MQTTTopicPrefix)
+
+	go s.mqttWriter(conn, c, done)
+	for {
+		// the broker answers the writer's pings, so silence means it is gone
+		conn.SetReadDeadline(time.Now().Add(mqttKeepAlive * 3 / 2))
+		typ, flags, body, err := s.readMQTTPacket(r)
+		if err != nil {
+			return err
+		}
+		switch typ {
+		case mqttPublish:
+			s.mqttDeliver(c, flags, body)
+		case mqttSubAck:
+			if len(body) == 3 && body[2] == 0x80 {
+				return fmt.Errorf("broker refused the subscription to %s#", c.MQTTTopicPrefix)
+			}
+		case mqttPingResp:
+		default:
+			return fmt.Errorf("unexpected packet type %d from the broker", typ)
+		}
+	}
+}
+
+// mqttWriter sends queued messages and keepalive pings until the session
+// ends. A failed write closes the connection, which ends the session.
+func (s *Server) mqttWriter(conn net.Conn, c config.Values, done <-chan struct{}) {
+	ping := time.NewTicker(mqttKeepAlive / 2)
+	defer ping.Stop()
+	for {
+		var pkt []byte
+		select {
+		case <-done:
+			return
+		case <-ping.C:
+			pkt = mqttPacket(mqttPingReq, 0, nil)
+		case m := <-s.mqtt.out:
+			if s.isReplica() {
+				continue // the primary sent it already
+			}
+			topic := c.MQTTTopicPrefix + strings.TrimPrefix(m.Channel, c.MQTTChannelPrefix)
+			if topic == "" || len(topic) > mqttMaxTopicLen || strings.ContainsAny(topic, "+#") {
+				s.mqtt.dropped.Add(1)
+				continue
+			}
+			s.mqtt.echoes.sent(topic, m.Message)
+			pkt = mqttPublishPacket(topic, m.Message)
+			s.mqtt.sent.Add(1)
+		}
+		conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+		if _, err := conn.Write(pkt); err != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// mqttDeliver publishes a message from the broker to its channel
+func (s *Server) mqttDeliver(c config.Values, flags byte, body []byte) {
+	if len(body) < 2 {
+		return
+	}
+	n := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+n {
+		return
+	}
+	topic, payload := string(body[2:2+n]), body[2+n:]
+	if flags&0x06 != 0 {
+		// QoS 1 and 2 carry a packet ID; the bridge subscribes at QoS 0,
+		// so brokers should never send them
+		if len(payload) < 2 {
+			return
+		}
+		payload = payload[2:]
+	}
+	if !strings.HasPrefix(topic, c.MQTTTopicPrefix) || s.mqtt.echoes.echo(topic, string(payload)) {
+		return
+	}
+	s.mqtt.received.Add(1)
+	s.pubsub.PublishFromTap(c.MQTTChannelPrefix+strings.TrimPrefix(topic, c.MQTTTopicPrefix), string(payload))
+}
+
+// readMQTTPacket reads one control packet, refusing any longer than
+// proto-max-bulk-len
+func (s *Server) readMQTTPacket(r *bufio.Reader) (typ, flags byte, body []byte, err error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	n, mult := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return 0, 0, nil, errors.New("malformed packet length from the broker")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n += int(b&0x7f) * mult
+		if b&0x80 == 0 {
+			break
+		}
+		mult *= 128
+	}
+	if int64(n) > s.maxBulkLen.Load() {
+		return 0, 0, nil, fmt.Errorf("packet of %d bytes from the broker exceeds proto-max-bulk-len", n)
+	}
+	body = make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, 0, nil, err
+	}
+	return h >> 4, h & 0x0f, body, nil
+}
+
+// mqttPacket frames body as a control packet of type typ
+func mqttPacket(typ, flags byte, body []byte) []byte {
+	out := []byte{typ<<4 | flags}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		out = append(out, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(out, body...)
+}
+
+func mqttAppendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// mqttConnectPacket asks for a clean session, as the bridge resubscribes
+// on every connection and QoS 0 keeps nothing for it while away
+func mqttConnectPacket(clientID string) []byte {
+	body := mqttAppendString(nil, "MQTT")
+	body = append(body, 4, 0x02) // protocol level 3.1.1, clean session
+	body = binary.BigEndian.AppendUint16(body, uint16(mqttKeepAlive/time.Second))
+	body = mqttAppendString(body, clientID)
+	return mqttPacket(mqttConnect, 0, body)
+}
+
+func mqttSubscribePacket(id uint16, filter string) []byte {
+	body := binary.BigEndian.AppendUint16(nil, id)
+	body = mqttAppendString(body, filter)
+	body = append(body, 0) // QoS 0
+	return mqttPacket(mqttSubscr, 0x02, body)
+}
+
+func mqttPublishPacket(topic, payload string) []byte {
+	body := mqttAppendString(nil, topic)
+	return mqttPacket(mqttPublish, 0, append(body, payload...))
+}
@@ -0,0 +1,274 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"multithreaded-redis/internal/config"
+	"multithreaded-redis/internal/protocol"
+)
+
+// txState is a connection's MULTI/EXEC state. The connection's goroutine
+// drives it; mu is needed because the keyspace hook marks it dirty from
+// shard workers and CLIENT INFO reads it.
+type txState struct {
+	mu      sync.Mutex
+	active  bool       // between MULTI and EXEC/DISCARD
+	queued  [][]string // commands waiting for EXEC
+	failed  bool       // a command was rejected while queuing
+	watched map[string]struct{}
+	dirty   bool // a watched key changed since WATCH
+}
+
+// depth is the number of queued commands, or -1 outside MULTI, as
+// CLIENT INFO reports it
+func (tx *txState) depth() int {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if !tx.active {
+		return -1
+	}
+	return len(tx.queued)
+}
+
+func (tx *txState) watchedKeys() []string {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	keys := make([]string, 0, len(tx.watched))
+	for k := range tx.watched {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// txExempt are the commands that run at once inside MULTI instead of being
+// queued
+var txExempt = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+	"WATCH":   true,
+	"UNWATCH": true,
+	"QUIT":    true,
+}
+
+// inMulti reports whether c has an open transaction
+func (s *Server) inMulti(c net.Conn) bool {
+	cl := s.client(c)
+	if cl == nil {
+		return false
+	}
+	cl.tx.mu.Lock()
+	defer cl.tx.mu.Unlock()
+	return cl.tx.active
+}
+
+// queue adds a command to c's transaction
+func (s *Server) queue(c net.Conn, cmd *command, args []string) {
+	if cmd.has(flagBlocking) || (cmd.has(flagPubSub) && subscribeContextCommands[cmd.name]) {
+		s.txError(c, "ERR Command not allowed inside a transaction")
+		return
+	}
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	cl.tx.mu.Lock()
+	cl.tx.queued = append(cl.tx.queued, args)
+	cl.tx.mu.Unlock()
+	s.reply(c, protocol.SimpleString("QUEUED"))
+}
+
+// txError rejects a command sent inside MULTI. Under the abort-transaction
+// policy the error is returned and EXEC will fail; under close-connection
+// the connection is dropped along with its transaction.
+func (s *Server) txError(c net.Conn, msg string) {
+	s.reply(c, protocol.Error(msg))
+	if s.cfg.Snapshot().MultiErrorPolicy == config.MultiCloseConnection {
+		log.Printf("Closing connection %s: %s inside MULTI", c.RemoteAddr(), msg)
+		c.Close()
+		return
+	}
+	if cl := s.client(c); cl != nil {
+		cl.tx.mu.Lock()
+		cl.tx.failed = true
+		cl.tx.mu.Unlock()
+	}
+}
+
+// MULTI
+func (s *Server) handleMulti(c net.Conn, args []string) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	cl.tx.mu.Lock()
+	defer cl.tx.mu.Unlock()
+	if cl.tx.active {
+		s.reply(c, protocol.Error("ERR MULTI calls can not be nested"))
+		return
+	}
+	cl.tx.active = true
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// DISCARD
+func (s *Server) handleDiscard(c net.Conn, args []string) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	if !s.endMulti(cl) {
+		s.reply(c, protocol.Error("ERR DISCARD without MULTI"))
+		return
+	}
+	s.unwatch(cl)
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// endMulti closes cl's transaction and reports whether one was open
+func (s *Server) endMulti(cl *client) bool {
+	cl.tx.mu.Lock()
+	defer cl.tx.mu.Unlock()
+	was := cl.tx.active
+	cl.tx.active, cl.tx.queued, cl.tx.failed = false, nil, false
+	return was
+}
+
+// EXEC runs the queued commands back to back and replies with an array of
+// their replies. Commands from other connections may interleave with them;
+// WATCH is the way to detect that.
+func (s *Server) handleExec(c net.Conn, args []string) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	cl.tx.mu.Lock()
+	active, failed, dirty, queued := cl.tx.active, cl.tx.failed, cl.tx.dirty, cl.tx.queued
+	cl.tx.mu.Unlock()
+	if !active {
+		s.reply(c, protocol.Error("ERR EXEC without MULTI"))
+		return
+	}
+	s.endMulti(cl)
+	s.unwatch(cl)
+	if failed {
+		s.reply(c, protocol.Error("EXECABORT Transaction discarded because of previous errors."))
+		return
+	}
+	if dirty {
+		s.reply(c, protocol.Array(nil))
+		return
+	}
+
+	tc := &txConn{Conn: c}
+	for _, q := range queued {
+		s.dispatch(tc, q[0], q)
+	}
+	if cl := s.client(c); cl != nil {
+		cl.write(func(w *bufio.Writer) error {
+			w.WriteString("*" + strconv.Itoa(len(queued)) + "\r\n")
+			_, err := w.Write(tc.buf.Bytes())
+			return err
+		})
+	}
+}
+
+// txConn collects the replies of commands run by EXEC. Lookups of client
+// state see through it to the real connection.
+type txConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (tc *txConn) Write(p []byte) (int, error) { return tc.buf.Write(p) }
+
+// WATCH key [key ...]
+func (s *Server) handleWatch(c net.Conn, args []string) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	if s.inMulti(c) {
+		s.reply(c, protocol.Error("ERR WATCH inside MULTI is not allowed"))
+		return
+	}
+	s.watchMu.Lock()
+	cl.tx.mu.Lock()
+	if cl.tx.watched == nil {
+		cl.tx.watched = make(map[string]struct{})
+	}
+	for _, key := range args[1:] {
+		k := key
+		if _, ok := cl.tx.watched[k]; ok {
+			continue
+		}
+		cl.tx.watched[k] = struct{}{}
+		if s.watchers[k] == nil {
+			s.watchers[k] = make(map[*client]struct{})
+		}
+		s.watchers[k][cl] = struct{}{}
+	}
+	cl.tx.mu.Unlock()
+	s.watching.Store(int64(len(s.watchers)))
+	s.watchMu.Unlock()
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// UNWATCH
+func (s *Server) handleUnwatch(c net.Conn, args []string) {
+	if cl := s.client(c); cl != nil {
+		s.unwatch(cl)
+	}
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// unwatch forgets every key cl watches
+func (s *Server) unwatch(cl *client) {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	cl.tx.mu.Lock()
+	defer cl.tx.mu.Unlock()
+	for k := range cl.tx.watched {
+		delete(s.watchers[k], cl)
+		if len(s.watchers[k]) == 0 {
+			delete(s.watchers, k)
+		}
+	}
+	cl.tx.watched, cl.tx.dirty = nil, false
+	s.watching.Store(int64(len(s.watchers)))
+}
+
+// touchWatched marks the transactions watching key as dirty. It runs for
+// every keyspace change, so it checks for watchers without locking first.
+func (s *Server) touchWatched(key string) {
+	if s.watching.Load() == 0 {
+		return
+	}
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for cl := range s.watchers[key] {
+		cl.tx.mu.Lock()
+		cl.tx.dirty = true
+		cl.tx.mu.Unlock()
+	}
+}
+
+// touchAllWatched marks every watching transaction dirty, for FLUSHALL
+func (s *Server) touchAllWatched() {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	for _, cls := range s.watchers {
+		for cl := range cls {
+			cl.tx.mu.Lock()
+			cl.tx.dirty = true
+			cl.tx.mu.Unlock()
+		}
+	}
+}
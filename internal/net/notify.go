@@ -0,0 +1,78 @@
+package net
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"strconv"
+
+	"multithreaded-redis/internal/store"
+)
+
+// keyspaceEvent is the store's event hook. It invalidates transactions
+// watching key, then publishes the change on
+// __keyspace@<db>__:<key> and/or __keyevent@<db>__:<event> when
+// notify-keyspace-events enables the event's class, as Redis does.
+func (s *Server) keyspaceEvent(class store.EventClass, event, key string) {
+	s.touchWatched(key)
+	s.keyReady(key)
+	enabled := store.EventClass(s.notifyClasses.Load())
+	if enabled&(store.EventKeyspace|store.EventKeyevent) == 0 || enabled&class == 0 {
+		return
+	}
+	db, name := store.KeyDB(key)
+	at := "@" + strconv.Itoa(db) + "__:"
+	if enabled&store.EventKeyspace != 0 {
+		s.pubsub.Publish("__keyspace"+at+name, event)
+	}
+	if enabled&store.EventKeyevent != 0 {
+		s.pubsub.Publish("__keyevent"+at+event, name)
+	}
+}
+
+// scoreEvent is the store's score hook. It publishes one JSON message per
+// changed member on __zscore@<db>__:<key>, for zset-score-events:
+//
+//	{"member":"alice","old_score":10,"new_score":25,"old_rank":4,"new_rank":1}
+//
+// old_score and old_rank are null for a member the write added. Ranks are
+// 0-based in ascending score order, as ZRANK counts them.
+func (s *Server) scoreEvent(key string, changes []store.ScoreChange) {
+	db, name := store.KeyDB(key)
+	channel := "__zscore@" + strconv.Itoa(db) + "__:" + name
+	for _, ch := range changes {
+		msg := scoreMessage{Member: ch.Member, NewScore: jsonScore(ch.NewScore), NewRank: ch.NewRank}
+		if ch.Existed {
+			old, rank := jsonScore(ch.OldScore), ch.OldRank
+			msg.OldScore, msg.OldRank = &old, &rank
+		}
+		b, err := json.Marshal(msg)
+		if err != nil {
+			log.Printf("ERROR: %s - Cannot encode score event: %v", name, err)
+			continue
+		}
+		s.pubsub.Publish(channel, string(b))
+	}
+}
+
+type scoreMessage struct {
+	Member   string     `json:"member"`
+	OldScore *jsonScore `json:"old_score"`
+	NewScore jsonScore  `json:"new_score"`
+	OldRank  *int       `json:"old_rank"`
+	NewRank  int        `json:"new_rank"`
+}
+
+// jsonScore is a score as a JSON number, or "inf" and "-inf", which JSON
+// numbers cannot express
+type jsonScore float64
+
+func (f jsonScore) MarshalJSON() ([]byte, error) {
+	switch {
+	case math.IsInf(float64(f), 1):
+		return []byte(`"inf"`), nil
+	case math.IsInf(float64(f), -1):
+		return []byte(`"-inf"`), nil
+	}
+	return strconv.AppendFloat(nil, float64(f), 'g', -1, 64), nil
+}
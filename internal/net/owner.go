@@ -0,0 +1,83 @@
+package net
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// auditReportKeys caps how many misplaced keys an audit reply lists
+const auditReportKeys = 100
+
+// OWNER key
+func (s *Server) handleOwner(c net.Conn, args []string) {
+	key := args[1]
+	s.reply(c, ownershipMap(s.shards.Owner(key)))
+}
+
+func ownershipMap(o store.KeyOwnership) protocol.Map {
+	residents := make(protocol.Array, len(o.Residents))
+	for i, n := range o.Residents {
+		residents[i] = protocol.BulkString(n)
+	}
+	return protocol.Map{
+		protocol.BulkString("key"), protocol.BulkString(o.Key),
+		protocol.BulkString("owner"), protocol.BulkString(o.Owner),
+		protocol.BulkString("residents"), residents,
+		protocol.BulkString("migrating"), protocol.Integer(boolInt(o.Migrating)),
+		protocol.BulkString("misplaced"), protocol.Integer(boolInt(o.Misplaced())),
+	}
+}
+
+// DEBUG OWNERSHIP-AUDIT [count]
+func (s *Server) debugOwnershipAudit(c net.Conn, args []string) {
+	limit := auditReportKeys
+	if len(args) > 2 {
+		v := args[2]
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			s.reply(c, protocol.Error("ERR count must be a non-negative integer"))
+			return
+		}
+		limit = n
+	}
+	a := s.runOwnershipAudit(limit)
+	keys := make(protocol.Array, len(a.Keys))
+	for i, o := range a.Keys {
+		keys[i] = ownershipMap(o)
+	}
+	s.reply(c, protocol.Map{
+		protocol.BulkString("scanned"), protocol.Integer(a.Scanned),
+		protocol.BulkString("misplaced"), protocol.Integer(a.Misplaced),
+		protocol.BulkString("duration_ms"), protocol.Integer(a.Duration.Milliseconds()),
+		protocol.BulkString("keys"), keys,
+	})
+}
+
+func (s *Server) runOwnershipAudit(limit int) store.OwnershipAudit {
+	a := s.shards.AuditOwnership(limit)
+	s.lastAudit.Store(&a)
+	s.audits.Add(1)
+	return a
+}
+
+// auditLoop runs the ownership audit every ownership-audit-interval, waking
+// early when the interval changes
+func (s *Server) auditLoop() {
+	for {
+		var tick <-chan time.Time
+		if d := s.cfg.Snapshot().OwnershipAuditInterval; d > 0 {
+			tick = time.After(d)
+		}
+		select {
+		case <-tick:
+			s.runOwnershipAudit(auditReportKeys)
+		case <-s.auditReset:
+		case <-s.stopCh:
+			return
+		}
+	}
+}
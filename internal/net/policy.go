@@ -0,0 +1,66 @@
+package net
+
+import (
+	"log"
+	"strings"
+
+	"multithreaded-redis/internal/config"
+)
+
+// Command policies, from command-policy, turn commands off or limit them to
+// admins while the server runs, so an expensive command can be stopped
+// during an incident without a restart. dispatch checks them after ACLs,
+// before a command is queued by MULTI or run, so a transaction queued
+// before a policy changed is checked again by EXEC. Commands arriving from
+// a primary are never refused, or a replica would drift from it.
+
+// policyExempt commands cannot be limited, since CONFIG SET is how a policy
+// is lifted again
+var policyExempt = map[string]bool{"CONFIG": true, "AUTH": true, "HELLO": true, "QUIT": true}
+
+// setPolicies resolves the configured command policies. Entries naming an
+// unknown or exempt command are logged and left out.
+func (s *Server) setPolicies(policies map[string]string) {
+	m := make(map[string]string, len(policies))
+	for name, policy := range policies {
+		cmd, ok := s.lookupCommand(name)
+		if !ok {
+			log.Printf("command-policy: unknown command %s, ignoring its policy", name)
+			continue
+		}
+		if policyExempt[cmd.name] {
+			log.Printf("command-policy: %s cannot be limited, ignoring its policy", cmd.name)
+			continue
+		}
+		m[cmd.name] = policy
+	}
+	s.policies.Store(&m)
+}
+
+// checkPolicy returns the error refusing cmd to cl under command-policy,
+// or "" if it may run
+func (s *Server) checkPolicy(cl *client, cmd *command) string {
+	m := s.policies.Load()
+	if m == nil {
+		return ""
+	}
+	switch (*m)[cmd.name] {
+	case config.CommandPolicyDeny:
+		return "ERR the '" + strings.ToLower(cmd.name) + "' command is disabled by command-policy"
+	case config.CommandPolicyAdminOnly:
+		if u := s.user(cl.user.Load().(string)); u == nil || !u.isAdmin() {
+			return "NOPERM the '" + strings.ToLower(cmd.name) + "' command is limited to admins by command-policy"
+		}
+	}
+	return ""
+}
+
+// isAdmin reports whether u may run every @admin command
+func (u *aclUser) isAdmin() bool {
+	for _, cmd := range commandTable {
+		if cmd.has(flagAdmin) && !u.canRun(cmd) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,234 @@
+package net
+
+import (
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// subscriptions is a connection's pub/sub state. The message channel and the
+// goroutine forwarding it to the connection are created by the first
+// (P)SUBSCRIBE and live until the connection closes.
+type subscriptions struct {
+	mu       sync.Mutex
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	msgs     chan store.PubSubMessage
+	stop     chan struct{}
+	closed   bool
+}
+
+// count is the number of channels and patterns held, as reported in
+// (un)subscribe replies. Callers must hold sub.mu.
+func (sub *subscriptions) count() int {
+	return len(sub.channels) + len(sub.patterns)
+}
+
+// active reports whether the connection holds any subscription
+func (sub *subscriptions) active() bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.count() > 0
+}
+
+func (s *Server) subscribe(c net.Conn, args []string, pattern bool) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	sub := &cl.subs
+	kind := "subscribe"
+	if pattern {
+		kind = "psubscribe"
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	if sub.msgs == nil {
+		sub.channels = make(map[string]struct{})
+		sub.patterns = make(map[string]struct{})
+		sub.msgs = make(chan store.PubSubMessage, 100) // Buffer to prevent blocking
+		sub.stop = make(chan struct{})
+		go s.forwardMessages(c, sub.msgs, sub.stop)
+	}
+
+	held := sub.channels
+	if pattern {
+		held = sub.patterns
+	}
+	for _, name := range args[1:] {
+		if _, ok := held[name]; !ok {
+			held[name] = struct{}{}
+			if pattern {
+				s.pubsub.PSubscribe([]string{name}, sub.msgs)
+			} else {
+				s.pubsub.Subscribe([]string{name}, sub.msgs)
+			}
+		}
+		s.reply(c, protocol.Array{
+			protocol.BulkString(kind),
+			protocol.BulkString(name),
+			protocol.Integer(sub.count()),
+		})
+	}
+}
+
+// unsubscribe drops the named channels or patterns, or all of them when none
+// are named
+func (s *Server) unsubscribe(c net.Conn, args []string, pattern bool) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	sub := &cl.subs
+	kind := "unsubscribe"
+	if pattern {
+		kind = "punsubscribe"
+	}
+
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	held := sub.channels
+	if pattern {
+		held = sub.patterns
+	}
+
+	names := args[1:]
+	if len(names) == 0 {
+		for name := range held {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	if len(names) == 0 {
+		s.reply(c, protocol.Array{
+			protocol.BulkString(kind),
+			protocol.BulkString(nil),
+			protocol.Integer(sub.count()),
+		})
+		return
+	}
+
+	for _, name := range names {
+		if _, ok := held[name]; ok {
+			delete(held, name)
+			if pattern {
+				s.pubsub.PUnsubscribe([]string{name}, sub.msgs)
+			} else {
+				s.pubsub.Unsubscribe([]string{name}, sub.msgs)
+			}
+		}
+		s.reply(c, protocol.Array{
+			protocol.BulkString(kind),
+			protocol.BulkString(name),
+			protocol.Integer(sub.count()),
+		})
+	}
+}
+
+// forwardMessages writes published messages to the subscriber connection
+func (s *Server) forwardMessages(c net.Conn, msgs chan store.PubSubMessage, stop chan struct{}) {
+	for {
+		select {
+		case message := <-msgs:
+			// ["message", channel, message] or ["pmessage", pattern, channel, message]
+			response := protocol.Array{
+				protocol.BulkString("message"),
+				protocol.BulkString(message.Channel),
+				protocol.BulkString(message.Message),
+			}
+			if message.Pattern != "" {
+				response = protocol.Array{
+					protocol.BulkString("pmessage"),
+					protocol.BulkString(message.Pattern),
+					protocol.BulkString(message.Channel),
+					protocol.BulkString(message.Message),
+				}
+			}
+			if err := s.reply(c, response); err != nil {
+				log.Printf("Failed to send message to subscriber: %v", err)
+				return
+			}
+		case <-stop:
+			return
+		case <-s.stopCh:
+			return // Server shutting down
+		}
+	}
+}
+
+// closeSubscriptions removes every subscription of a closing connection and
+// stops its forwarder. Safe to call more than once.
+func (s *Server) closeSubscriptions(sub *subscriptions) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	if sub.msgs == nil {
+		return
+	}
+	channels := make([]string, 0, len(sub.channels))
+	for name := range sub.channels {
+		channels = append(channels, name)
+	}
+	patterns := make([]string, 0, len(sub.patterns))
+	for name := range sub.patterns {
+		patterns = append(patterns, name)
+	}
+	s.pubsub.Unsubscribe(channels, sub.msgs)
+	s.pubsub.PUnsubscribe(patterns, sub.msgs)
+	sub.channels, sub.patterns = nil, nil
+	close(sub.stop)
+}
+
+// PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT
+func (s *Server) handlePubSub(c net.Conn, args []string) {
+	sub := args[1]
+	switch strings.ToUpper(sub) {
+	case "CHANNELS":
+		if len(args) > 3 {
+			s.reply(c, protocol.Error("ERR wrong number of arguments for 'pubsub|channels' command"))
+			return
+		}
+		pattern := ""
+		if len(args) == 3 {
+			p := args[2]
+			if err := store.CheckGlob(p); err != nil {
+				s.reply(c, protocol.Error(err.Error()))
+				return
+			}
+			pattern = p
+		}
+		channels := s.pubsub.Channels(pattern)
+		sort.Strings(channels)
+		arr := make(protocol.Array, len(channels))
+		for i, ch := range channels {
+			arr[i] = protocol.BulkString(ch)
+		}
+		s.reply(c, arr)
+
+	case "NUMSUB":
+		channels := args[2:]
+		m := protocol.Map{}
+		for i, n := range s.pubsub.NumSub(channels) {
+			m = append(m, protocol.BulkString(channels[i]), protocol.Integer(n))
+		}
+		s.reply(c, m)
+
+	case "NUMPAT":
+		s.reply(c, protocol.Integer(s.pubsub.NumPat()))
+
+	default:
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+sub+"'. Try PUBSUB CHANNELS, PUBSUB NUMSUB or PUBSUB NUMPAT."))
+	}
+}
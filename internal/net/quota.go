@@ -0,0 +1,156 @@
+package net
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+// quotaLimits are a user's command limits, set with the ratelimit:,
+// bandwidth: and dailyquota: ACL rules; zero means unlimited
+type quotaLimits struct {
+	rate      int64 // commands per second
+	bandwidth int64 // bytes of command arguments received per second
+	daily     int64 // commands per UTC day
+}
+
+// quotaRules names the ACL rule setting each limit
+var quotaRules = map[string]func(q *quotaLimits) *int64{
+	"ratelimit":  func(q *quotaLimits) *int64 { return &q.rate },
+	"bandwidth":  func(q *quotaLimits) *int64 { return &q.bandwidth },
+	"dailyquota": func(q *quotaLimits) *int64 { return &q.daily },
+}
+
+// isQuotaRule reports whether the lower-case rule sets or resets a limit
+func isQuotaRule(rule string) bool {
+	if rule == "resetquotas" {
+		return true
+	}
+	name, _, ok := strings.Cut(rule, ":")
+	return ok && quotaRules[name] != nil
+}
+
+// apply applies one quota rule accepted by isQuotaRule
+func (q *quotaLimits) apply(rule string) error {
+	if rule == "resetquotas" {
+		*q = quotaLimits{}
+		return nil
+	}
+	name, value, _ := strings.Cut(rule, ":")
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("the %s limit must be a non-negative integer", name)
+	}
+	*quotaRules[name](q) = n
+	return nil
+}
+
+// rules renders the limits that are set as ACL rules
+func (q quotaLimits) rules() []string {
+	var out []string
+	for _, name := range []string{"ratelimit", "bandwidth", "dailyquota"} {
+		if n := *quotaRules[name](&q); n > 0 {
+			out = append(out, fmt.Sprintf("%s:%d", name, n))
+		}
+	}
+	return out
+}
+
+// quotaUsage counts one user's commands against its limits. It is kept
+// apart from aclUser, which ACL SETUSER replaces, so changing a user's
+// rules does not reset its usage.
+type quotaUsage struct {
+	mu       sync.Mutex
+	second   int64 // unix second the rate counters cover
+	commands int64 // commands in that second
+	bytes    int64 // argument bytes in that second
+	day      int64 // UTC day, in days since the epoch, daily covers
+	daily    int64 // commands that day
+}
+
+// roll starts new windows once now has left the current ones
+func (u *quotaUsage) roll(now time.Time) {
+	if sec := now.Unix(); sec != u.second {
+		u.second, u.commands, u.bytes = sec, 0, 0
+	}
+	if day := now.Unix() / 86400; day != u.day {
+		u.day, u.daily = day, 0
+	}
+}
+
+// charge counts a command of size bytes, or returns the QUOTA error it is
+// rejected with. Rejected commands are not counted. The first command of a
+// second always fits the bandwidth, however large.
+func (u *quotaUsage) charge(q quotaLimits, size int64, now time.Time) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roll(now)
+	switch {
+	case q.daily > 0 && u.daily >= q.daily:
+		return fmt.Sprintf("QUOTA daily quota of %d commands exceeded", q.daily)
+	case q.rate > 0 && u.commands >= q.rate:
+		return fmt.Sprintf("QUOTA rate limit of %d commands per second exceeded", q.rate)
+	case q.bandwidth > 0 && u.bytes > 0 && u.bytes+size > q.bandwidth:
+		return fmt.Sprintf("QUOTA bandwidth limit of %d bytes per second exceeded", q.bandwidth)
+	}
+	u.commands++
+	u.bytes += size
+	u.daily++
+	return ""
+}
+
+// usage returns the ACL GETUSER usage fields as of now
+func (u *quotaUsage) usage(q quotaLimits, now time.Time) protocol.Map {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.roll(now)
+	remaining := int64(-1)
+	if q.daily > 0 {
+		remaining = max(q.daily-u.daily, 0)
+	}
+	return protocol.Map{
+		protocol.BulkString("commands-this-second"), protocol.Integer(u.commands),
+		protocol.BulkString("bytes-this-second"), protocol.Integer(u.bytes),
+		protocol.BulkString("commands-today"), protocol.Integer(u.daily),
+		protocol.BulkString("commands-left-today"), protocol.Integer(remaining),
+	}
+}
+
+// quotaUsage returns the usage counters of the named user
+func (s *Server) quotaUsage(name string) *quotaUsage {
+	s.acl.mu.RLock()
+	u := s.acl.usage[name]
+	s.acl.mu.RUnlock()
+	if u != nil {
+		return u
+	}
+	s.acl.mu.Lock()
+	defer s.acl.mu.Unlock()
+	if u = s.acl.usage[name]; u == nil {
+		u = &quotaUsage{}
+		s.acl.usage[name] = u
+	}
+	return u
+}
+
+// checkQuota charges a command to the connection's user and returns the
+// error it must be rejected with, or "" when it is within the user's limits
+func (s *Server) checkQuota(cl *client, cmd *command, args []string) string {
+	if aclExempt[cmd.name] {
+		return ""
+	}
+	name := cl.user.Load().(string)
+	u := s.user(name)
+	if u == nil || u.quotas == (quotaLimits{}) {
+		return ""
+	}
+	var size int64
+	for _, a := range args {
+		size += int64(len(a))
+	}
+	return s.quotaUsage(name).charge(u.quotas, size, time.Now())
+}
@@ -0,0 +1,102 @@
+package net
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+func TestQuotaCharge(t *testing.T) {
+	now := time.Date(2026, 1, 1, 23, 59, 58, 0, time.UTC)
+	var u quotaUsage
+
+	rate := quotaLimits{rate: 2}
+	for i := 0; i < 2; i++ {
+		if msg := u.charge(rate, 1, now); msg != "" {
+			t.Fatalf("command %d: %s", i, msg)
+		}
+	}
+	if msg := u.charge(rate, 1, now); !strings.HasPrefix(msg, "QUOTA rate limit") {
+		t.Errorf("third command in a second = %q, want a rate limit error", msg)
+	}
+	if msg := u.charge(rate, 1, now.Add(time.Second)); msg != "" {
+		t.Errorf("the next second is still limited: %s", msg)
+	}
+
+	u = quotaUsage{}
+	bw := quotaLimits{bandwidth: 10}
+	if msg := u.charge(bw, 100, now); msg != "" {
+		t.Errorf("the first command of a second was refused: %s", msg)
+	}
+	if msg := u.charge(bw, 1, now); !strings.HasPrefix(msg, "QUOTA bandwidth limit") {
+		t.Errorf("a command over the bandwidth = %q", msg)
+	}
+
+	u = quotaUsage{}
+	daily := quotaLimits{daily: 3}
+	for i := 0; i < 3; i++ {
+		u.charge(daily, 1, now)
+	}
+	if msg := u.charge(daily, 1, now.Add(time.Second)); !strings.HasPrefix(msg, "QUOTA daily quota") {
+		t.Errorf("a command over the daily quota = %q", msg)
+	}
+	// the quota restarts at UTC midnight
+	if msg := u.charge(daily, 1, now.Add(2*time.Second)); msg != "" {
+		t.Errorf("the daily quota did not reset at midnight: %s", msg)
+	}
+}
+
+func TestQuotaRules(t *testing.T) {
+	u := newUser("carol")
+	for _, rule := range []string{"ratelimit:100", "BANDWIDTH:4096", "dailyquota:5000"} {
+		if err := u.apply(rule); err != nil {
+			t.Fatalf("apply(%q): %v", rule, err)
+		}
+	}
+	if got, want := strings.Join(u.quotas.rules(), " "), "ratelimit:100 bandwidth:4096 dailyquota:5000"; got != want {
+		t.Errorf("rules = %q, want %q", got, want)
+	}
+	for _, bad := range []string{"ratelimit:x", "dailyquota:-1"} {
+		if err := u.apply(bad); err == nil {
+			t.Errorf("apply(%q) was accepted", bad)
+		}
+	}
+	u.apply("resetquotas")
+	if u.quotas != (quotaLimits{}) {
+		t.Errorf("resetquotas left %+v", u.quotas)
+	}
+}
+
+func TestQuotaEnforcement(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.dial(t)
+	admin.expect(protocol.SimpleString("OK"), "ACL", "SETUSER", "dave", "on", ">pw", "allkeys", "+@all", "dailyquota:3")
+
+	c := s.dial(t)
+	c.expect(protocol.SimpleString("OK"), "AUTH", "dave", "pw") // AUTH is not charged
+	c.expect(protocol.SimpleString("OK"), "SET", "k", "v")
+	c.expect(protocol.BulkString("v"), "GET", "k")
+	c.expect(protocol.Integer(1), "STRLEN", "k")
+	c.expect(protocol.Error("QUOTA daily quota of 3 commands exceeded"), "GET", "k")
+
+	user, _ := admin.do("ACL", "GETUSER", "dave").(protocol.Array)
+	fields := make(map[string]protocol.RESPType)
+	for i := 0; i+1 < len(user); i += 2 {
+		name, _ := user[i].(protocol.BulkString)
+		fields[string(name)] = user[i+1]
+	}
+	if got := fields["quotas"]; string(got.(protocol.BulkString)) != "dailyquota:3" {
+		t.Errorf("quotas = %v", got)
+	}
+	usage, _ := fields["usage"].(protocol.Array)
+	if len(usage) != 8 || usage[5] != protocol.Integer(3) || usage[7] != protocol.Integer(0) {
+		t.Errorf("usage = %v, want 3 commands today and none left", usage)
+	}
+
+	// changing the user keeps the usage it has run up
+	admin.expect(protocol.SimpleString("OK"), "ACL", "SETUSER", "dave", "dailyquota:4")
+	c.expect(protocol.BulkString("v"), "GET", "k")
+	c.expect(protocol.Error("QUOTA daily quota of 4 commands exceeded"), "GET", "k")
+}
@@ -0,0 +1,338 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+const (
+	replRetryDelay  = time.Second
+	replAckInterval = time.Second
+	replDialTimeout = 5 * time.Second
+)
+
+// primaryLink is a replica's connection to its primary. It reconnects until
+// stopped, resuming with a partial resync when the primary still has the
+// offset in its backlog.
+type primaryLink struct {
+	addr    string
+	stop    chan struct{}
+	up      atomic.Bool
+	syncing atomic.Bool
+	lastIO  atomic.Int64 // unix seconds
+	conn    atomic.Value // net.Conn, closed to stop
+}
+
+// primaryConn is the connection replicated commands are dispatched on. It
+// discards replies and is the only connection that may write on a replica.
+type primaryConn struct {
+	net.Conn
+}
+
+func (primaryConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func isPrimaryConn(c net.Conn) bool {
+	_, ok := c.(primaryConn)
+	return ok
+}
+
+// role is "replica" or "master", as HELLO reports it
+func (s *Server) role() string {
+	if s.isReplica() {
+		return "replica"
+	}
+	return "master"
+}
+
+// isReplica reports whether this server replicates a primary
+func (s *Server) isReplica() bool {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	return s.repl.link != nil
+}
+
+// linkAddr returns the address of the primary this server replicates,
+// "" if it is a primary
+func (s *Server) linkAddr() string {
+	s.repl.mu.Lock()
+	defer s.repl.mu.Unlock()
+	if s.repl.link == nil {
+		return ""
+	}
+	return s.repl.link.addr
+}
+
+// REPLICAOF host port | REPLICAOF NO ONE
+func (s *Server) handleReplicaOf(c net.Conn, args []string) {
+	host := args[1]
+	port := args[2]
+	if strings.EqualFold(host, "NO") && strings.EqualFold(port, "ONE") {
+		s.stopReplication()
+		s.reply(c, protocol.SimpleString("OK"))
+		return
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		s.reply(c, protocol.Error("ERR Invalid master port"))
+		return
+	}
+	addr := net.JoinHostPort(host, port)
+	s.repl.mu.Lock()
+	same := s.repl.link != nil && s.repl.link.addr == addr
+	s.repl.mu.Unlock()
+	if same {
+		s.reply(c, protocol.SimpleString("OK Already connected to specified master"))
+		return
+	}
+	s.startReplication(addr)
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// startReplication makes this server a replica of addr, replacing any
+// previous primary. The replication ID is kept, so a new primary that shares
+// its history can continue from the current offset.
+func (s *Server) startReplication(addr string) {
+	old := s.primaryAddr()
+	s.dropLink()
+	link := &primaryLink{addr: addr, stop: make(chan struct{})}
+	s.repl.mu.Lock()
+	s.repl.link = link
+	s.repl.mu.Unlock()
+	log.Printf("Replicating %s", addr)
+	s.pushTopology("replica", addr)
+	s.event(eventReplicaOf, "replicating %s", addr)
+	s.switchMaster(old, addr)
+	go s.replicate(link)
+}
+
+// stopReplication turns a replica back into a primary. It takes a new
+// replication ID, since writes it accepts from now on are not in the old
+// primary's history, and keeps the old one up to the current offset so
+// other replicas of the old primary can resync partially.
+func (s *Server) stopReplication() {
+	s.repl.mu.Lock()
+	link := s.repl.link
+	if link != nil {
+		s.changeReplID(true)
+	}
+	s.repl.mu.Unlock()
+	s.dropLink()
+	if link != nil {
+		s.pushTopology("primary")
+		s.event(eventFailover, "promoted to primary")
+		s.switchMaster(link.addr, s.announceAddr())
+	}
+}
+
+// dropLink disconnects from the primary, if any
+func (s *Server) dropLink() {
+	s.repl.mu.Lock()
+	link := s.repl.link
+	s.repl.link = nil
+	s.repl.mu.Unlock()
+	if link == nil {
+		return
+	}
+	close(link.stop)
+	if c, ok := link.conn.Load().(net.Conn); ok {
+		c.Close()
+	}
+	log.Printf("Stopped replicating %s", link.addr)
+}
+
+// replicate syncs with the primary and applies its stream, reconnecting
+// after errors until the link is stopped
+func (s *Server) replicate(link *primaryLink) {
+	for {
+		err := s.syncWithPrimary(link)
+		link.up.Store(false)
+		link.syncing.Store(false)
+		select {
+		case <-link.stop:
+			return
+		case <-s.stopCh:
+			return
+		default:
+		}
+		log.Printf("ERROR: Replication from %s failed: %v", link.addr, err)
+		select {
+		case <-time.After(replRetryDelay):
+		case <-link.stop:
+			return
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// syncWithPrimary runs one connection to the primary: the handshake, a full
+// or partial resync, then the command stream until the connection ends
+func (s *Server) syncWithPrimary(link *primaryLink) error {
+	c, err := net.DialTimeout("tcp", link.addr, replDialTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	link.conn.Store(c)
+	select {
+	case <-link.stop:
+		return nil // stopped while dialing
+	default:
+	}
+	r := bufio.NewReader(c)
+
+	if cfg := s.cfg.Snapshot(); cfg.MasterAuth != "" {
+		auth := []string{"AUTH", cfg.MasterAuth}
+		if cfg.MasterUser != "" {
+			auth = []string{"AUTH", cfg.MasterUser, cfg.MasterAuth}
+		}
+		if err := replCommand(c, r, auth...); err != nil {
+			return err
+		}
+	}
+	_, port, _ := net.SplitHostPort(s.addr)
+	if err := replCommand(c, r, "REPLCONF", "listening-port", port, "node-id", s.nodeID); err != nil {
+		return err
+	}
+
+	s.repl.mu.Lock()
+	id := s.repl.id
+	s.repl.mu.Unlock()
+	off := int64(-1)
+	if b := s.repl.backlog.Load(); b != nil {
+		off = b.offset()
+	}
+	s.reply(c, protocol.Array{
+		protocol.BulkString("PSYNC"), protocol.BulkString(id), protocol.BulkString(strconv.FormatInt(off, 10)),
+	})
+	resp, err := protocol.ParseRESP(r)
+	if err != nil {
+		return err
+	}
+	line, _ := resp.(protocol.SimpleString)
+	fields := strings.Fields(string(line))
+	switch {
+	case len(fields) == 3 && fields[0] == "FULLRESYNC":
+		start, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad FULLRESYNC offset %q", fields[2])
+		}
+		if err := s.loadFromPrimary(link, r, fields[1], start); err != nil {
+			return err
+		}
+	case len(fields) == 2 && fields[0] == "CONTINUE":
+		log.Printf("Partial resync with %s from offset %d", link.addr, off)
+		if fields[1] != id {
+			// the primary was promoted since: follow its new history
+			s.repl.mu.Lock()
+			s.changeReplID(true)
+			s.repl.id = fields[1]
+			s.repl.mu.Unlock()
+			s.event(eventFailover, "primary %s was promoted since the last sync, following its new history", link.addr)
+		}
+	default:
+		if e, ok := resp.(protocol.Error); ok {
+			return fmt.Errorf("PSYNC refused: %s", e)
+		}
+		return fmt.Errorf("unexpected PSYNC reply %v", resp)
+	}
+
+	link.up.Store(true)
+	link.lastIO.Store(time.Now().Unix())
+	done := make(chan struct{})
+	defer close(done)
+	go s.ackPrimary(c, done)
+
+	pc := primaryConn{Conn: c}
+	for {
+		resp, err := protocol.ParseRESP(r)
+		if err != nil {
+			return err
+		}
+		link.lastIO.Store(time.Now().Unix())
+		req, _ := resp.(protocol.Array)
+		args, ok := argv(req)
+		if !ok || len(args) == 0 {
+			return fmt.Errorf("unexpected replication stream entry %v", resp)
+		}
+		s.dispatch(pc, args[0], args)
+	}
+}
+
+// loadFromPrimary replaces the dataset with the snapshot that follows
+// +FULLRESYNC and restarts the stream at the primary's offset
+func (s *Server) loadFromPrimary(link *primaryLink, r *bufio.Reader, id string, off int64) error {
+	link.syncing.Store(true)
+	defer link.syncing.Store(false)
+	start := time.Now()
+	resp, err := protocol.ParseRESP(r)
+	if err != nil {
+		return err
+	}
+	payload, ok := resp.(protocol.BulkString)
+	if !ok {
+		return fmt.Errorf("expected a snapshot after FULLRESYNC, got %T", resp)
+	}
+
+	s.repl.gate.Lock()
+	defer s.repl.gate.Unlock()
+	n, err := s.shards.LoadSnapshot(bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("loading snapshot: %w", err)
+	}
+	s.repl.mu.Lock()
+	s.repl.id = id
+	s.repl.id2, s.repl.off2 = "", -1
+	s.repl.mu.Unlock()
+	s.resetBacklog(off)
+	s.touchAllWatched()
+	log.Printf("Full resync with %s: loaded %d keys (%d bytes) in %v", link.addr, n, len(payload), time.Since(start))
+	return nil
+}
+
+// ackPrimary reports the applied offset every replAckInterval
+func (s *Server) ackPrimary(c net.Conn, done chan struct{}) {
+	t := time.NewTicker(replAckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			ack := protocol.Array{
+				protocol.BulkString("REPLCONF"), protocol.BulkString("ACK"),
+				protocol.BulkString(strconv.FormatInt(s.replOffset(), 10)),
+			}
+			if err := protocol.EncodeTo(c, ack); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// replCommand sends a handshake command and expects +OK
+func replCommand(c net.Conn, r *bufio.Reader, args ...string) error {
+	req := make(protocol.Array, len(args))
+	for i, a := range args {
+		req[i] = protocol.BulkString(a)
+	}
+	if err := protocol.EncodeTo(c, req); err != nil {
+		return err
+	}
+	resp, err := protocol.ParseRESP(r)
+	if err != nil {
+		return err
+	}
+	if e, ok := resp.(protocol.Error); ok {
+		return fmt.Errorf("%s: %s", args[0], e)
+	}
+	return nil
+}
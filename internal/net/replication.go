@@ -0,0 +1,495 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"multithreaded-redis/internal/config"
+	"multithreaded-redis/internal/protocol"
+)
+
+// replChunk caps how much of the backlog is sent to a replica per write
+const replChunk = 64 << 10
+
+var errBacklogGone = errors.New("replication offset no longer in the backlog")
+
+// replState is the server's side of replication. A primary appends every
+// write command it executes to the backlog; replicas read the backlog from
+// their offset after a full or partial resync. A replica applies the stream
+// it receives through the same dispatch path, so its own backlog and offset
+// follow the primary's byte for byte.
+type replState struct {
+	// gate is held shared by every write command from execution until it is
+	// in the backlog, and exclusively by a full resync, so a snapshot and its
+	// offset agree
+	gate    sync.RWMutex
+	backlog atomic.Pointer[replBacklog]
+
+	mu       sync.Mutex
+	id       string       // replication ID, the primary's while replicating
+	id2      string       // the previous ID, empty if none
+	off2     int64        // offset up to which id2's history is ours
+	link     *primaryLink // set while this server is a replica
+	replicas map[*client]struct{}
+}
+
+// noReplID is how INFO shows a missing previous replication ID
+const noReplID = "0000000000000000000000000000000000000000"
+
+// changeReplID starts a new replication history. With keepOld, the old ID
+// stays valid up to the current offset, so replicas that followed it can
+// still resync partially, as after a promotion. Callers hold repl.mu.
+func (s *Server) changeReplID(keepOld bool) {
+	if keepOld {
+		s.repl.id2, s.repl.off2 = s.repl.id, s.replOffset()
+	} else {
+		s.repl.id2, s.repl.off2 = "", -1
+	}
+	s.repl.id = newID()
+}
+
+// continuable reports whether a replica at off in history id can resume
+// from the backlog. Callers hold repl.mu.
+func (s *Server) continuable(id string, off int64, b *replBacklog) bool {
+	switch {
+	case id == s.repl.id:
+	case id != "" && id == s.repl.id2 && off <= s.repl.off2:
+	default:
+		return false
+	}
+	return b.has(off)
+}
+
+// newID returns 40 random hex characters, the form of replication and
+// cluster node IDs
+func newID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// replicaPeer is the primary's view of one replica connection
+type replicaPeer struct {
+	id      string // cluster node ID announced by REPLCONF node-id
+	addr    string // replica's address as announced by REPLCONF listening-port
+	ack     atomic.Int64
+	lastAck atomic.Int64 // unix seconds
+	stop    chan struct{}
+}
+
+// replBacklog is a ring buffer of the most recent replication stream bytes.
+// Offsets count every byte ever fed, so a replica resumes by asking for the
+// offset after the last byte it applied.
+type replBacklog struct {
+	mu     sync.Mutex
+	buf    []byte
+	start  int64 // offset the backlog was created at
+	end    int64 // offset after the last byte fed
+	notify chan struct{}
+	closed bool
+}
+
+func newReplBacklog(size int, start int64) *replBacklog {
+	if size <= 0 {
+		size = 1 << 20
+	}
+	return &replBacklog{buf: make([]byte, size), start: start, end: start, notify: make(chan struct{})}
+}
+
+// feed appends p and wakes the replica senders
+func (b *replBacklog) feed(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(p) > 0 {
+		i := int(b.end % int64(len(b.buf)))
+		n := copy(b.buf[i:], p)
+		p = p[n:]
+		b.end += int64(n)
+	}
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// first is the oldest offset still held. Callers hold b.mu.
+func (b *replBacklog) first() int64 {
+	return max(b.start, b.end-int64(len(b.buf)))
+}
+
+// offset is the replication offset, the end of the stream so far
+func (b *replBacklog) offset() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.end
+}
+
+func (b *replBacklog) has(off int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.closed && off >= b.first() && off <= b.end
+}
+
+// readFrom copies up to limit bytes starting at off. With nothing new it
+// returns a channel closed on the next feed.
+func (b *replBacklog) readFrom(off int64, limit int) ([]byte, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || off < b.first() || off > b.end {
+		return nil, nil, errBacklogGone
+	}
+	if off == b.end {
+		return nil, b.notify, nil
+	}
+	out := make([]byte, min(b.end-off, int64(limit)))
+	for n := 0; n < len(out); {
+		i := int((off + int64(n)) % int64(len(b.buf)))
+		n += copy(out[n:], b.buf[i:])
+	}
+	return out, nil, nil
+}
+
+// close ends the stream for every sender, which drops its replica
+func (b *replBacklog) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.notify)
+	}
+}
+
+// ensureBacklog returns the backlog, creating it on the first resync.
+// Callers hold the gate exclusively.
+func (s *Server) ensureBacklog() *replBacklog {
+	if b := s.repl.backlog.Load(); b != nil {
+		return b
+	}
+	b := newReplBacklog(s.cfg.Snapshot().ReplBacklogSize, 0)
+	s.repl.backlog.Store(b)
+	return b
+}
+
+// resetBacklog starts a new stream at off, as after a full resync from a
+// primary. Replicas of this server lose their place and must resync.
+func (s *Server) resetBacklog(off int64) {
+	b := newReplBacklog(s.cfg.Snapshot().ReplBacklogSize, off)
+	if old := s.repl.backlog.Swap(b); old != nil {
+		old.close()
+	}
+}
+
+// replOffset is the current replication offset, 0 before any replica
+func (s *Server) replOffset() int64 {
+	if b := s.repl.backlog.Load(); b != nil {
+		return b.offset()
+	}
+	return 0
+}
+
+// propagate appends a write command to the backlog once a replica has
+// attached. Callers hold the gate shared.
+func (s *Server) propagate(args []string) {
+	if b := s.repl.backlog.Load(); b != nil && args != nil {
+		b.feed(protocol.Append(nil, request(args)))
+	}
+}
+
+// propagatePublish sends a PUBLISH down the replication stream when
+// repl-pubsub is everywhere. A replica relays every PUBLISH its primary sent
+// whatever its own setting, so its stream stays byte-for-byte the primary's,
+// and never adds messages published on it.
+func (s *Server) propagatePublish(c net.Conn, args []string) {
+	if s.isReplica() {
+		if !isPrimaryConn(c) {
+			return
+		}
+	} else if s.cfg.Snapshot().ReplPubSub != config.ReplPubSubEverywhere {
+		return
+	}
+	s.repl.gate.RLock()
+	defer s.repl.gate.RUnlock()
+	s.propagate(args)
+}
+
+// write runs a write command with the gate held and propagates it. Commands
+// with a rewrite run against a teeConn so the rewrite can see the reply.
+// Blocking commands propagate what they write themselves.
+func (s *Server) write(c net.Conn, cmd *command, args []string) {
+	if s.isReplica() && !isPrimaryConn(c) {
+		s.replicaWriteError(c, cmd, args)
+		return
+	}
+	if cmd.has(flagBlocking) {
+		// may wait indefinitely, so it takes the gate per write, see moveElement
+		cmd.handler(s, c, args)
+		return
+	}
+	s.repl.gate.RLock()
+	defer s.repl.gate.RUnlock()
+	if cmd.rewrite == nil || s.repl.backlog.Load() == nil {
+		cmd.handler(s, c, args)
+		s.propagate(args)
+		return
+	}
+	tc := &teeConn{Conn: c}
+	cmd.handler(s, tc, args)
+	reply, err := protocol.ParseRESP(bufio.NewReader(&tc.buf))
+	if err != nil {
+		log.Printf("ERROR: Failed to read %s reply for replication: %v", cmd.name, err)
+		return
+	}
+	s.propagate(cmd.rewrite(args, reply))
+}
+
+// teeConn passes writes through and keeps a copy
+type teeConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (tc *teeConn) Write(p []byte) (int, error) {
+	tc.buf.Write(p)
+	return tc.Conn.Write(p)
+}
+
+// rewriteSPop propagates SPOP as an SREM of the members it popped, since a
+// replica would pick different ones
+func rewriteSPop(args []string, reply protocol.RESPType) []string {
+	out := []string{"SREM", args[1]}
+	switch r := reply.(type) {
+	case protocol.BulkString:
+		if r != nil {
+			out = append(out, string(r))
+		}
+	case protocol.Array:
+		for _, m := range r {
+			if b, ok := m.(protocol.BulkString); ok {
+				out = append(out, string(b))
+			}
+		}
+	}
+	if len(out) == 2 {
+		return nil
+	}
+	return out
+}
+
+// REPLCONF listening-port <port> | capa <capability> | ACK <offset>
+func (s *Server) handleReplConf(c net.Conn, args []string) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	if (len(args)-1)%2 != 0 {
+		s.reply(c, protocol.Error("ERR syntax error"))
+		return
+	}
+	for i := 1; i < len(args); i += 2 {
+		opt := args[i]
+		val := args[i+1]
+		switch strings.ToLower(opt) {
+		case "listening-port":
+			host, _, _ := net.SplitHostPort(c.RemoteAddr().String())
+			cl.replAddr.Store(net.JoinHostPort(host, val))
+		case "node-id":
+			cl.replNodeID.Store(val)
+		case "capa":
+		case "ack":
+			// acknowledgements get no reply, the connection carries the stream
+			if p := cl.replica.Load(); p != nil {
+				off, _ := strconv.ParseInt(val, 10, 64)
+				p.ack.Store(off)
+				p.lastAck.Store(time.Now().Unix())
+			}
+			return
+		default:
+			s.reply(c, protocol.Error("ERR Unrecognized REPLCONF option: "+opt))
+			return
+		}
+	}
+	s.reply(c, protocol.SimpleString("OK"))
+}
+
+// PSYNC <replid> <offset> turns the connection into a replication stream.
+// A replica that names this server's replication ID and an offset still in
+// the backlog continues from there; any other gets a full snapshot first.
+func (s *Server) handlePSync(c net.Conn, args []string) {
+	cl := s.client(c)
+	if cl == nil {
+		return
+	}
+	if cl.replica.Load() != nil {
+		s.reply(c, protocol.Error("ERR connection is already a replica"))
+		return
+	}
+	id := args[1]
+	offArg := args[2]
+	off, err := strconv.ParseInt(offArg, 10, 64)
+	if err != nil {
+		off = -1
+	}
+
+	s.repl.gate.Lock()
+	b := s.ensureBacklog()
+	s.repl.mu.Lock()
+	myID := s.repl.id
+	cont := s.continuable(id, off, b)
+	s.repl.mu.Unlock()
+	if cont {
+		s.repl.gate.Unlock()
+		c.Write([]byte("+CONTINUE " + myID + "\r\n"))
+		log.Printf("Replica %s continuing from offset %d", c.RemoteAddr(), off)
+		s.attachReplica(c, cl, b, off)
+		return
+	}
+	snap, err := s.shards.TakeSnapshot()
+	off = b.offset()
+	s.repl.gate.Unlock()
+	if err != nil {
+		s.reply(c, protocol.Error("ERR full resync failed: "+err.Error()))
+		return
+	}
+
+	var buf bytes.Buffer
+	if _, err := snap.WriteTo(&buf); err != nil {
+		s.reply(c, protocol.Error("ERR full resync failed: "+err.Error()))
+		return
+	}
+	log.Printf("Full resync of %s: %d keys, %d bytes at offset %d", c.RemoteAddr(), snap.Keys(), buf.Len(), off)
+	c.Write([]byte(fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n", myID, off, buf.Len())))
+	c.Write(buf.Bytes())
+	c.Write([]byte("\r\n"))
+	s.attachReplica(c, cl, b, off)
+}
+
+// attachReplica registers cl as a replica and streams the backlog to it
+// from off until either side goes away
+func (s *Server) attachReplica(c net.Conn, cl *client, b *replBacklog, off int64) {
+	p := &replicaPeer{stop: make(chan struct{})}
+	if addr, ok := cl.replAddr.Load().(string); ok && addr != "" {
+		p.addr = addr
+	} else {
+		p.addr = c.RemoteAddr().String()
+	}
+	p.id, _ = cl.replNodeID.Load().(string)
+	p.ack.Store(off)
+	p.lastAck.Store(time.Now().Unix())
+	cl.replica.Store(p)
+	s.repl.mu.Lock()
+	s.repl.replicas[cl] = struct{}{}
+	s.repl.mu.Unlock()
+
+	go func() {
+		defer c.Close()
+		for {
+			data, wait, err := b.readFrom(off, replChunk)
+			if err != nil {
+				log.Printf("Dropping replica %s: %v", p.addr, err)
+				return
+			}
+			if data == nil {
+				select {
+				case <-wait:
+					continue
+				case <-p.stop:
+					return
+				}
+			}
+			if _, err := c.Write(data); err != nil {
+				return
+			}
+			off += int64(len(data))
+		}
+	}()
+}
+
+// detachReplica stops streaming to a closed replica connection
+func (s *Server) detachReplica(cl *client) {
+	p := cl.replica.Load()
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	s.repl.mu.Lock()
+	delete(s.repl.replicas, cl)
+	s.repl.mu.Unlock()
+}
+
+func (s *Server) infoReplication() []string {
+	s.repl.mu.Lock()
+	id, link := s.repl.id, s.repl.link
+	id2, off2 := s.repl.id2, s.repl.off2
+	if id2 == "" {
+		id2, off2 = noReplID, -1
+	}
+	peers := make([]*replicaPeer, 0, len(s.repl.replicas))
+	for cl := range s.repl.replicas {
+		peers = append(peers, cl.replica.Load())
+	}
+	s.repl.mu.Unlock()
+
+	lines := []string{}
+	if link != nil {
+		host, port, _ := net.SplitHostPort(link.addr)
+		status := "down"
+		if link.up.Load() {
+			status = "up"
+		}
+		lines = append(lines,
+			"role:slave",
+			"master_host:"+host,
+			"master_port:"+port,
+			"master_link_status:"+status,
+			fmt.Sprintf("master_last_io_seconds_ago:%d", time.Now().Unix()-link.lastIO.Load()),
+			fmt.Sprintf("master_sync_in_progress:%d", boolInt(link.syncing.Load())),
+			fmt.Sprintf("slave_repl_offset:%d", s.replOffset()),
+			"slave_read_only:1",
+		)
+	} else {
+		lines = append(lines, "role:master")
+	}
+	lines = append(lines, fmt.Sprintf("connected_slaves:%d", len(peers)))
+	offset := s.replOffset()
+	for i, p := range peers {
+		host, port, _ := net.SplitHostPort(p.addr)
+		lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,offset=%d,lag=%d",
+			i, host, port, p.ack.Load(), time.Now().Unix()-p.lastAck.Load()))
+	}
+	lines = append(lines,
+		"master_replid:"+id,
+		"master_replid2:"+id2,
+		fmt.Sprintf("master_repl_offset:%d", offset),
+		fmt.Sprintf("second_repl_offset:%d", off2),
+	)
+	if b := s.repl.backlog.Load(); b != nil {
+		b.mu.Lock()
+		first, histlen := b.first(), b.end-b.first()
+		b.mu.Unlock()
+		lines = append(lines,
+			"repl_backlog_active:1",
+			fmt.Sprintf("repl_backlog_size:%d", len(b.buf)),
+			fmt.Sprintf("repl_backlog_first_byte_offset:%d", first),
+			fmt.Sprintf("repl_backlog_histlen:%d", histlen),
+		)
+	} else {
+		lines = append(lines, "repl_backlog_active:0")
+	}
+	return lines
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package net
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// RESHARD STATUS | START
+//
+// STATUS reports the progress of the running or last reshard, and how many
+// slots are still off the node the ring places them on. START moves those
+// in the background, as after a failed ADDNODE or REMOVENODE.
+func (s *Server) handleReshard(c net.Conn, args []string) {
+	sub := args[1]
+	switch strings.ToUpper(sub) {
+	case "STATUS":
+	case "START":
+		if st := s.shards.ReshardStatus(); !st.Running && st.Pending > 0 {
+			go s.reshard()
+		}
+	default:
+		s.reply(c, protocol.Error("ERR RESHARD subcommand must be STATUS or START"))
+		return
+	}
+	s.reply(c, reshardMap(s.shards.ReshardStatus()))
+}
+
+// reshard moves every slot not on its ring node, with the usual events
+func (s *Server) reshard() {
+	s.event(eventMigrationStarted, "moving slots to the nodes the ring places them on")
+	if err := s.shards.Reshard(context.Background()); err != nil {
+		log.Printf("ERROR: Resharding failed: %v", err)
+		s.event(eventMigrationFailed, "moving slots: %v", err)
+		return
+	}
+	s.event(eventMigrationFinished, "moved slots to the nodes the ring places them on")
+}
+
+func reshardMap(st store.ReshardStatus) protocol.Map {
+	unixMs := func(t time.Time) protocol.Integer {
+		if t.IsZero() {
+			return 0
+		}
+		return protocol.Integer(t.UnixMilli())
+	}
+	return protocol.Map{
+		protocol.BulkString("running"), protocol.Integer(boolInt(st.Running)),
+		protocol.BulkString("started_ms"), unixMs(st.Started),
+		protocol.BulkString("finished_ms"), unixMs(st.Finished),
+		protocol.BulkString("slots"), protocol.Integer(st.Slots),
+		protocol.BulkString("slots_moved"), protocol.Integer(st.SlotsMoved),
+		protocol.BulkString("keys_moved"), protocol.Integer(st.KeysMoved),
+		protocol.BulkString("moving_slot"), protocol.Integer(st.Moving),
+		protocol.BulkString("from"), protocol.BulkString(st.From),
+		protocol.BulkString("to"), protocol.BulkString(st.To),
+		protocol.BulkString("pending"), protocol.Integer(st.Pending),
+		protocol.BulkString("error"), protocol.BulkString(st.Err),
+	}
+}
@@ -0,0 +1,45 @@
+package net
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// reweightLoop adjusts virtual nodes every vnode-reweight-interval, waking
+// early when the interval changes. Each round moves at most one shard one
+// step and moves the slots that changed owner before the next round, so
+// a persistent hot spot is smoothed gradually rather than by one large
+// reshuffle.
+func (s *Server) reweightLoop() {
+	for {
+		var tick <-chan time.Time
+		if d := s.cfg.Snapshot().VNodeReweightInterval; d > 0 {
+			tick = time.After(d)
+		}
+		select {
+		case <-tick:
+			s.reweight()
+		case <-s.reweightReset:
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *Server) reweight() {
+	change, ok := s.shards.Reweight(s.cfg.Snapshot().VNodes)
+	if !ok {
+		return
+	}
+	log.Printf("Reweighting %s from %d to %d virtual nodes, at %.2f of an even load", change.NodeID, change.From, change.To, change.Load)
+	s.event(eventVNodes, "%s from %d to %d virtual nodes at %.2f of an even load", change.NodeID, change.From, change.To, change.Load)
+
+	s.event(eventMigrationStarted, "rebalancing keys after reweighting %s", change.NodeID)
+	if err := s.shards.Reshard(context.Background()); err != nil {
+		log.Printf("ERROR: Rebalancing after reweighting %s failed: %v", change.NodeID, err)
+		s.event(eventMigrationFailed, "rebalancing keys after reweighting %s: %v", change.NodeID, err)
+		return
+	}
+	s.event(eventMigrationFinished, "rebalanced keys after reweighting %s", change.NodeID)
+}
@@ -0,0 +1,227 @@
+package net
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+	"multithreaded-redis/internal/store"
+)
+
+// selfTestPrefix names the keys and channels the self-test creates and
+// removes again
+const selfTestPrefix = "__selftest__:"
+
+// selfTestTimeout bounds each check that waits on a shard or a subscriber
+const selfTestTimeout = 5 * time.Second
+
+// CheckResult is the outcome of one self-test check
+type CheckResult struct {
+	Name   string
+	OK     bool
+	Detail string // what was checked, or why it failed
+	Took   time.Duration
+}
+
+// SelfTest exercises each subsystem in turn: every shard serves a write and
+// a read of a key the ring routes to it, the ring routes every key to a
+// running shard, a value survives a DUMP and RESTORE, and a published
+// message reaches a subscriber. Its keys and channel are removed again, and
+// it writes to the shards directly, so nothing reaches replicas.
+func (s *Server) SelfTest() []CheckResult {
+	checks := []struct {
+		name string
+		run  func() (string, error)
+	}{
+		{"shards", s.checkShards},
+		{"ring", s.checkRing},
+		{"persistence", s.checkPersistence},
+		{"pubsub", s.checkPubSub},
+	}
+	results := make([]CheckResult, 0, len(checks))
+	for _, c := range checks {
+		start := time.Now()
+		detail, err := c.run()
+		r := CheckResult{Name: c.name, OK: err == nil, Detail: detail, Took: time.Since(start)}
+		if err != nil {
+			r.Detail = err.Error()
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// probeKeys finds a key the ring routes to each shard
+func (s *Server) probeKeys() (map[string]string, error) {
+	nodes := s.shards.GetNodes()
+	keys := make(map[string]string, len(nodes))
+	for i := 0; len(keys) < len(nodes) && i < 100000; i++ {
+		k := selfTestPrefix + strconv.Itoa(i)
+		if node, ok := s.shards.GetNodeForKey(k); ok && keys[node] == "" {
+			keys[node] = k
+		}
+	}
+	if len(keys) < len(nodes) {
+		return keys, fmt.Errorf("the ring routes no key to %d of %d shards", len(nodes)-len(keys), len(nodes))
+	}
+	return keys, nil
+}
+
+func (s *Server) checkShards() (string, error) {
+	keys, err := s.probeKeys()
+	if err != nil {
+		return "", err
+	}
+	for node, k := range keys {
+		want := "probe " + node
+		res, err := s.withTimeout(func() interface{} {
+			if err, ok := s.shards.Execute("SET", k, want).(error); ok {
+				return err
+			}
+			res := s.shards.Execute("GET", k)
+			s.shards.Execute("DEL", k)
+			return res
+		})
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", node, err)
+		}
+		if got, _ := res.([]byte); string(got) != want {
+			return "", fmt.Errorf("%s: read back %q, wrote %q", node, got, want)
+		}
+	}
+	return fmt.Sprintf("%d shards wrote and read a key", len(keys)), nil
+}
+
+func (s *Server) checkRing() (string, error) {
+	nodes := s.shards.GetNodes()
+	if len(nodes) == 0 {
+		return "", errors.New("the ring has no nodes")
+	}
+	for _, node := range nodes {
+		if _, ok := s.shards.GetShardByNodeID(node); !ok {
+			return "", fmt.Errorf("the ring routes to %s, which has no shard", node)
+		}
+	}
+	vnodes := 0
+	for _, st := range s.shards.ShardStats() {
+		vnodes += st.VNodes
+	}
+	return fmt.Sprintf("%d nodes, %d virtual nodes", len(nodes), vnodes), nil
+}
+
+func (s *Server) checkPersistence() (string, error) {
+	k, want := selfTestPrefix+"dump", "persistence probe"
+	res, err := s.withTimeout(func() interface{} {
+		defer s.shards.Execute("DEL", k)
+		if err, ok := s.shards.Execute("SET", k, want).(error); ok {
+			return err
+		}
+		payload, _, ok, err := s.shards.Dump(k)
+		if err != nil || !ok {
+			return fmt.Errorf("DUMP of the probe key failed: %v", err)
+		}
+		if err := s.shards.Restore(k, payload, time.Time{}, true); err != nil {
+			return fmt.Errorf("RESTORE of the probe key failed: %w", err)
+		}
+		return s.shards.Execute("GET", k)
+	})
+	if err != nil {
+		return "", err
+	}
+	if got, _ := res.([]byte); !bytes.Equal(got, []byte(want)) {
+		return "", fmt.Errorf("restored %q, dumped %q", got, want)
+	}
+	return "a value survived DUMP and RESTORE, engine " + s.cfg.Snapshot().Storage.Engine, nil
+}
+
+func (s *Server) checkPubSub() (string, error) {
+	channel := selfTestPrefix + newID()[:8]
+	out := make(chan store.PubSubMessage, 1)
+	s.pubsub.Subscribe([]string{channel}, out)
+	defer s.pubsub.Unsubscribe([]string{channel}, out)
+	if n := s.pubsub.Publish(channel, "probe"); n != 1 {
+		return "", fmt.Errorf("message reached %d subscribers, want 1", n)
+	}
+	select {
+	case m := <-out:
+		if m.Message != "probe" {
+			return "", fmt.Errorf("received %q, published %q", m.Message, "probe")
+		}
+	case <-time.After(selfTestTimeout):
+		return "", errors.New("published message never arrived")
+	}
+	return "a published message reached its subscriber", nil
+}
+
+// withTimeout runs fn, failing if it takes longer than selfTestTimeout, as
+// when a shard worker is stuck. A result that is an error is returned as
+// one.
+func (s *Server) withTimeout(fn func() interface{}) (interface{}, error) {
+	done := make(chan interface{}, 1)
+	go func() { done <- fn() }()
+	select {
+	case res := <-done:
+		if err, ok := res.(error); ok {
+			return nil, err
+		}
+		return res, nil
+	case <-time.After(selfTestTimeout):
+		return nil, fmt.Errorf("no reply within %v", selfTestTimeout)
+	}
+}
+
+// CAPABILITIES reports the version, the features this deployment has on,
+// its limits, and the outcome of a self-test of every subsystem
+func (s *Server) handleCapabilities(c net.Conn, args []string) {
+	cfg := s.cfg.Snapshot()
+	maxmemory, policy := s.shards.MaxMemory()
+	onOff := func(on bool) protocol.RESPType {
+		if on {
+			return protocol.BulkString("yes")
+		}
+		return protocol.BulkString("no")
+	}
+	features := protocol.Map{
+		protocol.BulkString("role"), protocol.BulkString(s.role()),
+		protocol.BulkString("storage-engine"), protocol.BulkString(cfg.Storage.Engine),
+		protocol.BulkString("maxmemory-policy"), protocol.BulkString(policy.String()),
+		protocol.BulkString("cluster"), onOff(cfg.ClusterEnabled),
+		protocol.BulkString("websocket"), onOff(cfg.WSAddr != ""),
+		protocol.BulkString("mqtt-bridge"), onOff(cfg.MQTTBroker != ""),
+		protocol.BulkString("hot-key-replication"), onOff(cfg.HotKeyThreshold > 0),
+		protocol.BulkString("vnode-reweighting"), onOff(cfg.VNodeReweightInterval > 0),
+		protocol.BulkString("anomaly-detection"), onOff(cfg.AnomalyWindow > 0),
+		protocol.BulkString("confirm-destructive"), onOff(cfg.ConfirmDestructive),
+		protocol.BulkString("sentinel-master-name"), protocol.BulkString(cfg.SentinelMasterName),
+	}
+	limits := protocol.Map{
+		protocol.BulkString("shards"), protocol.Integer(len(s.shards.GetNodes())),
+		protocol.BulkString("databases"), protocol.Integer(cfg.Databases),
+		protocol.BulkString("proto-max-bulk-len"), protocol.Integer(cfg.ProtoMaxBulkLen),
+		protocol.BulkString("max-value-size"), protocol.Integer(cfg.MaxValueSize),
+		protocol.BulkString("max-collection-len"), protocol.Integer(cfg.MaxCollectionLen),
+		protocol.BulkString("maxmemory"), protocol.Integer(maxmemory),
+	}
+	checks := protocol.Array{}
+	for _, r := range s.SelfTest() {
+		checks = append(checks, protocol.Map{
+			protocol.BulkString("name"), protocol.BulkString(r.Name),
+			protocol.BulkString("ok"), onOff(r.OK),
+			protocol.BulkString("detail"), protocol.BulkString(r.Detail),
+			protocol.BulkString("took_us"), protocol.Integer(r.Took.Microseconds()),
+		})
+	}
+	s.reply(c, protocol.Map{
+		protocol.BulkString("version"), protocol.BulkString(serverVersion),
+		protocol.BulkString("go_version"), protocol.BulkString(runtime.Version()),
+		protocol.BulkString("protocols"), protocol.Array{protocol.Integer(2), protocol.Integer(3)},
+		protocol.BulkString("features"), features,
+		protocol.BulkString("limits"), limits,
+		protocol.BulkString("selftest"), checks,
+	})
+}
@@ -0,0 +1,160 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+// Every server answers the part of the Sentinel protocol that clients use
+// to find the primary, about the replication group it belongs to, so an
+// HA-aware client can list the servers themselves as its sentinels. The
+// group is named by sentinel-master-name. A server whose primary changes,
+// by REPLICAOF or by being promoted, publishes
+//
+//	+switch-master <name> <old-ip> <old-port> <new-ip> <new-port>
+//
+// to its own subscribers, as a sentinel would after a failover.
+
+// switchMasterChannel is where a change of primary is announced
+const switchMasterChannel = "+switch-master"
+
+// primaryAddr is the address of this server's primary: the one it
+// replicates, or its own when it takes writes
+func (s *Server) primaryAddr() string {
+	s.repl.mu.Lock()
+	link := s.repl.link
+	s.repl.mu.Unlock()
+	if link != nil {
+		return link.addr
+	}
+	return s.announceAddr()
+}
+
+// switchMaster announces that the group's primary moved from old to new
+func (s *Server) switchMaster(old, new string) {
+	if old == new {
+		return
+	}
+	oldHost, oldPort, _ := net.SplitHostPort(old)
+	newHost, newPort, _ := net.SplitHostPort(new)
+	s.pubsub.Publish(switchMasterChannel, fmt.Sprintf("%s %s %s %s %s",
+		s.cfg.Snapshot().SentinelMasterName, oldHost, oldPort, newHost, newPort))
+}
+
+// SENTINEL GET-MASTER-ADDR-BY-NAME name | MASTER name | MASTERS |
+// REPLICAS name | SLAVES name | SENTINELS name | MYID
+func (s *Server) handleSentinel(c net.Conn, args []string) {
+	sub := strings.ToUpper(args[1])
+	want := 3
+	if sub == "MASTERS" || sub == "MYID" {
+		want = 2
+	}
+	if len(args) != want {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'sentinel|"+strings.ToLower(sub)+"' command"))
+		return
+	}
+	name := s.cfg.Snapshot().SentinelMasterName
+	if want == 3 && args[2] != name {
+		if sub == "GET-MASTER-ADDR-BY-NAME" {
+			s.reply(c, protocol.Array(nil))
+		} else {
+			s.reply(c, protocol.Error("ERR No such master with that name"))
+		}
+		return
+	}
+
+	nodes := s.clusterNodes()
+	switch sub {
+	case "GET-MASTER-ADDR-BY-NAME":
+		host, port, _ := net.SplitHostPort(nodes[0].addr)
+		s.reply(c, protocol.Array{protocol.BulkString(host), protocol.BulkString(port)})
+
+	case "MASTER":
+		s.reply(c, s.sentinelMaster(name, nodes))
+
+	case "MASTERS":
+		s.reply(c, protocol.Array{s.sentinelMaster(name, nodes)})
+
+	case "REPLICAS", "SLAVES":
+		primaryHost, primaryPort, _ := net.SplitHostPort(nodes[0].addr)
+		out := protocol.Array{}
+		for _, n := range nodes[1:] {
+			host, port, _ := net.SplitHostPort(n.addr)
+			out = append(out, protocol.Map{
+				protocol.BulkString("name"), protocol.BulkString(n.addr),
+				protocol.BulkString("ip"), protocol.BulkString(host),
+				protocol.BulkString("port"), protocol.BulkString(port),
+				protocol.BulkString("runid"), protocol.BulkString(n.id),
+				protocol.BulkString("flags"), protocol.BulkString("slave"),
+				protocol.BulkString("master-link-status"), protocol.BulkString("ok"),
+				protocol.BulkString("master-host"), protocol.BulkString(primaryHost),
+				protocol.BulkString("master-port"), protocol.BulkString(primaryPort),
+				protocol.BulkString("slave-repl-offset"), protocol.BulkString(strconv.FormatInt(n.offset, 10)),
+			})
+		}
+		s.reply(c, out)
+
+	case "SENTINELS":
+		// there are no other sentinels; every server answers for itself
+		s.reply(c, protocol.Array{})
+
+	case "MYID":
+		s.reply(c, protocol.BulkString(s.nodeID))
+
+	default:
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+args[1]+"'. Try SENTINEL HELP."))
+	}
+}
+
+// sentinelMaster describes the group's primary as SENTINEL MASTER does
+func (s *Server) sentinelMaster(name string, nodes []clusterNode) protocol.Map {
+	host, port, _ := net.SplitHostPort(nodes[0].addr)
+	return protocol.Map{
+		protocol.BulkString("name"), protocol.BulkString(name),
+		protocol.BulkString("ip"), protocol.BulkString(host),
+		protocol.BulkString("port"), protocol.BulkString(port),
+		protocol.BulkString("runid"), protocol.BulkString(nodes[0].id),
+		protocol.BulkString("flags"), protocol.BulkString("master"),
+		protocol.BulkString("role-reported"), protocol.BulkString("master"),
+		protocol.BulkString("num-slaves"), protocol.BulkString(strconv.Itoa(len(nodes) - 1)),
+		protocol.BulkString("num-other-sentinels"), protocol.BulkString("0"),
+		protocol.BulkString("quorum"), protocol.BulkString("1"),
+	}
+}
+
+// ROLE
+func (s *Server) handleRole(c net.Conn, args []string) {
+	s.repl.mu.Lock()
+	link := s.repl.link
+	s.repl.mu.Unlock()
+	if link != nil {
+		host, port, _ := net.SplitHostPort(link.addr)
+		p, _ := strconv.Atoi(port)
+		state := "connect"
+		switch {
+		case link.up.Load():
+			state = "connected"
+		case link.syncing.Load():
+			state = "sync"
+		}
+		s.reply(c, protocol.Array{
+			protocol.BulkString("slave"), protocol.BulkString(host), protocol.Integer(p),
+			protocol.BulkString(state), protocol.Integer(s.replOffset()),
+		})
+		return
+	}
+	replicas := protocol.Array{}
+	for _, n := range s.clusterNodes()[1:] {
+		host, port, _ := net.SplitHostPort(n.addr)
+		replicas = append(replicas, protocol.Array{
+			protocol.BulkString(host), protocol.BulkString(port), protocol.BulkString(strconv.FormatInt(n.offset, 10)),
+		})
+	}
+	s.reply(c, protocol.Array{
+		protocol.BulkString("master"), protocol.Integer(s.replOffset()), replicas,
+	})
+}
@@ -3,25 +3,38 @@ package net
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"multithreaded-redis/internal/config"
 	"multithreaded-redis/internal/protocol"
 	"multithreaded-redis/internal/store"
 )
 
+// serverVersion is reported by HELLO and INFO
+const serverVersion = "0.1.0"
+
 type Server struct {
 	addr   string
 	shards *store.SharedStore
 	pubsub *store.PubSub
 	ln     net.Listener
+	cfg    *config.Config
+
+	// optional RESP over WebSocket listener
+	wsServer *http.Server
 
 	// connection management
 	mu    sync.Mutex
-	conns map[net.Conn]struct{}
+	conns map[net.Conn]*client
 	wg    sync.WaitGroup
 
 	// lifecycle management
@@ -30,69 +43,339 @@ type Server struct {
 
 	// debugging flags
 	debug bool
-}
 
-func NewServer(addr string) *Server {
-	sharedStore := store.NewSharedStore(2) // 2 replicas for consistent hashing
+	// metrics reported by INFO
+	startTime        time.Time
+	totalConnections atomic.Uint64
+	totalCommands    atomic.Uint64
 
-	// Create and add 2 shards
-	numShards := 2
-	for i := 0; i < numShards; i++ {
-		st := store.NewStore()
-		// Start cleaner for each store
-		st.StartCleaner(20, 100000*time.Millisecond)
-		shard := store.NewShard(st)
-		nodeID := fmt.Sprintf("shard-%d", i)
-		sharedStore.AddNode(nodeID, shard)
+	nextClientID atomic.Uint64
+
+	// keys watched by WATCH and the connections watching them
+	watchMu  sync.Mutex
+	watchers map[string]map[*client]struct{}
+	watching atomic.Int64 // len(watchers), checked before locking
+
+	notifyClasses atomic.Uint32 // store.EventClass from notify-keyspace-events
+
+	// connection timeouts, from timeout and read-timeout
+	idleTimeout atomic.Int64
+	readTimeout atomic.Int64
+	maxBulkLen  atomic.Int64
+
+	accept acceptGate // throttling of new connections, see accept.go
+
+	aliases  atomic.Pointer[map[string]*command] // from command-aliases
+	policies atomic.Pointer[map[string]string]   // from command-policy, see policy.go
+
+	events eventLog   // recent server events, see events.go
+	blocks blockState // clients blocked on empty lists, see blocking.go
+
+	acl aclState
+
+	nodeID string // cluster node ID, new on every start
+	repl   replState
+
+	// ownership audits, periodic or from DEBUG OWNERSHIP-AUDIT
+	auditReset chan struct{}
+	audits     atomic.Uint64
+	lastAudit  atomic.Pointer[store.OwnershipAudit]
+
+	reweightReset chan struct{} // vnode-reweight-interval changed
+
+	draining atomic.Bool // handing over to a new process, see upgrade.go
+
+	anomaly       anomalyState // per-user command baselines, see anomaly.go
+	anomalyWindow atomic.Int64 // anomaly-window, 0 = off
+
+	memGuard memoryGuard // tiers of memory pressure, see memguard.go
+
+	configReset chan struct{} // config-watch-interval changed
+
+	mqtt mqttBridge // pub/sub relayed to and from mqtt-broker, see mqtt.go
+}
+
+func NewServer(cfg *config.Config) (*Server, error) {
+	if _, err := cfg.ReadSecrets(); err != nil {
+		return nil, err
 	}
+	c := cfg.Snapshot()
+	sharedStore := store.NewSharedStore(c.Replicas)
 
 	s := &Server{
-		addr:     addr,
+		addr:     c.Addr,
+		cfg:      cfg,
 		shards:   sharedStore,
 		pubsub:   store.NewPubSub(),
-		conns:    make(map[net.Conn]struct{}),
+		conns:    make(map[net.Conn]*client),
+		watchers: make(map[string]map[*client]struct{}),
 		stopCh:   make(chan struct{}),
+		accept:   newAcceptGate(c.AcceptQueue),
+		events:   newEventLog(c.EventLogMaxLen),
+		blocks:   newBlockState(),
+		anomaly:  newAnomalyState(),
 		mu:       sync.Mutex{},
 		wg:       sync.WaitGroup{},
 		stopOnce: sync.Once{},
 		debug:    true,
+
+		startTime: time.Now(),
+		// buffered so applyConfig never waits for the audit loop
+		auditReset:    make(chan struct{}, 1),
+		reweightReset: make(chan struct{}, 1),
+		configReset:   make(chan struct{}, 1),
+	}
+	s.nodeID = newID()
+	sharedStore.SetRedirectAddr(s.announceAddr())
+	s.repl.id = newID()
+	s.repl.replicas = make(map[*client]struct{})
+	s.acl.users = make(map[string]*aclUser)
+	s.acl.usage = make(map[string]*quotaUsage)
+	sharedStore.SetEventHook(s.keyspaceEvent)
+
+	shards := c.Shards
+	if shards == 0 {
+		shards = config.CPUs()
+		log.Printf("Sized the shard pool to %d, one per usable CPU", shards)
+	}
+	for i := 0; i < shards; i++ {
+		nodeID := fmt.Sprintf("shard-%d", i)
+		st, err := s.newStore(nodeID)
+		if err != nil {
+			return nil, err
+		}
+		sharedStore.AddNode(nodeID, store.NewShard(st))
+	}
+	// the first shard took every slot; the stores are empty, so spreading
+	// them is only bookkeeping
+	if err := sharedStore.Reshard(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to spread slots over the shards: %w", err)
 	}
+	s.applyConfig(nil)
 
-	return s
+	return s, nil
+}
+
+// newStore creates the store for shard nodeID on the configured engine, with
+// its background jobs configured
+func (s *Server) newStore(nodeID string) (*store.Store, error) {
+	c := s.cfg.Snapshot()
+	engine, err := store.OpenEngine(c.Storage, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage engine for %s: %w", nodeID, err)
+	}
+	st := store.NewStoreWithEngine(engine)
+	st.SetCleaner(c.CleanerSampleSize, c.CleanerInterval)
+	st.SetTTLPolicies(c.TTLPolicies)
+	st.StartAdaptiveTTL(c.AdaptiveTTL)
+	// compact containers left oversized by deletes
+	st.StartDefrag(c.DefragSampleSize, c.DefragInterval)
+	return st, nil
+}
+
+// applyConfig pushes runtime tunables down to the store. changed names the
+// parameters that were just set; nil applies everything.
+func (s *Server) applyConfig(changed map[string]bool) {
+	c := s.cfg.Snapshot()
+	all := changed == nil
+	if all || changed["maxmemory"] || changed["maxmemory-policy"] {
+		s.shards.SetMaxMemory(c.MaxMemory, c.MaxMemoryPolicy)
+	}
+	if all || changed["maxmemory-eviction-types"] {
+		s.shards.SetEvictionTypes(c.MaxMemoryTypes)
+	}
+	if all || changed["max-value-size"] || changed["max-collection-len"] {
+		s.shards.SetLimits(store.Limits{MaxValueSize: c.MaxValueSize, MaxCollectionLen: c.MaxCollectionLen})
+	}
+	if all || changed["hotkey-threshold"] || changed["hotkey-window"] {
+		s.shards.SetHotKeyPolicy(uint32(c.HotKeyThreshold), c.HotKeyWindow)
+	}
+	if all || changed["accept-rate-limit"] || changed["max-clients-per-ip"] {
+		s.accept.rate.Store(int64(c.AcceptRateLimit))
+		s.accept.perIP.Store(int64(c.MaxClientsPerIP))
+	}
+	if all || changed["proto-max-bulk-len"] {
+		s.maxBulkLen.Store(int64(c.ProtoMaxBulkLen))
+	}
+	if all || changed["timeout"] || changed["read-timeout"] {
+		s.idleTimeout.Store(int64(c.Timeout))
+		s.readTimeout.Store(int64(c.ReadTimeout))
+	}
+	if all || changed["requirepass"] {
+		s.setDefaultPassword(c.RequirePass)
+	}
+	if all || changed["notify-keyspace-events"] {
+		s.notifyClasses.Store(uint32(c.NotifyKeyspaceEvents))
+	}
+	if all || changed["intern-members"] {
+		s.shards.SetInterning(c.InternMembers)
+	}
+	if all || changed["set-max-intset-entries"] || changed["set-max-listpack-entries"] || changed["set-max-listpack-value"] ||
+		changed["hash-max-listpack-entries"] || changed["hash-max-listpack-value"] {
+		s.shards.SetEncodingLimits(c.Encoding)
+	}
+	if changed["event-log-max-len"] {
+		s.events.setMaxLen(c.EventLogMaxLen)
+	}
+	if all || changed["bf-error-rate"] || changed["bf-initial-size"] || changed["bf-expansion"] {
+		s.shards.SetBloomDefaults(c.Bloom)
+	}
+	if all || changed["command-aliases"] {
+		s.setAliases(c.CommandAliases)
+	}
+	if all || changed["migration-max-keys-per-sec"] || changed["migration-max-bytes-per-sec"] {
+		s.shards.SetMigrationLimits(c.Migration)
+	}
+	if all || changed["command-aliases"] || changed["command-policy"] {
+		s.setPolicies(c.CommandPolicy) // may name aliases
+	}
+	if all || changed["zset-score-events"] {
+		s.shards.SetScoreEvents(c.ZSetScoreEvents, s.scoreEvent)
+	}
+	if changed["tier-idle-threshold"] {
+		s.shards.SetTierIdle(c.Storage.TierIdle)
+	}
+	if all || changed["anomaly-window"] {
+		if s.anomalyWindow.Swap(int64(c.AnomalyWindow)) != int64(c.AnomalyWindow) {
+			select {
+			case s.anomaly.reset <- struct{}{}:
+			default:
+			}
+		}
+	}
+	if changed["vnode-reweight-interval"] {
+		select {
+		case s.reweightReset <- struct{}{}:
+		default:
+		}
+	}
+	if changed["ownership-audit-interval"] {
+		select {
+		case s.auditReset <- struct{}{}:
+		default:
+		}
+	}
+	if changed["config-watch-interval"] {
+		select {
+		case s.configReset <- struct{}{}:
+		default:
+		}
+	}
+	if changed["cleaner-sample-size"] || changed["cleaner-interval"] {
+		s.shards.SetCleaner(c.CleanerSampleSize, c.CleanerInterval)
+	}
+	if changed["ttl-policy"] {
+		s.shards.SetTTLPolicies(c.TTLPolicies)
+	}
+	if changed["adaptive-ttl-interval"] || changed["adaptive-ttl-min"] || changed["adaptive-ttl-max"] || changed["adaptive-ttl-hot-reads"] {
+		s.shards.SetAdaptiveTTL(c.AdaptiveTTL)
+	}
 }
 
 func (s *Server) Start() error {
-	ln, err := net.Listen("tcp", s.addr)
+	c := s.cfg.Snapshot()
+	h, err := inheritedHandover()
 	if err != nil {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
+	var ln net.Listener
+	if h != nil {
+		// the previous process writes its keys once its clients are gone,
+		// and serves again if they cannot be loaded
+		n, err := h.load(s.shards)
+		if err != nil {
+			return fmt.Errorf("failed to take over from the previous process: %w", err)
+		}
+		log.Printf("Took over the listener and %d keys from the previous process", n)
+		ln = h.ln
+	} else {
+		if c.RestoreFrom != "" {
+			t, err := store.OpenBackupTarget(c.RestoreFrom, s.s3Config())
+			if err != nil {
+				return fmt.Errorf("failed to restore: %w", err)
+			}
+			if _, err := s.shards.RestoreBackup(t); err != nil {
+				return fmt.Errorf("failed to restore from %s: %w", t, err)
+			}
+		}
+		if ln, err = net.Listen("tcp", s.addr); err != nil {
+			return fmt.Errorf("failed to start server: %w", err)
+		}
+	}
 	s.ln = ln
 
 	log.Printf("Server started on %s", s.addr)
 	go s.acceptLoop()
+	go s.startLoop()
+	go s.auditLoop()
+	go s.reweightLoop()
+	go s.anomalyLoop()
+	go s.memoryGuardLoop()
+	go s.configWatchLoop()
+	go s.blockLoop()
+
+	if c.ReplicaOf != "" {
+		host, port, ok := strings.Cut(strings.TrimSpace(c.ReplicaOf), " ")
+		if !ok {
+			return fmt.Errorf("replicaof must be \"host port\", got %q", c.ReplicaOf)
+		}
+		s.startReplication(net.JoinHostPort(host, strings.TrimSpace(port)))
+	}
+
+	if c.WSAddr != "" {
+		if err := s.StartWebSocket(c.WSAddr); err != nil {
+			return err
+		}
+	}
+	if c.MQTTBroker != "" {
+		if err := s.StartMQTT(); err != nil {
+			return err
+		}
+	}
+	if c.WarmupManifest != "" {
+		if c.WarmupLoader == "" {
+			return fmt.Errorf("warmup-manifest requires warmup-loader")
+		}
+		if err := s.StartWarmup(c.WarmupManifest, c.WarmupLoader); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *Server) acceptLoop() {
-	for {
-		conn, err := s.ln.Accept()
-		if err != nil {
-			select {
-			case <-s.stopCh:
-				// Server is shutting down
-				return
-			default:
-				log.Printf("failed to accept connection: %v", err)
-				continue
-			}
-		}
-		s.mu.Lock()
-		s.conns[conn] = struct{}{}
-		s.mu.Unlock()
+// setKeepAlive applies tcp-keepalive to an accepted connection, so peers
+// that vanished without closing are eventually detected
+func (s *Server) setKeepAlive(tc *net.TCPConn) {
+	d := s.cfg.Snapshot().TCPKeepAlive
+	if d <= 0 {
+		tc.SetKeepAlive(false)
+		return
+	}
+	tc.SetKeepAlive(true)
+	tc.SetKeepAlivePeriod(d)
+}
 
-		s.wg.Add(1)
-		go s.handleConn(conn)
+// StartWarmup preloads the keys listed in manifestPath from loaderURL in the
+// background. Progress is reported in the Warmup section of INFO and the
+// load is abandoned if the server shuts down first.
+func (s *Server) StartWarmup(manifestPath, loaderURL string) error {
+	entries, err := store.ReadWarmupManifest(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to read warmup manifest: %w", err)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-s.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	go func() {
+		defer cancel()
+		s.shards.Warmup(ctx, entries, store.NewHTTPWarmupLoader(loaderURL), 8)
+	}()
+	return nil
 }
 
 // Shutdown order:
@@ -104,9 +387,13 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	var retErr error
 	s.stopOnce.Do(func() {
 		close(s.stopCh)
+		s.stopReplication()
 		if s.ln != nil {
 			s.ln.Close()
 		}
+		if s.wsServer != nil {
+			s.wsServer.Close()
+		}
 
 		// Close all active connections
 		s.mu.Lock()
@@ -137,127 +424,133 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return retErr
 }
 
+// execute runs a command for connection c, recording an OOM refusal
+func (s *Server) execute(c net.Conn, cmd, key string, args ...string) interface{} {
+	res := s.shards.Execute(cmd, key, args...)
+	if err, ok := res.(error); ok && errors.Is(err, store.ErrOOM) {
+		s.oomEvent(cmd, key)
+	}
+	return res
+}
+
+// executeMulti runs a command once per op for connection c, one request
+// per shard, see store.ExecuteMulti
+func (s *Server) executeMulti(c net.Conn, cmd string, ops []store.KeyOp) []interface{} {
+	replies := s.shards.ExecuteMulti(cmd, ops)
+	for i, r := range replies {
+		if err, ok := r.(error); ok && errors.Is(err, store.ErrOOM) {
+			s.oomEvent(cmd, ops[i].Key)
+			break
+		}
+	}
+	return replies
+}
+
+// Until a client authenticates it may only send short commands, as in
+// Redis, so a peer without credentials can't make the server buffer much
+const (
+	unauthMaxArgs = 10
+	unauthMaxBulk = 16 << 10
+)
+
 // handleConn processes incoming connections and RESP commands
 func (s *Server) handleConn(c net.Conn) {
 	defer func() {
-		s.mu.Lock()
-		delete(s.conns, c)
-		s.mu.Unlock()
+		s.unregister(c)
 		c.Close()
 		s.wg.Done()
 	}()
 	r := bufio.NewReader(c)
+	cl := s.client(c)
 
 	for {
-		resp, err := protocol.ParseRESP(r)
+		if err := s.awaitCommand(c, r); err != nil {
+			log.Printf("closing connection %s: %v", c.RemoteAddr(), err)
+			return
+		}
+		maxArgs, maxBulk := protocol.MaxMultibulkLen, int(s.maxBulkLen.Load())
+		if cl == nil || cl.user.Load().(string) == "" {
+			maxArgs, maxBulk = unauthMaxArgs, unauthMaxBulk
+		}
+		resp, err := protocol.ParseRequest(r, maxArgs, maxBulk)
 		if err != nil {
+			var perr protocol.ProtocolError
+			if errors.As(err, &perr) {
+				s.reply(c, protocol.Error("ERR "+perr.Error()))
+			}
 			log.Printf("failed to parse RESP: %v", err)
 			return
 		}
+		c.SetReadDeadline(time.Time{})
 		log.Printf("Received RESP: %v", resp)
 
 		//Handle command
 		switch v := resp.(type) {
 		case protocol.Array:
+			if v == nil {
+				continue // blank inline line
+			}
 			if len(v) == 0 {
-				c.Write([]byte(protocol.Encode(protocol.Error("ERR Empty command"))))
+				s.requestError(c, "ERR Empty command")
 				continue
 			}
-			cmd, ok := v[0].(protocol.BulkString)
+			args, ok := argv(v)
 			if !ok {
-				c.Write([]byte(protocol.Encode(protocol.Error("ERR Invalid command type"))))
+				s.requestError(c, "ERR Protocol error: expected bulk string arguments")
 				continue
 			}
 
-			cmdStr := string(cmd)
-			log.Printf("Received command: %s with args: %v", cmdStr, v)
-
-			switch cmdStr {
-			case "PING":
-				log.Printf("Handling PING command")
-				c.Write([]byte(protocol.Encode(protocol.SimpleString("PONG"))))
-			case "SET":
-				s.handleSET(c, v)
-			case "GET":
-				s.handleGET(c, v)
-			case "DEL":
-				s.handleDel(c, v)
-			case "TTL":
-				s.handleTTL(c, v)
-			case "SADD":
-				s.handleSAdd(c, v)
-			case "SREM":
-				s.handleSRem(c, v)
-			case "SMEMBERS":
-				s.handleSMembers(c, v)
-			case "SCARD":
-				s.handleSCard(c, v)
-			case "SPOP":
-				s.handleSPop(c, v)
-			case "SUNION":
-				s.handleSUnion(c, v)
-			case "SINTER":
-				s.handleSInter(c, v)
-			case "SDIFF":
-				s.handleSDiff(c, v)
-			case "SISMEMBER":
-				s.handleSIsMember(c, v)
-			case "SRANDMEMBER":
-				s.handleSRandMember(c, v)
-			case "HSET":
-				s.handleHSet(c, v)
-			case "HGET":
-				s.handleHGet(c, v)
-			case "HDEL":
-				s.handleHDel(c, v)
-			case "HGETALL":
-				s.handleHGetAll(c, v)
-			case "CMSINCR":
-				s.handleCMSIncr(c, v)
-			case "CMSQUERY":
-				s.handleCMSQuery(c, v)
-			case "LPUSH":
-				s.handleLPush(c, v)
-			case "RPUSH":
-				s.handleRPush(c, v)
-			case "LPOP":
-				s.handleLPop(c, v)
-			case "RPOP":
-				s.handleRPop(c, v)
-			case "LLEN":
-				s.handleLLen(c, v)
-			case "LRANGE":
-				s.handleLRange(c, v)
-			case "ZADD":
-				s.handleZAdd(c, v)
-			case "ZSCORE":
-				s.handleZScore(c, v)
-			case "ZCARD":
-				s.handleZCard(c, v)
-			case "ZRANK":
-				s.handleZRank(c, v)
-			case "ZRANGE":
-				s.handleZRange(c, v)
-			case "BFADD":
-				s.handleBFAdd(c, v)
-			case "BFEXISTS":
-				s.handleBFExists(c, v)
-			case "ADDNODE":
-				log.Printf("Handling ADDNODE command with key: %s", string(v[1].(protocol.BulkString)))
-				s.handleAddNode(c, v)
-			case "REMOVENODE":
-				s.handleRemoveNode(c, v)
-			case "SUBSCRIBE":
-				s.handleSubscribe(c, v)
-			case "UNSUBSCRIBE":
-				s.handleUnsubscribe(c, v)
-			case "PUBLISH":
-				s.handlePublish(c, v)
-			default:
-				c.Write([]byte(protocol.Encode(protocol.Error("ERR Unknown command"))))
-			}
+			log.Printf("Received command: %s with args: %v", args[0], args)
+			s.dispatch(c, args[0], args)
+		default:
+			s.requestError(c, "ERR Invalid request")
+		}
+	}
+}
+
+// awaitCommand waits for the next command to start arriving, for at most
+// the idle timeout, then gives the rest of it read-timeout to arrive.
+// Subscribers and replicas wait without limit, as they may legitimately be
+// silent for long.
+func (s *Server) awaitCommand(c net.Conn, r *bufio.Reader) error {
+	idle := time.Duration(s.idleTimeout.Load())
+	if cl := s.client(c); cl != nil && clientType(cl) != "normal" {
+		idle = 0
+	}
+	if r.Buffered() == 0 {
+		switch {
+		case s.draining.Load():
+			// a command already sent is served; then the connection closes
+			c.SetReadDeadline(time.Now())
+		case idle > 0:
+			c.SetReadDeadline(time.Now().Add(idle))
 		default:
-			c.Write([]byte(protocol.Encode(protocol.Error("ERR Invalid request"))))
+			c.SetReadDeadline(time.Time{})
 		}
+		if _, err := r.Peek(1); err != nil {
+			if s.draining.Load() {
+				return errDraining
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return fmt.Errorf("idle for more than %v", idle)
+			}
+			return err
+		}
+	}
+	if d := time.Duration(s.readTimeout.Load()); d > 0 {
+		c.SetReadDeadline(time.Now().Add(d))
+	} else if idle > 0 {
+		c.SetReadDeadline(time.Time{})
+	}
+	return nil
+}
+
+// requestError rejects a malformed request, applying multi-error-policy if
+// c is inside MULTI
+func (s *Server) requestError(c net.Conn, msg string) {
+	if s.inMulti(c) {
+		s.txError(c, msg)
+		return
 	}
+	s.reply(c, protocol.Error(msg))
 }
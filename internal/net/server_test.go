@@ -0,0 +1,92 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"multithreaded-redis/internal/config"
+	"multithreaded-redis/internal/protocol"
+)
+
+// newTestServer starts a server on a free local port with logging
+// discarded, and shuts it down when t ends
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(out) })
+
+	cfg := config.Default()
+	cfg.Addr = "127.0.0.1:0"
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return s
+}
+
+// testClient sends commands to a test server and reads its replies
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (s *Server) dial(t *testing.T) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends args as a command and returns the reply
+func (tc *testClient) do(args ...string) protocol.RESPType {
+	tc.t.Helper()
+	cmd := make(protocol.Array, len(args))
+	for i, a := range args {
+		cmd[i] = protocol.BulkString(a)
+	}
+	if err := protocol.EncodeTo(tc.conn, cmd); err != nil {
+		tc.t.Fatal(err)
+	}
+	tc.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := protocol.ParseRESP(tc.r)
+	if err != nil {
+		tc.t.Fatalf("%v: %v", args, err)
+	}
+	return reply
+}
+
+// expect sends args and fails the test unless the reply is want
+func (tc *testClient) expect(want protocol.RESPType, args ...string) {
+	tc.t.Helper()
+	if got := tc.do(args...); !reflect.DeepEqual(got, want) {
+		tc.t.Errorf("%v = %#v, want %#v", args, got, want)
+	}
+}
+
+// bulks builds the array reply of the given bulk strings
+func bulks(elems ...string) protocol.Array {
+	arr := make(protocol.Array, len(elems))
+	for i, e := range elems {
+		arr[i] = protocol.BulkString(e)
+	}
+	return arr
+}
@@ -0,0 +1,69 @@
+package net
+
+import (
+	"net"
+	"strings"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+// TIER PIN key | UNPIN key | WHERE key | STATS
+func (s *Server) handleTier(c net.Conn, args []string) {
+	sub := args[1]
+	name := strings.ToUpper(sub)
+	if name == "STATS" {
+		st, ok := s.shards.TierStats()
+		if !ok {
+			s.reply(c, protocol.Error("ERR storage engine does not support tiering"))
+			return
+		}
+		s.reply(c, protocol.Map{
+			protocol.BulkString("hot_keys"), protocol.Integer(st.HotKeys),
+			protocol.BulkString("cold_keys"), protocol.Integer(st.ColdKeys),
+			protocol.BulkString("pinned_keys"), protocol.Integer(st.PinnedKeys),
+			protocol.BulkString("cold_bytes"), protocol.Integer(st.ColdBytes),
+			protocol.BulkString("hits"), protocol.Integer(st.Hits),
+			protocol.BulkString("misses"), protocol.Integer(st.Misses),
+			protocol.BulkString("promotions"), protocol.Integer(st.Promotions),
+			protocol.BulkString("demotions"), protocol.Integer(st.Demotions),
+		})
+		return
+	}
+	if name != "PIN" && name != "UNPIN" && name != "WHERE" {
+		s.reply(c, protocol.Error("ERR unknown subcommand '"+sub+"'. Try TIER PIN, TIER UNPIN, TIER WHERE or TIER STATS."))
+		return
+	}
+	if len(args) != 3 {
+		s.reply(c, protocol.Error("ERR wrong number of arguments for 'tier|"+strings.ToLower(name)+"' command"))
+		return
+	}
+	key := protocol.BulkString(s.dbKey(c, args[2]))
+
+	var reply protocol.RESPType
+	var err error
+	switch name {
+	case "PIN", "UNPIN":
+		var changed bool
+		if name == "PIN" {
+			changed, err = s.shards.Pin(string(key))
+		} else {
+			changed, err = s.shards.Unpin(string(key))
+		}
+		reply = protocol.Integer(0)
+		if changed {
+			reply = protocol.Integer(1)
+		}
+	case "WHERE":
+		var tier string
+		tier, err = s.shards.KeyTier(string(key))
+		reply = protocol.BulkString(nil)
+		if tier != "" {
+			reply = protocol.BulkString(tier)
+		}
+	}
+	if err != nil {
+		s.reply(c, protocol.Error(err.Error()))
+		return
+	}
+	s.reply(c, reply)
+}
@@ -0,0 +1,245 @@
+package net
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"multithreaded-redis/internal/store"
+)
+
+// A binary upgrade replaces the running process with a new one without
+// refusing connections. The old process starts the new binary with the
+// listening socket and two pipes as extra files, stops accepting, and
+// drains: every client is disconnected once its command in flight has
+// replied, and has to reconnect. It then streams a snapshot of the
+// keyspace down one pipe and waits for the new process to report on the
+// other that it has loaded it. The new process loads the snapshot before
+// it accepts anything, so connections arriving meanwhile wait in the
+// socket's backlog instead of being refused, and clients reconnecting find
+// every key they wrote.
+//
+// If the new process exits, or does not report back in time, the old one
+// kills it and goes back to serving on the same socket with the keys it
+// still holds. The new process reads its config from the same file and
+// flags; CONFIG SET changes survive only if CONFIG REWRITE saved them.
+
+// errDraining closes a connection during an upgrade
+var errDraining = errors.New("server is handing over to a new process")
+
+// upgradeEnv marks a process started by Upgrade
+const upgradeEnv = "MTREDIS_UPGRADE"
+
+// file descriptors of the inherited listener, snapshot pipe and ack pipe,
+// after stdin, stdout and stderr
+const (
+	upgradeListenerFD = 3
+	upgradeSnapshotFD = 4
+	upgradeAckFD      = 5
+)
+
+// upgradeHandoverTimeout bounds writing the snapshot and waiting for the
+// new process to load it
+const upgradeHandoverTimeout = time.Minute
+
+// drainPoll is how often draining connections still waiting for a command
+// are woken to notice it
+const drainPoll = 50 * time.Millisecond
+
+// Upgrade hands the listening socket and the keyspace to a new process
+// running the current executable with the same arguments, then shuts the
+// server down. ctx bounds the drain. On an error the server is serving
+// again: either the new process could not be started, or it failed to
+// take over and was killed.
+func (s *Server) Upgrade(ctx context.Context) error {
+	tl, ok := s.ln.(*net.TCPListener)
+	if !ok {
+		return errors.New("listener cannot be handed over")
+	}
+	lnFile, err := tl.File()
+	if err != nil {
+		return fmt.Errorf("duplicating listener: %w", err)
+	}
+	defer lnFile.Close()
+	snapR, snapW, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("creating snapshot pipe: %w", err)
+	}
+	defer snapW.Close()
+	ackR, ackW, err := os.Pipe()
+	if err != nil {
+		snapR.Close()
+		return fmt.Errorf("creating ack pipe: %w", err)
+	}
+	defer ackR.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		snapR.Close()
+		ackW.Close()
+		return fmt.Errorf("finding executable: %w", err)
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), upgradeEnv+"=1")
+	cmd.ExtraFiles = []*os.File{lnFile, snapR, ackW} // fds 3, 4 and 5
+	err = cmd.Start()
+	// only the new process may hold these, so its exit is seen as EOF
+	snapR.Close()
+	ackW.Close()
+	if err != nil {
+		return fmt.Errorf("starting %s: %w", exe, err)
+	}
+	go cmd.Wait()
+	log.Printf("Upgrade: started %s as pid %d, draining connections", exe, cmd.Process.Pid)
+
+	primary := s.linkAddr()
+	s.drain(ctx)
+	n, err := s.handOver(snapW, ackR)
+	if err != nil {
+		// it must not accept on the socket alongside this process
+		cmd.Process.Kill()
+		log.Printf("ERROR: Upgrade: pid %d did not take over, serving again: %v", cmd.Process.Pid, err)
+		if rerr := s.resume(lnFile, primary); rerr != nil {
+			return fmt.Errorf("handover failed: %v; resuming failed: %w", err, rerr)
+		}
+		return fmt.Errorf("handover failed: %w", err)
+	}
+	log.Printf("Upgrade: handed over %d keys to pid %d", n, cmd.Process.Pid)
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("Upgrade: shutdown: %v", err)
+	}
+	return nil
+}
+
+// handOver writes a snapshot of the keyspace to the new process and waits
+// for it to report that it loaded it, returning the number of keys
+func (s *Server) handOver(snapW, ackR *os.File) (int, error) {
+	deadline := time.Now().Add(upgradeHandoverTimeout)
+	snapW.SetWriteDeadline(deadline)
+	ackR.SetReadDeadline(deadline)
+
+	sn, err := s.shards.TakeSnapshot()
+	if err != nil {
+		return 0, fmt.Errorf("taking snapshot: %w", err)
+	}
+	if _, err := sn.WriteTo(snapW); err != nil {
+		return 0, fmt.Errorf("writing snapshot: %w", err)
+	}
+	snapW.Close()
+	var ack [1]byte
+	if _, err := ackR.Read(ack[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, errors.New("new process exited before loading the keyspace")
+		}
+		return 0, fmt.Errorf("waiting for the new process: %w", err)
+	}
+	return sn.Keys(), nil
+}
+
+// resume undoes drain after a failed handover, accepting again on the
+// listener Upgrade duplicated and relinking to the primary, if any
+func (s *Server) resume(lnFile *os.File, primary string) error {
+	ln, err := net.FileListener(lnFile)
+	if err != nil {
+		return fmt.Errorf("reopening listener: %w", err)
+	}
+	s.ln = ln
+	s.draining.Store(false)
+	go s.acceptLoop()
+	if primary != "" {
+		s.startReplication(primary)
+	}
+	if addr := s.cfg.Snapshot().WSAddr; addr != "" {
+		if err := s.StartWebSocket(addr); err != nil {
+			log.Printf("WARNING: Upgrade: %v", err)
+		}
+	}
+	return nil
+}
+
+// drain stops accepting and disconnects every client once its command in
+// flight has replied, waiting until they are all gone or ctx ends. Clients
+// are not handed over: they see their connection close and must reconnect.
+func (s *Server) drain(ctx context.Context) {
+	s.draining.Store(true)
+	s.dropLink() // the new process syncs with the primary itself
+	s.ln.Close()
+	if s.wsServer != nil {
+		s.wsServer.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	tick := time.NewTicker(drainPoll)
+	defer tick.Stop()
+	for {
+		// a connection waiting for its next command wakes and sees draining;
+		// one running a command finds it when the command is done
+		s.mu.Lock()
+		for c := range s.conns {
+			c.SetReadDeadline(time.Now())
+		}
+		s.mu.Unlock()
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			log.Printf("WARNING: Upgrade: connections still open after the drain timeout")
+			return
+		case <-tick.C:
+		}
+	}
+}
+
+// handover is what an upgrading parent passes down to the new process
+type handover struct {
+	ln       net.Listener
+	snapshot *os.File // the parent's keyspace, written once it has drained
+	ack      *os.File // written to once the snapshot is loaded
+}
+
+// inheritedHandover returns the socket and pipes an upgrading parent
+// passed down, or nil if this process was started normally
+func inheritedHandover() (*handover, error) {
+	if os.Getenv(upgradeEnv) == "" {
+		return nil, nil
+	}
+	os.Unsetenv(upgradeEnv) // not for processes this one starts
+	f := os.NewFile(upgradeListenerFD, "listener")
+	ln, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("inheriting listener: %w", err)
+	}
+	return &handover{
+		ln:       ln,
+		snapshot: os.NewFile(upgradeSnapshotFD, "snapshot"),
+		ack:      os.NewFile(upgradeAckFD, "ack"),
+	}, nil
+}
+
+// load reads the parent's keyspace and tells the parent it may exit. On an
+// error nothing is acknowledged, and the parent goes back to serving once
+// this process exits.
+func (h *handover) load(ss *store.SharedStore) (int, error) {
+	defer h.ack.Close()
+	n, err := ss.LoadSnapshot(h.snapshot)
+	h.snapshot.Close()
+	if err != nil {
+		return n, err
+	}
+	if _, err := h.ack.Write([]byte{1}); err != nil {
+		return n, fmt.Errorf("acknowledging the handover: %w", err)
+	}
+	return n, nil
+}
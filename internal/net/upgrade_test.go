@@ -0,0 +1,71 @@
+package net
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"multithreaded-redis/internal/protocol"
+)
+
+func TestUpgradeHandover(t *testing.T) {
+	old, next := newTestServer(t), newTestServer(t)
+	old.dial(t).expect(protocol.SimpleString("OK"), "SET", "k", "v")
+
+	snapR, snapW, _ := os.Pipe()
+	ackR, ackW, _ := os.Pipe()
+	h := &handover{snapshot: snapR, ack: ackW}
+	loaded := make(chan error, 1)
+	go func() {
+		_, err := h.load(next.shards)
+		loaded <- err
+	}()
+	if n, err := old.handOver(snapW, ackR); n != 1 || err != nil {
+		t.Fatalf("handOver = %d, %v", n, err)
+	}
+	if err := <-loaded; err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	next.dial(t).expect(protocol.BulkString("v"), "GET", "k")
+
+	// a new process that goes away without acknowledging fails the handover
+	snapR, snapW, _ = os.Pipe()
+	ackR, ackW, _ = os.Pipe()
+	go func() {
+		io.Copy(io.Discard, snapR)
+		snapR.Close()
+		ackW.Close()
+	}()
+	if _, err := old.handOver(snapW, ackR); err == nil {
+		t.Error("handOver succeeded without an ack")
+	}
+}
+
+func TestUpgradeResume(t *testing.T) {
+	s := newTestServer(t)
+	c := s.dial(t)
+	c.expect(protocol.SimpleString("OK"), "SET", "k", "v")
+
+	lnFile, err := s.ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lnFile.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.drain(ctx)
+
+	// draining closed the client, which has to reconnect
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.r.ReadByte(); err != io.EOF {
+		t.Errorf("a drained connection read %v, want EOF", err)
+	}
+
+	if err := s.resume(lnFile, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.dial(t).expect(protocol.BulkString("v"), "GET", "k")
+}
@@ -0,0 +1,258 @@
+package net
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// RFC 6455 constants
+const (
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsMaxFrame = 512 * 1024 * 1024 // matches the bulk string limit
+)
+
+// wsConn carries a RESP byte stream inside WebSocket frames so handleConn can
+// serve browser clients unchanged. Incoming data frames are concatenated;
+// every Write becomes one binary frame.
+type wsConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	remaining uint64 // unread payload bytes of the current data frame
+	mask      [4]byte
+	maskPos   int
+	masked    bool
+
+	wmu    sync.Mutex
+	closed bool
+}
+
+func (ws *wsConn) Read(p []byte) (int, error) {
+	for ws.remaining == 0 {
+		if err := ws.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(p)) > ws.remaining {
+		p = p[:ws.remaining]
+	}
+	n, err := ws.r.Read(p)
+	if ws.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= ws.mask[ws.maskPos%4]
+			ws.maskPos++
+		}
+	}
+	ws.remaining -= uint64(n)
+	return n, err
+}
+
+// nextFrame reads frame headers until a data frame with payload arrives,
+// answering control frames along the way
+func (ws *wsConn) nextFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(ws.r, hdr[:]); err != nil {
+		return err
+	}
+	opcode := hdr[0] & 0x0F
+	masked := hdr[1]&0x80 != 0
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(ws.r, ext[:]); err != nil {
+			return err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(ws.r, ext[:]); err != nil {
+			return err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxFrame {
+		ws.writeFrame(wsOpClose, closePayload(1009, "frame too large"))
+		return errors.New("websocket frame too large")
+	}
+	if !masked {
+		// clients must mask every frame
+		ws.writeFrame(wsOpClose, closePayload(1002, "unmasked frame"))
+		return errors.New("unmasked websocket frame from client")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.r, mask[:]); err != nil {
+		return err
+	}
+
+	switch opcode {
+	case wsOpText, wsOpBinary, wsOpContinuation:
+		ws.remaining, ws.mask, ws.maskPos, ws.masked = length, mask, 0, masked
+		return nil
+	case wsOpPing, wsOpPong, wsOpClose:
+		if length > 125 {
+			return errors.New("websocket control frame too large")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(ws.r, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		switch opcode {
+		case wsOpPing:
+			return ws.writeFrame(wsOpPong, payload)
+		case wsOpClose:
+			ws.writeFrame(wsOpClose, payload)
+			return io.EOF
+		}
+		return nil
+	default:
+		ws.writeFrame(wsOpClose, closePayload(1003, "unsupported opcode"))
+		return fmt.Errorf("unsupported websocket opcode %d", opcode)
+	}
+}
+
+func (ws *wsConn) Write(p []byte) (int, error) {
+	if err := ws.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame sends one unfragmented, unmasked frame (servers never mask)
+func (ws *wsConn) writeFrame(opcode byte, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closed {
+		return net.ErrClosed
+	}
+	if opcode == wsOpClose {
+		ws.closed = true
+	}
+
+	hdr := make([]byte, 2, 10)
+	hdr[0] = 0x80 | opcode // FIN
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n <= 0xFFFF:
+		hdr[1] = 126
+		hdr = binary.BigEndian.AppendUint16(hdr, uint16(n))
+	default:
+		hdr[1] = 127
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+	if _, err := ws.Conn.Write(append(hdr, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (ws *wsConn) Close() error {
+	ws.writeFrame(wsOpClose, closePayload(1000, ""))
+	return ws.Conn.Close()
+}
+
+func closePayload(code uint16, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, code), reason...)
+}
+
+// wsAccept computes the Sec-WebSocket-Accept header for a client key
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StartWebSocket serves RESP over WebSocket on addr, for browser dashboards
+// and environments without raw TCP. Any request path is accepted; the "resp"
+// subprotocol is confirmed when the client offers it.
+func (s *Server) StartWebSocket(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to start websocket listener: %w", err)
+	}
+	s.wsServer = &http.Server{Handler: http.HandlerFunc(s.handleWebSocket)}
+	log.Printf("WebSocket listener started on %s", addr)
+	go func() {
+		if err := s.wsServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("websocket listener stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// handleWebSocket performs the RFC 6455 handshake and hands the connection to
+// the regular RESP loop
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || key == "" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("websocket hijack failed: %v", err)
+		return
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n"
+	if headerContains(r.Header, "Sec-WebSocket-Protocol", "resp") {
+		resp += "Sec-WebSocket-Protocol: resp\r\n"
+	}
+	if _, err := conn.Write([]byte(resp + "\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+
+	ws := &wsConn{Conn: conn, r: rw.Reader}
+	s.register(ws)
+
+	s.wg.Add(1)
+	go s.handleConn(ws)
+}
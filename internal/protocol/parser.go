@@ -2,12 +2,83 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
 )
 
+// ProtocolError is malformed input. Servers reply with it as
+// "-ERR Protocol error: ..." and close the connection, as the stream can no
+// longer be framed.
+type ProtocolError string
+
+func (e ProtocolError) Error() string { return "Protocol error: " + string(e) }
+
+const (
+	// DefaultMaxBulkLen is the largest bulk string ParseRESP accepts, as
+	// Redis's proto-max-bulk-len
+	DefaultMaxBulkLen = 512 << 20
+
+	// MaxMultibulkLen is the most elements an array may claim
+	MaxMultibulkLen = 1024 * 1024
+
+	maxInlineLen     = 64 << 10 // longest inline command
+	maxLineLen       = 64 << 10 // longest simple string, error or length line
+	maxArrayPrealloc = 1024     // elements allocated up front, whatever the header claims
+	maxBulkPrealloc  = 32 << 10 // bulk bytes allocated up front; longer ones grow as they arrive
+)
+
+// ParseRESP reads one RESP value, with bulk strings of at most
+// DefaultMaxBulkLen bytes
 func ParseRESP(r *bufio.Reader) (RESPType, error) {
+	return parse(r, DefaultMaxBulkLen)
+}
+
+// ParseRequest reads one client command: an array of bulk strings, or an
+// inline command, a line of space separated arguments as telnet sends them.
+// Arrays of more than maxArgs elements and bulk strings longer than maxBulk
+// are refused. A blank inline line or an empty array is returned as a nil
+// Array, to be skipped.
+func ParseRequest(r *bufio.Reader, maxArgs, maxBulk int) (RESPType, error) {
+	prefix, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if prefix[0] != '*' {
+		return parseInline(r)
+	}
+	r.ReadByte()
+	n, err := readLength(r, "multibulk")
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return Array(nil), nil // nothing to run, like a blank line
+	}
+	if n > maxArgs {
+		return nil, ProtocolError("invalid multibulk length")
+	}
+	arr := make(Array, 0, min(n, maxArrayPrealloc))
+	for range n {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != '$' {
+			return nil, ProtocolError(fmt.Sprintf("expected '$', got '%c'", b))
+		}
+		elem, err := parseBulk(r, maxBulk)
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, elem)
+	}
+	return arr, nil
+}
+
+func parse(r *bufio.Reader, maxBulk int) (RESPType, error) {
 	prefix, err := r.ReadByte()
 	if err != nil {
 		return nil, err
@@ -15,56 +86,249 @@ func ParseRESP(r *bufio.Reader) (RESPType, error) {
 
 	switch prefix {
 	case '+': // Simple String
-		line, _ := r.ReadString('\n')
-		return SimpleString(trim(line)), nil
+		line, err := readLine(r, maxLineLen)
+		if err != nil {
+			return nil, err
+		}
+		return SimpleString(line), nil
 	case '-': // Error
-		line, _ := r.ReadString('\n')
-		return Error(trim(line)), nil
+		line, err := readLine(r, maxLineLen)
+		if err != nil {
+			return nil, err
+		}
+		return Error(line), nil
 	case ':': // Integer
-		line, _ := r.ReadString('\n')
-		val, _ := strconv.ParseInt(trim(line), 10, 64)
+		line, err := readLine(r, maxLineLen)
+		if err != nil {
+			return nil, err
+		}
+		val, err := strconv.ParseInt(string(line), 10, 64)
+		if err != nil {
+			return nil, ProtocolError("invalid integer")
+		}
 		return Integer(val), nil
 	case '$': // Bulk String
-		line, _ := r.ReadString('\n')
-		length, _ := strconv.Atoi(trim(line))
-		if length == -1 {
-			return BulkString(nil), nil
-		}
-		buf := make([]byte, length+2) // +2 for \r\n
-		_, err = io.ReadFull(r, buf)
+		return parseBulk(r, maxBulk)
+	case '*': // Array
+		n, err := readLength(r, "multibulk")
 		if err != nil {
 			return nil, err
 		}
-		return BulkString(buf[:length]), nil
-	case '*': // Array
-		line, _ := r.ReadString('\n')
-		length, _ := strconv.Atoi(trim(line))
-		if length == -1 {
+		if n < 0 {
 			return Array(nil), nil
 		}
-		arr := make(Array, length)
-		for i := 0; i < length; i++ {
-			elem, err := ParseRESP(r)
+		if n > MaxMultibulkLen {
+			return nil, ProtocolError("invalid multibulk length")
+		}
+		arr := make(Array, 0, min(n, maxArrayPrealloc))
+		for range n {
+			elem, err := parse(r, maxBulk)
 			if err != nil {
 				return nil, err
 			}
-			arr[i] = elem
+			arr = append(arr, elem)
 		}
 		return arr, nil
 	default:
-		return nil, fmt.Errorf("invalid RESP prefix: %q", prefix)
+		return nil, ProtocolError(fmt.Sprintf("invalid RESP prefix %q", prefix))
+	}
+}
+
+// parseBulk reads a bulk string once its '$' is consumed. Only
+// maxBulkPrealloc bytes are allocated on the length's word; past that the
+// buffer grows with the bytes that actually arrive, so a peer claiming a
+// huge length and sending nothing costs little.
+func parseBulk(r *bufio.Reader, maxBulk int) (RESPType, error) {
+	n, err := readLength(r, "bulk")
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return BulkString(nil), nil
+	}
+	if n > maxBulk {
+		return nil, ProtocolError("invalid bulk length")
+	}
+	var buf []byte
+	if n <= maxBulkPrealloc {
+		buf = make([]byte, n+2) // +2 for \r\n
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+	} else {
+		b := bytes.NewBuffer(make([]byte, 0, maxBulkPrealloc))
+		if _, err := io.CopyN(b, r, int64(n)+2); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		buf = b.Bytes()
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, ProtocolError("expected CRLF after bulk string")
+	}
+	return BulkString(buf[:n]), nil
+}
+
+// readLength reads the length line of a bulk string or array: a
+// non-negative count, or -1 for null
+func readLength(r *bufio.Reader, what string) (int, error) {
+	line, err := readLine(r, maxLineLen)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(string(line))
+	if err != nil || n < -1 {
+		return 0, ProtocolError("invalid " + what + " length")
+	}
+	return n, nil
+}
+
+// readLine reads up to the next CRLF and returns the line without it.
+// Lines longer than limit are refused.
+func readLine(r *bufio.Reader, limit int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > limit+2 {
+			return nil, ProtocolError("too big line")
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, ProtocolError("expected CRLF")
 	}
+	return line[:len(line)-2], nil
 }
 
-func trim(s string) string {
-	if len(s) == 0 {
-		return s
+// parseInline reads a command sent as a plain line. A lone LF ends the line
+// too, for clients that do not send CR.
+func parseInline(r *bufio.Reader) (RESPType, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxInlineLen {
+			return nil, ProtocolError("too big inline request")
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
 	}
-	if s[len(s)-2:] == "\r\n" {
-		s = s[:len(s)-2]
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	args, err := splitArgs(line)
+	if err != nil {
+		return nil, err
 	}
-	if s[len(s)-1] == '\n' {
-		s = s[:len(s)-1]
+	if len(args) == 0 {
+		return Array(nil), nil
+	}
+	return args, nil
+}
+
+// splitArgs splits an inline command into arguments the way redis-cli
+// quotes them: "double quotes" take \n, \r, \t, \b, \a, \xHH and escaped
+// quotes, 'single quotes' only \'. A closing quote must be followed by a
+// space or the end of the line.
+func splitArgs(line []byte) (Array, error) {
+	var args Array
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, ProtocolError("unbalanced quotes in request")
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDouble:
+				switch {
+				case c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					v, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					arg = append(arg, byte(v))
+					i += 3
+				case c == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				case c == '"':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ProtocolError("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			case inSingle:
+				switch {
+				case c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					arg = append(arg, '\'')
+					i++
+				case c == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, ProtocolError("unbalanced quotes in request")
+					}
+					done = true
+				default:
+					arg = append(arg, c)
+				}
+			default:
+				switch c {
+				case ' ', '\t', '\r', '\n':
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			i++
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, BulkString(arg))
 	}
-	return s
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
 }
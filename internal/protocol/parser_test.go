@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		in   string
+		want RESPType
+		err  string // substring of the error, "" for none
+	}{
+		{"*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", Array{BulkString("GET"), BulkString("k")}, ""},
+		{"*1\r\n$0\r\n\r\n", Array{BulkString("")}, ""},
+		{"*0\r\n", Array(nil), ""},
+		{"*-1\r\n", Array(nil), ""},
+		{"*1\r\n$-1\r\n", Array{BulkString(nil)}, ""},
+		{"PING\r\n", Array{BulkString("PING")}, ""},
+		{"PING\n", Array{BulkString("PING")}, ""},
+		{"\r\n", Array(nil), ""},
+
+		{"*x\r\n", nil, "invalid multibulk length"},
+		{"*-2\r\n", nil, "invalid multibulk length"},
+		{"*11\r\n", nil, "invalid multibulk length"},
+		{"*1\r\n$x\r\n", nil, "invalid bulk length"},
+		{"*1\r\n$-2\r\n", nil, "invalid bulk length"},
+		{"*1\r\n:1\r\n", nil, "expected '$'"},
+		{"*1\r\n$3\r\nGETX\r\n", nil, "expected CRLF after bulk string"},
+		{"*1\n", nil, "expected CRLF"},
+		{"*1\r\n$65\r\n", nil, "invalid bulk length"},
+		{"*1\r\n$3\r\nGE", nil, io.ErrUnexpectedEOF.Error()},
+		{"*2\r\n$3\r\nGET\r\n", nil, io.EOF.Error()},
+		{"GET \"k\r\n", nil, "unbalanced quotes"},
+		{"*" + strings.Repeat("1", maxLineLen+1) + "\r\n", nil, "too big line"},
+		{strings.Repeat("a", maxInlineLen+1) + "\r\n", nil, "too big inline request"},
+	}
+	for _, tt := range tests {
+		got, err := ParseRequest(bufio.NewReader(strings.NewReader(tt.in)), 10, 64)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseRequest(%.40q) error = %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", tt.want) {
+			t.Errorf("ParseRequest(%.40q) = %#v, %v; want %#v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// TestParseBulkGrows checks that a large claimed length is only allocated
+// as its bytes arrive
+func TestParseBulkGrows(t *testing.T) {
+	const n = 1 << 20
+	in := fmt.Sprintf("$%d\r\n%s\r\n", n, strings.Repeat("v", n))
+	got, err := ParseRESP(bufio.NewReader(strings.NewReader(in)))
+	if b, _ := got.(BulkString); err != nil || len(b) != n {
+		t.Fatalf("ParseRESP of a %d byte bulk = %d bytes, %v", n, len(b), err)
+	}
+
+	// a length of 500MB with nothing behind it fails on the missing bytes
+	// without allocating them
+	allocs := testing.AllocsPerRun(1, func() {
+		_, err = ParseRESP(bufio.NewReader(strings.NewReader("$500000000\r\nshort")))
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated bulk error = %v", err)
+	}
+	if allocs > 10 {
+		t.Errorf("truncated bulk took %v allocations", allocs)
+	}
+}
+
+func TestParseRESP(t *testing.T) {
+	tests := []struct {
+		in   string
+		want RESPType
+	}{
+		{"+OK\r\n", SimpleString("OK")},
+		{"-ERR x\r\n", Error("ERR x")},
+		{":-7\r\n", Integer(-7)},
+		{"$-1\r\n", BulkString(nil)},
+		{"*-1\r\n", Array(nil)},
+		{"*2\r\n:1\r\n*1\r\n+a\r\n", Array{Integer(1), Array{SimpleString("a")}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRESP(bufio.NewReader(strings.NewReader(tt.in)))
+		if err != nil || fmt.Sprintf("%#v", got) != fmt.Sprintf("%#v", tt.want) {
+			t.Errorf("ParseRESP(%q) = %#v, %v; want %#v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{":x\r\n", "?\r\n", fmt.Sprintf("*%d\r\n", MaxMultibulkLen+1)} {
+		if _, err := ParseRESP(bufio.NewReader(strings.NewReader(bad))); !errors.As(err, new(ProtocolError)) {
+			t.Errorf("ParseRESP(%q) error = %v, want a ProtocolError", bad, err)
+		}
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		ok   bool
+	}{
+		{"set k v", []string{"set", "k", "v"}, true},
+		{"  a \t b  ", []string{"a", "b"}, true},
+		{`set k "hello world"`, []string{"set", "k", "hello world"}, true},
+		{`"a\nb\tc\x41\x4a\"q"`, []string{"a\nb\tcAJ\"q"}, true},
+		{`"\x4"`, []string{"x4"}, true},
+		{`'it\'s' 'a\nb'`, []string{"it's", `a\nb`}, true},
+		{`""`, []string{""}, true},
+		{`"abc"def`, nil, false},
+		{`'abc`, nil, false},
+		{`"abc`, nil, false},
+		{"", nil, true},
+	}
+	for _, tt := range tests {
+		args, err := splitArgs([]byte(tt.in))
+		if (err == nil) != tt.ok {
+			t.Errorf("splitArgs(%q) error = %v", tt.in, err)
+			continue
+		}
+		got := make([]string, len(args))
+		for i, a := range args {
+			got[i] = string(a.(BulkString))
+		}
+		if tt.ok && fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package protocol
 
 import (
-	"fmt"
-	"strings"
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"sync"
 )
 
 type RESPType interface{}
@@ -13,31 +16,241 @@ type Integer int64
 type BulkString []byte
 type Array []RESPType
 
+// Map is a list of alternating keys and values. RESP2 clients receive it as a
+// flat array, RESP3 clients as a native map.
+type Map []RESPType
+
+// Push is an out-of-band message. RESP3 clients receive it as a push, RESP2
+// clients as an array.
+type Push []RESPType
+
+// Set is an unordered collection. RESP3 clients receive it as a set, RESP2
+// clients as an array.
+type Set []RESPType
+
+// The remaining types are RESP3's; RESP2 clients receive each as the closest
+// RESP2 type, as noted.
+type (
+	Null      struct{} // a null bulk string
+	Boolean   bool     // the integer 1 or 0
+	Double    float64  // a bulk string
+	BigNumber string   // decimal digits, optionally signed; a bulk string
+)
+
+// Attribute is Value preceded by auxiliary data about it. RESP2 clients
+// receive only Value.
+type Attribute struct {
+	Attrs Map
+	Value RESPType
+}
+
+// VerbatimString is text with a three letter format such as txt or mkd.
+// RESP2 clients receive the text as a bulk string.
+type VerbatimString struct {
+	Format string
+	Text   string
+}
+
 // Encode helpers
 func Encode(v RESPType) string {
+	return string(Append(nil, v))
+}
+
+// EncodeProto encodes v for a client speaking the given RESP version
+func EncodeProto(v RESPType, proto int) string {
+	return string(AppendProto(nil, v, proto))
+}
+
+// Append appends the RESP2 encoding of v to b, growing it as needed
+func Append(b []byte, v RESPType) []byte {
+	return AppendProto(b, v, 2)
+}
+
+// AppendProto appends the encoding of v for the given RESP version to b.
+// Nothing is built apart from b, so a reused b makes encoding
+// allocation-free.
+func AppendProto(b []byte, v RESPType, proto int) []byte {
 	switch x := v.(type) {
 	case SimpleString:
-		return fmt.Sprintf("+%s\r\n", string(x))
+		return append(append(append(b, '+'), x...), "\r\n"...)
 	case Error:
-		return fmt.Sprintf("-%s\r\n", string(x))
+		return append(append(append(b, '-'), x...), "\r\n"...)
 	case Integer:
-		return fmt.Sprintf(":%d\r\n", int64(x))
+		return append(strconv.AppendInt(append(b, ':'), int64(x), 10), "\r\n"...)
 	case BulkString:
 		if x == nil {
-			return "$-1\r\n"
+			return append(b, "$-1\r\n"...)
 		}
-		return fmt.Sprintf("$%d\r\n%s\r\n", len(x), x)
+		b = appendHeader(b, '$', len(x))
+		return append(append(b, x...), "\r\n"...)
 	case Array:
 		if x == nil {
-			return "*-1\r\n"
+			return append(b, "*-1\r\n"...)
 		}
-		var b strings.Builder
-		b.WriteString(fmt.Sprintf("*%d\r\n", len(x)))
-		for _, elem := range x {
-			b.WriteString(Encode(elem))
+		return appendAggregate(b, '*', len(x), x, proto)
+	case Map:
+		if proto >= 3 {
+			return appendAggregate(b, '%', len(x)/2, x, proto)
 		}
-		return b.String()
+		return AppendProto(b, Array(x), proto)
+	case Push:
+		if proto >= 3 {
+			return appendAggregate(b, '>', len(x), x, proto)
+		}
+		return AppendProto(b, Array(x), proto)
+	case Set:
+		if proto >= 3 {
+			return appendAggregate(b, '~', len(x), x, proto)
+		}
+		return AppendProto(b, Array(x), proto)
+	case Null:
+		if proto >= 3 {
+			return append(b, "_\r\n"...)
+		}
+		return append(b, "$-1\r\n"...)
+	case Boolean:
+		if proto >= 3 {
+			if x {
+				return append(b, "#t\r\n"...)
+			}
+			return append(b, "#f\r\n"...)
+		}
+		if x {
+			return append(b, ":1\r\n"...)
+		}
+		return append(b, ":0\r\n"...)
+	case Double:
+		if proto >= 3 {
+			return append(appendDouble(append(b, ','), float64(x)), "\r\n"...)
+		}
+		return AppendProto(b, BulkString(appendDouble(nil, float64(x))), proto)
+	case BigNumber:
+		if proto >= 3 {
+			return append(append(append(b, '('), x...), "\r\n"...)
+		}
+		return AppendProto(b, BulkString(x), proto)
+	case VerbatimString:
+		if proto >= 3 {
+			b = appendHeader(b, '=', len(x.Format)+1+len(x.Text))
+			return append(append(append(append(b, x.Format...), ':'), x.Text...), "\r\n"...)
+		}
+		return AppendProto(b, BulkString(x.Text), proto)
+	case Attribute:
+		if proto >= 3 {
+			b = appendAggregate(b, '|', len(x.Attrs)/2, x.Attrs, proto)
+		}
+		return AppendProto(b, x.Value, proto)
 	default:
-		return "-ERR unknown type\r\n"
+		return append(b, "-ERR unknown type\r\n"...)
+	}
+}
+
+func appendHeader(b []byte, kind byte, n int) []byte {
+	return append(strconv.AppendInt(append(b, kind), int64(n), 10), "\r\n"...)
+}
+
+// appendDouble spells f the way RESP3 does, with inf, -inf and nan for the
+// values that have no digits
+func appendDouble(b []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(b, "inf"...)
+	case math.IsInf(f, -1):
+		return append(b, "-inf"...)
+	case math.IsNaN(f):
+		return append(b, "nan"...)
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, 64)
+}
+
+func appendAggregate(b []byte, kind byte, n int, elems []RESPType, proto int) []byte {
+	b = appendHeader(b, kind, n)
+	for _, elem := range elems {
+		b = AppendProto(b, elem, proto)
+	}
+	return b
+}
+
+// encodeBufs recycles the buffers of EncodeTo
+var encodeBufs = sync.Pool{New: func() any { return new([]byte) }}
+
+// maxPooledEncode caps the buffers kept for reuse, so one huge reply does
+// not pin its memory
+const maxPooledEncode = 64 << 10
+
+// EncodeTo writes the RESP2 encoding of v to w, see EncodeProtoTo
+func EncodeTo(w io.Writer, v RESPType) error {
+	return EncodeProtoTo(w, v, 2)
+}
+
+// WriteProto streams the encoding of v for the given RESP version into w,
+// one element at a time, so a large reply goes out as w fills rather than
+// being built whole first. The caller flushes w.
+func WriteProto(w *bufio.Writer, v RESPType, proto int) error {
+	var elems []RESPType
+	var kind byte
+	switch x := v.(type) {
+	case Array:
+		elems, kind = x, '*'
+		if x == nil {
+			_, err := w.WriteString("*-1\r\n")
+			return err
+		}
+	case Map:
+		elems, kind = x, '*'
+		if proto >= 3 {
+			kind = '%'
+		}
+	case Push:
+		elems, kind = x, '*'
+		if proto >= 3 {
+			kind = '>'
+		}
+	case BulkString:
+		if len(x) > w.Available() {
+			// too big to encode in place: skip the copy into the buffer
+			w.Write(appendHeader(w.AvailableBuffer(), '$', len(x)))
+			w.Write(x)
+			_, err := w.WriteString("\r\n")
+			return err
+		}
+		_, err := w.Write(AppendProto(w.AvailableBuffer(), v, proto))
+		return err
+	default:
+		_, err := w.Write(AppendProto(w.AvailableBuffer(), v, proto))
+		return err
+	}
+	n := len(elems)
+	if kind == '%' {
+		n /= 2
+	}
+	if _, err := w.Write(appendHeader(w.AvailableBuffer(), kind, n)); err != nil {
+		return err
+	}
+	for _, elem := range elems {
+		if err := WriteProto(w, elem, proto); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeProtoTo writes the encoding of v for the given RESP version to w. A
+// *bufio.Writer is streamed into with WriteProto and flushed; any other
+// writer gets the whole encoding in a single Write, from a pooled buffer.
+func EncodeProtoTo(w io.Writer, v RESPType, proto int) error {
+	if bw, ok := w.(*bufio.Writer); ok {
+		if err := WriteProto(bw, v, proto); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	bp := encodeBufs.Get().(*[]byte)
+	b := AppendProto((*bp)[:0], v, proto)
+	_, err := w.Write(b)
+	if cap(b) <= maxPooledEncode {
+		*bp = b
+		encodeBufs.Put(bp)
 	}
+	return err
 }
@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAppendProto(t *testing.T) {
+	tests := []struct {
+		v     RESPType
+		proto int
+		want  string
+	}{
+		{SimpleString("OK"), 2, "+OK\r\n"},
+		{Error("ERR x"), 2, "-ERR x\r\n"},
+		{Integer(-42), 2, ":-42\r\n"},
+		{BulkString("hi"), 2, "$2\r\nhi\r\n"},
+		{BulkString(nil), 2, "$-1\r\n"},
+		{BulkString(""), 2, "$0\r\n\r\n"},
+		{Array(nil), 2, "*-1\r\n"},
+		{Array{}, 2, "*0\r\n"},
+		{Array{Integer(1), Array{BulkString("a")}}, 2, "*2\r\n:1\r\n*1\r\n$1\r\na\r\n"},
+		{Map{BulkString("k"), Integer(1)}, 2, "*2\r\n$1\r\nk\r\n:1\r\n"},
+		{Map{BulkString("k"), Integer(1)}, 3, "%1\r\n$1\r\nk\r\n:1\r\n"},
+		{Push{BulkString("m")}, 2, "*1\r\n$1\r\nm\r\n"},
+		{Push{BulkString("m")}, 3, ">1\r\n$1\r\nm\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(AppendProto(nil, tt.v, tt.proto)); got != tt.want {
+			t.Errorf("AppendProto(%#v, %d) = %q, want %q", tt.v, tt.proto, got, tt.want)
+		}
+	}
+}
+
+// TestWriteProtoStreams checks that streaming through a buffer smaller than
+// the reply writes the same bytes as encoding it whole
+func TestWriteProtoStreams(t *testing.T) {
+	big := make(Array, 500)
+	for i := range big {
+		big[i] = BulkString(strings.Repeat("x", i))
+	}
+	values := []RESPType{
+		big,
+		Map{BulkString("nested"), big, BulkString("n"), Integer(7)},
+		BulkString(strings.Repeat("y", 10000)),
+		Push{Array(nil), BulkString(nil), Error("ERR e")},
+	}
+	for _, proto := range []int{2, 3} {
+		for i, v := range values {
+			var out bytes.Buffer
+			w := bufio.NewWriterSize(&out, 64)
+			if err := WriteProto(w, v, proto); err != nil {
+				t.Fatal(err)
+			}
+			w.Flush()
+			if want := AppendProto(nil, v, proto); !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("value %d, RESP%d: streamed %d bytes differ from the %d encoded", i, proto, out.Len(), len(want))
+			}
+		}
+	}
+}
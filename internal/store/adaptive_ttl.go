@@ -0,0 +1,117 @@
+package store
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// AdaptiveTTL moves the TTLs of volatile keys toward how often they are
+// read. Every Interval, a key read at least HotReads times has its remaining
+// TTL doubled, up to Max; a key not read at all has it halved, down to Min.
+// Keys without a TTL are never given one, and TTLs already outside the
+// bounds are not pulled into them.
+type AdaptiveTTL struct {
+	Interval time.Duration // 0 disables the policy
+	Min      time.Duration
+	Max      time.Duration
+	HotReads int
+}
+
+// Enabled reports whether the policy adjusts any TTLs
+func (p AdaptiveTTL) Enabled() bool {
+	return p.Interval > 0
+}
+
+// adaptiveState is the per-store side of the policy. reads is guarded by
+// lazy.mu and only filled while the policy is enabled.
+type adaptiveState struct {
+	policy    atomic.Pointer[AdaptiveTTL]
+	wake      chan struct{}
+	reads     map[string]uint32
+	extended  atomic.Uint64
+	shortened atomic.Uint64
+}
+
+// AdaptiveTTLStats counts the TTLs the policy has changed
+type AdaptiveTTLStats struct {
+	Extended  uint64
+	Shortened uint64
+}
+
+// countRead records a read of key for the adaptive TTL policy. Callers hold
+// lazy.mu.
+func (s *Store) countRead(key string) {
+	if p := s.adaptive.policy.Load(); p == nil || !p.Enabled() {
+		return
+	}
+	if s.adaptive.reads == nil {
+		s.adaptive.reads = make(map[string]uint32)
+	}
+	s.adaptive.reads[key]++
+}
+
+// StartAdaptiveTTL applies p and runs the policy until the process exits
+func (s *Store) StartAdaptiveTTL(p AdaptiveTTL) {
+	s.SetAdaptiveTTL(p)
+	go func() {
+		for {
+			var tick <-chan time.Time
+			if p := s.adaptive.policy.Load(); p.Enabled() {
+				tick = time.After(p.Interval)
+			}
+			select {
+			case <-tick:
+				s.adaptTTLs(*s.adaptive.policy.Load())
+			case <-s.adaptive.wake:
+				// policy changed: start a fresh interval
+			}
+		}
+	}()
+}
+
+// SetAdaptiveTTL changes the policy at runtime. Reads counted so far are
+// dropped, so the first interval under the new policy starts empty.
+func (s *Store) SetAdaptiveTTL(p AdaptiveTTL) {
+	s.adaptive.policy.Store(&p)
+	s.lazy.mu.Lock()
+	s.adaptive.reads = nil
+	s.lazy.mu.Unlock()
+	select {
+	case s.adaptive.wake <- struct{}{}:
+	default:
+	}
+}
+
+// adaptTTLs applies one interval of the policy to every volatile key
+func (s *Store) adaptTTLs(p AdaptiveTTL) {
+	s.lazy.mu.Lock()
+	reads := s.adaptive.reads
+	s.adaptive.reads = nil
+	s.lazy.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, exp := range s.ttl {
+		left := exp.Sub(now)
+		if left <= 0 {
+			continue // the cleaner's
+		}
+		switch n := reads[key]; {
+		case int(n) >= p.HotReads && left < p.Max:
+			s.setTTL(key, now.Add(min(2*left, p.Max)))
+			s.adaptive.extended.Add(1)
+		case n == 0 && left > p.Min:
+			s.setTTL(key, now.Add(max(left/2, p.Min)))
+			s.adaptive.shortened.Add(1)
+		}
+	}
+}
+
+// AdaptiveTTLStats reports how many TTLs the policy has changed
+func (s *Store) AdaptiveTTLStats() AdaptiveTTLStats {
+	return AdaptiveTTLStats{
+		Extended:  s.adaptive.extended.Load(),
+		Shortened: s.adaptive.shortened.Load(),
+	}
+}
@@ -0,0 +1,458 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backupVersion is written to every manifest; restores refuse other versions
+const backupVersion = 1
+
+// backupFreezeTimeout bounds how long BACKUP waits for every shard worker to
+// pause before giving up
+const backupFreezeTimeout = 5 * time.Second
+
+const backupManifestName = "manifest.json"
+
+// BackupTarget is where a backup is written to or read from
+type BackupTarget interface {
+	Create(name string) (io.WriteCloser, error)
+	Open(name string) (io.ReadCloser, error)
+	String() string
+}
+
+// BackupManifest describes a backup; it is written last, so a backup
+// without one is incomplete
+type BackupManifest struct {
+	Version    int               `json:"version"`
+	Created    time.Time         `json:"created"`
+	Keys       int               `json:"keys"`
+	Shards     []BackupShard     `json:"shards"`
+	Increments []BackupIncrement `json:"increments,omitempty"` // see backup_incremental.go
+}
+
+// BackupShard is one shard's file in a backup
+type BackupShard struct {
+	Node    string `json:"node"`
+	File    string `json:"file"`
+	Keys    int    `json:"keys"`              // records in the file
+	Deleted int    `json:"deleted,omitempty"` // of which tombstones, in an increment
+	Bytes   int64  `json:"bytes"`
+	SHA256  string `json:"sha256"`
+}
+
+// backupRecord is one key in a shard file, a gob stream of these
+type backupRecord struct {
+	Key     string
+	TTL     time.Time // zero: no expiration
+	Value   []byte    // see encodeValue
+	Deleted bool      // a tombstone: the key was deleted, in an increment
+}
+
+// OpenBackupTarget resolves a location: s3://bucket/prefix for an
+// S3-compatible store configured by s3, anything else is a local directory
+func OpenBackupTarget(location string, s3 S3Config) (BackupTarget, error) {
+	if rest, ok := strings.CutPrefix(location, "s3://"); ok {
+		return newS3Target(rest, s3)
+	}
+	if location == "" {
+		return nil, errors.New("empty backup location")
+	}
+	return dirTarget(location), nil
+}
+
+// dirTarget writes backups to a local directory
+type dirTarget string
+
+func (d dirTarget) Create(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return nil, err
+	}
+	return os.Create(filepath.Join(string(d), name))
+}
+
+func (d dirTarget) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+func (d dirTarget) String() string { return string(d) }
+
+// snapshot encodes every live key. The caller makes sure no writes run on
+// this shard meanwhile; the read lock keeps background jobs out.
+func (s *Store) snapshot() ([]backupRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := make([]backupRecord, 0, s.data.Len())
+	for k, v := range s.data.All() {
+		if s.pastTTL(k) {
+			continue
+		}
+		raw, err := encodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		records = append(records, backupRecord{Key: k, TTL: s.ttl[k], Value: raw})
+	}
+	return records, nil
+}
+
+// restoreValue stores a key read from a backup, replacing any current value
+func (s *Store) restoreValue(key string, v Value, ttl time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putValue(key, v, ttl)
+	s.account(key, false) // restored, not created by a client
+}
+
+func (s *Shard) cmdFreeze(req ShardRequest) {
+	release := req.Payload.(chan struct{})
+	req.Reply <- "OK"
+	<-release
+}
+
+// freeze pauses every shard worker in the same instant, so the snapshots
+// taken before release is called form one consistent point in time. A
+// worker stuck forwarding to a paused shard would never pause, so freeze
+// gives up after backupFreezeTimeout.
+func (ss *SharedStore) freeze() (shards map[string]*Shard, release func(), err error) {
+	ss.mu.RLock()
+	shards = make(map[string]*Shard, len(ss.nodeShards))
+	for id, sh := range ss.nodeShards {
+		shards[id] = sh
+	}
+	ss.mu.RUnlock()
+
+	gate := make(chan struct{})
+	release = func() { close(gate) }
+	acks := make(chan interface{}, len(shards))
+	for _, sh := range shards {
+		sh.enqueue(ShardRequest{Command: "FREEZE", Reply: acks, Payload: gate, internal: true})
+	}
+	timeout := time.After(backupFreezeTimeout)
+	for range shards {
+		select {
+		case <-acks:
+		case <-timeout:
+			release()
+			return nil, nil, errors.New("timed out pausing shards")
+		}
+	}
+	return shards, release, nil
+}
+
+// Backup writes a consistent snapshot of every shard to t, starting a new
+// chain of incremental backups there. Shards are paused only while their
+// keys are encoded in memory, not during the upload.
+func (ss *SharedStore) Backup(t BackupTarget) (BackupManifest, error) {
+	ss.backups.mu.Lock()
+	defer ss.backups.mu.Unlock()
+
+	start := time.Now()
+	snapshots, undo, err := ss.snapshotShards(snapshotFull)
+	if err != nil {
+		return BackupManifest{}, err
+	}
+
+	m := BackupManifest{Version: backupVersion, Created: start.UTC()}
+	if m.Shards, err = writeBackupShards(t, snapshots, ""); err != nil {
+		undo()
+		return BackupManifest{}, err
+	}
+	for _, sh := range m.Shards {
+		m.Keys += sh.Keys
+	}
+	if err := writeManifest(t, m); err != nil {
+		undo()
+		return BackupManifest{}, err
+	}
+	ss.backups.location, ss.backups.created = t.String(), m.Created
+	log.Printf("Backup of %d keys written to %s in %v", m.Keys, t, time.Since(start))
+	return m, nil
+}
+
+func writeManifest(t BackupTarget, m BackupManifest) error {
+	w, err := t.Create(backupManifestName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func readManifest(t BackupTarget) (BackupManifest, error) {
+	var m BackupManifest
+	r, err := t.Open(backupManifestName)
+	if err != nil {
+		return m, err
+	}
+	defer r.Close()
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return m, err
+	}
+	if m.Version != backupVersion && m.Version != backupIncrementalVersion {
+		return m, fmt.Errorf("unsupported backup version %d", m.Version)
+	}
+	return m, nil
+}
+
+// snapshotKind is what snapshotShards encodes
+type snapshotKind int
+
+const (
+	snapshotCopy  snapshotKind = iota // every key, for a replica or an upgrade
+	snapshotFull                      // every key, starting a new backup chain
+	snapshotDelta                     // the keys changed since the last backup
+)
+
+// snapshotShards encodes every shard's keys at one point in time. For a
+// backup it also swaps out the sets of changed keys; undo puts them back if
+// the backup is not written after all.
+func (ss *SharedStore) snapshotShards(kind snapshotKind) (snapshots map[string][]backupRecord, undo func(), err error) {
+	start := time.Now()
+	shards, release, err := ss.freeze()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+	taken := make(map[*Store]map[string]struct{})
+	undo = func() {
+		for st, keys := range taken {
+			st.returnDirty(keys)
+		}
+	}
+	snapshots = make(map[string][]backupRecord, len(shards))
+	for id, sh := range shards {
+		if kind != snapshotCopy {
+			taken[sh.Store] = sh.Store.takeDirty()
+		}
+		if kind == snapshotDelta {
+			snapshots[id], err = sh.Store.delta(taken[sh.Store])
+		} else {
+			snapshots[id], err = sh.Store.snapshot()
+		}
+		if err != nil {
+			undo()
+			return nil, nil, fmt.Errorf("snapshot of %s: %w", id, err)
+		}
+	}
+	log.Printf("DEBUG: Snapshot paused %d shards for %v", len(shards), time.Since(start))
+	return snapshots, undo, nil
+}
+
+func writeBackupShard(t BackupTarget, name, node string, records []backupRecord) (BackupShard, error) {
+	sh := BackupShard{Node: node, File: name + ".bak", Keys: len(records)}
+	for _, rec := range records {
+		if rec.Deleted {
+			sh.Deleted++
+		}
+	}
+	w, err := t.Create(sh.File)
+	if err != nil {
+		return sh, err
+	}
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(w, h)}
+	enc := gob.NewEncoder(cw)
+	for i := range records {
+		if err := enc.Encode(&records[i]); err != nil {
+			w.Close()
+			return sh, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return sh, err
+	}
+	sh.Bytes, sh.SHA256 = cw.n, hex.EncodeToString(h.Sum(nil))
+	return sh, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// RestoreBackup loads a backup written by Backup, and any increments added
+// by BackupIncremental in order, routing every key to the shard that owns
+// it now, so the shard count may differ from the backup's. Each shard file
+// is verified against its checksum before any of its keys are applied. Keys
+// whose TTL passed since the backup are skipped.
+func (ss *SharedStore) RestoreBackup(t BackupTarget) (BackupManifest, error) {
+	m, err := readManifest(t)
+	if err != nil {
+		return m, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	restored := 0
+	for _, sh := range m.Shards {
+		n, err := ss.restoreBackupShard(t, sh, false)
+		if err != nil {
+			return m, fmt.Errorf("restoring %s: %w", sh.File, err)
+		}
+		restored += n
+	}
+	for _, inc := range m.Increments {
+		for _, sh := range inc.Shards {
+			n, err := ss.restoreBackupShard(t, sh, true)
+			if err != nil {
+				return m, fmt.Errorf("restoring %s: %w", sh.File, err)
+			}
+			restored += n
+		}
+	}
+	log.Printf("Restored %d of %d keys and %d increments from %s", restored, m.Keys, len(m.Increments), t)
+	return m, nil
+}
+
+func (ss *SharedStore) restoreBackupShard(t BackupTarget, sh BackupShard, replay bool) (int, error) {
+	f, err := verifiedBackupShard(t, sh)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	return ss.restoreRecords(gob.NewDecoder(f), sh.Keys, replay)
+}
+
+// verifiedBackupShard copies a shard file to a temporary file, checking it
+// against the manifest's checksum on the way, so nothing is applied from a
+// corrupt file. The caller closes and removes the file.
+func verifiedBackupShard(t BackupTarget, sh BackupShard) (*os.File, error) {
+	r, err := t.Open(sh.File)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", "restore-*.bak")
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), r)
+	r.Close()
+	if err == nil {
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != sh.SHA256 {
+			err = fmt.Errorf("checksum mismatch: manifest has %s, file has %s", sh.SHA256, sum)
+		}
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// restoreRecords applies count records from dec, routing each key to the
+// shard that owns it now and skipping keys whose TTL has passed. Replaying
+// an increment, a tombstone or a passed TTL deletes the key instead, as it
+// may hold an older value. It returns the number of keys applied.
+func (ss *SharedStore) restoreRecords(dec *gob.Decoder, count int, replay bool) (int, error) {
+	now := ss.Now()
+	n := 0
+	for i := 0; i < count; i++ {
+		var rec backupRecord
+		if err := dec.Decode(&rec); err != nil {
+			return n, err
+		}
+		st, ok := ss.shardStore(rec.Key)
+		if !ok {
+			return n, fmt.Errorf("no shard for key %s", rec.Key)
+		}
+		if rec.Deleted || !rec.TTL.IsZero() && now.After(rec.TTL) {
+			if replay {
+				st.restoreDelete(rec.Key)
+			}
+			continue
+		}
+		v, err := decodeValue(rec.Value)
+		if err != nil {
+			return n, fmt.Errorf("%s: %w", rec.Key, err)
+		}
+		st.restoreValue(rec.Key, v, rec.TTL)
+		n++
+	}
+	return n, nil
+}
+
+// Snapshot is a point-in-time copy of every key, in the encoding backups
+// use, for sending to a replica
+type Snapshot struct {
+	records []backupRecord
+}
+
+// snapshotHeader starts an encoded Snapshot
+type snapshotHeader struct {
+	Version int
+	Keys    int
+}
+
+// TakeSnapshot copies every shard's keys at one point in time, pausing the
+// shard workers only while the keys are encoded
+func (ss *SharedStore) TakeSnapshot() (*Snapshot, error) {
+	snapshots, _, err := ss.snapshotShards(snapshotCopy)
+	if err != nil {
+		return nil, err
+	}
+	sn := &Snapshot{}
+	for _, records := range snapshots {
+		sn.records = append(sn.records, records...)
+	}
+	return sn, nil
+}
+
+// Keys is the number of keys in the snapshot
+func (sn *Snapshot) Keys() int { return len(sn.records) }
+
+// WriteTo encodes the snapshot as a gob stream LoadSnapshot reads
+func (sn *Snapshot) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	enc := gob.NewEncoder(cw)
+	if err := enc.Encode(snapshotHeader{Version: backupVersion, Keys: len(sn.records)}); err != nil {
+		return cw.n, err
+	}
+	for i := range sn.records {
+		if err := enc.Encode(&sn.records[i]); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, nil
+}
+
+// LoadSnapshot replaces every key with the contents of a snapshot written
+// by Snapshot.WriteTo and returns the number of keys loaded
+func (ss *SharedStore) LoadSnapshot(r io.Reader) (int, error) {
+	dec := gob.NewDecoder(r)
+	var h snapshotHeader
+	if err := dec.Decode(&h); err != nil {
+		return 0, fmt.Errorf("reading snapshot header: %w", err)
+	}
+	if h.Version != backupVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", h.Version)
+	}
+	ss.FlushAll()
+	return ss.restoreRecords(dec, h.Keys, false)
+}
@@ -0,0 +1,316 @@
+package store
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An incremental backup adds to the backup already at a location: it writes
+// only the keys changed since the previous backup, live ones as records and
+// deleted ones as tombstones, in new shard files listed as an increment in
+// the manifest. Restores apply the full backup and then every increment in
+// order. Compaction folds the increments back into one full backup, so a
+// chain does not grow without bound. Superseded files are left in place.
+//
+// Every store tracks the keys changed since the last backup once the first
+// one is taken, so a server that never backs up pays nothing for it. The
+// sets are only correct for the backup they were last swapped out by, so an
+// incremental backup must follow the latest backup this server took, at the
+// same location.
+
+// backupIncrementalVersion is written to manifests listing increments
+const backupIncrementalVersion = 2
+
+// ErrBackupChain is returned for an incremental backup that would not
+// follow on from the latest backup at its location
+var ErrBackupChain = errors.New("the location's latest backup was not taken by this server since it started; take a full BACKUP first")
+
+// BackupIncrement is one incremental backup: the keys changed since the
+// backup before it
+type BackupIncrement struct {
+	Created time.Time     `json:"created"`
+	Keys    int           `json:"keys"`    // live keys written
+	Deleted int           `json:"deleted"` // tombstones written
+	Shards  []BackupShard `json:"shards"`
+}
+
+// latest is when the most recent snapshot in the backup was taken
+func (m BackupManifest) latest() time.Time {
+	if n := len(m.Increments); n > 0 {
+		return m.Increments[n-1].Created
+	}
+	return m.Created
+}
+
+// backupChain is the latest backup this server took. Backups hold mu
+// throughout, so two never interleave at one location.
+type backupChain struct {
+	mu       sync.Mutex
+	location string
+	created  time.Time
+}
+
+// dirtyKeys are the keys changed since the last backup. Guarded by Store.mu.
+type dirtyKeys struct {
+	on   bool // set by the first backup
+	keys map[string]struct{}
+}
+
+func (d *dirtyKeys) mark(key string) {
+	if d.on {
+		d.keys[key] = struct{}{}
+	}
+}
+
+// markAllDirty marks every key, before a flush drops them all. Callers must
+// hold s.mu for writing.
+func (s *Store) markAllDirty() {
+	if !s.dirty.on {
+		return
+	}
+	for k := range s.data.All() {
+		s.dirty.keys[k] = struct{}{}
+	}
+}
+
+// takeDirty returns the keys changed since the last backup and starts a new
+// set. The shard is paused, so the swap is the backup's point in time.
+func (s *Store) takeDirty() map[string]struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.dirty.keys
+	s.dirty.on, s.dirty.keys = true, make(map[string]struct{})
+	return keys
+}
+
+// returnDirty puts back the keys a failed backup took, so the next one
+// writes them
+func (s *Store) returnDirty(keys map[string]struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range keys {
+		s.dirty.mark(k)
+	}
+}
+
+// delta encodes the live keys among changed, and a tombstone for each of
+// the others
+func (s *Store) delta(changed map[string]struct{}) ([]backupRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := make([]backupRecord, 0, len(changed))
+	for k := range changed {
+		v, ok := s.data.Get(k)
+		if !ok || s.pastTTL(k) {
+			records = append(records, backupRecord{Key: k, Deleted: true})
+			continue
+		}
+		raw, err := encodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		records = append(records, backupRecord{Key: k, TTL: s.ttl[k], Value: raw})
+	}
+	return records, nil
+}
+
+// restoreDelete removes a key an increment records as deleted
+func (s *Store) restoreDelete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Get(key); !ok {
+		return
+	}
+	s.data.Delete(key)
+	s.clearTTL(key)
+	s.account(key, false)
+}
+
+// dedupeTombstones keeps one tombstone per deleted key and none for keys
+// live on another shard. A key that moved between shards since the last
+// backup is deleted from one and created on the other, and shard files are
+// restored one after another, so its tombstone could otherwise land last; a
+// write forwarded by a shard that does not own the key marks it there too.
+func dedupeTombstones(snapshots map[string][]backupRecord) {
+	seen := make(map[string]bool) // key: live on some shard
+	for _, records := range snapshots {
+		for _, rec := range records {
+			seen[rec.Key] = seen[rec.Key] || !rec.Deleted
+		}
+	}
+	nodes := make([]string, 0, len(snapshots))
+	for id := range snapshots {
+		nodes = append(nodes, id)
+	}
+	sort.Strings(nodes)
+	for _, id := range nodes {
+		kept := snapshots[id][:0]
+		for _, rec := range snapshots[id] {
+			if rec.Deleted {
+				live, pending := seen[rec.Key]
+				if live || !pending {
+					continue
+				}
+				delete(seen, rec.Key) // written once, here
+			}
+			kept = append(kept, rec)
+		}
+		snapshots[id] = kept
+	}
+}
+
+// BackupIncremental adds the keys changed since the latest backup at t, as
+// an increment in its manifest. It returns ErrBackupChain unless that
+// backup is the latest one this server took.
+func (ss *SharedStore) BackupIncremental(t BackupTarget) (BackupManifest, error) {
+	ss.backups.mu.Lock()
+	defer ss.backups.mu.Unlock()
+
+	m, err := readManifest(t)
+	if err != nil {
+		return m, fmt.Errorf("reading manifest: %w", err)
+	}
+	if ss.backups.location != t.String() || !m.latest().Equal(ss.backups.created) {
+		return m, ErrBackupChain
+	}
+
+	start := time.Now()
+	snapshots, undo, err := ss.snapshotShards(snapshotDelta)
+	if err != nil {
+		return m, err
+	}
+	dedupeTombstones(snapshots)
+
+	inc := BackupIncrement{Created: start.UTC()}
+	shards, err := writeBackupShards(t, snapshots, fmt.Sprintf(".inc-%d", len(m.Increments)+1))
+	if err != nil {
+		undo()
+		return m, err
+	}
+	inc.Shards = shards
+	for _, sh := range shards {
+		inc.Keys += sh.Keys - sh.Deleted
+		inc.Deleted += sh.Deleted
+	}
+	m.Version = backupIncrementalVersion
+	m.Increments = append(m.Increments, inc)
+	if err := writeManifest(t, m); err != nil {
+		undo()
+		return m, err
+	}
+	ss.backups.created = inc.Created
+	log.Printf("Incremental backup of %d changed and %d deleted keys written to %s in %v",
+		inc.Keys, inc.Deleted, t, time.Since(start))
+	return m, nil
+}
+
+// CompactBackup folds the increments at t into a new full backup at the
+// same location, keeping each key's latest record. The result is a backup
+// of the same point in time, so incremental backups may still follow it.
+// The whole backup is read into memory to merge it.
+func (ss *SharedStore) CompactBackup(t BackupTarget) (BackupManifest, error) {
+	ss.backups.mu.Lock()
+	defer ss.backups.mu.Unlock()
+
+	m, err := readManifest(t)
+	if err != nil {
+		return m, fmt.Errorf("reading manifest: %w", err)
+	}
+	if len(m.Increments) == 0 {
+		return m, nil
+	}
+
+	start := time.Now()
+	type entry struct {
+		node string
+		rec  backupRecord
+	}
+	merged := make(map[string]entry, m.Keys)
+	apply := func(sh BackupShard) error {
+		return readBackupShard(t, sh, func(rec backupRecord) {
+			if rec.Deleted {
+				delete(merged, rec.Key)
+			} else {
+				merged[rec.Key] = entry{sh.Node, rec}
+			}
+		})
+	}
+	for _, sh := range m.Shards {
+		if err := apply(sh); err != nil {
+			return m, fmt.Errorf("reading %s: %w", sh.File, err)
+		}
+	}
+	for _, inc := range m.Increments {
+		for _, sh := range inc.Shards {
+			if err := apply(sh); err != nil {
+				return m, fmt.Errorf("reading %s: %w", sh.File, err)
+			}
+		}
+	}
+
+	now := ss.Now()
+	snapshots := make(map[string][]backupRecord)
+	for _, e := range merged {
+		if !e.rec.TTL.IsZero() && now.After(e.rec.TTL) {
+			continue
+		}
+		snapshots[e.node] = append(snapshots[e.node], e.rec)
+	}
+	compacted := BackupManifest{Version: backupVersion, Created: m.latest()}
+	compacted.Shards, err = writeBackupShards(t, snapshots, fmt.Sprintf(".full-%d", start.UnixNano()))
+	if err != nil {
+		return m, err
+	}
+	for _, sh := range compacted.Shards {
+		compacted.Keys += sh.Keys
+	}
+	if err := writeManifest(t, compacted); err != nil {
+		return m, err
+	}
+	log.Printf("Compacted %d increments at %s into %d keys in %v", len(m.Increments), t, compacted.Keys, time.Since(start))
+	return compacted, nil
+}
+
+// readBackupShard verifies a shard file and passes each of its records to fn
+func readBackupShard(t BackupTarget, sh BackupShard, fn func(backupRecord)) error {
+	f, err := verifiedBackupShard(t, sh)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	dec := gob.NewDecoder(f)
+	for i := 0; i < sh.Keys; i++ {
+		var rec backupRecord
+		if err := dec.Decode(&rec); err != nil {
+			return err
+		}
+		fn(rec)
+	}
+	return nil
+}
+
+// writeBackupShards writes every shard's records to a file named after its
+// node and suffix, in node order
+func writeBackupShards(t BackupTarget, snapshots map[string][]backupRecord, suffix string) ([]BackupShard, error) {
+	nodes := make([]string, 0, len(snapshots))
+	for id := range snapshots {
+		nodes = append(nodes, id)
+	}
+	sort.Strings(nodes)
+	shards := make([]BackupShard, 0, len(nodes))
+	for _, id := range nodes {
+		sh, err := writeBackupShard(t, id+suffix, id, snapshots[id])
+		if err != nil {
+			return nil, fmt.Errorf("writing %s: %w", id, err)
+		}
+		shards = append(shards, sh)
+	}
+	return shards, nil
+}
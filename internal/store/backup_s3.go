@@ -0,0 +1,222 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3Config addresses an S3-compatible object store. Requests use path-style
+// URLs (endpoint/bucket/key), which AWS, MinIO and Ceph all accept.
+type S3Config struct {
+	Endpoint     string // e.g. http://localhost:9000; empty means AWS for Region
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+}
+
+// S3ConfigFromEnv fills the credentials from the standard AWS_* variables
+func S3ConfigFromEnv(endpoint, region string) S3Config {
+	return S3Config{
+		Endpoint:     endpoint,
+		Region:       region,
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+type s3Target struct {
+	cfg    S3Config
+	bucket string
+	prefix string
+	client *http.Client
+}
+
+// newS3Target parses "bucket/prefix", the part of an s3:// URL after the scheme
+func newS3Target(path string, cfg S3Config) (*s3Target, error) {
+	bucket, prefix, _ := strings.Cut(path, "/")
+	if bucket == "" {
+		return nil, errors.New("s3 location needs a bucket: s3://bucket/prefix")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("s3 credentials missing: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	return &s3Target{
+		cfg:    cfg,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+		client: &http.Client{Timeout: 10 * time.Minute},
+	}, nil
+}
+
+func (t *s3Target) String() string {
+	return "s3://" + t.bucket + "/" + t.prefix
+}
+
+func (t *s3Target) objectPath(name string) string {
+	key := name
+	if t.prefix != "" {
+		key = t.prefix + "/" + name
+	}
+	return "/" + t.bucket + "/" + key
+}
+
+// Create buffers the object in a temporary file and uploads it on Close,
+// since a signed PUT needs the payload's length and hash up front
+func (t *s3Target) Create(name string) (io.WriteCloser, error) {
+	f, err := os.CreateTemp("", "backup-*.part")
+	if err != nil {
+		return nil, err
+	}
+	return &s3Upload{t: t, path: t.objectPath(name), f: f, h: sha256.New()}, nil
+}
+
+type s3Upload struct {
+	t    *s3Target
+	path string
+	f    *os.File
+	h    hash.Hash
+	n    int64
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	n, err := u.f.Write(p)
+	u.h.Write(p[:n])
+	u.n += int64(n)
+	return n, err
+}
+
+func (u *s3Upload) Close() error {
+	defer os.Remove(u.f.Name())
+	defer u.f.Close()
+	if _, err := u.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	resp, err := u.t.do(http.MethodPut, u.path, u.f, u.n, hex.EncodeToString(u.h.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t *s3Target) Open(name string) (io.ReadCloser, error) {
+	resp, err := t.do(http.MethodGet, t.objectPath(name), nil, 0, emptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// do sends a request signed with AWS Signature Version 4 and turns non-2xx
+// replies into errors
+func (t *s3Target) do(method, path string, body io.Reader, length int64, payloadHash string) (*http.Response, error) {
+	u, err := url.Parse(t.cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	u.Path, u.RawPath = path, awsEscapePath(path)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = length
+	}
+	t.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return resp, nil
+}
+
+func (t *s3Target) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if t.cfg.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", t.cfg.SessionToken)
+		headers = append(headers, "x-amz-security-token")
+	}
+
+	var canonHeaders strings.Builder
+	for _, h := range headers {
+		v := req.Header.Get(h)
+		if h == "host" {
+			v = req.URL.Host
+		}
+		canonHeaders.WriteString(h + ":" + strings.TrimSpace(v) + "\n")
+	}
+	signed := strings.Join(headers, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscapePath(req.URL.Path),
+		"", // no query string
+		canonHeaders.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + t.cfg.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + t.cfg.SecretKey)
+	for _, part := range []string{day, t.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.cfg.AccessKey, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+// awsEscapePath percent-encodes everything but unreserved characters and
+// the slashes between segments, as SigV4 requires
+func awsEscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,391 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Bitmaps are strings read as arrays of bits, bit 0 being the most
+// significant bit of the first byte, as in Redis. Like SETRANGE, writes
+// build a new slice rather than changing the old one in place, since
+// readers may still be sending the old one.
+
+// MaxBitOffset is one past the largest offset SETBIT accepts, the bits of
+// a 512MB string
+const MaxBitOffset = 8 * maxStringSize
+
+// ErrBitOp is a BITOP operation other than AND, OR, XOR and NOT
+var ErrBitOp = errors.New("ERR syntax error")
+
+// ErrBitOpNot is a BITOP NOT given more than one source key
+var ErrBitOpNot = errors.New("ERR BITOP NOT must be called with a single source key.")
+
+// BitRange is the optional start and end of BITCOUNT and BITPOS, counted
+// in bytes or, with Bits, in bits. Negative positions count from the end.
+type BitRange struct {
+	Start, End int
+	HasStart   bool
+	HasEnd     bool
+	Bits       bool
+}
+
+// SETBIT key offset value, returns the bit's previous value
+func (s *Store) SetBit(key string, offset int, bit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired(key) {
+		s.data.Delete(key)
+	}
+
+	val, ok := s.data.Get(key)
+	if ok && val.Type != StringType {
+		return 0, ErrWrongType
+	}
+	i := offset >> 3
+	size := max(len(val.Data), i+1)
+	if err := s.checkValueSize(size); err != nil {
+		return 0, err
+	}
+	if !ok {
+		val = Value{Type: StringType}
+	}
+
+	data := make([]byte, size)
+	copy(data, val.Data)
+	mask := byte(0x80 >> (offset & 7))
+	old := 0
+	if data[i]&mask != 0 {
+		old = 1
+	}
+	if bit == 1 {
+		data[i] |= mask
+	} else {
+		data[i] &^= mask
+	}
+	val.Data = data
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventString, "setbit", key)
+	return old, nil
+}
+
+// GETBIT key offset, bits past the end of the string are 0
+func (s *Store) GetBit(key string, offset int) (int, error) {
+	data, err := s.bitmap(key)
+	if err != nil || offset>>3 >= len(data) {
+		return 0, err
+	}
+	if data[offset>>3]&(0x80>>(offset&7)) != 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func (s *Store) BitCount(key string, r BitRange) (int, error) {
+	data, err := s.bitmap(key)
+	if err != nil {
+		return 0, err
+	}
+	first, last, ok := r.bits(len(data))
+	if !ok {
+		return 0, nil
+	}
+	fb, lb := first>>3, last>>3
+	n := popcount(data[fb : lb+1])
+	// take off the bits of the edge bytes outside the range
+	n -= bits.OnesCount8(data[fb] >> (8 - first&7))
+	n -= bits.OnesCount8(data[lb] & (0xff >> (last&7 + 1)))
+	return n, nil
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]], the first bit set to bit in the
+// range, or -1. Looking for 0 without an end, the string counts as padded
+// with zeros, so the bit after its end is the answer if nothing earlier is.
+func (s *Store) BitPos(key string, bit int, r BitRange) (int, error) {
+	data, err := s.bitmap(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) == 0 {
+		if bit == 1 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+	first, last, ok := r.bits(len(data))
+	if !ok {
+		return -1, nil
+	}
+	skip := byte(0x00) // a byte with no bit worth looking at
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i := first; i <= last; {
+		if i&7 == 0 && i+7 <= last && data[i>>3] == skip {
+			i += 8
+			continue
+		}
+		if int(data[i>>3]>>(7-i&7))&1 == bit {
+			return i, nil
+		}
+		i++
+	}
+	if bit == 0 && !r.HasEnd {
+		return last + 1, nil
+	}
+	return -1, nil
+}
+
+// bits turns r into the first and last bit it covers in a string of n
+// bytes, reporting false if it covers none
+func (r BitRange) bits(n int) (first, last int, ok bool) {
+	total := n
+	if r.Bits {
+		total = 8 * n
+	}
+	start, end := 0, total-1
+	if r.HasStart {
+		start = r.Start
+	}
+	if r.HasEnd {
+		end = r.End
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = total + end
+	}
+	end = min(end, total-1)
+	if start > end || end < 0 {
+		return 0, 0, false
+	}
+	if r.Bits {
+		return start, end, true
+	}
+	return 8 * start, 8*end + 7, true
+}
+
+// bitmap returns key's string, nil if it does not exist
+func (s *Store) bitmap(key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return nil, nil
+	}
+	val, ok := s.lookupRead(key)
+	if !ok {
+		return nil, nil
+	}
+	if val.Type != StringType {
+		return nil, ErrWrongType
+	}
+	s.touch(key)
+	return val.Data, nil
+}
+
+// setBitOpResult stores the result of a BITOP under key, deleting key if
+// the result is empty as every source was
+func (s *Store) setBitOpResult(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expired(key)
+	if len(data) == 0 {
+		if _, ok := s.data.Get(key); ok {
+			s.data.Delete(key)
+			s.clearTTL(key)
+			s.accountKey(key)
+			s.notify(EventGeneric, "del", key)
+		}
+		return nil
+	}
+	if err := s.checkValueSize(len(data)); err != nil {
+		return err
+	}
+	s.data.Put(key, Value{Type: StringType, Data: data, LastAccess: s.now().UnixNano()})
+	s.clearTTL(key)
+	s.account(key, true)
+	s.notify(EventString, "set", key)
+	return nil
+}
+
+// BitOp stores the bitwise AND, OR, XOR or NOT of the source keys in dest
+// and returns its length. Shorter and missing sources count as padded with
+// zero bytes. The sources are read one shard at a time, so the result is
+// not a snapshot of all of them if they are written meanwhile.
+func (ss *SharedStore) BitOp(op, dest string, srcs []string) (int, error) {
+	op = strings.ToUpper(op)
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(srcs) != 1 {
+			return 0, ErrBitOpNot
+		}
+	default:
+		return 0, ErrBitOp
+	}
+	inputs := make([][]byte, len(srcs))
+	size := 0
+	for i, k := range srcs {
+		st, ok := ss.shardStore(k)
+		if !ok {
+			return 0, ErrNoSuchKey
+		}
+		data, err := st.bitmap(k)
+		if err != nil {
+			return 0, err
+		}
+		inputs[i] = data
+		size = max(size, len(data))
+	}
+
+	out := make([]byte, size)
+	switch op {
+	case "NOT":
+		for i, b := range inputs[0] {
+			out[i] = ^b
+		}
+	case "AND":
+		// anything past the shortest source is ANDed with zeros
+		short := size
+		for _, in := range inputs {
+			short = min(short, len(in))
+		}
+		copy(out, inputs[0][:short])
+		for _, in := range inputs[1:] {
+			for i := range short {
+				out[i] &= in[i]
+			}
+		}
+	default:
+		for _, in := range inputs {
+			for i, b := range in {
+				if op == "OR" {
+					out[i] |= b
+				} else {
+					out[i] ^= b
+				}
+			}
+		}
+	}
+
+	to, ok := ss.shardStore(dest)
+	if !ok {
+		return 0, ErrNoSuchKey
+	}
+	ss.dropHotKeys(dest)
+	if err := to.setBitOpResult(dest, out); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// popcount counts the set bits of b, eight bytes at a time
+func popcount(b []byte) int {
+	n := 0
+	for len(b) >= 8 {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(b))
+		b = b[8:]
+	}
+	for _, c := range b {
+		n += bits.OnesCount8(c)
+	}
+	return n
+}
+
+func (s *Shard) cmdSetBit(req ShardRequest) {
+	if len(req.Args) < 2 {
+		req.Reply <- fmt.Errorf("SETBIT requires offset and value")
+		return
+	}
+	offset, _ := strconv.Atoi(req.Args[0])
+	bit, _ := strconv.Atoi(req.Args[1])
+	old, err := s.Store.SetBit(req.Key, offset, bit)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- old
+}
+
+func (s *Shard) cmdGetBit(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- fmt.Errorf("GETBIT requires an offset")
+		return
+	}
+	offset, _ := strconv.Atoi(req.Args[0])
+	bit, err := s.Store.GetBit(req.Key, offset)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- bit
+}
+
+// cmdBitCount takes the BitRange as start, end and unit arguments, each
+// empty when not given
+func (s *Shard) cmdBitCount(req ShardRequest) {
+	if len(req.Args) < 3 {
+		req.Reply <- fmt.Errorf("BITCOUNT requires start, end and unit")
+		return
+	}
+	n, err := s.Store.BitCount(req.Key, parseBitRange(req.Args))
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
+
+// cmdBitPos takes the bit, then the BitRange as for cmdBitCount
+func (s *Shard) cmdBitPos(req ShardRequest) {
+	if len(req.Args) < 4 {
+		req.Reply <- fmt.Errorf("BITPOS requires bit, start, end and unit")
+		return
+	}
+	bit, _ := strconv.Atoi(req.Args[0])
+	pos, err := s.Store.BitPos(req.Key, bit, parseBitRange(req.Args[1:]))
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- pos
+}
+
+func parseBitRange(args []string) BitRange {
+	var r BitRange
+	if args[0] != "" {
+		r.Start, _ = strconv.Atoi(args[0])
+		r.HasStart = true
+	}
+	if args[1] != "" {
+		r.End, _ = strconv.Atoi(args[1])
+		r.HasEnd = true
+	}
+	r.Bits = args[2] == "BIT"
+	return r
+}
+
+// Args renders r as the start, end and unit arguments of BITCOUNT and
+// BITPOS shard requests
+func (r BitRange) Args() []string {
+	args := []string{"", "", "BYTE"}
+	if r.HasStart {
+		args[0] = strconv.Itoa(r.Start)
+	}
+	if r.HasEnd {
+		args[1] = strconv.Itoa(r.End)
+	}
+	if r.Bits {
+		args[2] = "BIT"
+	}
+	return args
+}
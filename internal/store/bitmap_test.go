@@ -0,0 +1,121 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBitmapBits(t *testing.T) {
+	s := NewStore()
+
+	// bit 0 is the high bit of the first byte, and the string grows to fit
+	if old, err := s.SetBit("b", 1, 1); old != 0 || err != nil {
+		t.Fatalf("SetBit(1) = %d, %v", old, err)
+	}
+	s.SetBit("b", 7, 1)
+	s.SetBit("b", 17, 1)
+	if v, _ := s.Get("b"); !bytes.Equal(v, []byte{0x41, 0x00, 0x40}) {
+		t.Fatalf("bitmap = %x, want 410040", v)
+	}
+	if old, _ := s.SetBit("b", 7, 0); old != 1 {
+		t.Errorf("clearing bit 7 returned %d, want its old value 1", old)
+	}
+	for offset, want := range map[int]int{0: 0, 1: 1, 7: 0, 17: 1, 1000: 0} {
+		if got, _ := s.GetBit("b", offset); got != want {
+			t.Errorf("GetBit(%d) = %d, want %d", offset, got, want)
+		}
+	}
+
+	// b is now 40 00 40
+	counts := []struct {
+		r    BitRange
+		want int
+	}{
+		{BitRange{}, 2},
+		{BitRange{Start: 1, End: 2, HasStart: true, HasEnd: true}, 1},
+		{BitRange{Start: -1, End: -1, HasStart: true, HasEnd: true}, 1},
+		{BitRange{Start: 2, End: 16, HasStart: true, HasEnd: true, Bits: true}, 0},
+		{BitRange{Start: 1, End: 17, HasStart: true, HasEnd: true, Bits: true}, 2},
+		{BitRange{Start: 2, End: 1, HasStart: true, HasEnd: true}, 0},
+	}
+	for _, tt := range counts {
+		if got, _ := s.BitCount("b", tt.r); got != tt.want {
+			t.Errorf("BitCount(%+v) = %d, want %d", tt.r, got, tt.want)
+		}
+	}
+
+	positions := []struct {
+		bit  int
+		r    BitRange
+		want int
+	}{
+		{1, BitRange{}, 1},
+		{1, BitRange{Start: 1, HasStart: true}, 17},
+		{0, BitRange{}, 0},
+		{1, BitRange{Start: 2, End: 16, HasStart: true, HasEnd: true, Bits: true}, -1},
+	}
+	for _, tt := range positions {
+		if got, _ := s.BitPos("b", tt.bit, tt.r); got != tt.want {
+			t.Errorf("BitPos(%d, %+v) = %d, want %d", tt.bit, tt.r, got, tt.want)
+		}
+	}
+
+	// looking for a 0 in all-ones runs past the end unless an end is given
+	s.Set("ones", []byte{0xff, 0xff}, 0)
+	if got, _ := s.BitPos("ones", 0, BitRange{}); got != 16 {
+		t.Errorf("BitPos(ones, 0) = %d, want 16", got)
+	}
+	if got, _ := s.BitPos("ones", 0, BitRange{Start: 0, End: 1, HasStart: true, HasEnd: true}); got != -1 {
+		t.Errorf("BitPos(ones, 0, 0, 1) = %d, want -1", got)
+	}
+	if got, _ := s.BitPos("missing", 1, BitRange{}); got != -1 {
+		t.Errorf("BitPos(missing, 1) = %d, want -1", got)
+	}
+
+	s.SAdd("set", "m")
+	if _, err := s.SetBit("set", 0, 1); err != ErrWrongType {
+		t.Errorf("SetBit on a set = %v, want ErrWrongType", err)
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	ss := newTestSharedStore(t)
+	ss.Set("a", []byte{0xf0, 0x0f}, 0)
+	ss.Set("b", []byte{0x3c}, 0)
+
+	tests := []struct {
+		op   string
+		srcs []string
+		want []byte
+	}{
+		{"AND", []string{"a", "b"}, []byte{0x30, 0x00}},
+		{"or", []string{"a", "b"}, []byte{0xfc, 0x0f}},
+		{"XOR", []string{"a", "b", "missing"}, []byte{0xcc, 0x0f}},
+		{"NOT", []string{"b"}, []byte{0xc3}},
+	}
+	for _, tt := range tests {
+		n, err := ss.BitOp(tt.op, "dest", tt.srcs)
+		if err != nil || n != len(tt.want) {
+			t.Errorf("BitOp(%s) = %d, %v", tt.op, n, err)
+			continue
+		}
+		if got, _ := ss.Get("dest"); !bytes.Equal(got, tt.want) {
+			t.Errorf("BitOp(%s) stored %x, want %x", tt.op, got, tt.want)
+		}
+	}
+
+	if _, err := ss.BitOp("NOT", "dest", []string{"a", "b"}); err != ErrBitOpNot {
+		t.Errorf("BITOP NOT with two keys = %v", err)
+	}
+	if _, err := ss.BitOp("NAND", "dest", []string{"a"}); err != ErrBitOp {
+		t.Errorf("BITOP NAND = %v", err)
+	}
+
+	// an empty result deletes the destination
+	if n, _ := ss.BitOp("AND", "dest", []string{"missing"}); n != 0 {
+		t.Errorf("BITOP of a missing key = %d", n)
+	}
+	if _, ok := ss.Get("dest"); ok {
+		t.Error("an empty BITOP result left the destination")
+	}
+}
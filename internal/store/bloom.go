@@ -0,0 +1,242 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"multithreaded-redis/internal/datastuctures"
+)
+
+// BloomDefaults shape the filters BFADD and BF.MADD create for missing
+// keys; BF.RESERVE picks its own
+type BloomDefaults struct {
+	ErrorRate float64
+	Capacity  int
+	Expansion int // 0: filters do not scale
+}
+
+// DefaultBloom are the defaults of a store nobody configured, those of
+// RedisBloom
+var DefaultBloom = BloomDefaults{ErrorRate: 0.01, Capacity: 100, Expansion: 2}
+
+var (
+	ErrBloomExists    = errors.New("ERR item exists")
+	ErrBloomErrorRate = errors.New("ERR (0 < error rate range < 1)")
+	ErrBloomCapacity  = errors.New("ERR (capacity should be larger than 0)")
+	ErrBloomExpansion = errors.New("ERR expansion should be greater or equal to 1")
+)
+
+// BloomInfo describes a filter for BF.INFO
+type BloomInfo struct {
+	Capacity  uint64
+	Size      int // bytes
+	Filters   int
+	Items     uint64
+	Expansion uint
+}
+
+// SetBloomDefaults sets the shape of filters created by adding to a
+// missing key
+func (s *Store) SetBloomDefaults(d BloomDefaults) {
+	s.bloom.Store(&d)
+}
+
+// SetBloomDefaults applies d on every shard, including shards added later
+func (ss *SharedStore) SetBloomDefaults(d BloomDefaults) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.bloom = d
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetBloomDefaults(d)
+	}
+}
+
+// CheckBloom validates the error rate and capacity of a filter
+func CheckBloom(errorRate float64, capacity int64) error {
+	if !(errorRate > 0 && errorRate < 1) {
+		return ErrBloomErrorRate
+	}
+	if capacity <= 0 {
+		return ErrBloomCapacity
+	}
+	return nil
+}
+
+// newBloom makes a filter, within the value size limit. Callers hold s.mu.
+func (s *Store) newBloom(errorRate float64, capacity uint64, expansion uint) (*datastuctures.BloomFilter, error) {
+	if err := s.checkValueSize(int(min(datastuctures.BloomBytes(errorRate, capacity), maxStringSize+1))); err != nil {
+		return nil, err
+	}
+	return datastuctures.NewBloomFilter(errorRate, capacity, expansion), nil
+}
+
+// BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING]
+func (s *Store) BFReserve(key string, errorRate float64, capacity uint64, expansion uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expired(key)
+	if _, ok := s.data.Get(key); ok {
+		return ErrBloomExists
+	}
+	bf, err := s.newBloom(errorRate, capacity, expansion)
+	if err != nil {
+		return err
+	}
+	s.data.Put(key, Value{Type: BFType, BF: bf, LastAccess: s.now().UnixNano()})
+	s.notify(EventModule, "bf.reserve", key)
+	return nil
+}
+
+// BFADD key item, BF.MADD key item [item ...]: whether each item was added
+// rather than possibly present already. A missing key gets a filter of the
+// store's defaults. Items after one a full non-scaling filter refuses are
+// not added.
+func (s *Store) BFAdd(key string, items ...string) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expired(key)
+	val, ok := s.data.Get(key)
+	if ok && val.Type != BFType {
+		return nil, ErrWrongType
+	}
+	if !ok {
+		d := DefaultBloom
+		if p := s.bloom.Load(); p != nil {
+			d = *p
+		}
+		bf, err := s.newBloom(d.ErrorRate, uint64(max(d.Capacity, 1)), uint(d.Expansion))
+		if err != nil {
+			return nil, err
+		}
+		val = Value{Type: BFType, BF: bf}
+	}
+
+	added := make([]bool, 0, len(items))
+	var err error
+	for _, item := range items {
+		if grow := val.BF.NextLayerBytes(); grow > 0 {
+			if err = s.checkValueSize(val.BF.SizeBytes() + int(min(grow, maxStringSize+1))); err != nil {
+				break
+			}
+		}
+		var ok bool
+		if ok, err = val.BF.Add(item); err != nil {
+			break
+		}
+		added = append(added, ok)
+	}
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventModule, "bf.add", key)
+	return added, err
+}
+
+// BFEXISTS key item, BF.MEXISTS key item [item ...]
+func (s *Store) BFExists(key string, items ...string) ([]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]bool, len(items))
+	if s.expiredRead(key) {
+		return out, nil
+	}
+	val, ok := s.lookupRead(key)
+	if !ok {
+		return out, nil
+	}
+	if val.Type != BFType {
+		return nil, ErrWrongType
+	}
+	s.touch(key)
+	for i, item := range items {
+		out[i] = val.BF.Exists(item)
+	}
+	return out, nil
+}
+
+// BF.INFO key
+func (s *Store) BFInfo(key string) (BloomInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return BloomInfo{}, ErrNoSuchKey
+	}
+	val, ok := s.lookupRead(key)
+	if !ok {
+		return BloomInfo{}, ErrNoSuchKey
+	}
+	if val.Type != BFType {
+		return BloomInfo{}, ErrWrongType
+	}
+	s.touch(key)
+	bf := val.BF
+	return BloomInfo{
+		Capacity:  bf.Capacity(),
+		Size:      bf.SizeBytes(),
+		Filters:   bf.Layers(),
+		Items:     bf.Count(),
+		Expansion: bf.Expansion(),
+	}, nil
+}
+
+// cmdBFReserve takes error_rate, capacity and expansion, 0 for NONSCALING
+func (s *Shard) cmdBFReserve(req ShardRequest) {
+	if len(req.Args) < 3 {
+		req.Reply <- fmt.Errorf("BF.RESERVE requires error rate, capacity and expansion")
+		return
+	}
+	rate, _ := strconv.ParseFloat(req.Args[0], 64)
+	capacity, _ := strconv.ParseUint(req.Args[1], 10, 64)
+	expansion, _ := strconv.ParseUint(req.Args[2], 10, 32)
+	if err := s.Store.BFReserve(req.Key, rate, capacity, uint(expansion)); err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- true
+}
+
+func (s *Shard) cmdBFAdd(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- fmt.Errorf("BFADD requires an item")
+		return
+	}
+	added, err := s.Store.BFAdd(req.Key, req.Args...)
+	if err != nil && len(added) == 0 {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- BloomResult{Found: added, Err: err}
+}
+
+func (s *Shard) cmdBFExists(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- fmt.Errorf("BFEXISTS requires an item")
+		return
+	}
+	found, err := s.Store.BFExists(req.Key, req.Args...)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- BloomResult{Found: found}
+}
+
+func (s *Shard) cmdBFInfo(req ShardRequest) {
+	info, err := s.Store.BFInfo(req.Key)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- info
+}
+
+// BloomResult is the reply of BFADD and BFEXISTS shard requests: one entry
+// per item, and for adds, the error that stopped them early
+type BloomResult struct {
+	Found []bool
+	Err   error
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Broadcast runs a command on every shard's worker concurrently, bypassing
+// ring routing, and returns each shard's reply keyed by node ID. Requests go
+// through the shard queues, so each runs in order with that shard's other
+// commands.
+func (ss *SharedStore) Broadcast(cmd, key string, args ...string) map[string]interface{} {
+	ss.mu.RLock()
+	pending := make(map[string]chan interface{}, len(ss.nodeShards))
+	for id, sh := range ss.nodeShards {
+		req := ShardRequest{
+			Command:  cmd,
+			Key:      key,
+			Args:     args,
+			Reply:    make(chan interface{}, 1),
+			internal: true,
+		}
+		sh.enqueue(req)
+		pending[id] = req.Reply
+	}
+	ss.mu.RUnlock()
+
+	replies := make(map[string]interface{}, len(pending))
+	for id, r := range pending {
+		replies[id] = <-r
+	}
+	return replies
+}
+
+// BroadcastCommand is Broadcast for client-supplied commands; migration and
+// replication plumbing is refused
+func (ss *SharedStore) BroadcastCommand(cmd, key string, args ...string) (map[string]interface{}, error) {
+	sc, ok := lookupShardCommand(cmd)
+	if !ok || sc.flags&shardInternal != 0 {
+		return nil, fmt.Errorf("ERR unknown or internal command '%s'", strings.ToLower(cmd))
+	}
+	return ss.Broadcast(cmd, key, args...), nil
+}
+
+// Flush removes every key from the store
+func (s *Store) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.markAllDirty()
+	s.data.Clear()
+	s.ttl = make(map[string]time.Time)
+	s.ttlKeys = ttlIndex{}
+	s.types = typeIndex{}
+	s.dbKeys = nil
+	s.memory.sizes = nil
+	s.memory.used.Store(0)
+	if s.slotIndex.slotOf != nil {
+		s.slotIndex.slots = make(map[int]map[string]struct{})
+	}
+	s.lazy.mu.Lock()
+	s.lazy.touched = make(map[string]int64)
+	s.lazy.mu.Unlock()
+}
+
+// DBSize returns the number of keys, including expired ones not yet reaped
+func (s *Store) DBSize() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.data.Len()
+}
+
+// FlushAll empties every shard and returns how many shards were flushed
+func (ss *SharedStore) FlushAll() int {
+	ss.dropAllHotKeys()
+	// a replica installed while the flush was in flight must not survive it
+	defer ss.dropAllHotKeys()
+	return len(ss.Broadcast("FLUSH", ""))
+}
+
+// DBSize sums the key counts of every shard
+func (ss *SharedStore) DBSize() int {
+	total := 0
+	for _, r := range ss.Broadcast("DBSIZE", "") {
+		if n, ok := r.(int); ok {
+			total += n
+		}
+	}
+	return total
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source of TTLs, expiry, access times and TIME. A store
+// reads it rather than time.Now, so a test can move time instead of
+// sleeping through it.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the clock stores use until told otherwise
+var SystemClock Clock = newMonotonicClock()
+
+// monotonicClock reads the wall clock once and from then on advances by the
+// monotonic clock, so TTLs neither expire early nor linger when the system
+// clock is stepped. Absolute expirations (EXPIREAT, SET EXAT) are measured
+// against it, so they drift from the wall clock by any step taken since
+// the server started.
+type monotonicClock struct {
+	base time.Time
+}
+
+func newMonotonicClock() *monotonicClock {
+	return &monotonicClock{base: time.Now()}
+}
+
+func (c *monotonicClock) Now() time.Time {
+	return c.base.Add(time.Since(c.base))
+}
+
+// ManualClock stands still until set or advanced
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock returns a clock stopped at t
+func NewManualClock(t time.Time) *ManualClock {
+	return &ManualClock{now: t}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to t, forward or back
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
+
+// Advance moves the clock forward by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// SetClock makes the store read time from c
+func (s *Store) SetClock(c Clock) {
+	s.clock.Store(&c)
+}
+
+// now is the store's current time: the time pinned for the request the
+// worker is running, if any, else the clock's
+func (s *Store) now() time.Time {
+	if d := s.pinned.Load(); d != 0 {
+		return s.pinBase.Add(time.Duration(d))
+	}
+	return s.clockNow()
+}
+
+func (s *Store) clockNow() time.Time {
+	if c := s.clock.Load(); c != nil {
+		return (*c).Now()
+	}
+	return SystemClock.Now()
+}
+
+// pinClock reads the clock once for the request the worker is about to
+// run, which sees that time throughout: a command checking many TTLs pays
+// for one clock read rather than one per key, and judges them all at the
+// same instant. Reads served off the worker meanwhile see it too, so they
+// lag the clock by at most the running command's duration. The time is
+// kept as an offset from pinBase, so pinning does not allocate.
+func (s *Store) pinClock() {
+	d := s.clockNow().Sub(s.pinBase)
+	if d == 0 {
+		d = 1 // 0 means not pinned
+	}
+	s.pinned.Store(int64(d))
+}
+
+// unpinClock goes back to reading the clock on every call
+func (s *Store) unpinClock() {
+	s.pinned.Store(0)
+}
+
+// SetClock makes every shard, including shards added later, read time
+// from c
+func (ss *SharedStore) SetClock(c Clock) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.clock = c
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetClock(c)
+	}
+}
+
+// Now is the time the stores measure TTLs against, for TIME and for TTLs
+// computed outside them
+func (ss *SharedStore) Now() time.Time {
+	ss.mu.RLock()
+	c := ss.clock
+	ss.mu.RUnlock()
+	if c == nil {
+		return SystemClock.Now()
+	}
+	return c.Now()
+}
@@ -5,21 +5,27 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
 type HashRing struct {
 	mutex    sync.RWMutex
-	replicas int               // virtual nodes per real node
+	replicas int               // virtual nodes a real node joins with
 	keys     []uint32          // sorted hashes of virtual nodes
 	vnodeMap map[uint32]string // maps virtual node hash to real node
-	nodes    map[string]struct{}
+	nodes    map[string]int    // virtual nodes of each real node
+
+	// routing overrides, see keygroup.go
+	groups  map[string]string              // group of each grouped key
+	members map[string]map[string]struct{} // keys of each group
+	grouped atomic.Int64                   // len(groups), read without the lock
 }
 
 func NewHashRing(replicas int) *HashRing {
 	hr := &HashRing{
 		replicas: replicas,
 		vnodeMap: make(map[uint32]string),
-		nodes:    make(map[string]struct{}),
+		nodes:    make(map[string]int),
 		keys:     nil,
 	}
 	return hr
@@ -39,16 +45,82 @@ func (hr *HashRing) AddNode(nodeID string) {
 		return
 	}
 
-	hr.nodes[nodeID] = struct{}{}
+	hr.nodes[nodeID] = hr.replicas
 	for i := 0; i < hr.replicas; i++ {
-		vnodeKey := nodeID + "#" + strconv.Itoa(i)
-		hv := hr.hashStr(vnodeKey)
+		hv := hr.hashStr(nodeID + "#" + strconv.Itoa(i))
+		hr.keys = append(hr.keys, hv)
+		hr.vnodeMap[hv] = nodeID
+	}
+	sort.Slice(hr.keys, func(i, j int) bool { return hr.keys[i] < hr.keys[j] })
+}
+
+// SetVNodes gives nodeID n virtual nodes. Virtual nodes are numbered, so
+// growing from n to n+1 adds one point and keeps the others: only the keys
+// falling between the new point and its predecessor change owner.
+func (hr *HashRing) SetVNodes(nodeID string, n int) {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+
+	cur, ok := hr.nodes[nodeID]
+	if !ok || n < 1 || n == cur {
+		return
+	}
+	hr.nodes[nodeID] = n
+	for i := cur; i < n; i++ {
+		hv := hr.vnodeHash(nodeID, i)
 		hr.keys = append(hr.keys, hv)
 		hr.vnodeMap[hv] = nodeID
 	}
+	if n < cur {
+		drop := make(map[uint32]bool, cur-n)
+		for i := n; i < cur; i++ {
+			hv := hr.vnodeHash(nodeID, i)
+			if hr.vnodeMap[hv] == nodeID {
+				delete(hr.vnodeMap, hv)
+				drop[hv] = true
+			}
+		}
+		keys := hr.keys[:0]
+		for _, kv := range hr.keys {
+			if !drop[kv] {
+				keys = append(keys, kv)
+			}
+		}
+		hr.keys = keys
+	}
 	sort.Slice(hr.keys, func(i, j int) bool { return hr.keys[i] < hr.keys[j] })
 }
 
+// vnodeHash places virtual node i of nodeID. Points beyond the ones a node
+// joins with go through a finalizer: FNV sums of names differing only in
+// their last digit land next to each other, so added points would take
+// over almost no keys. The original points keep their places.
+func (hr *HashRing) vnodeHash(nodeID string, i int) uint32 {
+	h := hr.hashStr(nodeID + "#" + strconv.Itoa(i))
+	if i < hr.replicas {
+		return h
+	}
+	return fmix32(h)
+}
+
+// fmix32 is murmur3's finalizer, which spreads inputs differing in a few
+// bits across the whole range
+func fmix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// VNodes returns the virtual nodes of nodeID, 0 if it is not on the ring
+func (hr *HashRing) VNodes(nodeID string) int {
+	hr.mutex.RLock()
+	defer hr.mutex.RUnlock()
+	return hr.nodes[nodeID]
+}
+
 func (hr *HashRing) RemoveNode(nodeID string) {
 	hr.mutex.Lock()
 	defer hr.mutex.Unlock()
@@ -68,13 +140,27 @@ func (hr *HashRing) RemoveNode(nodeID string) {
 	hr.keys = newKeys
 }
 
+// GetNode returns the node the ring places key's slot on. The slot comes
+// from the key's group or hash tag when it has one, so keys sharing a tag,
+// such as {user:1000}:name and {user:1000}:cart, land on the same node.
 func (hr *HashRing) GetNode(key string) (string, bool) {
+	return hr.GetSlotNode(hr.keySlot(key))
+}
+
+// GetSlotNode returns the node the ring places a hash slot on. Slot numbers
+// are consecutive, so they go through fmix32 rather than FNV, whose sums of
+// neighbouring numbers would crowd onto a few virtual nodes.
+func (hr *HashRing) GetSlotNode(slot int) (string, bool) {
+	return hr.locate(fmix32(uint32(slot) + 1))
+}
+
+// locate returns the node of the first virtual node at or after hv
+func (hr *HashRing) locate(hv uint32) (string, bool) {
 	hr.mutex.RLock()
 	defer hr.mutex.RUnlock()
 	if len(hr.keys) == 0 {
 		return "", false
 	}
-	hv := hr.hashStr(key)
 	// Binery search for the closest vnode hash >= hv
 	idx := sort.Search(len(hr.keys), func(i int) bool { return hr.keys[i] >= hv })
 	if idx == len(hr.keys) {
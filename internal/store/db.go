@@ -0,0 +1,191 @@
+package store
+
+import (
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Logical databases share one keyspace. A key of database n > 0 is stored
+// as "\x00n\x00key" and database 0 keys are stored as they are, so routing,
+// migration, snapshots and replication carry the database with the key and
+// need no notion of it. A database 0 key that itself starts with such a
+// prefix is taken to belong to that database.
+
+const dbMark = '\x00'
+
+// DBKey is the stored name of key in database db
+func DBKey(db int, key string) string {
+	if db == 0 {
+		return key
+	}
+	return string(dbMark) + strconv.Itoa(db) + string(dbMark) + key
+}
+
+// KeyDB splits a stored name into its database and the key clients see
+func KeyDB(stored string) (int, string) {
+	if len(stored) < 3 || stored[0] != dbMark {
+		return 0, stored
+	}
+	end := strings.IndexByte(stored[1:], dbMark) + 1
+	if end <= 1 {
+		return 0, stored
+	}
+	db, err := strconv.Atoi(stored[1:end])
+	if err != nil || db <= 0 || strconv.Itoa(db) != stored[1:end] {
+		return 0, stored
+	}
+	return db, stored[end+1:]
+}
+
+// countDBKey keeps dbKeys in step as indexKey files or drops key. Callers
+// hold s.mu.
+func (s *Store) countDBKey(key string, delta int) {
+	db, _ := KeyDB(key)
+	if db == 0 {
+		return // database 0 is what the others leave of data.Len()
+	}
+	if s.dbKeys == nil {
+		s.dbKeys = make(map[int]int)
+	}
+	s.dbKeys[db] += delta
+	if s.dbKeys[db] == 0 {
+		delete(s.dbKeys, db)
+	}
+}
+
+// DBKeys counts the keys of database db, including expired ones not yet
+// reaped
+func (s *Store) DBKeys(db int) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if db != 0 {
+		return s.dbKeys[db]
+	}
+	n := s.data.Len()
+	for _, c := range s.dbKeys {
+		n -= c
+	}
+	return n
+}
+
+// keysOfDB lists the stored names of every key in database db
+func (s *Store) keysOfDB(db int) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx := s.types.all[db]
+	if idx == nil {
+		return nil
+	}
+	entries := idx.RangeByScore(0, math.MaxUint32)
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = e.Member
+	}
+	return keys
+}
+
+// FlushDB removes every key of database db
+func (s *Store) FlushDB(db int) int {
+	keys := s.keysOfDB(db)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		s.data.Delete(k)
+		s.clearTTL(k)
+		s.accountKey(k)
+	}
+	return len(keys)
+}
+
+func (s *Shard) cmdFlushDB(req ShardRequest) {
+	db := 0
+	if len(req.Args) > 0 {
+		db, _ = strconv.Atoi(req.Args[0])
+	}
+	req.Reply <- s.Store.FlushDB(db)
+}
+
+// DBKeys counts the keys of database db on every shard
+func (ss *SharedStore) DBKeys(db int) int {
+	total := 0
+	for _, sh := range ss.shardList() {
+		total += sh.Store.DBKeys(db)
+	}
+	return total
+}
+
+// FlushDB empties database db on every shard
+func (ss *SharedStore) FlushDB(db int) {
+	ss.dropDBHotKeys(db)
+	// a replica installed while the flush was in flight must not survive it
+	defer ss.dropDBHotKeys(db)
+	ss.Broadcast("FLUSHDB", "", strconv.Itoa(db))
+}
+
+// dropDBHotKeys drops the replicas of every key of database db, as
+// dropAllHotKeys does for all of them
+func (ss *SharedStore) dropDBHotKeys(db int) {
+	hk := ss.hotKeys()
+	if hk == nil {
+		return
+	}
+	for _, k := range hk.trackedKeys() {
+		if d, _ := KeyDB(k); d == db {
+			ss.invalidateHotKey(hk, k)
+		}
+	}
+}
+
+// SwapDB exchanges the contents of databases a and b and returns how many
+// keys moved. Every key of both is taken out, then put back under its new
+// name on the shard that owns it. Commands running meanwhile may find a key
+// in neither database; a key a client writes under its new name before it
+// is put back keeps the written value.
+func (ss *SharedStore) SwapDB(a, b int) int {
+	if a == b {
+		return 0
+	}
+	var moved []backupRecord
+	for _, sh := range ss.shardList() {
+		for _, k := range append(sh.Store.keysOfDB(a), sh.Store.keysOfDB(b)...) {
+			rec, ok, err := sh.Store.exportKey(k, true, "")
+			if err != nil {
+				log.Printf("ERROR: %s - Cannot move during SWAPDB: %v", k, err)
+				continue
+			}
+			if ok {
+				moved = append(moved, rec)
+			}
+		}
+	}
+	for _, rec := range moved {
+		db, name := KeyDB(rec.Key)
+		dst := DBKey(a, name)
+		if db == a {
+			dst = DBKey(b, name)
+		}
+		ss.dropHotKeys(rec.Key, dst)
+		st, ok := ss.shardStore(dst)
+		if !ok {
+			log.Printf("ERROR: %s - No shard to move into during SWAPDB", dst)
+			continue
+		}
+		if _, err := st.importKey(dst, rec, false, ""); err != nil {
+			log.Printf("ERROR: %s - Cannot move during SWAPDB: %v", dst, err)
+		}
+	}
+	return len(moved)
+}
+
+// shardList snapshots the current shards
+func (ss *SharedStore) shardList() []*Shard {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	shards := make([]*Shard, 0, len(ss.nodeShards))
+	for _, sh := range ss.nodeShards {
+		shards = append(shards, sh)
+	}
+	return shards
+}
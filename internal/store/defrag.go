@@ -0,0 +1,162 @@
+package store
+
+import (
+	"time"
+)
+
+// Go maps never release buckets and re-sliced lists keep their backing array,
+// so after heavy churn a shard can hold far more memory than its live data.
+// The defrag job rebuilds such containers with right-sized storage.
+const (
+	defragShrinkRatio = 0.5  // rebuild the keyspace once it drops below half its peak
+	defragMinKeys     = 1024 // never bother rebuilding maps smaller than this
+	defragSlackRatio  = 2    // trim slices whose capacity is over twice their length
+	defragMinSlack    = 64   // ignore slack below this many elements or bytes
+)
+
+// DefragStats counts the work done by the defrag job
+type DefragStats struct {
+	Runs             uint64
+	MapsRebuilt      uint64 // set and hash containers rehashed after deletes
+	SlicesTrimmed    uint64 // list and string buffers reallocated
+	KeyspaceRebuilds uint64 // the store's own data/ttl/size maps rebuilt
+	BytesReclaimed   int64  // estimated slack released
+	LastRun          time.Time
+}
+
+// defragState is guarded by Store.mu
+type defragState struct {
+	peakKeys int
+	churn    map[string]int // deletes per container since its last rebuild
+	stats    DefragStats
+}
+
+// noteShrink records n deletes from the container at key. Callers must hold s.mu.
+func (s *Store) noteShrink(key string, n int) {
+	if n == 0 {
+		return
+	}
+	if s.defrag.churn == nil {
+		s.defrag.churn = make(map[string]int)
+	}
+	s.defrag.churn[key] += n
+}
+
+// StartDefrag periodically compacts up to sampleSize containers per run
+func (s *Store) StartDefrag(sampleSize int, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.defragCycle(sampleSize)
+		}
+	}()
+}
+
+func (s *Store) defragCycle(sampleSize int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := &s.defrag.stats
+	st.Runs++
+	st.LastRun = time.Now()
+
+	// containers with enough deletes that their storage is mostly dead
+	for key, deletes := range s.defrag.churn {
+		if sampleSize <= 0 {
+			break
+		}
+		sampleSize--
+		delete(s.defrag.churn, key)
+
+		val, ok := s.data.Get(key)
+		if !ok {
+			continue
+		}
+		switch val.Type {
+		case SetType:
+			if val.packed != nil || deletes < len(val.Set) || deletes < defragMinSlack {
+				continue
+			}
+			set := make(map[string]struct{}, len(val.Set))
+			for m := range val.Set {
+				set[m] = struct{}{}
+			}
+			val.Set = set
+		case HashType:
+			if val.packed != nil || deletes < len(val.Hash) || deletes < defragMinSlack {
+				continue
+			}
+			hash := make(map[string]string, len(val.Hash))
+			for f, v := range val.Hash {
+				hash[f] = v
+			}
+			val.Hash = hash
+		case ListType:
+			if deletes < len(val.List) || deletes < defragMinSlack {
+				continue
+			}
+			val.List = append(make([]string, 0, len(val.List)), val.List...)
+			s.data.Put(key, val)
+			st.SlicesTrimmed++
+			st.BytesReclaimed += int64(deletes) * 16
+			continue
+		default:
+			continue
+		}
+		s.data.Put(key, val)
+		st.MapsRebuilt++
+		st.BytesReclaimed += int64(deletes) * 48
+	}
+
+	// map iteration order is random, so this samples the keyspace
+	for key, val := range s.data.All() {
+		if sampleSize <= 0 {
+			break
+		}
+		sampleSize--
+
+		switch val.Type {
+		case ListType:
+			slack := cap(val.List) - len(val.List)
+			if slack < defragMinSlack || cap(val.List) <= defragSlackRatio*len(val.List) {
+				continue
+			}
+			val.List = append(make([]string, 0, len(val.List)), val.List...)
+			st.BytesReclaimed += int64(slack) * 16
+		case StringType:
+			slack := cap(val.Data) - len(val.Data)
+			if slack < defragMinSlack || cap(val.Data) <= defragSlackRatio*len(val.Data) {
+				continue
+			}
+			val.Data = append(make([]byte, 0, len(val.Data)), val.Data...)
+			st.BytesReclaimed += int64(slack)
+		default:
+			continue
+		}
+		s.data.Put(key, val)
+		st.SlicesTrimmed++
+	}
+
+	// the keyspace itself
+	if n := s.data.Len(); n > s.defrag.peakKeys {
+		s.defrag.peakKeys = n
+	}
+	if s.defrag.peakKeys >= defragMinKeys && float64(s.data.Len()) < defragShrinkRatio*float64(s.defrag.peakKeys) {
+		s.data.Compact()
+		ttl := make(map[string]time.Time, len(s.ttl))
+		for k, t := range s.ttl {
+			ttl[k] = t
+		}
+		sizes := make(map[string]int64, len(s.memory.sizes))
+		for k, n := range s.memory.sizes {
+			sizes[k] = n
+		}
+		st.BytesReclaimed += int64(s.defrag.peakKeys-s.data.Len()) * 48
+		s.ttl, s.memory.sizes = ttl, sizes
+		s.ttlKeys.rebuild()
+		s.defrag.peakKeys = s.data.Len()
+		st.KeyspaceRebuilds++
+	}
+}
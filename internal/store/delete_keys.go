@@ -0,0 +1,14 @@
+package store
+
+// DeleteKeys deletes keys and returns how many existed, every shard
+// deleting its share of the keys in one request, all shards at once, see
+// ExecuteMulti
+func (ss *SharedStore) DeleteKeys(keys []string) int {
+	deleted := 0
+	for _, r := range ss.ExecuteMulti("DEL", KeyOps(keys)) {
+		if ok, _ := r.(bool); ok {
+			deleted++
+		}
+	}
+	return deleted
+}
@@ -0,0 +1,159 @@
+package store
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"hash"
+	"math"
+)
+
+// Digests are order independent so two stores holding the same data agree
+// regardless of map iteration or insertion order: unordered collections XOR
+// the hashes of their elements, and a dataset XORs the digests of its keys.
+// Expiry deadlines are left out because migration and replication re-derive
+// them from the remaining TTL.
+
+type Digest [sha1.Size]byte
+
+func (d *Digest) xor(o Digest) {
+	for i := range d {
+		d[i] ^= o[i]
+	}
+}
+
+// writeField writes a length-prefixed field so adjacent fields can't alias
+func writeField(h hash.Hash, b []byte) {
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], uint64(len(b)))
+	h.Write(n[:])
+	h.Write(b)
+}
+
+func sumFields(fields ...[]byte) Digest {
+	h := sha1.New()
+	for _, f := range fields {
+		writeField(h, f)
+	}
+	var d Digest
+	h.Sum(d[:0])
+	return d
+}
+
+// digestValue hashes a value's type and contents
+func digestValue(v Value) Digest {
+	var elems Digest // XOR of element hashes for unordered types
+	h := sha1.New()
+	h.Write([]byte{byte(v.Type)})
+
+	switch v.Type {
+	case StringType:
+		writeField(h, v.Data)
+	case SetType:
+		for m := range v.setMembers() {
+			elems.xor(sumFields([]byte(m)))
+		}
+	case HashType:
+		for f, val := range v.hashFields() {
+			elems.xor(sumFields([]byte(f), []byte(val)))
+		}
+	case ZSetType:
+		for m, score := range v.ZSet {
+			var sb [8]byte
+			binary.BigEndian.PutUint64(sb[:], math.Float64bits(score))
+			elems.xor(sumFields([]byte(m), sb[:]))
+		}
+	case ListType:
+		for _, e := range v.List {
+			writeField(h, []byte(e))
+		}
+	case CMSType:
+		if v.CMS != nil {
+			var dim [16]byte
+			binary.BigEndian.PutUint64(dim[:8], uint64(v.CMS.Depth))
+			binary.BigEndian.PutUint64(dim[8:], uint64(v.CMS.Width))
+			h.Write(dim[:])
+			var c [4]byte
+			for _, row := range v.CMS.Table {
+				for _, n := range row {
+					binary.BigEndian.PutUint32(c[:], n)
+					h.Write(c[:])
+				}
+			}
+		}
+	case BFType:
+		if v.BF != nil {
+			writeField(h, v.BF.Bits())
+		}
+	}
+	h.Write(elems[:])
+
+	var d Digest
+	h.Sum(d[:0])
+	return d
+}
+
+// digestKey combines a key name with its value digest
+func digestKey(key string, v Value) Digest {
+	vd := digestValue(v)
+	return sumFields([]byte(key), vd[:])
+}
+
+// Digest returns the order-independent digest of every live key; an empty
+// store yields all zeros
+func (s *Store) Digest() Digest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var d Digest
+	for k, v := range s.data.All() {
+		if s.pastTTL(k) {
+			continue
+		}
+		d.xor(digestKey(k, v))
+	}
+	return d
+}
+
+// DigestKey returns the digest of one key's value, ok=false if it is missing
+func (s *Store) DigestKey(key string) (Digest, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.data.Get(key)
+	if !ok || s.pastTTL(key) {
+		return Digest{}, false
+	}
+	return digestValue(v), true
+}
+
+// Digests returns each shard's dataset digest by node ID, computed on the
+// shard workers so every digest reflects a point between two commands
+func (ss *SharedStore) Digests() map[string]Digest {
+	out := make(map[string]Digest)
+	for id, r := range ss.Broadcast("DIGEST", "") {
+		if d, ok := r.(Digest); ok {
+			out[id] = d
+		}
+	}
+	return out
+}
+
+// Digest combines the shard digests into one for the whole dataset
+func (ss *SharedStore) Digest() Digest {
+	var d Digest
+	for _, sd := range ss.Digests() {
+		d.xor(sd)
+	}
+	return d
+}
+
+// DigestKey returns the value digest of key on the shard that owns it
+func (ss *SharedStore) DigestKey(key string) (Digest, bool) {
+	node, ok := ss.GetNodeForKey(key)
+	if !ok {
+		return Digest{}, false
+	}
+	sh, ok := ss.getShardByNodeID(node)
+	if !ok {
+		return Digest{}, false
+	}
+	return sh.Store.DigestKey(key)
+}
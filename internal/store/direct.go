@@ -0,0 +1,101 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Fast read-only commands skip the shard worker. The Store's RWMutex already
+// lets readers run side by side, so queueing a read behind the worker only
+// adds two channel hops and a goroutine switch; running it on the caller's
+// goroutine keeps writes ordered on the worker and reads off its queue.
+
+// replyPool recycles the reply channels of direct reads
+var replyPool = sync.Pool{New: func() any { return make(chan interface{}, 1) }}
+
+// workerReply is the pooled reply channel of a request sent to a worker. A
+// handler that panics after replying is answered again with
+// ErrShardRestarted, which a channel the sender had already recycled would
+// hand to an unrelated request. So the sender and the worker each hold a
+// reference, and the last to let go drains the channel and recycles it.
+type workerReply struct {
+	ch   chan interface{}
+	refs atomic.Int32
+}
+
+var workerReplyPool = sync.Pool{New: func() any { return &workerReply{ch: make(chan interface{}, 1)} }}
+
+// newWorkerReply takes a reply from the pool, referenced by the sender and
+// the worker
+func newWorkerReply() *workerReply {
+	r := workerReplyPool.Get().(*workerReply)
+	r.refs.Store(2)
+	return r
+}
+
+// release drops a reference to r; a nil r is a channel its sender owns
+func (r *workerReply) release() {
+	if r == nil || r.refs.Add(-1) > 0 {
+		return
+	}
+	select {
+	case <-r.ch:
+	default:
+	}
+	workerReplyPool.Put(r)
+}
+
+// directRead returns the shard command for cmd if it may run on the caller
+func directRead(cmd string) (shardCommand, bool) {
+	sc, ok := shardCommands[cmd]
+	if !ok {
+		sc, ok = shardCommands[strings.ToUpper(cmd)]
+	}
+	const want = shardFast | shardReadOnly
+	return sc, ok && sc.flags&want == want && sc.flags&shardInternal == 0
+}
+
+// readDirect runs a fast read on the calling goroutine. A panicking handler
+// fails the read alone; there is no worker to restart.
+func (s *Shard) readDirect(sc shardCommand, req ShardRequest) (resp interface{}) {
+	reply := replyPool.Get().(chan interface{})
+	req.Reply = reply
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("ERROR: %s - %s panicked: %v\n%s", s.nodeID, req.Command, r, debug.Stack())
+			resp = fmt.Errorf("internal error handling %s", req.Command)
+			return // the channel may hold a reply, so it is not reused
+		}
+		replyPool.Put(reply)
+	}()
+	s.direct.Add(1)
+	sc.handler(s, req)
+	return <-reply
+}
+
+// isMissReply reports whether a shard reply means "key not found"
+func isMissReply(resp interface{}) bool {
+	switch v := resp.(type) {
+	case nil:
+		return true
+	case []byte:
+		return v == nil
+	case []string:
+		return len(v) == 0
+	case map[string]string:
+		return len(v) == 0
+	case setsBatch:
+		return len(v.sets) == 0
+	case int:
+		return v == 0
+	case int64:
+		return v == -2 // TTL of a missing key
+	case bool:
+		return !v
+	}
+	return false
+}
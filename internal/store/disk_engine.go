@@ -0,0 +1,369 @@
+package store
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"iter"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"multithreaded-redis/internal/datastuctures"
+)
+
+const (
+	defaultDiskCacheKeys = 10000
+	// the spill file is rewritten once superseded records pass this size
+	// and outweigh the live ones
+	diskCompactMinBytes = 4 << 20
+)
+
+// diskEngine keeps the most recently used values decoded in memory and
+// spills the rest to an append-only file, so a shard can hold more data than
+// fits in RAM. The file is scratch space, not persistence: it is truncated
+// on open and removed on close.
+type diskEngine struct {
+	// keys is every live key. It changes only in Put, Delete and Clear, which
+	// run under the store's write lock, so All can range over it while Get
+	// runs in other readers.
+	keys map[string]struct{}
+
+	mu        sync.Mutex // guards everything below; Get changes the cache
+	file      *spillFile
+	cache     map[string]*list.Element
+	lru       *list.List // of *diskEntry, most recently used first
+	cacheKeys int
+}
+
+type diskEntry struct {
+	key   string
+	v     Value
+	dirty bool // changed since it was last written to the file
+}
+
+func openDiskEngine(path string, cacheKeys int) (*diskEngine, error) {
+	if cacheKeys <= 0 {
+		cacheKeys = defaultDiskCacheKeys
+	}
+	file, err := openSpillFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &diskEngine{
+		keys:      make(map[string]struct{}),
+		file:      file,
+		cache:     make(map[string]*list.Element),
+		lru:       list.New(),
+		cacheKeys: cacheKeys,
+	}, nil
+}
+
+func (e *diskEngine) Name() string { return DiskEngine }
+
+func (e *diskEngine) Get(key string) (Value, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if el, ok := e.cache[key]; ok {
+		e.lru.MoveToFront(el)
+		return el.Value.(*diskEntry).v, true
+	}
+	v, ok := e.file.read(key)
+	if !ok {
+		return Value{}, false
+	}
+	e.cache[key] = e.lru.PushFront(&diskEntry{key: key, v: v})
+	e.shrinkCache()
+	return v, true
+}
+
+func (e *diskEngine) Put(key string, v Value) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keys[key] = struct{}{}
+	if el, ok := e.cache[key]; ok {
+		ent := el.Value.(*diskEntry)
+		ent.v, ent.dirty = v, true
+		e.lru.MoveToFront(el)
+		return
+	}
+	e.cache[key] = e.lru.PushFront(&diskEntry{key: key, v: v, dirty: true})
+	e.shrinkCache()
+}
+
+func (e *diskEngine) Delete(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.keys, key)
+	if el, ok := e.cache[key]; ok {
+		e.lru.Remove(el)
+		delete(e.cache, key)
+	}
+	e.file.remove(key)
+}
+
+func (e *diskEngine) Len() int { return len(e.keys) }
+
+// All loads each value through the cache. Ranging over the key map keeps
+// the order random, as with the memory engine.
+func (e *diskEngine) All() iter.Seq2[string, Value] {
+	return func(yield func(string, Value) bool) {
+		for k := range e.keys {
+			v, ok := e.Get(k)
+			if !ok {
+				continue
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (e *diskEngine) Clear() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keys = make(map[string]struct{})
+	e.cache = make(map[string]*list.Element)
+	e.lru.Init()
+	e.file.clear()
+}
+
+func (e *diskEngine) Compact() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.file.dead > 0 {
+		e.file.compact()
+	}
+}
+
+func (e *diskEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.close()
+}
+
+// shrinkCache spills the least recently used values until the cache is
+// back within its limit. Callers must hold e.mu.
+func (e *diskEngine) shrinkCache() {
+	for e.lru.Len() > e.cacheKeys {
+		el := e.lru.Back()
+		ent := el.Value.(*diskEntry)
+		if ent.dirty {
+			if err := e.file.write(ent.key, ent.v); err != nil {
+				// keep the value in memory rather than lose it
+				log.Printf("ERROR: %s - Failed to spill value to %s: %v", ent.key, e.file.path, err)
+				return
+			}
+		}
+		e.lru.Remove(el)
+		delete(e.cache, ent.key)
+	}
+	e.file.maybeCompact()
+}
+
+// spillFile is an append-only file of encoded values, the storage behind the
+// disk and tiered engines. Callers serialize access.
+type spillFile struct {
+	path    string
+	f       *os.File
+	size    int64                 // end of the file, where records are appended
+	dead    int64                 // bytes of records superseded or removed
+	records map[string]diskRecord // where each key's latest value is
+}
+
+type diskRecord struct {
+	off int64
+	n   int64
+}
+
+// diskValue is the on-disk form of a Value. Sketches and filters are stored
+// in their gob encoding and sorted sets without their skiplist, which is
+// rebuilt on load.
+type diskValue struct {
+	Type       ValueType
+	Data       []byte
+	Set        map[string]struct{}
+	Hash       map[string]string
+	List       []string
+	ZSet       map[string]float64
+	CMS        []byte
+	BF         []byte
+	Expiration int64
+	LastAccess int64
+}
+
+func openSpillFile(path string) (*spillFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{path: path, f: f, records: make(map[string]diskRecord)}, nil
+}
+
+func (sf *spillFile) has(key string) bool {
+	_, ok := sf.records[key]
+	return ok
+}
+
+// write appends v as key's latest record
+func (sf *spillFile) write(key string, v Value) error {
+	raw, err := encodeValue(v)
+	if err != nil {
+		return err
+	}
+	if _, err := sf.f.WriteAt(raw, sf.size); err != nil {
+		return err
+	}
+	sf.remove(key)
+	sf.records[key] = diskRecord{off: sf.size, n: int64(len(raw))}
+	sf.size += int64(len(raw))
+	return nil
+}
+
+// read decodes key's latest record. A record that cannot be read is logged
+// and reported as missing.
+func (sf *spillFile) read(key string) (Value, bool) {
+	rec, ok := sf.records[key]
+	if !ok {
+		return Value{}, false
+	}
+	v, err := sf.load(rec)
+	if err != nil {
+		log.Printf("ERROR: %s - Failed to read value from %s: %v", key, sf.path, err)
+		return Value{}, false
+	}
+	return v, true
+}
+
+func (sf *spillFile) load(rec diskRecord) (Value, error) {
+	raw := make([]byte, rec.n)
+	if _, err := sf.f.ReadAt(raw, rec.off); err != nil {
+		return Value{}, err
+	}
+	return decodeValue(raw)
+}
+
+// encodeValue serializes every part of v, unlike serializeValue which
+// leaves the expiration and access time to the KeyDump around it
+func encodeValue(v Value) ([]byte, error) {
+	v = v.unpacked()
+	dv := diskValue{
+		Type: v.Type, Data: v.Data, Set: v.Set, Hash: v.Hash, List: v.List, ZSet: v.ZSet,
+		Expiration: v.Expiration, LastAccess: v.LastAccess,
+	}
+	var err error
+	if v.CMS != nil {
+		if dv.CMS, err = v.CMS.GobEncode(); err != nil {
+			return nil, err
+		}
+	}
+	if v.BF != nil {
+		if dv.BF, err = v.BF.GobEncode(); err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(dv); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeValue(raw []byte) (Value, error) {
+	var dv diskValue
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&dv); err != nil {
+		return Value{}, err
+	}
+	v := Value{
+		Type: dv.Type, Data: dv.Data, Set: dv.Set, Hash: dv.Hash, List: dv.List, ZSet: dv.ZSet,
+		Expiration: dv.Expiration, LastAccess: dv.LastAccess,
+	}
+	if len(dv.CMS) > 0 {
+		v.CMS = &datastuctures.CountMinSketch{}
+		if err := v.CMS.GobDecode(dv.CMS); err != nil {
+			return Value{}, err
+		}
+	}
+	if len(dv.BF) > 0 {
+		v.BF = &datastuctures.BloomFilter{}
+		if err := v.BF.GobDecode(dv.BF); err != nil {
+			return Value{}, err
+		}
+	}
+	if v.Type == ZSetType {
+		v.ZSL = datastuctures.NewSkipList()
+		for member, score := range v.ZSet {
+			v.ZSL.Insert(member, score)
+		}
+	}
+	return v, nil
+}
+
+// remove marks key's record as dead
+func (sf *spillFile) remove(key string) {
+	if rec, ok := sf.records[key]; ok {
+		sf.dead += rec.n
+		delete(sf.records, key)
+	}
+}
+
+func (sf *spillFile) clear() {
+	sf.records = make(map[string]diskRecord)
+	sf.size, sf.dead = 0, 0
+	if err := sf.f.Truncate(0); err != nil {
+		log.Printf("ERROR: Failed to truncate %s: %v", sf.path, err)
+	}
+}
+
+func (sf *spillFile) close() error {
+	err := sf.f.Close()
+	os.Remove(sf.path)
+	return err
+}
+
+// maybeCompact compacts once dead records are both large and the majority
+func (sf *spillFile) maybeCompact() {
+	if sf.dead >= diskCompactMinBytes && sf.dead > sf.size-sf.dead {
+		sf.compact()
+	}
+}
+
+// compact copies the live records to a new file and swaps it in
+func (sf *spillFile) compact() {
+	tmp := sf.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		log.Printf("ERROR: Failed to compact %s: %v", sf.path, err)
+		return
+	}
+	records := make(map[string]diskRecord, len(sf.records))
+	var size int64
+	for key, rec := range sf.records {
+		raw := make([]byte, rec.n)
+		if _, err = sf.f.ReadAt(raw, rec.off); err == nil {
+			_, err = f.WriteAt(raw, size)
+		}
+		if err != nil {
+			break
+		}
+		records[key] = diskRecord{off: size, n: rec.n}
+		size += rec.n
+	}
+	if err == nil {
+		err = os.Rename(tmp, sf.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		log.Printf("ERROR: Failed to compact %s: %v", sf.path, err)
+		return
+	}
+	sf.f.Close()
+	log.Printf("DEBUG: Compacted %s from %d to %d bytes", sf.path, sf.size, size)
+	sf.f, sf.size, sf.dead, sf.records = f, size, 0, records
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"time"
+)
+
+// A DUMP payload is the encodeValue form of the value followed by a
+// two-byte format version and a CRC-64 of everything before it, both little
+// endian. RESTORE refuses payloads of another version or with a bad checksum,
+// so a value never loads from a truncated or foreign payload.
+const dumpVersion = 1
+
+var (
+	ErrBadDump = errors.New("ERR DUMP payload version or checksum are wrong")
+	ErrBusyKey = errors.New("BUSYKEY Target key name already exists.")
+	ErrNoShard = errors.New("TRYAGAIN no shard owns the key, retry")
+)
+
+var dumpTable = crc64.MakeTable(crc64.ECMA)
+
+// Dump serializes the value at key, without its name or expiration, and
+// returns the expiration apart, zero when the key has none
+func (ss *SharedStore) Dump(key string) ([]byte, time.Time, bool, error) {
+	st, ok := ss.shardStore(key)
+	if !ok {
+		return nil, time.Time{}, false, nil
+	}
+	rec, ok, err := st.exportKey(key, false, "")
+	if err != nil || !ok {
+		return nil, time.Time{}, false, err
+	}
+	out := binary.LittleEndian.AppendUint16(rec.Value, dumpVersion)
+	return binary.LittleEndian.AppendUint64(out, crc64.Checksum(out, dumpTable)), rec.TTL, true, nil
+}
+
+// Restore creates key from a DUMP payload, expiring at ttl unless it is
+// zero. An existing key is only overwritten with replace.
+func (ss *SharedStore) Restore(key string, payload []byte, ttl time.Time, replace bool) error {
+	if len(payload) < 10 {
+		return ErrBadDump
+	}
+	body, sum := payload[:len(payload)-8], payload[len(payload)-8:]
+	if binary.LittleEndian.Uint64(sum) != crc64.Checksum(body, dumpTable) ||
+		binary.LittleEndian.Uint16(body[len(body)-2:]) != dumpVersion {
+		return ErrBadDump
+	}
+	st, ok := ss.shardStore(key)
+	if !ok {
+		return ErrNoShard
+	}
+	ss.dropHotKeys(key)
+	rec := backupRecord{Key: key, TTL: ttl, Value: body[:len(body)-2]}
+	restored, err := st.importKey(key, rec, replace, "restore")
+	if err != nil {
+		return ErrBadDump
+	}
+	if !restored {
+		return ErrBusyKey
+	}
+	return nil
+}
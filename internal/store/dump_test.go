@@ -0,0 +1,55 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDumpRestore(t *testing.T) {
+	ss := newTestSharedStore(t)
+	ss.Set("src", []byte("hello"), 0)
+	payload, _, ok, err := ss.Dump("src")
+	if err != nil || !ok {
+		t.Fatalf("Dump = %v, %v", ok, err)
+	}
+	if _, _, ok, _ := ss.Dump("missing"); ok {
+		t.Error("Dump of a missing key succeeded")
+	}
+
+	if err := ss.Restore("dst", payload, time.Time{}, false); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if v, ok := ss.Get("dst"); !ok || string(v) != "hello" {
+		t.Errorf("restored value = %q, %v", v, ok)
+	}
+	if err := ss.Restore("dst", payload, time.Time{}, false); err != ErrBusyKey {
+		t.Errorf("Restore over an existing key = %v, want ErrBusyKey", err)
+	}
+	if err := ss.Restore("dst", payload, time.Time{}, true); err != nil {
+		t.Errorf("Restore with replace: %v", err)
+	}
+
+	// every corrupted or cut payload is refused before it reaches the key
+	for name, bad := range map[string][]byte{
+		"flipped byte": flip(payload, 0),
+		"bad checksum": flip(payload, len(payload)-1),
+		"bad version":  flip(payload, len(payload)-10),
+		"truncated":    payload[:len(payload)-1],
+		"too short":    payload[:9],
+		"empty":        nil,
+	} {
+		if err := ss.Restore("bad", bad, time.Time{}, true); err != ErrBadDump {
+			t.Errorf("%s: Restore = %v, want ErrBadDump", name, err)
+		}
+	}
+	if _, ok := ss.Get("bad"); ok {
+		t.Error("a refused payload created its key")
+	}
+}
+
+// flip returns a copy of b with the byte at i changed
+func flip(b []byte, i int) []byte {
+	out := append([]byte(nil), b...)
+	out[i] ^= 0xff
+	return out
+}
@@ -0,0 +1,396 @@
+package store
+
+import (
+	"iter"
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+// Small sets and hashes are kept packed instead of in Go maps, which cost a
+// few hundred bytes even when nearly empty:
+//
+//   - intset: a set whose members are all integers, as a sorted []int64
+//   - listpack: any other small set as a flat []string of members, and a
+//     small hash as alternating fields and values
+//
+// A value moves to the map ("hashtable") encoding for good once it grows
+// past the EncodingLimits, as in Redis; removing members never packs it
+// again. Values loaded from snapshots, DUMP payloads or migrations are
+// packed when they are small enough.
+
+// EncodingLimits are the sizes up to which sets and hashes stay packed,
+// Redis's set-max-intset-entries and *-max-listpack-* settings. Zero
+// entries disables an encoding.
+type EncodingLimits struct {
+	SetIntsetEntries    int
+	SetListpackEntries  int
+	SetListpackValue    int // longest member of a listpack set, in bytes
+	HashListpackEntries int
+	HashListpackValue   int // longest field or value of a listpack hash, in bytes
+}
+
+// packed holds a set or hash in one of the compact encodings. A set is an
+// intset while items is nil.
+type packed struct {
+	ints  []int64
+	items []string
+}
+
+// storeEncoding is read on every write, so it is kept in atomics
+type storeEncoding struct {
+	setIntset, setListpack, setValue atomic.Int64
+	hashListpack, hashValue          atomic.Int64
+}
+
+// SetEncodingLimits changes the sizes up to which new and growing values
+// stay packed; values already stored keep their encoding
+func (s *Store) SetEncodingLimits(l EncodingLimits) {
+	s.encoding.setIntset.Store(int64(l.SetIntsetEntries))
+	s.encoding.setListpack.Store(int64(l.SetListpackEntries))
+	s.encoding.setValue.Store(int64(l.SetListpackValue))
+	s.encoding.hashListpack.Store(int64(l.HashListpackEntries))
+	s.encoding.hashValue.Store(int64(l.HashListpackValue))
+}
+
+// SetEncodingLimits applies l on every shard, including shards added later
+func (ss *SharedStore) SetEncodingLimits(l EncodingLimits) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.encoding = l
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetEncodingLimits(l)
+	}
+}
+
+// encodingName names the representation of v, as OBJECT ENCODING reports it
+func (v *Value) encodingName() string {
+	switch v.Type {
+	case StringType:
+		if n, err := strconv.ParseInt(string(v.Data), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(v.Data) {
+			return "int"
+		}
+		if len(v.Data) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+	case SetType:
+		switch {
+		case v.packed == nil:
+			return "hashtable"
+		case v.packed.items == nil:
+			return "intset"
+		}
+		return "listpack"
+	case HashType:
+		if v.packed != nil {
+			return "listpack"
+		}
+		return "hashtable"
+	case ListType:
+		return "quicklist"
+	case ZSetType:
+		return "skiplist"
+	}
+	return "raw"
+}
+
+// newSetValue is an empty set in the most compact encoding s allows
+func (s *Store) newSetValue() Value {
+	if s.encoding.setIntset.Load() > 0 {
+		return Value{Type: SetType, packed: &packed{}}
+	}
+	if s.encoding.setListpack.Load() > 0 {
+		return Value{Type: SetType, packed: &packed{items: []string{}}}
+	}
+	return Value{Type: SetType, Set: make(map[string]struct{})}
+}
+
+// newHashValue is an empty hash in the most compact encoding s allows
+func (s *Store) newHashValue() Value {
+	if s.encoding.hashListpack.Load() > 0 {
+		return Value{Type: HashType, packed: &packed{items: []string{}}}
+	}
+	return Value{Type: HashType, Hash: make(map[string]string)}
+}
+
+// setInt parses m as an intset member: an integer written the way
+// strconv.FormatInt writes it, so it converts back to the same string
+func setInt(m string) (int64, bool) {
+	if m == "" || len(m) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(m, 10, 64)
+	return n, err == nil && strconv.FormatInt(n, 10) == m
+}
+
+func (v *Value) setLen() int {
+	switch {
+	case v.packed == nil:
+		return len(v.Set)
+	case v.packed.items == nil:
+		return len(v.packed.ints)
+	}
+	return len(v.packed.items)
+}
+
+func (v *Value) setHas(m string) bool {
+	switch {
+	case v.packed == nil:
+		_, ok := v.Set[m]
+		return ok
+	case v.packed.items == nil:
+		n, ok := setInt(m)
+		if !ok {
+			return false
+		}
+		_, found := slices.BinarySearch(v.packed.ints, n)
+		return found
+	}
+	return slices.Contains(v.packed.items, m)
+}
+
+// setMembers yields every member of a set
+func (v *Value) setMembers() iter.Seq[string] {
+	return func(yield func(string) bool) {
+		switch {
+		case v.packed == nil:
+			for m := range v.Set {
+				if !yield(m) {
+					return
+				}
+			}
+		case v.packed.items == nil:
+			for _, n := range v.packed.ints {
+				if !yield(strconv.FormatInt(n, 10)) {
+					return
+				}
+			}
+		default:
+			for _, m := range v.packed.items {
+				if !yield(m) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// setAdd adds m to the set v, moving it to a larger encoding if m does not
+// fit its current one, and reports whether m is new. Callers hold s.mu.
+func (s *Store) setAdd(v *Value, m string) bool {
+	if v.setHas(m) {
+		return false
+	}
+	p := v.packed
+	if p != nil && p.items == nil {
+		if n, ok := setInt(m); ok && int64(len(p.ints)) < s.encoding.setIntset.Load() {
+			i, _ := slices.BinarySearch(p.ints, n)
+			p.ints = slices.Insert(p.ints, i, n)
+			return true
+		}
+		if int64(len(p.ints)) < s.encoding.setListpack.Load() && s.fitsSetListpack(m) {
+			items := make([]string, 0, len(p.ints)+1)
+			for _, n := range p.ints {
+				items = append(items, strconv.FormatInt(n, 10))
+			}
+			p.ints, p.items = nil, items
+		} else {
+			v.unpackSet()
+		}
+	} else if p != nil && (int64(len(p.items)) >= s.encoding.setListpack.Load() || !s.fitsSetListpack(m)) {
+		v.unpackSet()
+	}
+	m = s.intern(m)
+	if v.packed != nil {
+		v.packed.items = append(v.packed.items, m)
+	} else {
+		v.Set[m] = struct{}{}
+	}
+	return true
+}
+
+func (s *Store) fitsSetListpack(m string) bool {
+	return int64(len(m)) <= s.encoding.setValue.Load()
+}
+
+// setRemove removes m from the set v and reports whether it was there
+func (v *Value) setRemove(m string) bool {
+	switch {
+	case v.packed == nil:
+		if _, ok := v.Set[m]; !ok {
+			return false
+		}
+		delete(v.Set, m)
+	case v.packed.items == nil:
+		n, ok := setInt(m)
+		if !ok {
+			return false
+		}
+		i, found := slices.BinarySearch(v.packed.ints, n)
+		if !found {
+			return false
+		}
+		v.packed.ints = slices.Delete(v.packed.ints, i, i+1)
+	default:
+		i := slices.Index(v.packed.items, m)
+		if i < 0 {
+			return false
+		}
+		v.packed.items = slices.Delete(v.packed.items, i, i+1)
+	}
+	return true
+}
+
+// unpackSet moves a packed set to the hashtable encoding
+func (v *Value) unpackSet() {
+	set := make(map[string]struct{}, v.setLen()+1)
+	for m := range v.setMembers() {
+		set[m] = struct{}{}
+	}
+	v.Set, v.packed = set, nil
+}
+
+func (v *Value) hashLen() int {
+	if v.packed != nil {
+		return len(v.packed.items) / 2
+	}
+	return len(v.Hash)
+}
+
+// hashIndex is the position of field f in a listpack hash, or -1
+func (p *packed) hashIndex(f string) int {
+	for i := 0; i < len(p.items); i += 2 {
+		if p.items[i] == f {
+			return i
+		}
+	}
+	return -1
+}
+
+func (v *Value) hashGet(f string) (string, bool) {
+	if v.packed == nil {
+		val, ok := v.Hash[f]
+		return val, ok
+	}
+	if i := v.packed.hashIndex(f); i >= 0 {
+		return v.packed.items[i+1], true
+	}
+	return "", false
+}
+
+// hashFields yields every field of a hash with its value
+func (v *Value) hashFields() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		if v.packed == nil {
+			for f, val := range v.Hash {
+				if !yield(f, val) {
+					return
+				}
+			}
+			return
+		}
+		for i := 0; i+1 < len(v.packed.items); i += 2 {
+			if !yield(v.packed.items[i], v.packed.items[i+1]) {
+				return
+			}
+		}
+	}
+}
+
+// hashSet sets field f of the hash v, moving it to the hashtable encoding
+// if the hash outgrows its listpack, and reports whether f is new. Callers
+// hold s.mu.
+func (s *Store) hashSet(v *Value, f, val string) bool {
+	if p := v.packed; p != nil {
+		i := p.hashIndex(f)
+		fits := int64(max(len(f), len(val))) <= s.encoding.hashValue.Load()
+		switch {
+		case i >= 0 && fits:
+			p.items[i+1] = val
+			return false
+		case i < 0 && fits && int64(len(p.items)/2) < s.encoding.hashListpack.Load():
+			p.items = append(p.items, s.intern(f), val)
+			return true
+		}
+		v.unpackHash()
+	}
+	_, exists := v.Hash[f]
+	v.Hash[s.intern(f)] = val
+	return !exists
+}
+
+// hashRemove removes field f from the hash v and reports whether it was there
+func (v *Value) hashRemove(f string) bool {
+	if v.packed == nil {
+		if _, ok := v.Hash[f]; !ok {
+			return false
+		}
+		delete(v.Hash, f)
+		return true
+	}
+	i := v.packed.hashIndex(f)
+	if i < 0 {
+		return false
+	}
+	v.packed.items = slices.Delete(v.packed.items, i, i+2)
+	return true
+}
+
+// unpackHash moves a listpack hash to the hashtable encoding
+func (v *Value) unpackHash() {
+	hash := make(map[string]string, v.hashLen()+1)
+	for f, val := range v.hashFields() {
+		hash[f] = val
+	}
+	v.Hash, v.packed = hash, nil
+}
+
+// unpacked returns v with a packed set or hash in maps, the form
+// snapshots and payloads are written in. v itself is left as it is.
+func (v Value) unpacked() Value {
+	switch {
+	case v.packed == nil:
+	case v.Type == SetType:
+		v.unpackSet()
+	case v.Type == HashType:
+		v.unpackHash()
+	}
+	return v
+}
+
+// pack moves a set or hash held in a map to a compact encoding if it is
+// small enough for one, for values arriving from outside the store.
+// Callers hold s.mu.
+func (s *Store) pack(v *Value) {
+	if v.packed != nil {
+		return
+	}
+	switch v.Type {
+	case SetType:
+		if v.Set == nil {
+			return
+		}
+		p := s.newSetValue()
+		if p.packed == nil || int64(len(v.Set)) > max(s.encoding.setIntset.Load(), s.encoding.setListpack.Load()) {
+			return
+		}
+		for m := range v.Set {
+			s.setAdd(&p, m)
+		}
+		if p.packed != nil {
+			v.Set, v.packed = nil, p.packed
+		}
+	case HashType:
+		if v.Hash == nil || int64(len(v.Hash)) > s.encoding.hashListpack.Load() {
+			return
+		}
+		p := s.newHashValue()
+		for f, val := range v.Hash {
+			s.hashSet(&p, f, val)
+		}
+		if p.packed != nil {
+			v.Hash, v.packed = nil, p.packed
+		}
+	}
+}
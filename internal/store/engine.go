@@ -0,0 +1,222 @@
+package store
+
+import (
+	"fmt"
+	"iter"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Engine holds a store's keys and values. The store serializes access:
+// Put, Delete, Clear and Compact run under its write lock, Get, Len and All
+// under at least its read lock, so engines only need to guard state that
+// Get itself changes.
+//
+// Store methods may change a Value's maps and slices in place after Get;
+// accountKey puts every written key back, so an engine sees the final value
+// before the write lock is released.
+type Engine interface {
+	Name() string
+	Get(key string) (Value, bool)
+	Put(key string, v Value)
+	Delete(key string)
+	Len() int
+	// All yields every key in no particular order. The store may Put or
+	// Delete the yielded key while iterating.
+	All() iter.Seq2[string, Value]
+	Clear()
+	// Compact releases space left behind by deleted keys
+	Compact()
+	Close() error
+}
+
+const (
+	MemoryEngine = "memory"
+	DiskEngine   = "disk"
+	TieredEngine = "tiered"
+)
+
+// CheckEngine reports whether name is a known engine
+func CheckEngine(name string) error {
+	if name != MemoryEngine && name != DiskEngine && name != TieredEngine {
+		return fmt.Errorf("unknown storage engine %q (want memory, disk or tiered)", name)
+	}
+	return nil
+}
+
+// EngineConfig selects the engine behind each shard's store
+type EngineConfig struct {
+	Engine    string // default engine, MemoryEngine if empty
+	Dir       string // where disk engines keep their files
+	CacheKeys int    // values a disk engine keeps decoded in memory
+	// TierIdle is how long a tiered engine keeps an unused value in memory
+	TierIdle time.Duration
+	// Namespaces maps a key prefix (the part before the first ':') to the
+	// engine for its keys, overriding Engine
+	Namespaces map[string]string
+}
+
+// OpenEngine creates the engine for the store named name, e.g. a shard ID
+func OpenEngine(cfg EngineConfig, name string) (Engine, error) {
+	def, err := openEngine(cfg, cfg.Engine, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Namespaces) == 0 {
+		return def, nil
+	}
+	e := &namespaceEngine{def: def, spaces: make(map[string]Engine, len(cfg.Namespaces))}
+	for ns, engine := range cfg.Namespaces {
+		sub, err := openEngine(cfg, engine, name+"-"+ns)
+		if err != nil {
+			e.Close()
+			return nil, err
+		}
+		e.spaces[ns] = sub
+	}
+	return e, nil
+}
+
+func openEngine(cfg EngineConfig, engine, name string) (Engine, error) {
+	switch engine {
+	case "", MemoryEngine:
+		return newMemoryEngine(), nil
+	case DiskEngine, TieredEngine:
+		if name == "" || filepath.Base(name) != name || name == ".." {
+			return nil, fmt.Errorf("invalid store name %q for a %s engine", name, engine)
+		}
+		path := filepath.Join(cfg.Dir, name+".spill")
+		if engine == TieredEngine {
+			return openTieredEngine(path, cfg.TierIdle)
+		}
+		return openDiskEngine(path, cfg.CacheKeys)
+	}
+	return nil, CheckEngine(engine)
+}
+
+// memoryEngine is the default engine, a plain map
+type memoryEngine struct {
+	data map[string]Value
+}
+
+func newMemoryEngine() *memoryEngine {
+	return &memoryEngine{data: make(map[string]Value)}
+}
+
+func (e *memoryEngine) Name() string { return MemoryEngine }
+
+func (e *memoryEngine) Get(key string) (Value, bool) {
+	v, ok := e.data[key]
+	return v, ok
+}
+
+func (e *memoryEngine) Put(key string, v Value) { e.data[key] = v }
+func (e *memoryEngine) Delete(key string)       { delete(e.data, key) }
+func (e *memoryEngine) Len() int                { return len(e.data) }
+
+// All ranges over the map, so the order is random, which eviction and
+// defrag rely on for sampling
+func (e *memoryEngine) All() iter.Seq2[string, Value] {
+	return func(yield func(string, Value) bool) {
+		for k, v := range e.data {
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (e *memoryEngine) Clear() { e.data = make(map[string]Value) }
+
+// Compact copies the map, since Go maps never shrink their buckets
+func (e *memoryEngine) Compact() {
+	data := make(map[string]Value, len(e.data))
+	for k, v := range e.data {
+		data[k] = v
+	}
+	e.data = data
+}
+
+func (e *memoryEngine) Close() error { return nil }
+
+// namespaceEngine routes keys to an engine by namespace, the key prefix
+// before the first ':' past any database prefix; other keys go to def
+type namespaceEngine struct {
+	def    Engine
+	spaces map[string]Engine
+}
+
+func (e *namespaceEngine) route(key string) Engine {
+	_, name := KeyDB(key)
+	if ns, _, ok := strings.Cut(name, ":"); ok {
+		if sub, ok := e.spaces[ns]; ok {
+			return sub
+		}
+	}
+	return e.def
+}
+
+// each calls fn for the default engine and then every namespace engine
+func (e *namespaceEngine) each(fn func(Engine)) {
+	fn(e.def)
+	for _, sub := range e.spaces {
+		fn(sub)
+	}
+}
+
+// Name lists the engines in use, e.g. "memory,archive=disk"
+func (e *namespaceEngine) Name() string {
+	names := make([]string, 0, len(e.spaces))
+	for ns, sub := range e.spaces {
+		names = append(names, ns+"="+sub.Name())
+	}
+	sort.Strings(names)
+	return e.def.Name() + "," + strings.Join(names, ",")
+}
+
+func (e *namespaceEngine) Get(key string) (Value, bool) { return e.route(key).Get(key) }
+func (e *namespaceEngine) Put(key string, v Value)      { e.route(key).Put(key, v) }
+func (e *namespaceEngine) Delete(key string)            { e.route(key).Delete(key) }
+
+func (e *namespaceEngine) Len() int {
+	n := 0
+	e.each(func(sub Engine) { n += sub.Len() })
+	return n
+}
+
+func (e *namespaceEngine) All() iter.Seq2[string, Value] {
+	return func(yield func(string, Value) bool) {
+		if !e.allOf(e.def, yield) {
+			return
+		}
+		for _, sub := range e.spaces {
+			if !e.allOf(sub, yield) {
+				return
+			}
+		}
+	}
+}
+
+func (e *namespaceEngine) allOf(sub Engine, yield func(string, Value) bool) bool {
+	for k, v := range sub.All() {
+		if !yield(k, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *namespaceEngine) Clear()   { e.each(Engine.Clear) }
+func (e *namespaceEngine) Compact() { e.each(Engine.Compact) }
+
+func (e *namespaceEngine) Close() error {
+	var first error
+	e.each(func(sub Engine) {
+		if err := sub.Close(); err != nil && first == nil {
+			first = err
+		}
+	})
+	return first
+}
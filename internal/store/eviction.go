@@ -0,0 +1,342 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+// EvictionPolicy selects which keys are dropped once maxmemory is reached
+type EvictionPolicy int32
+
+const (
+	NoEviction    EvictionPolicy = iota // reject writes instead of evicting
+	AllKeysLRU                          // least recently used among all keys
+	AllKeysRandom                       // any key
+	VolatileLRU                         // least recently used among keys with a TTL
+	VolatileTTL                         // key with the nearest expiration
+)
+
+var evictionPolicyNames = map[EvictionPolicy]string{
+	NoEviction:    "noeviction",
+	AllKeysLRU:    "allkeys-lru",
+	AllKeysRandom: "allkeys-random",
+	VolatileLRU:   "volatile-lru",
+	VolatileTTL:   "volatile-ttl",
+}
+
+func (p EvictionPolicy) String() string {
+	return evictionPolicyNames[p]
+}
+
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	for p, n := range evictionPolicyNames {
+		if strings.EqualFold(name, n) {
+			return p, nil
+		}
+	}
+	return NoEviction, fmt.Errorf("unknown maxmemory policy %q", name)
+}
+
+// ParseMemorySize parses a byte count with an optional kb/mb/gb suffix
+func ParseMemorySize(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10}, {"b", 1}} {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, mult = strings.TrimSuffix(lower, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return n * mult, nil
+}
+
+// evictionSamples is how many keys are compared per eviction, as in Redis
+const evictionSamples = 5
+
+// memoryState holds approximate per-key memory accounting. sizes is guarded by
+// Store.mu; the counters are atomic so INFO can read them without the lock.
+type memoryState struct {
+	sizes     map[string]int64
+	used      atomic.Int64
+	maxmemory atomic.Int64
+	policy    atomic.Int32
+	evicted   atomic.Uint64
+	types     atomic.Uint32    // bitmask of ValueTypes that may be evicted, 0 = all
+	onEvict   func(key string) // set before the worker starts, see AddNode
+}
+
+// accountKey re-estimates the memory held by key after it was written or
+// removed, refiles it in the type index and puts the value back to the
+// engine, since writers may have changed it in place. Callers must hold s.mu.
+func (s *Store) accountKey(key string) {
+	s.account(key, true)
+}
+
+// account implements accountKey. A key seen for the first time raises a
+// "new" event unless notifyNew is false, as for keys arriving by migration.
+func (s *Store) account(key string, notifyNew bool) {
+	s.indexKey(key)
+	s.dirty.mark(key)
+	if s.memory.sizes == nil {
+		s.memory.sizes = make(map[string]int64)
+	}
+	old, known := s.memory.sizes[key]
+	var size int64
+	if v, ok := s.data.Get(key); ok {
+		if !known && notifyNew {
+			s.notify(EventNew, "new", key)
+		}
+		s.data.Put(key, v)
+		size = estimateSize(key, v)
+		s.memory.sizes[key] = size
+		s.slotIndex.track(key, true)
+	} else {
+		delete(s.memory.sizes, key)
+		s.slotIndex.track(key, false)
+	}
+	s.memory.used.Add(size - old)
+}
+
+// AccountKey is the locking form of accountKey, used by the shard worker
+// after each write
+func (s *Store) AccountKey(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accountKey(key)
+}
+
+// AccountWrite is AccountKey for a client's write, which also gives key
+// the TTL its policy calls for
+func (s *Store) AccountWrite(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applyTTLPolicy(key)
+	s.accountKey(key)
+}
+
+// UsedMemory returns the estimated bytes held by keys and values
+func (s *Store) UsedMemory() int64 {
+	return s.memory.used.Load()
+}
+
+// SetMaxMemory sets this store's memory budget; 0 means unlimited
+func (s *Store) SetMaxMemory(bytes int64, policy EvictionPolicy) {
+	s.memory.maxmemory.Store(bytes)
+	s.memory.policy.Store(int32(policy))
+}
+
+func (s *Store) MaxMemory() (int64, EvictionPolicy) {
+	return s.memory.maxmemory.Load(), EvictionPolicy(s.memory.policy.Load())
+}
+
+// SetEvictionTypes restricts eviction to keys of the given types; none means
+// any key may be evicted
+func (s *Store) SetEvictionTypes(types []ValueType) {
+	var mask uint32
+	for _, t := range types {
+		mask |= 1 << t
+	}
+	s.memory.types.Store(mask)
+}
+
+// evictable reports whether keys of type t may be evicted
+func (s *Store) evictable(t ValueType) bool {
+	mask := s.memory.types.Load()
+	return mask == 0 || mask&(1<<t) != 0
+}
+
+// eachEvictable calls fn for candidate keys in map order until it returns
+// false. With a type restriction only the matching type indexes are walked.
+// Callers must hold s.mu.
+func (s *Store) eachEvictable(fn func(k string, v Value) bool) {
+	if s.memory.types.Load() == 0 {
+		for k, v := range s.data.All() {
+			if !fn(k, v) {
+				return
+			}
+		}
+		return
+	}
+	for _, t := range valueTypes {
+		if !s.evictable(t) {
+			continue
+		}
+		for k := range s.types.keys[t] {
+			v, _ := s.data.Get(k)
+			if !fn(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// EvictedKeys returns how many keys were dropped to stay under maxmemory
+func (s *Store) EvictedKeys() uint64 {
+	return s.memory.evicted.Load()
+}
+
+func (s *Store) overMaxMemory() bool {
+	max := s.memory.maxmemory.Load()
+	return max > 0 && s.memory.used.Load() > max
+}
+
+// FreeMemory evicts keys per the configured policy until the store is back
+// under maxmemory. It returns ErrOOM if that is not possible.
+func (s *Store) FreeMemory() error {
+	if s.overMaxMemory() {
+		s.foldTouches() // so LRU sees recent reads
+	}
+	for s.overMaxMemory() {
+		if !s.EvictOne() {
+			return ErrOOM
+		}
+	}
+	return nil
+}
+
+// EvictShare evicts keys by the eviction policy until the store holds pct
+// percent less memory than it did, whatever maxmemory is, returning how
+// many keys went. It stops early when the policy allows no more evictions.
+func (s *Store) EvictShare(pct int) int {
+	target := s.memory.used.Load() * int64(100-min(max(pct, 0), 100)) / 100
+	s.foldTouches()
+	n := 0
+	for s.memory.used.Load() > target && s.EvictOne() {
+		n++
+	}
+	return n
+}
+
+// EvictShare runs EvictShare on every shard's worker, returning the keys
+// evicted in all
+func (ss *SharedStore) EvictShare(pct int) int {
+	n := 0
+	for _, r := range ss.Broadcast("EVICTSHARE", "", strconv.Itoa(pct)) {
+		if k, ok := r.(int); ok {
+			n += k
+		}
+	}
+	return n
+}
+
+// EVICTSHARE pct; internal, sent by SharedStore.EvictShare
+func (s *Shard) cmdEvictShare(req ShardRequest) {
+	pct, _ := strconv.Atoi(req.Args[0])
+	req.Reply <- s.Store.EvictShare(pct)
+}
+
+// EvictOne removes a single key chosen by the eviction policy from a small
+// random sample, like Redis's approximated LRU. It returns false when the
+// policy allows no eviction or there is no candidate.
+func (s *Store) EvictOne() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policy := EvictionPolicy(s.memory.policy.Load())
+	var victim string
+	switch policy {
+	case AllKeysRandom:
+		s.eachEvictable(func(k string, v Value) bool {
+			victim = k
+			return false
+		})
+	case AllKeysLRU:
+		var oldest int64
+		n := 0
+		s.eachEvictable(func(k string, v Value) bool {
+			if victim == "" || v.LastAccess < oldest {
+				victim, oldest = k, v.LastAccess
+			}
+			n++
+			return n < evictionSamples
+		})
+	case VolatileLRU, VolatileTTL:
+		var oldest int64
+		var soonest time.Time
+		n := 0
+		for k, exp := range s.ttl {
+			v, ok := s.data.Get(k)
+			if !ok || !s.evictable(v.Type) {
+				continue
+			}
+			switch {
+			case victim == "":
+				victim, oldest, soonest = k, v.LastAccess, exp
+			case policy == VolatileLRU && v.LastAccess < oldest:
+				victim, oldest = k, v.LastAccess
+			case policy == VolatileTTL && exp.Before(soonest):
+				victim, soonest = k, exp
+			}
+			if n++; n >= evictionSamples {
+				break
+			}
+		}
+	default:
+		return false
+	}
+
+	if victim == "" {
+		return false
+	}
+	s.data.Delete(victim)
+	s.clearTTL(victim)
+	s.accountKey(victim)
+	s.memory.evicted.Add(1)
+	s.notify(EventEvicted, "evicted", victim)
+	if s.memory.onEvict != nil {
+		s.memory.onEvict(victim)
+	}
+	return true
+}
+
+// SetMaxMemory splits a total memory budget evenly across the shards; shards
+// added later get a share when the budget is reapplied
+func (ss *SharedStore) SetMaxMemory(total int64, policy EvictionPolicy) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.maxmemory, ss.maxmemoryPolicy = total, policy
+	ss.applyMaxMemory()
+}
+
+// SetEvictionTypes restricts eviction on every shard to keys of the given
+// types; an empty list allows any key
+func (ss *SharedStore) SetEvictionTypes(types []ValueType) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.evictionTypes = types
+	ss.applyMaxMemory()
+}
+
+func (ss *SharedStore) MaxMemory() (int64, EvictionPolicy) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.maxmemory, ss.maxmemoryPolicy
+}
+
+// applyMaxMemory must be called with ss.mu held
+func (ss *SharedStore) applyMaxMemory() {
+	if len(ss.nodeShards) == 0 {
+		return
+	}
+	per := ss.maxmemory / int64(len(ss.nodeShards))
+	if ss.maxmemory > 0 && per == 0 {
+		per = 1
+	}
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetMaxMemory(per, ss.maxmemoryPolicy)
+		sh.Store.SetEvictionTypes(ss.evictionTypes)
+	}
+}
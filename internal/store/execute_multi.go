@@ -0,0 +1,143 @@
+package store
+
+import "sort"
+
+// KeyOp is one key's share of a multi-key command: the key and the
+// arguments that go with it, as a value does in MSET
+type KeyOp struct {
+	Key  string
+	Args []string
+}
+
+// KeyOps turns keys into ops without arguments
+func KeyOps(keys []string) []KeyOp {
+	ops := make([]KeyOp, len(keys))
+	for i, k := range keys {
+		ops[i] = KeyOp{Key: k}
+	}
+	return ops
+}
+
+// multiKeyBatch is a shard's reply to MULTIKEY: the reply of every op it
+// ran, by position in the batch, and the positions of the ops whose key the
+// shard no longer owns or has moved on mid-reshard, which it left alone
+type multiKeyBatch struct {
+	replies []interface{}
+	moved   []int
+}
+
+// ExecuteMulti runs cmd once per op and returns the replies in op order.
+// Ops are grouped by the shard owning their key and every shard runs its
+// group in one request, all shards at once, so a multi-key command costs a
+// round trip per shard rather than per key. Ops whose key changed owner
+// before its shard got to it go one at a time through Execute, which
+// follows the key to its new shard. One shard's ops run back to back in op
+// order; ops on different shards are not ordered against each other. Keys
+// sharing a hash tag share a slot and so a shard: ops on them run in one
+// request, with no other command on that shard between them.
+func (ss *SharedStore) ExecuteMulti(cmd string, ops []KeyOp) []interface{} {
+	if hk := ss.hotKeys(); hk != nil && !isReadOnlyCommand(cmd) {
+		for _, op := range ops {
+			ss.invalidateHotKey(hk, op.Key)
+		}
+		// a replica installed while the write was in flight must not survive it
+		defer func() {
+			for _, op := range ops {
+				ss.invalidateHotKey(hk, op.Key)
+			}
+		}()
+	}
+
+	// ops are grouped by slot first, so the owner of a slot is looked up
+	// once however many of its keys the command names
+	var single []int
+	bySlot := make(map[int][]int)
+	for i, op := range ops {
+		slot := ss.slotOf(op.Key)
+		bySlot[slot] = append(bySlot[slot], i)
+	}
+	groups := make(map[*Shard][]int)
+	ss.mu.RLock()
+	for slot, positions := range bySlot {
+		if sh, ok := ss.nodeShards[ss.slots.owner(slot)]; ok {
+			groups[sh] = append(groups[sh], positions...)
+		} else {
+			single = append(single, positions...)
+		}
+	}
+	ss.mu.RUnlock()
+	for _, positions := range groups {
+		sort.Ints(positions) // back in op order
+	}
+
+	type sent struct {
+		positions []int
+		reply     chan interface{}
+	}
+	pending := make([]sent, 0, len(groups))
+	for sh, positions := range groups {
+		batch := make([]KeyOp, len(positions))
+		for j, i := range positions {
+			batch[j] = ops[i]
+		}
+		req := ShardRequest{
+			Command:  "MULTIKEY",
+			Args:     []string{cmd},
+			Payload:  batch,
+			Reply:    make(chan interface{}, 1),
+			internal: true, // the shard checks ownership per key
+		}
+		sh.enqueue(req)
+		pending = append(pending, sent{positions, req.Reply})
+	}
+
+	replies := make([]interface{}, len(ops))
+	for _, p := range pending {
+		switch v := (<-p.reply).(type) {
+		case multiKeyBatch:
+			for j, r := range v.replies {
+				replies[p.positions[j]] = r
+			}
+			for _, j := range v.moved {
+				single = append(single, p.positions[j])
+			}
+		case error:
+			// the shard restarted mid-batch: every op it held fails alike
+			for _, i := range p.positions {
+				replies[i] = v
+			}
+		}
+	}
+	for _, i := range single {
+		replies[i] = ss.Execute(cmd, ops[i].Key, ops[i].Args...)
+	}
+	return replies
+}
+
+// MULTIKEY runs other shard commands, so it joins the table once the table
+// exists
+func init() {
+	shardCommands["MULTIKEY"] = shardCommand{shardInternal, (*Shard).cmdMultiKey}
+}
+
+// MULTIKEY cmd; internal, sent by ExecuteMulti with its ops as Payload
+func (s *Shard) cmdMultiKey(req ShardRequest) {
+	ops, _ := req.Payload.([]KeyOp)
+	batch := multiKeyBatch{replies: make([]interface{}, len(ops))}
+	reply := make(chan interface{}, 1)
+	for j, op := range ops {
+		if s.parent != nil {
+			if node, _ := s.parent.ownerOf(op.Key); node != "" && node != s.nodeID {
+				batch.moved = append(batch.moved, j)
+				continue
+			}
+			if _, ok := s.parent.askTarget(s, op.Key); ok {
+				batch.moved = append(batch.moved, j)
+				continue
+			}
+		}
+		s.exec(ShardRequest{Command: req.Args[0], Key: op.Key, Args: op.Args, Reply: reply})
+		batch.replies[j] = <-reply
+	}
+	req.Reply <- batch
+}
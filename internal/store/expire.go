@@ -0,0 +1,249 @@
+package store
+
+import (
+	"container/heap"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Keys past their TTL are deleted when a command touches them and by the
+// active expire cycle, which each shard worker runs between commands. The
+// keys with a TTL are kept in a min-heap by expiration, so a cycle deletes
+// exactly the keys that are due, earliest first, and the worker sleeps
+// until the next one is: expired events go out about when keys expire,
+// and a shard holding millions of TTLs that are far off spends nothing on
+// them. A cycle runs for at most a quarter of cleaner-interval, checking
+// the time every cleaner-sample-size keys; one cut short by that limit
+// means keys fall due faster than the cycle keeps up with, so the next
+// comes sooner. The worker wakes at least every cleaner-interval, as the
+// store's clock may jump, and less and less often without TTLs.
+
+const (
+	expireTimeFraction = 4  // a cycle takes at most interval/4
+	expireFastFactor   = 8  // a cut short cycle is followed after interval/8
+	expireIdleFactor   = 10 // a shard without TTLs waits up to 10 intervals
+)
+
+// ttlIndex is a min-heap of the keys with a TTL by expiration, with each
+// key's position so a changed or removed TTL is fixed in O(log n). Guarded
+// by Store.mu.
+type ttlIndex struct {
+	items []ttlItem
+	pos   map[string]int
+}
+
+type ttlItem struct {
+	key string
+	at  time.Time
+}
+
+func (x *ttlIndex) Len() int           { return len(x.items) }
+func (x *ttlIndex) Less(i, j int) bool { return x.items[i].at.Before(x.items[j].at) }
+
+func (x *ttlIndex) Swap(i, j int) {
+	x.items[i], x.items[j] = x.items[j], x.items[i]
+	x.pos[x.items[i].key] = i
+	x.pos[x.items[j].key] = j
+}
+
+func (x *ttlIndex) Push(v any) {
+	it := v.(ttlItem)
+	x.pos[it.key] = len(x.items)
+	x.items = append(x.items, it)
+}
+
+func (x *ttlIndex) Pop() any {
+	last := len(x.items) - 1
+	it := x.items[last]
+	x.items[last] = ttlItem{}
+	x.items = x.items[:last]
+	delete(x.pos, it.key)
+	return it
+}
+
+// set files key under expiration at, moving it if it is there already
+func (x *ttlIndex) set(key string, at time.Time) {
+	if x.pos == nil {
+		x.pos = make(map[string]int)
+	}
+	if i, ok := x.pos[key]; ok {
+		x.items[i].at = at
+		heap.Fix(x, i)
+		return
+	}
+	heap.Push(x, ttlItem{key, at})
+}
+
+func (x *ttlIndex) remove(key string) {
+	if i, ok := x.pos[key]; ok {
+		heap.Remove(x, i)
+	}
+}
+
+// next returns the key expiring first
+func (x *ttlIndex) next() (ttlItem, bool) {
+	if len(x.items) == 0 {
+		return ttlItem{}, false
+	}
+	return x.items[0], true
+}
+
+// rebuild copies the index into fresh storage, returning what deletes left
+// behind
+func (x *ttlIndex) rebuild() {
+	items := make([]ttlItem, len(x.items))
+	copy(items, x.items)
+	pos := make(map[string]int, len(x.pos))
+	for k, i := range x.pos {
+		pos[k] = i
+	}
+	x.items, x.pos = items, pos
+}
+
+// setTTL gives key an expiration. Every TTL change goes through setTTL and
+// clearTTL so the index stays in step with s.ttl. Callers hold s.mu.
+func (s *Store) setTTL(key string, at time.Time) {
+	s.ttl[key] = at
+	s.ttlKeys.set(key, at)
+	if s.ttlKeys.pos[key] == 0 && at.UnixNano() < s.cleaner.nextCycle.Load() {
+		// due before the worker means to look: wake it
+		select {
+		case s.cleaner.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// clearTTL removes key's expiration, if any. Callers hold s.mu.
+func (s *Store) clearTTL(key string) {
+	delete(s.ttl, key)
+	s.ttlKeys.remove(key)
+}
+
+// expire deletes key, which is past its TTL. Callers hold s.mu.
+func (s *Store) expire(key string) {
+	s.data.Delete(key)
+	s.clearTTL(key)
+	s.accountKey(key)
+	s.notify(EventExpired, "expired", key)
+	s.cleaner.stats.expired.Add(1)
+}
+
+// expiryStats count expirations and the work of the expire cycle
+type expiryStats struct {
+	expired    atomic.Uint64
+	cycles     atomic.Uint64
+	timeCapped atomic.Uint64 // cycles cut short by the time limit
+	cycleNanos atomic.Int64
+	stalePerc  atomic.Uint64 // float64 bits, see activeExpireCycle
+}
+
+// ExpiryStats reports expirations and the expire cycle's work
+type ExpiryStats struct {
+	Expired    uint64 // keys deleted for reaching their TTL
+	Cycles     uint64 // active expire cycles run
+	TimeCapped uint64 // cycles cut short by their time limit
+	CycleTime  time.Duration
+	StalePerc  float64 // estimated percentage of TTL keys already expired
+}
+
+// SetCleaner changes the active expiry sample size and interval at runtime
+func (s *Store) SetCleaner(sampleSize int, interval time.Duration) {
+	s.cleaner.sampleSize.Store(int64(sampleSize))
+	s.cleaner.interval.Store(int64(interval))
+	select {
+	case s.cleaner.wake <- struct{}{}:
+	default:
+	}
+}
+
+// expireInterval is the configured time between cycles
+func (s *Store) expireInterval() time.Duration {
+	if d := time.Duration(s.cleaner.interval.Load()); d > 0 {
+		return d
+	}
+	return 100 * time.Millisecond
+}
+
+// activeExpireCycle runs one expire cycle and returns how long to wait
+// before the next. Only the shard worker runs it.
+func (s *Store) activeExpireCycle() time.Duration {
+	interval := s.expireInterval()
+	batch := max(int(s.cleaner.sampleSize.Load()), 1)
+	start := time.Now()
+
+	s.mu.Lock()
+	now := s.now()
+	total := s.ttlKeys.Len()
+	expired := 0
+	capped := false
+	for {
+		it, ok := s.ttlKeys.next()
+		if !ok || it.at.After(now) {
+			break
+		}
+		s.expire(it.key)
+		if expired++; expired%batch == 0 && time.Since(start) > interval/expireTimeFraction {
+			capped = true
+			break
+		}
+	}
+	next, pending := s.ttlKeys.next()
+	s.mu.Unlock()
+
+	st := &s.cleaner.stats
+	st.cycles.Add(1)
+	st.cycleNanos.Add(int64(time.Since(start)))
+	if total > 0 {
+		// the share of TTL keys that were expired but still held, as a
+		// moving average, since one cycle says little
+		perc := 100 * float64(expired) / float64(total)
+		old := math.Float64frombits(st.stalePerc.Load())
+		st.stalePerc.Store(math.Float64bits(0.05*perc + 0.95*old))
+	}
+
+	switch {
+	case capped:
+		st.timeCapped.Add(1)
+		s.cleaner.delay = max(interval/expireFastFactor, time.Millisecond)
+	case !pending:
+		s.cleaner.delay = min(max(2*s.cleaner.delay, interval), expireIdleFactor*interval)
+	default:
+		s.cleaner.delay = min(max(next.at.Sub(now), time.Millisecond), interval)
+	}
+	s.cleaner.nextCycle.Store(now.Add(s.cleaner.delay).UnixNano())
+	return s.cleaner.delay
+}
+
+// ExpiryStats reports the store's expirations and expire cycles
+func (s *Store) ExpiryStats() ExpiryStats {
+	st := &s.cleaner.stats
+	return ExpiryStats{
+		Expired:    st.expired.Load(),
+		Cycles:     st.cycles.Load(),
+		TimeCapped: st.timeCapped.Load(),
+		CycleTime:  time.Duration(st.cycleNanos.Load()),
+		StalePerc:  math.Float64frombits(st.stalePerc.Load()),
+	}
+}
+
+// ExpiryStats sums the expirations and expire cycles of every shard; the
+// stale percentage is their average
+func (ss *SharedStore) ExpiryStats() ExpiryStats {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	var out ExpiryStats
+	for _, sh := range ss.nodeShards {
+		st := sh.Store.ExpiryStats()
+		out.Expired += st.Expired
+		out.Cycles += st.Cycles
+		out.TimeCapped += st.TimeCapped
+		out.CycleTime += st.CycleTime
+		out.StalePerc += st.StalePerc
+	}
+	if n := len(ss.nodeShards); n > 0 {
+		out.StalePerc /= float64(n)
+	}
+	return out
+}
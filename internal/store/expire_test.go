@@ -0,0 +1,92 @@
+package store
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTTLIndex(t *testing.T) {
+	base := time.Unix(1_000_000, 0)
+	at := func(s int) time.Time { return base.Add(time.Duration(s) * time.Second) }
+
+	var x ttlIndex
+	x.set("a", at(3))
+	x.set("b", at(1))
+	x.set("c", at(2))
+	x.set("d", at(5))
+	x.set("d", at(0)) // moved to the front
+	x.remove("c")
+	x.remove("missing")
+
+	var order []string
+	for {
+		for i, it := range x.items {
+			if x.pos[it.key] != i {
+				t.Fatalf("%s is at %d but indexed at %d", it.key, i, x.pos[it.key])
+			}
+		}
+		it, ok := x.next()
+		if !ok {
+			break
+		}
+		order = append(order, it.key)
+		x.remove(it.key)
+	}
+	if !reflect.DeepEqual(order, []string{"d", "b", "a"}) {
+		t.Errorf("keys came out as %v, want [d b a]", order)
+	}
+}
+
+func TestActiveExpireCycle(t *testing.T) {
+	s := NewStore()
+	clock := NewManualClock(time.Unix(1_000_000, 0))
+	s.SetClock(clock)
+	s.SetCleaner(20, 10*time.Second)
+
+	for i := 1; i <= 5; i++ {
+		s.Set("k"+strconv.Itoa(i), []byte("v"), time.Duration(i)*time.Second)
+	}
+	s.Set("forever", []byte("v"), 0)
+	held := func(key string) bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		_, ok := s.data.Get(key)
+		return ok
+	}
+
+	// exactly the keys that are due go, and the worker sleeps until the next
+	clock.Advance(2500 * time.Millisecond)
+	if d := s.activeExpireCycle(); d != 500*time.Millisecond {
+		t.Errorf("delay after the first cycle = %v, want 500ms", d)
+	}
+	for key, want := range map[string]bool{"k1": false, "k2": false, "k3": true, "k4": true, "k5": true, "forever": true} {
+		if held(key) != want {
+			t.Errorf("after 2.5s, %s held = %v, want %v", key, !want, want)
+		}
+	}
+	if n := s.ExpiryStats().Expired; n != 2 {
+		t.Errorf("Expired = %d, want 2", n)
+	}
+
+	// a key whose TTL is cleared leaves the index
+	s.Set("k3", []byte("v"), 0)
+	clock.Advance(10 * time.Second)
+	s.activeExpireCycle()
+	if !held("k3") || held("k4") || held("k5") {
+		t.Errorf("after 12.5s, k3 %v, k4 %v, k5 %v; want only k3", held("k3"), held("k4"), held("k5"))
+	}
+
+	// with no TTLs left the worker backs off, up to ten intervals
+	var d time.Duration
+	for i := 0; i < 10; i++ {
+		d = s.activeExpireCycle()
+	}
+	if d != 100*time.Second {
+		t.Errorf("idle delay = %v, want 100s", d)
+	}
+	if n := s.ttlKeys.Len(); n != 0 {
+		t.Errorf("%d keys left in the TTL index", n)
+	}
+}
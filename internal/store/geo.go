@@ -0,0 +1,562 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Geo sets are sorted sets whose scores are 52-bit geohashes, as in Redis:
+// a member's longitude and latitude, each scaled to 26 bits, with their bits
+// interleaved. Nearby members get nearby scores, so a search reads the score
+// ranges of the geohash cell around its center and the eight cells next to
+// it, sized so the nine cover the whole shape, and keeps the members within
+// the exact distance.
+const (
+	geoStep     = 26 // bits per coordinate
+	geoLonMin   = -180.0
+	geoLonMax   = 180.0
+	geoLatMin   = -85.05112878
+	geoLatMax   = 85.05112878
+	earthRadius = 6372797.560856 // meters, as Redis computes distances
+	mercatorMax = 20037726.37    // half the earth's circumference, in meters
+)
+
+var (
+	ErrGeoMember = errors.New("ERR could not decode requested zset member")
+	ErrGeoUnit   = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+)
+
+// GeoPoint is a position in degrees
+type GeoPoint struct {
+	Lon, Lat float64
+}
+
+// GeoMatch is a member found by GeoSearch
+type GeoMatch struct {
+	Member string
+	GeoPoint
+	Dist float64 // from the center, in the query's unit
+	Hash uint64
+}
+
+// GeoUnit returns the meters in one unit of a GEO command
+func GeoUnit(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, ErrGeoUnit
+}
+
+// parseGeoPoint reads a longitude and latitude, which must be in the range
+// geohashes cover
+func parseGeoPoint(lonArg, latArg string) (GeoPoint, error) {
+	lon, err1 := strconv.ParseFloat(lonArg, 64)
+	lat, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil {
+		return GeoPoint{}, ErrNotFloat
+	}
+	if lon < geoLonMin || lon > geoLonMax || lat < geoLatMin || lat > geoLatMax {
+		return GeoPoint{}, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return GeoPoint{Lon: lon, Lat: lat}, nil
+}
+
+// geohash encodes p at step bits per coordinate
+func geohash(p GeoPoint, step uint) uint64 {
+	scale := float64(uint64(1) << step)
+	lat := min(uint64((p.Lat-geoLatMin)/(geoLatMax-geoLatMin)*scale), uint64(scale)-1)
+	lon := min(uint64((p.Lon-geoLonMin)/(geoLonMax-geoLonMin)*scale), uint64(scale)-1)
+	return spreadBits(lat) | spreadBits(lon)<<1
+}
+
+// geoCell is the area a geohash of step bits per coordinate stands for
+func geoCell(hash uint64, step uint) (lo, hi GeoPoint) {
+	scale := float64(uint64(1) << step)
+	lat, lon := float64(squashBits(hash)), float64(squashBits(hash>>1))
+	lo = GeoPoint{
+		Lon: geoLonMin + lon/scale*(geoLonMax-geoLonMin),
+		Lat: geoLatMin + lat/scale*(geoLatMax-geoLatMin),
+	}
+	hi = GeoPoint{
+		Lon: geoLonMin + (lon+1)/scale*(geoLonMax-geoLonMin),
+		Lat: geoLatMin + (lat+1)/scale*(geoLatMax-geoLatMin),
+	}
+	return lo, hi
+}
+
+// geoDecode returns the position a score stands for, the center of its cell
+func geoDecode(score float64) GeoPoint {
+	lo, hi := geoCell(uint64(score), geoStep)
+	return GeoPoint{
+		Lon: min(max((lo.Lon+hi.Lon)/2, geoLonMin), geoLonMax),
+		Lat: min(max((lo.Lat+hi.Lat)/2, geoLatMin), geoLatMax),
+	}
+}
+
+// spreadBits moves the low 32 bits of v to the even bit positions
+func spreadBits(v uint64) uint64 {
+	v &= 0xFFFFFFFF
+	v = (v | v<<16) & 0x0000FFFF0000FFFF
+	v = (v | v<<8) & 0x00FF00FF00FF00FF
+	v = (v | v<<4) & 0x0F0F0F0F0F0F0F0F
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// squashBits undoes spreadBits
+func squashBits(v uint64) uint64 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0F0F0F0F0F0F0F0F
+	v = (v | v>>4) & 0x00FF00FF00FF00FF
+	v = (v | v>>8) & 0x0000FFFF0000FFFF
+	v = (v | v>>16) & 0x00000000FFFFFFFF
+	return v
+}
+
+// geoDistance is the great-circle distance between a and b in meters
+func geoDistance(a, b GeoPoint) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin((b.Lon - a.Lon) * math.Pi / 180 / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1)*math.Cos(lat2)*v*v))
+}
+
+// geoSearchStep picks the geohash precision whose cells are at least as
+// wide as radius around lat, so a cell and its neighbors cover the circle.
+// Cells narrow toward the poles, hence the coarser steps there.
+func geoSearchStep(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStep
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(min(max(step, 1), geoStep))
+}
+
+// geoSearchRanges returns the score ranges of the cell holding center and
+// its neighbors at step
+func geoSearchRanges(center GeoPoint, step uint) [][2]float64 {
+	lo, hi := geoCell(geohash(center, step), step)
+	mid := GeoPoint{Lon: (lo.Lon + hi.Lon) / 2, Lat: (lo.Lat + hi.Lat) / 2}
+	w, h := hi.Lon-lo.Lon, hi.Lat-lo.Lat
+	shift := 2 * (geoStep - step)
+	var hashes []uint64
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			p := GeoPoint{Lon: mid.Lon + float64(dx)*w, Lat: mid.Lat + float64(dy)*h}
+			if p.Lat < geoLatMin || p.Lat > geoLatMax {
+				continue
+			}
+			if p.Lon > geoLonMax {
+				p.Lon -= 360
+			} else if p.Lon < geoLonMin {
+				p.Lon += 360
+			}
+			if h := geohash(p, step); !slices.Contains(hashes, h) {
+				hashes = append(hashes, h)
+			}
+		}
+	}
+	ranges := make([][2]float64, len(hashes))
+	for i, h := range hashes {
+		ranges[i] = [2]float64{float64(h << shift), float64((h+1)<<shift - 1)}
+	}
+	return ranges
+}
+
+// GeoAddArgs are the options and members of a GEOADD
+type GeoAddArgs struct {
+	NX, XX, CH bool
+	Scores     map[string]float64 // geohash of each member
+}
+
+// ParseGeoAdd reads GEOADD's arguments after the key:
+// [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func ParseGeoAdd(args []string) (GeoAddArgs, error) {
+	a := GeoAddArgs{Scores: map[string]float64{}}
+	i := 0
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			a.NX = true
+			continue
+		case "XX":
+			a.XX = true
+			continue
+		case "CH":
+			a.CH = true
+			continue
+		}
+		break
+	}
+	if a.NX && a.XX {
+		return a, errors.New("ERR XX and NX options at the same time are not compatible")
+	}
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%3 != 0 {
+		return a, errors.New("ERR syntax error")
+	}
+	for j := 0; j < len(rest); j += 3 {
+		p, err := parseGeoPoint(rest[j], rest[j+1])
+		if err != nil {
+			return a, err
+		}
+		a.Scores[rest[j+2]] = float64(geohash(p, geoStep))
+	}
+	return a, nil
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member ..., returns the members
+// added, plus those moved with CH
+func (s *Store) GeoAdd(key string, a GeoAddArgs) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expired(key)
+	val, ok := s.data.Get(key)
+	if !ok {
+		if a.XX {
+			return 0, nil
+		}
+		val = newZSetValue()
+	}
+	if val.Type != ZSetType {
+		return 0, ErrWrongType
+	}
+	members := make(map[string]float64, len(a.Scores))
+	for m, score := range a.Scores {
+		if _, exists := val.ZSet[m]; exists && a.NX || !exists && a.XX {
+			continue
+		}
+		members[m] = score
+	}
+	if len(members) == 0 {
+		return 0, nil
+	}
+	added, changed, err := s.zadd(key, val, members)
+	if a.CH {
+		added += changed
+	}
+	return added, err
+}
+
+// geoSet returns key's sorted set, a zero Value if there is none. Callers
+// hold s.mu.RLock.
+func (s *Store) geoSet(key string) (Value, error) {
+	if s.expiredRead(key) {
+		return Value{}, nil
+	}
+	val, ok := s.lookupRead(key)
+	if !ok {
+		return Value{}, nil
+	}
+	if val.Type != ZSetType {
+		return Value{}, ErrWrongType
+	}
+	s.touch(key)
+	return val, nil
+}
+
+// GEOPOS key member ..., nil for missing members
+func (s *Store) GeoPos(key string, members []string) ([]*GeoPoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	val, err := s.geoSet(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*GeoPoint, len(members))
+	for i, m := range members {
+		if score, ok := val.ZSet[m]; ok {
+			p := geoDecode(score)
+			out[i] = &p
+		}
+	}
+	return out, nil
+}
+
+// GEODIST key member1 member2, in meters; false if either is missing
+func (s *Store) GeoDist(key, a, b string) (float64, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	val, err := s.geoSet(key)
+	if err != nil {
+		return 0, false, err
+	}
+	sa, ok1 := val.ZSet[a]
+	sb, ok2 := val.ZSet[b]
+	if !ok1 || !ok2 {
+		return 0, false, nil
+	}
+	return geoDistance(geoDecode(sa), geoDecode(sb)), true, nil
+}
+
+// GeoQuery is a parsed GEOSEARCH
+type GeoQuery struct {
+	FromMember string // FROMMEMBER, or the center is From
+	From       GeoPoint
+	Radius     float64 // BYRADIUS, in meters
+	Width      float64 // BYBOX, in meters; zero for BYRADIUS
+	Height     float64
+	Unit       float64 // meters per unit of the query
+	Count      int     // 0 = all
+	Any        bool    // stop at Count matches rather than the nearest Count
+	Sort       int     // 1 ascending by distance, -1 descending, 0 unsorted
+
+	WithCoord, WithDist, WithHash bool
+}
+
+// ParseGeoSearch reads GEOSEARCH's arguments after the key:
+// FROMMEMBER member | FROMLONLAT longitude latitude,
+// BYRADIUS radius unit | BYBOX width height unit,
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func ParseGeoSearch(args []string) (GeoQuery, error) {
+	var q GeoQuery
+	from, by := 0, 0
+	syntax := errors.New("ERR syntax error")
+	for i := 0; i < len(args); i++ {
+		left := len(args) - i - 1
+		switch strings.ToUpper(args[i]) {
+		case "FROMMEMBER":
+			if left < 1 {
+				return q, syntax
+			}
+			q.FromMember = args[i+1]
+			from++
+			i++
+		case "FROMLONLAT":
+			if left < 2 {
+				return q, syntax
+			}
+			p, err := parseGeoPoint(args[i+1], args[i+2])
+			if err != nil {
+				return q, err
+			}
+			q.From = p
+			from++
+			i += 2
+		case "BYRADIUS":
+			if left < 2 {
+				return q, syntax
+			}
+			r, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil || r < 0 {
+				return q, errors.New("ERR need numeric radius")
+			}
+			if q.Unit, err = GeoUnit(args[i+2]); err != nil {
+				return q, err
+			}
+			q.Radius = r * q.Unit
+			by++
+			i += 2
+		case "BYBOX":
+			if left < 3 {
+				return q, syntax
+			}
+			w, err1 := strconv.ParseFloat(args[i+1], 64)
+			h, err2 := strconv.ParseFloat(args[i+2], 64)
+			if err1 != nil || err2 != nil || w < 0 || h < 0 {
+				return q, errors.New("ERR need numeric width and height")
+			}
+			var err error
+			if q.Unit, err = GeoUnit(args[i+3]); err != nil {
+				return q, err
+			}
+			q.Width, q.Height = w*q.Unit, h*q.Unit
+			by++
+			i += 3
+		case "ASC":
+			q.Sort = 1
+		case "DESC":
+			q.Sort = -1
+		case "COUNT":
+			if left < 1 {
+				return q, syntax
+			}
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n <= 0 {
+				return q, errors.New("ERR COUNT must be > 0")
+			}
+			q.Count = n
+			i++
+			if left > 1 && strings.EqualFold(args[i+1], "ANY") {
+				q.Any = true
+				i++
+			}
+		case "WITHCOORD":
+			q.WithCoord = true
+		case "WITHDIST":
+			q.WithDist = true
+		case "WITHHASH":
+			q.WithHash = true
+		default:
+			return q, syntax
+		}
+	}
+	if from != 1 {
+		return q, errors.New("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if by != 1 {
+		return q, errors.New("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+	if q.Any && q.Count == 0 {
+		return q, errors.New("ERR the ANY argument requires COUNT argument")
+	}
+	if q.Count > 0 && q.Sort == 0 && !q.Any {
+		q.Sort = 1 // the nearest Count, as Redis returns
+	}
+	return q, nil
+}
+
+// within reports whether p is inside the query's shape around center and
+// returns its distance from center in meters
+func (q *GeoQuery) within(center, p GeoPoint) (float64, bool) {
+	if q.Width == 0 && q.Height == 0 {
+		d := geoDistance(center, p)
+		return d, d <= q.Radius
+	}
+	// north-south, then east-west along p's own parallel
+	if earthRadius*math.Abs(p.Lat-center.Lat)*math.Pi/180 > q.Height/2 {
+		return 0, false
+	}
+	if geoDistance(GeoPoint{Lon: center.Lon, Lat: p.Lat}, p) > q.Width/2 {
+		return 0, false
+	}
+	return geoDistance(center, p), true
+}
+
+// GEOSEARCH key ..., the members inside the query's circle or box
+func (s *Store) GeoSearch(key string, q GeoQuery) ([]GeoMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	val, err := s.geoSet(key)
+	if err != nil || val.ZSet == nil {
+		return nil, err
+	}
+	center := q.From
+	if q.FromMember != "" {
+		score, ok := val.ZSet[q.FromMember]
+		if !ok {
+			return nil, ErrGeoMember
+		}
+		center = geoDecode(score)
+	}
+	radius := q.Radius
+	if q.Width > 0 || q.Height > 0 {
+		radius = math.Hypot(q.Width/2, q.Height/2)
+	}
+
+	var out []GeoMatch
+scan:
+	for _, r := range geoSearchRanges(center, geoSearchStep(radius, center.Lat)) {
+		for _, e := range val.ZSL.RangeByScore(r[0], r[1]) {
+			p := geoDecode(e.Score)
+			d, ok := q.within(center, p)
+			if !ok {
+				continue
+			}
+			out = append(out, GeoMatch{Member: e.Member, GeoPoint: p, Dist: d / q.Unit, Hash: uint64(e.Score)})
+			if q.Any && len(out) == q.Count {
+				break scan
+			}
+		}
+	}
+	if q.Sort != 0 {
+		slices.SortFunc(out, func(a, b GeoMatch) int {
+			if q.Sort < 0 {
+				a, b = b, a
+			}
+			switch {
+			case a.Dist < b.Dist:
+				return -1
+			case a.Dist > b.Dist:
+				return 1
+			}
+			return strings.Compare(a.Member, b.Member)
+		})
+	}
+	if q.Count > 0 && len(out) > q.Count {
+		out = out[:q.Count]
+	}
+	return out, nil
+}
+
+func (s *Shard) cmdGeoAdd(req ShardRequest) {
+	a, err := ParseGeoAdd(req.Args)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	n, err := s.Store.GeoAdd(req.Key, a)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
+
+func (s *Shard) cmdGeoPos(req ShardRequest) {
+	pos, err := s.Store.GeoPos(req.Key, req.Args)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- pos
+}
+
+// cmdGeoDist replies the distance in meters, or nil
+func (s *Shard) cmdGeoDist(req ShardRequest) {
+	if len(req.Args) < 2 {
+		req.Reply <- fmt.Errorf("GEODIST requires two members")
+		return
+	}
+	d, ok, err := s.Store.GeoDist(req.Key, req.Args[0], req.Args[1])
+	switch {
+	case err != nil:
+		req.Reply <- err
+	case !ok:
+		req.Reply <- nil
+	default:
+		req.Reply <- d
+	}
+}
+
+func (s *Shard) cmdGeoSearch(req ShardRequest) {
+	q, err := ParseGeoSearch(req.Args)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	matches, err := s.Store.GeoSearch(req.Key, q)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- matches
+}
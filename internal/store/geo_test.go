@@ -0,0 +1,110 @@
+package store
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// sicily adds the members of the Redis GEO examples
+func sicily(t *testing.T) *Store {
+	t.Helper()
+	s := NewStore()
+	a, err := ParseGeoAdd(strings.Fields("13.361389 38.115556 Palermo 15.087269 37.502669 Catania"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.GeoAdd("Sicily", a); n != 2 || err != nil {
+		t.Fatalf("GeoAdd = %d, %v", n, err)
+	}
+	return s
+}
+
+func TestGeoAddPos(t *testing.T) {
+	s := sicily(t)
+
+	pos, _ := s.GeoPos("Sicily", []string{"Palermo", "missing"})
+	if pos[1] != nil {
+		t.Errorf("GeoPos of a missing member = %v", pos[1])
+	}
+	// a 52-bit geohash keeps positions to well under a meter
+	if p := pos[0]; p == nil || math.Abs(p.Lon-13.361389) > 1e-5 || math.Abs(p.Lat-38.115556) > 1e-5 {
+		t.Errorf("GeoPos(Palermo) = %v", p)
+	}
+
+	d, ok, _ := s.GeoDist("Sicily", "Palermo", "Catania")
+	if !ok || math.Abs(d-166274.1516) > 0.01 {
+		t.Errorf("GeoDist = %.4f, %v; want 166274.1516", d, ok)
+	}
+	if _, ok, _ := s.GeoDist("Sicily", "Palermo", "missing"); ok {
+		t.Error("GeoDist found a missing member")
+	}
+
+	// NX leaves Palermo where it is, CH counts the move of Catania
+	a, _ := ParseGeoAdd(strings.Fields("NX 0 0 Palermo"))
+	if n, _ := s.GeoAdd("Sicily", a); n != 0 {
+		t.Errorf("GEOADD NX of an existing member = %d", n)
+	}
+	a, _ = ParseGeoAdd(strings.Fields("XX CH 15 37 Catania 1 1 Nowhere"))
+	if n, _ := s.GeoAdd("Sicily", a); n != 1 {
+		t.Errorf("GEOADD XX CH = %d, want 1", n)
+	}
+	if pos, _ := s.GeoPos("Sicily", []string{"Nowhere"}); pos[0] != nil {
+		t.Error("GEOADD XX added a new member")
+	}
+
+	for _, bad := range []string{"NX XX 1 1 m", "1 1", "181 0 m", "0 86 m", "x 0 m"} {
+		if _, err := ParseGeoAdd(strings.Fields(bad)); err == nil {
+			t.Errorf("ParseGeoAdd(%q) was accepted", bad)
+		}
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	s := sicily(t)
+
+	tests := []struct {
+		query string
+		want  []string
+		dists []float64
+	}{
+		{"FROMLONLAT 15 37 BYRADIUS 200 km ASC", []string{"Catania", "Palermo"}, []float64{56.4413, 190.4424}},
+		{"FROMLONLAT 15 37 BYRADIUS 200 km DESC", []string{"Palermo", "Catania"}, []float64{190.4424, 56.4413}},
+		{"FROMLONLAT 15 37 BYRADIUS 100 km", []string{"Catania"}, []float64{56.4413}},
+		{"FROMLONLAT 15 37 BYBOX 400 400 km ASC", []string{"Catania", "Palermo"}, []float64{56.4413, 190.4424}},
+		{"FROMLONLAT 15 37 BYRADIUS 200 km COUNT 1", []string{"Catania"}, []float64{56.4413}},
+		{"FROMMEMBER Palermo BYRADIUS 1 m", []string{"Palermo"}, []float64{0}},
+	}
+	for _, tt := range tests {
+		q, err := ParseGeoSearch(strings.Fields(tt.query))
+		if err != nil {
+			t.Fatalf("ParseGeoSearch(%q): %v", tt.query, err)
+		}
+		matches, _ := s.GeoSearch("Sicily", q)
+		if len(matches) != len(tt.want) {
+			t.Errorf("GEOSEARCH %s = %v, want %v", tt.query, matches, tt.want)
+			continue
+		}
+		for i, m := range matches {
+			if m.Member != tt.want[i] || math.Abs(m.Dist-tt.dists[i]) > 0.001 {
+				t.Errorf("GEOSEARCH %s [%d] = %s at %.4f, want %s at %.4f", tt.query, i, m.Member, m.Dist, tt.want[i], tt.dists[i])
+			}
+		}
+	}
+
+	if _, err := s.GeoSearch("Sicily", GeoQuery{FromMember: "missing", Radius: 1, Unit: 1}); err != ErrGeoMember {
+		t.Errorf("GEOSEARCH from a missing member = %v", err)
+	}
+	for _, bad := range []string{
+		"BYRADIUS 1 km",
+		"FROMLONLAT 15 37",
+		"FROMLONLAT 15 37 FROMMEMBER Palermo BYRADIUS 1 km",
+		"FROMLONLAT 15 37 BYRADIUS 1 parsecs",
+		"FROMLONLAT 15 37 BYRADIUS 1 km COUNT 0",
+		"FROMLONLAT 15 37 BYRADIUS 1 km ANY",
+	} {
+		if _, err := ParseGeoSearch(strings.Fields(bad)); err == nil {
+			t.Errorf("ParseGeoSearch(%q) was accepted", bad)
+		}
+	}
+}
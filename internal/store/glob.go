@@ -0,0 +1,148 @@
+package store
+
+import (
+	"errors"
+	"strings"
+)
+
+// Limits on matching, so a hostile KEYS or PSUBSCRIBE pattern can't keep a
+// CPU busy: commands refuse patterns longer than GlobMaxPattern, and one
+// match gives up, reporting no match, after globMaxSteps steps. Ordinary
+// patterns take about one step per byte of the subject.
+const (
+	GlobMaxPattern = 1024
+	globMaxSteps   = 1 << 16
+)
+
+var ErrPatternTooLong = errors.New("ERR pattern is too long")
+
+// CheckGlob rejects a pattern commands should not accept
+func CheckGlob(pattern string) error {
+	if len(pattern) > GlobMaxPattern {
+		return ErrPatternTooLong
+	}
+	return nil
+}
+
+// GlobMatch reports whether s matches a Redis-style glob: * and ? wildcards,
+// [abc], [^abc] and [a-z] classes, and \ to escape the next byte. Matching
+// is byte-wise, so keys and channels need not be valid UTF-8. It is the one
+// matcher behind KEYS, SCAN MATCH, PSUBSCRIBE, PUBSUB CHANNELS, CONFIG GET
+// and ACL key patterns.
+func GlobMatch(pattern, s string) bool {
+	meta := strings.IndexAny(pattern, `*?[\`)
+	switch {
+	case meta < 0:
+		return pattern == s
+	case meta == len(pattern)-1 && pattern[meta] == '*':
+		return strings.HasPrefix(s, pattern[:meta])
+	case len(pattern) > GlobMaxPattern:
+		return false
+	}
+	match, _ := globMatch(pattern, s, globMaxSteps)
+	return match
+}
+
+// GlobSteps matches like GlobMatch, without the fast paths, and also
+// returns the steps taken and whether the step limit cut the match short
+func GlobSteps(pattern, s string) (match bool, steps int, limited bool) {
+	match, steps = globMatch(pattern, s, globMaxSteps)
+	return match, steps, steps > globMaxSteps
+}
+
+// globMatch walks pattern and s together. On a mismatch it goes back to the
+// most recent * and lets it absorb one more byte; earlier stars never need
+// revisiting, so matching is O(len(pattern)*len(s)) at worst rather than
+// exponential. Past limit steps it gives up and reports no match.
+func globMatch(p, s string, limit int) (bool, int) {
+	pi, si := 0, 0
+	star, mark := -1, 0 // pattern position after the last *, and where its match ends
+	steps := 0
+	for si < len(s) {
+		if steps++; steps > limit {
+			return false, steps
+		}
+		if pi < len(p) {
+			switch p[pi] {
+			case '*':
+				for pi < len(p) && p[pi] == '*' {
+					pi++
+				}
+				if pi == len(p) {
+					return true, steps
+				}
+				star, mark = pi, si
+				continue
+			case '?':
+				pi++
+				si++
+				continue
+			case '[':
+				if n, ok := matchClass(p[pi:], s[si]); ok {
+					pi += n
+					si++
+					continue
+				}
+			case '\\':
+				// a trailing \ matches itself
+				lit := byte('\\')
+				if pi+1 < len(p) {
+					lit = p[pi+1]
+				}
+				if lit == s[si] {
+					pi += min(2, len(p)-pi)
+					si++
+					continue
+				}
+			default:
+				if p[pi] == s[si] {
+					pi++
+					si++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false, steps
+		}
+		mark++
+		pi, si = star, mark
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p), steps
+}
+
+// matchClass matches c against the class at the start of p, which begins
+// with '['. It returns the length of the class, up to and including the
+// closing ']' or to the end of p when there is none.
+func matchClass(p string, c byte) (int, bool) {
+	i := 1
+	negate := i < len(p) && p[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for i < len(p) && p[i] != ']' {
+		switch {
+		case p[i] == '\\' && i+1 < len(p):
+			matched = matched || p[i+1] == c
+			i += 2
+		case i+2 < len(p) && p[i+1] == '-' && p[i+2] != ']':
+			lo, hi := p[i], p[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			i += 3
+		default:
+			matched = matched || p[i] == c
+			i++
+		}
+	}
+	if i < len(p) {
+		i++ // closing ]
+	}
+	return i, matched != negate
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:", true},
+		{"user:*", "users", false},
+		{"*:1", "a:b:1", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"**x", "abx", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"[\\]]", "]", true},
+		{"a\\*b", "a*b", true},
+		{"a\\*b", "axb", false},
+		{"a\\", "a\\", true},
+		{"[abc", "b", true}, // an unclosed class runs to the end
+		{"\xff*", "\xff\xfe", true},
+	}
+	for _, tt := range tests {
+		if got := GlobMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("GlobMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+// TestGlobMatchBacktracking checks that patterns with many stars fail in
+// polynomial time on input that almost matches
+func TestGlobMatchBacktracking(t *testing.T) {
+	pattern := strings.Repeat("a*", 30) + "b"
+	s := strings.Repeat("a", 5000)
+	if GlobMatch(pattern, s) {
+		t.Errorf("GlobMatch matched without the trailing b")
+	}
+	if !GlobMatch(pattern, s+"b") {
+		t.Errorf("GlobMatch missed the trailing b")
+	}
+}
+
+func TestGlobStepLimit(t *testing.T) {
+	// an ordinary pattern takes about one step per byte
+	s := strings.Repeat("x", 1000)
+	if match, steps, limited := GlobSteps("x*x", s); !match || limited || steps > 2*len(s) {
+		t.Errorf("GlobSteps(x*x) = %v, %d steps, limited %v", match, steps, limited)
+	}
+
+	// a * that must retry at every byte gives up past the step limit
+	pattern := "*" + strings.Repeat("a", 500) + "b"
+	s = strings.Repeat("a", 5000)
+	match, steps, limited := GlobSteps(pattern, s+"b")
+	if match || !limited || steps != globMaxSteps+1 {
+		t.Errorf("GlobSteps = %v, %d steps, limited %v; want the limit to cut it short", match, steps, limited)
+	}
+	if GlobMatch(pattern, s+"b") {
+		t.Error("GlobMatch matched past the step limit")
+	}
+
+	long := strings.Repeat("?", GlobMaxPattern+1)
+	if CheckGlob(long) != ErrPatternTooLong || CheckGlob(long[1:]) != nil {
+		t.Errorf("CheckGlob does not stop at %d bytes", GlobMaxPattern)
+	}
+	if GlobMatch(long, strings.Repeat("k", len(long))) {
+		t.Error("GlobMatch accepted a pattern over the length limit")
+	}
+}
@@ -0,0 +1,337 @@
+package store
+
+import (
+	"hash/maphash"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"multithreaded-redis/internal/datastuctures"
+)
+
+// hotReplica is a read-only copy of a hot string key held by every shard
+type hotReplica struct {
+	data      []byte
+	expiresAt time.Time // zero => no TTL
+}
+
+// hotKeyStripes is how many parts the tracker's state is split into by key
+// hash. Every GET is counted, so one lock would serialize the reads of all
+// shards; a stripe's lock is only shared with the keys hashing alike.
+const hotKeyStripes = 32
+
+// hotKeySketchWidth is the width of each stripe's sketch. A stripe counts a
+// 1/hotKeyStripes share of the keys, so the stripes together estimate as
+// well as one sketch hotKeyStripes times as wide.
+const hotKeySketchWidth = 4096 / hotKeyStripes
+
+// hotKeyTracker counts GETs per key with a Count-Min Sketch that is reset
+// every window (a cheap LFU approximation). Keys crossing the threshold are
+// copied to all shards for a lease period so reads spread across workers.
+type hotKeyTracker struct {
+	threshold uint32
+	window    time.Duration
+	lease     time.Duration
+	seed      maphash.Seed
+	stripes   [hotKeyStripes]hotKeyStripe
+
+	rr          atomic.Uint64
+	replicaHits atomic.Uint64
+}
+
+// hotKeyStripe is the tracker's state for the keys hashing to it
+type hotKeyStripe struct {
+	mu        sync.Mutex
+	sketch    *datastuctures.CountMinSketch
+	windowEnd time.Time
+	hot       map[string]time.Time // key -> lease expiry
+	pending   map[string]bool      // replication in flight
+}
+
+// HotKeyStats is a snapshot of hot-key replication state
+type HotKeyStats struct {
+	HotKeys     []string
+	ReplicaHits uint64
+}
+
+// SetHotKeyPolicy enables automatic hot-key replication: keys read more than
+// threshold times within window are replicated to every shard. A threshold
+// of 0 disables replication.
+func (ss *SharedStore) SetHotKeyPolicy(threshold uint32, window time.Duration) {
+	ss.mu.Lock()
+	old := ss.hot
+	if threshold == 0 {
+		ss.hot = nil
+	} else {
+		ss.hot = newHotKeyTracker(threshold, window)
+	}
+	ss.mu.Unlock()
+
+	if old != nil {
+		for _, k := range old.hotKeys() {
+			ss.broadcastHotKey("HOTKEY_DEL", k, nil)
+		}
+	}
+}
+
+func newHotKeyTracker(threshold uint32, window time.Duration) *hotKeyTracker {
+	hk := &hotKeyTracker{
+		threshold: threshold,
+		window:    window,
+		lease:     5 * window,
+		seed:      maphash.MakeSeed(),
+	}
+	end := time.Now().Add(window)
+	for i := range hk.stripes {
+		st := &hk.stripes[i]
+		st.sketch = datastuctures.NewCountMinSketch(4, hotKeySketchWidth)
+		st.windowEnd = end
+		st.hot = make(map[string]time.Time)
+		st.pending = make(map[string]bool)
+	}
+	return hk
+}
+
+// stripe is the part of the tracker key belongs to
+func (hk *hotKeyTracker) stripe(key string) *hotKeyStripe {
+	return &hk.stripes[maphash.String(hk.seed, key)%hotKeyStripes]
+}
+
+// hotKeys lists the keys replicated now
+func (hk *hotKeyTracker) hotKeys() []string {
+	var keys []string
+	for i := range hk.stripes {
+		st := &hk.stripes[i]
+		st.mu.Lock()
+		for k := range st.hot {
+			keys = append(keys, k)
+		}
+		st.mu.Unlock()
+	}
+	return keys
+}
+
+// HotKeyStats reports the currently replicated keys
+func (ss *SharedStore) HotKeyStats() HotKeyStats {
+	ss.mu.RLock()
+	hk := ss.hot
+	ss.mu.RUnlock()
+	if hk == nil {
+		return HotKeyStats{}
+	}
+	return HotKeyStats{HotKeys: hk.hotKeys(), ReplicaHits: hk.replicaHits.Load()}
+}
+
+// hotKeys returns the active tracker, or nil when replication is disabled
+func (ss *SharedStore) hotKeys() *hotKeyTracker {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.hot
+}
+
+// trackHotKey runs before a command is routed. For GETs on a replicated key
+// it returns the replica value; for writes it invalidates the replicas first.
+func (ss *SharedStore) trackHotKey(hk *hotKeyTracker, cmd, key string) (interface{}, bool) {
+	if !isHotKeyRead(cmd) {
+		ss.invalidateHotKey(hk, key)
+		return nil, false
+	}
+	if strings.ToUpper(cmd) != "GET" {
+		return nil, false
+	}
+
+	if resp, ok := ss.readHotReplica(hk, key); ok {
+		return resp, true
+	}
+	if hk.observe(key) {
+		go ss.replicateHotKey(hk, key)
+	}
+	return nil, false
+}
+
+// isHotKeyRead reports whether cmd leaves replicas intact; every other command
+// touching the key is treated as a write and drops them
+func isHotKeyRead(cmd string) bool {
+	return isReadOnlyCommand(cmd)
+}
+
+// observe counts a read and reports whether the key just became hot
+func (hk *hotKeyTracker) observe(key string) bool {
+	st := hk.stripe(key)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+	if now.After(st.windowEnd) {
+		st.sketch = datastuctures.NewCountMinSketch(st.sketch.Depth, st.sketch.Width)
+		st.windowEnd = now.Add(hk.window)
+	}
+	st.sketch.Incr(key, 1)
+	if st.sketch.Query(key) < hk.threshold {
+		return false
+	}
+	if _, isHot := st.hot[key]; isHot || st.pending[key] {
+		return false
+	}
+	st.pending[key] = true
+	return true
+}
+
+func (ss *SharedStore) readHotReplica(hk *hotKeyTracker, key string) (interface{}, bool) {
+	st := hk.stripe(key)
+	st.mu.Lock()
+	lease, isHot := st.hot[key]
+	if isHot && time.Now().After(lease) {
+		// lease ran out: stop routing to replicas and let the key re-qualify
+		delete(st.hot, key)
+		st.mu.Unlock()
+		go ss.broadcastHotKey("HOTKEY_DEL", key, nil)
+		return nil, false
+	}
+	st.mu.Unlock()
+	if !isHot {
+		return nil, false
+	}
+
+	ss.mu.RLock()
+	shards := make([]*Shard, 0, len(ss.nodeShards))
+	for _, sh := range ss.nodeShards {
+		shards = append(shards, sh)
+	}
+	ss.mu.RUnlock()
+	if len(shards) == 0 {
+		return nil, false
+	}
+
+	sh := shards[hk.rr.Add(1)%uint64(len(shards))]
+	req := ShardRequest{
+		Command:  "HOTKEY_GET",
+		Key:      key,
+		Reply:    make(chan interface{}, 1),
+		internal: true,
+	}
+	sh.enqueue(req)
+	resp := <-req.Reply
+	data, ok := resp.([]byte)
+	if !ok {
+		return nil, false
+	}
+	hk.replicaHits.Add(1)
+	return data, true
+}
+
+// replicateHotKey copies the owner's value to every shard. Only string keys
+// are replicated; other types stay on their owner.
+func (ss *SharedStore) replicateHotKey(hk *hotKeyTracker, key string) {
+	st := hk.stripe(key)
+	defer func() {
+		st.mu.Lock()
+		delete(st.pending, key)
+		st.mu.Unlock()
+	}()
+
+	owner, ok := ss.getShardForKey(key, "GET")
+	if !ok {
+		return
+	}
+	val, ok := owner.Store.getRaw(key)
+	if !ok || val.Type != StringType {
+		return
+	}
+	replica := hotReplica{
+		data:      append([]byte(nil), val.Data...),
+		expiresAt: owner.Store.getExpirationTime(key),
+	}
+
+	// Hold the stripe lock while installing so a concurrent write waits in
+	// invalidateHotKey and removes the replicas right after.
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.pending[key] {
+		return // invalidated by a write while we were copying
+	}
+	ss.broadcastHotKey("HOTKEY_SET", key, replica)
+	st.hot[key] = time.Now().Add(hk.lease)
+	log.Printf("DEBUG: %s - Replicated hot key to all shards", key)
+}
+
+func (ss *SharedStore) invalidateHotKey(hk *hotKeyTracker, key string) {
+	st := hk.stripe(key)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	_, isHot := st.hot[key]
+	delete(st.pending, key)
+	if !isHot {
+		return
+	}
+	delete(st.hot, key)
+	ss.broadcastHotKey("HOTKEY_DEL", key, nil)
+	log.Printf("DEBUG: %s - Invalidated hot key replicas on write", key)
+}
+
+// dropEvictedHotKey drops the replicas of a key its shard evicted. It is
+// called on the shard's worker, which invalidating would wait on, so the
+// replicas go on a goroutine of their own.
+func (ss *SharedStore) dropEvictedHotKey(key string) {
+	if hk := ss.hotKeys(); hk != nil {
+		go ss.invalidateHotKey(hk, key)
+	}
+}
+
+// trackedKeys lists the keys replicated now or on their way to it
+func (hk *hotKeyTracker) trackedKeys() []string {
+	var keys []string
+	for i := range hk.stripes {
+		st := &hk.stripes[i]
+		st.mu.Lock()
+		for k := range st.hot {
+			keys = append(keys, k)
+		}
+		for k := range st.pending {
+			if _, isHot := st.hot[k]; !isHot {
+				keys = append(keys, k)
+			}
+		}
+		st.mu.Unlock()
+	}
+	return keys
+}
+
+// dropAllHotKeys drops the replicas of every key and cancels replications
+// in flight, for commands deleting keys wholesale
+func (ss *SharedStore) dropAllHotKeys() {
+	if hk := ss.hotKeys(); hk != nil {
+		for _, k := range hk.trackedKeys() {
+			ss.invalidateHotKey(hk, k)
+		}
+	}
+}
+
+// broadcastHotKey sends an internal replica command to every shard and waits
+func (ss *SharedStore) broadcastHotKey(cmd, key string, payload interface{}) {
+	ss.mu.RLock()
+	shards := make([]*Shard, 0, len(ss.nodeShards))
+	for _, sh := range ss.nodeShards {
+		shards = append(shards, sh)
+	}
+	ss.mu.RUnlock()
+
+	replies := make([]chan interface{}, 0, len(shards))
+	for _, sh := range shards {
+		req := ShardRequest{
+			Command:  cmd,
+			Key:      key,
+			Payload:  payload,
+			Reply:    make(chan interface{}, 1),
+			internal: true,
+		}
+		sh.enqueue(req)
+		replies = append(replies, req.Reply)
+	}
+	for _, r := range replies {
+		<-r
+	}
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"hash/maphash"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+	"weak"
+)
+
+// internTable shares one copy of each set member, hash field and sorted set
+// member across the keys of a store, for datasets where many collections
+// draw on the same vocabulary. Entries point at their string weakly: the
+// garbage collector keeps the count of keys still using a string, and an
+// entry dies with the last of them, whichever path dropped it. Dead entries
+// are swept once the table has doubled since the last sweep.
+//
+// The table is only touched by writers, under the store's write lock.
+type internTable struct {
+	on      atomic.Bool
+	seed    maphash.Seed
+	entries map[uint64][]internEntry // by hash of the string
+	size    int                      // entries, live or dead
+	swept   int                      // live entries after the last sweep
+	shared  atomic.Int64             // insertions that reused an entry
+}
+
+type internEntry struct {
+	data weak.Pointer[byte]
+	n    int
+}
+
+// internSweepMin is the table size below which dead entries are not worth
+// a sweep
+const internSweepMin = 4096
+
+// SetInterning turns sharing of member and field strings on or off. Members
+// already stored keep whatever copy they have.
+func (s *Store) SetInterning(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.strings.on.Store(on)
+	if !on {
+		s.strings.entries, s.strings.size, s.strings.swept = nil, 0, 0
+	}
+}
+
+// intern returns the shared copy of m, making m's own copy the shared one
+// if there is none. Callers hold s.mu.
+func (s *Store) intern(m string) string {
+	t := &s.strings
+	if !t.on.Load() || m == "" {
+		return m
+	}
+	if t.entries == nil {
+		t.seed = maphash.MakeSeed()
+		t.entries = make(map[uint64][]internEntry)
+	}
+	h := maphash.String(t.seed, m)
+	bucket := t.entries[h]
+	free := -1
+	for i, e := range bucket {
+		p := e.data.Value()
+		if p == nil {
+			free = i
+			continue
+		}
+		if e.n == len(m) {
+			if c := unsafe.String(p, e.n); c == m {
+				t.shared.Add(1)
+				return c
+			}
+		}
+	}
+	// copy, so the entry never pins a larger buffer m may be a slice of
+	c := strings.Clone(m)
+	e := internEntry{data: weak.Make(unsafe.StringData(c)), n: len(c)}
+	if free >= 0 {
+		bucket[free] = e
+	} else {
+		t.entries[h] = append(bucket, e)
+		t.size++
+	}
+	if t.size >= internSweepMin && t.size >= 2*t.swept {
+		t.sweep()
+	}
+	return c
+}
+
+// sweep drops the entries whose string is no longer used by any key
+func (t *internTable) sweep() {
+	live := 0
+	for h, bucket := range t.entries {
+		kept := bucket[:0]
+		for _, e := range bucket {
+			if e.data.Value() != nil {
+				kept = append(kept, e)
+			}
+		}
+		clear(bucket[len(kept):])
+		if len(kept) == 0 {
+			delete(t.entries, h)
+		} else {
+			t.entries[h] = kept
+		}
+		live += len(kept)
+	}
+	t.size, t.swept = live, live
+}
+
+// InternStats reports the entries of the intern table, including ones not
+// yet swept, and how many stored members reused an existing entry
+func (s *Store) InternStats() (entries int, shared int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.strings.size, s.strings.shared.Load()
+}
+
+// SetInterning turns string sharing on or off on every shard, including
+// shards added later
+func (ss *SharedStore) SetInterning(on bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.interning = on
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetInterning(on)
+	}
+}
+
+// InternStats sums InternStats over every shard
+func (ss *SharedStore) InternStats() (entries int, shared int64) {
+	for _, sh := range ss.shardList() {
+		e, n := sh.Store.InternStats()
+		entries += e
+		shared += n
+	}
+	return entries, shared
+}
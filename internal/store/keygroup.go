@@ -0,0 +1,206 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// A key group places a chosen set of keys by the group's name instead of
+// their own, so the keys live on one shard together and multi-key commands
+// on them run there atomically, without a hash tag in every name. The ring
+// keeps the groups as routing overrides: a grouped key's slot is the slot a
+// hash tag equal to the group name gives, so keys tagged {name} land with
+// the group too. Groups are kept in memory only; after a restart keys are
+// placed by their names again.
+//
+// Joining or leaving a group may move the key to another shard. The move is
+// run by the worker of the shard the key is on, which takes both stores'
+// locks and changes the override while it holds them, so the key lives on
+// exactly one shard throughout and every later command finds it where the
+// override routes it. Groups cannot change while a reshard runs, as its
+// slot index is built from them.
+
+// MaxKeyGroupSize bounds the keys of one group, since a group is never
+// split across shards however hot or large it grows
+const MaxKeyGroupSize = 1024
+
+var (
+	// ErrKeyGroupFull is returned when a key would take a group past
+	// MaxKeyGroupSize
+	ErrKeyGroupFull = fmt.Errorf("ERR key group is full (%d keys)", MaxKeyGroupSize)
+	// ErrKeyGroupBusy is returned while a reshard runs
+	ErrKeyGroupBusy = errors.New("TRYAGAIN key groups cannot change while slots are moving, retry")
+)
+
+// keySlot is the slot key is placed by: its group's if it has one, else
+// KeySlot's
+func (hr *HashRing) keySlot(key string) int {
+	if hr.grouped.Load() == 0 {
+		return KeySlot(key)
+	}
+	hr.mutex.RLock()
+	g, ok := hr.groups[key]
+	hr.mutex.RUnlock()
+	if !ok {
+		return KeySlot(key)
+	}
+	return GroupSlot(g)
+}
+
+// setGroup puts key in group, or takes it out of its group when group is ""
+func (hr *HashRing) setGroup(key, group string) error {
+	hr.mutex.Lock()
+	defer hr.mutex.Unlock()
+	old, had := hr.groups[key]
+	if had && old == group {
+		return nil
+	}
+	if group != "" && len(hr.members[group]) >= MaxKeyGroupSize {
+		return ErrKeyGroupFull
+	}
+	if had {
+		delete(hr.members[old], key)
+		if len(hr.members[old]) == 0 {
+			delete(hr.members, old)
+		}
+		delete(hr.groups, key)
+		hr.grouped.Add(-1)
+	}
+	if group == "" {
+		return nil
+	}
+	if hr.groups == nil {
+		hr.groups = make(map[string]string)
+		hr.members = make(map[string]map[string]struct{})
+	}
+	if hr.members[group] == nil {
+		hr.members[group] = make(map[string]struct{})
+	}
+	hr.members[group][key] = struct{}{}
+	hr.groups[key] = group
+	hr.grouped.Add(1)
+	return nil
+}
+
+// KeyGroup returns the group key is in, "" if none
+func (ss *SharedStore) KeyGroup(key string) string {
+	ss.ring.mutex.RLock()
+	defer ss.ring.mutex.RUnlock()
+	return ss.ring.groups[key]
+}
+
+// KeyGroupMembers lists the keys of group, sorted
+func (ss *SharedStore) KeyGroupMembers(group string) []string {
+	ss.ring.mutex.RLock()
+	keys := make([]string, 0, len(ss.ring.members[group]))
+	for k := range ss.ring.members[group] {
+		keys = append(keys, k)
+	}
+	ss.ring.mutex.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// KeyGroups returns the number of keys in every group
+func (ss *SharedStore) KeyGroups() map[string]int {
+	ss.ring.mutex.RLock()
+	defer ss.ring.mutex.RUnlock()
+	out := make(map[string]int, len(ss.ring.members))
+	for g, keys := range ss.ring.members {
+		out[g] = len(keys)
+	}
+	return out
+}
+
+// GroupSlot is the slot a group's keys are placed by
+func GroupSlot(group string) int {
+	return int(crc16(group) % ClusterSlots)
+}
+
+// slotOf is the slot key is routed by, see keySlot
+func (ss *SharedStore) slotOf(key string) int {
+	return ss.ring.keySlot(key)
+}
+
+// SetKeyGroup puts key in group, or takes it out of its group when group
+// is "", moving it to the shard its new slot's owner runs
+func (ss *SharedStore) SetKeyGroup(key, group string) error {
+	if !ss.reshard.run.TryLock() {
+		return ErrKeyGroupBusy
+	}
+	defer ss.reshard.run.Unlock()
+	for {
+		from, ok := ss.nodeFor(key)
+		if !ok {
+			return fmt.Errorf("ERR no shard available for key %s", key)
+		}
+		src, ok := ss.getShardByNodeID(from)
+		if !ok {
+			continue // removed meanwhile
+		}
+		switch res := src.call("GROUPMOVE", key, group).(type) {
+		case error:
+			return res
+		case bool:
+			if res {
+				return nil
+			}
+			// key is routed elsewhere now: look it up again
+		}
+	}
+}
+
+// GROUPMOVE key; internal, sent by SetKeyGroup with the group as Payload to
+// the shard key is routed to. It moves key to the owner of the group's
+// slot, or of its own slot when leaving its group, and changes the override
+// while holding both stores, replying false when key is no longer routed
+// here.
+func (s *Shard) cmdGroupMove(req ShardRequest) {
+	group, _ := req.Payload.(string)
+	ss := s.parent
+	if node, _ := ss.nodeFor(req.Key); node != s.nodeID {
+		req.Reply <- false
+		return
+	}
+	slot := KeySlot(req.Key)
+	if group != "" {
+		slot = GroupSlot(group)
+	}
+	to, ok := ss.getShardByNodeID(ss.slots.owner(slot))
+	if !ok {
+		req.Reply <- fmt.Errorf("ERR no shard available for slot %d", slot)
+		return
+	}
+	if ss.slots.isMoving(ss.slotOf(req.Key)) || ss.slots.isMoving(slot) {
+		req.Reply <- ErrKeyGroupBusy // left split by a cancelled reshard
+		return
+	}
+	old := ss.KeyGroup(req.Key)
+	if to == s {
+		if err := ss.ring.setGroup(req.Key, group); err != nil {
+			req.Reply <- err
+			return
+		}
+		req.Reply <- true
+		return
+	}
+	first, second := s.Store, to.Store
+	if to.nodeID < s.nodeID {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+	if err := ss.ring.setGroup(req.Key, group); err != nil {
+		req.Reply <- err
+		return
+	}
+	if _, err := moveKey(s.Store, to.Store, req.Key); err != nil {
+		ss.ring.setGroup(req.Key, old) // back where the key still is
+		req.Reply <- err
+		return
+	}
+	req.Reply <- true
+}
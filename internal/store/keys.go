@@ -0,0 +1,214 @@
+package store
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+var ErrNoSuchKey = errors.New("ERR no such key")
+
+// Exists reports whether key holds a live value
+func (s *Store) Exists(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.expiredRead(key) {
+		return false
+	}
+	_, ok := s.lookupRead(key)
+	return ok
+}
+
+// Type returns the type of the value at key
+func (s *Store) Type(key string) (ValueType, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.expiredRead(key) {
+		return 0, false
+	}
+	v, ok := s.lookupRead(key)
+	return v.Type, ok
+}
+
+func (s *Shard) cmdExists(req ShardRequest) {
+	req.Reply <- s.Store.Exists(req.Key)
+}
+
+func (s *Shard) cmdType(req ShardRequest) {
+	t, ok := s.Store.Type(req.Key)
+	if !ok {
+		req.Reply <- nil
+		return
+	}
+	req.Reply <- t
+}
+
+// randomKey returns a live key of database db, without its prefix. Map
+// iteration starts at a random point, so the pick is cheap but not uniform.
+func (s *Store) randomKey(db int) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for k := range s.data.All() {
+		if s.pastTTL(k) {
+			continue
+		}
+		if d, name := KeyDB(k); d == db {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// exportKey encodes key's value and expiry, removing the key if remove is
+// set and raising event for it unless event is empty
+func (s *Store) exportKey(key string, remove bool, event string) (backupRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired(key)
+	v, ok := s.data.Get(key)
+	if !ok {
+		return backupRecord{}, false, nil
+	}
+	raw, err := encodeValue(v)
+	if err != nil {
+		return backupRecord{}, false, err
+	}
+	rec := backupRecord{Key: key, TTL: s.ttl[key], Value: raw}
+	if remove {
+		s.data.Delete(key)
+		s.clearTTL(key)
+		s.accountKey(key)
+		if event != "" {
+			s.notify(EventGeneric, event, key)
+		}
+	}
+	return rec, true, nil
+}
+
+// importKey stores rec's value and expiry under key and raises event for
+// it. An existing key is kept, and importKey reports false, unless replace
+// is set.
+func (s *Store) importKey(key string, rec backupRecord, replace bool, event string) (bool, error) {
+	v, err := decodeValue(rec.Value)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired(key)
+	if _, exists := s.data.Get(key); exists && !replace {
+		return false, nil
+	}
+	s.putValue(key, v, rec.TTL)
+	s.account(key, false)
+	if event != "" {
+		s.notify(EventGeneric, event, key)
+	}
+	return true, nil
+}
+
+// Rename moves src to dst, replacing dst. With nx an existing dst is kept
+// and Rename reports false. The value moves in one piece, whichever shards
+// own the two names.
+func (ss *SharedStore) Rename(src, dst string, nx bool) (bool, error) {
+	from, ok := ss.shardStore(src)
+	if !ok {
+		return false, ErrNoSuchKey
+	}
+	to, ok := ss.shardStore(dst)
+	if !ok {
+		return false, ErrNoSuchKey
+	}
+	if src == dst || nx {
+		if !from.Exists(src) {
+			return false, ErrNoSuchKey
+		}
+		if src == dst {
+			return !nx, nil
+		}
+		if to.Exists(dst) {
+			return false, nil
+		}
+	}
+	ss.dropHotKeys(src, dst)
+	rec, ok, err := from.exportKey(src, true, "rename_from")
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, ErrNoSuchKey
+	}
+	return to.importKey(dst, rec, true, "rename_to")
+}
+
+// Copy copies src to dst. Without replace an existing dst is kept and Copy
+// reports false.
+func (ss *SharedStore) Copy(src, dst string, replace bool) (bool, error) {
+	from, ok := ss.shardStore(src)
+	if !ok {
+		return false, nil
+	}
+	to, ok := ss.shardStore(dst)
+	if !ok {
+		return false, nil
+	}
+	rec, ok, err := from.exportKey(src, false, "")
+	if err != nil || !ok {
+		return false, err
+	}
+	ss.dropHotKeys(dst)
+	return to.importKey(dst, rec, replace, "copy_to")
+}
+
+// dropHotKeys invalidates the hot-key replicas of keys written outside the
+// shard queues
+func (ss *SharedStore) dropHotKeys(keys ...string) {
+	if hk := ss.hotKeys(); hk != nil {
+		for _, k := range keys {
+			ss.invalidateHotKey(hk, k)
+		}
+	}
+}
+
+// RandomKey returns a random live key of database db, picking the shard in
+// proportion to its share of the database's keys
+func (ss *SharedStore) RandomKey(db int) (string, bool) {
+	shards := ss.shardList()
+	weights := make([]int, len(shards))
+	total := 0
+	for i, sh := range shards {
+		weights[i] = sh.Store.DBKeys(db)
+		total += weights[i]
+	}
+	if total == 0 {
+		return "", false
+	}
+	pick := rand.Intn(total)
+	first := 0
+	for i, w := range weights {
+		if pick < w {
+			first = i
+			break
+		}
+		pick -= w
+	}
+	// a shard whose keys all expired yields to the next one
+	for i := range shards {
+		if k, ok := shards[(first+i)%len(shards)].Store.randomKey(db); ok {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// putValue stores v, decoded from a backup or payload, under key with
+// expiry ttl, zero for none. Callers hold s.mu and account the key.
+func (s *Store) putValue(key string, v Value, ttl time.Time) {
+	s.pack(&v)
+	s.data.Put(key, v)
+	if ttl.IsZero() {
+		s.clearTTL(key)
+	} else {
+		s.setTTL(key, ttl)
+	}
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"sync"
+)
+
+// lazyExpiredQueue bounds how many expired keys reads can hand to the worker
+// at once; when it is full the active cleaner picks up the rest
+const lazyExpiredQueue = 1024
+
+// lazyState holds the writes that read commands defer to the shard worker.
+// Reads run under s.mu.RLock and never modify the keyspace: a key found past
+// its TTL is reported as missing and queued for deletion, and LRU access
+// times are buffered in touched until the worker folds them in.
+type lazyState struct {
+	expired chan string
+
+	mu      sync.Mutex
+	touched map[string]int64 // key -> last read, UnixNano
+}
+
+func newLazyState() lazyState {
+	return lazyState{
+		expired: make(chan string, lazyExpiredQueue),
+		touched: make(map[string]int64),
+	}
+}
+
+// expiredRead is expired for callers holding only the read lock. The
+// deletion is queued for the shard worker instead of done in place.
+func (s *Store) expiredRead(key string) bool {
+	if !s.pastTTL(key) {
+		return false
+	}
+	select {
+	case s.lazy.expired <- key:
+	default:
+	}
+	return true
+}
+
+// touch records a read of key for LRU eviction and adaptive TTLs
+func (s *Store) touch(key string) {
+	now := s.now().UnixNano()
+	s.lazy.mu.Lock()
+	s.lazy.touched[key] = now
+	s.countRead(key)
+	s.lazy.mu.Unlock()
+}
+
+// reapExpired deletes a key queued by expiredRead. The TTL is checked again
+// since the key may have been rewritten after the read saw it expire.
+func (s *Store) reapExpired(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired(key)
+}
+
+// foldTouches copies buffered read times into LastAccess
+func (s *Store) foldTouches() {
+	s.lazy.mu.Lock()
+	touched := s.lazy.touched
+	if len(touched) == 0 {
+		s.lazy.mu.Unlock()
+		return
+	}
+	s.lazy.touched = make(map[string]int64, len(touched))
+	s.lazy.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, at := range touched {
+		if v, ok := s.data.Get(key); ok && at > v.LastAccess {
+			v.LastAccess = at
+			s.data.Put(key, v)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	ErrValueTooLarge      = errors.New("ERR value exceeds max-value-size")
+	ErrCollectionTooLarge = errors.New("ERR collection would exceed max-collection-len")
+)
+
+// Limits caps how much memory a single key may take. Zero disables a limit.
+type Limits struct {
+	MaxValueSize     int // bytes per string value or collection element
+	MaxCollectionLen int // elements per set, hash, list or sorted set
+}
+
+// storeLimits is read on every write, so it is kept in atomics
+type storeLimits struct {
+	maxValueSize     atomic.Int64
+	maxCollectionLen atomic.Int64
+}
+
+// SetLimits replaces the store's limits; existing keys are not trimmed
+func (s *Store) SetLimits(l Limits) {
+	s.limits.maxValueSize.Store(int64(l.MaxValueSize))
+	s.limits.maxCollectionLen.Store(int64(l.MaxCollectionLen))
+}
+
+func (s *Store) Limits() Limits {
+	return Limits{
+		MaxValueSize:     int(s.limits.maxValueSize.Load()),
+		MaxCollectionLen: int(s.limits.maxCollectionLen.Load()),
+	}
+}
+
+func (s *Store) checkValueSize(n int) error {
+	if max := s.limits.maxValueSize.Load(); max > 0 && int64(n) > max {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// checkElements applies the value size limit to each collection element
+func (s *Store) checkElements(elems []string) error {
+	if s.limits.maxValueSize.Load() <= 0 {
+		return nil
+	}
+	for _, e := range elems {
+		if err := s.checkValueSize(len(e)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) checkCollectionLen(n int) error {
+	if max := s.limits.maxCollectionLen.Load(); max > 0 && int64(n) > max {
+		return ErrCollectionTooLarge
+	}
+	return nil
+}
+
+// SetLimits applies l to every shard, including shards added later
+func (ss *SharedStore) SetLimits(l Limits) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.limits = l
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetLimits(l)
+	}
+}
+
+// countNew returns how many distinct items are not already present
+func countNew(items []string, present func(string) bool) int {
+	seen := make(map[string]struct{}, len(items))
+	for _, it := range items {
+		if _, dup := seen[it]; dup || present(it) {
+			continue
+		}
+		seen[it] = struct{}{}
+	}
+	return len(seen)
+}
+
+func (ss *SharedStore) Limits() Limits {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.limits
+}
@@ -0,0 +1,338 @@
+package store
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// The list commands beyond push, pop and LRANGE. Readers get slices of a
+// list's backing array without copying (see LRange), so a write never
+// changes an element in place: it builds a new slice, or reslices when it
+// only drops elements from the ends. A list left empty is deleted.
+
+// ErrIndexOutOfRange is returned by LSET for an index past either end
+var ErrIndexOutOfRange = errors.New("ERR index out of range")
+
+// listIndex resolves a possibly negative list index against n elements,
+// reporting whether it falls inside the list
+func listIndex(i, n int) (int, bool) {
+	if i < 0 {
+		i += n
+	}
+	return i, i >= 0 && i < n
+}
+
+// writableList returns the list at key for a write, false if there is
+// none. Callers hold s.mu.
+func (s *Store) writableList(key string) (Value, bool, error) {
+	s.expired(key)
+	val, ok := s.data.Get(key)
+	if !ok {
+		return Value{}, false, nil
+	}
+	if val.Type != ListType {
+		return Value{}, false, ErrWrongType
+	}
+	return val, true, nil
+}
+
+// putList stores list at key after a write, deleting key once the list is
+// empty. Callers hold s.mu.
+func (s *Store) putList(key string, val Value, list []string) {
+	if len(list) == 0 {
+		s.data.Delete(key)
+		s.clearTTL(key)
+		s.notify(EventGeneric, "del", key)
+		return
+	}
+	val.List = list
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+}
+
+// LInsert inserts value before or after the first occurrence of pivot,
+// returning the new length, -1 when pivot is not in the list, or 0 when
+// there is no list at key
+func (s *Store) LInsert(key string, before bool, pivot, value string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, ok, err := s.writableList(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	at := -1
+	for i, e := range val.List {
+		if e == pivot {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return -1, nil
+	}
+	if err := s.checkElements([]string{value}); err != nil {
+		return 0, err
+	}
+	if err := s.checkCollectionLen(len(val.List) + 1); err != nil {
+		return 0, err
+	}
+	if !before {
+		at++
+	}
+	list := make([]string, 0, len(val.List)+1)
+	list = append(list, val.List[:at]...)
+	list = append(list, value)
+	list = append(list, val.List[at:]...)
+	s.notify(EventList, "linsert", key)
+	s.putList(key, val, list)
+	return len(list), nil
+}
+
+// LSet replaces the element at index, counted from the tail when negative
+func (s *Store) LSet(key string, index int, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, ok, err := s.writableList(key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNoSuchKey
+	}
+	i, ok := listIndex(index, len(val.List))
+	if !ok {
+		return ErrIndexOutOfRange
+	}
+	if err := s.checkElements([]string{value}); err != nil {
+		return err
+	}
+	list := append([]string(nil), val.List...)
+	list[i] = value
+	s.notify(EventList, "lset", key)
+	s.putList(key, val, list)
+	return nil
+}
+
+// LRem removes elements equal to value, returning how many went: the first
+// count from the head when count > 0, the last -count from the tail when
+// count < 0, and all of them when count is 0
+func (s *Store) LRem(key string, count int, value string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, ok, err := s.writableList(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+	// mark the elements to drop, scanning from the end count names
+	drop := make(map[int]bool)
+	for j := range val.List {
+		i := j
+		if count < 0 {
+			i = len(val.List) - 1 - j
+		}
+		if val.List[i] == value {
+			drop[i] = true
+			if len(drop) == limit {
+				break
+			}
+		}
+	}
+	if len(drop) == 0 {
+		return 0, nil
+	}
+	list := make([]string, 0, len(val.List)-len(drop))
+	for i, e := range val.List {
+		if !drop[i] {
+			list = append(list, e)
+		}
+	}
+	s.noteShrink(key, len(drop))
+	s.notify(EventList, "lrem", key)
+	s.putList(key, val, list)
+	return len(drop), nil
+}
+
+// LTrim keeps only the elements from start to stop, inclusive, with
+// negative indices counted from the tail as in LRANGE
+func (s *Store) LTrim(key string, start, stop int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, ok, err := s.writableList(key)
+	if err != nil || !ok {
+		return err
+	}
+	n := len(val.List)
+	if start < 0 {
+		start = max(start+n, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop, n-1)
+	var list []string
+	if start <= stop {
+		list = val.List[start : stop+1 : stop+1]
+	}
+	s.noteShrink(key, n-len(list))
+	s.notify(EventList, "ltrim", key)
+	s.putList(key, val, list)
+	return nil
+}
+
+// LPosOptions are the options of LPOS. Rank picks which match to start
+// from, counting from the tail when negative; Count is how many matches to
+// return, 0 for all; MaxLen bounds the elements compared, 0 for all.
+type LPosOptions struct {
+	Rank   int
+	Count  int
+	MaxLen int
+}
+
+// LPos returns the indexes of elements equal to elem, in the order they
+// were found
+func (s *Store) LPos(key, elem string, opts LPosOptions) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return nil, nil
+	}
+	val, ok := s.lookupRead(key)
+	if !ok {
+		return nil, nil
+	}
+	if val.Type != ListType {
+		return nil, ErrWrongType
+	}
+	s.touch(key)
+
+	rank := max(opts.Rank, 1)
+	if opts.Rank < 0 {
+		rank = -opts.Rank
+	}
+	n := len(val.List)
+	var out []int
+	for j := 0; j < n && (opts.MaxLen == 0 || j < opts.MaxLen); j++ {
+		i := j
+		if opts.Rank < 0 {
+			i = n - 1 - j
+		}
+		if val.List[i] != elem {
+			continue
+		}
+		if rank > 1 {
+			rank--
+			continue
+		}
+		out = append(out, i)
+		if opts.Count > 0 && len(out) == opts.Count {
+			break
+		}
+	}
+	return out, nil
+}
+
+// LMPop pops up to count elements from the head, or the tail when left is
+// false, nil if there is no list at key or it is empty
+func (s *Store) LMPop(key string, left bool, count int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, ok, err := s.writableList(key)
+	if err != nil || !ok || len(val.List) == 0 {
+		return nil, err
+	}
+	n := min(count, len(val.List))
+	var popped, list []string
+	if left {
+		popped, list = val.List[:n], val.List[n:]
+		s.noteShrink(key, n)
+		s.notify(EventList, "lpop", key)
+	} else {
+		// popped in the order they leave the tail
+		popped = make([]string, n)
+		for i := range popped {
+			popped[i] = val.List[len(val.List)-1-i]
+		}
+		list = val.List[: len(val.List)-n : len(val.List)-n]
+		s.notify(EventList, "rpop", key)
+	}
+	s.putList(key, val, list)
+	return popped, nil
+}
+
+// LINSERT key BEFORE|AFTER pivot value
+func (s *Shard) cmdLInsert(req ShardRequest) {
+	n, err := s.Store.LInsert(req.Key, strings.EqualFold(req.Args[0], "BEFORE"), req.Args[1], req.Args[2])
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
+
+// LSET key index value
+func (s *Shard) cmdLSet(req ShardRequest) {
+	index, _ := strconv.Atoi(req.Args[0])
+	if err := s.Store.LSet(req.Key, index, req.Args[1]); err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- "OK"
+}
+
+// LREM key count value
+func (s *Shard) cmdLRem(req ShardRequest) {
+	count, _ := strconv.Atoi(req.Args[0])
+	n, err := s.Store.LRem(req.Key, count, req.Args[1])
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
+
+// LTRIM key start stop
+func (s *Shard) cmdLTrim(req ShardRequest) {
+	start, _ := strconv.Atoi(req.Args[0])
+	stop, _ := strconv.Atoi(req.Args[1])
+	if err := s.Store.LTrim(req.Key, start, stop); err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- "OK"
+}
+
+// LPOS key element rank count maxlen, the options checked by the caller
+func (s *Shard) cmdLPos(req ShardRequest) {
+	var opts LPosOptions
+	opts.Rank, _ = strconv.Atoi(req.Args[1])
+	opts.Count, _ = strconv.Atoi(req.Args[2])
+	opts.MaxLen, _ = strconv.Atoi(req.Args[3])
+	pos, err := s.Store.LPos(req.Key, req.Args[0], opts)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- pos
+}
+
+// LMPOP key LEFT|RIGHT count, for one key of the client's LMPOP
+func (s *Shard) cmdLMPop(req ShardRequest) {
+	count, _ := strconv.Atoi(req.Args[1])
+	popped, err := s.Store.LMPop(req.Key, strings.EqualFold(req.Args[0], "LEFT"), count)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- popped
+}
@@ -0,0 +1,131 @@
+package store
+
+// LMove pops an element from one end of the list at src and pushes it onto
+// one end of the list at dst, creating dst if needed, and returns it; false
+// if src is missing or empty. Both shards' stores stay locked for the whole
+// move, taken in node ID order, so no reader sees the element in both lists
+// or in neither, whether or not src and dst live on the same shard. This is
+// what makes RPOPLPUSH safe for reliable queues.
+func (ss *SharedStore) LMove(src, dst string, fromLeft, toLeft bool) (string, bool, error) {
+	for {
+		elem, ok, err, stale := ss.tryLMove(src, dst, fromLeft, toLeft)
+		if !stale {
+			return elem, ok, err
+		}
+	}
+}
+
+// tryLMove is one attempt at LMove. It reports stale, having done nothing,
+// when a reshard moved src or dst off the store it was looked up on before
+// the stores were locked.
+func (ss *SharedStore) tryLMove(src, dst string, fromLeft, toLeft bool) (string, bool, error, bool) {
+	srcNode, ok1 := ss.GetNodeForKey(src)
+	dstNode, ok2 := ss.GetNodeForKey(dst)
+	if !ok1 || !ok2 {
+		return "", false, ErrNoSuchKey, false
+	}
+	fromShard, ok1 := ss.getShardByNodeID(srcNode)
+	toShard, ok2 := ss.getShardByNodeID(dstNode)
+	if !ok1 || !ok2 {
+		return "", false, ErrNoSuchKey, false
+	}
+	from, to := fromShard.Store, toShard.Store
+	ss.dropHotKeys(src, dst)
+
+	first, second := from, to
+	if dstNode < srcNode {
+		first, second = to, from
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	if second != first {
+		second.mu.Lock()
+		defer second.mu.Unlock()
+	}
+	if ss.movedOff(from, srcNode, src) || ss.movedOff(to, dstNode, dst) {
+		return "", false, nil, true
+	}
+	elem, ok, err := lmove(from, to, src, dst, fromLeft, toLeft)
+	return elem, ok, err, false
+}
+
+// lmove implements LMove. Callers hold both stores' locks.
+func lmove(from, to *Store, src, dst string, fromLeft, toLeft bool) (string, bool, error) {
+	from.expired(src)
+	to.expired(dst)
+	sv, ok := from.data.Get(src)
+	if !ok {
+		return "", false, nil
+	}
+	if sv.Type != ListType {
+		return "", false, ErrWrongType
+	}
+	if len(sv.List) == 0 {
+		return "", false, nil
+	}
+	same := from == to && src == dst
+	dv, exists := to.data.Get(dst)
+	if exists && dv.Type != ListType {
+		return "", false, ErrWrongType
+	}
+	if !same {
+		if err := to.checkCollectionLen(len(dv.List) + 1); err != nil {
+			return "", false, err
+		}
+	}
+
+	list, elem := sv.List, ""
+	if fromLeft {
+		elem, list = list[0], list[1:]
+	} else {
+		elem, list = list[len(list)-1], list[:len(list)-1]
+	}
+	now := to.now().UnixNano()
+	if same {
+		dv = sv
+		dv.List = list
+	} else {
+		if len(list) == 0 {
+			from.data.Delete(src)
+			from.clearTTL(src)
+		} else {
+			sv.List = list
+			sv.LastAccess = now
+			from.data.Put(src, sv)
+		}
+		if !exists {
+			dv = Value{Type: ListType}
+		}
+	}
+	if fromLeft {
+		from.noteShrink(src, 1)
+	}
+
+	// new backing arrays, since readers may still hold the old ones
+	if toLeft {
+		dv.List = append([]string{elem}, dv.List...)
+	} else {
+		dv.List = append(dv.List[:len(dv.List):len(dv.List)], elem)
+	}
+	dv.LastAccess = now
+	to.data.Put(dst, dv)
+
+	if fromLeft {
+		from.notify(EventList, "lpop", src)
+	} else {
+		from.notify(EventList, "rpop", src)
+	}
+	if !same {
+		if len(list) == 0 {
+			from.notify(EventGeneric, "del", src)
+		}
+		from.accountKey(src)
+	}
+	if toLeft {
+		to.notify(EventList, "lpush", dst)
+	} else {
+		to.notify(EventList, "rpush", dst)
+	}
+	to.accountKey(dst)
+	return elem, true, nil
+}
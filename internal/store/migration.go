@@ -0,0 +1,208 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A reshard can be throttled, paused, resumed and cancelled while it runs.
+// Throttling paces key moves to MigrationLimits; a paused reshard stops
+// before its next key and a cancelled one returns. Either way the slot in
+// flight stays split between its source and destination, served as ASK
+// until a later reshard finishes moving it.
+
+// MigrationLimits caps how fast a reshard moves keys, 0 = unlimited
+type MigrationLimits struct {
+	KeysPerSec  int
+	BytesPerSec int64
+}
+
+// MigrationNode is one node's share of the current or last reshard
+type MigrationNode struct {
+	Node                        string
+	SlotsToSend, SlotsToReceive int // in this reshard's plan
+	SlotsSent, SlotsReceived    int
+	KeysSent, KeysReceived      int
+	BytesSent, BytesReceived    int64
+	Err                         string // why a slot to or from it failed
+}
+
+// ErrMigrationCancelled is returned by a reshard stopped by CancelMigration
+var ErrMigrationCancelled = errors.New("migration cancelled")
+
+// migrationControl throttles, pauses and cancels reshards
+type migrationControl struct {
+	mu     sync.Mutex
+	limits MigrationLimits
+	cancel context.CancelCauseFunc // of the running reshard, nil if none
+
+	resume    chan struct{} // closed on resume; nil unless paused
+	pausedAt  time.Time
+	pausedFor time.Duration // over the running reshard, up to pausedAt
+
+	// pacing: moves since paceStart are spread over time at the limits
+	paceStart time.Time
+	paceKeys  int64
+	paceBytes int64
+}
+
+// SetMigrationLimits sets how fast reshards move keys, including the one
+// running
+func (ss *SharedStore) SetMigrationLimits(l MigrationLimits) {
+	m := &ss.migration
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limits = l
+	m.paceStart, m.paceKeys, m.paceBytes = time.Now(), 0, 0
+}
+
+// PauseMigration stops reshards before their next key until
+// ResumeMigration. It reports false if they were paused already.
+func (ss *SharedStore) PauseMigration() bool {
+	m := &ss.migration
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.resume != nil {
+		return false
+	}
+	m.resume = make(chan struct{})
+	m.pausedAt = time.Now()
+	return true
+}
+
+// ResumeMigration lets paused reshards go on. It reports false if they
+// were not paused.
+func (ss *SharedStore) ResumeMigration() bool {
+	m := &ss.migration
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.resume == nil {
+		return false
+	}
+	close(m.resume)
+	m.resume = nil
+	m.pausedFor += time.Since(m.pausedAt)
+	m.paceStart, m.paceKeys, m.paceBytes = time.Now(), 0, 0
+	return true
+}
+
+// CancelMigration stops the running reshard before its next key. It
+// reports false if none is running.
+func (ss *SharedStore) CancelMigration() bool {
+	m := &ss.migration
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cancel == nil {
+		return false
+	}
+	m.cancel(ErrMigrationCancelled)
+	return true
+}
+
+// start makes ctx's reshard the one CancelMigration stops
+func (m *migrationControl) start(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	m.mu.Lock()
+	m.cancel = cancel
+	m.pausedFor = 0
+	if m.resume != nil {
+		m.pausedAt = time.Now()
+	}
+	m.paceStart, m.paceKeys, m.paceBytes = time.Now(), 0, 0
+	m.mu.Unlock()
+	return ctx, func() {
+		m.mu.Lock()
+		m.cancel = nil
+		m.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// paused reports whether reshards are paused, and for how long they have
+// been in all since the running one started
+func (m *migrationControl) paused() (bool, time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.resume == nil {
+		return false, m.pausedFor
+	}
+	return true, m.pausedFor + time.Since(m.pausedAt)
+}
+
+// proceed waits while reshards are paused, returning the cause once ctx
+// is done
+func (m *migrationControl) proceed(ctx context.Context) error {
+	for {
+		m.mu.Lock()
+		resume := m.resume
+		m.mu.Unlock()
+		if resume == nil {
+			return context.Cause(ctx)
+		}
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// pace counts a moved key and waits long enough to keep within the limits.
+// Time spent under the limits is not saved up for a later burst.
+func (m *migrationControl) pace(ctx context.Context, bytes int) error {
+	m.mu.Lock()
+	l := m.limits
+	m.paceKeys++
+	m.paceBytes += int64(bytes)
+	var due time.Duration
+	if l.KeysPerSec > 0 {
+		due = time.Duration(m.paceKeys) * time.Second / time.Duration(l.KeysPerSec)
+	}
+	if l.BytesPerSec > 0 {
+		due = max(due, time.Duration(float64(m.paceBytes)/float64(l.BytesPerSec)*float64(time.Second)))
+	}
+	wait := time.Until(m.paceStart.Add(due))
+	if wait < -time.Second {
+		m.paceStart, m.paceKeys, m.paceBytes = time.Now(), 0, 0
+	}
+	m.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// nodeProgress returns node's entry in the running reshard's progress;
+// callers hold reshard.mu
+func (r *reshardState) nodeProgress(node string) *MigrationNode {
+	if r.nodes == nil {
+		r.nodes = make(map[string]*MigrationNode)
+	}
+	p := r.nodes[node]
+	if p == nil {
+		p = &MigrationNode{Node: node}
+		r.nodes[node] = p
+	}
+	return p
+}
+
+// nodeList returns the per-node progress sorted by node; callers hold
+// reshard.mu
+func (r *reshardState) nodeList() []MigrationNode {
+	out := make([]MigrationNode, 0, len(r.nodes))
+	for _, p := range r.nodes {
+		out = append(out, *p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Node < out[j].Node })
+	return out
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// EventClass is a set of notify-keyspace-events classes, one bit per letter
+type EventClass uint16
+
+const (
+	EventKeyspace EventClass = 1 << iota // K: publish on __keyspace@0__:<key>
+	EventKeyevent                        // E: publish on __keyevent@0__:<event>
+	EventGeneric                         // g: del and other type-independent commands
+	EventString                          // $
+	EventList                            // l
+	EventSet                             // s
+	EventHash                            // h
+	EventZSet                            // z
+	EventExpired                         // x: a key reached its TTL
+	EventEvicted                         // e: a key was evicted for maxmemory
+	EventModule                          // d: count-min sketches and bloom filters
+	EventNew                             // n: a key was created
+)
+
+var eventClassLetters = []struct {
+	letter byte
+	class  EventClass
+}{
+	{'K', EventKeyspace}, {'E', EventKeyevent}, {'g', EventGeneric}, {'$', EventString},
+	{'l', EventList}, {'s', EventSet}, {'h', EventHash}, {'z', EventZSet},
+	{'x', EventExpired}, {'e', EventEvicted}, {'d', EventModule}, {'n', EventNew},
+}
+
+// EventAll is the A alias: every class except n, K and E
+const EventAll = EventGeneric | EventString | EventList | EventSet | EventHash | EventZSet | EventExpired | EventEvicted | EventModule
+
+// ParseEventClasses parses a notify-keyspace-events string such as "KEA"
+func ParseEventClasses(flags string) (EventClass, error) {
+	var c EventClass
+next:
+	for i := 0; i < len(flags); i++ {
+		if flags[i] == 'A' {
+			c |= EventAll
+			continue
+		}
+		for _, l := range eventClassLetters {
+			if l.letter == flags[i] {
+				c |= l.class
+				continue next
+			}
+		}
+		return 0, fmt.Errorf("invalid event class '%c'", flags[i])
+	}
+	return c, nil
+}
+
+func (c EventClass) String() string {
+	var b strings.Builder
+	for _, l := range eventClassLetters {
+		if l.class == EventKeyspace || l.class == EventKeyevent {
+			continue
+		}
+		if c&EventAll == EventAll && l.class&EventAll != 0 {
+			continue
+		}
+		if c&l.class != 0 {
+			b.WriteByte(l.letter)
+		}
+	}
+	s := b.String()
+	if c&EventAll == EventAll {
+		s = "A" + s
+	}
+	if c&EventKeyevent != 0 {
+		s = "E" + s
+	}
+	if c&EventKeyspace != 0 {
+		s = "K" + s
+	}
+	return s
+}
+
+// KeyEventHook receives every keyspace change; filtering by class is up to
+// the hook. It runs with the store locked and must not call back into it.
+type KeyEventHook func(class EventClass, event, key string)
+
+type eventHook struct {
+	fn atomic.Pointer[KeyEventHook]
+}
+
+// SetEventHook installs fn as the store's event hook; nil removes it
+func (s *Store) SetEventHook(fn KeyEventHook) {
+	if fn == nil {
+		s.events.fn.Store(nil)
+		return
+	}
+	s.events.fn.Store(&fn)
+}
+
+// notify reports a change to key
+func (s *Store) notify(class EventClass, event, key string) {
+	if fn := s.events.fn.Load(); fn != nil {
+		(*fn)(class, event, key)
+	}
+}
+
+// SetEventHook installs fn on every shard, including shards added later
+func (ss *SharedStore) SetEventHook(fn KeyEventHook) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.eventHook = fn
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetEventHook(fn)
+	}
+}
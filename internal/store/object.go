@@ -0,0 +1,56 @@
+package store
+
+import "time"
+
+// ObjectInfo is what OBJECT reports about a key
+type ObjectInfo struct {
+	Encoding string        // see Value.encodingName
+	Idle     time.Duration // since the key was last read or written
+}
+
+// Object describes key's value without counting as an access to it
+func (s *Store) Object(key string) (ObjectInfo, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.expiredRead(key) {
+		return ObjectInfo{}, false
+	}
+	v, ok := s.data.Get(key)
+	if !ok {
+		return ObjectInfo{}, false
+	}
+	last := v.LastAccess
+	s.lazy.mu.Lock()
+	last = max(last, s.lazy.touched[key])
+	s.lazy.mu.Unlock()
+	return ObjectInfo{
+		Encoding: v.encodingName(),
+		Idle:     max(s.now().Sub(time.Unix(0, last)), 0),
+	}, true
+}
+
+func (s *Shard) cmdObject(req ShardRequest) {
+	info, ok := s.Store.Object(req.Key)
+	if !ok {
+		req.Reply <- nil
+		return
+	}
+	req.Reply <- info
+}
+
+// KeyFreq estimates how often key was read in the current hot-key window,
+// the only access frequency kept. It reports false when hot-key tracking
+// is off.
+func (ss *SharedStore) KeyFreq(key string) (uint32, bool) {
+	hk := ss.hotKeys()
+	if hk == nil {
+		return 0, false
+	}
+	st := hk.stripe(key)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if time.Now().After(st.windowEnd) {
+		return 0, true
+	}
+	return st.sketch.Query(key), true
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// KeyOwnership is where the ring routes a key and where copies of it
+// actually live. They differ when a migration is in flight or when one left
+// a key behind.
+type KeyOwnership struct {
+	Key       string
+	Owner     string   // node the ring maps the key to, empty if none
+	Residents []string // nodes holding a live copy, sorted
+	Migrating bool     // a migration of the key is in flight
+}
+
+// Misplaced reports whether a copy lives anywhere but on the owner. Keys in
+// flight are expected to and are not counted.
+func (o KeyOwnership) Misplaced() bool {
+	if o.Migrating {
+		return false
+	}
+	for _, n := range o.Residents {
+		if n != o.Owner {
+			return true
+		}
+	}
+	return false
+}
+
+// holds reports whether the store has a live copy of key, without counting
+// a hit or miss
+func (s *Store) holds(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.data.Get(key)
+	return ok && !s.pastTTL(key)
+}
+
+// liveKeys lists the keys whose TTL has not passed
+func (s *Store) liveKeys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, s.data.Len())
+	for k := range s.data.All() {
+		if !s.pastTTL(k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// isMigrating reports whether the slot key is in is moving
+func (ss *SharedStore) isMigrating(key string) bool {
+	return ss.slots.isMoving(ss.slotOf(key))
+}
+
+// Owner reports where key is routed and which shards hold it
+func (ss *SharedStore) Owner(key string) KeyOwnership {
+	o := KeyOwnership{Key: key, Migrating: ss.isMigrating(key)}
+	o.Owner, _ = ss.GetNodeForKey(key)
+
+	ss.mu.RLock()
+	shards := make(map[string]*Shard, len(ss.nodeShards))
+	for id, sh := range ss.nodeShards {
+		shards[id] = sh
+	}
+	ss.mu.RUnlock()
+	for id, sh := range shards {
+		if sh.Store.holds(key) {
+			o.Residents = append(o.Residents, id)
+		}
+	}
+	sort.Strings(o.Residents)
+	return o
+}
+
+// OwnershipAudit is the result of checking every key against the ring
+type OwnershipAudit struct {
+	Started   time.Time
+	Duration  time.Duration
+	Scanned   int
+	Misplaced int            // keys with a copy off their owner
+	Keys      []KeyOwnership // the first misplaced keys found, up to the limit
+}
+
+// AuditOwnership walks every shard for keys the ring maps elsewhere and
+// reports up to limit of them in detail. Shards are scanned one at a time,
+// so keys that move during the audit may be reported or missed.
+func (ss *SharedStore) AuditOwnership(limit int) OwnershipAudit {
+	a := OwnershipAudit{Started: time.Now()}
+	ss.mu.RLock()
+	shards := make(map[string]*Shard, len(ss.nodeShards))
+	for id, sh := range ss.nodeShards {
+		shards[id] = sh
+	}
+	ss.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for id, sh := range shards {
+		// the ring is consulted after the store lock is released, since
+		// AddNode takes store locks while holding ss.mu
+		keys := sh.Store.liveKeys()
+		a.Scanned += len(keys)
+		for _, k := range keys {
+			if owner, _ := ss.GetNodeForKey(k); owner == id || seen[k] {
+				continue // on its owner, or already reported
+			}
+			seen[k] = true
+			o := ss.Owner(k)
+			if !o.Misplaced() {
+				continue
+			}
+			a.Misplaced++
+			if len(a.Keys) < limit {
+				a.Keys = append(a.Keys, o)
+			}
+		}
+	}
+	a.Duration = time.Since(a.Started)
+	if a.Misplaced > 0 {
+		log.Printf("WARNING: Ownership audit found %d of %d keys off their owner", a.Misplaced, a.Scanned)
+	}
+	return a
+}
@@ -3,6 +3,7 @@ package store
 import "sync"
 
 type PubSubMessage struct {
+	Pattern string // set when delivered through a PSUBSCRIBE pattern
 	Channel string
 	Message string
 }
@@ -10,41 +11,88 @@ type PubSubMessage struct {
 type PubSub struct {
 	mu          sync.RWMutex
 	subscribers map[string]map[chan PubSubMessage]struct{} // channel -> set of subscriber channels
+	patterns    map[string]map[chan PubSubMessage]struct{} // pattern -> set of subscriber channels
+	tap         func(channel, message string)              // sees every Publish, see SetTap
 }
 
 func NewPubSub() *PubSub {
 	return &PubSub{
 		subscribers: make(map[string]map[chan PubSubMessage]struct{}),
+		patterns:    make(map[string]map[chan PubSubMessage]struct{}),
 	}
 }
 
 func (ps *PubSub) Subscribe(channels []string, out chan PubSubMessage) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
-	for _, channel := range channels {
-		if ps.subscribers[channel] == nil {
-			ps.subscribers[channel] = make(map[chan PubSubMessage]struct{})
-		}
-		ps.subscribers[channel][out] = struct{}{}
-	}
+	addSubscriber(ps.subscribers, channels, out)
 }
 
 func (ps *PubSub) Unsubscribe(channels []string, out chan PubSubMessage) {
 	ps.mu.Lock()
 	defer ps.mu.Unlock()
-	for _, channel := range channels {
-		if subs, ok := ps.subscribers[channel]; ok {
+	removeSubscriber(ps.subscribers, channels, out)
+}
+
+// PSubscribe delivers messages for every channel matching one of patterns
+func (ps *PubSub) PSubscribe(patterns []string, out chan PubSubMessage) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	addSubscriber(ps.patterns, patterns, out)
+}
+
+func (ps *PubSub) PUnsubscribe(patterns []string, out chan PubSubMessage) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	removeSubscriber(ps.patterns, patterns, out)
+}
+
+func addSubscriber(m map[string]map[chan PubSubMessage]struct{}, names []string, out chan PubSubMessage) {
+	for _, name := range names {
+		if m[name] == nil {
+			m[name] = make(map[chan PubSubMessage]struct{})
+		}
+		m[name][out] = struct{}{}
+	}
+}
+
+func removeSubscriber(m map[string]map[chan PubSubMessage]struct{}, names []string, out chan PubSubMessage) {
+	for _, name := range names {
+		if subs, ok := m[name]; ok {
 			delete(subs, out)
 			if len(subs) == 0 {
-				delete(ps.subscribers, channel)
+				delete(m, name)
 			}
 		}
 	}
 }
 
+// SetTap installs fn to see every message published with Publish, as a
+// bridge to another messaging system does; nil removes it. fn runs on the
+// publisher's goroutine with the subscriber lists locked, so it must not
+// block.
+func (ps *PubSub) SetTap(fn func(channel, message string)) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.tap = fn
+}
+
 func (ps *PubSub) Publish(channel, message string) int {
+	return ps.publish(channel, message, true)
+}
+
+// PublishFromTap delivers a message arriving from the system the tap
+// bridges to, without showing it to the tap, so it is not sent back
+func (ps *PubSub) PublishFromTap(channel, message string) int {
+	return ps.publish(channel, message, false)
+}
+
+func (ps *PubSub) publish(channel, message string, tap bool) int {
 	ps.mu.RLock()
 	defer ps.mu.RUnlock()
+	if tap && ps.tap != nil {
+		ps.tap(channel, message)
+	}
 	count := 0
 	msg := PubSubMessage{
 		Channel: channel,
@@ -58,5 +106,52 @@ func (ps *PubSub) Publish(channel, message string) int {
 			// If the subscriber's channel is full, we skip sending to it
 		}
 	}
+	for pattern, subs := range ps.patterns {
+		if !GlobMatch(pattern, channel) {
+			continue
+		}
+		pmsg := msg
+		pmsg.Pattern = pattern
+		for out := range subs {
+			select {
+			case out <- pmsg:
+				count++
+			default:
+			}
+		}
+	}
 	return count
 }
+
+// Channels lists channels with at least one subscriber, optionally only
+// those matching a glob pattern
+func (ps *PubSub) Channels(pattern string) []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	out := make([]string, 0, len(ps.subscribers))
+	for ch := range ps.subscribers {
+		if pattern == "" || GlobMatch(pattern, ch) {
+			out = append(out, ch)
+		}
+	}
+	return out
+}
+
+// NumSub returns the number of direct subscribers of each channel; pattern
+// subscriptions are not counted
+func (ps *PubSub) NumSub(channels []string) []int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	out := make([]int, len(channels))
+	for i, ch := range channels {
+		out[i] = len(ps.subscribers[ch])
+	}
+	return out
+}
+
+// NumPat returns the number of distinct patterns subscribed to
+func (ps *PubSub) NumPat() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.patterns)
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests run reads, writes and expiry of every value type side by side.
+// They assert little beyond the absence of errors; their point is to give
+// go test -race interleavings of the shard workers, direct reads, lazy and
+// active expiry and hot-key replicas to check.
+
+// raceOp is a command on a key of one type, run through SharedStore.Execute
+type raceOp struct {
+	cmd  string
+	args []string
+}
+
+// raceOps are the writes and reads run per type, keyed by key prefix
+var raceOps = map[string][]raceOp{
+	"str": {
+		{"SET", []string{"v", "PX", "3"}}, {"APPEND", []string{"x"}}, {"SETRANGE", []string{"2", "yz"}},
+		{"GET", nil}, {"STRLEN", nil}, {"GETRANGE", []string{"0", "-1"}},
+		{"TTL", nil}, {"EXISTS", nil}, {"TYPE", nil}, {"OBJECT", nil},
+	},
+	"set": {
+		{"SADD", []string{"a", "b", "c"}}, {"SREM", []string{"b"}}, {"SPOP", []string{"1"}},
+		{"SMEMBERS", nil}, {"SCARD", nil}, {"SISMEMBER", []string{"a"}}, {"SRANDMEMBER", []string{"2"}},
+	},
+	"hash": {
+		{"HSET", []string{"f", "v", "g", "w"}}, {"HINCRBY", []string{"n", "1"}}, {"HDEL", []string{"g"}},
+		{"HGET", []string{"f"}}, {"HGETALL", nil}, {"HLEN", nil}, {"HEXISTS", []string{"n"}},
+	},
+	"list": {
+		{"RPUSH", []string{"a", "b", "a"}}, {"LPOP", nil}, {"LTRIM", []string{"0", "8"}},
+		{"LINSERT", []string{"BEFORE", "a", "c"}}, {"LREM", []string{"0", "c"}},
+		{"LRANGE", []string{"0", "-1"}}, {"LLEN", nil}, {"LPOS", []string{"a", "1", "0", "0"}},
+	},
+	"zset": {
+		{"ZADD", []string{"1", "a", "2", "b"}}, {"ZINCRBY", []string{"1.5", "a"}},
+		{"ZSCORE", []string{"a"}}, {"ZCARD", nil}, {"ZRANK", []string{"b"}}, {"ZRANGE", []string{"0", "-1", "WITHSCORES"}},
+	},
+	"cms": {
+		{"CMSINCR", []string{"item", "2"}}, {"CMSQUERY", []string{"item"}},
+	},
+	"bf": {
+		{"BFADD", []string{"a", "b"}}, {"BFEXISTS", []string{"a"}},
+	},
+}
+
+// raceKeys is how many keys of each type the workers share
+const raceKeys = 8
+
+func TestConcurrentAccessAllTypes(t *testing.T) {
+	ss := newTestStore(t, 4)
+	// every key written expires within milliseconds, whatever its type, so
+	// lazy and active expiry race the reads and writes
+	ss.SetTTLPolicies([]TTLPolicy{{Prefix: "", Default: 2 * time.Millisecond}})
+	ss.SetHotKeyPolicy(20, 50*time.Millisecond)
+
+	const workers, rounds = 16, 300
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				for prefix, ops := range raceOps {
+					op := ops[(w+i)%len(ops)]
+					key := prefix + ":" + strconv.Itoa((w*7+i)%raceKeys)
+					if err, ok := ss.Execute(op.cmd, key, op.args...).(error); ok && !errors.Is(err, ErrNoSuchKey) {
+						errs <- fmt.Errorf("%s %s: %v", op.cmd, key, err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if ss.ExpiryStats().Expired == 0 {
+		t.Error("no key expired, so expiry raced nothing")
+	}
+}
+
+// TestConcurrentExpiryOnStore runs a bare Store's lazy and active expiry
+// against its readers and writers: reads queue expired keys, and a stand-in
+// for the shard worker reaps them and runs expire cycles while other
+// goroutines read TTLs and rewrite the keys.
+func TestConcurrentExpiryOnStore(t *testing.T) {
+	discardLogs(t)
+	s := NewStore()
+
+	done := make(chan struct{})
+	reaped := make(chan struct{})
+	go func() {
+		defer close(reaped)
+		tick := time.NewTicker(time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case key := <-s.lazy.expired:
+				s.reapExpired(key)
+			case <-tick.C:
+				s.activeExpireCycle()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	const workers, rounds = 8, 500
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := "k" + strconv.Itoa(i%raceKeys)
+				switch (w + i) % 5 {
+				case 0:
+					s.Set(key, []byte("v"), time.Millisecond)
+				case 1:
+					s.Get(key)
+				case 2:
+					if ttl := s.TTL(key); ttl < -2 {
+						t.Errorf("TTL %s = %d", key, ttl)
+					}
+				case 3:
+					if pttl := s.PTTL(key); pttl < -2 {
+						t.Errorf("PTTL %s = %d", key, pttl)
+					}
+				case 4:
+					s.Delete(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	<-reaped
+}
@@ -0,0 +1,530 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Keys are placed by hash slot, as in Redis Cluster: every key of a slot
+// (see KeySlot) lives on the node owning the slot. The ring says which node
+// each slot should live on and the slot table which node it does. Adding or
+// removing a node, or reweighting, changes the first; Reshard then moves
+// the slots the two disagree on, one at a time.
+//
+// While a slot moves, its source still owns it. A command on a key the
+// source holds runs there; a command on any other key of the slot is sent
+// to the destination, as a Redis Cluster node answers ASK. Each key moves
+// in one step with both stores locked, run by the source's worker between
+// its commands, so it always lives in exactly one place and no command sees
+// it missing. Once the source holds no key of the slot the destination
+// takes ownership.
+
+// slotMove is a slot on its way from one node to another
+type slotMove struct {
+	From, To string
+}
+
+// slotTable is which node owns every slot, and which slots are moving
+type slotTable struct {
+	mu     sync.RWMutex
+	owners [ClusterSlots]string // "" until the first node joins
+	moving map[int]slotMove
+}
+
+func (t *slotTable) owner(slot int) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.owners[slot]
+}
+
+// importer returns the node slot is moving to from node, if it is
+func (t *slotTable) importer(slot int, node string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	mv, ok := t.moving[slot]
+	if !ok || mv.From != node {
+		return "", false
+	}
+	return mv.To, true
+}
+
+// isMoving reports whether slot is in flight
+func (t *slotTable) isMoving(slot int) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, ok := t.moving[slot]
+	return ok
+}
+
+// settled reports whether node owns slot and is not moving it
+func (t *slotTable) settled(slot int, node string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	_, moving := t.moving[slot]
+	return !moving && t.owners[slot] == node
+}
+
+// assign gives every slot without an owner to the node place picks
+func (t *slotTable) assign(place func(slot int) (string, bool)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for slot := range t.owners {
+		if t.owners[slot] == "" {
+			t.owners[slot], _ = place(slot)
+		}
+	}
+}
+
+// release takes node's slots away, as when it is removed without its keys
+// being moved, and gives them to the nodes place picks
+func (t *slotTable) release(node string, place func(slot int) (string, bool)) {
+	t.mu.Lock()
+	for slot, owner := range t.owners {
+		if owner == node {
+			t.owners[slot] = ""
+		}
+	}
+	for slot, mv := range t.moving {
+		if mv.From == node || mv.To == node {
+			delete(t.moving, slot)
+		}
+	}
+	t.mu.Unlock()
+	t.assign(place)
+}
+
+// ownerOf returns the node owning key's slot
+func (ss *SharedStore) ownerOf(key string) (string, bool) {
+	node := ss.slots.owner(ss.slotOf(key))
+	return node, node != ""
+}
+
+// askTarget returns the shard to send a command on key to instead of sh,
+// the slot's owner: the one importing the slot, once sh no longer holds
+// key. Only sh's worker may rely on the answer for writes, as only it runs
+// the moves off sh.
+func (ss *SharedStore) askTarget(sh *Shard, key string) (*Shard, bool) {
+	to, ok := ss.slots.importer(ss.slotOf(key), sh.nodeID)
+	if !ok || sh.Store.holds(key) {
+		return nil, false
+	}
+	return ss.getShardByNodeID(to)
+}
+
+// nodeFor is where a command on key runs now: the slot's owner, or the
+// importing node once the owner no longer holds key
+func (ss *SharedStore) nodeFor(key string) (string, bool) {
+	node, ok := ss.ownerOf(key)
+	if !ok {
+		return "", false
+	}
+	if sh, ok := ss.getShardByNodeID(node); ok {
+		if to, ok := ss.askTarget(sh, key); ok {
+			return to.nodeID, true
+		}
+	}
+	return node, true
+}
+
+// slotSettled reports whether key's slot sits still on node, so a miss
+// there is a miss everywhere
+func (ss *SharedStore) slotSettled(key, node string) bool {
+	return ss.slots.settled(ss.slotOf(key), node)
+}
+
+// movedOff reports whether key belongs on the importing node rather than
+// st, node's store: its slot is moving off node and st does not hold it.
+// Callers hold st.mu, so it cannot move meanwhile.
+func (ss *SharedStore) movedOff(st *Store, node, key string) bool {
+	if _, ok := ss.slots.importer(ss.slotOf(key), node); !ok {
+		return false
+	}
+	_, ok := st.data.Get(key)
+	return !ok
+}
+
+// askRedirect is a shard's answer to a command on a key it no longer holds
+// in a slot it is moving away: run it on the importing shard
+type askRedirect struct {
+	to *Shard
+}
+
+// ReshardStatus is the progress of the current or last reshard
+type ReshardStatus struct {
+	Running    bool
+	Started    time.Time
+	Finished   time.Time // zero while running
+	Slots      int       // slots to move in this pass
+	SlotsMoved int
+	KeysMoved  int
+	Moving     int    // the slot in flight, -1 if none
+	From, To   string // where Moving is going
+	Pending    int    // slots not yet on the node the ring places them on
+	Err        string
+
+	Paused     bool
+	KeysTotal  int // keys in the slots to move as the reshard started
+	BytesMoved int64
+	ETA        time.Duration // at the rate so far, -1 if unknown or not running
+	Nodes      []MigrationNode
+}
+
+// reshardState serializes reshards and keeps their progress
+type reshardState struct {
+	run    sync.Mutex
+	mu     sync.Mutex
+	status ReshardStatus
+	nodes  map[string]*MigrationNode
+}
+
+// ErrReshardSource is returned when a slot's keys could not all be moved
+var ErrReshardSource = errors.New("keys left on the source")
+
+// reshardRounds bounds how often a slot's keys are listed and moved before
+// its move is given up on
+const reshardRounds = 3
+
+// ReshardStatus reports the current or last reshard
+func (ss *SharedStore) ReshardStatus() ReshardStatus {
+	ss.reshard.mu.Lock()
+	st := ss.reshard.status
+	st.Nodes = ss.reshard.nodeList()
+	ss.reshard.mu.Unlock()
+	st.ETA = -1
+	if !st.Running {
+		st.Moving = -1
+	} else {
+		var pausedFor time.Duration
+		st.Paused, pausedFor = ss.migration.paused()
+		// keys written meanwhile can take the count past the estimate
+		if active := time.Since(st.Started) - pausedFor; st.KeysMoved > 0 && active > 0 {
+			left := max(st.KeysTotal-st.KeysMoved, 0)
+			st.ETA = time.Duration(float64(left) / float64(st.KeysMoved) * float64(active))
+		}
+	}
+	st.Pending = len(ss.slotPlan())
+	return st
+}
+
+func (ss *SharedStore) updateReshard(fn func(st *ReshardStatus)) {
+	ss.reshard.mu.Lock()
+	defer ss.reshard.mu.Unlock()
+	fn(&ss.reshard.status)
+}
+
+// plannedMove is one step of a reshard
+type plannedMove struct {
+	slot int
+	slotMove
+}
+
+// slotPlan lists the slots whose owner is not the node the ring places
+// them on, in slot order
+func (ss *SharedStore) slotPlan() []plannedMove {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	var plan []plannedMove
+	for slot := 0; slot < ClusterSlots; slot++ {
+		want, ok := ss.ring.GetSlotNode(slot)
+		if !ok {
+			return nil
+		}
+		if have := ss.slots.owner(slot); have != want {
+			if _, ok := ss.nodeShards[want]; ok {
+				plan = append(plan, plannedMove{slot, slotMove{From: have, To: want}})
+			}
+		}
+	}
+	return plan
+}
+
+// Reshard moves every slot that is not on the node the ring places it on,
+// one at a time, until none is left. Only one reshard runs at a time; a
+// second call waits and then moves whatever the first left. A reshard
+// cancelled, through ctx or CancelMigration, stops before its next key,
+// see migration.go.
+func (ss *SharedStore) Reshard(ctx context.Context) error {
+	ss.reshard.run.Lock()
+	defer ss.reshard.run.Unlock()
+	ctx, done := ss.migration.start(ctx)
+	defer done()
+
+	plan := ss.slotPlan()
+	ss.updateReshard(func(st *ReshardStatus) {
+		*st = ReshardStatus{Running: true, Started: time.Now(), Slots: len(plan), Moving: -1}
+	})
+	ss.reshard.mu.Lock()
+	ss.reshard.nodes = nil
+	for _, mv := range plan {
+		ss.reshard.nodeProgress(mv.From).SlotsToSend++
+		ss.reshard.nodeProgress(mv.To).SlotsToReceive++
+	}
+	ss.reshard.mu.Unlock()
+	if len(plan) > 0 {
+		log.Printf("Resharding %d slots", len(plan))
+	}
+
+	// index the keys of every source by slot, so each slot's keys are
+	// found without scanning the whole store
+	sources := make(map[string]*Shard)
+	for _, mv := range plan {
+		if sh, ok := ss.getShardByNodeID(mv.From); ok && sources[mv.From] == nil {
+			sources[mv.From] = sh
+			sh.Store.indexSlots(ss.slotOf)
+		}
+	}
+	defer func() {
+		for _, sh := range sources {
+			sh.Store.indexSlots(nil)
+		}
+	}()
+	total := 0
+	for _, mv := range plan {
+		if sh := sources[mv.From]; sh != nil {
+			total += len(sh.Store.keysInSlot(mv.slot))
+		}
+	}
+	ss.updateReshard(func(st *ReshardStatus) { st.KeysTotal = total })
+
+	var err error
+	for _, mv := range plan {
+		if ctx.Err() != nil {
+			err = context.Cause(ctx)
+			break
+		}
+		ss.updateReshard(func(st *ReshardStatus) { st.Moving, st.From, st.To = mv.slot, mv.From, mv.To })
+		err = ss.moveSlot(ctx, mv.slot, mv.From, mv.To)
+		ss.reshard.mu.Lock()
+		if err == nil {
+			ss.reshard.status.SlotsMoved++
+			ss.reshard.nodeProgress(mv.From).SlotsSent++
+			ss.reshard.nodeProgress(mv.To).SlotsReceived++
+		} else {
+			err = fmt.Errorf("slot %d from %s to %s: %w", mv.slot, mv.From, mv.To, err)
+			ss.reshard.nodeProgress(mv.From).Err = err.Error()
+			ss.reshard.nodeProgress(mv.To).Err = err.Error()
+		}
+		ss.reshard.mu.Unlock()
+		if err != nil {
+			break
+		}
+	}
+
+	ss.updateReshard(func(st *ReshardStatus) {
+		st.Running, st.Finished, st.Moving = false, time.Now(), -1
+		if err != nil {
+			st.Err = err.Error()
+		}
+	})
+	if len(plan) > 0 {
+		log.Printf("Resharding finished: %d of %d slots moved", ss.ReshardStatus().SlotsMoved, len(plan))
+	}
+	return err
+}
+
+// moveSlot moves slot's keys from one node to another, at the pace the
+// migration limits allow, and hands the slot over. A source whose shard is
+// gone has nothing left to move.
+func (ss *SharedStore) moveSlot(ctx context.Context, slot int, from, to string) error {
+	dest, ok := ss.getShardByNodeID(to)
+	if !ok {
+		return fmt.Errorf("node %s is gone", to)
+	}
+	src, ok := ss.getShardByNodeID(from)
+	if !ok {
+		ss.finishSlot(slot, to)
+		return nil
+	}
+
+	ss.slots.mu.Lock()
+	if ss.slots.moving == nil {
+		ss.slots.moving = make(map[int]slotMove)
+	}
+	ss.slots.moving[slot] = slotMove{From: from, To: to}
+	ss.slots.mu.Unlock()
+
+	// commands on keys the source does not hold go to the destination from
+	// here on, so the index holds every key left to move. A write that
+	// resolved its store before the slot started moving may still land on
+	// the source, so the index is read again until it is empty.
+	for round := 0; round < reshardRounds; round++ {
+		keys := src.Store.keysInSlot(slot)
+		if len(keys) == 0 {
+			ss.finishSlot(slot, to)
+			return nil
+		}
+		for _, key := range keys {
+			if err := ss.migration.proceed(ctx); err != nil {
+				ss.abortSlot(slot, err)
+				return err
+			}
+			switch res := src.call("SLOTMOVE", key, dest).(type) {
+			case int:
+				if res == 0 {
+					continue
+				}
+				ss.reshard.mu.Lock()
+				ss.reshard.status.KeysMoved++
+				ss.reshard.status.BytesMoved += int64(res)
+				p := ss.reshard.nodeProgress(from)
+				p.KeysSent++
+				p.BytesSent += int64(res)
+				p = ss.reshard.nodeProgress(to)
+				p.KeysReceived++
+				p.BytesReceived += int64(res)
+				ss.reshard.mu.Unlock()
+				if err := ss.migration.pace(ctx, res); err != nil {
+					ss.abortSlot(slot, err)
+					return err
+				}
+			case error:
+				err := fmt.Errorf("moving %s: %w", key, res)
+				ss.abortSlot(slot, err)
+				return err
+			}
+		}
+	}
+	left := len(src.Store.keysInSlot(slot))
+	if left == 0 {
+		ss.finishSlot(slot, to)
+		return nil
+	}
+	err := fmt.Errorf("%w: %d", ErrReshardSource, left)
+	ss.abortSlot(slot, err)
+	return err
+}
+
+// finishSlot hands slot to its new owner
+func (ss *SharedStore) finishSlot(slot int, to string) {
+	ss.slots.mu.Lock()
+	defer ss.slots.mu.Unlock()
+	ss.slots.owners[slot] = to
+	delete(ss.slots.moving, slot)
+}
+
+// abortSlot leaves slot with its source, along with the keys moved so far:
+// the source sends commands on them to the destination until the next
+// reshard finishes the move
+func (ss *SharedStore) abortSlot(slot int, err error) {
+	ss.slots.mu.Lock()
+	defer ss.slots.mu.Unlock()
+	mv := ss.slots.moving[slot]
+	log.Printf("WARNING: Moving slot %d from %s to %s stopped (%v), keys already moved stay on %s", slot, mv.From, mv.To, err, mv.To)
+}
+
+// SLOTMOVE key; internal, sent by moveSlot to the source with the
+// destination shard as Payload. It runs on the source's worker, so no
+// command on key runs between the move and the source's check of whether
+// it holds key.
+func (s *Shard) cmdSlotMove(req ShardRequest) {
+	dest, _ := req.Payload.(*Shard)
+	if dest == nil {
+		req.Reply <- fmt.Errorf("SLOTMOVE requires a destination")
+		return
+	}
+	first, second := s.Store, dest.Store
+	if dest.nodeID < s.nodeID {
+		first, second = second, first
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+	size, err := moveKey(s.Store, dest.Store, req.Key)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- size
+}
+
+// moveKey moves key's value and expiry from one store to another and
+// returns the size of its encoding, 0 if there was no key to move. The
+// value goes by its full encoding, so a key of every type moves whole, and
+// the expiry as the instant it falls due rather than a rounded remaining
+// TTL. Callers hold both stores' locks, so the key is never in both or in
+// neither.
+func moveKey(from, to *Store, key string) (int, error) {
+	if from.expired(key) {
+		return 0, nil
+	}
+	v, ok := from.data.Get(key)
+	if !ok {
+		from.slotIndex.track(key, false) // removed without being accounted
+		return 0, nil
+	}
+	// a value is re-encoded rather than shared, as each store packs and
+	// interns its own
+	raw, err := encodeValue(v)
+	if err != nil {
+		return 0, err
+	}
+	moved, err := decodeValue(raw)
+	if err != nil {
+		return 0, err
+	}
+	ttl := from.ttl[key]
+	from.data.Delete(key)
+	from.clearTTL(key)
+	from.account(key, false)
+	to.expired(key)
+	to.putValue(key, moved, ttl)
+	to.account(key, false) // moved, not created: no "new" event
+	return len(raw), nil
+}
+
+// slotIndex lists the keys of every slot while a reshard moves slots off
+// the store. Guarded by Store.mu.
+type slotIndex struct {
+	slotOf func(key string) int // nil when not indexing
+	slots  map[int]map[string]struct{}
+}
+
+func (x *slotIndex) track(key string, present bool) {
+	if x.slotOf == nil {
+		return
+	}
+	slot := x.slotOf(key)
+	if !present {
+		delete(x.slots[slot], key)
+		return
+	}
+	keys := x.slots[slot]
+	if keys == nil {
+		keys = make(map[string]struct{})
+		x.slots[slot] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+// indexSlots starts indexing the store's keys by the slot slotOf routes
+// them by, or stops when it is nil
+func (s *Store) indexSlots(slotOf func(key string) int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slotIndex = slotIndex{slotOf: slotOf}
+	if slotOf == nil {
+		return
+	}
+	s.slotIndex.slots = make(map[int]map[string]struct{})
+	for k := range s.data.All() {
+		s.slotIndex.track(k, true)
+	}
+}
+
+// keysInSlot lists the keys of slot, sorted; the store must be indexing
+func (s *Store) keysInSlot(slot int) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.slotIndex.slots[slot]))
+	for k := range s.slotIndex.slots[slot] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// expireAt gives key the exact expiry at, as a restore would
+func expireAt(s *Store, key string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, _ := s.data.Get(key)
+	s.putValue(key, v, at)
+}
+
+// TestMoveKeyKeepsEveryTypeAndTTL moves a key of every value type between
+// two stores, as a reshard or REMOVENODE does, and checks that each arrives
+// whole with its expiry to the nanosecond
+func TestMoveKeyKeepsEveryTypeAndTTL(t *testing.T) {
+	discardLogs(t)
+	from, to := NewStore(), NewStore()
+
+	from.Set("str", []byte("value"), 0)
+	from.SAdd("set", "a", "b", "c")
+	from.HSet("hash", "f", "v", "g", "w")
+	from.RPush("list", "x", "y", "z")
+	from.ZAdd("zset", map[string]float64{"a": 1, "b": 2.5})
+	from.CMSIncr("cms", "item", 3)
+	from.BFAdd("bf", "a", "b")
+
+	keys := []string{"str", "set", "hash", "list", "zset", "cms", "bf"}
+	ttls := make(map[string]time.Time)
+	for i, k := range keys {
+		// sub-second, and different per key, so truncation would show
+		ttls[k] = time.Now().Add(time.Hour + time.Duration(i)*time.Millisecond + 123*time.Nanosecond)
+		expireAt(from, k, ttls[k])
+	}
+
+	from.mu.Lock()
+	to.mu.Lock()
+	for _, k := range keys {
+		if n, err := moveKey(from, to, k); err != nil || n == 0 {
+			t.Fatalf("moveKey(%s) = %d, %v", k, n, err)
+		}
+	}
+	to.mu.Unlock()
+	from.mu.Unlock()
+
+	for _, k := range keys {
+		if from.Exists(k) {
+			t.Errorf("%s still on the source store", k)
+		}
+		to.mu.RLock()
+		got := to.ttl[k]
+		to.mu.RUnlock()
+		if !got.Equal(ttls[k]) {
+			t.Errorf("%s expires at %v, want %v", k, got, ttls[k])
+		}
+	}
+
+	if v, ok := to.Get("str"); !ok || string(v) != "value" {
+		t.Errorf("str = %q, %v", v, ok)
+	}
+	members := to.SMembers("set")
+	sort.Strings(members)
+	if !reflect.DeepEqual(members, []string{"a", "b", "c"}) {
+		t.Errorf("set = %v", members)
+	}
+	if h := to.HGetAll("hash"); !reflect.DeepEqual(h, map[string]string{"f": "v", "g": "w"}) {
+		t.Errorf("hash = %v", h)
+	}
+	if l := to.LRange("list", 0, -1); !reflect.DeepEqual(l, []string{"x", "y", "z"}) {
+		t.Errorf("list = %v", l)
+	}
+	// ZRange walks the skip list, which the move rebuilds
+	if z := to.ZRange("zset", 0, -1, false); !reflect.DeepEqual(z, []string{"a", "b"}) {
+		t.Errorf("zset = %v", z)
+	}
+	if score, ok := to.ZScore("zset", "b"); !ok || score != 2.5 {
+		t.Errorf("zset b = %v, %v", score, ok)
+	}
+	if n := to.CMSQuery("cms", "item"); n != 3 {
+		t.Errorf("cms item = %d, want 3", n)
+	}
+	if found, err := to.BFExists("bf", "a", "b"); err != nil || !found[0] || !found[1] {
+		t.Errorf("bf = %v, %v", found, err)
+	}
+}
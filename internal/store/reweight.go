@@ -0,0 +1,126 @@
+package store
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// VNodeBounds limit automatic reweighting: no node goes below Min or above
+// Max virtual nodes. Max 0 is four times the virtual nodes a node joins
+// with.
+type VNodeBounds struct {
+	Min, Max int
+}
+
+// reweightTolerance is how far a node's load may stray from an even share
+// before its virtual nodes change
+const reweightTolerance = 0.25
+
+// VNodeChange is one reweighting step
+type VNodeChange struct {
+	NodeID   string
+	From, To int     // virtual nodes before and after
+	Load     float64 // the node's load relative to an even share, 1 = even
+}
+
+// reweightState keeps the operation counts of the last Reweight, to measure
+// rates since
+type reweightState struct {
+	mu      sync.Mutex
+	ops     map[string]uint64
+	at      time.Time
+	changes atomic.Uint64
+}
+
+// Reweight measures every node's operations per second since the last call
+// and its memory, and moves the node furthest from an even share one step
+// toward it: fewer virtual nodes if it carries more than its share, more if
+// less. Steps are a tenth of the node's virtual nodes, at least one, so hot
+// spots are smoothed over several calls. It reports false when no node is
+// out of tolerance, on the first call, or when bounds stop every step. The
+// caller moves the slots the change gives new owners, see Reshard.
+func (ss *SharedStore) Reweight(b VNodeBounds) (VNodeChange, bool) {
+	stats := ss.ShardStats()
+
+	ss.reweight.mu.Lock()
+	defer ss.reweight.mu.Unlock()
+	now := time.Now()
+	prev, elapsed := ss.reweight.ops, now.Sub(ss.reweight.at).Seconds()
+	ops := make(map[string]uint64, len(stats))
+	for _, st := range stats {
+		for _, l := range st.Lanes {
+			ops[st.NodeID] += l.Processed
+		}
+	}
+	ss.reweight.ops, ss.reweight.at = ops, now
+	if prev == nil || len(stats) < 2 || elapsed <= 0 {
+		return VNodeChange{}, false
+	}
+
+	rates := make(map[string]float64, len(stats))
+	var totalRate, totalMem float64
+	for _, st := range stats {
+		if p, ok := prev[st.NodeID]; ok && ops[st.NodeID] >= p {
+			rates[st.NodeID] = float64(ops[st.NodeID]-p) / elapsed
+		}
+		totalRate += rates[st.NodeID]
+		totalMem += float64(st.MemoryBytes)
+	}
+	if totalRate == 0 && totalMem == 0 {
+		return VNodeChange{}, false
+	}
+
+	// a node's load averages its shares of throughput and memory, scaled so
+	// an even split is 1
+	n := float64(len(stats))
+	loads := make([]VNodeChange, 0, len(stats))
+	for _, st := range stats {
+		var load float64
+		var dims int
+		if totalRate > 0 {
+			load += rates[st.NodeID] / totalRate * n
+			dims++
+		}
+		if totalMem > 0 {
+			load += float64(st.MemoryBytes) / totalMem * n
+			dims++
+		}
+		loads = append(loads, VNodeChange{NodeID: st.NodeID, From: st.VNodes, Load: load / float64(dims)})
+	}
+	sort.Slice(loads, func(i, j int) bool {
+		return math.Abs(loads[i].Load-1) > math.Abs(loads[j].Load-1)
+	})
+
+	lo, hi := max(b.Min, 1), b.Max
+	if hi == 0 {
+		hi = 4 * ss.ring.replicas
+	}
+	for _, c := range loads {
+		if math.Abs(c.Load-1) <= reweightTolerance {
+			break
+		}
+		step := max(c.From/10, 1)
+		if c.Load > 1 {
+			c.To = max(c.From-step, lo)
+		} else {
+			c.To = min(c.From+step, hi)
+		}
+		if c.To == c.From || c.From == 0 {
+			continue
+		}
+		ss.mu.Lock()
+		ss.ring.SetVNodes(c.NodeID, c.To)
+		ss.mu.Unlock()
+		ss.reweight.changes.Add(1)
+		return c, true
+	}
+	return VNodeChange{}, false
+}
+
+// Reweights counts the virtual node changes Reweight has made
+func (ss *SharedStore) Reweights() uint64 {
+	return ss.reweight.changes.Load()
+}
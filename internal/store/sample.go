@@ -0,0 +1,127 @@
+package store
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+var ErrSampleWeights = errors.New("ERR WEIGHTED needs a sorted set, whose scores are the weights")
+
+// Sample returns up to count distinct members of the set or sorted set at
+// key, each sample equally likely. With weighted, a sorted set member is
+// drawn with probability proportional to its score, and members scoring
+// zero or less are never drawn. withScores follows each zset member with
+// its score. One pass keeps only count members, so sampling a large key
+// costs no more memory than the sample.
+func (s *Store) Sample(key string, count int, weighted, withScores bool) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return []string{}, nil
+	}
+	val, ok := s.lookupRead(key)
+	if !ok || count <= 0 {
+		return []string{}, nil
+	}
+
+	var out []string
+	switch val.Type {
+	case SetType:
+		if weighted || withScores {
+			return nil, ErrSampleWeights
+		}
+		out = reservoir(min(count, val.setLen()), func(yield func(string)) {
+			for m := range val.setMembers() {
+				yield(m)
+			}
+		})
+	case ZSetType:
+		count = min(count, len(val.ZSet))
+		var picked []string
+		if weighted {
+			picked = weightedSample(val.ZSet, count)
+		} else {
+			picked = reservoir(count, func(yield func(string)) {
+				for m := range val.ZSet {
+					yield(m)
+				}
+			})
+		}
+		if !withScores {
+			out = picked
+			break
+		}
+		out = make([]string, 0, 2*len(picked))
+		for _, m := range picked {
+			out = append(out, m, strconv.FormatFloat(val.ZSet[m], 'f', -1, 64))
+		}
+	default:
+		return nil, ErrWrongType
+	}
+	s.touch(key)
+	return out, nil
+}
+
+// reservoir keeps a uniform sample of count items from each (Algorithm R)
+func reservoir(count int, each func(yield func(string))) []string {
+	res := make([]string, 0, count)
+	seen := 0
+	each(func(m string) {
+		seen++
+		if len(res) < count {
+			res = append(res, m)
+		} else if j := rand.Intn(seen); j < count {
+			res[j] = m
+		}
+	})
+	rand.Shuffle(len(res), func(i, j int) { res[i], res[j] = res[j], res[i] })
+	return res
+}
+
+// weightedSample draws count members without replacement, weighted by score
+// (Efraimidis-Spirakis): each member gets the key u^(1/w) for a uniform u,
+// and the count largest keys win. Keys are compared as ln(u)/w to keep
+// small weights from underflowing.
+func weightedSample(zset map[string]float64, count int) []string {
+	h := make(sampleHeap, 0, count)
+	for m, w := range zset {
+		if !(w > 0) {
+			continue
+		}
+		k := math.Log(1-rand.Float64()) / w
+		if len(h) < count {
+			heap.Push(&h, sampleItem{member: m, key: k})
+		} else if k > h[0].key {
+			h[0] = sampleItem{member: m, key: k}
+			heap.Fix(&h, 0)
+		}
+	}
+	out := make([]string, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(&h).(sampleItem).member
+	}
+	return out
+}
+
+type sampleItem struct {
+	member string
+	key    float64
+}
+
+// sampleHeap is a min-heap on key, so the weakest of the sample is on top
+type sampleHeap []sampleItem
+
+func (h sampleHeap) Len() int           { return len(h) }
+func (h sampleHeap) Less(i, j int) bool { return h[i].key < h[j].key }
+func (h sampleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x any)        { *h = append(*h, x.(sampleItem)) }
+func (h *sampleHeap) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
@@ -0,0 +1,207 @@
+package store
+
+import "fmt"
+
+// SetOp is a set algebra operation over several keys
+type SetOp int
+
+const (
+	SetUnion SetOp = iota // members of any set
+	SetInter              // members of every set
+	SetDiff               // members of the first set and none of the others
+)
+
+// storeEvent is the keyspace event of storing op's result
+func (op SetOp) storeEvent() string {
+	switch op {
+	case SetInter:
+		return "sinterstore"
+	case SetDiff:
+		return "sdiffstore"
+	}
+	return "sunionstore"
+}
+
+// setsBatch is a shard's reply to SETMEMBERS: the members of the key if it
+// holds a set, nothing if the key is missing
+type setsBatch struct {
+	sets map[string]map[string]struct{}
+}
+
+// SetOp computes the union, intersection or difference of the sets at keys,
+// which may live on any shards. The sets are read with ExecuteMulti, every
+// shard reading its share in one request, all shards at once, and merged
+// here. Missing keys count as empty sets; a key holding another type is an
+// ErrWrongType. The sets are not read at one instant, so a write racing the
+// read may be seen on one shard and not another.
+func (ss *SharedStore) SetOp(op SetOp, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sets, err := ss.readSets(keys)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSets(op, keys, sets), nil
+}
+
+// SetOpStore stores the result of SetOp in dest, replacing whatever dest
+// held and deleting it if the result is empty, and returns its cardinality
+func (ss *SharedStore) SetOpStore(op SetOp, dest string, keys []string) (int, error) {
+	members, err := ss.SetOp(op, keys)
+	if err != nil {
+		return 0, err
+	}
+	res := ss.Execute("SETSTORE", dest, append([]string{op.storeEvent()}, members...)...)
+	if err, ok := res.(error); ok {
+		return 0, err
+	}
+	n, _ := res.(int)
+	return n, nil
+}
+
+// readSets reads the sets at keys through ExecuteMulti, one SETMEMBERS
+// request per shard
+func (ss *SharedStore) readSets(keys []string) (map[string]map[string]struct{}, error) {
+	sets := make(map[string]map[string]struct{}, len(keys))
+	for _, r := range ss.ExecuteMulti("SETMEMBERS", KeyOps(keys)) {
+		switch v := r.(type) {
+		case setsBatch:
+			for k, members := range v.sets {
+				sets[k] = members
+			}
+		case error:
+			return nil, v
+		}
+	}
+	return sets, nil
+}
+
+// mergeSets applies op to the sets read for keys, in key order
+func mergeSets(op SetOp, keys []string, sets map[string]map[string]struct{}) []string {
+	result := make(map[string]struct{})
+	switch op {
+	case SetUnion:
+		for _, k := range keys {
+			for m := range sets[k] {
+				result[m] = struct{}{}
+			}
+		}
+	case SetInter:
+		// walk the smallest set, probing the others
+		smallest := sets[keys[0]]
+		for _, k := range keys {
+			if len(sets[k]) < len(smallest) {
+				smallest = sets[k]
+			}
+		}
+	members:
+		for m := range smallest {
+			for _, k := range keys {
+				if _, ok := sets[k][m]; !ok {
+					continue members
+				}
+			}
+			result[m] = struct{}{}
+		}
+	case SetDiff:
+		for m := range sets[keys[0]] {
+			result[m] = struct{}{}
+		}
+		for _, k := range keys[1:] {
+			for m := range sets[k] {
+				delete(result, m)
+			}
+		}
+	}
+
+	out := make([]string, 0, len(result))
+	for m := range result {
+		out = append(out, m)
+	}
+	return out
+}
+
+// setsOf copies the members of the set at every key that holds one
+func (s *Store) setsOf(keys []string) (map[string]map[string]struct{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sets := make(map[string]map[string]struct{}, len(keys))
+	for _, k := range keys {
+		if s.expiredRead(k) {
+			continue
+		}
+		val, ok := s.lookupRead(k)
+		if !ok {
+			continue
+		}
+		if val.Type != SetType {
+			return nil, ErrWrongType
+		}
+		s.touch(k)
+		members := make(map[string]struct{}, val.setLen())
+		for m := range val.setMembers() {
+			members[m] = struct{}{}
+		}
+		sets[k] = members
+	}
+	return sets, nil
+}
+
+// storeSet replaces whatever key holds with a set of members, or deletes
+// key if there are none, and returns the set's cardinality. event names the
+// command storing it, for keyspace notifications.
+func (s *Store) storeSet(key, event string, members []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expired(key)
+	if len(members) == 0 {
+		if _, ok := s.data.Get(key); ok {
+			s.data.Delete(key)
+			s.clearTTL(key)
+			s.notify(EventGeneric, "del", key)
+		}
+		return 0, nil
+	}
+	if err := s.checkElements(members); err != nil {
+		return 0, err
+	}
+	if err := s.checkCollectionLen(len(members)); err != nil {
+		return 0, err
+	}
+	val := s.newSetValue()
+	for _, m := range members {
+		s.setAdd(&val, m)
+	}
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.clearTTL(key)
+	s.notify(EventSet, event, key)
+	return val.setLen(), nil
+}
+
+// SETMEMBERS key; internal, sent by SetOp through ExecuteMulti
+func (s *Shard) cmdSetMembers(req ShardRequest) {
+	sets, err := s.Store.setsOf([]string{req.Key})
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- setsBatch{sets: sets}
+}
+
+// SETSTORE key event [member ...]; internal, sent by SetOpStore
+func (s *Shard) cmdSetStore(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- fmt.Errorf("SETSTORE requires an event")
+		return
+	}
+	n, err := s.Store.storeSet(req.Key, req.Args[0], req.Args[1:])
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
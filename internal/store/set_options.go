@@ -0,0 +1,139 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSyntax           = errors.New("ERR syntax error")
+	ErrInvalidSetExpire = errors.New("ERR invalid expire time in 'set' command")
+)
+
+// SetOptions carries the SET command modifiers
+type SetOptions struct {
+	NX       bool      // only set if the key does not exist
+	XX       bool      // only set if the key already exists
+	KeepTTL  bool      // retain the existing expiration
+	Get      bool      // return the old value
+	ExpireAt time.Time // absolute expiration; zero => no expiration
+}
+
+// ParseSetOptions parses the tokens following "SET key value":
+// [NX | XX] [GET] [EX seconds | PX milliseconds | EXAT unix-sec | PXAT unix-ms | KEEPTTL]
+// Relative expirations are resolved against now.
+func ParseSetOptions(tokens []string, now time.Time) (SetOptions, error) {
+	var opts SetOptions
+	expireSet := false
+
+	for i := 0; i < len(tokens); i++ {
+		opt := strings.ToUpper(tokens[i])
+		switch opt {
+		case "NX":
+			if opts.XX {
+				return opts, ErrSyntax
+			}
+			opts.NX = true
+		case "XX":
+			if opts.NX {
+				return opts, ErrSyntax
+			}
+			opts.XX = true
+		case "GET":
+			opts.Get = true
+		case "KEEPTTL":
+			if expireSet {
+				return opts, ErrSyntax
+			}
+			opts.KeepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expireSet || opts.KeepTTL || i+1 >= len(tokens) {
+				return opts, ErrSyntax
+			}
+			i++
+			n, err := strconv.ParseInt(tokens[i], 10, 64)
+			if err != nil {
+				return opts, ErrNotInteger
+			}
+			// a larger n overflows a time.Duration; Redis refuses it too
+			unit := time.Second
+			if opt == "PX" || opt == "PXAT" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				return opts, ErrInvalidSetExpire
+			}
+			switch opt {
+			case "EX", "PX":
+				opts.ExpireAt = now.Add(time.Duration(n) * unit)
+			case "EXAT":
+				opts.ExpireAt = time.Unix(n, 0)
+			case "PXAT":
+				opts.ExpireAt = time.UnixMilli(n)
+			}
+			expireSet = true
+		default:
+			return opts, ErrSyntax
+		}
+	}
+	return opts, nil
+}
+
+// SetWithOptions stores a string value honoring NX/XX/KEEPTTL/GET in one
+// critical section. It returns the previous string value (if any) and whether
+// the write was applied.
+func (s *Store) SetWithOptions(key string, val []byte, opts SetOptions) (old []byte, hadOld bool, applied bool, err error) {
+	if err := s.checkValueSize(len(val)); err != nil {
+		return nil, false, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired(key) {
+		s.data.Delete(key)
+	}
+
+	cur, exists := s.data.Get(key)
+	if exists && opts.Get && cur.Type != StringType {
+		return nil, false, false, ErrWrongType
+	}
+	if exists && cur.Type == StringType {
+		old, hadOld = cur.Data, true
+	}
+	if (opts.NX && exists) || (opts.XX && !exists) {
+		return old, hadOld, false, nil
+	}
+
+	now := s.now()
+	if !opts.ExpireAt.IsZero() && !opts.ExpireAt.After(now) {
+		// an absolute expiration in the past deletes the key right away
+		s.data.Delete(key)
+		s.clearTTL(key)
+		s.notify(EventString, "set", key)
+		s.notify(EventGeneric, "del", key)
+		return old, hadOld, true, nil
+	}
+
+	s.data.Put(key, Value{
+		Type:       StringType,
+		Data:       val,
+		LastAccess: now.UnixNano(),
+	})
+	switch {
+	case opts.KeepTTL:
+		// leave any existing expiration in place
+	case !opts.ExpireAt.IsZero():
+		s.setTTL(key, opts.ExpireAt)
+	default:
+		s.clearTTL(key)
+	}
+	s.notify(EventString, "set", key)
+	if !opts.ExpireAt.IsZero() {
+		s.notify(EventGeneric, "expire", key)
+	}
+	return old, hadOld, true, nil
+}
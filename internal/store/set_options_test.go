@@ -0,0 +1,84 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSetOptions(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		tokens string
+		want   SetOptions
+		err    error
+	}{
+		{"", SetOptions{}, nil},
+		{"nx get", SetOptions{NX: true, Get: true}, nil},
+		{"XX KEEPTTL", SetOptions{XX: true, KeepTTL: true}, nil},
+		{"EX 10", SetOptions{ExpireAt: now.Add(10 * time.Second)}, nil},
+		{"px 1500", SetOptions{ExpireAt: now.Add(1500 * time.Millisecond)}, nil},
+		{"EXAT 2000", SetOptions{ExpireAt: time.Unix(2000, 0)}, nil},
+		{"PXAT 2000500", SetOptions{ExpireAt: time.UnixMilli(2000500)}, nil},
+
+		{"NX XX", SetOptions{}, ErrSyntax},
+		{"EX 10 PX 10", SetOptions{}, ErrSyntax},
+		{"EX 10 KEEPTTL", SetOptions{}, ErrSyntax},
+		{"KEEPTTL EX 10", SetOptions{}, ErrSyntax},
+		{"EX", SetOptions{}, ErrSyntax},
+		{"BOGUS", SetOptions{}, ErrSyntax},
+		{"EX ten", SetOptions{}, ErrNotInteger},
+		{"EX 0", SetOptions{}, ErrInvalidSetExpire},
+		{"PX -5", SetOptions{}, ErrInvalidSetExpire},
+		// seconds past what a time.Duration holds
+		{"EX 9223372037", SetOptions{}, ErrInvalidSetExpire},
+		{"PX 9223372036855", SetOptions{}, ErrInvalidSetExpire},
+	}
+	for _, tt := range tests {
+		got, err := ParseSetOptions(strings.Fields(tt.tokens), now)
+		if err != tt.err {
+			t.Errorf("ParseSetOptions(%q) error = %v, want %v", tt.tokens, err, tt.err)
+			continue
+		}
+		if err == nil && (got.NX != tt.want.NX || got.XX != tt.want.XX || got.KeepTTL != tt.want.KeepTTL ||
+			got.Get != tt.want.Get || !got.ExpireAt.Equal(tt.want.ExpireAt)) {
+			t.Errorf("ParseSetOptions(%q) = %+v, want %+v", tt.tokens, got, tt.want)
+		}
+	}
+}
+
+func TestSetWithOptions(t *testing.T) {
+	s := NewStore()
+
+	if _, _, applied, _ := s.SetWithOptions("k", []byte("a"), SetOptions{XX: true}); applied {
+		t.Error("SET XX applied to a missing key")
+	}
+	if _, _, applied, _ := s.SetWithOptions("k", []byte("a"), SetOptions{NX: true}); !applied {
+		t.Error("SET NX was not applied to a missing key")
+	}
+	old, hadOld, applied, _ := s.SetWithOptions("k", []byte("b"), SetOptions{NX: true, Get: true})
+	if applied || !hadOld || string(old) != "a" {
+		t.Errorf("SET NX GET on an existing key = %q, %v, %v", old, hadOld, applied)
+	}
+
+	s.SetWithOptions("k", []byte("c"), SetOptions{ExpireAt: time.Now().Add(time.Hour)})
+	s.SetWithOptions("k", []byte("d"), SetOptions{KeepTTL: true})
+	if s.TTL("k") <= 0 {
+		t.Error("KEEPTTL dropped the expiration")
+	}
+	s.SetWithOptions("k", []byte("e"), SetOptions{})
+	if ttl := s.TTL("k"); ttl != -1 {
+		t.Errorf("a plain SET left TTL %d, want -1", ttl)
+	}
+
+	// an absolute time in the past deletes the key
+	s.SetWithOptions("k", []byte("f"), SetOptions{ExpireAt: time.Unix(1, 0)})
+	if _, ok := s.Get("k"); ok {
+		t.Error("SET EXAT in the past kept the key")
+	}
+
+	s.HSet("h", "f", "v")
+	if _, _, _, err := s.SetWithOptions("h", []byte("x"), SetOptions{Get: true}); err != ErrWrongType {
+		t.Errorf("SET GET on a hash error = %v, want %v", err, ErrWrongType)
+	}
+}
@@ -1,21 +1,40 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"runtime/debug"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Shard struct {
-	Store  *Store
-	inbox  chan ShardRequest
-	quit   chan struct{}
-	done   chan struct{}
-	nodeID string
-	parent *SharedStore
+	Store     *Store
+	inbox     chan ShardRequest // slow lane: expensive and internal commands
+	fastInbox chan ShardRequest // fast lane: O(1) commands, always served first
+	quit      chan struct{}
+	done      chan struct{}
+	nodeID    string
+	parent    *SharedStore
+	lanes     [2]laneCounters
+	direct    atomic.Uint64 // reads served on the caller's goroutine
+	restarts  atomic.Uint64
+
+	// inflight is the request being handled, failed if the handler panics;
+	// only touched by Run
+	inflight *ShardRequest
+
+	// read-only copies of hot keys owned by other shards; only touched by Run
+	hotReplicas map[string]hotReplica
 }
 
+// ErrShardRestarted is the reply to a request whose handler crashed the
+// shard worker. The worker restarts; the request was not applied, or only
+// partly, and may be retried.
+var ErrShardRestarted = errors.New("TRYAGAIN shard worker restarted while handling the command, retry")
+
 type ShardRequest struct {
 	Command  string
 	Key      string
@@ -23,6 +42,33 @@ type ShardRequest struct {
 	Reply    chan interface{}
 	internal bool // mark interbal ops
 	Payload  interface{}
+	enqueued time.Time    // set by enqueue, used for lane wait metrics
+	pooled   *workerReply // Reply's pool entry, nil if the sender owns Reply
+	hops     int          // times redirected to another shard, see SharedStore.send
+}
+
+const (
+	fastLane = iota
+	slowLane
+)
+
+// fastBurst is how many fast requests in a row the worker serves before it
+// gives the slow lane and its timers a turn
+const fastBurst = 32
+
+type laneCounters struct {
+	processed atomic.Uint64
+	waitNanos atomic.Int64
+	maxWait   atomic.Int64
+}
+
+// LaneStats is a snapshot of queueing metrics for one shard lane
+type LaneStats struct {
+	Lane      string
+	Queued    int
+	Processed uint64
+	AvgWait   time.Duration
+	MaxWait   time.Duration
 }
 
 type KeyDump struct {
@@ -34,381 +80,267 @@ type KeyDump struct {
 
 func NewShard(s *Store) *Shard {
 	shard := &Shard{
-		Store: s,
-		inbox: make(chan ShardRequest, 100),
-		quit:  make(chan struct{}),
-		done:  make(chan struct{}),
+		Store:     s,
+		inbox:     make(chan ShardRequest, 100),
+		fastInbox: make(chan ShardRequest, 100),
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+
+		hotReplicas: make(map[string]hotReplica),
 	}
 	return shard
 }
 
+// enqueue routes a request to the fast or slow lane based on its command
+func (s *Shard) enqueue(req ShardRequest) {
+	req.enqueued = time.Now()
+	if isFast(req) {
+		s.fastInbox <- req
+		return
+	}
+	s.inbox <- req
+}
+
+// call runs an internal request on s, bypassing slot forwarding, and
+// returns its reply
+func (s *Shard) call(cmd, key string, payload interface{}) interface{} {
+	req := ShardRequest{
+		Command:  cmd,
+		Key:      key,
+		Payload:  payload,
+		Reply:    make(chan interface{}, 1),
+		internal: true,
+	}
+	s.enqueue(req)
+	return <-req.Reply
+}
+
+// process handles one request and records how long it waited in its lane
+func (s *Shard) process(lane int, req ShardRequest) {
+	if !req.enqueued.IsZero() {
+		wait := int64(time.Since(req.enqueued))
+		c := &s.lanes[lane]
+		c.processed.Add(1)
+		c.waitNanos.Add(wait)
+		for {
+			cur := c.maxWait.Load()
+			if wait <= cur || c.maxWait.CompareAndSwap(cur, wait) {
+				break
+			}
+		}
+	}
+	s.inflight = &req
+	s.Store.pinClock()
+	s.handle(req)
+	s.Store.unpinClock()
+	s.inflight = nil
+	req.pooled.release()
+}
+
+// LaneStats returns queue wait metrics for the fast and slow lanes, and
+// how many reads skipped the queues
+func (s *Shard) LaneStats() []LaneStats {
+	out := make([]LaneStats, 0, len(s.lanes)+1)
+	for lane, name := range []string{"fast", "slow"} {
+		c := &s.lanes[lane]
+		st := LaneStats{
+			Lane:      name,
+			Processed: c.processed.Load(),
+			MaxWait:   time.Duration(c.maxWait.Load()),
+		}
+		if lane == fastLane {
+			st.Queued = len(s.fastInbox)
+		} else {
+			st.Queued = len(s.inbox)
+		}
+		if st.Processed > 0 {
+			st.AvgWait = time.Duration(c.waitNanos.Load() / int64(st.Processed))
+		}
+		out = append(out, st)
+	}
+	return append(out, LaneStats{Lane: "direct", Processed: s.direct.Load()})
+}
+
+// Run serves the shard's requests until it is removed. If a handler panics,
+// the request being handled fails with ErrShardRestarted and the worker
+// starts over on the same Store; queued requests stay in the lanes and are
+// served by the new loop.
 func (s *Shard) Run() {
-	defer close(s.done)
+	defer func() {
+		if err := s.Store.Close(); err != nil {
+			log.Printf("ERROR: %s - Failed to close store: %v", s.nodeID, err)
+		}
+		close(s.done)
+	}()
 
 	// Signal that we're ready to process requests
 	ready := make(chan interface{}, 1)
 	ready <- struct{}{}
 	s.inbox <- ShardRequest{
-		Command: "_INTERNAL_READY",
-		Reply:   ready,
+		Command:  "_INTERNAL_READY",
+		Reply:    ready,
+		internal: true, // keyless, so never routed to another shard
 	}
 	<-ready
 
+	for !s.serve() {
+		n := s.restarts.Add(1)
+		log.Printf("ERROR: %s - Restarting shard worker (%d restarts)", s.nodeID, n)
+	}
+}
+
+// Restarts is how many times the worker recovered from a panic
+func (s *Shard) Restarts() uint64 {
+	return s.restarts.Load()
+}
+
+// serve is the worker loop. It returns true once the shard is told to quit
+// and false after recovering from a panicking handler.
+func (s *Shard) serve() (stopped bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("ERROR: %s - Shard worker panicked: %v\n%s", s.nodeID, r, debug.Stack())
+		if req := s.inflight; req != nil && req.Reply != nil {
+			select {
+			case req.Reply <- ErrShardRestarted:
+			default: // already answered before the panic
+			}
+			req.pooled.release()
+		}
+		s.inflight = nil
+		s.Store.unpinClock()
+		stopped = false
+	}()
+
+	// evict in the background too, for memory that arrives via migration
+	evictTicker := time.NewTicker(100 * time.Millisecond)
+	defer evictTicker.Stop()
+	// active expiry, at a rate activeExpireCycle adapts; see expire.go
+	expireTimer := time.NewTimer(s.Store.expireInterval())
+	defer expireTimer.Stop()
+
+	burst := 0
 	for {
+		// Serve the fast lane first so cheap commands don't queue behind
+		// scans, but only fastBurst in a row: then a waiting slow request
+		// goes next and everything else gets a fair select, so a saturated
+		// fast lane cannot starve the slow one.
+		if burst < fastBurst {
+			select {
+			case req := <-s.fastInbox:
+				s.process(fastLane, req)
+				burst++
+				continue
+			default:
+			}
+		} else {
+			select {
+			case req := <-s.inbox:
+				s.process(slowLane, req)
+			default:
+			}
+		}
+		burst = 0
+
 		select {
+		case req := <-s.fastInbox:
+			s.process(fastLane, req)
 		case req := <-s.inbox:
-			s.handle(req)
+			s.process(slowLane, req)
+		case key := <-s.Store.lazy.expired:
+			s.Store.reapExpired(key)
+		case <-evictTicker.C:
+			s.Store.foldTouches()
+			s.Store.demoteIdle()
+			if s.Store.overMaxMemory() {
+				s.Store.FreeMemory()
+			}
+		case <-expireTimer.C:
+			expireTimer.Reset(s.Store.activeExpireCycle())
+		case <-s.Store.cleaner.wake:
+			// new settings, or a key due before the timer fires
+			if !expireTimer.Stop() {
+				select {
+				case <-expireTimer.C:
+				default:
+				}
+			}
+			expireTimer.Reset(s.Store.activeExpireCycle())
 		case <-s.quit:
 			// Drain remaining requests before exiting
 			for {
 				select {
+				case req := <-s.fastInbox:
+					s.process(fastLane, req)
 				case req := <-s.inbox:
-					s.handle(req)
+					s.process(slowLane, req)
 				default:
-					return
+					return true
 				}
 			}
 		}
 	}
 }
 
+// handle runs req, or when its key's slot belongs to another shard,
+// replies with a redirect for the sender to follow. The worker never waits
+// on another shard, so two shards disagreeing about a slot cannot deadlock.
 func (s *Shard) handle(req ShardRequest) {
-	//check if key should live on this shard (ring authoritative)
 	if s.parent != nil && !req.internal {
-		targetNode, _ := s.parent.ring.GetNode(req.Key)
-		if targetNode != "" && targetNode != s.nodeID {
-			//forward request to the correct shard
+		if targetNode, _ := s.parent.ownerOf(req.Key); targetNode != "" && targetNode != s.nodeID {
 			if dest, ok := s.parent.getShardByNodeID(targetNode); ok {
-				//forward : keep req but make sure Reply exists
-				if req.Reply == nil {
-					// if no reply expected, create a temp chan to avoid blocking
-					req.Reply = make(chan interface{}, 1)
-				}
-				dest.inbox <- req
-				//wait for resp and return to original caller
-				resp := <-req.Reply
-				//write back to reply if this was external
-				if req.Reply != nil {
-					req.Reply <- resp
-				}
-				return
-			} else {
-				// destination not found : return MOVED-like error
-				if req.Reply != nil {
-					req.Reply <- fmt.Errorf("MOVED: key %s should be on node %s", req.Key, targetNode)
-				}
-				return
-			}
-		}
-	}
-
-	cmd := strings.ToUpper(req.Command)
-	log.Printf("DEBUG: %s - Processing %s command in shard %s", req.Key, cmd, s.nodeID)
-
-	switch cmd {
-	case "SET":
-		if len(req.Args) < 1 {
-			log.Printf("ERROR: %s - SET command missing value argument", req.Key)
-			req.Reply <- fmt.Errorf("SET requires at least 1 argument")
-			return
-		}
-		val := []byte(req.Args[0])
-		var expire time.Duration
-		if len(req.Args) >= 2 {
-			dur, err := time.ParseDuration(req.Args[1])
-			if err != nil {
-				log.Printf("ERROR: %s - Invalid expiration duration: %v", req.Key, err)
-				req.Reply <- fmt.Errorf("invalid duration: %v", err)
+				req.Reply <- misrouted{to: dest}
 				return
 			}
-			expire = dur
-		}
-		expireStr := ""
-		if expire > 0 {
-			expireStr = fmt.Sprintf(" and expiration %v", expire)
-		}
-		log.Printf("DEBUG: %s - Setting value with length %d bytes%s",
-			req.Key, len(val), expireStr)
-		s.Store.Set(req.Key, val, expire)
-		log.Printf("DEBUG: %s - Successfully set value", req.Key)
-		req.Reply <- "OK"
-	case "GET":
-		val, found := s.Store.Get(req.Key)
-		if !found {
-			req.Reply <- nil
-		} else {
-			req.Reply <- val
-		}
-	case "DEL":
-		deleted := s.Store.Delete(req.Key)
-		req.Reply <- deleted
-	case "SADD":
-		if len(req.Args) < 1 {
-			req.Reply <- 0
-			return
-		}
-		added := s.Store.SAdd(req.Key, req.Args...)
-		req.Reply <- added
-	case "SREM":
-		if len(req.Args) < 1 {
-			req.Reply <- 0
-			return
-		}
-		removed := s.Store.SRem(req.Key, req.Args...)
-		req.Reply <- removed
-	case "SMEMBERS":
-		members := s.Store.SMembers(req.Key)
-		req.Reply <- members
-	case "SCARD":
-		card := s.Store.SCard(req.Key)
-		req.Reply <- card
-	case "SISMEMBER":
-		if len(req.Args) < 1 {
-			req.Reply <- false
-			return
-		}
-		ok := s.Store.SIsMember(req.Key, req.Args[0])
-		req.Reply <- ok
-	case "SUNION":
-		members := s.Store.SUnion(append([]string{req.Key}, req.Args...)...)
-		req.Reply <- members
-	case "SINTER":
-		members := s.Store.SInter(append([]string{req.Key}, req.Args...)...)
-		req.Reply <- members
-	case "SDIFF":
-		members := s.Store.SDiff(append([]string{req.Key}, req.Args...)...)
-		req.Reply <- members
-	case "SPOP":
-		count := 1
-		if len(req.Args) >= 1 {
-			fmt.Sscanf(req.Args[0], "%d", &count)
-		}
-		members := s.Store.SPop(req.Key, count)
-		req.Reply <- members
-	case "SRANDMEMBER":
-		count := 0
-		if len(req.Args) >= 1 {
-			fmt.Sscanf(req.Args[0], "%d", &count)
-		}
-		members := s.Store.SRandMember(req.Key, count)
-		req.Reply <- members
-	case "HSET":
-		if len(req.Args) < 2 {
-			req.Reply <- 0
-			return
-		}
-		n := s.Store.HSet(req.Key, req.Args[0], req.Args[1])
-		req.Reply <- n
-	case "HGET":
-		if len(req.Args) < 1 {
-			req.Reply <- ""
-			return
-		}
-		val, _ := s.Store.HGet(req.Key, req.Args[0])
-		req.Reply <- val
-	case "HDEL":
-		if len(req.Args) < 1 {
-			req.Reply <- 0
-			return
-		}
-		deleted := s.Store.HDel(req.Key, req.Args...)
-		req.Reply <- deleted
-	case "HGETALL":
-		result := s.Store.HGetAll(req.Key)
-		req.Reply <- result
-	case "CMSINCR":
-		if len(req.Args) < 2 {
-			req.Reply <- nil
-			return
-		}
-		var count uint32
-		fmt.Sscanf(req.Args[1], "%d", &count)
-		s.Store.CMSIncr(req.Key, req.Args[0], count)
-		req.Reply <- true
-	case "CMSQUERY":
-		if len(req.Args) < 1 {
-			req.Reply <- uint32(0)
-			return
-		}
-		count := s.Store.CMSQuery(req.Key, req.Args[0])
-		req.Reply <- count
-	case "LPUSH":
-		if len(req.Args) < 1 {
-			req.Reply <- -1
-			return
-		}
-		newLen := s.Store.LPush(req.Key, req.Args...)
-		req.Reply <- newLen
-	case "RPUSH":
-		if len(req.Args) < 1 {
-			req.Reply <- -1
-			return
-		}
-		newLen := s.Store.RPush(req.Key, req.Args...)
-		req.Reply <- newLen
-	case "LPOP":
-		val, _ := s.Store.LPop(req.Key)
-		req.Reply <- val
-	case "RPOP":
-		val, _ := s.Store.RPop(req.Key)
-		req.Reply <- val
-	case "LLEN":
-		length := s.Store.LLen(req.Key)
-		req.Reply <- length
-	case "LRANGE":
-		if len(req.Args) < 2 {
-			req.Reply <- nil
-			return
-		}
-		var start, stop int
-		fmt.Sscanf(req.Args[0], "%d", &start)
-		fmt.Sscanf(req.Args[1], "%d", &stop)
-		result := s.Store.LRange(req.Key, start, stop)
-		req.Reply <- result
-	case "ZADD":
-		if len(req.Args) < 2 || len(req.Args)%2 != 0 {
-			req.Reply <- -1
-			return
-		}
-		members := make(map[string]float64)
-		for i := 0; i < len(req.Args); i += 2 {
-			score := 0.0
-			fmt.Sscanf(req.Args[i], "%f", &score)
-			members[req.Args[i+1]] = score
-		}
-		added := s.Store.ZAdd(req.Key, members)
-		req.Reply <- added
-	case "ZSCORE":
-		if len(req.Args) < 1 {
-			req.Reply <- 0.0
-			return
-		}
-		score, _ := s.Store.ZScore(req.Key, req.Args[0])
-		req.Reply <- score
-	case "ZCARD":
-		count := s.Store.ZCard(req.Key)
-		req.Reply <- count
-	case "ZRANK":
-		if len(req.Args) < 1 {
-			req.Reply <- -1
-			return
-		}
-		rank, _ := s.Store.ZRank(req.Key, req.Args[0])
-		req.Reply <- rank
-	case "ZRANGE":
-		if len(req.Args) < 2 {
-			req.Reply <- nil
-			return
-		}
-		var start, stop int
-		fmt.Sscanf(req.Args[0], "%d", &start)
-		fmt.Sscanf(req.Args[1], "%d", &stop)
-		withScores := false
-		if len(req.Args) > 2 && strings.ToUpper(req.Args[2]) == "WITHSCORES" {
-			withScores = true
-		}
-		result := s.Store.ZRange(req.Key, start, stop, withScores)
-		req.Reply <- result
-	case "BFADD":
-		if len(req.Args) < 1 {
-			req.Reply <- false
-			return
-		}
-		ok := s.Store.BFAdd(req.Key, req.Args[0])
-		req.Reply <- ok
-	case "BFEXISTS":
-		if len(req.Args) < 1 {
-			req.Reply <- false
-			return
-		}
-		ok := s.Store.BFExists(req.Key, req.Args[0])
-		req.Reply <- ok
-	case "DUMPKEY":
-		// internal API : return KeyDump or nil
-		val, ok := s.Store.getRaw(req.Key)
-		if !ok {
-			log.Printf("DEBUG: %s - Not found in shard during DUMPKEY", req.Key)
-			if req.Reply != nil {
-				req.Reply <- nil
-			}
+			// destination not found : tell the client to retry
+			log.Printf("DEBUG: %s - Node %s is gone, replying MOVED", req.Key, targetNode)
+			req.Reply <- s.parent.moved(req.Key)
 			return
 		}
-
-		// Log value details based on type
-		switch val.Type {
-		case StringType:
-			log.Printf("DEBUG: %s - Found in source shard with type=STRING, data=%q", req.Key, string(val.Data))
-		case SetType:
-			log.Printf("DEBUG: %s - Found in source shard with type=SET, members=%d", req.Key, len(val.Set))
-		case HashType:
-			log.Printf("DEBUG: %s - Found in source shard with type=HASH, fields=%d", req.Key, len(val.Hash))
-		case CMSType:
-			if val.CMS != nil {
-				log.Printf("DEBUG: %s - Found in source shard with type=CMS, width=%d, depth=%d",
-					req.Key, val.CMS.Width, val.CMS.Depth)
-			} else {
-				log.Printf("DEBUG: %s - Found in source shard with type=CMS but CMS is nil", req.Key)
-			}
-		default:
-			log.Printf("DEBUG: %s - Found in source shard with type=%d", req.Key, val.Type)
-		}
-
-		valueBytes := s.Store.serializeValue(val)
-		if valueBytes == nil {
-			log.Printf("ERROR: %s - Failed to serialize value", req.Key)
-			if req.Reply != nil {
-				req.Reply <- nil
-			}
+		// a key already moved off a slot on its way out lives on the
+		// importing shard, as does one created meanwhile
+		if dest, ok := s.parent.askTarget(s, req.Key); ok {
+			req.Reply <- askRedirect{to: dest}
 			return
 		}
+	}
 
-		kd := KeyDump{
-			Key:        req.Key,
-			ValueType:  int(val.Type),
-			ValueBytes: valueBytes,
-			TTL:        s.Store.getExpirationTime(req.Key),
-		}
+	s.exec(req)
+}
 
-		log.Printf("DEBUG: %s - Dumped value: type=%d, size=%d bytes",
-			req.Key, kd.ValueType, len(kd.ValueBytes))
+// exec runs req on this shard's store, wherever the ring routes its key
+func (s *Shard) exec(req ShardRequest) {
+	cmd := strings.ToUpper(req.Command)
+	log.Printf("DEBUG: %s - Processing %s command in shard %s", req.Key, cmd, s.nodeID)
 
-		if req.Reply != nil {
-			req.Reply <- kd
-		}
+	sc, ok := shardCommands[cmd]
+	if !ok {
+		req.Reply <- fmt.Errorf("unknown command: %s", req.Command)
 		return
-	case "MIGRATE_RESTORE":
-		// expecting Payload to be KeyDump
-		kd, ok := req.Payload.(KeyDump)
-		if !ok {
-			log.Printf("DEBUG: %s - Bad payload type for MIGRATE_RESTORE: %T", req.Key, req.Payload)
-			if req.Reply != nil {
-				req.Reply <- fmt.Errorf("bad payload")
-			}
-			return
-		}
-		log.Printf("DEBUG: %s - Starting restore with type=%d, size=%d bytes",
-			kd.Key, kd.ValueType, len(kd.ValueBytes))
-
-		// restore into s.store preserving TTL
-		if err := s.Store.restoreFromDump(kd); err != nil {
-			log.Printf("ERROR: %s - Failed to restore: %v", kd.Key, err)
-			if req.Reply != nil {
-				req.Reply <- err
-			}
+	}
+	if sc.flags&shardDenyOOM != 0 {
+		if err := s.Store.FreeMemory(); err != nil {
+			log.Printf("DEBUG: %s - Rejecting %s, shard %s is over maxmemory", req.Key, cmd, s.nodeID)
+			req.Reply <- err
 			return
 		}
-		log.Printf("DEBUG: %s - Successfully restored", kd.Key)
-		if req.Reply != nil {
-			req.Reply <- true
-		}
-		return
-	case "MIGRATE_DELETE":
-		deleted := s.Store.Delete(req.Key)
-		if req.Reply != nil {
-			req.Reply <- deleted
-		}
+	}
+	// keyless plumbing such as FLUSH and MULTIKEY has no key of its own to
+	// account; an empty key from a client is a key like any other
+	if sc.flags&shardReadOnly == 0 && sc.flags&shardInternal == 0 && s.Store.ttlPolicies.Load() != nil {
+		// hold the reply until the key has its policy's TTL
+		reply := req.Reply
+		req.Reply = make(chan interface{}, 1)
+		sc.handler(s, req)
+		s.Store.AccountWrite(req.Key)
+		reply <- <-req.Reply
 		return
-	default:
-		req.Reply <- fmt.Errorf("unknown command: %s", req.Command)
 	}
+	if sc.flags&shardReadOnly == 0 && (req.Key != "" || sc.flags&shardInternal == 0) {
+		defer s.Store.AccountKey(req.Key)
+	}
+	sc.handler(s, req)
 }
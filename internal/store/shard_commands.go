@@ -0,0 +1,748 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type shardCommandFlag uint8
+
+const (
+	shardFast     shardCommandFlag = 1 << iota // O(1), served from the fast lane; see fastArgLimits
+	shardReadOnly                              // never modifies the keyspace
+	shardInternal                              // migration and replication plumbing
+	shardDenyOOM                               // may grow memory, refused over maxmemory
+)
+
+// shardCommand is one entry in the shard dispatch table
+type shardCommand struct {
+	flags   shardCommandFlag
+	handler func(s *Shard, req ShardRequest)
+}
+
+// shardCommands maps upper-case command names to their shard-side handlers
+var shardCommands = map[string]shardCommand{
+	"SET":             {shardFast | shardDenyOOM, (*Shard).cmdSet},
+	"GET":             {shardFast | shardReadOnly, (*Shard).cmdGet},
+	"APPEND":          {shardFast | shardDenyOOM, (*Shard).cmdAppend},
+	"STRLEN":          {shardFast | shardReadOnly, (*Shard).cmdStrLen},
+	"GETRANGE":        {shardReadOnly, (*Shard).cmdGetRange},
+	"SETRANGE":        {shardDenyOOM, (*Shard).cmdSetRange},
+	"GETSET":          {shardFast | shardDenyOOM, (*Shard).cmdGetSet},
+	"SETBIT":          {shardDenyOOM, (*Shard).cmdSetBit},
+	"GETBIT":          {shardFast | shardReadOnly, (*Shard).cmdGetBit},
+	"BITCOUNT":        {shardReadOnly, (*Shard).cmdBitCount},
+	"BITPOS":          {shardReadOnly, (*Shard).cmdBitPos},
+	"SETNX":           {shardFast | shardDenyOOM, (*Shard).cmdSetNX},
+	"EXISTS":          {shardFast | shardReadOnly, (*Shard).cmdExists},
+	"TYPE":            {shardFast | shardReadOnly, (*Shard).cmdType},
+	"OBJECT":          {shardFast | shardReadOnly, (*Shard).cmdObject},
+	"TTL":             {shardFast | shardReadOnly, (*Shard).cmdTTL},
+	"DEL":             {0, (*Shard).cmdDel}, // freeing a big value costs as much as listing it
+	"SADD":            {shardDenyOOM, (*Shard).cmdSAdd},
+	"SREM":            {0, (*Shard).cmdSRem},
+	"SMEMBERS":        {shardReadOnly, (*Shard).cmdSMembers},
+	"SCARD":           {shardFast | shardReadOnly, (*Shard).cmdSCard},
+	"SISMEMBER":       {shardFast | shardReadOnly, (*Shard).cmdSIsMember},
+	"SETMEMBERS":      {shardReadOnly | shardInternal, (*Shard).cmdSetMembers},
+	"SETSTORE":        {shardDenyOOM | shardInternal, (*Shard).cmdSetStore},
+	"SPOP":            {0, (*Shard).cmdSPop},
+	"SRANDMEMBER":     {shardReadOnly, (*Shard).cmdSRandMember},
+	"SAMPLE":          {shardReadOnly, (*Shard).cmdSample},
+	"HSET":            {shardFast | shardDenyOOM, (*Shard).cmdHSet},
+	"HGET":            {shardFast | shardReadOnly, (*Shard).cmdHGet},
+	"HMGET":           {shardReadOnly, (*Shard).cmdHMGet},
+	"HEXISTS":         {shardFast | shardReadOnly, (*Shard).cmdHExists},
+	"HLEN":            {shardFast | shardReadOnly, (*Shard).cmdHLen},
+	"HKEYS":           {shardReadOnly, (*Shard).cmdHKeys},
+	"HVALS":           {shardReadOnly, (*Shard).cmdHVals},
+	"HINCRBY":         {shardFast | shardDenyOOM, (*Shard).cmdHIncrBy},
+	"HINCRBYFLOAT":    {shardDenyOOM, (*Shard).cmdHIncrByFloat},
+	"HDEL":            {shardFast, (*Shard).cmdHDel},
+	"HGETALL":         {shardReadOnly, (*Shard).cmdHGetAll},
+	"CMSINCR":         {shardFast | shardDenyOOM, (*Shard).cmdCMSIncr},
+	"CMSQUERY":        {shardFast | shardReadOnly, (*Shard).cmdCMSQuery},
+	"LPUSH":           {shardFast | shardDenyOOM, (*Shard).cmdLPush},
+	"RPUSH":           {shardFast | shardDenyOOM, (*Shard).cmdRPush},
+	"LPOP":            {shardFast, (*Shard).cmdLPop},
+	"RPOP":            {shardFast, (*Shard).cmdRPop},
+	"LLEN":            {shardFast | shardReadOnly, (*Shard).cmdLLen},
+	"LRANGE":          {shardReadOnly, (*Shard).cmdLRange},
+	"LINSERT":         {shardDenyOOM, (*Shard).cmdLInsert},
+	"LSET":            {shardDenyOOM, (*Shard).cmdLSet},
+	"LREM":            {0, (*Shard).cmdLRem},
+	"LTRIM":           {0, (*Shard).cmdLTrim},
+	"LPOS":            {shardReadOnly, (*Shard).cmdLPos},
+	"LMPOP":           {0, (*Shard).cmdLMPop},
+	"ZADD":            {shardDenyOOM, (*Shard).cmdZAdd},
+	"ZINCRBY":         {shardFast | shardDenyOOM, (*Shard).cmdZIncrBy},
+	"ZSCORE":          {shardFast | shardReadOnly, (*Shard).cmdZScore},
+	"ZCARD":           {shardFast | shardReadOnly, (*Shard).cmdZCard},
+	"ZRANK":           {shardReadOnly, (*Shard).cmdZRank},
+	"ZRANGE":          {shardReadOnly, (*Shard).cmdZRange},
+	"GEOADD":          {shardDenyOOM, (*Shard).cmdGeoAdd},
+	"GEOPOS":          {shardReadOnly, (*Shard).cmdGeoPos},
+	"GEODIST":         {shardFast | shardReadOnly, (*Shard).cmdGeoDist},
+	"GEOSEARCH":       {shardReadOnly, (*Shard).cmdGeoSearch},
+	"BFADD":           {shardFast | shardDenyOOM, (*Shard).cmdBFAdd},
+	"BFEXISTS":        {shardFast | shardReadOnly, (*Shard).cmdBFExists},
+	"BF.RESERVE":      {shardFast | shardDenyOOM, (*Shard).cmdBFReserve},
+	"BF.INFO":         {shardFast | shardReadOnly, (*Shard).cmdBFInfo},
+	"DUMPKEY":         {shardReadOnly | shardInternal, (*Shard).cmdDumpKey},
+	"MIGRATE_RESTORE": {shardInternal, (*Shard).cmdMigrateRestore},
+	"HOTKEY_SET":      {shardInternal, (*Shard).cmdHotKeySet},
+	"HOTKEY_DEL":      {shardInternal, (*Shard).cmdHotKeyDel},
+	"HOTKEY_GET":      {shardFast | shardReadOnly | shardInternal, (*Shard).cmdHotKeyGet},
+	"MIGRATE_DELETE":  {shardInternal, (*Shard).cmdMigrateDelete},
+	"SLOTMOVE":        {shardInternal, (*Shard).cmdSlotMove},
+	"GROUPMOVE":       {shardInternal, (*Shard).cmdGroupMove},
+	"FLUSH":           {shardInternal, (*Shard).cmdFlush},
+	"FLUSHDB":         {shardInternal, (*Shard).cmdFlushDB},
+	"DBSIZE":          {shardFast | shardReadOnly, (*Shard).cmdDBSize},
+	"DIGEST":          {shardReadOnly | shardInternal, (*Shard).cmdDigest},
+	"FREEZE":          {shardReadOnly | shardInternal, (*Shard).cmdFreeze},
+	"EVICTSHARE":      {shardInternal, (*Shard).cmdEvictShare},
+}
+
+func lookupShardCommand(cmd string) (shardCommand, bool) {
+	sc, ok := shardCommands[strings.ToUpper(cmd)]
+	return sc, ok
+}
+
+// fastArgLimits caps the arguments of the shardFast commands that are O(1)
+// only for a single field, element or pop. With more, or with a count, they
+// cost as much as a scan of that size and take the slow lane.
+var fastArgLimits = map[string]int{
+	"HSET":  2,
+	"HDEL":  1,
+	"LPUSH": 1,
+	"RPUSH": 1,
+	"LPOP":  0,
+	"RPOP":  0,
+}
+
+// isFast reports whether req belongs in the fast lane
+func isFast(req ShardRequest) bool {
+	if req.internal {
+		return false
+	}
+	cmd := strings.ToUpper(req.Command)
+	sc, ok := shardCommands[cmd]
+	if !ok || sc.flags&shardFast == 0 {
+		return false
+	}
+	if limit, ok := fastArgLimits[cmd]; ok {
+		return len(req.Args) <= limit
+	}
+	return true
+}
+
+// isReadOnlyCommand reports whether cmd never writes; unknown commands are
+// treated as writes
+func isReadOnlyCommand(cmd string) bool {
+	sc, ok := lookupShardCommand(cmd)
+	return ok && sc.flags&shardReadOnly != 0
+}
+
+func (s *Shard) cmdSet(req ShardRequest) {
+	if len(req.Args) < 1 {
+		log.Printf("ERROR: %s - SET command missing value argument", req.Key)
+		req.Reply <- fmt.Errorf("SET requires at least 1 argument")
+		return
+	}
+	val := []byte(req.Args[0])
+	opts, err := ParseSetOptions(req.Args[1:], s.Store.now())
+	if err != nil {
+		log.Printf("ERROR: %s - Invalid SET options %v: %v", req.Key, req.Args[1:], err)
+		req.Reply <- err
+		return
+	}
+	expireStr := ""
+	if !opts.ExpireAt.IsZero() {
+		expireStr = fmt.Sprintf(" and expiration %v", opts.ExpireAt)
+	}
+	log.Printf("DEBUG: %s - Setting value with length %d bytes%s",
+		req.Key, len(val), expireStr)
+	old, hadOld, applied, err := s.Store.SetWithOptions(req.Key, val, opts)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	switch {
+	case opts.Get && hadOld:
+		req.Reply <- old
+	case opts.Get:
+		req.Reply <- []byte(nil)
+	case applied:
+		log.Printf("DEBUG: %s - Successfully set value", req.Key)
+		req.Reply <- "OK"
+	default:
+		req.Reply <- nil
+	}
+}
+
+func (s *Shard) cmdGet(req ShardRequest) {
+	val, found := s.Store.Get(req.Key)
+	if !found {
+		req.Reply <- nil
+	} else {
+		req.Reply <- val
+	}
+}
+
+func (s *Shard) cmdAppend(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- fmt.Errorf("APPEND requires a value")
+		return
+	}
+	n, err := s.Store.Append(req.Key, req.Args[0])
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
+
+func (s *Shard) cmdStrLen(req ShardRequest) {
+	n, err := s.Store.StrLen(req.Key)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
+
+func (s *Shard) cmdGetRange(req ShardRequest) {
+	if len(req.Args) < 2 {
+		req.Reply <- fmt.Errorf("GETRANGE requires start and end")
+		return
+	}
+	var start, end int
+	fmt.Sscanf(req.Args[0], "%d", &start)
+	fmt.Sscanf(req.Args[1], "%d", &end)
+	sub, err := s.Store.GetRange(req.Key, start, end)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- sub
+}
+
+func (s *Shard) cmdSetRange(req ShardRequest) {
+	if len(req.Args) < 2 {
+		req.Reply <- fmt.Errorf("SETRANGE requires offset and value")
+		return
+	}
+	var offset int
+	fmt.Sscanf(req.Args[0], "%d", &offset)
+	n, err := s.Store.SetRange(req.Key, offset, req.Args[1])
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
+
+func (s *Shard) cmdGetSet(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- fmt.Errorf("GETSET requires a value")
+		return
+	}
+	old, found, err := s.Store.GetSet(req.Key, []byte(req.Args[0]))
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	if !found {
+		req.Reply <- nil
+		return
+	}
+	req.Reply <- old
+}
+
+func (s *Shard) cmdSetNX(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- false
+		return
+	}
+	ok, err := s.Store.SetNX(req.Key, []byte(req.Args[0]))
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- ok
+}
+
+func (s *Shard) cmdTTL(req ShardRequest) {
+	req.Reply <- s.Store.TTL(req.Key)
+}
+
+func (s *Shard) cmdDel(req ShardRequest) {
+	deleted := s.Store.Delete(req.Key)
+	req.Reply <- deleted
+}
+
+func (s *Shard) cmdSAdd(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- 0
+		return
+	}
+	added, err := s.Store.SAdd(req.Key, req.Args...)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- added
+}
+
+func (s *Shard) cmdSRem(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- 0
+		return
+	}
+	removed := s.Store.SRem(req.Key, req.Args...)
+	req.Reply <- removed
+}
+
+func (s *Shard) cmdSMembers(req ShardRequest) {
+	members := s.Store.SMembers(req.Key)
+	req.Reply <- members
+}
+
+func (s *Shard) cmdSCard(req ShardRequest) {
+	card := s.Store.SCard(req.Key)
+	req.Reply <- card
+}
+
+func (s *Shard) cmdSIsMember(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- false
+		return
+	}
+	ok := s.Store.SIsMember(req.Key, req.Args[0])
+	req.Reply <- ok
+}
+
+func (s *Shard) cmdSPop(req ShardRequest) {
+	count := 1
+	if len(req.Args) >= 1 {
+		fmt.Sscanf(req.Args[0], "%d", &count)
+	}
+	members := s.Store.SPop(req.Key, count)
+	req.Reply <- members
+}
+
+func (s *Shard) cmdSRandMember(req ShardRequest) {
+	count := 0
+	if len(req.Args) >= 1 {
+		fmt.Sscanf(req.Args[0], "%d", &count)
+	}
+	members := s.Store.SRandMember(req.Key, count)
+	req.Reply <- members
+}
+
+// SAMPLE key count [WEIGHTED] [WITHSCORES]; options are validated upstream
+func (s *Shard) cmdSample(req ShardRequest) {
+	count := 0
+	if len(req.Args) >= 1 {
+		fmt.Sscanf(req.Args[0], "%d", &count)
+	}
+	var weighted, withScores bool
+	for _, opt := range req.Args[min(1, len(req.Args)):] {
+		switch strings.ToUpper(opt) {
+		case "WEIGHTED":
+			weighted = true
+		case "WITHSCORES":
+			withScores = true
+		}
+	}
+	members, err := s.Store.Sample(req.Key, count, weighted, withScores)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- members
+}
+
+func (s *Shard) cmdHSet(req ShardRequest) {
+	if len(req.Args) < 2 || len(req.Args)%2 != 0 {
+		req.Reply <- 0
+		return
+	}
+	n, err := s.Store.HSet(req.Key, req.Args...)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
+
+func (s *Shard) cmdHGet(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- nil
+		return
+	}
+	val, found := s.Store.HGet(req.Key, req.Args[0])
+	if !found {
+		req.Reply <- nil
+		return
+	}
+	req.Reply <- val
+}
+
+func (s *Shard) cmdHMGet(req ShardRequest) {
+	req.Reply <- s.Store.HMGet(req.Key, req.Args...)
+}
+
+func (s *Shard) cmdHExists(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- false
+		return
+	}
+	req.Reply <- s.Store.HExists(req.Key, req.Args[0])
+}
+
+func (s *Shard) cmdHLen(req ShardRequest) {
+	req.Reply <- s.Store.HLen(req.Key)
+}
+
+func (s *Shard) cmdHKeys(req ShardRequest) {
+	req.Reply <- s.Store.HKeys(req.Key)
+}
+
+func (s *Shard) cmdHVals(req ShardRequest) {
+	req.Reply <- s.Store.HVals(req.Key)
+}
+
+func (s *Shard) cmdHIncrBy(req ShardRequest) {
+	if len(req.Args) < 2 {
+		req.Reply <- fmt.Errorf("HINCRBY requires field and increment")
+		return
+	}
+	delta, err := strconv.ParseInt(req.Args[1], 10, 64)
+	if err != nil {
+		req.Reply <- ErrNotInteger
+		return
+	}
+	n, err := s.Store.HIncrBy(req.Key, req.Args[0], delta)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- n
+}
+
+func (s *Shard) cmdHIncrByFloat(req ShardRequest) {
+	if len(req.Args) < 2 {
+		req.Reply <- fmt.Errorf("HINCRBYFLOAT requires field and increment")
+		return
+	}
+	delta, err := strconv.ParseFloat(req.Args[1], 64)
+	if err != nil {
+		req.Reply <- ErrNotFloat
+		return
+	}
+	f, err := s.Store.HIncrByFloat(req.Key, req.Args[0], delta)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- f
+}
+
+func (s *Shard) cmdHDel(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- 0
+		return
+	}
+	deleted := s.Store.HDel(req.Key, req.Args...)
+	req.Reply <- deleted
+}
+
+func (s *Shard) cmdHGetAll(req ShardRequest) {
+	result := s.Store.HGetAll(req.Key)
+	req.Reply <- result
+}
+
+func (s *Shard) cmdCMSIncr(req ShardRequest) {
+	if len(req.Args) < 2 {
+		req.Reply <- nil
+		return
+	}
+	var count uint32
+	fmt.Sscanf(req.Args[1], "%d", &count)
+	s.Store.CMSIncr(req.Key, req.Args[0], count)
+	req.Reply <- true
+}
+
+func (s *Shard) cmdCMSQuery(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- uint32(0)
+		return
+	}
+	count := s.Store.CMSQuery(req.Key, req.Args[0])
+	req.Reply <- count
+}
+
+func (s *Shard) cmdLPush(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- -1
+		return
+	}
+	newLen, err := s.Store.LPush(req.Key, req.Args...)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- newLen
+}
+
+func (s *Shard) cmdRPush(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- -1
+		return
+	}
+	newLen, err := s.Store.RPush(req.Key, req.Args...)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- newLen
+}
+
+func (s *Shard) cmdLPop(req ShardRequest) {
+	val, _ := s.Store.LPop(req.Key)
+	req.Reply <- val
+}
+
+func (s *Shard) cmdRPop(req ShardRequest) {
+	val, _ := s.Store.RPop(req.Key)
+	req.Reply <- val
+}
+
+func (s *Shard) cmdLLen(req ShardRequest) {
+	length := s.Store.LLen(req.Key)
+	req.Reply <- length
+}
+
+func (s *Shard) cmdLRange(req ShardRequest) {
+	if len(req.Args) < 2 {
+		req.Reply <- nil
+		return
+	}
+	var start, stop int
+	fmt.Sscanf(req.Args[0], "%d", &start)
+	fmt.Sscanf(req.Args[1], "%d", &stop)
+	result := s.Store.LRange(req.Key, start, stop)
+	req.Reply <- result
+}
+
+func (s *Shard) cmdZAdd(req ShardRequest) {
+	if len(req.Args) < 2 || len(req.Args)%2 != 0 {
+		req.Reply <- -1
+		return
+	}
+	members := make(map[string]float64)
+	for i := 0; i < len(req.Args); i += 2 {
+		score := 0.0
+		fmt.Sscanf(req.Args[i], "%f", &score)
+		members[req.Args[i+1]] = score
+	}
+	added, err := s.Store.ZAdd(req.Key, members)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- added
+}
+
+func (s *Shard) cmdZIncrBy(req ShardRequest) {
+	if len(req.Args) != 2 {
+		req.Reply <- fmt.Errorf("ZINCRBY requires increment and member")
+		return
+	}
+	delta, err := strconv.ParseFloat(req.Args[0], 64)
+	if err != nil {
+		req.Reply <- ErrNotFloat
+		return
+	}
+	score, err := s.Store.ZIncrBy(req.Key, req.Args[1], delta)
+	if err != nil {
+		req.Reply <- err
+		return
+	}
+	req.Reply <- score
+}
+
+func (s *Shard) cmdZScore(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- 0.0
+		return
+	}
+	score, ok := s.Store.ZScore(req.Key, req.Args[0])
+	if !ok {
+		req.Reply <- nil
+		return
+	}
+	req.Reply <- score
+}
+
+func (s *Shard) cmdZCard(req ShardRequest) {
+	count := s.Store.ZCard(req.Key)
+	req.Reply <- count
+}
+
+func (s *Shard) cmdZRank(req ShardRequest) {
+	if len(req.Args) < 1 {
+		req.Reply <- -1
+		return
+	}
+	rank, ok := s.Store.ZRank(req.Key, req.Args[0])
+	if !ok {
+		req.Reply <- nil
+		return
+	}
+	req.Reply <- rank
+}
+
+func (s *Shard) cmdZRange(req ShardRequest) {
+	if len(req.Args) < 2 {
+		req.Reply <- nil
+		return
+	}
+	var start, stop int
+	fmt.Sscanf(req.Args[0], "%d", &start)
+	fmt.Sscanf(req.Args[1], "%d", &stop)
+	withScores := false
+	if len(req.Args) > 2 && strings.ToUpper(req.Args[2]) == "WITHSCORES" {
+		withScores = true
+	}
+	result := s.Store.ZRange(req.Key, start, stop, withScores)
+	req.Reply <- result
+}
+
+func (s *Shard) cmdDumpKey(req ShardRequest) {
+	// internal API : return KeyDump or nil
+	val, ok := s.Store.getRaw(req.Key)
+	if !ok {
+		log.Printf("DEBUG: %s - Not found in shard during DUMPKEY", req.Key)
+		if req.Reply != nil {
+			req.Reply <- nil
+		}
+		return
+	}
+
+	// Log value details based on type
+	switch val.Type {
+	case StringType:
+		log.Printf("DEBUG: %s - Found in source shard with type=STRING, data=%q", req.Key, string(val.Data))
+	case SetType:
+		log.Printf("DEBUG: %s - Found in source shard with type=SET, members=%d", req.Key, val.setLen())
+	case HashType:
+		log.Printf("DEBUG: %s - Found in source shard with type=HASH, fields=%d", req.Key, val.hashLen())
+	case CMSType:
+		if val.CMS != nil {
+			log.Printf("DEBUG: %s - Found in source shard with type=CMS, width=%d, depth=%d",
+				req.Key, val.CMS.Width, val.CMS.Depth)
+		} else {
+			log.Printf("DEBUG: %s - Found in source shard with type=CMS but CMS is nil", req.Key)
+		}
+	default:
+		log.Printf("DEBUG: %s - Found in source shard with type=%d", req.Key, val.Type)
+	}
+
+	valueBytes := s.Store.serializeValue(val)
+	if valueBytes == nil {
+		log.Printf("ERROR: %s - Failed to serialize value", req.Key)
+		if req.Reply != nil {
+			req.Reply <- nil
+		}
+		return
+	}
+
+	kd := KeyDump{
+		Key:        req.Key,
+		ValueType:  int(val.Type),
+		ValueBytes: valueBytes,
+		TTL:        s.Store.getExpirationTime(req.Key),
+	}
+
+	log.Printf("DEBUG: %s - Dumped value: type=%d, size=%d bytes",
+		req.Key, kd.ValueType, len(kd.ValueBytes))
+
+	if req.Reply != nil {
+		req.Reply <- kd
+	}
+}
+
+func (s *Shard) cmdMigrateRestore(req ShardRequest) {
+	// expecting Payload to be KeyDump
+	kd, ok := req.Payload.(KeyDump)
+	if !ok {
+		log.Printf("DEBUG: %s - Bad payload type for MIGRATE_RESTORE: %T", req.Key, req.Payload)
+		if req.Reply != nil {
+			req.Reply <- fmt.Errorf("bad payload")
+		}
+		return
+	}
+	log.Printf("DEBUG: %s - Starting restore with type=%d, size=%d bytes",
+		kd.Key, kd.ValueType, len(kd.ValueBytes))
+
+	// restore into s.store preserving TTL
+	if err := s.Store.restoreFromDump(kd); err != nil {
+		log.Printf("ERROR: %s - Failed to restore: %v", kd.Key, err)
+		if req.Reply != nil {
+			req.Reply <- err
+		}
+		return
+	}
+	log.Printf("DEBUG: %s - Successfully restored", kd.Key)
+	if req.Reply != nil {
+		req.Reply <- true
+	}
+}
+
+func (s *Shard) cmdHotKeySet(req ShardRequest) {
+	if r, ok := req.Payload.(hotReplica); ok {
+		s.hotReplicas[req.Key] = r
+	}
+	req.Reply <- true
+}
+
+func (s *Shard) cmdHotKeyDel(req ShardRequest) {
+	delete(s.hotReplicas, req.Key)
+	req.Reply <- true
+}
+
+func (s *Shard) cmdHotKeyGet(req ShardRequest) {
+	r, ok := s.hotReplicas[req.Key]
+	if !ok || (!r.expiresAt.IsZero() && time.Now().After(r.expiresAt)) {
+		delete(s.hotReplicas, req.Key)
+		req.Reply <- nil
+		return
+	}
+	req.Reply <- r.data
+}
+
+func (s *Shard) cmdMigrateDelete(req ShardRequest) {
+	deleted := s.Store.migrateDelete(req.Key)
+	if req.Reply != nil {
+		req.Reply <- deleted
+	}
+}
+
+func (s *Shard) cmdFlush(req ShardRequest) {
+	s.Store.Flush()
+	req.Reply <- "OK"
+}
+
+func (s *Shard) cmdDBSize(req ShardRequest) {
+	req.Reply <- s.Store.DBSize()
+}
+
+func (s *Shard) cmdDigest(req ShardRequest) {
+	req.Reply <- s.Store.Digest()
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// discardLogs silences the log until tb ends, since every command logs at
+// DEBUG
+func discardLogs(tb testing.TB) {
+	out := log.Writer()
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(out) })
+}
+
+// newTestSharedStore runs a one-node store until t ends
+func newTestSharedStore(t *testing.T) *SharedStore {
+	t.Helper()
+	discardLogs(t)
+	ss := NewSharedStore(1)
+	if err := ss.AddNode("node-0", NewShard(NewStore())); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ss.Shutdown(ctx)
+	})
+	return ss
+}
+
+func TestLaneRouting(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		args []string
+		fast bool
+	}{
+		{"GET", nil, true},
+		{"set", []string{"v"}, true},
+		{"DEL", nil, false},
+		{"SMEMBERS", nil, false},
+		{"HSET", []string{"f", "v"}, true},
+		{"HSET", []string{"f", "v", "g", "w"}, false},
+		{"HDEL", []string{"f"}, true},
+		{"HDEL", []string{"f", "g"}, false},
+		{"LPUSH", []string{"a"}, true},
+		{"RPUSH", []string{"a", "b"}, false},
+		{"LPOP", nil, true},
+		{"RPOP", []string{"10"}, false},
+	}
+	for _, tt := range tests {
+		if got := isFast(ShardRequest{Command: tt.cmd, Args: tt.args}); got != tt.fast {
+			t.Errorf("isFast(%s %v) = %v, want %v", tt.cmd, tt.args, got, tt.fast)
+		}
+	}
+	if isFast(ShardRequest{Command: "GET", internal: true}) {
+		t.Error("an internal GET took the fast lane")
+	}
+}
+
+// TestSlowLaneUnderFastLoad fills the fast lane while the worker is held up,
+// then checks that a slow request waits behind at most fastBurst of them
+func TestSlowLaneUnderFastLoad(t *testing.T) {
+	ss := newTestSharedStore(t)
+	sh, _ := ss.GetShardByNodeID("node-0")
+
+	// hold the worker inside a SET until both lanes are queued; a GET would
+	// skip the worker as a direct read
+	sh.Store.mu.Lock()
+	go ss.Execute("SET", "blocker", "v")
+	for len(sh.fastInbox) > 0 || sh.lanes[fastLane].processed.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < cap(sh.fastInbox); i++ {
+		sh.enqueue(ShardRequest{Command: "RPUSH", Key: "list", Args: []string{"x"}, Reply: make(chan interface{}, 1)})
+	}
+	slow := ShardRequest{Command: "LRANGE", Key: "list", Args: []string{"0", "-1"}, Reply: make(chan interface{}, 1)}
+	sh.enqueue(slow)
+	sh.Store.mu.Unlock()
+
+	select {
+	case res := <-slow.Reply:
+		// the pushes the worker ran before the LRANGE
+		if n := len(res.([]string)); n > fastBurst {
+			t.Errorf("LRANGE ran after %d fast requests, want at most %d", n, fastBurst)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("LRANGE starved behind a full fast lane")
+	}
+}
@@ -2,9 +2,13 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -12,7 +16,31 @@ type SharedStore struct {
 	mu         sync.RWMutex
 	ring       *HashRing
 	nodeShards map[string]*Shard // map nodeID to Shard
+	hot        *hotKeyTracker    // nil unless hot-key replication is enabled
 	// optional : local cached mapping for pickShard faster path
+
+	warmup warmupProgress
+	limits Limits // applied to every shard's store
+
+	// total memory budget, split evenly across shards
+	maxmemory       int64
+	maxmemoryPolicy EvictionPolicy
+	evictionTypes   []ValueType // empty means any type may be evicted
+
+	eventHook   KeyEventHook // installed on every shard's store
+	scoreEvents scoreEvents  // likewise
+	interning   bool         // likewise, see SetInterning
+	encoding    EncodingLimits
+	bloom       BloomDefaults
+	clock       Clock // nil: SystemClock
+	reweight    reweightState
+	backups     backupChain
+	slots       slotTable // where each slot lives, see reshard.go
+	reshard     reshardState
+	migration   migrationControl
+	routing     routingStats
+
+	redirectAddr atomic.Value // string, the address MOVED replies name
 }
 
 func NewSharedStore(replicas int) *SharedStore {
@@ -36,8 +64,22 @@ func (ss *SharedStore) AddNode(nodeID string, sh *Shard) error {
 	// Set up the new shard
 	sh.nodeID = nodeID
 	sh.parent = ss
+	sh.Store.memory.onEvict = ss.dropEvictedHotKey
+	sh.Store.SetLimits(ss.limits)
+	sh.Store.SetEventHook(ss.eventHook)
+	sh.Store.SetScoreEvents(ss.scoreEvents.patterns, ss.scoreEvents.fn)
+	sh.Store.SetInterning(ss.interning)
+	sh.Store.SetEncodingLimits(ss.encoding)
+	if ss.bloom != (BloomDefaults{}) {
+		sh.Store.SetBloomDefaults(ss.bloom)
+	}
+	if ss.clock != nil {
+		sh.Store.SetClock(ss.clock)
+	}
 	ss.nodeShards[nodeID] = sh
+	ss.applyMaxMemory()
 	ss.ring.AddNode(nodeID)
+	ss.slots.assign(ss.ring.GetSlotNode) // only the first node gets any
 	log.Printf("DEBUG: %s - Added node to ring with %d replicas", nodeID, ss.ring.replicas)
 
 	// Start the shard worker before waiting for ready
@@ -47,8 +89,9 @@ func (ss *SharedStore) AddNode(nodeID string, sh *Shard) error {
 	// Wait for shard to be ready with timeout
 	ready := make(chan interface{}, 1)
 	sh.inbox <- ShardRequest{
-		Command: "_INTERNAL_READY",
-		Reply:   ready,
+		Command:  "_INTERNAL_READY",
+		Reply:    ready,
+		internal: true, // keyless, so never routed to another shard
 	}
 
 	select {
@@ -60,6 +103,7 @@ func (ss *SharedStore) AddNode(nodeID string, sh *Shard) error {
 		ss.mu.Lock()
 		delete(ss.nodeShards, nodeID)
 		ss.ring.RemoveNode(nodeID)
+		ss.slots.release(nodeID, ss.ring.GetSlotNode)
 		ss.mu.Unlock()
 		log.Printf("ERROR: %s - Node worker failed to become ready", nodeID)
 		return fmt.Errorf("node %s failed to become ready", nodeID)
@@ -73,9 +117,12 @@ func (ss *SharedStore) RemoveNode(nodeID string) {
 		// signal shard to stop accepting new requests, drain via its Run() quit handling
 		close(sh.quit)
 		delete(ss.nodeShards, nodeID)
+		ss.applyMaxMemory()
 
 	}
 	ss.ring.RemoveNode(nodeID)
+	// the node's keys go with it
+	ss.slots.release(nodeID, ss.ring.GetSlotNode)
 }
 
 // RemoveNodeFromRing removes a node from the hash ring only (keeps shard for migration)
@@ -85,7 +132,8 @@ func (ss *SharedStore) RemoveNodeFromRing(nodeID string) {
 	ss.ring.RemoveNode(nodeID)
 }
 
-// RemoveShardOnly removes the shard but assumes node was already removed from ring
+// RemoveShardOnly removes the shard but assumes node was already removed
+// from ring and resharded off, see Reshard
 func (ss *SharedStore) RemoveShardOnly(nodeID string) {
 	ss.mu.Lock()
 	defer ss.mu.Unlock()
@@ -93,12 +141,14 @@ func (ss *SharedStore) RemoveShardOnly(nodeID string) {
 		// signal shard to stop accepting new requests, drain via its Run() quit handling
 		close(sh.quit)
 		delete(ss.nodeShards, nodeID)
+		ss.applyMaxMemory()
 	}
+	ss.slots.release(nodeID, ss.ring.GetSlotNode)
 }
 
-// Internal ultility: getShardForKey (by ring)
+// Internal ultility: getShardForKey (by slot owner)
 func (ss *SharedStore) getShardForKey(key string, command string) (*Shard, bool) {
-	nodeID, ok := ss.ring.GetNode(key)
+	nodeID, ok := ss.ownerOf(key)
 	if !ok {
 		log.Printf("DEBUG: %s - Hash ring could not determine target node", key)
 		// For SET-like operations, hash to any available shard
@@ -115,7 +165,7 @@ func (ss *SharedStore) getShardForKey(key string, command string) (*Shard, bool)
 
 			if len(nodes) > 0 {
 				// Hash to a consistent node
-				hash := ss.ring.hashStr(key)
+				hash := ss.ring.hashStr(HashTag(key))
 				nodeID = nodes[hash%uint32(len(nodes))]
 				sh, exists := ss.nodeShards[nodeID]
 				if exists {
@@ -127,7 +177,7 @@ func (ss *SharedStore) getShardForKey(key string, command string) (*Shard, bool)
 		return nil, false
 	}
 
-	log.Printf("DEBUG: %s - Hash ring maps to node %s", key, nodeID)
+	log.Printf("DEBUG: %s - Slot is owned by node %s", key, nodeID)
 
 	ss.mu.RLock()
 	defer ss.mu.RUnlock()
@@ -159,11 +209,10 @@ func (ss *SharedStore) GetNodes() []string {
 	return ss.ring.Nodes()
 }
 
-// GetNodeForKey returns the node ID that should handle a given key
+// GetNodeForKey returns the node ID that should handle a given key: its
+// slot's owner, or the node importing the slot once key has moved there
 func (ss *SharedStore) GetNodeForKey(key string) (string, bool) {
-	ss.mu.RLock()
-	defer ss.mu.RUnlock()
-	return ss.ring.GetNode(key)
+	return ss.nodeFor(key)
 }
 
 func (ss *SharedStore) Execute(cmd string, key string, args ...string) interface{} {
@@ -171,25 +220,115 @@ func (ss *SharedStore) Execute(cmd string, key string, args ...string) interface
 		Command: cmd,
 		Key:     key,
 		Args:    args,
-		Reply:   make(chan interface{}, 1),
 	}
 	log.Printf("DEBUG: %s - Executing %s command", key, cmd)
 
+	if hk := ss.hotKeys(); hk != nil {
+		if resp, ok := ss.trackHotKey(hk, cmd, key); ok {
+			return resp
+		}
+		if !isHotKeyRead(cmd) {
+			// a replica installed while this write was in flight must not survive it
+			defer ss.invalidateHotKey(hk, key)
+		}
+	}
+
 	shard, ok := ss.getShardForKey(key, cmd)
 	if !ok {
 		log.Printf("DEBUG: %s - No shard available for command %s", key, cmd)
 		return fmt.Errorf("no shard available for key %s", key)
 	}
 
+	if sc, ok := directRead(cmd); ok && !ss.slots.isMoving(ss.slotOf(key)) {
+		resp := shard.readDirect(sc, req)
+		if !isMissReply(resp) || ss.slotSettled(key, shard.nodeID) {
+			return resp
+		}
+		// the slot started moving during the read, so key may have just
+		// left: ask the worker, which knows
+	}
 	log.Printf("DEBUG: %s - Sending %s command to shard %s", key, cmd, shard.nodeID)
-	shard.inbox <- req
-	resp := <-req.Reply
-	log.Printf("DEBUG: %s - Got response type %T from shard %s", key, resp, shard.nodeID)
-	return resp
+	return ss.send(shard, req)
+}
+
+// maxForwardHops bounds how often one request follows a redirect. One hop
+// covers a slot that changed owner after the request was routed, a second
+// one a slot moving meanwhile; more means the shards disagree about where
+// the key lives.
+const maxForwardHops = 4
+
+// ErrForwardLoop is the reply to a request redirected more than
+// maxForwardHops times
+var ErrForwardLoop = errors.New("TRYAGAIN the key's routing is changing, retry")
+
+// misrouted is a shard's answer to a command on a key whose slot another
+// shard owns: send it there
+type misrouted struct {
+	to *Shard
+}
+
+// RoutingStats counts the redirects shards answered requests with
+type RoutingStats struct {
+	Forwarded uint64 // to the slot's owner, see misrouted
+	Asked     uint64 // to the shard importing the slot, see askRedirect
+	HopLimit  uint64 // requests failed with ErrForwardLoop
+}
+
+type routingStats struct {
+	forwarded, asked, hopLimit atomic.Uint64
+}
+
+// RoutingStats reports the redirects followed since startup
+func (ss *SharedStore) RoutingStats() RoutingStats {
+	return RoutingStats{
+		Forwarded: ss.routing.forwarded.Load(),
+		Asked:     ss.routing.asked.Load(),
+		HopLimit:  ss.routing.hopLimit.Load(),
+	}
+}
+
+// send runs req on sh and returns its reply, following the redirects of
+// shards the key is not on, up to maxForwardHops of them. The caller does
+// the waiting, so no shard worker ever blocks on another.
+func (ss *SharedStore) send(sh *Shard, req ShardRequest) interface{} {
+	for {
+		r := newWorkerReply()
+		req.Reply, req.pooled = r.ch, r
+		sh.enqueue(req)
+		resp := <-r.ch
+		r.release()
+		switch redirect := resp.(type) {
+		case misrouted:
+			ss.routing.forwarded.Add(1)
+			log.Printf("DEBUG: %s - Slot owned by %s, forwarding", req.Key, redirect.to.nodeID)
+			sh = redirect.to
+		case askRedirect:
+			ss.routing.asked.Add(1)
+			log.Printf("DEBUG: %s - Moved to %s mid-reshard, asking it", req.Key, redirect.to.nodeID)
+			req.internal = true // the importing shard runs it without checking ownership
+			sh = redirect.to
+		default:
+			log.Printf("DEBUG: %s - Got response type %T from shard %s", req.Key, resp, sh.nodeID)
+			return resp
+		}
+		if req.hops++; req.hops > maxForwardHops {
+			ss.routing.hopLimit.Add(1)
+			log.Printf("WARNING: %s - %s redirected %d times, giving up", req.Key, req.Command, req.hops)
+			return ErrForwardLoop
+		}
+	}
 }
 
 func (ss *SharedStore) Set(key string, val []byte, expire time.Duration) error {
-	resp := ss.Execute("SET", key, string(val), expire.String())
+	args := []string{string(val)}
+	if expire > 0 {
+		ms := expire.Milliseconds()
+		if ms <= 0 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+	resp := ss.Execute("SET", key, args...)
 	if err, isErr := resp.(error); isErr {
 		return err
 	}
@@ -236,3 +375,71 @@ func (ss *SharedStore) Shutdown(ctx context.Context) error {
 	}
 	return nil
 }
+
+// SetCleaner updates the expiry cleaner settings of every shard
+func (ss *SharedStore) SetCleaner(sampleSize int, interval time.Duration) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetCleaner(sampleSize, interval)
+	}
+}
+
+// SetAdaptiveTTL updates the adaptive TTL policy of every shard
+func (ss *SharedStore) SetAdaptiveTTL(p AdaptiveTTL) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetAdaptiveTTL(p)
+	}
+}
+
+// AdaptiveTTLStats sums the TTL changes of every shard
+func (ss *SharedStore) AdaptiveTTLStats() AdaptiveTTLStats {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	var out AdaptiveTTLStats
+	for _, sh := range ss.nodeShards {
+		st := sh.Store.AdaptiveTTLStats()
+		out.Extended += st.Extended
+		out.Shortened += st.Shortened
+	}
+	return out
+}
+
+// TypeStats sums the per-type key counts of every shard
+func (ss *SharedStore) TypeStats() map[ValueType]int {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	out := make(map[ValueType]int)
+	for _, sh := range ss.nodeShards {
+		for t, n := range sh.Store.TypeStats() {
+			out[t] += n
+		}
+	}
+	return out
+}
+
+// Scan walks the shards in node ID order. The upper 32 bits of the cursor
+// pick the shard and the lower 32 bits are that shard's Store.Scan cursor;
+// 0 starts and ends a full iteration.
+func (ss *SharedStore) Scan(cursor uint64, count int, f ScanFilter) ([]string, uint64) {
+	nodes := ss.GetNodes()
+	sort.Strings(nodes)
+	idx := int(cursor >> 32)
+	if idx >= len(nodes) {
+		return nil, 0
+	}
+	sh, ok := ss.getShardByNodeID(nodes[idx])
+	if !ok {
+		return nil, 0
+	}
+	keys, next, done := sh.Store.Scan(uint32(cursor), count, f)
+	if !done {
+		return keys, uint64(idx)<<32 | uint64(next)
+	}
+	if idx+1 >= len(nodes) {
+		return keys, 0
+	}
+	return keys, uint64(idx+1) << 32
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestStore starts a SharedStore of n shards with logging discarded and
+// stops it when tb ends
+func newTestStore(tb testing.TB, n int) *SharedStore {
+	tb.Helper()
+	discardLogs(tb)
+	ss := NewSharedStore(100)
+	for i := 0; i < n; i++ {
+		if err := ss.AddNode(fmt.Sprintf("node-%d", i), NewShard(NewStore())); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	tb.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ss.Shutdown(ctx)
+	})
+	return ss
+}
+
+// benchKeys are spread over every shard
+var benchKeys = func() []string {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+	return keys
+}()
+
+func BenchmarkSharedStoreGet(b *testing.B) {
+	ss := newTestStore(b, 4)
+	for _, k := range benchKeys {
+		if err := ss.Set(k, []byte("value"), 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, ok := ss.Get(benchKeys[i%len(benchKeys)]); !ok {
+				b.Fatal("key missing")
+			}
+		}
+	})
+}
+
+func BenchmarkSharedStoreSet(b *testing.B) {
+	ss := newTestStore(b, 4)
+	val := []byte("value")
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if err := ss.Set(benchKeys[i%len(benchKeys)], val, 0); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ClusterSlots is the number of hash slots in Redis Cluster's keyspace
+const ClusterSlots = 16384
+
+// KeySlot is the Redis Cluster hash slot of key: CRC16 of its HashTag
+func KeySlot(key string) int {
+	return int(crc16(HashTag(key)) % ClusterSlots)
+}
+
+// HashTag is the part of key that places it: the part between the first
+// '{' and the next '}' when non-empty, else the whole key. Keys sharing a
+// tag share a slot, so they always live on one shard together.
+func HashTag(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			return key[i+1 : i+1+j]
+		}
+	}
+	return key
+}
+
+// crc16 is CRC-16/XMODEM, the checksum Redis Cluster uses for slots
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// RedirectError tells a client to retry a command elsewhere, rendered as a
+// Redis Cluster -MOVED or -ASK reply
+type RedirectError struct {
+	Kind string // "MOVED" or "ASK"
+	Slot int
+	Addr string // host:port to retry on
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("%s %d %s", e.Kind, e.Slot, e.Addr)
+}
+
+// SetRedirectAddr sets the address MOVED replies send clients to. Every
+// shard is served on the same address, so a MOVED from a shard whose node
+// just left tells the client to retry once the ring has settled.
+func (ss *SharedStore) SetRedirectAddr(addr string) {
+	ss.redirectAddr.Store(addr)
+}
+
+func (ss *SharedStore) moved(key string) error {
+	addr, _ := ss.redirectAddr.Load().(string)
+	return &RedirectError{Kind: "MOVED", Slot: KeySlot(key), Addr: addr}
+}
+
+// KeysInSlot returns up to count live keys in slot, and the number of keys
+// in it
+func (ss *SharedStore) KeysInSlot(slot, count int) ([]string, int) {
+	ss.mu.RLock()
+	shards := make([]*Shard, 0, len(ss.nodeShards))
+	for _, sh := range ss.nodeShards {
+		shards = append(shards, sh)
+	}
+	ss.mu.RUnlock()
+
+	var keys []string
+	n := 0
+	for _, sh := range shards {
+		for _, k := range sh.Store.liveKeys() {
+			if ss.slotOf(k) != slot {
+				continue
+			}
+			n++
+			if len(keys) < count {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys, n
+}
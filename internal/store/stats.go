@@ -0,0 +1,171 @@
+package store
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// storeStats are lock-free counters updated on the command path
+type storeStats struct {
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// StoreStats is a snapshot of one store's keyspace and counters
+type StoreStats struct {
+	Keys        int
+	Expires     int
+	MemoryBytes int64 // estimated size of keys and values
+	Hits        uint64
+	Misses      uint64
+	Evicted     uint64
+	Defrag      DefragStats
+}
+
+// ShardStats pairs a shard's store stats with its lane metrics
+type ShardStats struct {
+	NodeID string
+	StoreStats
+	Lanes    []LaneStats
+	Restarts uint64 // worker restarts after a panicking handler
+	VNodes   int    // virtual nodes on the hash ring
+}
+
+// lookupRead fetches key for a read command and counts the hit or miss.
+// Callers must hold s.mu.
+func (s *Store) lookupRead(key string) (Value, bool) {
+	val, ok := s.data.Get(key)
+	if ok {
+		s.stats.hits.Add(1)
+	} else {
+		s.stats.misses.Add(1)
+	}
+	return val, ok
+}
+
+// Stats reports keyspace counters; memory comes from the per-key accounting
+func (s *Store) Stats() StoreStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return StoreStats{
+		Keys:        s.data.Len(),
+		Expires:     len(s.ttl),
+		MemoryBytes: s.memory.used.Load(),
+		Hits:        s.stats.hits.Load(),
+		Misses:      s.stats.misses.Load(),
+		Evicted:     s.memory.evicted.Load(),
+		Defrag:      s.defrag.stats,
+	}
+}
+
+// sizeSamples bounds how many elements estimateSize inspects per container so
+// accounting stays cheap on every write
+const sizeSamples = 16
+
+// estimateSize approximates the bytes held by a key and its value: payloads
+// plus a rough per-entry overhead for Go maps and slices. Large containers
+// are extrapolated from a sample of their elements.
+func estimateSize(key string, v Value) int64 {
+	const entryOverhead = 48
+	n := int64(len(key)) + entryOverhead
+	switch v.Type {
+	case StringType:
+		n += int64(cap(v.Data))
+	case SetType:
+		if v.packed != nil {
+			// an intset holds 8 bytes a member, a listpack its strings
+			n += int64(8*cap(v.packed.ints) + 16*cap(v.packed.items))
+			for _, m := range v.packed.items {
+				n += int64(len(m))
+			}
+			break
+		}
+		var sampled, bytes int
+		for m := range v.Set {
+			bytes += len(m)
+			if sampled++; sampled >= sizeSamples {
+				break
+			}
+		}
+		n += extrapolate(len(v.Set), sampled, bytes, entryOverhead)
+	case HashType:
+		if v.packed != nil {
+			n += int64(16 * cap(v.packed.items))
+			for _, e := range v.packed.items {
+				n += int64(len(e))
+			}
+			break
+		}
+		var sampled, bytes int
+		for f, val := range v.Hash {
+			bytes += len(f) + len(val)
+			if sampled++; sampled >= sizeSamples {
+				break
+			}
+		}
+		n += extrapolate(len(v.Hash), sampled, bytes, entryOverhead)
+	case ListType:
+		var bytes int
+		sampled := min(len(v.List), sizeSamples)
+		for _, e := range v.List[:sampled] {
+			bytes += len(e)
+		}
+		n += extrapolate(len(v.List), sampled, bytes, 16)
+	case ZSetType:
+		// map entry plus skiplist node per member
+		var sampled, bytes int
+		for m := range v.ZSet {
+			bytes += 2 * len(m)
+			if sampled++; sampled >= sizeSamples {
+				break
+			}
+		}
+		n += extrapolate(len(v.ZSet), sampled, bytes, 2*entryOverhead)
+	case CMSType:
+		if v.CMS != nil {
+			n += int64(v.CMS.Depth) * int64(v.CMS.Width) * 4
+		}
+	case BFType:
+		if v.BF != nil {
+			n += int64(v.BF.SizeBytes())
+		}
+	}
+	return n
+}
+
+// extrapolate scales the sampled payload bytes up to total elements and adds
+// a fixed overhead per element
+func extrapolate(total, sampled, bytes int, perElem int64) int64 {
+	if total == 0 {
+		return 0
+	}
+	avg := int64(0)
+	if sampled > 0 {
+		avg = int64(bytes) / int64(sampled)
+	}
+	return int64(total) * (avg + perElem)
+}
+
+// ShardStats reports per-shard stats ordered by node ID
+func (ss *SharedStore) ShardStats() []ShardStats {
+	ss.mu.RLock()
+	shards := make(map[string]*Shard, len(ss.nodeShards))
+	for id, sh := range ss.nodeShards {
+		shards[id] = sh
+	}
+	ss.mu.RUnlock()
+
+	out := make([]ShardStats, 0, len(shards))
+	for id, sh := range shards {
+		out = append(out, ShardStats{
+			NodeID:     id,
+			StoreStats: sh.Store.Stats(),
+			Lanes:      sh.LaneStats(),
+			Restarts:   sh.Restarts(),
+			VNodes:     ss.ring.VNodes(id),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NodeID < out[j].NodeID })
+	return out
+}
@@ -1,11 +1,14 @@
 package store
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
-	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"multithreaded-redis/internal/datastuctures"
@@ -26,45 +29,113 @@ const (
 type Value struct {
 	Type       ValueType
 	Data       []byte                        // for strings
-	Set        map[string]struct{}           // for sets
-	Hash       map[string]string             // for hashes
+	Set        map[string]struct{}           // for sets, hashtable encoding
+	Hash       map[string]string             // for hashes, hashtable encoding
 	CMS        *datastuctures.CountMinSketch // for Count-Min Sketch
 	List       []string
 	ZSet       map[string]float64
+	ZSL        *datastuctures.SkipList    // ordered index over ZSet
 	BF         *datastuctures.BloomFilter // for Bloom Filter
 	Expiration int64                      // Unix timestamp in seconds; 0 means no expiration
-	LastAccess int64                      // Unix timestamp in seconds
+	LastAccess int64                      // Unix timestamp in nanoseconds, for LRU eviction
+
+	packed *packed // small sets and hashes instead of Set or Hash, see encoding.go
 }
 
+var (
+	ErrWrongType      = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNotInteger     = errors.New("ERR value is not an integer or out of range")
+	ErrNotFloat       = errors.New("ERR value is not a valid float")
+	ErrHashNotInteger = errors.New("ERR hash value is not an integer")
+	ErrHashNotFloat   = errors.New("ERR hash value is not a float")
+	ErrIncrOverflow   = errors.New("ERR increment or decrement would overflow")
+	ErrIncrNaN        = errors.New("ERR increment would produce NaN or Infinity")
+	ErrScoreNaN       = errors.New("ERR resulting score is not a number (NaN)")
+	ErrStringTooLong  = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
 type Store struct {
-	mu      sync.RWMutex
-	data    map[string]Value
-	ttl     map[string]time.Time
-	ttlKeys []string // for random sampling
+	mu          sync.RWMutex
+	data        Engine
+	ttl         map[string]time.Time
+	ttlKeys     ttlIndex                    // keys of ttl by expiration, see expire.go
+	ttlPolicies atomic.Pointer[[]TTLPolicy] // longest prefix first, see ttl_policy.go
+	stats       storeStats
+	limits      storeLimits
+	defrag      defragState
+	memory      memoryState
+	cleaner     cleanerSettings
+	lazy        lazyState
+	adaptive    adaptiveState
+	types       typeIndex   // keys of each type, see indexKey
+	dbKeys      map[int]int // keys of each database but 0, see countDBKey
+	events      eventHook
+	scores      scoreWatch
+	strings     internTable
+	encoding    storeEncoding
+	bloom       atomic.Pointer[BloomDefaults]
+	clock       atomic.Pointer[Clock] // nil: SystemClock
+	pinned      atomic.Int64          // see pinClock; 0 when not pinned
+	pinBase     time.Time
+	dirty       dirtyKeys // changed since the last backup
+	slotIndex   slotIndex // keys of each slot while resharding, see indexSlots
+}
+
+// cleanerSettings are read by the shard worker on every expire cycle
+type cleanerSettings struct {
+	sampleSize atomic.Int64
+	interval   atomic.Int64
+	wake       chan struct{} // settings changed, or a key falls due before nextCycle
+	delay      time.Duration // until the next cycle; only touched by the worker
+	nextCycle  atomic.Int64  // UnixNano by the store's clock of the next cycle
+	stats      expiryStats
 }
 
+// expired reports whether key has outlived its TTL and, if so, deletes it.
+// Callers must hold s.mu for writing; read paths use expiredRead instead.
 func (s *Store) expired(key string) bool {
-	exp, ok := s.ttl[key]
-	if !ok {
+	if !s.pastTTL(key) {
 		return false
 	}
-	if time.Now().After(exp) {
-		s.mu.Lock()
-		delete(s.data, key)
-		delete(s.ttl, key)
-		s.mu.Unlock()
-		return true
-	}
-	return false
+	s.expire(key)
+	return true
+}
+
+// pastTTL reports whether key has a TTL that has passed. Callers must hold
+// s.mu, for reading or writing.
+func (s *Store) pastTTL(key string) bool {
+	exp, ok := s.ttl[key]
+	return ok && s.now().After(exp)
 }
 
 func NewStore() *Store {
+	return NewStoreWithEngine(newMemoryEngine())
+}
+
+// NewStoreWithEngine creates a store that keeps its keys in e
+func NewStoreWithEngine(e Engine) *Store {
 	return &Store{
-		data: make(map[string]Value),
-		ttl:  make(map[string]time.Time),
+		data:     e,
+		ttl:      make(map[string]time.Time),
+		cleaner:  cleanerSettings{wake: make(chan struct{}, 1)},
+		lazy:     newLazyState(),
+		adaptive: adaptiveState{wake: make(chan struct{}, 1)},
+		pinBase:  time.Now(),
 	}
 }
 
+// Engine returns the name of the engine holding the store's keys
+func (s *Store) Engine() string {
+	return s.data.Name()
+}
+
+// Close releases the engine, e.g. removing a disk engine's spill file
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Close()
+}
+
 func (s *Store) Set(key string, val []byte, expire time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -72,32 +143,34 @@ func (s *Store) Set(key string, val []byte, expire time.Duration) {
 	s.expired(key)
 	expiration := int64(0)
 
-	s.data[key] = Value{
+	s.data.Put(key, Value{
 		Type:       StringType, // Set the type for string values
 		Data:       val,
 		Expiration: expiration,
-		LastAccess: time.Now().UnixNano(),
-	}
+		LastAccess: s.now().UnixNano(),
+	})
 	if expire > 0 {
-		if _, exists := s.ttl[key]; !exists {
-			s.ttlKeys = append(s.ttlKeys, key) //track new TTL key
-		}
-		s.ttl[key] = time.Now().Add(expire)
+		s.setTTL(key, s.now().Add(expire))
 	} else {
-		delete(s.ttl, key)
+		s.clearTTL(key)
+	}
+	s.accountKey(key)
+	s.notify(EventString, "set", key)
+	if expire > 0 {
+		s.notify(EventGeneric, "expire", key)
 	}
 }
 
 func (s *Store) Get(key string) ([]byte, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if s.expired(key) {
+	if s.expiredRead(key) {
 		log.Printf("DEBUG: %s - Found in store but expired", key)
 		return nil, false
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.lookupRead(key)
 	if !ok {
 		log.Printf("DEBUG: %s - Not found in store data map", key)
 		return nil, false
@@ -107,9 +180,9 @@ func (s *Store) Get(key string) ([]byte, bool) {
 	case StringType:
 		log.Printf("DEBUG: %s - Found string value with data %q", key, string(val.Data))
 	case SetType:
-		log.Printf("DEBUG: %s - Found set with %d members", key, len(val.Set))
+		log.Printf("DEBUG: %s - Found set with %d members", key, val.setLen())
 	case HashType:
-		log.Printf("DEBUG: %s - Found hash with %d fields", key, len(val.Hash))
+		log.Printf("DEBUG: %s - Found hash with %d fields", key, val.hashLen())
 	case CMSType:
 		if val.CMS != nil {
 			log.Printf("DEBUG: %s - Found CMS with width=%d, depth=%d", key, val.CMS.Width, val.CMS.Depth)
@@ -131,9 +204,7 @@ func (s *Store) Get(key string) ([]byte, bool) {
 		log.Printf("WARNING: %s - Found with StringType but empty data", key)
 		return nil, false
 	}
-
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
+	s.touch(key)
 
 	if !ok {
 		return nil, false
@@ -142,103 +213,261 @@ func (s *Store) Get(key string) ([]byte, bool) {
 }
 
 func (s *Store) Delete(key string) bool {
+	return s.delete(key, true)
+}
+
+// migrateDelete drops the source copy of a key that moved to another shard.
+// The key still exists, so no event is sent.
+func (s *Store) migrateDelete(key string) bool {
+	return s.delete(key, false)
+}
+
+func (s *Store) delete(key string, notify bool) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	_, exists := s.data[key]
+	_, exists := s.data.Get(key)
 	if exists {
-		delete(s.data, key)
-		delete(s.ttl, key)
+		s.data.Delete(key)
+		s.clearTTL(key)
+		if notify {
+			s.notify(EventGeneric, "del", key)
+		}
 		return true
 	}
 
 	return exists
 }
 
+// maxStringSize mirrors Redis's proto-max-bulk-len for SETRANGE/APPEND growth
+const maxStringSize = 512 * 1024 * 1024
+
+// APPEND key value, returns the new length
+func (s *Store) Append(key, suffix string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired(key) {
+		s.data.Delete(key)
+	}
+
+	val, ok := s.data.Get(key)
+	if !ok {
+		val = Value{Type: StringType}
+	}
+	if val.Type != StringType {
+		return 0, ErrWrongType
+	}
+	if len(val.Data)+len(suffix) > maxStringSize {
+		return 0, ErrStringTooLong
+	}
+	if err := s.checkValueSize(len(val.Data) + len(suffix)); err != nil {
+		return 0, err
+	}
+
+	data := make([]byte, 0, len(val.Data)+len(suffix))
+	data = append(data, val.Data...)
+	data = append(data, suffix...)
+	val.Data = data
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventString, "append", key)
+	return len(data), nil
+}
+
+// STRLEN key
+func (s *Store) StrLen(key string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return 0, nil
+	}
+
+	val, ok := s.lookupRead(key)
+	if !ok {
+		return 0, nil
+	}
+	if val.Type != StringType {
+		return 0, ErrWrongType
+	}
+	return len(val.Data), nil
+}
+
+// GETRANGE key start end, offsets are inclusive and may be negative
+func (s *Store) GetRange(key string, start, end int) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return "", nil
+	}
+
+	val, ok := s.lookupRead(key)
+	if !ok {
+		return "", nil
+	}
+	if val.Type != StringType {
+		return "", ErrWrongType
+	}
+
+	n := len(val.Data)
+	if start < 0 && end < 0 && start > end {
+		return "", nil
+	}
+	if start < 0 {
+		start = n + start
+	}
+	if end < 0 {
+		end = n + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if start > end || n == 0 {
+		return "", nil
+	}
+	s.touch(key)
+	return string(val.Data[start : end+1]), nil
+}
+
+// SETRANGE key offset value, zero-pads the string when offset is past the end
+func (s *Store) SetRange(key string, offset int, value string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired(key) {
+		s.data.Delete(key)
+	}
+
+	val, ok := s.data.Get(key)
+	if ok && val.Type != StringType {
+		return 0, ErrWrongType
+	}
+	if len(value) == 0 {
+		return len(val.Data), nil
+	}
+	if offset+len(value) > maxStringSize {
+		return 0, ErrStringTooLong
+	}
+	if err := s.checkValueSize(max(offset+len(value), len(val.Data))); err != nil {
+		return 0, err
+	}
+	if !ok {
+		val = Value{Type: StringType}
+	}
+
+	size := len(val.Data)
+	if offset+len(value) > size {
+		size = offset + len(value)
+	}
+	data := make([]byte, size)
+	copy(data, val.Data)
+	copy(data[offset:], value)
+	val.Data = data
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventString, "setrange", key)
+	return len(data), nil
+}
+
+// GETSET key value, returns the old value and clears any TTL
+func (s *Store) GetSet(key string, value []byte) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired(key) {
+		s.data.Delete(key)
+	}
+
+	old, ok := s.data.Get(key)
+	if ok && old.Type != StringType {
+		return nil, false, ErrWrongType
+	}
+	if err := s.checkValueSize(len(value)); err != nil {
+		return nil, false, err
+	}
+
+	s.data.Put(key, Value{
+		Type:       StringType,
+		Data:       value,
+		LastAccess: s.now().UnixNano(),
+	})
+	s.clearTTL(key)
+	s.notify(EventString, "set", key)
+	return old.Data, ok, nil
+}
+
+// SETNX key value, returns true if the key was set
+func (s *Store) SetNX(key string, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired(key) {
+		s.data.Delete(key)
+	}
+
+	if _, exists := s.data.Get(key); exists {
+		return false, nil
+	}
+	if err := s.checkValueSize(len(value)); err != nil {
+		return false, err
+	}
+	s.data.Put(key, Value{
+		Type:       StringType,
+		Data:       value,
+		LastAccess: s.now().UnixNano(),
+	})
+	s.notify(EventString, "set", key)
+	return true, nil
+}
+
 func (s *Store) TTL(key string) int64 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	exp, ok := s.ttl[key]
 	if !ok {
-		if _, exists := s.data[key]; exists {
+		if _, exists := s.data.Get(key); exists {
 			return -1 // no expiration
 		}
 		return -2 // key does not exist
 	}
 
-	ttl := time.Until(exp)
+	ttl := exp.Sub(s.now())
 	if ttl <= 0 {
 		return -2
 	}
 	return int64(ttl.Seconds())
 }
 
+// PTTL is TTL in milliseconds
 func (s *Store) PTTL(key string) int64 {
-	s.mu.Lock()
+	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	exp, ok := s.ttl[key]
 	if !ok {
-		if _, exists := s.data[key]; exists {
+		if _, exists := s.data.Get(key); exists {
 			return -1
 		}
 		return -2
 	}
 
-	ttl := time.Until(exp)
+	ttl := exp.Sub(s.now())
 	if ttl <= 0 {
 		return -2
 	}
-	return ttl.Microseconds()
+	return max(ttl.Milliseconds(), 1)
 }
 
-func (s *Store) StartCleaner(sampleSize int, interval time.Duration) {
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-
-		for range ticker.C {
-			for {
-				expired := s.expireCycle(sampleSize)
-				if expired < sampleSize/4 { // if less than 25% expired, break to avoid busy loop
-					break
-				}
-			}
-		}
-	}()
-}
-
-func (s *Store) expireCycle(sampleSize int) int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if len(s.ttlKeys) == 0 {
-		return 0
-	}
-
-	expiredCount := 0
-	now := time.Now()
-
-	for i := 0; i < sampleSize; i++ {
-		// pick random key
-		idx := rand.Intn(len(s.ttlKeys))
-		k := s.ttlKeys[idx]
-
-		exp, ok := s.ttl[k]
-		if !ok {
-			continue
-		}
-		if now.After(exp) {
-			delete(s.data, k)
-			delete(s.ttl, k)
-			expiredCount++
-		}
-	}
-	return expiredCount
-}
-
-func (s *Store) SAdd(key string, members ...string) int {
+func (s *Store) SAdd(key string, members ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -246,26 +475,33 @@ func (s *Store) SAdd(key string, members ...string) int {
 		// expired key is like it never existed
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.data.Get(key)
 	if !ok {
-		val = Value{Type: SetType, Set: make(map[string]struct{})}
-		s.data[key] = val
+		val = s.newSetValue()
 	}
 
 	if val.Type != SetType {
-		return 0 // in Redis, this would be a WRONGTYPE error (we’ll handle in dispatcher)
+		return 0, nil // in Redis, this would be a WRONGTYPE error (we’ll handle in dispatcher)
+	}
+	if err := s.checkElements(members); err != nil {
+		return 0, err
 	}
-	val.LastAccess = time.Now().UnixNano()
+	if err := s.checkCollectionLen(val.setLen() + countNew(members, val.setHas)); err != nil {
+		return 0, err
+	}
+	val.LastAccess = s.now().UnixNano()
 
 	added := 0
 	for _, m := range members {
-		if _, exists := val.Set[m]; !exists {
-			val.Set[m] = struct{}{}
+		if s.setAdd(&val, m) {
 			added++
 		}
 	}
-	s.data[key] = val
-	return added
+	s.data.Put(key, val)
+	if added > 0 {
+		s.notify(EventSet, "sadd", key)
+	}
+	return added, nil
 }
 
 func (s *Store) SRem(key string, members ...string) int {
@@ -276,20 +512,22 @@ func (s *Store) SRem(key string, members ...string) int {
 		return 0
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.data.Get(key)
 	if !ok || val.Type != SetType {
 		return 0
 	}
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
-
 	removed := 0
 	for _, m := range members {
-		if _, exists := val.Set[m]; exists {
-			delete(val.Set, m)
+		if val.setRemove(m) {
 			removed++
 		}
 	}
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.noteShrink(key, removed)
+	if removed > 0 {
+		s.notify(EventSet, "srem", key)
+	}
 	return removed
 }
 
@@ -298,19 +536,18 @@ func (s *Store) SMembers(key string) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.expired(key) {
+	if s.expiredRead(key) {
 		return nil
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != SetType {
 		return nil
 	}
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
+	s.touch(key)
 
-	out := make([]string, 0, len(val.Set))
-	for m := range val.Set {
+	out := make([]string, 0, val.setLen())
+	for m := range val.setMembers() {
 		out = append(out, m)
 	}
 	return out
@@ -321,167 +558,34 @@ func (s *Store) SCard(key string) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.expired(key) {
+	if s.expiredRead(key) {
 		return 0
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != SetType {
 		return 0
 	}
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
+	s.touch(key)
 
-	return len(val.Set)
+	return val.setLen()
 }
 
 func (s *Store) SIsMember(key, member string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.expired(key) {
+	if s.expiredRead(key) {
 		return false
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != SetType {
 		return false
 	}
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
-
-	_, exists := val.Set[member]
-	return exists
-}
-
-// SUnion returns the union of multiple sets
-func (s *Store) SUnion(keys ...string) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	result := make(map[string]struct{})
-	for _, k := range keys {
-		if s.expired(k) {
-			continue
-		}
-		val, ok := s.data[k]
-		if !ok || val.Type != SetType {
-			continue
-		}
-		val.LastAccess = time.Now().UnixNano()
-		s.data[k] = val
-		for m := range val.Set {
-			result[m] = struct{}{}
-		}
-	}
+	s.touch(key)
 
-	out := make([]string, 0, len(result))
-	for m := range result {
-		out = append(out, m)
-	}
-	return out
-}
-
-// SInter returns the intersection of multiple sets
-func (s *Store) SInter(keys ...string) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	if len(keys) == 0 {
-		return nil
-	}
-
-	//Start with 1st set
-	firstKey := keys[0]
-	if s.expired(firstKey) {
-		return nil
-	}
-	val, ok := s.data[firstKey]
-	if !ok || val.Type != SetType {
-		return nil
-	}
-
-	val.LastAccess = time.Now().UnixNano()
-	s.data[firstKey] = val
-
-	result := make(map[string]struct{})
-	for m := range val.Set {
-		result[m] = struct{}{}
-	}
-
-	//Intersert with remaining sets
-	for _, k := range keys[1:] {
-		if s.expired(k) {
-			return nil
-		}
-		v, ok := s.data[k]
-		if !ok || v.Type != SetType {
-			return nil
-		}
-		v.LastAccess = time.Now().UnixNano()
-		s.data[k] = v
-		for m := range result {
-			if _, exists := val.Set[m]; !exists {
-				delete(result, m)
-			}
-		}
-	}
-
-	out := make([]string, 0, len(result))
-	for m := range result {
-		out = append(out, m)
-	}
-	return out
-}
-
-// Difference (elements in first set but not in others).
-func (s *Store) SDiff(keys ...string) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	if len(keys) == 0 {
-		return nil
-	}
-
-	firstKey := keys[0]
-	if s.expired(firstKey) {
-		return nil
-	}
-	val, ok := s.data[firstKey]
-	if !ok || val.Type != SetType {
-		return nil
-	}
-
-	// LRU: update LastAccess for firstKey
-	val.LastAccess = time.Now().UnixNano()
-	s.data[firstKey] = val
-
-	result := make(map[string]struct{})
-	for m := range val.Set {
-		result[m] = struct{}{}
-	}
-
-	for _, k := range keys[1:] {
-		if s.expired(k) {
-			continue
-		}
-		v, ok := s.data[k]
-		if !ok || v.Type != SetType {
-			continue
-		}
-		// LRU: update LastAccess for k
-		v.LastAccess = time.Now().UnixNano()
-		s.data[k] = v
-		for m := range v.Set {
-			delete(result, m)
-		}
-	}
-
-	out := make([]string, 0, len(result))
-	for m := range result {
-		out = append(out, m)
-	}
-	return out
+	return val.setHas(member)
 }
 
 // Return one or more random ellements
@@ -489,22 +593,22 @@ func (s *Store) SRandMember(key string, count int) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.expired(key) {
+	if s.expiredRead(key) {
 		return nil
 	}
-	val, ok := s.data[key]
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != SetType {
 		return nil
 	}
 
-	n := len(val.Set)
+	n := val.setLen()
 	if n == 0 {
 		return nil
 	}
 
 	//Flatten to slice
 	all := make([]string, 0, n)
-	for m := range val.Set {
+	for m := range val.setMembers() {
 		all = append(all, m)
 	}
 
@@ -522,8 +626,7 @@ func (s *Store) SRandMember(key string, count int) []string {
 	rand.Shuffle(n, func(i, j int) {
 		all[i], all[j] = all[j], all[i]
 	})
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
+	s.touch(key)
 	return all[:count]
 }
 
@@ -535,19 +638,19 @@ func (s *Store) SPop(key string, count int) []string {
 	if s.expired(key) {
 		return nil
 	}
-	val, ok := s.data[key]
+	val, ok := s.data.Get(key)
 	if !ok || val.Type != SetType {
 		return nil
 	}
 
-	n := len(val.Set)
+	n := val.setLen()
 	if n == 0 {
 		return nil
 	}
 
 	//Flatten to slice
 	all := make([]string, 0, n)
-	for m := range val.Set {
+	for m := range val.setMembers() {
 		all = append(all, m)
 	}
 
@@ -565,65 +668,80 @@ func (s *Store) SPop(key string, count int) []string {
 
 	// Remove from set
 	for _, m := range selected {
-		delete(val.Set, m)
+		val.setRemove(m)
 	}
+	s.noteShrink(key, len(selected))
+	s.notify(EventSet, "spop", key)
 
 	// If empty after removal, delete key entirely
-	if len(val.Set) == 0 {
-		delete(s.data, key)
+	if val.setLen() == 0 {
+		s.data.Delete(key)
+		s.notify(EventGeneric, "del", key)
 	} else {
-		val.LastAccess = time.Now().UnixNano()
-		s.data[key] = val
+		val.LastAccess = s.now().UnixNano()
+		s.data.Put(key, val)
 	}
 
 	return selected
 }
 
-// HSET key field value
-func (s *Store) HSet(key, field, value string) int {
+// HSET key field value [field value ...]
+func (s *Store) HSet(key string, fieldValues ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.expired(key) {
-		delete(s.data, key)
+		s.data.Delete(key)
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.data.Get(key)
 	if !ok {
-		val = Value{Type: HashType, Hash: make(map[string]string)}
-		s.data[key] = val
+		val = s.newHashValue()
 	}
 	if val.Type != HashType {
-		return 0
+		return 0, nil
+	}
+	if err := s.checkElements(fieldValues); err != nil {
+		return 0, err
+	}
+	fields := make([]string, 0, len(fieldValues)/2)
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		fields = append(fields, fieldValues[i])
+	}
+	if err := s.checkCollectionLen(val.hashLen() + countNew(fields, func(f string) bool {
+		_, exists := val.hashGet(f)
+		return exists
+	})); err != nil {
+		return 0, err
 	}
 
-	_, exists := val.Hash[field]
-	val.Hash[field] = value
-	if !exists {
-		return 0
+	added := 0
+	for i := 0; i+1 < len(fieldValues); i += 2 {
+		if s.hashSet(&val, fieldValues[i], fieldValues[i+1]) {
+			added++
+		}
 	}
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
-	return 1
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventHash, "hset", key)
+	return added, nil
 }
 
 // HGET key field
 func (s *Store) HGet(key, field string) (string, bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if s.expired(key) {
-		delete(s.data, key)
+	if s.expiredRead(key) {
 		return "", false
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != HashType {
 		return "", false
 	}
-	value, ok := val.Hash[field]
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
+	value, ok := val.hashGet(field)
+	s.touch(key)
 	return value, ok
 }
 
@@ -633,28 +751,32 @@ func (s *Store) HDel(key string, fields ...string) int {
 	defer s.mu.Unlock()
 
 	if s.expired(key) {
-		delete(s.data, key)
+		s.data.Delete(key)
 		return 0
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.data.Get(key)
 	if !ok || val.Type != HashType {
 		return 0
 	}
 
 	deleted := 0
 	for _, f := range fields {
-		if _, exists := val.Hash[f]; exists {
-			delete(val.Hash, f)
+		if val.hashRemove(f) {
 			deleted++
 		}
 	}
+	s.noteShrink(key, deleted)
+	if deleted > 0 {
+		s.notify(EventHash, "hdel", key)
+	}
 
-	if len(val.Hash) == 0 {
-		delete(s.data, key)
+	if val.hashLen() == 0 {
+		s.data.Delete(key)
+		s.notify(EventGeneric, "del", key)
 	} else {
-		val.LastAccess = time.Now().UnixNano()
-		s.data[key] = val
+		val.LastAccess = s.now().UnixNano()
+		s.data.Put(key, val)
 	}
 
 	return deleted
@@ -662,38 +784,208 @@ func (s *Store) HDel(key string, fields ...string) int {
 
 // HGETALL key
 func (s *Store) HGetAll(key string) map[string]string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if s.expired(key) {
-		delete(s.data, key)
+	if s.expiredRead(key) {
 		return nil
 	}
 
-	val, ok := s.data[key]
-	val.LastAccess = time.Now().UnixNano()
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != HashType {
 		return nil
 	}
 
-	result := make(map[string]string, len(val.Hash))
-	for k, val := range val.Hash {
+	result := make(map[string]string, val.hashLen())
+	for k, val := range val.hashFields() {
 		result[k] = val
 	}
-	s.data[key] = val
+	s.touch(key)
 	return result
 }
 
+// HMGET key field [field ...]; missing fields are returned as nil
+func (s *Store) HMGet(key string, fields ...string) []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]interface{}, len(fields))
+	if s.expiredRead(key) {
+		return out
+	}
+
+	val, ok := s.lookupRead(key)
+	if !ok || val.Type != HashType {
+		return out
+	}
+	for i, f := range fields {
+		if v, exists := val.hashGet(f); exists {
+			out[i] = v
+		}
+	}
+	s.touch(key)
+	return out
+}
+
+// HEXISTS key field
+func (s *Store) HExists(key, field string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return false
+	}
+
+	val, ok := s.lookupRead(key)
+	if !ok || val.Type != HashType {
+		return false
+	}
+	_, exists := val.hashGet(field)
+	s.touch(key)
+	return exists
+}
+
+// HLEN key
+func (s *Store) HLen(key string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return 0
+	}
+
+	val, ok := s.lookupRead(key)
+	if !ok || val.Type != HashType {
+		return 0
+	}
+	s.touch(key)
+	return val.hashLen()
+}
+
+// HKEYS key
+func (s *Store) HKeys(key string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return nil
+	}
+
+	val, ok := s.lookupRead(key)
+	if !ok || val.Type != HashType {
+		return nil
+	}
+	out := make([]string, 0, val.hashLen())
+	for f := range val.hashFields() {
+		out = append(out, f)
+	}
+	s.touch(key)
+	return out
+}
+
+// HVALS key
+func (s *Store) HVals(key string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.expiredRead(key) {
+		return nil
+	}
+
+	val, ok := s.lookupRead(key)
+	if !ok || val.Type != HashType {
+		return nil
+	}
+	out := make([]string, 0, val.hashLen())
+	for _, v := range val.hashFields() {
+		out = append(out, v)
+	}
+	s.touch(key)
+	return out
+}
+
+// HINCRBY key field increment
+func (s *Store) HIncrBy(key, field string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired(key) {
+		s.data.Delete(key)
+	}
+
+	val, ok := s.data.Get(key)
+	if !ok {
+		val = s.newHashValue()
+	}
+	if val.Type != HashType {
+		return 0, ErrWrongType
+	}
+
+	var cur int64
+	if raw, exists := val.hashGet(field); exists {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return 0, ErrHashNotInteger
+		}
+		cur = n
+	}
+	if (delta > 0 && cur > math.MaxInt64-delta) || (delta < 0 && cur < math.MinInt64-delta) {
+		return 0, ErrIncrOverflow
+	}
+	cur += delta
+	s.hashSet(&val, field, strconv.FormatInt(cur, 10))
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventHash, "hincrby", key)
+	return cur, nil
+}
+
+// HINCRBYFLOAT key field increment
+func (s *Store) HIncrByFloat(key, field string, delta float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.expired(key) {
+		s.data.Delete(key)
+	}
+
+	val, ok := s.data.Get(key)
+	if !ok {
+		val = s.newHashValue()
+	}
+	if val.Type != HashType {
+		return 0, ErrWrongType
+	}
+
+	var cur float64
+	if raw, exists := val.hashGet(field); exists {
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return 0, ErrHashNotFloat
+		}
+		cur = f
+	}
+	cur += delta
+	if math.IsNaN(cur) || math.IsInf(cur, 0) {
+		return 0, ErrIncrNaN
+	}
+	s.hashSet(&val, field, strconv.FormatFloat(cur, 'f', -1, 64))
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventHash, "hincrbyfloat", key)
+	return cur, nil
+}
+
 // CMS.INCR key item count
 func (s *Store) CMSIncr(key, item string, count uint32) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	if s.expired(key) {
-		delete(s.data, key)
+		s.data.Delete(key)
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.data.Get(key)
 	if !ok {
 		val = Value{
 			Type: CMSType,
@@ -705,8 +997,9 @@ func (s *Store) CMSIncr(key, item string, count uint32) {
 	}
 
 	val.CMS.Incr(item, count)
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventModule, "cmsincr", key)
 }
 
 // CMS.QUERY key item
@@ -714,67 +1007,77 @@ func (s *Store) CMSQuery(key, item string) uint32 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.expired(key) {
-		delete(s.data, key)
+	if s.expiredRead(key) {
 		return 0
 	}
 
-	val, ok := s.data[key]
-	val.LastAccess = time.Now().UnixNano()
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != CMSType {
 		return 0
 	}
 
-	s.data[key] = val
+	s.touch(key)
 	return val.CMS.Query(item)
 }
 
 // LPUSH
-func (s *Store) LPush(key string, values ...string) int {
+func (s *Store) LPush(key string, values ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	val, ok := s.data[key]
+	val, ok := s.data.Get(key)
 	if !ok {
 		val = Value{
 			Type: ListType,
 			List: []string{},
 		}
-		s.data[key] = val
 	}
 	if val.Type != ListType {
-		return -1
+		return -1, nil
+	}
+	if err := s.checkElements(values); err != nil {
+		return 0, err
+	}
+	if err := s.checkCollectionLen(len(val.List) + len(values)); err != nil {
+		return 0, err
 	}
 
 	// Prepend (reverse order for multiple push)
 	for i := len(values) - 1; i >= 0; i-- {
 		val.List = append([]string{values[i]}, val.List...)
 	}
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
-	return len(val.List)
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventList, "lpush", key)
+	return len(val.List), nil
 }
 
 // RPUSH
-func (s *Store) RPush(key string, values ...string) int {
+func (s *Store) RPush(key string, values ...string) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	val, ok := s.data[key]
+	val, ok := s.data.Get(key)
 	if !ok {
 		val = Value{
 			Type: ListType,
 			List: []string{},
 		}
-		s.data[key] = val
 	}
 	if val.Type != ListType {
-		return -1
+		return -1, nil
+	}
+	if err := s.checkElements(values); err != nil {
+		return 0, err
+	}
+	if err := s.checkCollectionLen(len(val.List) + len(values)); err != nil {
+		return 0, err
 	}
 	val.List = append(val.List, values...)
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
-	return len(val.List)
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventList, "rpush", key)
+	return len(val.List), nil
 }
 
 // LPOP
@@ -783,19 +1086,22 @@ func (s *Store) LPop(key string) (string, bool) {
 	defer s.mu.Unlock()
 
 	if s.expired(key) {
-		delete(s.data, key)
+		s.data.Delete(key)
 		return "", false
 	}
 
-	val, ok := s.data[key]
-	val.LastAccess = time.Now().UnixNano()
+	val, ok := s.data.Get(key)
+	val.LastAccess = s.now().UnixNano()
 	if !ok || val.Type != ListType || len(val.List) == 0 {
 		return "", false
 	}
 
 	item := val.List[0]
 	val.List = val.List[1:]
-	s.data[key] = val
+	s.data.Put(key, val)
+	// the popped prefix is invisible to cap(), so let defrag count it
+	s.noteShrink(key, 1)
+	s.notify(EventList, "lpop", key)
 	return item, true
 }
 
@@ -805,12 +1111,12 @@ func (s *Store) RPop(key string) (string, bool) {
 	defer s.mu.Unlock()
 
 	if s.expired(key) {
-		delete(s.data, key)
+		s.data.Delete(key)
 		return "", false
 	}
 
-	val, ok := s.data[key]
-	val.LastAccess = time.Now().UnixNano()
+	val, ok := s.data.Get(key)
+	val.LastAccess = s.now().UnixNano()
 	if !ok || val.Type != ListType || len(val.List) == 0 {
 		return "", false
 	}
@@ -818,41 +1124,38 @@ func (s *Store) RPop(key string) (string, bool) {
 	idx := len(val.List) - 1
 	item := val.List[idx]
 	val.List = val.List[:idx]
-	s.data[key] = val
+	s.data.Put(key, val)
+	s.notify(EventList, "rpop", key)
 	return item, true
 }
 
 // LLEN
 func (s *Store) LLen(key string) int {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if s.expired(key) {
-		delete(s.data, key)
+	if s.expiredRead(key) {
 		return 0
 	}
 
-	val, ok := s.data[key]
-	val.LastAccess = time.Now().UnixNano()
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != ListType {
 		return 0
 	}
-	s.data[key] = val
+	s.touch(key)
 	return len(val.List)
 }
 
 // LRANGE
 func (s *Store) LRange(key string, start, stop int) []string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if s.expired(key) {
-		delete(s.data, key)
+	if s.expiredRead(key) {
 		return nil
 	}
 
-	val, ok := s.data[key]
-	val.LastAccess = time.Now().UnixNano()
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != ListType {
 		return nil
 	}
@@ -881,37 +1184,127 @@ func (s *Store) LRange(key string, start, stop int) []string {
 		return nil
 	}
 
-	s.data[key] = val
+	s.touch(key)
 	return val.List[start : stop+1]
 }
 
 // ZADD
-func (s *Store) ZAdd(key string, members map[string]float64) int {
+func (s *Store) ZAdd(key string, members map[string]float64) (int, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	val, ok := s.data[key]
+	val, ok := s.data.Get(key)
 	if !ok {
-		val = Value{
-			Type: ZSetType,
-			ZSet: make(map[string]float64),
-		}
-		s.data[key] = val
+		val = newZSetValue()
 	}
 	if val.Type != ZSetType {
-		return -1
+		return -1, nil
 	}
+	added, _, err := s.zadd(key, val, members)
+	return added, err
+}
 
-	added := 0
+// zadd sets the scores of members in val, the sorted set stored at key or
+// a new one, and reports how many members were added and how many existing
+// ones changed score. Callers hold s.mu.
+func (s *Store) zadd(key string, val Value, members map[string]float64) (added, changed int, err error) {
+	names := make([]string, 0, len(members))
+	for m := range members {
+		names = append(names, m)
+	}
+	if err := s.checkElements(names); err != nil {
+		return 0, 0, err
+	}
+	if err := s.checkCollectionLen(len(val.ZSet) + countNew(names, func(m string) bool {
+		_, exists := val.ZSet[m]
+		return exists
+	})); err != nil {
+		return 0, 0, err
+	}
+
+	var changes []ScoreChange
+	watch := s.scoreWatcher(key)
+	if watch != nil {
+		changes = scoreChanges(val, members)
+	}
 	for member, score := range members {
-		if _, exists := val.ZSet[member]; !exists {
+		member = s.intern(member)
+		if old, exists := val.ZSet[member]; exists {
+			if old != score {
+				changed++
+			}
+			val.ZSL.UpdateScore(member, old, score)
+		} else {
+			val.ZSL.Insert(member, score)
 			added++
 		}
 		val.ZSet[member] = score
 	}
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
-	return added
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventZSet, "zadd", key)
+	if watch != nil {
+		watch.report(key, val, changes)
+	}
+	return added, changed, nil
+}
+
+// ZIncrBy adds delta to the score of member, adding the member with score
+// delta if it is missing, and returns the new score
+func (s *Store) ZIncrBy(key, member string, delta float64) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expired(key)
+	val, ok := s.data.Get(key)
+	if !ok {
+		val = newZSetValue()
+	}
+	if val.Type != ZSetType {
+		return 0, ErrWrongType
+	}
+	old, exists := val.ZSet[member]
+	if !exists {
+		if err := s.checkElements([]string{member}); err != nil {
+			return 0, err
+		}
+		if err := s.checkCollectionLen(len(val.ZSet) + 1); err != nil {
+			return 0, err
+		}
+	}
+	score := old + delta
+	if math.IsNaN(score) {
+		return 0, ErrScoreNaN
+	}
+
+	var changes []ScoreChange
+	watch := s.scoreWatcher(key)
+	if watch != nil {
+		changes = scoreChanges(val, map[string]float64{member: score})
+	}
+	member = s.intern(member)
+	if exists {
+		val.ZSL.UpdateScore(member, old, score)
+	} else {
+		val.ZSL.Insert(member, score)
+	}
+	val.ZSet[member] = score
+	val.LastAccess = s.now().UnixNano()
+	s.data.Put(key, val)
+	s.notify(EventZSet, "zincr", key)
+	if watch != nil {
+		watch.report(key, val, changes)
+	}
+	return score, nil
+}
+
+// newZSetValue returns an empty sorted set with its skiplist index
+func newZSetValue() Value {
+	return Value{
+		Type: ZSetType,
+		ZSet: make(map[string]float64),
+		ZSL:  datastuctures.NewSkipList(),
+	}
 }
 
 // ZSCORE
@@ -919,18 +1312,17 @@ func (s *Store) ZScore(key, member string) (float64, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.expired(key) {
-		delete(s.data, key)
+	if s.expiredRead(key) {
 		return 0, false
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != ZSetType {
 		return 0, false
 	}
 
 	score, exists := val.ZSet[member]
-	s.data[key] = val
+	s.touch(key)
 	return score, exists
 }
 
@@ -939,16 +1331,15 @@ func (s *Store) ZCard(key string) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.expired(key) {
-		delete(s.data, key)
+	if s.expiredRead(key) {
 		return 0
 	}
 
-	val, ok := s.data[key]
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != ZSetType {
 		return 0
 	}
-	s.data[key] = val
+	s.touch(key)
 	return len(val.ZSet)
 }
 
@@ -957,41 +1348,25 @@ func (s *Store) ZRank(key, member string) (int, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.expired(key) {
-		delete(s.data, key)
+	if s.expiredRead(key) {
 		return 0, false
 	}
 
-	val, ok := s.data[key]
-	val.LastAccess = time.Now().UnixNano()
+	val, ok := s.lookupRead(key)
 	if !ok || val.Type != ZSetType {
 		return 0, false
 	}
 
-	// sort menbers by score
-	type pair struct {
-		member string
-		score  float64
-	}
-	pairs := make([]pair, 0, len(val.ZSet))
-	for m, score := range val.ZSet {
-		pairs = append(pairs, pair{m, score})
+	score, exists := val.ZSet[member]
+	if !exists {
+		return 0, false
 	}
-
-	sort.Slice(pairs, func(i, j int) bool {
-		if pairs[i].score == pairs[j].score {
-			return pairs[i].member < pairs[j].member // tie-breaker: lex order
-		}
-		return pairs[i].score < pairs[j].score
-	})
-	// find rank
-	for rank, p := range pairs {
-		if p.member == member {
-			return rank, true
-		}
+	rank := val.ZSL.Rank(member, score)
+	if rank < 0 {
+		return 0, false
 	}
-	s.data[key] = val
-	return 0, false
+	s.touch(key)
+	return rank, true
 }
 
 // ZRANGE
@@ -999,36 +1374,17 @@ func (s *Store) ZRange(key string, start, stop int, withScores bool) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if s.expired(key) {
-		delete(s.data, key)
+	if s.expiredRead(key) {
 		return nil
 	}
 
-	val, ok := s.data[key]
-	val.LastAccess = time.Now().UnixNano()
+	val, ok := s.lookupRead(key)
 
 	if !ok || val.Type != ZSetType {
 		return nil
 	}
 
-	// sort menbers by score
-	type pair struct {
-		member string
-		score  float64
-	}
-	pairs := make([]pair, 0, len(val.ZSet))
-	for m, score := range val.ZSet {
-		pairs = append(pairs, pair{m, score})
-	}
-
-	sort.Slice(pairs, func(i, j int) bool {
-		if pairs[i].score == pairs[j].score {
-			return pairs[i].member < pairs[j].member // tie-breaker: lex order
-		}
-		return pairs[i].score < pairs[j].score
-	})
-
-	n := len(pairs)
+	n := val.ZSL.Len()
 	if n == 0 {
 		return nil
 	}
@@ -1052,117 +1408,22 @@ func (s *Store) ZRange(key string, start, stop int, withScores bool) []string {
 		return nil
 	}
 
-	result := make([]string, 0, stop-start+1)
-	for _, p := range pairs[start : stop+1] {
-		result = append(result, p.member)
+	entries := val.ZSL.Range(start, stop)
+	result := make([]string, 0, len(entries)*2)
+	for _, e := range entries {
+		result = append(result, e.Member)
 		if withScores {
-			result = append(result, fmt.Sprintf("%f", p.score))
+			result = append(result, fmt.Sprintf("%f", e.Score))
 		}
 	}
-	s.data[key] = val
+	s.touch(key)
 	return result
 }
 
-// BF.ADD
-func (s *Store) BFAdd(key, item string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.expired(key) {
-		delete(s.data, key)
-	}
-
-	// Get or create BloomFilter
-	val, ok := s.data[key]
-	if !ok || val.Type != BFType {
-		bf := datastuctures.NewBloomFilter(1_000_000, 7)
-		bf.Add(item)
-		s.data[key] = Value{
-			Type: BFType,
-			BF:   bf,
-		}
-		return true
-	}
-
-	if val.Type != BFType {
-		return false // WRONGTYPE error in Redis (handled in dispatcher)
-	}
-
-	val.BF.Add(item)
-	val.LastAccess = time.Now().UnixNano()
-	s.data[key] = val
-	return true
-}
-
-// BF.EXISTS
-func (s *Store) BFExists(key, item string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	if s.expired(key) {
-		delete(s.data, key)
-		return false
-	}
-
-	val, ok := s.data[key]
-	val.LastAccess = time.Now().UnixNano()
-
-	if !ok || val.Type != BFType {
-		return false
-	}
-	s.data[key] = val
-	return val.BF.Exists(item)
-}
-
-func (s *Store) EvictOne() bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if len(s.data) == 0 {
-		return false
-	}
-
-	sampleSize := 5
-	if len(s.data) < sampleSize {
-		sampleSize = len(s.data)
-	}
-
-	//Collect random keys
-	keys := make([]string, 0, sampleSize)
-	for k := range s.data {
-		keys = append(keys, k)
-		if len(keys) >= sampleSize {
-			break
-		}
-	}
-
-	// Find least recently used among sampled keys
-	var lruKey string                         // oldest key
-	var lruTime int64 = time.Now().UnixNano() // oldest time
-
-	for _, k := range keys {
-		val, ok := s.data[k]
-		if !ok {
-			continue
-		}
-		if val.LastAccess < lruTime {
-			lruTime = val.LastAccess
-			lruKey = k
-		}
-	}
-
-	if lruKey != "" {
-		delete(s.data, lruKey)
-		delete(s.ttl, lruKey)
-		return true
-	}
-	return false
-}
-
 func (s *Store) ScanKeys(batchSize int) []string {
 	s.mu.RLock()
-	keys := make([]string, 0, len(s.data))
-	for k := range s.data {
+	keys := make([]string, 0, s.data.Len())
+	for k := range s.data.All() {
 		keys = append(keys, k)
 	}
 	s.mu.RUnlock()
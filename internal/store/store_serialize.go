@@ -3,7 +3,6 @@ package store
 import (
 	"bytes"
 	"encoding/gob"
-	"fmt"
 	"log"
 	"time"
 
@@ -16,7 +15,10 @@ type SerializedValue struct {
 	Data []byte              // for strings
 	Set  map[string]struct{} // for sets
 	Hash map[string]string   // for hashes
+	List []string            // for lists
+	ZSet map[string]float64  // for sorted sets (skiplist is rebuilt on restore)
 	CMS  []byte              // serialized CMS data
+	BF   []byte              // serialized Bloom filter
 }
 
 func init() {
@@ -24,6 +26,7 @@ func init() {
 }
 
 func (s *Store) serializeValue(v Value) []byte {
+	v = v.unpacked()
 	var buf bytes.Buffer
 	enc := gob.NewEncoder(&buf)
 
@@ -51,6 +54,8 @@ func (s *Store) serializeValue(v Value) []byte {
 		Data: v.Data,
 		Set:  v.Set,
 		Hash: v.Hash,
+		List: v.List,
+		ZSet: v.ZSet,
 	}
 
 	// If we have a CMS, serialize it separately
@@ -62,6 +67,14 @@ func (s *Store) serializeValue(v Value) []byte {
 		}
 		sv.CMS = cmsBytes
 	}
+	if v.BF != nil {
+		bfBytes, err := v.BF.GobEncode()
+		if err != nil {
+			log.Printf("ERROR: Failed to encode Bloom filter: %v", err)
+			return nil
+		}
+		sv.BF = bfBytes
+	}
 
 	// Encode the serialized version
 	if err := enc.Encode(sv); err != nil {
@@ -93,6 +106,8 @@ func (s *Store) restoreFromDump(kd KeyDump) error {
 		Data: sv.Data,
 		Set:  sv.Set,
 		Hash: sv.Hash,
+		List: sv.List,
+		ZSet: sv.ZSet,
 	}
 
 	// If we have serialized CMS data, deserialize it
@@ -104,6 +119,14 @@ func (s *Store) restoreFromDump(kd KeyDump) error {
 		}
 		v.CMS = cms
 	}
+	if len(sv.BF) > 0 {
+		bf := &datastuctures.BloomFilter{}
+		if err := bf.GobDecode(sv.BF); err != nil {
+			log.Printf("ERROR: Failed to decode Bloom filter: %v", err)
+			return err
+		}
+		v.BF = bf
+	}
 
 	// Initialize nil maps if needed
 	if v.Hash == nil {
@@ -120,9 +143,6 @@ func (s *Store) restoreFromDump(kd KeyDump) error {
 	switch v.Type {
 	case StringType:
 		log.Printf("DEBUG: Restoring string value: type=%d, data=%q", v.Type, string(v.Data))
-		if len(v.Data) == 0 {
-			return fmt.Errorf("empty data for string value")
-		}
 	case SetType:
 		log.Printf("DEBUG: Restoring set value: type=%d, members=%d", v.Type, len(v.Set))
 	case HashType:
@@ -143,7 +163,7 @@ func (s *Store) restoreFromDump(kd KeyDump) error {
 	} else {
 		v.Expiration = 0
 	}
-	v.LastAccess = time.Now().UnixNano()
+	v.LastAccess = s.now().UnixNano()
 
 	//set into store with proper TTL handling
 	s.mu.Lock()
@@ -175,12 +195,21 @@ func (s *Store) restoreFromDump(kd KeyDump) error {
 		}
 		v.ZSet = newZSet
 	}
+	if v.Type == ZSetType {
+		// rebuild the ordered index from the member->score dict
+		v.ZSL = datastuctures.NewSkipList()
+		for member, score := range v.ZSet {
+			v.ZSL.Insert(member, score)
+		}
+	}
 
 	// Store the value and set TTL if needed
-	s.data[kd.Key] = v
+	s.pack(&v)
+	s.data.Put(kd.Key, v)
 	if !kd.TTL.IsZero() {
-		s.ttl[kd.Key] = kd.TTL
+		s.setTTL(kd.Key, kd.TTL)
 	}
+	s.account(kd.Key, false) // moved, not created: no "new" event
 
 	log.Printf("DEBUG: %s - Successfully restored value with type=%d", kd.Key, v.Type)
 	if v.Type == StringType {
@@ -190,7 +219,7 @@ func (s *Store) restoreFromDump(kd KeyDump) error {
 	// Extra debug logging for key2
 	if kd.Key == "key2" {
 		// Verify it was stored
-		if stored, ok := s.data[kd.Key]; ok {
+		if stored, ok := s.data.Get(kd.Key); ok {
 			log.Printf("DEBUG: key2 - Verified in store with type %d and value %q",
 				stored.Type, string(stored.Data))
 		} else {
@@ -212,6 +241,6 @@ func (s *Store) getExpirationTime(key string) time.Time {
 func (s *Store) getRaw(key string) (Value, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	v, ok := s.data[key]
+	v, ok := s.data.Get(key)
 	return v, ok
 }
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestZSetRankAndRange(t *testing.T) {
+	s := NewStore()
+	if added, _ := s.ZAdd("z", map[string]float64{"c": 3, "a": 1, "b": 2, "b2": 2}); added != 4 {
+		t.Fatalf("ZAdd added %d, want 4", added)
+	}
+	// a new score moves the member
+	if added, _ := s.ZAdd("z", map[string]float64{"a": 4}); added != 0 {
+		t.Fatalf("ZAdd of an existing member added %d", added)
+	}
+
+	if got, want := s.ZRange("z", 0, -1, false), []string{"b", "b2", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ZRange = %v, want %v", got, want)
+	}
+	if got, want := s.ZRange("z", -2, -1, true), []string{"c", "3.000000", "a", "4.000000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ZRange WITHSCORES = %v, want %v", got, want)
+	}
+	for member, want := range map[string]int{"b": 0, "b2": 1, "c": 2, "a": 3} {
+		if rank, ok := s.ZRank("z", member); !ok || rank != want {
+			t.Errorf("ZRank(%s) = %d, %v, want %d", member, rank, ok, want)
+		}
+	}
+}
+
+func TestZSetMissingMember(t *testing.T) {
+	s := NewStore()
+	s.ZAdd("z", map[string]float64{"a": 1})
+	if _, ok := s.ZRank("z", "missing"); ok {
+		t.Error("ZRank found a missing member")
+	}
+	if _, ok := s.ZScore("z", "missing"); ok {
+		t.Error("ZScore found a missing member")
+	}
+	if _, ok := s.ZRank("nokey", "a"); ok {
+		t.Error("ZRank found a member of a missing key")
+	}
+}
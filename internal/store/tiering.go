@@ -0,0 +1,459 @@
+package store
+
+import (
+	"container/list"
+	"errors"
+	"iter"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoTiering = errors.New("ERR storage engine does not support tiering")
+
+// TierStats describes where a tiered engine's values are and how often
+// lookups had to go to disk
+type TierStats struct {
+	HotKeys    int
+	ColdKeys   int
+	PinnedKeys int
+	ColdBytes  int64  // live bytes in the spill file
+	Hits       uint64 // lookups served from memory
+	Misses     uint64 // lookups that loaded a value from disk
+	Promotions uint64
+	Demotions  uint64
+}
+
+func (t *TierStats) add(o TierStats) {
+	t.HotKeys += o.HotKeys
+	t.ColdKeys += o.ColdKeys
+	t.PinnedKeys += o.PinnedKeys
+	t.ColdBytes += o.ColdBytes
+	t.Hits += o.Hits
+	t.Misses += o.Misses
+	t.Promotions += o.Promotions
+	t.Demotions += o.Demotions
+}
+
+const (
+	TierMemory = "memory"
+	TierDisk   = "disk"
+)
+
+// tieredEngine keeps recently used values in memory and moves values idle
+// beyond a threshold to a spill file, promoting them back when they are
+// read or written. Pinned keys always stay in memory. Like the disk engine,
+// the file is scratch space and does not survive a restart.
+type tieredEngine struct {
+	keys map[string]struct{} // every live key, see diskEngine.keys
+
+	mu     sync.Mutex // guards everything below; Get promotes
+	cold   *spillFile
+	hot    map[string]*tierEntry
+	lru    *list.List // of unpinned *tierEntry, most recently used first
+	pinned map[string]struct{}
+	stats  TierStats // counters only; key counts are filled in by Stats
+
+	idle atomic.Int64 // demotion threshold, 0 disables demotion
+}
+
+type tierEntry struct {
+	key   string
+	v     Value
+	at    time.Time     // last access
+	el    *list.Element // nil while pinned
+	dirty bool          // differs from the spill file, or was never written
+}
+
+func openTieredEngine(path string, idle time.Duration) (*tieredEngine, error) {
+	cold, err := openSpillFile(path)
+	if err != nil {
+		return nil, err
+	}
+	e := &tieredEngine{
+		keys:   make(map[string]struct{}),
+		cold:   cold,
+		hot:    make(map[string]*tierEntry),
+		lru:    list.New(),
+		pinned: make(map[string]struct{}),
+	}
+	e.idle.Store(int64(idle))
+	return e, nil
+}
+
+func (e *tieredEngine) Name() string { return TieredEngine }
+
+// touch marks ent as just used. Callers must hold e.mu.
+func (e *tieredEngine) touch(ent *tierEntry) {
+	ent.at = time.Now()
+	if ent.el != nil {
+		e.lru.MoveToFront(ent.el)
+	}
+}
+
+// promote loads key from disk into memory. Callers must hold e.mu.
+func (e *tieredEngine) promote(key string) (*tierEntry, bool) {
+	v, ok := e.cold.read(key)
+	if !ok {
+		return nil, false
+	}
+	ent := &tierEntry{key: key, v: v, at: time.Now()}
+	if _, pinned := e.pinned[key]; !pinned {
+		ent.el = e.lru.PushFront(ent)
+	}
+	e.hot[key] = ent
+	e.stats.Promotions++
+	return ent, true
+}
+
+func (e *tieredEngine) Get(key string) (Value, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ent, ok := e.hot[key]; ok {
+		e.stats.Hits++
+		e.touch(ent)
+		return ent.v, true
+	}
+	ent, ok := e.promote(key)
+	if !ok {
+		return Value{}, false
+	}
+	e.stats.Misses++
+	return ent.v, true
+}
+
+func (e *tieredEngine) Put(key string, v Value) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keys[key] = struct{}{}
+	ent, ok := e.hot[key]
+	if !ok {
+		ent = &tierEntry{key: key}
+		if _, pinned := e.pinned[key]; !pinned {
+			ent.el = e.lru.PushFront(ent)
+		}
+		e.hot[key] = ent
+	}
+	ent.v, ent.dirty = v, true
+	e.touch(ent)
+}
+
+func (e *tieredEngine) Delete(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.keys, key)
+	delete(e.pinned, key)
+	if ent, ok := e.hot[key]; ok {
+		if ent.el != nil {
+			e.lru.Remove(ent.el)
+		}
+		delete(e.hot, key)
+	}
+	e.cold.remove(key)
+}
+
+func (e *tieredEngine) Len() int { return len(e.keys) }
+
+// All reads cold values straight from disk, without promoting them or
+// counting them as accesses, so a SCAN or digest does not warm the whole
+// keyspace
+func (e *tieredEngine) All() iter.Seq2[string, Value] {
+	return func(yield func(string, Value) bool) {
+		for k := range e.keys {
+			v, ok := e.peek(k)
+			if !ok {
+				continue
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (e *tieredEngine) peek(key string) (Value, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ent, ok := e.hot[key]; ok {
+		return ent.v, true
+	}
+	return e.cold.read(key)
+}
+
+func (e *tieredEngine) Clear() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.keys = make(map[string]struct{})
+	e.hot = make(map[string]*tierEntry)
+	e.pinned = make(map[string]struct{})
+	e.lru.Init()
+	e.cold.clear()
+}
+
+func (e *tieredEngine) Compact() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cold.dead > 0 {
+		e.cold.compact()
+	}
+}
+
+func (e *tieredEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.cold.close()
+}
+
+func (e *tieredEngine) SetIdleThreshold(d time.Duration) {
+	e.idle.Store(int64(d))
+}
+
+// Demote moves values idle for longer than the threshold to disk and
+// returns how many it moved. Values that are unchanged since they were
+// promoted are dropped from memory without being rewritten.
+func (e *tieredEngine) Demote(now time.Time) int {
+	idle := time.Duration(e.idle.Load())
+	if idle <= 0 {
+		return 0
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n := 0
+	for el := e.lru.Back(); el != nil; el = e.lru.Back() {
+		ent := el.Value.(*tierEntry)
+		if now.Sub(ent.at) < idle {
+			break
+		}
+		if ent.dirty || !e.cold.has(ent.key) {
+			if err := e.cold.write(ent.key, ent.v); err != nil {
+				// keep the value in memory rather than lose it
+				log.Printf("ERROR: %s - Failed to demote value to %s: %v", ent.key, e.cold.path, err)
+				break
+			}
+		}
+		e.lru.Remove(el)
+		delete(e.hot, ent.key)
+		n++
+	}
+	e.stats.Demotions += uint64(n)
+	e.cold.maybeCompact()
+	return n
+}
+
+// Pin keeps key in memory until Unpin, promoting it if it is on disk
+func (e *tieredEngine) Pin(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.keys[key]; !ok {
+		return false
+	}
+	ent, ok := e.hot[key]
+	if !ok {
+		if ent, ok = e.promote(key); !ok {
+			return false
+		}
+	}
+	if ent.el != nil {
+		e.lru.Remove(ent.el)
+		ent.el = nil
+	}
+	e.pinned[key] = struct{}{}
+	return true
+}
+
+// Unpin lets key be demoted again once it goes idle
+func (e *tieredEngine) Unpin(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.pinned[key]; !ok {
+		return false
+	}
+	delete(e.pinned, key)
+	if ent, ok := e.hot[key]; ok {
+		ent.el = e.lru.PushFront(ent)
+		ent.at = time.Now()
+	}
+	return true
+}
+
+// Tier reports whether key is in memory or on disk, "" if it does not exist
+func (e *tieredEngine) Tier(key string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.hot[key]; ok {
+		return TierMemory
+	}
+	if e.cold.has(key) {
+		return TierDisk
+	}
+	return ""
+}
+
+func (e *tieredEngine) Stats() TierStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st := e.stats
+	st.HotKeys = len(e.hot)
+	st.ColdKeys = len(e.keys) - len(e.hot)
+	st.PinnedKeys = len(e.pinned)
+	st.ColdBytes = e.cold.size - e.cold.dead
+	return st
+}
+
+// tieredFor returns the tiered engine holding key, looking through
+// per-namespace routing
+func tieredFor(e Engine, key string) (*tieredEngine, bool) {
+	if ns, ok := e.(*namespaceEngine); ok {
+		e = ns.route(key)
+	}
+	t, ok := e.(*tieredEngine)
+	return t, ok
+}
+
+// tieredEngines returns every tiered engine behind e
+func tieredEngines(e Engine) []*tieredEngine {
+	if ns, ok := e.(*namespaceEngine); ok {
+		var out []*tieredEngine
+		ns.each(func(sub Engine) { out = append(out, tieredEngines(sub)...) })
+		return out
+	}
+	if t, ok := e.(*tieredEngine); ok {
+		return []*tieredEngine{t}
+	}
+	return nil
+}
+
+// SetTierIdle sets the demotion threshold of the store's tiered engines
+func (s *Store) SetTierIdle(d time.Duration) {
+	for _, t := range tieredEngines(s.data) {
+		t.SetIdleThreshold(d)
+	}
+}
+
+// demoteIdle moves idle values to disk; the shard worker calls it on its
+// eviction tick. It takes the write lock because encoding a value must not
+// race with writers changing it in place.
+func (s *Store) demoteIdle() {
+	tiers := tieredEngines(s.data)
+	if len(tiers) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, t := range tiers {
+		t.Demote(now)
+	}
+}
+
+// Pin keeps key in memory; it reports false if the key does not exist
+func (s *Store) Pin(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := tieredFor(s.data, key)
+	if !ok {
+		return false, ErrNoTiering
+	}
+	if s.expired(key) {
+		return false, nil
+	}
+	return t.Pin(key), nil
+}
+
+// Unpin reports false if key was not pinned
+func (s *Store) Unpin(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := tieredFor(s.data, key)
+	if !ok {
+		return false, ErrNoTiering
+	}
+	return t.Unpin(key), nil
+}
+
+// KeyTier reports TierMemory or TierDisk for key, "" if it does not exist
+func (s *Store) KeyTier(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := tieredFor(s.data, key)
+	if !ok {
+		return "", ErrNoTiering
+	}
+	if s.pastTTL(key) {
+		return "", nil
+	}
+	return t.Tier(key), nil
+}
+
+// TierStats sums the stats of the store's tiered engines; ok is false if
+// it has none
+func (s *Store) TierStats() (st TierStats, ok bool) {
+	for _, t := range tieredEngines(s.data) {
+		st.add(t.Stats())
+		ok = true
+	}
+	return st, ok
+}
+
+// SetTierIdle sets the demotion threshold on every shard
+func (ss *SharedStore) SetTierIdle(d time.Duration) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetTierIdle(d)
+	}
+}
+
+// shardStore returns the store that owns key
+func (ss *SharedStore) shardStore(key string) (*Store, bool) {
+	node, ok := ss.GetNodeForKey(key)
+	if !ok {
+		return nil, false
+	}
+	sh, ok := ss.getShardByNodeID(node)
+	if !ok {
+		return nil, false
+	}
+	return sh.Store, true
+}
+
+func (ss *SharedStore) Pin(key string) (bool, error) {
+	st, ok := ss.shardStore(key)
+	if !ok {
+		return false, nil
+	}
+	return st.Pin(key)
+}
+
+func (ss *SharedStore) Unpin(key string) (bool, error) {
+	st, ok := ss.shardStore(key)
+	if !ok {
+		return false, nil
+	}
+	return st.Unpin(key)
+}
+
+func (ss *SharedStore) KeyTier(key string) (string, error) {
+	st, ok := ss.shardStore(key)
+	if !ok {
+		return "", nil
+	}
+	return st.KeyTier(key)
+}
+
+// TierStats sums the tier stats of every shard; ok is false if no shard
+// uses a tiered engine
+func (ss *SharedStore) TierStats() (TierStats, bool) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	var total TierStats
+	found := false
+	for _, sh := range ss.nodeShards {
+		if st, ok := sh.Store.TierStats(); ok {
+			total.add(st)
+			found = true
+		}
+	}
+	return total, found
+}
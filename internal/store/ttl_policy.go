@@ -0,0 +1,86 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// TTL policies enforce expirations by key name, so cache keys cannot live
+// forever because one application forgot to set a TTL. The shard worker
+// applies them after every client write, before replying, so the writer
+// never sees the key without its policy's TTL. Of the policies whose prefix
+// a key starts with, the longest prefix alone applies; keys are matched by
+// their names within their database. An explicit TTL wins over the
+// default but not over the max: a TTL past the max is cut down to it, and
+// a key under a max is never left without a TTL. Keys arriving by
+// migration or from a backup keep the TTLs they had.
+
+// TTLPolicy gives keys starting with Prefix Default as their TTL when a
+// write leaves them without one, and cuts every TTL they get down to Max;
+// 0 = none
+type TTLPolicy struct {
+	Prefix  string
+	Default time.Duration
+	Max     time.Duration
+}
+
+// SetTTLPolicies replaces the store's TTL policies, nil or empty for none.
+// Keys already written keep their TTLs until their next write.
+func (s *Store) SetTTLPolicies(policies []TTLPolicy) {
+	if len(policies) == 0 {
+		s.ttlPolicies.Store(nil)
+		return
+	}
+	sorted := append([]TTLPolicy(nil), policies...)
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i].Prefix) > len(sorted[j].Prefix) })
+	s.ttlPolicies.Store(&sorted)
+}
+
+// SetTTLPolicies replaces the TTL policies of every shard
+func (ss *SharedStore) SetTTLPolicies(policies []TTLPolicy) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetTTLPolicies(policies)
+	}
+}
+
+// ttlPolicy returns the policy key falls under, if any
+func (s *Store) ttlPolicy(key string) (TTLPolicy, bool) {
+	policies := s.ttlPolicies.Load()
+	if policies == nil {
+		return TTLPolicy{}, false
+	}
+	_, name := KeyDB(key)
+	for _, p := range *policies { // longest prefix first
+		if strings.HasPrefix(name, p.Prefix) {
+			return p, true
+		}
+	}
+	return TTLPolicy{}, false
+}
+
+// applyTTLPolicy gives key the TTL its policy calls for after a write.
+// Callers hold s.mu.
+func (s *Store) applyTTLPolicy(key string) {
+	p, ok := s.ttlPolicy(key)
+	if !ok {
+		return
+	}
+	if _, exists := s.data.Get(key); !exists {
+		return
+	}
+	now := s.now()
+	at, has := s.ttl[key]
+	switch {
+	case !has && p.Default > 0:
+		at = now.Add(p.Default)
+	case p.Max > 0 && (!has || at.After(now.Add(p.Max))):
+		at = now.Add(p.Max)
+	default:
+		return
+	}
+	s.setTTL(key, at)
+	s.notify(EventGeneric, "expire", key)
+}
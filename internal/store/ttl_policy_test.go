@@ -0,0 +1,55 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLPolicies(t *testing.T) {
+	ss := newTestSharedStore(t)
+	sh, _ := ss.GetShardByNodeID("node-0")
+	ss.SetTTLPolicies([]TTLPolicy{
+		{Prefix: "cache:", Default: time.Hour},
+		{Prefix: "cache:short:", Max: time.Minute},
+	})
+
+	ss.Set("cache:a", []byte("v"), 0)
+	ss.Set("cache:b", []byte("v"), 30*time.Second)
+	ss.Set("cache:short:a", []byte("v"), 0)
+	ss.Set("cache:short:b", []byte("v"), time.Hour)
+	ss.Set("cache:short:c", []byte("v"), 30*time.Second)
+	ss.Set("other", []byte("v"), 0)
+
+	tests := []struct {
+		key      string
+		min, max int64
+	}{
+		{"cache:a", 3590, 3600},   // the default
+		{"cache:b", 20, 30},       // an explicit TTL wins over the default
+		{"cache:short:a", 50, 60}, // the longest prefix alone applies: no default, but never without a TTL
+		{"cache:short:b", 50, 60}, // cut down to the max
+		{"cache:short:c", 20, 30}, // under the max already
+		{"other", -1, -1},         // no policy
+	}
+	for _, tt := range tests {
+		if got := sh.Store.TTL(tt.key); got < tt.min || got > tt.max {
+			t.Errorf("TTL(%s) = %d, want %d..%d", tt.key, got, tt.min, tt.max)
+		}
+	}
+
+	// PERSIST is a write, so the policy puts the default back
+	ss.Execute("PERSIST", "cache:a")
+	if got := sh.Store.TTL("cache:a"); got < 3590 {
+		t.Errorf("TTL(cache:a) after PERSIST = %d, want the default", got)
+	}
+
+	// keys written before the policies went away keep their TTLs
+	ss.SetTTLPolicies(nil)
+	ss.Set("cache:c", []byte("v"), 0)
+	if got := sh.Store.TTL("cache:c"); got != -1 {
+		t.Errorf("TTL(cache:c) without policies = %d, want -1", got)
+	}
+	if got := sh.Store.TTL("cache:short:b"); got < 50 || got > 60 {
+		t.Errorf("TTL(cache:short:b) after dropping the policies = %d", got)
+	}
+}
@@ -0,0 +1,74 @@
+package store
+
+import "time"
+
+// TTLBuckets are the upper bounds of the TTLStats histogram buckets; a last
+// bucket counts the keys beyond them
+var TTLBuckets = [...]time.Duration{
+	10 * time.Second,
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+// TTLForecastMinutes is how far ahead TTLStats forecasts expirations
+const TTLForecastMinutes = 60
+
+// TTLStats summarizes the remaining TTLs of keys that have one
+type TTLStats struct {
+	Keys      int
+	Histogram [len(TTLBuckets) + 1]int // keys with a remaining TTL up to TTLBuckets[i]
+	Forecast  [TTLForecastMinutes]int  // keys expiring in each of the next minutes
+}
+
+func (st *TTLStats) add(o TTLStats) {
+	st.Keys += o.Keys
+	for i, n := range o.Histogram {
+		st.Histogram[i] += n
+	}
+	for i, n := range o.Forecast {
+		st.Forecast[i] += n
+	}
+}
+
+// TTLStats walks the keys with a TTL, so it costs a pass over them under
+// the read lock. Keys already past their TTL are left out.
+func (s *Store) TTLStats(now time.Time) TTLStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var st TTLStats
+	for _, exp := range s.ttl {
+		left := exp.Sub(now)
+		if left <= 0 {
+			continue
+		}
+		st.Keys++
+		b := len(TTLBuckets)
+		for i, bound := range TTLBuckets {
+			if left <= bound {
+				b = i
+				break
+			}
+		}
+		st.Histogram[b]++
+		if m := int(left / time.Minute); m < TTLForecastMinutes {
+			st.Forecast[m]++
+		}
+	}
+	return st
+}
+
+// TTLStats sums TTLStats over every shard, all measured from the same
+// instant
+func (ss *SharedStore) TTLStats() TTLStats {
+	now := ss.Now()
+	var st TTLStats
+	for _, sh := range ss.shardList() {
+		st.add(sh.Store.TTLStats(now))
+	}
+	return st
+}
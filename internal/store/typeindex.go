@@ -0,0 +1,183 @@
+package store
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+
+	"multithreaded-redis/internal/datastuctures"
+)
+
+var valueTypeNames = map[ValueType]string{
+	StringType: "string",
+	SetType:    "set",
+	HashType:   "hash",
+	CMSType:    "cms",
+	ListType:   "list",
+	ZSetType:   "zset",
+	BFType:     "bloom",
+}
+
+// valueTypes lists every type in declaration order
+var valueTypes = []ValueType{StringType, SetType, HashType, CMSType, ListType, ZSetType, BFType}
+
+func (t ValueType) String() string {
+	return valueTypeNames[t]
+}
+
+func ParseValueType(name string) (ValueType, error) {
+	for t, n := range valueTypeNames {
+		if strings.EqualFold(name, n) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown type name '%s'", name)
+}
+
+// typeIndex files keys by type and keeps each database's keys ordered by
+// scan hash, so SCAN resumes from a cursor without sorting the keyspace
+type typeIndex struct {
+	keys  map[ValueType]map[string]struct{}
+	order map[scanSlot]*datastuctures.SkipList // scored by scanHash
+	all   map[int]*datastuctures.SkipList      // each database's keys, scored by scanHash
+}
+
+// scanSlot names the keys of one type in one database
+type scanSlot struct {
+	db int
+	t  ValueType
+}
+
+// indexKey files key under its current type, or drops it from the index if
+// it no longer exists. Called from accountKey, so it runs after every write;
+// callers must hold s.mu.
+func (s *Store) indexKey(key string) {
+	ix := &s.types
+	if ix.keys == nil {
+		ix.keys = make(map[ValueType]map[string]struct{})
+		ix.order = make(map[scanSlot]*datastuctures.SkipList)
+		ix.all = make(map[int]*datastuctures.SkipList)
+	}
+	v, ok := s.data.Get(key)
+	db, _ := KeyDB(key)
+	h := float64(scanHash(key))
+	was := false
+	for _, t := range valueTypes {
+		if _, in := ix.keys[t][key]; !in {
+			continue
+		}
+		was = true
+		if ok && t == v.Type {
+			return
+		}
+		delete(ix.keys[t], key)
+		ix.order[scanSlot{db, t}].Delete(key, h)
+	}
+	if !ok {
+		if was {
+			ix.all[db].Delete(key, h)
+			s.countDBKey(key, -1)
+		}
+		return
+	}
+	if ix.keys[v.Type] == nil {
+		ix.keys[v.Type] = make(map[string]struct{})
+	}
+	ix.keys[v.Type][key] = struct{}{}
+	slot := scanSlot{db, v.Type}
+	if ix.order[slot] == nil {
+		ix.order[slot] = datastuctures.NewSkipList()
+	}
+	ix.order[slot].Insert(key, h)
+	if !was {
+		if ix.all[db] == nil {
+			ix.all[db] = datastuctures.NewSkipList()
+		}
+		ix.all[db].Insert(key, h)
+		s.countDBKey(key, 1)
+	}
+}
+
+// TypeStats counts the keys of each type without visiting the keyspace
+func (s *Store) TypeStats() map[ValueType]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[ValueType]int, len(s.types.keys))
+	for t, keys := range s.types.keys {
+		if len(keys) > 0 {
+			out[t] = len(keys)
+		}
+	}
+	return out
+}
+
+// ScanFilter narrows a SCAN. Only keys of Type are visited when HasType is set.
+type ScanFilter struct {
+	Match   string // glob, empty matches everything
+	Type    ValueType
+	HasType bool
+	DB      int // keys are returned without their database prefix
+}
+
+// scanHash orders keys for SCAN. Cursors are hash values, so a key present
+// for the whole iteration is returned no matter what is added or removed.
+func scanHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Scan returns up to count keys whose scan hash is at least cursor, plus the
+// cursor to continue from. done is true once the store has been covered.
+// Keys sharing a hash are never split across calls, so a reply may exceed
+// count slightly. A call costs O(log n + count).
+func (s *Store) Scan(cursor uint32, count int, f ScanFilter) (keys []string, next uint32, done bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx := s.types.all[f.DB]
+	if f.HasType {
+		idx = s.types.order[scanSlot{f.DB, f.Type}]
+	}
+	if idx == nil {
+		return nil, 0, true
+	}
+
+	var entries []datastuctures.SkipListEntry
+	if count <= 0 {
+		entries = idx.RangeByScore(float64(cursor), math.MaxUint32)
+		done = true
+	} else {
+		// one more than asked tells whether anything is left
+		entries = idx.FirstByScore(float64(cursor), count+1)
+		if len(entries) <= count {
+			done = true
+		} else {
+			last := entries[count-1]
+			entries = entries[:count]
+			for _, e := range idx.RangeByScore(last.Score, last.Score) {
+				if e.Member > last.Member {
+					entries = append(entries, e)
+				}
+			}
+			done = last.Score == math.MaxUint32
+			next = uint32(last.Score) + 1
+		}
+	}
+
+	for _, e := range entries {
+		if s.pastTTL(e.Member) {
+			continue
+		}
+		_, name := KeyDB(e.Member)
+		if f.Match != "" && !GlobMatch(f.Match, name) {
+			continue
+		}
+		keys = append(keys, name)
+	}
+	if done {
+		next = 0
+	}
+	return keys, next, done
+}
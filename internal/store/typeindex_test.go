@@ -0,0 +1,131 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// scanAll runs a full SCAN iteration and returns the keys it saw, sorted
+func scanAll(t *testing.T, s *Store, count int, f ScanFilter) []string {
+	t.Helper()
+	seen := make(map[string]bool)
+	var cursor uint32
+	for calls := 0; ; calls++ {
+		if calls > 10000 {
+			t.Fatal("SCAN did not finish")
+		}
+		keys, next, done := s.Scan(cursor, count, f)
+		for _, k := range keys {
+			seen[k] = true
+		}
+		if done {
+			break
+		}
+		if next <= cursor {
+			t.Fatalf("cursor went from %d to %d", cursor, next)
+		}
+		cursor = next
+	}
+	out := make([]string, 0, len(seen))
+	for k := range seen {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestScanCoversKeyspace(t *testing.T) {
+	s := NewStore()
+	var strs, sets []string
+	for i := 0; i < 200; i++ {
+		k := fmt.Sprintf("str:%03d", i)
+		s.Set(k, []byte("v"), 0)
+		strs = append(strs, k)
+	}
+	for i := 0; i < 50; i++ {
+		k := fmt.Sprintf("set:%03d", i)
+		s.SAdd(k, "m")
+		s.AccountKey(k) // as the shard worker does after every write
+		sets = append(sets, k)
+	}
+
+	all := append(append([]string{}, sets...), strs...)
+	for _, count := range []int{0, 1, 7, 1000} {
+		if got := scanAll(t, s, count, ScanFilter{}); fmt.Sprint(got) != fmt.Sprint(all) {
+			t.Errorf("COUNT %d: scanned %d keys, want %d", count, len(got), len(all))
+		}
+	}
+	if got := scanAll(t, s, 10, ScanFilter{Type: SetType, HasType: true}); fmt.Sprint(got) != fmt.Sprint(sets) {
+		t.Errorf("TYPE set scanned %v", got)
+	}
+	if got := scanAll(t, s, 10, ScanFilter{Type: HashType, HasType: true}); len(got) != 0 {
+		t.Errorf("TYPE hash scanned %v, want none", got)
+	}
+	if got := scanAll(t, s, 10, ScanFilter{Match: "set:00*"}); len(got) != 10 {
+		t.Errorf("MATCH set:00* scanned %d keys, want 10", len(got))
+	}
+}
+
+func TestScanFollowsWrites(t *testing.T) {
+	s := NewStore()
+	s.Set("k", []byte("v"), 0)
+	s.Delete("k")
+	s.AccountKey("k")
+	s.SAdd("k", "m")
+	s.AccountKey("k")
+	if got := scanAll(t, s, 10, ScanFilter{Type: StringType, HasType: true}); len(got) != 0 {
+		t.Errorf("a deleted string is still indexed: %v", got)
+	}
+	if got := scanAll(t, s, 10, ScanFilter{}); len(got) != 1 || got[0] != "k" {
+		t.Errorf("SCAN = %v, want [k]", got)
+	}
+	if stats := s.TypeStats(); stats[SetType] != 1 || stats[StringType] != 0 {
+		t.Errorf("TypeStats = %v", stats)
+	}
+}
+
+func TestScanCountBoundsWork(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 1000; i++ {
+		s.Set(fmt.Sprint(i), []byte("v"), 0)
+	}
+	keys, next, done := s.Scan(0, 10, ScanFilter{})
+	if done || len(keys) < 10 || len(keys) > 12 {
+		t.Fatalf("Scan(0, 10) = %d keys, done %v", len(keys), done)
+	}
+	// the keys returned are the 10 lowest hashes, and the cursor passes them
+	for _, k := range keys {
+		if scanHash(k) >= next {
+			t.Errorf("key %s (hash %d) is not below cursor %d", k, scanHash(k), next)
+		}
+	}
+}
+
+// TestScanDB checks that a database's SCAN only walks that database, so a
+// small COUNT still returns its keys while other databases are large
+func TestScanDB(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 500; i++ {
+		s.Set(fmt.Sprint(i), []byte("v"), 0)
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		s.Set(DBKey(3, k), []byte("v"), 0)
+	}
+	keys, _, done := s.Scan(0, 3, ScanFilter{DB: 3})
+	if !done || len(keys) != 3 {
+		t.Errorf("Scan(0, 3) of database 3 = %v, done %v", keys, done)
+	}
+	if got := scanAll(t, s, 2, ScanFilter{DB: 3, Match: "[ab]"}); fmt.Sprint(got) != "[a b]" {
+		t.Errorf("MATCH [ab] in database 3 scanned %v", got)
+	}
+	if n := len(scanAll(t, s, 50, ScanFilter{})); n != 500 {
+		t.Errorf("database 0 scanned %d keys, want 500", n)
+	}
+	if got := s.DBKeys(3); got != 3 {
+		t.Errorf("DBKeys(3) = %d, want 3", got)
+	}
+	if n := s.FlushDB(3); n != 3 || s.DBKeys(0) != 500 {
+		t.Errorf("FlushDB(3) = %d, left %d keys in database 0", n, s.DBKeys(0))
+	}
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WarmupEntry is one line of a warmup manifest
+type WarmupEntry struct {
+	Key string
+	TTL time.Duration // zero => no expiration
+}
+
+// WarmupLoader fetches the value of key from the backing source. ok is false
+// when the source has no value for the key.
+type WarmupLoader func(ctx context.Context, key string) (val []byte, ok bool, err error)
+
+// WarmupStatus is a snapshot of warmup progress
+type WarmupStatus struct {
+	State    string // idle, running, done or aborted
+	Total    int
+	Loaded   int
+	Missing  int // not found at the loader, or already set by a client
+	Failed   int
+	Started  time.Time
+	Finished time.Time
+}
+
+type warmupProgress struct {
+	mu     sync.Mutex
+	status WarmupStatus
+}
+
+// ReadWarmupManifest parses a manifest file. Each non-empty line holds a key
+// optionally followed by a TTL in seconds; lines starting with # are comments.
+func ReadWarmupManifest(path string) ([]WarmupEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []WarmupEntry
+	sc := bufio.NewScanner(f)
+	lineNo := 0
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		e := WarmupEntry{Key: fields[0]}
+		if len(fields) > 1 {
+			secs, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil || secs <= 0 {
+				return nil, fmt.Errorf("%s:%d: invalid ttl %q", path, lineNo, fields[1])
+			}
+			e.TTL = time.Duration(secs) * time.Second
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// NewHTTPWarmupLoader returns a loader that issues GET <baseURL>/<key> and
+// stores the response body. A 404 means the key does not exist.
+func NewHTTPWarmupLoader(baseURL string) WarmupLoader {
+	client := &http.Client{Timeout: 10 * time.Second}
+	base := strings.TrimRight(baseURL, "/")
+	return func(ctx context.Context, key string) ([]byte, bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/"+url.PathEscape(key), nil)
+		if err != nil {
+			return nil, false, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, false, err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				return nil, false, err
+			}
+			return body, true, nil
+		case http.StatusNotFound:
+			return nil, false, nil
+		default:
+			return nil, false, fmt.Errorf("loader returned %s", resp.Status)
+		}
+	}
+}
+
+// Warmup pre-populates the store from entries using loader, with up to
+// workers concurrent fetches. Values are written with NX so anything a client
+// set while warmup was running wins over the loaded copy.
+func (ss *SharedStore) Warmup(ctx context.Context, entries []WarmupEntry, loader WarmupLoader, workers int) {
+	if workers <= 0 {
+		workers = 1
+	}
+	ss.warmup.mu.Lock()
+	ss.warmup.status = WarmupStatus{State: "running", Total: len(entries), Started: time.Now()}
+	ss.warmup.mu.Unlock()
+	log.Printf("Warmup: loading %d keys with %d workers", len(entries), workers)
+
+	jobs := make(chan WarmupEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				ss.warmupOne(ctx, e, loader)
+			}
+		}()
+	}
+
+feed:
+	for _, e := range entries {
+		select {
+		case jobs <- e:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	ss.warmup.mu.Lock()
+	st := &ss.warmup.status
+	st.Finished = time.Now()
+	st.State = "done"
+	if ctx.Err() != nil {
+		st.State = "aborted"
+	}
+	log.Printf("Warmup: %s in %v (loaded=%d missing=%d failed=%d)",
+		st.State, st.Finished.Sub(st.Started), st.Loaded, st.Missing, st.Failed)
+	ss.warmup.mu.Unlock()
+}
+
+func (ss *SharedStore) warmupOne(ctx context.Context, e WarmupEntry, loader WarmupLoader) {
+	val, ok, err := loader(ctx, e.Key)
+	if err == nil && ok {
+		args := []string{string(val), "NX"}
+		if e.TTL > 0 {
+			args = append(args, "PX", strconv.FormatInt(e.TTL.Milliseconds(), 10))
+		}
+		resp := ss.Execute("SET", e.Key, args...)
+		if rerr, isErr := resp.(error); isErr {
+			err = rerr
+		}
+		ok = resp == "OK"
+	}
+
+	ss.warmup.mu.Lock()
+	defer ss.warmup.mu.Unlock()
+	switch {
+	case err != nil:
+		log.Printf("ERROR: %s - Warmup load failed: %v", e.Key, err)
+		ss.warmup.status.Failed++
+	case ok:
+		ss.warmup.status.Loaded++
+	default:
+		ss.warmup.status.Missing++
+	}
+}
+
+// WarmupStatus reports the progress of the last warmup run
+func (ss *SharedStore) WarmupStatus() WarmupStatus {
+	ss.warmup.mu.Lock()
+	defer ss.warmup.mu.Unlock()
+	st := ss.warmup.status
+	if st.State == "" {
+		st.State = "idle"
+	}
+	return st
+}
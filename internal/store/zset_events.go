@@ -0,0 +1,97 @@
+package store
+
+import "sync/atomic"
+
+// ScoreChange is one sorted set member whose score a write changed. Ranks
+// are 0-based in ascending score order, as ZRANK counts them; OldRank is -1
+// and OldScore meaningless for a member the write added.
+type ScoreChange struct {
+	Member   string
+	Existed  bool
+	OldScore float64
+	NewScore float64
+	OldRank  int
+	NewRank  int
+}
+
+// ScoreHook receives the members a ZADD or ZINCRBY changed on a key that
+// matches the score event patterns. Like KeyEventHook it runs with the
+// store locked and must not call back into it.
+type ScoreHook func(key string, changes []ScoreChange)
+
+// scoreEvents is which keys report score changes, and to whom
+type scoreEvents struct {
+	patterns []string
+	fn       ScoreHook
+}
+
+type scoreWatch struct {
+	cfg atomic.Pointer[scoreEvents]
+}
+
+// SetScoreEvents reports score changes of sorted sets whose key matches one
+// of patterns to fn; no patterns turns reporting off
+func (s *Store) SetScoreEvents(patterns []string, fn ScoreHook) {
+	if len(patterns) == 0 || fn == nil {
+		s.scores.cfg.Store(nil)
+		return
+	}
+	s.scores.cfg.Store(&scoreEvents{patterns: patterns, fn: fn})
+}
+
+// SetScoreEvents installs score reporting on every shard, including shards
+// added later
+func (ss *SharedStore) SetScoreEvents(patterns []string, fn ScoreHook) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.scoreEvents = scoreEvents{patterns: patterns, fn: fn}
+	for _, sh := range ss.nodeShards {
+		sh.Store.SetScoreEvents(patterns, fn)
+	}
+}
+
+// scoreWatcher returns where score changes of key go, or nil when key is
+// not watched. The key's database prefix is not part of the match.
+func (s *Store) scoreWatcher(key string) *scoreEvents {
+	ev := s.scores.cfg.Load()
+	if ev == nil {
+		return nil
+	}
+	_, name := KeyDB(key)
+	for _, p := range ev.patterns {
+		if GlobMatch(p, name) {
+			return ev
+		}
+	}
+	return nil
+}
+
+// scoreChanges records the old score and rank of each member whose score
+// is about to change. Callers hold s.mu.
+func scoreChanges(val Value, members map[string]float64) []ScoreChange {
+	var changes []ScoreChange
+	for member, score := range members {
+		old, existed := val.ZSet[member]
+		if existed && old == score {
+			continue
+		}
+		ch := ScoreChange{Member: member, NewScore: score, OldRank: -1}
+		if existed {
+			ch.Existed, ch.OldScore, ch.OldRank = true, old, val.ZSL.Rank(member, old)
+		}
+		changes = append(changes, ch)
+	}
+	return changes
+}
+
+// report fills in the new ranks once the write is applied and hands the
+// changes to the hook
+func (ev *scoreEvents) report(key string, val Value, changes []ScoreChange) {
+	if len(changes) == 0 {
+		return
+	}
+	for i := range changes {
+		changes[i].NewRank = val.ZSL.Rank(changes[i].Member, changes[i].NewScore)
+	}
+	ev.fn(key, changes)
+}
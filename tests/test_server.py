@@ -75,6 +75,10 @@ def run_tests():
     test("GET expired", "GET", "tempkey")
     time.sleep(6)  # Wait for key to expire
     test("GET after expire", "GET", "tempkey")
+    test("SET NX existing", "SET", "mykey", "other", "NX")
+    test("SET XX GET", "SET", "mykey", "newvalue", "XX", "GET")
+    test("SET PX KEEPTTL", "SET", "mykey", "v", "PX", "100", "KEEPTTL")
+    test("SET EX overflow", "SET", "mykey", "v", "EX", "9223372037")
 
     # Set operations
     test("SADD", "SADD", "myset", "value1", "value2", "value3")
@@ -88,6 +92,9 @@ def run_tests():
     test("SUNION", "SUNION", "myset", "set2")
     test("SINTER", "SINTER", "myset", "set2")
     test("SDIFF", "SDIFF", "myset", "set2")
+    test("SUNIONSTORE", "SUNIONSTORE", "set3", "myset", "set2")
+    test("SINTERSTORE", "SINTERSTORE", "set3", "myset", "set2")
+    test("SDIFFSTORE", "SDIFFSTORE", "set3", "myset", "set2")
 
     # Hash operations
     test("HSET", "HSET", "myhash", "field1", "value1")
@@ -104,6 +111,14 @@ def run_tests():
     test("LPOP", "LPOP", "mylist")
     test("RPUSH", "RPUSH", "mylist", "value4")
     test("RPOP", "RPOP", "mylist")
+    test("LMOVE", "LMOVE", "mylist", "mylist2", "LEFT", "RIGHT")
+    test("BLMOVE timeout", "BLMOVE", "nolist", "mylist2", "LEFT", "RIGHT", "0.1")
+    test("LINSERT", "LINSERT", "mylist", "BEFORE", "value2", "value5")
+    test("LSET", "LSET", "mylist", "0", "value6")
+    test("LPOS", "LPOS", "mylist", "value5")
+    test("LREM", "LREM", "mylist", "0", "value5")
+    test("LTRIM", "LTRIM", "mylist", "0", "0")
+    test("LMPOP", "LMPOP", "2", "mylist", "mylist2", "LEFT", "COUNT", "2")
 
     # Sorted Set operations
     test("ZADD", "ZADD", "myzset", "1", "one", "2", "two", "3", "three")
@@ -112,18 +127,104 @@ def run_tests():
     test("ZRANK", "ZRANK", "myzset", "two")
     test("ZRANGE", "ZRANGE", "myzset", "0", "-1")
     test("ZRANGE with scores", "ZRANGE", "myzset", "0", "-1", "WITHSCORES")
+    test("ZRANK missing", "ZRANK", "myzset", "nonexistent")
+    test("ZSCORE missing", "ZSCORE", "myzset", "nonexistent")
+
+    # String operations
+    test("APPEND", "APPEND", "mystr", "Hello")
+    test("STRLEN", "STRLEN", "mystr")
+    test("GETRANGE", "GETRANGE", "mystr", "0", "2")
+    test("SETRANGE", "SETRANGE", "mystr", "0", "J")
+    test("GETSET", "GETSET", "mystr", "World")
+    test("SETNX existing", "SETNX", "mystr", "x")
+    test("MSET", "MSET", "mk1", "a", "mk2", "b")
+    test("MGET", "MGET", "mk1", "missing", "mk2")
+
+    # Hash field operations
+    test("HSET multiple", "HSET", "myhash2", "f1", "1", "f2", "2")
+    test("HMGET", "HMGET", "myhash2", "f1", "f2", "missing")
+    test("HEXISTS", "HEXISTS", "myhash2", "f1")
+    test("HLEN", "HLEN", "myhash2")
+    test("HKEYS", "HKEYS", "myhash2")
+    test("HVALS", "HVALS", "myhash2")
+    test("HINCRBY", "HINCRBY", "myhash2", "f1", "10")
+    test("HINCRBYFLOAT", "HINCRBYFLOAT", "myhash2", "f2", "0.5")
 
     # Bloom Filter operations
     test("BF.ADD", "BF.ADD", "myfilter", "item1")
     test("BF.EXISTS true", "BF.EXISTS", "myfilter", "item1")
     test("BF.EXISTS false", "BF.EXISTS", "myfilter", "nonexistent")
+    test("BF.RESERVE", "BF.RESERVE", "myfilter2", "0.01", "1000")
+    test("BF.MADD", "BF.MADD", "myfilter2", "a", "b")
+    test("BF.MEXISTS", "BF.MEXISTS", "myfilter2", "a", "c")
+    test("BF.INFO", "BF.INFO", "myfilter2")
 
     # Count-Min Sketch operations
     test("CMSINCR", "CMSINCR", "mycms", "item1", "5")
     test("CMSQUERY", "CMSQUERY", "mycms", "item1")
 
+    # Bitmap operations
+    test("SETBIT", "SETBIT", "mybits", "7", "1")
+    test("GETBIT", "GETBIT", "mybits", "7")
+    test("BITCOUNT", "BITCOUNT", "mybits")
+    test("BITPOS", "BITPOS", "mybits", "1")
+    test("BITOP NOT", "BITOP", "NOT", "bitdest", "mybits")
+
+    # Geo operations
+    test("GEOADD", "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+    test("GEOPOS", "GEOPOS", "Sicily", "Palermo", "missing")
+    test("GEODIST", "GEODIST", "Sicily", "Palermo", "Catania", "km")
+    test("GEOSEARCH", "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHDIST")
+
+    # Server time
+    test("TIME", "TIME")
+
+    # Command introspection
+    test("COMMAND COUNT", "COMMAND", "COUNT")
+    test("COMMAND INFO", "COMMAND", "INFO", "get", "zrange")
+
+    # Server introspection
+    test("INFO server", "INFO", "server")
+    test("CAPABILITIES", "CAPABILITIES")
+    test("CONFIG GET", "CONFIG", "GET", "maxmemory*")
+    test("CONFIG SET", "CONFIG", "SET", "maxmemory-policy", "allkeys-lru")
+
+    # Keyspace iteration
+    test("SCAN TYPE", "SCAN", "0", "COUNT", "100", "TYPE", "zset")
+    test("TYPESTATS", "TYPESTATS")
+    test("KEYS", "KEYS", "my[sz]*")
+    test("DEBUG STRINGMATCH-LEN", "DEBUG", "STRINGMATCH-LEN", "*a*b", "aaab")
+
+    test("DBSIZE", "DBSIZE")
+    test("DUMP missing key", "DUMP", "nosuchkey")
+    test("OBJECT ENCODING", "OBJECT", "ENCODING", "myset")
+    test("OBJECT FREQ", "OBJECT", "FREQ", "myset")
+    test("TTLSTATS", "TTLSTATS")
+    test("EVENTS", "EVENTS", "COUNT", "5")
+    test("KEYGROUP ADD", "KEYGROUP", "ADD", "grp", "mk1", "mk2")
+    test("KEYGROUP GET", "KEYGROUP", "GET", "mk1")
+    test("KEYGROUP REMOVE", "KEYGROUP", "REMOVE", "mk1", "mk2")
+
+    # ACL
+    test("ACL WHOAMI", "ACL", "WHOAMI")
+    test("ACL SETUSER", "ACL", "SETUSER", "tester", "on", ">pw", "~test:*", "+@read")
+    test("ACL SETUSER quotas", "ACL", "SETUSER", "tester", "ratelimit:100", "dailyquota:10000")
+    test("ACL GETUSER", "ACL", "GETUSER", "tester")
+    test("ACL DELUSER", "ACL", "DELUSER", "tester")
+
+    # Replication role
+    test("ROLE", "ROLE")
+    test("SENTINEL MYID", "SENTINEL", "MYID")
+
+    # Logical databases
+    test("SELECT 1", "SELECT", "1")
+    test("SET in db 1", "SET", "dbkey", "v")
+    test("SCAN db 1", "SCAN", "0", "COUNT", "10")
+    test("FLUSHDB", "FLUSHDB")
+    test("SELECT 0", "SELECT", "0")
+
     # Cleanup
-    test("DEL", "DEL", "mykey", "myset", "set2", "myhash", "mylist", "myzset", "myfilter", "mycms")
+    test("DEL", "DEL", "mykey", "myset", "set2", "set3", "myhash", "myhash2", "mylist", "mylist2", "myzset", "myfilter", "myfilter2", "mycms", "mystr", "mk1", "mk2", "mybits", "bitdest", "Sicily")
     
     client.close()
 